@@ -6,6 +6,7 @@
 package absnfs
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -27,11 +28,56 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 	if squash != "" && squash != "root" && squash != "all" && squash != "none" {
 		return nil, fmt.Errorf("invalid squash mode %q: must be root, all, or none", options.Squash)
 	}
+	if err := validateUnsupportedSetattr(options.UnsupportedSetattr); err != nil {
+		return nil, err
+	}
+	if err := validateClientRules(options.ClientRules); err != nil {
+		return nil, err
+	}
+	if err := validateCacheConsistency(options.CacheConsistency); err != nil {
+		return nil, err
+	}
+
+	// A pinned export serves a read-only historical view of fs
+	if options.PinnedTime != nil {
+		view, err := pinnedView(fs, *options.PinnedTime)
+		if err != nil {
+			return nil, err
+		}
+		fs = view
+		options.ReadOnly = true
+	}
+
+	// An ExportRoot export serves only the subtree below that directory
+	backend := fs
+	var subtree *subtreeView
+	if options.ExportRoot != "" {
+		view, err := newSubtreeView(fs, options.ExportRoot)
+		if err != nil {
+			return nil, err
+		}
+		subtree = view
+		fs = view
+	}
 
 	// Set default values if not specified
+	if options.RequireReservedPort == nil {
+		requireReservedPort := true
+		options.RequireReservedPort = &requireReservedPort
+	}
 	if options.TransferSize <= 0 {
 		options.TransferSize = 65536 // Default: 64KB
 	}
+	if options.MaxReadSize <= 0 {
+		options.MaxReadSize = 1048576
+	}
+	if options.MaxWriteSize <= 0 {
+		options.MaxWriteSize = 1048576
+	}
+
+	if options.PreferredDirReadSize <= 0 {
+		options.PreferredDirReadSize = 8192
+	}
 
 	// Set attribute cache defaults
 	if options.AttrCacheTimeout <= 0 {
@@ -46,6 +92,9 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 	if options.NegativeCacheTimeout <= 0 {
 		options.NegativeCacheTimeout = 5 * time.Second
 	}
+	if options.NegativeCacheMaxPerDir <= 0 {
+		options.NegativeCacheMaxPerDir = 256
+	}
 
 	// Set directory cache defaults
 	if options.DirCacheTimeout <= 0 {
@@ -60,6 +109,29 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 		options.DirCacheMaxDirSize = 10000
 	}
 
+	if options.CookieCacheSize <= 0 {
+		options.CookieCacheSize = 1000
+	}
+
+	if options.MaxSymlinkDepth <= 0 {
+		options.MaxSymlinkDepth = defaultMaxSymlinkDepth
+	}
+
+	options.AnonUID = anonOrDefault(options.AnonUID)
+	options.AnonGID = anonOrDefault(options.AnonGID)
+
+	if options.CacheHealthThreshold <= 0 {
+		options.CacheHealthThreshold = 0.5
+	}
+
+	if options.MetricsSampleRate <= 0 || options.MetricsSampleRate > 1 {
+		options.MetricsSampleRate = 1
+	}
+
+	if options.OutageThreshold <= 0 {
+		options.OutageThreshold = 3
+	}
+
 	// Set worker pool defaults
 	if options.MaxWorkers <= 0 {
 		options.MaxWorkers = runtime.NumCPU() * 4 // Default: number of logical CPUs * 4
@@ -166,11 +238,27 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 		logger:           log.New(os.Stderr, "[absnfs] ", log.LstdFlags),
 		structuredLogger: structuredLogger,
 		attrCache:        NewAttrCache(options.AttrCacheTimeout, options.AttrCacheSize),
+		cookieCache:      NewCookieCache(options.CookieCacheSize),
+	}
+	server.fileMap.SetIdleTimeout(options.HandleIdleTimeout)
+	server.linker, _ = backend.(Linker)
+	server.mknoder, _ = backend.(Mknoder)
+	server.advisor, _ = backend.(Advisor)
+	server.checksummer, _ = backend.(ChecksumVerifier)
+	server.setattrSupporter, _ = backend.(SetattrSupporter)
+	if subtree != nil {
+		subtree.scopeCapabilities(server)
 	}
 
 	// Populate atomic option pointers from the fully-defaulted ExportOptions
 	server.initAtomicOptions(&options)
 
+	// Derive handles from file identity so they survive a restart
+	if options.PersistentHandles {
+		server.handleIndex = newHandleIndex(maxIndexedPaths)
+		server.fileMap.resolver = server
+	}
+
 	// Initialize directory cache if enabled
 	if options.EnableDirCache {
 		server.dirCache = NewDirCache(options.DirCacheTimeout, options.DirCacheMaxEntries, options.DirCacheMaxDirSize)
@@ -178,11 +266,19 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 
 	// Configure negative caching
 	server.attrCache.ConfigureNegativeCaching(options.CacheNegativeLookups, options.NegativeCacheTimeout)
+	server.attrCache.SetNegativeDirLimit(options.NegativeCacheMaxPerDir)
 
 	// Initialize and start worker pool
 	server.workerPool = NewWorkerPool(options.MaxWorkers, server)
+	server.workerPool.SetMetadataReserve(options.MetadataWorkerReserve)
+	if options.AutoScaleWorkers {
+		server.workerPool.SetAutoScale(autoScaleMinWorkers(options.MaxWorkers), options.MaxWorkers)
+	}
 	server.workerPool.Start()
 
+	server.syncQueue = newSyncQueue(server)
+	server.warmer = newMountWarmer(server)
+
 	// Initialize metrics collection
 	server.initMetrics()
 
@@ -206,9 +302,9 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 		return nil, err
 	}
 
-	modTime := info.ModTime()
+	modTime := server.fileModTime("/", info)
 	root.attrs = &NFSAttrs{
-		Mode: info.Mode(),
+		Mode: server.fileMode("/", info),
 		Size: info.Size(),
 		Uid:  0, // Root ownership by default
 		Gid:  0,
@@ -220,6 +316,13 @@ func New(fs absfs.SymlinkFileSystem, options ExportOptions) (*AbsfsNFS, error) {
 	root.mu.Unlock()
 
 	server.root = root
+
+	if options.OutageProbeInterval > 0 {
+		server.startOutageProbe()
+	}
+	if server.handleIndex != nil {
+		server.startWalk()
+	}
 	return server, nil
 }
 
@@ -241,6 +344,36 @@ func (n *AbsfsNFS) ExecuteWithWorker(task func() interface{}) interface{} {
 	return task()
 }
 
+// ExecuteWithWorkerContext runs a task in the worker pool on behalf of a
+// request with the given context. If ctx is done before the task starts,
+// the task is skipped and ctx.Err() is returned. If the pool is unavailable
+// the task runs directly.
+func (n *AbsfsNFS) ExecuteWithWorkerContext(ctx context.Context, task func() interface{}) (interface{}, error) {
+	return n.executeWithWorker(ctx, false, task)
+}
+
+// executeWithWorker is ExecuteWithWorkerContext with a choice of queue:
+// metadata tasks go to the workers reserved by MetadataWorkerReserve
+func (n *AbsfsNFS) executeWithWorker(ctx context.Context, metadata bool, task func() interface{}) (interface{}, error) {
+	if n.workerPool != nil {
+		submit := n.workerPool.SubmitWithContext
+		if metadata {
+			submit = n.workerPool.SubmitMetadata
+		}
+		if resultChan := submit(ctx, task); resultChan != nil {
+			result := <-resultChan
+			if err := ctx.Err(); err != nil && result == err {
+				return nil, err
+			}
+			return result, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return task(), nil
+}
+
 // GetAttrCacheSize returns the current attribute cache size in a thread-safe manner
 func (n *AbsfsNFS) GetAttrCacheSize() int {
 	n.mu.RLock()
@@ -264,6 +397,24 @@ func (n *AbsfsNFS) Close() error {
 		n.workerPool.Stop()
 	}
 
+	// Stop the backing filesystem outage probe
+	n.stopOutageProbe()
+
+	// Abandon cache warming queued by mounts
+	if n.warmer != nil {
+		n.warmer.close()
+	}
+
+	// Abandon indexing the export for persistent handles
+	if n.handleIndex != nil {
+		n.stopWalk()
+	}
+
+	// Run syncs still queued for UNSTABLE writes
+	if n.syncQueue != nil {
+		n.syncQueue.close()
+	}
+
 	// Release all file handles to prevent file descriptor leaks
 	if n.fileMap != nil {
 		n.fileMap.ReleaseAll()
@@ -278,6 +429,8 @@ func (n *AbsfsNFS) Close() error {
 		n.dirCache.Clear()
 	}
 
+	n.locks.clear()
+
 	// Close structured logger if it's a SlogLogger
 	n.loggerMu.Lock()
 	slogger, isSlog := n.structuredLogger.(*SlogLogger)
@@ -335,40 +488,64 @@ func (n *AbsfsNFS) UpdateExportOptions(newOptions ExportOptions) error {
 		return fmt.Errorf("nil server")
 	}
 
-	// Apply tuning changes (lock-free, immediate).
-	// Use tuningFromExportOptions for complete field coverage.
-	// Preserve Timeouts and Log from the current snapshot when not provided,
-	// since nil pointer fields would cause panics on NFS operations.
-	n.UpdateTuningOptions(func(t *TuningOptions) {
-		newTuning := tuningFromExportOptions(&newOptions)
-		if newTuning.Timeouts == nil {
-			newTuning.Timeouts = t.Timeouts
-		}
-		if newTuning.Log == nil {
-			newTuning.Log = t.Log
-		}
-		*t = *newTuning
-	})
-
-	// Validate immutable fields before attempting policy update.
-	// Squash cannot be changed at runtime.
+	// Validate immutable fields before changing anything, so a rejected
+	// update leaves tuning untouched too. Squash cannot be changed at runtime.
 	currentPolicy := n.policy.Load()
 	if newOptions.Squash != "" && newOptions.Squash != currentPolicy.Squash {
 		return fmt.Errorf("cannot change Squash mode at runtime (requires restart)")
 	}
+	if newOptions.PinnedTime != nil && !samePinnedTime(newOptions.PinnedTime, currentPolicy.PinnedTime) {
+		return fmt.Errorf("cannot change PinnedTime at runtime (requires restart)")
+	}
+	if newOptions.ExportRoot != "" && newOptions.ExportRoot != currentPolicy.ExportRoot {
+		return fmt.Errorf("cannot change ExportRoot at runtime (requires restart)")
+	}
+	if newOptions.PersistentHandles && !currentPolicy.PersistentHandles {
+		return fmt.Errorf("cannot change PersistentHandles at runtime (requires restart)")
+	}
+	if err := validateCacheConsistency(newOptions.CacheConsistency); err != nil {
+		return err
+	}
 
 	// Apply policy changes (drain-and-swap)
 	newPolicy := PolicyOptions{
-		ReadOnly:           newOptions.ReadOnly,
-		Secure:             newOptions.Secure,
-		Squash:             currentPolicy.Squash, // immutable
-		MaxFileSize:        newOptions.MaxFileSize,
-		EnableRateLimiting: newOptions.EnableRateLimiting,
+		ReadOnly:               newOptions.ReadOnly,
+		Secure:                 newOptions.Secure,
+		Squash:                 currentPolicy.Squash, // immutable
+		EnablePermissionChecks: newOptions.EnablePermissionChecks,
+		MaxFileSize:            newOptions.MaxFileSize,
+		MaxWriteGap:            newOptions.MaxWriteGap,
+		UnsupportedSetattr:     newOptions.UnsupportedSetattr,
+		EnableRateLimiting:     newOptions.EnableRateLimiting,
+		CertToIDFunc:           newOptions.CertToIDFunc,
+		GSSAcceptor:            newOptions.GSSAcceptor,
+		PrincipalMapper:        newOptions.PrincipalMapper,
+		PinnedTime:             currentPolicy.PinnedTime, // immutable
+		ExportRoot:             currentPolicy.ExportRoot, // immutable
+		ConfineSymlinks:        newOptions.ConfineSymlinks,
+		MaxSymlinkDepth:        newOptions.MaxSymlinkDepth,
+		MaxSymlinkResolutions:  newOptions.MaxSymlinkResolutions,
+		UIDMap:                 newOptions.UIDMap,
+		GIDMap:                 newOptions.GIDMap,
+		AnonUID:                anonOrDefault(newOptions.AnonUID),
+		AnonGID:                anonOrDefault(newOptions.AnonGID),
+		ReplayWindow:           newOptions.ReplayWindow,
+		DryRun:                 newOptions.DryRun,
+		PersistentHandles:      currentPolicy.PersistentHandles, // immutable
+		HandleEncoder:          currentPolicy.HandleEncoder,     // immutable
+		RequireReservedPort:    currentPolicy.RequireReservedPort,
+	}
+	if newOptions.RequireReservedPort != nil {
+		newPolicy.RequireReservedPort = *newOptions.RequireReservedPort
 	}
 	if len(newOptions.AllowedIPs) > 0 {
 		newPolicy.AllowedIPs = make([]string, len(newOptions.AllowedIPs))
 		copy(newPolicy.AllowedIPs, newOptions.AllowedIPs)
 	}
+	if len(newOptions.ClientRules) > 0 {
+		newPolicy.ClientRules = make([]ClientRule, len(newOptions.ClientRules))
+		copy(newPolicy.ClientRules, newOptions.ClientRules)
+	}
 	if newOptions.RateLimitConfig != nil {
 		rc := *newOptions.RateLimitConfig
 		newPolicy.RateLimitConfig = &rc
@@ -376,6 +553,27 @@ func (n *AbsfsNFS) UpdateExportOptions(newOptions ExportOptions) error {
 	if newOptions.TLS != nil {
 		newPolicy.TLS = newOptions.TLS.Clone()
 	}
+	if err := validatePolicyChange(currentPolicy, &newPolicy); err != nil {
+		return err
+	}
+
+	// Apply tuning changes (lock-free, immediate).
+	// Use tuningFromExportOptions for complete field coverage.
+	// Preserve Timeouts and Log from the current snapshot when not provided,
+	// since nil pointer fields would cause panics on NFS operations.
+	err := n.UpdateTuningOptions(func(t *TuningOptions) {
+		newTuning := tuningFromExportOptions(&newOptions)
+		if newTuning.Timeouts == nil {
+			newTuning.Timeouts = t.Timeouts
+		}
+		if newTuning.Log == nil {
+			newTuning.Log = t.Log
+		}
+		*t = *newTuning
+	})
+	if err != nil {
+		return err
+	}
 
 	return n.UpdatePolicyOptions(newPolicy)
 }
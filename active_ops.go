@@ -0,0 +1,128 @@
+// active_ops.go: Listing and cancellation of in-flight NFS calls.
+//
+// HandleCall registers every NFS call with the export's activeOps table
+// for as long as it waits on the call, together with the cancel function
+// of the call's context. ActiveOperations lists them for operators, and
+// CancelOperation cancels one: HandleCall returns at once with an error
+// wrapping context.Canceled, just as it does when the call times out.
+package absnfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OpInfo describes an NFS call in progress
+type OpInfo struct {
+	ID      uint64        // Identifies the call to CancelOperation
+	Op      string        // Procedure name, e.g. "READ"
+	Path    string        // Path of the file handle the call names, if it resolves
+	Client  string        // Client IP address
+	Elapsed time.Duration // Time since the call started
+}
+
+// activeOp is an in-flight call and the cancel function of its context
+type activeOp struct {
+	info   OpInfo
+	start  time.Time
+	cancel context.CancelFunc
+}
+
+// activeOps tracks in-flight NFS calls by ID
+type activeOps struct {
+	mu     sync.Mutex
+	nextID uint64
+	ops    map[uint64]*activeOp
+}
+
+// add registers a call and returns its ID
+func (a *activeOps) add(op, path, client string, cancel context.CancelFunc) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.ops == nil {
+		a.ops = make(map[uint64]*activeOp)
+	}
+	a.nextID++
+	a.ops[a.nextID] = &activeOp{
+		info:   OpInfo{ID: a.nextID, Op: op, Path: path, Client: client},
+		start:  time.Now(),
+		cancel: cancel,
+	}
+	return a.nextID
+}
+
+// remove forgets the call with the given ID
+func (a *activeOps) remove(id uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.ops, id)
+}
+
+// ActiveOperations lists the NFS calls in progress, oldest first. A call
+// is listed until its reply is ready or it is abandoned on timeout or
+// cancellation.
+func (n *AbsfsNFS) ActiveOperations() []OpInfo {
+	n.activeOps.mu.Lock()
+	defer n.activeOps.mu.Unlock()
+
+	now := time.Now()
+	ops := make([]OpInfo, 0, len(n.activeOps.ops))
+	for _, op := range n.activeOps.ops {
+		info := op.info
+		info.Elapsed = now.Sub(op.start)
+		ops = append(ops, info)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+	return ops
+}
+
+// CancelOperation cancels the context of the in-flight call with the given
+// ID, as listed by ActiveOperations, and reports whether it was found. The
+// call is abandoned without a reply, as on timeout; a backing filesystem
+// call it has already made runs to completion.
+func (n *AbsfsNFS) CancelOperation(id uint64) bool {
+	n.activeOps.mu.Lock()
+	op, ok := n.activeOps.ops[id]
+	n.activeOps.mu.Unlock()
+	if !ok {
+		return false
+	}
+	op.cancel()
+	return true
+}
+
+// peekHandlePath returns the path of the file handle that starts the
+// arguments of an NFS call, or "" if there is none or it does not
+// resolve, along with a reader that still yields the whole of body
+func (h *NFSProcedureHandler) peekHandlePath(call *RPCCall, body io.Reader) (string, io.Reader) {
+	handle, ok, body := peekHandle(call, body)
+	if !ok {
+		return "", body
+	}
+	if node, ok := h.handleNode(handle); ok {
+		return node.path, body
+	}
+	return "", body
+}
+
+// peekHandle decodes the file handle that starts the arguments of an NFS
+// call, if there is one, along with a reader that still yields the whole
+// of body
+func peekHandle(call *RPCCall, body io.Reader) (uint64, bool, io.Reader) {
+	if call.Header.Procedure == NFSPROC3_NULL {
+		return 0, false, body
+	}
+	var head bytes.Buffer
+	decode := xdrDecodeFileHandle
+	if call.Header.Version == NFS_V2 {
+		decode = xdrDecodeFileHandleV2
+	}
+	handle, err := decode(io.TeeReader(body, &head))
+	body = io.MultiReader(&head, body)
+	return handle, err == nil, body
+}
@@ -0,0 +1,115 @@
+package absnfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// blockingReadFS holds every ReadAt until release is closed
+type blockingReadFS struct {
+	*memfs.FileSystem
+	release chan struct{}
+}
+
+type blockingReadFile struct {
+	absfs.File
+	release chan struct{}
+}
+
+func (f *blockingReadFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &blockingReadFile{File: file, release: f.release}, nil
+}
+
+func (f *blockingReadFile) ReadAt(p []byte, off int64) (int, error) {
+	<-f.release
+	return f.File.ReadAt(p, off)
+}
+
+func TestActiveOperationsCancel(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, _ := mfs.Create("/slow.txt")
+	f.Write([]byte("slow data"))
+	f.Close()
+
+	fs := &blockingReadFS{FileSystem: mfs, release: make(chan struct{})}
+	defer close(fs.release)
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+
+	var args bytes.Buffer
+	xdrEncodeFileHandle(&args, getFileHandle(server, "/slow.txt"))
+	binary.Write(&args, binary.BigEndian, uint64(0))
+	binary.Write(&args, binary.BigEndian, uint32(9))
+	call := &RPCCall{
+		Header: RPCMsgHeader{
+			Xid:        1,
+			MsgType:    RPC_CALL,
+			RPCVersion: 2,
+			Program:    NFS_PROGRAM,
+			Version:    NFS_V3,
+			Procedure:  NFSPROC3_READ,
+		},
+	}
+	authCtx := testAuthContext()
+	authCtx.Credential = &call.Credential
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := handler.HandleCall(call, &args, authCtx)
+		done <- err
+	}()
+
+	var op OpInfo
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if ops := nfs.ActiveOperations(); len(ops) == 1 {
+			op = ops[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The READ never showed up in ActiveOperations")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if op.Op != "READ" || op.Path != "/slow.txt" || op.Client != "127.0.0.1" {
+		t.Errorf("ActiveOperations listed %+v, want READ of /slow.txt from 127.0.0.1", op)
+	}
+
+	if nfs.CancelOperation(op.ID + 1) {
+		t.Error("CancelOperation reported an unknown ID as found")
+	}
+	if !nfs.CancelOperation(op.ID) {
+		t.Fatal("CancelOperation did not find the READ")
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the cancelled READ to return context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The cancelled READ did not return promptly")
+	}
+	if ops := nfs.ActiveOperations(); len(ops) != 0 {
+		t.Errorf("Expected no active operations after cancelling, got %+v", ops)
+	}
+}
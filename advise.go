@@ -0,0 +1,54 @@
+// advise.go: Access-pattern hints for backing filesystems.
+//
+// A backing filesystem that can use hints about upcoming reads, such as a
+// wrapper passing them to posix_fadvise, implements Advisor. With
+// ExportOptions.AdviseSequentialReads set, the server counts the READs of
+// each file handle that start where the previous one ended. Once a run
+// reaches that many, the file is advised as sequential and each further
+// READ in the run advises the range after it as needed soon, so the OS
+// reads ahead for sequential clients and not for random-access ones.
+package absnfs
+
+// Advice is a hint about how a file will be read
+type Advice int
+
+const (
+	AdviceSequential Advice = iota + 1 // the file will be read in order
+	AdviceWillNeed                     // the range will be read soon
+)
+
+// Advisor is implemented by backing filesystems that take read hints
+type Advisor interface {
+	// Advise hints how the range of length bytes at offset of the file at
+	// path will be read; a length of 0 extends to the end of the file
+	Advise(path string, offset, length int64, advice Advice) error
+}
+
+// adviseRead records a READ of n bytes at offset of node and advises the
+// backing filesystem if it continues a sequential run. Advice is only a
+// hint, so its errors are ignored.
+func (s *AbsfsNFS) adviseRead(node *NFSNode, offset int64, n int) {
+	threshold := s.tuning.Load().AdviseSequentialReads
+	if s.advisor == nil || threshold <= 0 || n == 0 {
+		return
+	}
+
+	end := offset + int64(n)
+	node.mu.Lock()
+	if offset == node.nextRead {
+		node.seqReads++
+	} else {
+		node.seqReads = 1
+	}
+	node.nextRead = end
+	run := node.seqReads
+	node.mu.Unlock()
+
+	if run < threshold {
+		return
+	}
+	if run == threshold {
+		s.advisor.Advise(node.path, 0, 0, AdviceSequential)
+	}
+	s.advisor.Advise(node.path, end, int64(n), AdviceWillNeed)
+}
@@ -0,0 +1,82 @@
+package absnfs
+
+import (
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// advisingFS records the read hints it is given
+type advisingFS struct {
+	*memfs.FileSystem
+	advice []adviceCall
+}
+
+type adviceCall struct {
+	path           string
+	offset, length int64
+	advice         Advice
+}
+
+func (f *advisingFS) Advise(path string, offset, length int64, advice Advice) error {
+	f.advice = append(f.advice, adviceCall{path, offset, length, advice})
+	return nil
+}
+
+func TestAdviseSequentialReads(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, mfs, "/seq", string(make([]byte, 65536)))
+	writeTestFile(t, mfs, "/random", string(make([]byte, 65536)))
+	fs := &advisingFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{AdviseSequentialReads: 3})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer nfs.Close()
+
+	read := func(path string, offsets ...int64) {
+		t.Helper()
+		node, err := nfs.Lookup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, off := range offsets {
+			if _, err := nfs.Read(node, off, 4096); err != nil {
+				t.Fatalf("Read(%s, %d): %v", path, off, err)
+			}
+		}
+	}
+
+	// Random 4KB reads never form a run of three
+	read("/random", 40960, 8192, 32768, 0, 20480, 12288, 57344)
+	if len(fs.advice) != 0 {
+		t.Fatalf("random reads advised %+v", fs.advice)
+	}
+
+	// The third contiguous read advises the file as sequential, and it and
+	// each read after it advise the next range
+	read("/seq", 0, 4096, 8192, 12288)
+	want := []adviceCall{
+		{"/seq", 0, 0, AdviceSequential},
+		{"/seq", 12288, 4096, AdviceWillNeed},
+		{"/seq", 16384, 4096, AdviceWillNeed},
+	}
+	if len(fs.advice) != len(want) {
+		t.Fatalf("sequential reads advised %+v, want %+v", fs.advice, want)
+	}
+	for i := range want {
+		if fs.advice[i] != want[i] {
+			t.Errorf("advice %d = %+v, want %+v", i, fs.advice[i], want[i])
+		}
+	}
+
+	// A seek breaks the run, and advice waits for a new one
+	fs.advice = nil
+	read("/seq", 0, 40960, 45056)
+	if len(fs.advice) != 0 {
+		t.Errorf("reads after a seek advised %+v before a new run of three", fs.advice)
+	}
+}
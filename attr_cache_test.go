@@ -1,6 +1,10 @@
 package absnfs
 
 import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -219,3 +223,134 @@ func TestAttrCacheTimeout(t *testing.T) {
 			attrs3.Mode, 0777)
 	}
 }
+
+// lstatCountingFS counts Lstat calls
+type lstatCountingFS struct {
+	*memfs.FileSystem
+	lstats atomic.Int64
+}
+
+func (f *lstatCountingFS) Lstat(name string) (os.FileInfo, error) {
+	f.lstats.Add(1)
+	return f.FileSystem.Lstat(name)
+}
+
+func TestHandleAttrCache(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.MkdirAll("/a/b", 0755)
+	f, err := mfs.Create("/a/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// With ConfineSymlinks, every path check Lstats each component
+	fs := &lstatCountingFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{HandleAttrCache: true, ConfineSymlinks: true, AttrCacheTimeout: time.Minute})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+	handler := &NFSProcedureHandler{server: &Server{handler: nfs}}
+	fileHandle := getFileHandle(handler.server, "/a/b/file")
+
+	getattrMode := func() uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fileHandle)
+		result, err := handler.handleGetattr(&buf, &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleGetattr: %v", err)
+		}
+		data := result.Data.([]byte)
+		if status := binary.BigEndian.Uint32(data[0:4]); status != NFS_OK {
+			t.Fatalf("GETATTR status = %d", status)
+		}
+		return binary.BigEndian.Uint32(data[8:12]) // after status and type
+	}
+
+	getattrMode() // warms the handle's entry
+	before := fs.lstats.Load()
+	for i := 0; i < 10; i++ {
+		getattrMode()
+	}
+	if n := fs.lstats.Load() - before; n != 0 {
+		t.Errorf("cached GETATTRs by handle made %d Lstat calls, want 0", n)
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, fileHandle)
+	buf.Write(encodeSattr3(true, 0600, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+	xdrEncodeUint32(&buf, 0) // no guard
+	result, err := handler.handleSetattr(&buf, &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleSetattr: %v", err)
+	}
+	if status := binary.BigEndian.Uint32(result.Data.([]byte)[0:4]); status != NFS_OK {
+		t.Fatalf("SETATTR status = %d", status)
+	}
+	if mode := getattrMode(); mode != 0600 {
+		t.Errorf("GETATTR after SETATTR mode = %o, want 600", mode)
+	}
+}
+
+func TestCacheConsistency(t *testing.T) {
+	tests := []struct {
+		mode string
+		want int64 // size LOOKUP reports after an out-of-band write
+	}{
+		{CacheConsistencyTTL, 5},
+		{CacheConsistencyCloseToOpen, 11},
+		{CacheConsistencyStrict, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			mfs, err := memfs.NewFS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			nfs, err := New(mfs, ExportOptions{AttrCacheTimeout: time.Hour, CacheConsistency: tt.mode})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			t.Cleanup(func() { nfs.Close() })
+
+			f, err := mfs.Create("/file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("hello"))
+			f.Close()
+			if node, err := nfs.Lookup("/file"); err != nil || node.attrs.Size != 5 {
+				t.Fatalf("first Lookup = %v, %v; want size 5", node, err)
+			}
+
+			// Another process on the backing filesystem
+			f, err = mfs.OpenFile("/file", os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte(" world"))
+			f.Close()
+
+			node, err := nfs.Lookup("/file")
+			if err != nil {
+				t.Fatalf("second Lookup: %v", err)
+			}
+			if node.attrs.Size != tt.want {
+				t.Errorf("size after out-of-band write = %d, want %d", node.attrs.Size, tt.want)
+			}
+		})
+	}
+
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(mfs, ExportOptions{CacheConsistency: "eventual"}); err == nil {
+		t.Error("New accepted CacheConsistency \"eventual\"")
+	}
+}
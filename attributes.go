@@ -9,6 +9,9 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"reflect"
+	"runtime"
+	"time"
 )
 
 // NFSv3 file types (ftype3)
@@ -22,6 +25,116 @@ const (
 	NF3FIFO = 7 // Named pipe (FIFO)
 )
 
+// normalizeModeType reduces the type bits of mode to a single file type, for
+// backing filesystems that report conflicting ones. Precedence is directory,
+// symlink, device, named pipe, socket; anything else is a regular file.
+// It also reports whether mode had to change.
+func normalizeModeType(mode os.FileMode) (os.FileMode, bool) {
+	typ := mode & os.ModeType
+	var canonical os.FileMode
+	switch {
+	case typ&os.ModeDir != 0:
+		canonical = os.ModeDir
+	case typ&os.ModeSymlink != 0:
+		canonical = os.ModeSymlink
+	case typ&os.ModeCharDevice != 0:
+		canonical = os.ModeDevice | os.ModeCharDevice
+	case typ&os.ModeDevice != 0:
+		canonical = os.ModeDevice
+	case typ&os.ModeNamedPipe != 0:
+		canonical = os.ModeNamedPipe
+	case typ&os.ModeSocket != 0:
+		canonical = os.ModeSocket
+	case typ == os.ModeIrregular:
+		// Served as a regular file, but not a conflict
+		canonical = os.ModeIrregular
+	}
+	if canonical == typ {
+		return mode, false
+	}
+	return mode&^os.ModeType | canonical, true
+}
+
+// fileMode returns the mode of info, the backing filesystem's view of path,
+// with conflicting type bits normalized and logged
+func (s *AbsfsNFS) fileMode(path string, info os.FileInfo) os.FileMode {
+	mode, changed := normalizeModeType(info.Mode())
+	if changed {
+		if slog := s.getStructuredLogger(); slog != nil {
+			slog.Warn("backing filesystem reported conflicting file type bits",
+				LogField{Key: "path", Value: path},
+				LogField{Key: "mode", Value: info.Mode().String()},
+				LogField{Key: "served_as", Value: mode.String()})
+		}
+	}
+	return mode
+}
+
+// fileModTime returns the modification time of info, the backing
+// filesystem's view of path. With ClampFutureMtime, a time ahead of the
+// server clock is reported as now, and logged.
+func (s *AbsfsNFS) fileModTime(path string, info os.FileInfo) time.Time {
+	modTime := info.ModTime()
+	if !s.tuning.Load().ClampFutureMtime {
+		return modTime
+	}
+	now := time.Now()
+	if !modTime.After(now) {
+		return modTime
+	}
+	if slog := s.getStructuredLogger(); slog != nil {
+		slog.Warn("backing filesystem reported a future mtime",
+			LogField{Key: "path", Value: path},
+			LogField{Key: "mtime", Value: modTime.UTC().Format(time.RFC3339Nano)},
+			LogField{Key: "served_as", Value: now.UTC().Format(time.RFC3339Nano)})
+	}
+	return now
+}
+
+// deviceNumbers returns the major and minor numbers of the device info
+// describes, split from the Rdev field of the value behind info.Sys() as
+// the host's dev_t lays them out. Anything but a block or character
+// device, or one whose filesystem reports no Rdev, gets zeros.
+func deviceNumbers(info os.FileInfo) (major, minor uint32) {
+	if info.Mode()&os.ModeDevice == 0 {
+		return 0, 0
+	}
+	dev, ok := sysField(info, "Rdev")
+	if !ok {
+		return 0, 0
+	}
+	if runtime.GOOS == "darwin" {
+		return uint32(dev >> 24 & 0xff), uint32(dev & 0xffffff)
+	}
+	// Linux: 12 bits of major and 20 of minor, interleaved
+	major = uint32(dev&0x00000000000fff00>>8 | dev&0xfffff00000000000>>32)
+	minor = uint32(dev&0x00000000000000ff | dev&0x00000ffffff00000>>12)
+	return major, minor
+}
+
+// sysField returns the integer field name of the struct behind
+// info.Sys(), such as the Ino and Rdev fields of syscall.Stat_t
+func sysField(info os.FileInfo, name string) (uint64, bool) {
+	v := reflect.ValueOf(info.Sys())
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return 0, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := v.FieldByName(name)
+	switch f.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f.Uint(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(f.Int()), true
+	}
+	return 0, false
+}
+
 // encodeFileAttributes writes NFSv3 fattr3 structure to an io.Writer in XDR format
 // Per RFC 1813, fattr3 contains:
 //
@@ -38,10 +151,12 @@ const (
 //	nfstime3   atime      - access time (seconds, nseconds)
 //	nfstime3   mtime      - modify time (seconds, nseconds)
 //	nfstime3   ctime      - change time (seconds, nseconds)
-func encodeFileAttributes(w io.Writer, attrs *NFSAttrs) error {
+//
+// The owner is translated to client IDs through ids.
+func encodeFileAttributes(w io.Writer, attrs *NFSAttrs, ids *idMaps) error {
 	// Determine file type from mode
 	var ftype uint32
-	mode := attrs.Mode
+	mode, _ := normalizeModeType(attrs.Mode)
 	switch mode & os.ModeType {
 	case os.ModeDir:
 		ftype = NF3DIR
@@ -76,11 +191,12 @@ func encodeFileAttributes(w io.Writer, attrs *NFSAttrs) error {
 		return err
 	}
 	// uid - owner user id
-	if err := xdrEncodeUint32(w, attrs.Uid); err != nil {
+	uid, gid := ids.toClient(attrs.Uid, attrs.Gid)
+	if err := xdrEncodeUint32(w, uid); err != nil {
 		return err
 	}
 	// gid - owner group id
-	if err := xdrEncodeUint32(w, attrs.Gid); err != nil {
+	if err := xdrEncodeUint32(w, gid); err != nil {
 		return err
 	}
 	// size - file size in bytes (uint64)
@@ -91,11 +207,15 @@ func encodeFileAttributes(w io.Writer, attrs *NFSAttrs) error {
 	if err := xdrEncodeUint64(w, uint64(attrs.Size)); err != nil {
 		return err
 	}
-	// rdev - specdata3 (specdata1, specdata2) - not used for regular files
-	if err := xdrEncodeUint32(w, 0); err != nil { // specdata1
+	// rdev - specdata3 (major, minor) - zero except for devices
+	var major, minor uint32
+	if ftype == NF3BLK || ftype == NF3CHR {
+		major, minor = attrs.RdevMajor, attrs.RdevMinor
+	}
+	if err := xdrEncodeUint32(w, major); err != nil { // specdata1
 		return err
 	}
-	if err := xdrEncodeUint32(w, 0); err != nil { // specdata2
+	if err := xdrEncodeUint32(w, minor); err != nil { // specdata2
 		return err
 	}
 	// fsid - filesystem id (uint64)
@@ -132,6 +252,13 @@ func encodeFileAttributes(w io.Writer, attrs *NFSAttrs) error {
 	return nil
 }
 
+// nfsTime returns the instant an nfstime3 denotes. NFS times count seconds
+// since the Unix epoch in UTC, so the result is in UTC rather than the
+// server's local zone.
+func nfsTime(sec, nsec uint32) time.Time {
+	return time.Unix(int64(sec), int64(nsec)).UTC()
+}
+
 // encodeWccAttr writes NFSv3 wcc_attr structure to an io.Writer in XDR format
 // Per RFC 1813, wcc_attr contains:
 //
@@ -164,10 +291,10 @@ func encodeWccAttr(w io.Writer, attrs *NFSAttrs) error {
 }
 
 // encodeAttributesResponse writes a successful NFS response with file attributes
-func encodeAttributesResponse(attrs *NFSAttrs) ([]byte, error) {
+func encodeAttributesResponse(attrs *NFSAttrs, ids *idMaps) ([]byte, error) {
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, ids); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"math"
 	"os"
+	"runtime"
 	"testing"
 	"time"
+
+	"github.com/absfs/memfs"
 )
 
 func TestEncodeFileAttributes(t *testing.T) {
@@ -23,7 +27,7 @@ func TestEncodeFileAttributes(t *testing.T) {
 		attrs.SetAtime(now)
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode attributes: %v", err)
 		}
@@ -198,7 +202,7 @@ func TestEncodeFileAttributes(t *testing.T) {
 		}
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode attributes: %v", err)
 		}
@@ -220,7 +224,7 @@ func TestEncodeFileAttributes(t *testing.T) {
 		}
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode attributes: %v", err)
 		}
@@ -251,7 +255,7 @@ func TestEncodeFileAttributes(t *testing.T) {
 			failAfter: 2, // Fail after writing ftype and mode
 		}
 
-		err := encodeFileAttributes(failWriter, attrs)
+		err := encodeFileAttributes(failWriter, attrs, nil)
 		if err == nil {
 			t.Error("Expected error from failing writer, got nil")
 		}
@@ -271,7 +275,7 @@ func TestEncodeAttributesResponse(t *testing.T) {
 		attrs.SetMtime(now)
 		attrs.SetAtime(now)
 
-		data, err := encodeAttributesResponse(attrs)
+		data, err := encodeAttributesResponse(attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode attributes response: %v", err)
 		}
@@ -340,3 +344,274 @@ func (w *attrFailingWriter) Write(p []byte) (n int, err error) {
 	}
 	return len(p), nil
 }
+
+// modeFileInfo is a FileInfo reporting an arbitrary mode
+type modeFileInfo struct {
+	mockFileInfo
+	mode os.FileMode
+}
+
+func (m *modeFileInfo) Mode() os.FileMode { return m.mode }
+
+func TestEncodeFileAttributesConflictingTypeBits(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  os.FileMode
+		ftype uint32
+	}{
+		{"dir and symlink", os.ModeDir | os.ModeSymlink | 0755, NF3DIR},
+		{"symlink and device", os.ModeSymlink | os.ModeDevice | 0777, NF3LNK},
+		{"char device without device bit", os.ModeCharDevice | 0600, NF3CHR},
+		{"device and pipe", os.ModeDevice | os.ModeNamedPipe | 0600, NF3BLK},
+		{"pipe and socket", os.ModeNamedPipe | os.ModeSocket | 0600, NF3FIFO},
+		{"dir and irregular", os.ModeDir | os.ModeIrregular | 0755, NF3DIR},
+		{"irregular", os.ModeIrregular | 0644, NF3REG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			attrs := NewNFSAttrs(tt.mode, 0, time.Now(), time.Now(), 0, 0)
+			if err := encodeFileAttributes(&buf, attrs, nil); err != nil {
+				t.Fatalf("encodeFileAttributes: %v", err)
+			}
+			if ftype := binary.BigEndian.Uint32(buf.Bytes()); ftype != tt.ftype {
+				t.Errorf("ftype = %d, want %d", ftype, tt.ftype)
+			}
+			if perm := binary.BigEndian.Uint32(buf.Bytes()[4:]); perm != uint32(tt.mode.Perm()) {
+				t.Errorf("mode = %o, want %o", perm, tt.mode.Perm())
+			}
+		})
+	}
+}
+
+func TestFileModeLogsConflictingTypeBits(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	logs := &recordingLogger{}
+	nfs.SetLogger(logs)
+
+	if mode := nfs.fileMode("/ok", &modeFileInfo{mode: os.ModeDir | 0755}); mode != os.ModeDir|0755 {
+		t.Errorf("consistent mode changed to %v", mode)
+	}
+	if len(logs.entries) != 0 {
+		t.Fatalf("Expected no log entries for a consistent mode, got %v", logs.entries)
+	}
+
+	if mode := nfs.fileMode("/odd", &modeFileInfo{mode: os.ModeDir | os.ModeSymlink | 0755}); mode != os.ModeDir|0755 {
+		t.Errorf("conflicting mode normalized to %v, want %v", mode, os.ModeDir|0755)
+	}
+	if len(logs.entries) != 1 {
+		t.Fatalf("Expected one log entry, got %v", logs.entries)
+	}
+	if logs.entries[0][1].Key != "path" || logs.entries[0][1].Value != "/odd" {
+		t.Errorf("Expected the entry to name /odd, got %v", logs.entries[0])
+	}
+}
+
+func TestEncodeFileAttributesLargeFile(t *testing.T) {
+	// fattr3 as it appears on the wire
+	type fattr3 struct {
+		Type, Mode, Nlink, Uid, Gid uint32
+		Size, Used                  uint64
+		Rdev1, Rdev2                uint32
+		Fsid, Fileid                uint64
+		Times                       [6]uint32
+	}
+
+	tests := []struct {
+		name   string
+		size   int64
+		fileID uint64
+	}{
+		{"5GB", 5 << 30, 1<<40 | 7},
+		{"just over 4GB", 1<<32 + 1, 1 << 32},
+		{"largest size", math.MaxInt64, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := NewNFSAttrs(0644, tt.size, time.Now(), time.Now(), 0, 0)
+			attrs.FileId = tt.fileID
+
+			var buf bytes.Buffer
+			if err := encodeFileAttributes(&buf, attrs, nil); err != nil {
+				t.Fatalf("encodeFileAttributes: %v", err)
+			}
+			var decoded fattr3
+			if err := binary.Read(bytes.NewReader(buf.Bytes()), binary.BigEndian, &decoded); err != nil {
+				t.Fatalf("decoding fattr3: %v", err)
+			}
+			if buf.Len() != binary.Size(decoded) {
+				t.Errorf("fattr3 is %d bytes, want %d", buf.Len(), binary.Size(decoded))
+			}
+			if decoded.Size != uint64(tt.size) || decoded.Used != uint64(tt.size) {
+				t.Errorf("size/used decoded as %d/%d, want %d", decoded.Size, decoded.Used, tt.size)
+			}
+			if decoded.Fileid != tt.fileID {
+				t.Errorf("fileid decoded as %d, want %d", decoded.Fileid, tt.fileID)
+			}
+
+			buf.Reset()
+			if err := encodeWccAttr(&buf, attrs); err != nil {
+				t.Fatalf("encodeWccAttr: %v", err)
+			}
+			if size := binary.BigEndian.Uint64(buf.Bytes()); size != uint64(tt.size) {
+				t.Errorf("wcc_attr size decoded as %d, want %d", size, tt.size)
+			}
+		})
+	}
+}
+
+// deviceFS reports the files in devices as device nodes with the given
+// mode bits and raw dev_t, in a Sys() value shaped like syscall.Stat_t
+type deviceFS struct {
+	*memfs.FileSystem
+	devices map[string]deviceInfo
+}
+
+type deviceInfo struct {
+	os.FileInfo
+	mode os.FileMode
+	sys  *struct{ Rdev uint64 }
+}
+
+func (d deviceInfo) Mode() os.FileMode { return d.mode }
+func (d deviceInfo) Sys() interface{}  { return d.sys }
+
+func (f *deviceFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := f.FileSystem.Lstat(name)
+	if dev, ok := f.devices[name]; ok && err == nil {
+		dev.FileInfo = info
+		return dev, nil
+	}
+	return info, err
+}
+
+// makedev packs major and minor into a dev_t the way the host does
+func makedev(major, minor uint64) uint64 {
+	if runtime.GOOS == "darwin" {
+		return major<<24 | minor
+	}
+	return minor&0xff | major&0xfff<<8 | minor&^0xff<<12 | major&^0xfff<<32
+}
+
+func TestEncodeFileAttributesRdev(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/sda1", "/tty", "/plain"} {
+		f, _ := mfs.Create(name)
+		f.Close()
+	}
+	fs := &deviceFS{FileSystem: mfs, devices: map[string]deviceInfo{
+		"/sda1":  {mode: os.ModeDevice | 0660, sys: &struct{ Rdev uint64 }{makedev(8, 1)}},
+		"/tty":   {mode: os.ModeDevice | os.ModeCharDevice | 0620, sys: &struct{ Rdev uint64 }{makedev(136, 300)}},
+		"/plain": {mode: 0644, sys: &struct{ Rdev uint64 }{makedev(1, 2)}},
+	}}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer nfs.Close()
+
+	tests := []struct {
+		path         string
+		ftype        uint32
+		major, minor uint32
+	}{
+		{"/sda1", NF3BLK, 8, 1},
+		{"/tty", NF3CHR, 136, 300},
+		{"/plain", NF3REG, 0, 0},
+	}
+	for _, tt := range tests {
+		node, err := nfs.Lookup(tt.path)
+		if err != nil {
+			t.Fatalf("Lookup(%s): %v", tt.path, err)
+		}
+		attrs, err := nfs.GetAttr(node)
+		if err != nil {
+			t.Fatalf("GetAttr(%s): %v", tt.path, err)
+		}
+		var buf bytes.Buffer
+		if err := encodeFileAttributes(&buf, attrs, nil); err != nil {
+			t.Fatalf("encodeFileAttributes: %v", err)
+		}
+		var decoded struct {
+			Type, Mode, Nlink, Uid, Gid uint32
+			Size, Used                  uint64
+			Rdev1, Rdev2                uint32
+		}
+		binary.Read(&buf, binary.BigEndian, &decoded)
+		if decoded.Type != tt.ftype || decoded.Rdev1 != tt.major || decoded.Rdev2 != tt.minor {
+			t.Errorf("%s: type %d rdev %d,%d; want type %d rdev %d,%d",
+				tt.path, decoded.Type, decoded.Rdev1, decoded.Rdev2, tt.ftype, tt.major, tt.minor)
+		}
+	}
+}
+
+// TestSetattrTimeRoundTripsInUTC sets a file's times to UTC instants with
+// SETATTR and reads them back with GETATTR while the server's local zone is
+// far from UTC: nfstime3 values must come back exactly as sent.
+func TestSetattrTimeRoundTripsInUTC(t *testing.T) {
+	for _, zone := range []*time.Location{
+		time.FixedZone("UTC-7", -7*3600),
+		time.FixedZone("UTC+5:30", 5*3600+1800),
+	} {
+		t.Run(zone.String(), func(t *testing.T) {
+			saved := time.Local
+			time.Local = zone
+			t.Cleanup(func() { time.Local = saved })
+
+			srv, handler, auth := setupHandlerEnv(t)
+			handle := allocHandle(t, srv, "/dir/file.txt")
+			atime := time.Date(2024, time.January, 1, 0, 0, 0, 5, time.UTC)
+			mtime := time.Date(2024, time.March, 10, 23, 59, 59, 123456789, time.UTC)
+
+			var buf bytes.Buffer
+			xdrEncodeFileHandle(&buf, handle)
+			buf.Write(encodeSattr3(false, 0, false, 0, false, 0, false, 0,
+				2, uint32(atime.Unix()), uint32(atime.Nanosecond()),
+				2, uint32(mtime.Unix()), uint32(mtime.Nanosecond())))
+			xdrEncodeUint32(&buf, 0) // no guard
+			reply, err := handler.handleSetattr(&buf, &RPCReply{}, auth)
+			if err != nil {
+				t.Fatalf("handleSetattr: %v", err)
+			}
+			if status := readStatus(t, reply); status != NFS_OK {
+				t.Fatalf("SETATTR status = %d, want NFS_OK", status)
+			}
+
+			info, err := srv.handler.fs.Stat("/dir/file.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(mtime) {
+				t.Errorf("backing mtime = %v, want %v", info.ModTime(), mtime)
+			}
+
+			buf.Reset()
+			xdrEncodeFileHandle(&buf, handle)
+			reply, err = handler.handleGetattr(&buf, &RPCReply{}, auth)
+			if err != nil {
+				t.Fatalf("handleGetattr: %v", err)
+			}
+			data := reply.Data.([]byte)
+			if status := binary.BigEndian.Uint32(data[0:4]); status != NFS_OK {
+				t.Fatalf("GETATTR status = %d, want NFS_OK", status)
+			}
+			// status, then fattr3 with mtime at offset 68; atime is reported
+			// as mtime since absfs exposes no atime
+			got := nfsTime(binary.BigEndian.Uint32(data[72:]), binary.BigEndian.Uint32(data[76:]))
+			if !got.Equal(mtime) {
+				t.Errorf("mtime = %v, want %v", got, mtime)
+			}
+		})
+	}
+}
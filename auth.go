@@ -1,12 +1,13 @@
 // auth.go: Authentication and access control.
 //
 // Implements IP-based host filtering (AllowedHosts), UID/GID squash modes
-// (none, root, all), TLS client certificate verification, and AUTH_SYS
-// credential extraction from RPC calls. Produces the AuthContext consumed
-// by NFS request handlers.
+// (none, root, all), TLS client certificate verification, AUTH_SYS
+// credential extraction from RPC calls, and the mapping of RPCSEC_GSS
+// principals. Produces the AuthContext consumed by NFS request handlers.
 package absnfs
 
 import (
+	"context"
 	"crypto/x509"
 	"fmt"
 	"net"
@@ -19,26 +20,42 @@ type AuthContext struct {
 	ClientPort   int                // Client port number
 	Credential   *RPCCredential     // RPC credential
 	AuthSys      *AuthSysCredential // Parsed AUTH_SYS credential (if applicable)
-	ClientCert   *x509.Certificate  // Client certificate (if TLS with client auth)
+	Principal    string             // Client principal of a verified RPCSEC_GSS call (if applicable)
+	ClientCert   *x509.Certificate  // Verified client certificate (if TLS with client auth)
 	TLSEnabled   bool               // Whether this connection is using TLS
 	EffectiveUID uint32             // Effective UID after squashing
 	EffectiveGID uint32             // Effective GID after squashing
+	ReadOnly     bool               // Client is read-only per the export table
+	UDP          bool               // Call arrived as a UDP datagram, so its reply must fit in one
+	Ping         bool               // Call is a NULL procedure, exempt from the reserved port check
+
+	ctx context.Context // Context of the NFS call, carrying its trace span
+}
+
+// callContext returns the context of the NFS call being handled
+func (a *AuthContext) callContext() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
 }
 
 // AuthResult contains the result of authentication validation
 type AuthResult struct {
-	Allowed bool   // Whether the request is allowed
-	UID     uint32 // Effective UID after squashing
-	GID     uint32 // Effective GID after squashing
-	Reason  string // Reason for denial (if not allowed)
+	Allowed bool        // Whether the request is allowed
+	UID     uint32      // Effective UID after squashing
+	GID     uint32      // Effective GID after squashing
+	Reason  string      // Reason for denial (if not allowed)
+	Rule    *ClientRule // Client rule covering the client, if any
 }
 
 // ValidateAuthentication validates a client request against policy options
 func ValidateAuthentication(ctx *AuthContext, policy *PolicyOptions) *AuthResult {
+	anon := anonIdentity(policy)
 	result := &AuthResult{
 		Allowed: false,
-		UID:     65534, // Default to nobody
-		GID:     65534, // Default to nobody
+		UID:     anon.UID, // Default to nobody
+		GID:     anon.GID, // Default to nobody
 	}
 
 	// Step 1: Validate client IP address
@@ -49,24 +66,47 @@ func ValidateAuthentication(ctx *AuthContext, policy *PolicyOptions) *AuthResult
 		}
 	}
 
-	// Step 2: Validate secure port requirement
-	if policy.Secure {
+	// Step 2: Validate the reserved port requirement, over TCP and UDP
+	// alike. NULL is let through so clients can still ping the server from
+	// any port
+	if (policy.RequireReservedPort || policy.Secure) && !ctx.Ping {
 		if ctx.ClientPort >= 1024 {
-			result.Reason = fmt.Sprintf("client port %d is not a privileged port (required when Secure=true)", ctx.ClientPort)
+			result.Reason = fmt.Sprintf("non-reserved port %d", ctx.ClientPort)
+			return result
+		}
+	}
+
+	// Step 3: The most specific client rule overrides Squash
+	squash := policy.Squash
+	if rule := matchClientRule(policy.ClientRules, ctx.ClientIP); rule != nil {
+		result.Rule = rule
+		if rule.Squash != "" {
+			squash = rule.Squash
+		}
+	}
+
+	// Step 4: A verified client certificate that CertToIDFunc maps is
+	// authoritative; the AUTH_SYS identity is client-asserted and ignored
+	if ctx.ClientCert != nil && policy.CertToIDFunc != nil {
+		if uid, gid, ok := policy.CertToIDFunc(ctx.ClientCert); ok {
+			result.Allowed = true
+			result.UID = uid
+			result.GID = gid
+			applySquashing(result, &AuthSysCredential{UID: uid, GID: gid}, squash, anon)
 			return result
 		}
 	}
 
-	// Step 3: Validate credential flavor
+	// Step 5: Validate credential flavor
 	switch ctx.Credential.Flavor {
 	case AUTH_NONE:
 		// AUTH_NONE is intentionally accepted per standard NFS server behavior.
 		// NFS servers commonly accept AUTH_NONE for public/shared exports where
-		// authentication is not required. The client is mapped to nobody/nobody
-		// (UID/GID 65534) to restrict access to unprivileged operations only.
+		// authentication is not required. The client is mapped to the anonymous
+		// user (AnonUID/AnonGID) to restrict access to unprivileged operations only.
 		result.Allowed = true
-		result.UID = 65534
-		result.GID = 65534
+		result.UID = anon.UID
+		result.GID = anon.GID
 
 	case AUTH_SYS:
 		// Parse AUTH_SYS credentials if not already parsed
@@ -84,8 +124,27 @@ func ValidateAuthentication(ctx *AuthContext, policy *PolicyOptions) *AuthResult
 		result.UID = ctx.AuthSys.UID
 		result.GID = ctx.AuthSys.GID
 
-		// Step 4: Apply squashing (user mapping)
-		applySquashing(result, ctx.AuthSys, policy.Squash)
+		// Step 6: Apply squashing (user mapping)
+		applySquashing(result, ctx.AuthSys, squash, anon)
+
+	case RPCSEC_GSS:
+		if policy.GSSAcceptor == nil {
+			result.Reason = "RPCSEC_GSS is not enabled"
+			return result
+		}
+
+		// Context establishment runs as nobody, and so does a principal
+		// PrincipalMapper does not map
+		result.Allowed = true
+		result.UID = anon.UID
+		result.GID = anon.GID
+		if ctx.Principal != "" && policy.PrincipalMapper != nil {
+			if uid, gid, ok := policy.PrincipalMapper(ctx.Principal); ok {
+				result.UID = uid
+				result.GID = gid
+			}
+		}
+		applySquashing(result, &AuthSysCredential{UID: result.UID, GID: result.GID}, squash, anon)
 
 	default:
 		// Other authentication flavors are not supported
@@ -96,17 +155,24 @@ func ValidateAuthentication(ctx *AuthContext, policy *PolicyOptions) *AuthResult
 	return result
 }
 
-// applySquashing applies user ID squashing/mapping according to the export options
-func applySquashing(result *AuthResult, authSys *AuthSysCredential, squash string) {
+// anonIdentity returns the identity squashed and anonymous callers get
+func anonIdentity(policy *PolicyOptions) AuthSysCredential {
+	uid, gid := policy.anonIDs()
+	return AuthSysCredential{UID: uid, GID: gid}
+}
+
+// applySquashing applies user ID squashing/mapping according to the export
+// options, squashing to anon's UID and GID
+func applySquashing(result *AuthResult, authSys *AuthSysCredential, squash string, anon AuthSysCredential) {
 	switch strings.ToLower(squash) {
 	case "root":
-		// Map root (UID 0) to nobody - squash both UID and GID when UID is root
+		// Map root (UID 0) to the anonymous user - squash both UID and GID when UID is root
 		if authSys.UID == 0 {
-			result.UID = 65534
-			result.GID = 65534
+			result.UID = anon.UID
+			result.GID = anon.GID
 		} else if result.GID == 0 {
 			// Non-root user with primary GID 0 -- squash the GID only
-			result.GID = 65534
+			result.GID = anon.GID
 		}
 		// Squash GID 0 in auxiliary GID list (copy first to avoid mutating shared slice)
 		if len(authSys.AuxGIDs) > 0 {
@@ -115,20 +181,20 @@ func applySquashing(result *AuthResult, authSys *AuthSysCredential, squash strin
 			authSys.AuxGIDs = auxCopy
 			for i, gid := range authSys.AuxGIDs {
 				if gid == 0 {
-					authSys.AuxGIDs[i] = 65534
+					authSys.AuxGIDs[i] = anon.GID
 				}
 			}
 		}
 
 	case "all":
-		// Map all users to nobody
-		result.UID = 65534
-		result.GID = 65534
+		// Map all users to the anonymous user
+		result.UID = anon.UID
+		result.GID = anon.GID
 		// Squash all auxiliary GIDs (copy first to avoid mutating shared slice)
 		if len(authSys.AuxGIDs) > 0 {
 			auxCopy := make([]uint32, len(authSys.AuxGIDs))
 			for i := range auxCopy {
-				auxCopy[i] = 65534
+				auxCopy[i] = anon.GID
 			}
 			authSys.AuxGIDs = auxCopy
 		}
@@ -139,8 +205,8 @@ func applySquashing(result *AuthResult, authSys *AuthSysCredential, squash strin
 
 	default:
 		// Unknown squash mode - fail closed by squashing all users
-		result.UID = 65534
-		result.GID = 65534
+		result.UID = anon.UID
+		result.GID = anon.GID
 	}
 }
 
@@ -154,14 +220,27 @@ func normalizeIP(ip net.IP) net.IP {
 	return ip
 }
 
+// parseClientIP parses a client address as it comes from a connection's
+// remote address, dropping the zone of a link-local IPv6 address
+// ("fe80::1%eth0"). It returns nil if the address is not an IP.
+func parseClientIP(clientIP string) net.IP {
+	if i := strings.IndexByte(clientIP, '%'); i >= 0 {
+		clientIP = clientIP[:i]
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return nil
+	}
+	return normalizeIP(ip)
+}
+
 // isIPAllowed checks if a client IP is in the allowed list
 func isIPAllowed(clientIP string, allowedIPs []string) bool {
 	// Parse client IP
-	ip := net.ParseIP(clientIP)
+	ip := parseClientIP(clientIP)
 	if ip == nil {
 		return false
 	}
-	ip = normalizeIP(ip)
 
 	// Check against each allowed IP/subnet
 	for _, allowed := range allowedIPs {
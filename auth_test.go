@@ -2,8 +2,15 @@ package absnfs
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/absfs/memfs"
 )
@@ -218,12 +225,26 @@ func TestAuthenticationEnforcement(t *testing.T) {
 			Credential: &call.Credential,
 		}
 
+		// NULL is answered whatever the port, so clients can still ping
 		reply, err := handler.HandleCall(call, bytes.NewReader([]byte{}), authCtx)
 		if err != nil {
 			t.Fatalf("HandleCall failed: %v", err)
 		}
-		if reply.Status != MSG_DENIED {
-			t.Errorf("Expected MSG_DENIED for non-privileged port, got %v", reply.Status)
+		if reply.Status != MSG_ACCEPTED {
+			t.Errorf("Expected MSG_ACCEPTED for NULL from non-privileged port, got %v", reply.Status)
+		}
+
+		// Any other procedure is refused, over TCP and UDP alike
+		call.Header.Procedure = NFSPROC3_GETATTR
+		for _, udp := range []bool{false, true} {
+			authCtx.UDP = udp
+			reply, err = handler.HandleCall(call, bytes.NewReader([]byte{}), authCtx)
+			if err != nil {
+				t.Fatalf("HandleCall failed: %v", err)
+			}
+			if reply.Status != MSG_DENIED {
+				t.Errorf("Expected MSG_DENIED for non-privileged port (UDP=%v), got %v", udp, reply.Status)
+			}
 		}
 
 		// Client from privileged port
@@ -235,6 +256,64 @@ func TestAuthenticationEnforcement(t *testing.T) {
 		if reply.Status != MSG_ACCEPTED {
 			t.Errorf("Expected MSG_ACCEPTED for privileged port, got %v", reply.Status)
 		}
+
+		result := ValidateAuthentication(&AuthContext{ClientIP: "127.0.0.1", ClientPort: 1024, Credential: &call.Credential}, fs.policy.Load())
+		if result.Allowed || !strings.Contains(result.Reason, "non-reserved port") {
+			t.Errorf("Port 1024: allowed=%v reason %q, want a non-reserved port denial", result.Allowed, result.Reason)
+		}
+	})
+
+	t.Run("Reserved port required by default", func(t *testing.T) {
+		memfs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		fs, err := New(memfs, ExportOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		defer fs.Close()
+		if opts := fs.GetExportOptions(); opts.RequireReservedPort == nil || !*opts.RequireReservedPort {
+			t.Fatal("RequireReservedPort is not on by default")
+		}
+
+		cred := &RPCCredential{Flavor: AUTH_NONE, Body: []byte{}}
+		for _, udp := range []bool{false, true} {
+			authCtx := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 5000, UDP: udp, Credential: cred}
+			result := ValidateAuthentication(authCtx, fs.policy.Load())
+			if result.Allowed || result.Reason != "non-reserved port 5000" {
+				t.Errorf("UDP=%v: allowed=%v reason %q, want a non-reserved port denial", udp, result.Allowed, result.Reason)
+			}
+			authCtx.Ping = true
+			if result := ValidateAuthentication(authCtx, fs.policy.Load()); !result.Allowed {
+				t.Errorf("UDP=%v: NULL from port 5000 denied: %s", udp, result.Reason)
+			}
+		}
+
+		// Turned off, any port is accepted; an update leaving it nil keeps that
+		opts := fs.GetExportOptions()
+		anyPort := false
+		opts.RequireReservedPort = &anyPort
+		if err := fs.UpdateExportOptions(opts); err != nil {
+			t.Fatalf("UpdateExportOptions: %v", err)
+		}
+		opts.RequireReservedPort = nil
+		if err := fs.UpdateExportOptions(opts); err != nil {
+			t.Fatalf("UpdateExportOptions: %v", err)
+		}
+		authCtx := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 5000, Credential: cred}
+		if result := ValidateAuthentication(authCtx, fs.policy.Load()); !result.Allowed {
+			t.Errorf("port 5000 denied with RequireReservedPort off: %s", result.Reason)
+		}
+
+		// Secure still requires a reserved port on its own
+		opts.Secure = true
+		if err := fs.UpdateExportOptions(opts); err != nil {
+			t.Fatalf("UpdateExportOptions: %v", err)
+		}
+		if result := ValidateAuthentication(authCtx, fs.policy.Load()); result.Allowed {
+			t.Error("port 5000 allowed with Secure set")
+		}
 	})
 
 	t.Run("Root squashing applied", func(t *testing.T) {
@@ -444,6 +523,24 @@ func TestIsIPAllowed(t *testing.T) {
 			allowedIPs: []string{"::1"},
 			expected:   true,
 		},
+		{
+			name:       "IPv6 CIDR match",
+			clientIP:   "fd00::1:2",
+			allowedIPs: []string{"10.0.0.0/8", "fd00::/8"},
+			expected:   true,
+		},
+		{
+			name:       "IPv6 link-local with zone",
+			clientIP:   "fe80::1%eth0",
+			allowedIPs: []string{"fe80::/10"},
+			expected:   true,
+		},
+		{
+			name:       "IPv4-mapped IPv6 matches IPv4 CIDR",
+			clientIP:   "::ffff:192.168.1.7",
+			allowedIPs: []string{"192.168.1.0/24"},
+			expected:   true,
+		},
 		{
 			name:       "Invalid IP",
 			clientIP:   "invalid",
@@ -478,7 +575,7 @@ func TestM4_RootSquashBothUIDAndGID(t *testing.T) {
 		GID:     1000,
 	}
 
-	applySquashing(result, authSys, "root")
+	applySquashing(result, authSys, "root", anonIdentity(&PolicyOptions{}))
 
 	if result.UID != 65534 {
 		t.Errorf("Expected UID to be squashed to 65534, got %d", result.UID)
@@ -499,7 +596,7 @@ func TestM4_RootSquashBothUIDAndGID(t *testing.T) {
 		GID:     0,
 	}
 
-	applySquashing(result2, authSys2, "root")
+	applySquashing(result2, authSys2, "root", anonIdentity(&PolicyOptions{}))
 
 	if result2.UID != 1000 {
 		t.Errorf("Non-root UID should not be squashed, got %d", result2.UID)
@@ -548,7 +645,7 @@ func TestL9_AuxGIDsRootSquash(t *testing.T) {
 		GID:     0,
 	}
 
-	applySquashing(result, authSys, "root")
+	applySquashing(result, authSys, "root", anonIdentity(&PolicyOptions{}))
 
 	// Check that GID 0 entries in AuxGIDs are squashed
 	for i, gid := range authSys.AuxGIDs {
@@ -581,7 +678,7 @@ func TestL9_AuxGIDsRootSquash(t *testing.T) {
 		GID:     1000,
 	}
 
-	applySquashing(result2, authSys2, "root")
+	applySquashing(result2, authSys2, "root", anonIdentity(&PolicyOptions{}))
 
 	// GID 0 in aux list should still be squashed for root_squash
 	if authSys2.AuxGIDs[0] != 65534 {
@@ -610,7 +707,7 @@ func TestR26_RootSquashDoesNotMutateOriginalAuxGIDs(t *testing.T) {
 	copy(originalValues, sharedAuxGIDs)
 
 	result := &AuthResult{Allowed: true, UID: 0, GID: 0}
-	applySquashing(result, authSys1, "root")
+	applySquashing(result, authSys1, "root", anonIdentity(&PolicyOptions{}))
 
 	// Verify the original shared slice was NOT mutated
 	for i, v := range sharedAuxGIDs {
@@ -850,15 +947,16 @@ func TestR3_AccessPermissionChecking(t *testing.T) {
 		data := getReplyData(result)
 		accessResult := binary.BigEndian.Uint32(data[len(data)-4:])
 
-		// Root should get all permissions (permBits = 7)
+		// Root may read and write anything, but execute only what has an
+		// execute bit
 		if accessResult&ACCESS3_READ == 0 {
 			t.Error("Root should have READ access even on mode 0000")
 		}
 		if accessResult&ACCESS3_MODIFY == 0 {
 			t.Error("Root should have MODIFY access even on mode 0000")
 		}
-		if accessResult&ACCESS3_EXECUTE == 0 {
-			t.Error("Root should have EXECUTE access even on mode 0000")
+		if accessResult&ACCESS3_EXECUTE != 0 {
+			t.Error("Root should not have EXECUTE access on mode 0000")
 		}
 	})
 
@@ -1045,7 +1143,7 @@ func TestCovBoost_HandleCall_AuthDenied(t *testing.T) {
 			RPCVersion: 2,
 			Program:    NFS_PROGRAM,
 			Version:    NFS_V3,
-			Procedure:  NFSPROC3_NULL,
+			Procedure:  NFSPROC3_GETATTR,
 		},
 		Credential: RPCCredential{Flavor: AUTH_NONE, Body: []byte{}},
 		Verifier:   RPCVerifier{Flavor: 0, Body: []byte{}},
@@ -1149,7 +1247,7 @@ func TestAllSquashClearsAuxGIDs(t *testing.T) {
 		AuxGIDs: []uint32{50, 100, 200},
 	}
 	result := &AuthResult{Allowed: true, UID: 1000, GID: 1000}
-	applySquashing(result, authSys, "all")
+	applySquashing(result, authSys, "all", anonIdentity(&PolicyOptions{}))
 
 	if result.UID != 65534 {
 		t.Errorf("UID = %d, want 65534", result.UID)
@@ -1163,3 +1261,286 @@ func TestAllSquashClearsAuxGIDs(t *testing.T) {
 		}
 	}
 }
+
+// TestSquashUsesAnonIDs verifies that squashing and AUTH_NONE use the
+// export's AnonUID and AnonGID rather than a fixed nobody
+func TestSquashUsesAnonIDs(t *testing.T) {
+	policy := &PolicyOptions{Squash: "root", AnonUID: 1500, AnonGID: 1600}
+
+	authSys := &AuthSysCredential{UID: 0, GID: 0, AuxGIDs: []uint32{0, 10}}
+	ctx := &AuthContext{
+		ClientIP:   "127.0.0.1",
+		ClientPort: 1023,
+		Credential: &RPCCredential{Flavor: AUTH_SYS},
+		AuthSys:    authSys,
+	}
+	result := ValidateAuthentication(ctx, policy)
+	if !result.Allowed || result.UID != 1500 || result.GID != 1600 {
+		t.Errorf("root squashed to %d/%d (allowed %v), want 1500/1600", result.UID, result.GID, result.Allowed)
+	}
+	if aux := ctx.AuthSys.AuxGIDs; len(aux) != 2 || aux[0] != 1600 || aux[1] != 10 {
+		t.Errorf("auxiliary groups squashed to %v, want [1600 10]", aux)
+	}
+
+	ctx = &AuthContext{ClientIP: "127.0.0.1", ClientPort: 1023, Credential: &RPCCredential{Flavor: AUTH_NONE}}
+	if result := ValidateAuthentication(ctx, policy); result.UID != 1500 || result.GID != 1600 {
+		t.Errorf("AUTH_NONE ran as %d/%d, want 1500/1600", result.UID, result.GID)
+	}
+
+	// An export built from ExportOptions defaults to nobody
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nfs.Close()
+	if opts := nfs.GetExportOptions(); opts.AnonUID != 65534 || opts.AnonGID != 65534 {
+		t.Errorf("default anonymous IDs %d/%d, want 65534/65534", opts.AnonUID, opts.AnonGID)
+	}
+}
+
+// chownRecordingFS records the owner applied to each path via Chown
+type chownRecordingFS struct {
+	*memfs.FileSystem
+	mu     sync.Mutex
+	owners map[string][2]int
+}
+
+func (f *chownRecordingFS) Chown(name string, uid, gid int) error {
+	f.mu.Lock()
+	f.owners[name] = [2]int{uid, gid}
+	f.mu.Unlock()
+	return f.FileSystem.Chown(name, uid, gid)
+}
+
+func TestCertToIDFuncOverridesAuthSys(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := generateTestCertificate(t, "localhost", false)
+	clientCert, clientKey := generateTestCertificate(t, "alice", false)
+	saveCertToFile(t, serverCert, filepath.Join(dir, "server.crt"))
+	saveKeyToFile(t, serverKey, filepath.Join(dir, "server.key"))
+	// The self-signed client cert acts as its own trust root
+	saveCertToFile(t, clientCert, filepath.Join(dir, "ca.crt"))
+
+	tlsConfig := DefaultTLSConfig()
+	tlsConfig.Enabled = true
+	tlsConfig.CertFile = filepath.Join(dir, "server.crt")
+	tlsConfig.KeyFile = filepath.Join(dir, "server.key")
+	tlsConfig.CAFile = filepath.Join(dir, "ca.crt")
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs := &chownRecordingFS{FileSystem: mfs, owners: make(map[string][2]int)}
+	anyPort := false
+	nfs, err := New(fs, ExportOptions{
+		TLS:                 tlsConfig,
+		RequireReservedPort: &anyPort,
+		CertToIDFunc: func(cert *x509.Certificate) (uint32, uint32, bool) {
+			if cert.Subject.CommonName == "alice" {
+				return 4242, 4343, true
+			}
+			return 0, 0, false
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+
+	server, err := NewServer(ServerOptions{Port: 0, Hostname: "127.0.0.1", UseRecordMarking: true})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetHandler(nfs)
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", server.options.Port), &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{clientCert.Raw},
+			PrivateKey:  clientKey,
+		}},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("TLS dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// CREATE /owned.txt claiming to be uid/gid 1000 via AUTH_SYS
+	var cred bytes.Buffer
+	xdrEncodeUint32(&cred, 0)         // stamp
+	xdrEncodeString(&cred, "spoofer") // machine name
+	xdrEncodeUint32(&cred, 1000)      // uid
+	xdrEncodeUint32(&cred, 1000)      // gid
+	xdrEncodeUint32(&cred, 0)         // no aux gids
+	var msg bytes.Buffer
+	for _, v := range []uint32{7, RPC_CALL, 2, NFS_PROGRAM, NFS_V3, NFSPROC3_CREATE, AUTH_SYS, uint32(cred.Len())} {
+		xdrEncodeUint32(&msg, v)
+	}
+	msg.Write(cred.Bytes())
+	xdrEncodeUint32(&msg, AUTH_NONE) // verifier
+	xdrEncodeUint32(&msg, 0)
+	xdrEncodeFileHandle(&msg, getRootHandle(&Server{handler: nfs}))
+	xdrEncodeString(&msg, "owned.txt")
+	xdrEncodeUint32(&msg, 0) // UNCHECKED
+	for i := 0; i < 6; i++ {
+		xdrEncodeUint32(&msg, 0) // no sattr3 fields set
+	}
+
+	rm := NewRecordMarkingConn(conn, conn)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := rm.WriteRecord(msg.Bytes()); err != nil {
+		t.Fatalf("WriteRecord failed: %v", err)
+	}
+	replyData, err := rm.ReadRecord()
+	if err != nil {
+		t.Fatalf("ReadRecord failed: %v", err)
+	}
+	// xid, REPLY, MSG_ACCEPTED, verifier(flavor, len), accept_stat, nfsstat3
+	if len(replyData) < 28 {
+		t.Fatalf("Reply too short: %d bytes", len(replyData))
+	}
+	if status := binary.BigEndian.Uint32(replyData[24:28]); status != NFS_OK {
+		t.Fatalf("Expected NFS_OK from CREATE, got %d", status)
+	}
+
+	fs.mu.Lock()
+	owner, ok := fs.owners["/owned.txt"]
+	fs.mu.Unlock()
+	if !ok {
+		t.Fatal("Expected CREATE to set the file owner")
+	}
+	if owner != [2]int{4242, 4343} {
+		t.Errorf("Expected owner from certificate (4242:4343), got %d:%d", owner[0], owner[1])
+	}
+}
+
+func TestReplayedVerifierRejected(t *testing.T) {
+	_, handler, _ := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.ReplayWindow = time.Minute
+	})
+
+	// call sends NULL with a stub verifier standing in for a stronger
+	// flavor, whose verifiers are unique per call
+	call := func(verf RPCVerifier) *RPCReply {
+		t.Helper()
+		c := &RPCCall{
+			Header: RPCMsgHeader{
+				Xid: 1, MsgType: RPC_CALL, RPCVersion: 2,
+				Program: NFS_PROGRAM, Version: NFS_V3, Procedure: NFSPROC3_NULL,
+			},
+			Credential: RPCCredential{Flavor: AUTH_NONE, Body: []byte{}},
+			Verifier:   verf,
+		}
+		auth := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 1023, Credential: &c.Credential}
+		reply, err := handler.HandleCall(c, bytes.NewReader(nil), auth)
+		if err != nil {
+			t.Fatalf("HandleCall: %v", err)
+		}
+		return reply
+	}
+
+	first := RPCVerifier{Flavor: AUTH_DH, Body: []byte("nonce-0001")}
+	if reply := call(first); reply.Status != MSG_ACCEPTED {
+		t.Fatalf("Expected first verifier to be accepted, got status %d", reply.Status)
+	}
+	if reply := call(RPCVerifier{Flavor: AUTH_DH, Body: []byte("nonce-0002")}); reply.Status != MSG_ACCEPTED {
+		t.Errorf("Expected fresh verifier to be accepted, got status %d", reply.Status)
+	}
+
+	reply := call(first)
+	if reply.Status != MSG_DENIED || reply.AuthStat != AUTH_REJECTEDVERF {
+		t.Fatalf("Expected replay to be denied with AUTH_REJECTEDVERF, got status %d auth_stat %d", reply.Status, reply.AuthStat)
+	}
+	var buf bytes.Buffer
+	if err := EncodeRPCReply(&buf, reply); err != nil {
+		t.Fatalf("EncodeRPCReply: %v", err)
+	}
+	encoded := buf.Bytes()
+	if stat := binary.BigEndian.Uint32(encoded[len(encoded)-4:]); stat != AUTH_REJECTEDVERF {
+		t.Errorf("Expected encoded auth_stat %d, got %d", AUTH_REJECTEDVERF, stat)
+	}
+
+	// Null verifiers are identical on every call and never tracked
+	null := RPCVerifier{Flavor: AUTH_NONE, Body: []byte{}}
+	for i := 0; i < 2; i++ {
+		if reply := call(null); reply.Status != MSG_ACCEPTED {
+			t.Errorf("Expected null verifier call %d to be accepted, got status %d", i, reply.Status)
+		}
+	}
+}
+
+func TestReplayCacheBounded(t *testing.T) {
+	verf := func(i int) RPCVerifier {
+		return RPCVerifier{Flavor: AUTH_DH, Body: []byte(fmt.Sprintf("nonce-%d", i))}
+	}
+	start := time.Now()
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Microsecond) }
+
+	t.Run("per client", func(t *testing.T) {
+		var c replayCache
+		for i := 0; i <= maxReplayPerClient; i++ {
+			if c.replayed("10.0.0.1", verf(i), time.Hour, at(i)) {
+				t.Fatalf("Fresh verifier %d reported as replay", i)
+			}
+		}
+		if n := len(c.seen["10.0.0.1"]); n != maxReplayPerClient {
+			t.Errorf("Expected %d remembered verifiers, got %d", maxReplayPerClient, n)
+		}
+		if c.count != maxReplayPerClient {
+			t.Errorf("Expected count %d, got %d", maxReplayPerClient, c.count)
+		}
+		// The oldest verifier made room for the newest
+		if _, ok := c.seen["10.0.0.1"]["3:nonce-0"]; ok {
+			t.Error("Expected the oldest verifier to be forgotten")
+		}
+		if !c.replayed("10.0.0.1", verf(maxReplayPerClient), time.Hour, at(maxReplayPerClient+1)) {
+			t.Error("Expected the newest verifier to still be caught as a replay")
+		}
+	})
+
+	t.Run("total", func(t *testing.T) {
+		var c replayCache
+		for i := 0; i <= maxReplayEntries; i++ {
+			client := fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+			if c.replayed(client, verf(i), time.Hour, at(i)) {
+				t.Fatalf("Fresh verifier %d reported as replay", i)
+			}
+		}
+		if c.count != maxReplayEntries {
+			t.Errorf("Expected count %d, got %d", maxReplayEntries, c.count)
+		}
+		if n := len(c.seen); n != maxReplayEntries {
+			t.Errorf("Expected %d clients remembered, got %d", maxReplayEntries, n)
+		}
+		if _, ok := c.seen["10.0.0.0"]; ok {
+			t.Error("Expected the oldest client's verifier to be forgotten")
+		}
+		if len(c.order) > 2*maxReplayEntries {
+			t.Errorf("Expected order to stay within %d entries, got %d", 2*maxReplayEntries, len(c.order))
+		}
+	})
+
+	t.Run("expired entries dropped", func(t *testing.T) {
+		var c replayCache
+		for i := 0; i < 10; i++ {
+			c.replayed("10.0.0.1", verf(i), time.Second, start)
+		}
+		c.replayed("10.0.0.2", verf(0), time.Second, start.Add(2*time.Second))
+		if c.count != 1 || len(c.order) != 1 {
+			t.Errorf("Expected only the fresh verifier to remain, got count %d order %d", c.count, len(c.order))
+		}
+		if _, ok := c.seen["10.0.0.1"]; ok {
+			t.Error("Expected the quiet client to be forgotten")
+		}
+	})
+}
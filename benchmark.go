@@ -0,0 +1,161 @@
+// benchmark.go: Backing filesystem self-benchmark.
+//
+// Benchmark measures what the backing filesystem can deliver on its own,
+// bypassing the network and the NFS caches: sequential write and read
+// throughput on a scratch file, and metadata lookup rate. Comparing the
+// result with client-observed throughput shows whether the backing store
+// or the NFS path is the bottleneck. All scratch files live in a temporary
+// directory that is removed before Benchmark returns.
+package absnfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// BenchmarkOptions configures Benchmark. Zero values select the defaults.
+type BenchmarkOptions struct {
+	// Dir is the directory in which the scratch directory is created.
+	// Default: "/"
+	Dir string
+
+	// FileSize is the number of bytes written and then read back.
+	// Default: 16MB
+	FileSize int64
+
+	// BlockSize is the size of each write and read call.
+	// Default: 64KB
+	BlockSize int
+
+	// Lookups is the number of Stat calls made for the lookup benchmark,
+	// spread over a small set of scratch files.
+	// Default: 10000
+	Lookups int
+}
+
+// BenchmarkResult reports the throughput measured by Benchmark
+type BenchmarkResult struct {
+	BytesWritten  int64
+	BytesRead     int64
+	Lookups       int
+	WriteDuration time.Duration
+	ReadDuration  time.Duration
+	LookupTime    time.Duration
+
+	WriteMBPerSec   float64
+	ReadMBPerSec    float64
+	LookupOpsPerSec float64
+}
+
+// benchmarkLookupFiles is the number of files the lookup benchmark cycles over
+const benchmarkLookupFiles = 16
+
+// Benchmark runs a short read/write/lookup microbenchmark directly against
+// the backing filesystem and reports the achievable throughput. It writes
+// to the backing filesystem even if the export is read-only.
+func (n *AbsfsNFS) Benchmark(opts BenchmarkOptions) (BenchmarkResult, error) {
+	if opts.Dir == "" {
+		opts.Dir = "/"
+	}
+	if opts.FileSize <= 0 {
+		opts.FileSize = 16 * 1024 * 1024
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 64 * 1024
+	}
+	if opts.Lookups <= 0 {
+		opts.Lookups = 10000
+	}
+
+	var result BenchmarkResult
+	dir := path.Join(opts.Dir, fmt.Sprintf(".absnfs-bench-%d", time.Now().UnixNano()))
+	if err := n.fs.Mkdir(dir, 0700); err != nil {
+		return result, fmt.Errorf("benchmark: create scratch dir: %w", err)
+	}
+	defer func() {
+		if err := n.fs.RemoveAll(dir); err != nil {
+			n.logger.Printf("benchmark: failed to remove %s: %v", dir, err)
+		}
+	}()
+
+	file := path.Join(dir, "data")
+	block := make([]byte, opts.BlockSize)
+	for i := range block {
+		block[i] = byte(i)
+	}
+
+	// Sequential write, synced so the time includes reaching the store
+	start := time.Now()
+	f, err := n.fs.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return result, fmt.Errorf("benchmark: create: %w", err)
+	}
+	for result.BytesWritten < opts.FileSize {
+		chunk := block
+		if remaining := opts.FileSize - result.BytesWritten; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		written, err := f.Write(chunk)
+		result.BytesWritten += int64(written)
+		if err != nil {
+			f.Close()
+			return result, fmt.Errorf("benchmark: write: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return result, fmt.Errorf("benchmark: sync: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return result, fmt.Errorf("benchmark: close: %w", err)
+	}
+	result.WriteDuration = time.Since(start)
+
+	// Sequential read of the same file
+	start = time.Now()
+	f, err = n.fs.OpenFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return result, fmt.Errorf("benchmark: open: %w", err)
+	}
+	for {
+		read, err := f.Read(block)
+		result.BytesRead += int64(read)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return result, fmt.Errorf("benchmark: read: %w", err)
+		}
+	}
+	f.Close()
+	result.ReadDuration = time.Since(start)
+
+	// Metadata lookups over a handful of small files
+	names := make([]string, benchmarkLookupFiles)
+	for i := range names {
+		names[i] = path.Join(dir, fmt.Sprintf("lookup-%d", i))
+		f, err := n.fs.OpenFile(names[i], os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return result, fmt.Errorf("benchmark: create: %w", err)
+		}
+		f.Close()
+	}
+	start = time.Now()
+	for i := 0; i < opts.Lookups; i++ {
+		if _, err := n.fs.Stat(names[i%len(names)]); err != nil {
+			return result, fmt.Errorf("benchmark: stat: %w", err)
+		}
+		result.Lookups++
+	}
+	result.LookupTime = time.Since(start)
+
+	const mb = 1024 * 1024
+	result.WriteMBPerSec = float64(result.BytesWritten) / mb / result.WriteDuration.Seconds()
+	result.ReadMBPerSec = float64(result.BytesRead) / mb / result.ReadDuration.Seconds()
+	result.LookupOpsPerSec = float64(result.Lookups) / result.LookupTime.Seconds()
+	return result, nil
+}
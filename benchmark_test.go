@@ -0,0 +1,48 @@
+package absnfs
+
+import (
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestBenchmark(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+
+	result, err := nfs.Benchmark(BenchmarkOptions{FileSize: 1 << 20, BlockSize: 4096, Lookups: 500})
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if result.BytesWritten != 1<<20 || result.BytesRead != 1<<20 {
+		t.Errorf("Expected 1MB written and read, got %d and %d", result.BytesWritten, result.BytesRead)
+	}
+	if result.Lookups != 500 {
+		t.Errorf("Expected 500 lookups, got %d", result.Lookups)
+	}
+	if result.WriteMBPerSec <= 0 || result.ReadMBPerSec <= 0 || result.LookupOpsPerSec <= 0 {
+		t.Errorf("Expected non-zero throughput, got %+v", result)
+	}
+
+	root, err := mfs.Open("/")
+	if err != nil {
+		t.Fatalf("Failed to open root: %v", err)
+	}
+	defer root.Close()
+	names, err := root.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames failed: %v", err)
+	}
+	for _, name := range names {
+		if name != "." && name != ".." {
+			t.Errorf("Benchmark left %q behind", name)
+		}
+	}
+}
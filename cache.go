@@ -7,12 +7,31 @@ package absnfs
 
 import (
 	"container/list"
+	"fmt"
 	"os"
+	"path"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// CacheConsistency values, for how far LOOKUP and GETATTR trust the
+// attribute cache
+const (
+	CacheConsistencyTTL         = "ttl"           // Trust entries until AttrCacheTimeout
+	CacheConsistencyCloseToOpen = "close-to-open" // LOOKUP revalidates mtime and size
+	CacheConsistencyStrict      = "strict"        // Never answer from the cache
+)
+
+// validateCacheConsistency checks a CacheConsistency value
+func validateCacheConsistency(mode string) error {
+	switch mode {
+	case "", CacheConsistencyTTL, CacheConsistencyCloseToOpen, CacheConsistencyStrict:
+		return nil
+	}
+	return fmt.Errorf("invalid CacheConsistency %q: must be ttl, close-to-open, strict, or empty", mode)
+}
+
 // AttrCache provides caching for file attributes and negative lookups
 type AttrCache struct {
 	mu             sync.RWMutex
@@ -22,6 +41,22 @@ type AttrCache struct {
 	maxSize        int           // Maximum number of entries in the cache
 	accessList     *list.List    // Doubly-linked list for O(1) LRU tracking
 	enableNegative bool          // Enable negative caching
+
+	// negativeDirs holds each directory's negative entries, oldest first,
+	// so one directory can hold at most maxNegativePerDir of them
+	negativeDirs      map[string]*list.List
+	maxNegativePerDir int
+
+	// byHandle finds the entries GETATTR has bound to a file handle, for
+	// HandleAttrCache. An entry leaves it whenever it leaves cache.
+	byHandle map[uint64]*CachedAttrs
+
+	peak int // Most entries held since cache was built; see Compact
+
+	// Lookup and eviction counts for CacheStats; hits and evictions are
+	// kept apart for positive and negative entries
+	hits, negativeHits, misses   uint64
+	evictions, negativeEvictions uint64
 }
 
 // CachedAttrs represents cached file attributes with expiration
@@ -31,6 +66,8 @@ type CachedAttrs struct {
 	expireAt    time.Time
 	listElement *list.Element // Reference to position in LRU list for O(1) access
 	isNegative  bool          // True if this is a negative cache entry
+	dirElement  *list.Element // Position in its directory's negative list
+	handle      uint64        // File handle bound by BindHandle, or 0
 }
 
 // NewAttrCache creates a new attribute cache with the specified TTL and maximum size
@@ -46,6 +83,8 @@ func NewAttrCache(ttl time.Duration, maxSize int) *AttrCache {
 		maxSize:        maxSize,
 		accessList:     list.New(),
 		enableNegative: false, // Disabled by default
+		negativeDirs:   make(map[string]*list.List),
+		byHandle:       make(map[uint64]*CachedAttrs),
 	}
 }
 
@@ -60,6 +99,24 @@ func (c *AttrCache) ConfigureNegativeCaching(enable bool, ttl time.Duration) {
 	}
 }
 
+// SetNegativeDirLimit caps the negative entries kept for any one directory;
+// past it, the directory's oldest negative entries are evicted first. A
+// limit of 0 removes the cap.
+func (c *AttrCache) SetNegativeDirLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxNegativePerDir = limit
+	if limit <= 0 {
+		return
+	}
+	for _, entries := range c.negativeDirs {
+		for entries.Len() > limit {
+			c.evictLocked(entries.Front().Value.(string))
+		}
+	}
+}
+
 // Get retrieves cached attributes if they exist and are not expired.
 // Returns:
 //   - (attrs, true) = positive cache hit (attrs found)
@@ -84,6 +141,7 @@ func (c *AttrCache) Get(path string, server ...*AbsfsNFS) (*NFSAttrs, bool) {
 				c.updateAccessLog(path)
 			}
 			c.mu.Unlock()
+			atomic.AddUint64(&c.negativeHits, 1)
 
 			// Record negative cache hit for metrics
 			if s != nil {
@@ -101,15 +159,7 @@ func (c *AttrCache) Get(path string, server ...*AbsfsNFS) (*NFSAttrs, bool) {
 		}
 
 		// Copy attributes while holding RLock to prevent data races
-		attrs := &NFSAttrs{
-			Mode:   cached.attrs.Mode,
-			Size:   cached.attrs.Size,
-			FileId: cached.attrs.FileId,
-			Uid:    cached.attrs.Uid,
-			Gid:    cached.attrs.Gid,
-		}
-		attrs.SetMtime(cached.attrs.Mtime())
-		attrs.SetAtime(cached.attrs.Atime())
+		attrs := copyAttrs(cached.attrs)
 		c.mu.RUnlock()
 
 		// Update access log (LRU tracking)
@@ -120,6 +170,7 @@ func (c *AttrCache) Get(path string, server ...*AbsfsNFS) (*NFSAttrs, bool) {
 			c.updateAccessLog(path)
 		}
 		c.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
 
 		// Record cache hit for metrics
 		if s != nil {
@@ -135,6 +186,7 @@ func (c *AttrCache) Get(path string, server ...*AbsfsNFS) (*NFSAttrs, bool) {
 		return attrs, true
 	}
 	c.mu.RUnlock()
+	atomic.AddUint64(&c.misses, 1)
 
 	// Record cache miss for metrics
 	if s != nil {
@@ -151,14 +203,64 @@ func (c *AttrCache) Get(path string, server ...*AbsfsNFS) (*NFSAttrs, bool) {
 		// Expired entry, remove it with re-check after lock upgrade
 		c.mu.Lock()
 		if entry, exists := c.cache[path]; exists && time.Now().After(entry.expireAt) {
-			c.removeFromAccessLog(path)
-			delete(c.cache, path)
+			c.deleteLocked(path)
 		}
 		c.mu.Unlock()
 	}
 	return nil, false
 }
 
+// GetByHandle returns the unexpired attributes bound to handle by
+// BindHandle, without resolving the path they belong to
+func (c *AttrCache) GetByHandle(handle uint64) (*NFSAttrs, bool) {
+	c.mu.RLock()
+	cached, ok := c.byHandle[handle]
+	if !ok || !time.Now().Before(cached.expireAt) {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	attrs := copyAttrs(cached.attrs)
+	c.mu.RUnlock()
+	return attrs, true
+}
+
+// BindHandle makes the cached attributes of path, if any, answer to
+// GetByHandle(handle) for as long as they stay cached
+func (c *AttrCache) BindHandle(handle uint64, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.cache[path]
+	if !ok || cached.isNegative || cached.handle == handle {
+		return
+	}
+	if old, bound := c.byHandle[handle]; bound {
+		old.handle = 0
+	}
+	if cached.handle != 0 {
+		delete(c.byHandle, cached.handle)
+	}
+	cached.handle = handle
+	c.byHandle[handle] = cached
+}
+
+// copyAttrs returns a copy of cached attributes, so callers cannot modify
+// the cache's own
+func copyAttrs(a *NFSAttrs) *NFSAttrs {
+	attrs := &NFSAttrs{
+		Mode:      a.Mode,
+		Size:      a.Size,
+		FileId:    a.FileId,
+		RdevMajor: a.RdevMajor,
+		RdevMinor: a.RdevMinor,
+		Uid:       a.Uid,
+		Gid:       a.Gid,
+	}
+	attrs.SetMtime(a.Mtime())
+	attrs.SetAtime(a.Atime())
+	return attrs
+}
+
 // updateAccessLog moves the path to the front of the access list (most recently used)
 // This is now O(1) using doubly-linked list operations
 func (c *AttrCache) updateAccessLog(path string) {
@@ -189,11 +291,86 @@ func (c *AttrCache) removeFromAccessLog(path string) {
 	cached.listElement = nil
 }
 
+// linkNegative appends a new negative entry to its directory's list,
+// first evicting the directory's oldest negative entries to stay within
+// maxNegativePerDir
+func (c *AttrCache) linkNegative(p string) *list.Element {
+	dir := path.Dir(p)
+	if c.maxNegativePerDir > 0 {
+		for entries := c.negativeDirs[dir]; entries != nil && entries.Len() >= c.maxNegativePerDir; {
+			c.evictLocked(entries.Front().Value.(string))
+			entries = c.negativeDirs[dir]
+		}
+	}
+	entries := c.negativeDirs[dir]
+	if entries == nil {
+		entries = list.New()
+		c.negativeDirs[dir] = entries
+	}
+	return entries.PushBack(p)
+}
+
+// unlinkNegative removes a negative entry from its directory's list
+func (c *AttrCache) unlinkNegative(p string) {
+	cached, ok := c.cache[p]
+	if !ok || cached.dirElement == nil {
+		return
+	}
+	dir := path.Dir(p)
+	entries := c.negativeDirs[dir]
+	entries.Remove(cached.dirElement)
+	cached.dirElement = nil
+	if entries.Len() == 0 {
+		delete(c.negativeDirs, dir)
+	}
+}
+
+// deleteLocked removes an entry from the cache and its lists; the caller
+// holds c.mu
+func (c *AttrCache) deleteLocked(p string) {
+	c.unlinkNegative(p)
+	c.removeFromAccessLog(p)
+	if cached, ok := c.cache[p]; ok && cached.handle != 0 {
+		delete(c.byHandle, cached.handle)
+	}
+	delete(c.cache, p)
+}
+
+// evictLocked deletes an entry to make room, counting it as an eviction;
+// the caller holds c.mu
+func (c *AttrCache) evictLocked(p string) {
+	if cached, ok := c.cache[p]; ok && cached.isNegative {
+		atomic.AddUint64(&c.negativeEvictions, 1)
+	} else {
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	c.deleteLocked(p)
+}
+
 // Put adds or updates cached attributes
 func (c *AttrCache) Put(path string, attrs *NFSAttrs) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.putLocked(path, attrs, time.Now())
+}
+
+// PutBatch adds or updates the cached attributes of every entry under a
+// single lock acquisition, as READDIRPLUS does when it caches a whole
+// directory. Entries beyond the cache's size evict as with Put.
+func (c *AttrCache) PutBatch(entries map[string]*NFSAttrs) {
+	if len(entries) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for path, attrs := range entries {
+		c.putLocked(path, attrs, now)
+	}
+}
 
+// putLocked stores attrs for path; the caller holds c.mu
+func (c *AttrCache) putLocked(path string, attrs *NFSAttrs, now time.Time) {
 	// Check if entry already exists
 	existing, exists := c.cache[path]
 
@@ -204,38 +381,33 @@ func (c *AttrCache) Put(path string, attrs *NFSAttrs) {
 			// Get LRU element from back of list - O(1)
 			lruElement := c.accessList.Back()
 			if lruElement != nil {
-				lruPath, ok := lruElement.Value.(string)
-				if ok {
-					delete(c.cache, lruPath)
-				}
-				c.accessList.Remove(lruElement) // O(1)
+				c.evictLocked(lruElement.Value.(string)) // O(1)
 			}
 		}
 	}
 
-	// Deep copy the attributes to prevent modification
-	attrsCopy := &NFSAttrs{
-		Mode:   attrs.Mode,
-		Size:   attrs.Size,
-		FileId: attrs.FileId,
-		Uid:    attrs.Uid,
-		Gid:    attrs.Gid,
-	}
-	attrsCopy.SetMtime(attrs.Mtime())
-	attrsCopy.SetAtime(attrs.Atime())
-
-	// Preserve the listElement reference when updating existing entry
+	// Preserve the listElement reference and handle binding when updating
+	// existing entry
 	var listElem *list.Element
+	var handle uint64
 	if exists && existing != nil {
 		listElem = existing.listElement
+		handle = existing.handle
+		c.unlinkNegative(path)
 	}
 
-	c.cache[path] = &CachedAttrs{
-		attrs:       attrsCopy,
-		expireAt:    time.Now().Add(c.ttl),
+	entry := &CachedAttrs{
+		attrs:       copyAttrs(attrs),
+		expireAt:    now.Add(c.ttl),
 		listElement: listElem,
 		isNegative:  false,
+		handle:      handle,
+	}
+	c.cache[path] = entry
+	if handle != 0 {
+		c.byHandle[handle] = entry
 	}
+	c.peak = max(c.peak, len(c.cache))
 
 	// Update access log to mark this as most recently used - O(1)
 	c.updateAccessLog(path)
@@ -266,19 +438,24 @@ func (c *AttrCache) PutNegative(path string) {
 			// Get LRU element from back of list - O(1)
 			lruElement := c.accessList.Back()
 			if lruElement != nil {
-				lruPath, ok := lruElement.Value.(string)
-				if ok {
-					delete(c.cache, lruPath)
-				}
-				c.accessList.Remove(lruElement) // O(1)
+				c.evictLocked(lruElement.Value.(string)) // O(1)
 			}
 		}
 	}
 
-	// Preserve the listElement reference when updating existing entry
-	var listElem *list.Element
+	// Preserve the listElement and dirElement references when updating
+	// existing entry
+	var listElem, dirElem *list.Element
 	if exists && existing != nil {
 		listElem = existing.listElement
+		dirElem = existing.dirElement
+		// The path no longer exists, so neither does a handle's file
+		if existing.handle != 0 {
+			delete(c.byHandle, existing.handle)
+		}
+	}
+	if dirElem == nil {
+		dirElem = c.linkNegative(path)
 	}
 
 	c.cache[path] = &CachedAttrs{
@@ -286,7 +463,9 @@ func (c *AttrCache) PutNegative(path string) {
 		expireAt:    time.Now().Add(negativeTTL),
 		listElement: listElem,
 		isNegative:  true,
+		dirElement:  dirElem,
 	}
+	c.peak = max(c.peak, len(c.cache))
 
 	// Update access log to mark this as most recently used - O(1)
 	c.updateAccessLog(path)
@@ -297,8 +476,7 @@ func (c *AttrCache) Invalidate(path string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.removeFromAccessLog(path)
-	delete(c.cache, path)
+	c.deleteLocked(path)
 }
 
 // Clear removes all entries from the cache
@@ -308,6 +486,9 @@ func (c *AttrCache) Clear() {
 
 	c.cache = make(map[string]*CachedAttrs)
 	c.accessList = list.New()
+	c.negativeDirs = make(map[string]*list.List)
+	c.byHandle = make(map[uint64]*CachedAttrs)
+	c.peak = 0
 }
 
 // Size returns the current number of entries in the cache
@@ -348,6 +529,31 @@ func (c *AttrCache) NegativeStats() int {
 	return count
 }
 
+// cacheStats returns the counters of the positive and negative entries.
+// Misses are counted once, under the positive entries, since a miss finds
+// neither kind.
+func (c *AttrCache) cacheStats() (attrs, negative CacheCounters) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, cached := range c.cache {
+		if cached.isNegative {
+			negative.Entries++
+		}
+	}
+	attrs = CacheCounters{
+		Entries:   len(c.cache) - negative.Entries,
+		Limit:     c.maxSize,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+	negative.Limit = c.maxNegativePerDir
+	negative.Hits = atomic.LoadUint64(&c.negativeHits)
+	negative.Evictions = atomic.LoadUint64(&c.negativeEvictions)
+	return attrs, negative
+}
+
 // InvalidateNegativeInDir invalidates all negative cache entries in a directory
 // This is called when a file is created in the directory
 func (c *AttrCache) InvalidateNegativeInDir(dirPath string) {
@@ -363,11 +569,8 @@ func (c *AttrCache) InvalidateNegativeInDir(dirPath string) {
 	}
 
 	// Delete the negative entries
-	// Remove from access log BEFORE deleting from cache, because
-	// removeFromAccessLog looks up the entry in c.cache to find the list element.
 	for _, path := range toDelete {
-		c.removeFromAccessLog(path)
-		delete(c.cache, path)
+		c.deleteLocked(path)
 	}
 }
 
@@ -433,11 +636,7 @@ func (c *AttrCache) Resize(newSize int) {
 		if lruElement == nil {
 			break
 		}
-		lruPath, ok := lruElement.Value.(string)
-		if ok {
-			delete(c.cache, lruPath)
-		}
-		c.accessList.Remove(lruElement)
+		c.evictLocked(lruElement.Value.(string))
 	}
 }
 
@@ -464,11 +663,14 @@ type DirCache struct {
 	maxDirSize int
 	hits       uint64
 	misses     uint64
+	evictions  uint64
+	peak       int // Most entries held since entries was built; see Compact
 }
 
 // CachedDirEntry represents cached directory entries with expiration
 type CachedDirEntry struct {
 	entries     []os.FileInfo
+	dirMtime    time.Time // directory mtime when listed (zero if unknown)
 	validUntil  time.Time
 	listElement *list.Element
 }
@@ -496,6 +698,16 @@ func NewDirCache(timeout time.Duration, maxEntries int, maxDirSize int) *DirCach
 
 // Get retrieves cached directory entries if they exist and are not expired
 func (c *DirCache) Get(path string) ([]os.FileInfo, bool) {
+	return c.get(path, nil)
+}
+
+// GetIfUnchanged is like Get but also treats the entry as stale, and drops
+// it, unless it was stored with exactly the given directory mtime
+func (c *DirCache) GetIfUnchanged(path string, dirMtime time.Time) ([]os.FileInfo, bool) {
+	return c.get(path, &dirMtime)
+}
+
+func (c *DirCache) get(path string, dirMtime *time.Time) ([]os.FileInfo, bool) {
 	c.mu.RLock()
 	cached, ok := c.entries[path]
 	if !ok {
@@ -504,14 +716,18 @@ func (c *DirCache) Get(path string) ([]os.FileInfo, bool) {
 		return nil, false
 	}
 
-	// Check if expired
-	if time.Now().After(cached.validUntil) {
+	stale := func(e *CachedDirEntry) bool {
+		return time.Now().After(e.validUntil) || (dirMtime != nil && !e.dirMtime.Equal(*dirMtime))
+	}
+
+	// Check if expired or modified since it was listed
+	if stale(cached) {
 		c.mu.RUnlock()
 		atomic.AddUint64(&c.misses, 1)
 
-		// Remove expired entry with re-check after lock upgrade
+		// Remove stale entry with re-check after lock upgrade
 		c.mu.Lock()
-		if entry, exists := c.entries[path]; exists && time.Now().After(entry.validUntil) {
+		if entry, exists := c.entries[path]; exists && stale(entry) {
 			c.removeFromAccessList(path)
 			delete(c.entries, path)
 		}
@@ -539,6 +755,12 @@ func (c *DirCache) Get(path string) ([]os.FileInfo, bool) {
 
 // Put adds or updates cached directory entries
 func (c *DirCache) Put(path string, entries []os.FileInfo) {
+	c.PutWithMtime(path, entries, time.Time{})
+}
+
+// PutWithMtime adds or updates cached directory entries, recording the
+// directory mtime observed before the listing was read
+func (c *DirCache) PutWithMtime(path string, entries []os.FileInfo, dirMtime time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -561,6 +783,7 @@ func (c *DirCache) Put(path string, entries []os.FileInfo) {
 					delete(c.entries, lruPath)
 				}
 				c.accessList.Remove(lruElement)
+				atomic.AddUint64(&c.evictions, 1)
 			}
 		}
 	}
@@ -577,9 +800,11 @@ func (c *DirCache) Put(path string, entries []os.FileInfo) {
 
 	c.entries[path] = &CachedDirEntry{
 		entries:     entriesCopy,
+		dirMtime:    dirMtime,
 		validUntil:  time.Now().Add(c.timeout),
 		listElement: listElem,
 	}
+	c.peak = max(c.peak, len(c.entries))
 
 	// Update access log to mark this as most recently used
 	c.updateAccessLog(path)
@@ -626,6 +851,7 @@ func (c *DirCache) Clear() {
 
 	c.entries = make(map[string]*CachedDirEntry)
 	c.accessList = list.New()
+	c.peak = 0
 }
 
 // Size returns the current number of entries in the cache
@@ -644,6 +870,20 @@ func (c *DirCache) Stats() (int, int64, int64) {
 	return len(c.entries), int64(atomic.LoadUint64(&c.hits)), int64(atomic.LoadUint64(&c.misses))
 }
 
+// cacheStats returns the cache's counters for CacheStats
+func (c *DirCache) cacheStats() CacheCounters {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return CacheCounters{
+		Entries:   len(c.entries),
+		Limit:     c.maxEntries,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
 // Resize changes the maximum number of entries in the directory cache
 // If the new size is smaller than current entries, LRU entries will be evicted
 func (c *DirCache) Resize(newMaxEntries int) {
@@ -672,6 +912,7 @@ func (c *DirCache) Resize(newMaxEntries int) {
 			delete(c.entries, lruPath)
 		}
 		c.accessList.Remove(lruElement)
+		atomic.AddUint64(&c.evictions, 1)
 	}
 }
 
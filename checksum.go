@@ -0,0 +1,56 @@
+// checksum.go: Read repair for backing filesystems that keep checksums.
+//
+// When the backing filesystem implements ChecksumVerifier, every READ
+// checks the data it got against the stored checksums. Data that fails is
+// read once more, in case the corruption was transient (a bad cache line,
+// a flaky replica), and the retry is counted in ReadRepairs. If the second
+// copy fails as well, the READ fails with NFSERR_IO rather than hand the
+// client corrupt data. Filesystems without checksums are read as before.
+package absnfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/absfs/absfs"
+)
+
+// ErrChecksumMismatch is wrapped by read errors for data that failed the
+// backing filesystem's checksums on both the read and its retry
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumVerifier is implemented by backing filesystems that keep
+// checksums of file contents
+type ChecksumVerifier interface {
+	// VerifyChecksum returns nil if data, read from the file at path
+	// starting at offset, matches the stored checksums, and an error
+	// describing the mismatch otherwise
+	VerifyChecksum(path string, offset int64, data []byte) error
+}
+
+// verifyRead checks the data read into buf from offset against the
+// checksums of cv, reading the range once more from f if it fails them
+func (s *AbsfsNFS) verifyRead(cv ChecksumVerifier, f absfs.File, path string, buf []byte, offset int64) ([]byte, error) {
+	mismatch := cv.VerifyChecksum(path, offset, buf)
+	if mismatch == nil {
+		return buf, nil
+	}
+
+	s.RecordReadRepair()
+	if slog := s.getStructuredLogger(); slog != nil {
+		slog.Warn("READ: checksum mismatch, re-reading",
+			LogField{Key: "path", Value: path},
+			LogField{Key: "offset", Value: offset},
+			LogField{Key: "error", Value: mismatch})
+	}
+
+	n, err := readAtRetryEINTR(f, buf[:cap(buf)], offset)
+	if err != nil && err != io.EOF {
+		return nil, opError("read", path, fmt.Errorf("re-read at offset %d: %w", offset, err))
+	}
+	if mismatch := cv.VerifyChecksum(path, offset, buf[:n]); mismatch != nil {
+		return nil, opError("read", path, fmt.Errorf("at offset %d: %w: %v", offset, ErrChecksumMismatch, mismatch))
+	}
+	return buf[:n], nil
+}
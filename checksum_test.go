@@ -0,0 +1,98 @@
+package absnfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// corruptingFS hands out corrupt data for its first corruptReads reads
+// and checks reads against the content it was created with
+type corruptingFS struct {
+	*memfs.FileSystem
+	content      []byte
+	corruptReads atomic.Int32
+}
+
+type corruptingFile struct {
+	absfs.File
+	fs *corruptingFS
+}
+
+func (f *corruptingFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &corruptingFile{File: file, fs: f}, nil
+}
+
+func (f *corruptingFS) VerifyChecksum(path string, offset int64, data []byte) error {
+	if !bytes.Equal(data, f.content[offset:offset+int64(len(data))]) {
+		return fmt.Errorf("block at %d does not match", offset)
+	}
+	return nil
+}
+
+func (f *corruptingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	if f.fs.corruptReads.Add(-1) >= 0 && n > 0 {
+		p[0] ^= 0xff
+	}
+	return n, err
+}
+
+func TestReadRepair(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("0123456789abcdef")
+	writeTestFile(t, mfs, "/data.txt", string(content))
+	fs := &corruptingFS{FileSystem: mfs, content: content}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer nfs.Close()
+	node, err := nfs.Lookup("/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Good data needs no repair
+	if data, err := nfs.Read(node, 2, 8); err != nil || string(data) != "23456789" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+	if got := nfs.GetMetrics().ReadRepairs; got != 0 {
+		t.Errorf("ReadRepairs = %d after a good read, want 0", got)
+	}
+
+	// Corrupt data is re-read and the good copy returned
+	fs.corruptReads.Store(1)
+	if data, err := nfs.Read(node, 2, 8); err != nil || string(data) != "23456789" {
+		t.Fatalf("Read after one corrupt copy = %q, %v; want the repaired data", data, err)
+	}
+	if got := nfs.GetMetrics().ReadRepairs; got != 1 {
+		t.Errorf("ReadRepairs = %d, want 1", got)
+	}
+
+	// Data corrupt on the retry too is an I/O error
+	fs.corruptReads.Store(2)
+	data, err := nfs.Read(node, 0, 16)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Read of persistently corrupt data = %q, %v; want ErrChecksumMismatch", data, err)
+	}
+	if status := MapErrorToNFSStatus(err); status != NFSERR_IO {
+		t.Errorf("checksum failure maps to %d, want NFSERR_IO", status)
+	}
+	if got := nfs.GetMetrics().ReadRepairs; got != 2 {
+		t.Errorf("ReadRepairs = %d, want 2", got)
+	}
+}
@@ -0,0 +1,110 @@
+package absnfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestMatchClientRule(t *testing.T) {
+	rules := []ClientRule{
+		{Host: "*", ReadOnly: true},
+		{Host: "10.0.0.0/8", ReadOnly: false},
+		{Host: "10.1.0.0/16", ReadOnly: true},
+		{Host: "10.1.2.3", ReadOnly: false, Squash: "all"},
+		{Host: "10.1.0.0/16", ReadOnly: false},
+	}
+	tests := []struct {
+		ip   string
+		want int // index into rules
+	}{
+		{"192.168.1.1", 0},
+		{"10.9.9.9", 1},
+		{"10.1.9.9", 2}, // the first of two equally specific rules
+		{"10.1.2.3", 3},
+		{"::ffff:10.1.2.3", 3},
+	}
+	for _, tt := range tests {
+		if got := matchClientRule(rules, tt.ip); got != &rules[tt.want] {
+			t.Errorf("matchClientRule(%s) = %+v, want %+v", tt.ip, got, rules[tt.want])
+		}
+	}
+	if got := matchClientRule(rules[1:], "192.168.1.1"); got != nil {
+		t.Errorf("matchClientRule with no covering rule = %+v, want nil", got)
+	}
+}
+
+func TestClientRuleSquash(t *testing.T) {
+	policy := &PolicyOptions{
+		Squash:      "none",
+		ClientRules: []ClientRule{{Host: "10.0.0.0/8", Squash: "root"}},
+	}
+	authenticate := func(ip string) *AuthResult {
+		return ValidateAuthentication(&AuthContext{
+			ClientIP:   ip,
+			Credential: &RPCCredential{Flavor: AUTH_SYS},
+			AuthSys:    &AuthSysCredential{UID: 0, GID: 0},
+		}, policy)
+	}
+
+	if result := authenticate("10.2.3.4"); result.UID != 65534 || result.Rule != &policy.ClientRules[0] {
+		t.Errorf("client covered by root-squash rule: UID %d, rule %v; want 65534 and the rule", result.UID, result.Rule)
+	}
+	if result := authenticate("192.168.1.1"); result.UID != 0 || result.Rule != nil {
+		t.Errorf("uncovered client: UID %d, rule %v; want 0 and no rule", result.UID, result.Rule)
+	}
+}
+
+func TestClientRuleReadOnly(t *testing.T) {
+	remove := func(t *testing.T, readOnly bool, rules []ClientRule) uint32 {
+		t.Helper()
+		srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+			o.ReadOnly = readOnly
+			o.ClientRules = rules
+		})
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/dir"))
+		xdrEncodeString(&buf, "file.txt")
+		status, _ := xdrDecodeUint32(callProc(t, handler, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_REMOVE, buf.Bytes()))
+		return status
+	}
+
+	tests := []struct {
+		name     string
+		readOnly bool
+		rules    []ClientRule
+		want     uint32
+	}{
+		{"rw rule lifts read-only export", true, []ClientRule{{Host: "127.0.0.1", ReadOnly: false}}, NFS_OK},
+		{"ro rule restricts writable export", false, []ClientRule{{Host: "127.0.0.0/8", ReadOnly: true}}, NFSERR_ROFS},
+		{"most specific rule wins", false, []ClientRule{
+			{Host: "127.0.0.1", ReadOnly: true},
+			{Host: "*", ReadOnly: false},
+		}, NFSERR_ROFS},
+		{"uncovered client keeps export setting", true, []ClientRule{{Host: "10.0.0.0/8", ReadOnly: false}}, NFSERR_ROFS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remove(t, tt.readOnly, tt.rules); got != tt.want {
+				t.Errorf("REMOVE status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRulesValidation(t *testing.T) {
+	for _, rule := range []ClientRule{
+		{Host: "client.example.com"},
+		{Host: "10.0.0.0/33"},
+		{Host: "*", Squash: "some"},
+	} {
+		mfs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(mfs, ExportOptions{ClientRules: []ClientRule{rule}}); err == nil {
+			t.Errorf("New accepted client rule %+v", rule)
+		}
+	}
+}
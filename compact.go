@@ -0,0 +1,114 @@
+// compact.go: Reclaiming the memory maps keep after churn.
+//
+// Go maps never shrink: a map that once held many entries keeps the room
+// for them after they are deleted, so a handle map or cache that peaked
+// during a burst holds that memory for the life of the server. The handle
+// map and the attribute and directory caches record the most entries they
+// have held since their maps were built, and Compact rebuilds the maps
+// holding fewer than that. Each structure is rebuilt under its own lock in
+// turn, so live traffic waits for at most one copy.
+package absnfs
+
+import "unsafe"
+
+// MapSizes reports how many entries the backing maps of the handle map and
+// caches are sized for: the most each has held since it was last built
+type MapSizes struct {
+	Handles   int
+	AttrCache int
+	DirCache  int // Zero without EnableDirCache
+}
+
+// MapSizes reports what the handle map and caches are sized for. After
+// Compact, each size is the number of entries the structure holds.
+func (s *AbsfsNFS) MapSizes() MapSizes {
+	var sizes MapSizes
+	s.fileMap.RLock()
+	sizes.Handles = s.fileMap.peak
+	s.fileMap.RUnlock()
+	s.attrCache.mu.RLock()
+	sizes.AttrCache = s.attrCache.peak
+	s.attrCache.mu.RUnlock()
+	if s.dirCache != nil {
+		s.dirCache.mu.RLock()
+		sizes.DirCache = s.dirCache.peak
+		s.dirCache.mu.RUnlock()
+	}
+	return sizes
+}
+
+// Compact rebuilds the handle map and caches whose maps are sized for more
+// entries than they hold, and returns an estimate of the bytes reclaimed.
+// Live entries are kept. It is safe to call while the server is serving.
+func (s *AbsfsNFS) Compact() int64 {
+	reclaimed := s.fileMap.compact() + s.attrCache.compact()
+	if s.dirCache != nil {
+		reclaimed += s.dirCache.compact()
+	}
+	return reclaimed
+}
+
+// compact rebuilds the handle maps if they have held more handles than
+// they do now
+func (fm *FileHandleMap) compact() int64 {
+	fm.Lock()
+	defer fm.Unlock()
+	if fm.peak <= len(fm.handles) {
+		return 0
+	}
+	var reclaimed, n int64
+	fm.handles, reclaimed = rebuildMap(fm.handles, fm.peak)
+	fm.pathHandles, n = rebuildMap(fm.pathHandles, fm.peak)
+	reclaimed += n
+	if fm.lastUsed != nil {
+		fm.lastUsed, n = rebuildMap(fm.lastUsed, fm.peak)
+		reclaimed += n
+	}
+	fm.peak = len(fm.handles)
+	return reclaimed
+}
+
+// compact rebuilds the cache map if it has held more entries than it does
+// now
+func (c *AttrCache) compact() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peak <= len(c.cache) {
+		return 0
+	}
+	var reclaimed int64
+	c.cache, reclaimed = rebuildMap(c.cache, c.peak)
+	// byHandle holds at most what cache does; its peak is not tracked, so
+	// it is rebuilt without counting toward the estimate
+	c.byHandle, _ = rebuildMap(c.byHandle, len(c.byHandle))
+	c.peak = len(c.cache)
+	return reclaimed
+}
+
+// compact rebuilds the entries map if it has held more directories than
+// it does now
+func (c *DirCache) compact() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peak <= len(c.entries) {
+		return 0
+	}
+	var reclaimed int64
+	c.entries, reclaimed = rebuildMap(c.entries, c.peak)
+	c.peak = len(c.entries)
+	return reclaimed
+}
+
+// rebuildMap returns a copy of m sized for the entries it holds, and an
+// estimate of the bytes m kept for the peak-len(m) entries it no longer
+// does: a key, a value and a hash byte each
+func rebuildMap[K comparable, V any](m map[K]V, peak int) (map[K]V, int64) {
+	fresh := make(map[K]V, len(m))
+	for k, v := range m {
+		fresh[k] = v
+	}
+	var k K
+	var v V
+	slot := int64(unsafe.Sizeof(k) + unsafe.Sizeof(v) + 1)
+	return fresh, int64(max(peak-len(m), 0)) * slot
+}
@@ -0,0 +1,68 @@
+package absnfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestCompact(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfs, err := New(mfs, ExportOptions{EnableDirCache: true, AttrCacheSize: 5000, DirCacheMaxEntries: 5000})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+
+	const total, live = 2000, 10
+	handles := make([]uint64, total)
+	for i := range handles {
+		p := fmt.Sprintf("/file%d", i)
+		handles[i] = nfs.fileMap.Allocate(&NFSNode{path: p})
+		nfs.attrCache.Put(p, &NFSAttrs{Mode: 0644, Size: int64(i)})
+		nfs.dirCache.Put(p, []os.FileInfo{})
+	}
+	for i := live; i < total; i++ {
+		p := fmt.Sprintf("/file%d", i)
+		nfs.fileMap.Release(handles[i])
+		nfs.attrCache.Invalidate(p)
+		nfs.dirCache.Invalidate(p)
+	}
+
+	before := nfs.MapSizes()
+	if before != (MapSizes{Handles: total, AttrCache: total, DirCache: total}) {
+		t.Fatalf("MapSizes before Compact = %+v, want %d each", before, total)
+	}
+	if reclaimed := nfs.Compact(); reclaimed <= 0 {
+		t.Errorf("Compact reclaimed %d bytes, want > 0", reclaimed)
+	}
+	if after := nfs.MapSizes(); after != (MapSizes{Handles: live, AttrCache: live, DirCache: live}) {
+		t.Errorf("MapSizes after Compact = %+v, want %d each", after, live)
+	}
+	if reclaimed := nfs.Compact(); reclaimed != 0 {
+		t.Errorf("second Compact reclaimed %d bytes, want 0", reclaimed)
+	}
+
+	// Live entries survive, and the maps keep working
+	for i := 0; i < live; i++ {
+		p := fmt.Sprintf("/file%d", i)
+		f, ok := nfs.fileMap.Get(handles[i])
+		if !ok || f.(*NFSNode).path != p {
+			t.Errorf("handle %d = %v, %v after Compact; want %s", handles[i], f, ok, p)
+		}
+		if attrs, ok := nfs.attrCache.Get(p); !ok || attrs.Size != int64(i) {
+			t.Errorf("attr cache lost %s", p)
+		}
+		if _, ok := nfs.dirCache.Get(p); !ok {
+			t.Errorf("dir cache lost %s", p)
+		}
+	}
+	if h := nfs.fileMap.Allocate(&NFSNode{path: "/file0"}); h != handles[0] {
+		t.Errorf("Allocate of a live path after Compact = %d, want existing handle %d", h, handles[0])
+	}
+}
@@ -0,0 +1,148 @@
+// confine.go: Containment check for backing filesystem paths.
+//
+// Every path an operation hands to the backing filesystem passes through
+// confineToRoot, which rejects paths that would leave the export root.
+// Lexical escapes ("..", backslashes, NUL, relative paths) are always
+// rejected; with ConfineSymlinks set, symlinks in intermediate components
+// are resolved and their targets checked as well. This is defense in depth
+// against path-construction bugs: escapes map to NFSERR_ACCES. Resolution
+// stops after MaxSymlinkDepth expansions, so a symlink cycle fails with
+// ELOOP (NFSERR_MLINK) instead of looping. With MaxSymlinkResolutions, a
+// request's context also carries a budget of expansions shared by every
+// path the request resolves.
+package absnfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// defaultMaxSymlinkDepth bounds symlink expansions while confining one path
+// unless MaxSymlinkDepth says otherwise, matching the usual kernel MAXSYMLINKS
+const defaultMaxSymlinkDepth = 40
+
+// errEscapesRoot wraps os.ErrPermission so escapes map to NFSERR_ACCES
+var errEscapesRoot = fmt.Errorf("path escapes export root: %w", os.ErrPermission)
+
+// confineToRoot validates that p stays within the export root and returns
+// its canonical form. The final component is not followed: a link that
+// points outside the export is still a valid object to LOOKUP, READLINK
+// or REMOVE, since NFS clients resolve symlinks themselves.
+func (s *AbsfsNFS) confineToRoot(p string) (string, error) {
+	return s.confine(p, false, nil)
+}
+
+// confineFollowing is confineToRoot for operations whose backing call
+// follows a symlink in the final component (open, stat, readdir).
+func (s *AbsfsNFS) confineFollowing(p string) (string, error) {
+	return s.confine(p, true, nil)
+}
+
+// symlinkBudget is the number of symlink expansions a request has left
+// across all the paths it resolves. A request is handled by one
+// goroutine, so it needs no locking.
+type symlinkBudget struct {
+	remaining int
+}
+
+type symlinkBudgetKey struct{}
+
+// withSymlinkBudget returns ctx carrying a fresh budget of
+// MaxSymlinkResolutions expansions for one request, or ctx itself when
+// there is no per-request limit
+func (s *AbsfsNFS) withSymlinkBudget(ctx context.Context) context.Context {
+	limit := s.policy.Load().MaxSymlinkResolutions
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, symlinkBudgetKey{}, &symlinkBudget{remaining: limit})
+}
+
+// symlinkBudgetFrom returns the budget carried by ctx, or nil
+func symlinkBudgetFrom(ctx context.Context) *symlinkBudget {
+	budget, _ := ctx.Value(symlinkBudgetKey{}).(*symlinkBudget)
+	return budget
+}
+
+// confine resolves p as confineToRoot and confineFollowing describe,
+// charging each symlink expansion to budget when there is one
+func (s *AbsfsNFS) confine(p string, followFinal bool, budget *symlinkBudget) (string, error) {
+	if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, "\\\x00") {
+		return "", fmt.Errorf("%q: %w", p, errEscapesRoot)
+	}
+
+	pending := strings.Split(p, "/")
+	var resolved []string // components of the resolved prefix below the root
+	expansions := 0
+	policy := s.policy.Load()
+	followLinks := policy.ConfineSymlinks
+	maxExpansions := policy.MaxSymlinkDepth
+	if maxExpansions <= 0 {
+		maxExpansions = defaultMaxSymlinkDepth
+	}
+
+	for len(pending) > 0 {
+		comp := pending[0]
+		pending = pending[1:]
+		switch comp {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", fmt.Errorf("%q: %w", p, errEscapesRoot)
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		resolved = append(resolved, comp)
+
+		if !followLinks || (!followFinal && !hasNextComponent(pending)) {
+			continue
+		}
+		current := "/" + strings.Join(resolved, "/")
+		info, err := s.fs.Lstat(current)
+		if err != nil {
+			// Nothing below a missing component can be a symlink
+			followLinks = false
+			continue
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		// A cycle anywhere along the path, through intermediate components
+		// included, keeps expanding until it runs into this bound
+		if expansions++; expansions > maxExpansions {
+			return "", fmt.Errorf("%q: %w", p, syscall.ELOOP)
+		}
+		if budget != nil {
+			if budget.remaining--; budget.remaining < 0 {
+				return "", fmt.Errorf("%q: symlink resolution budget exhausted: %w", p, syscall.ELOOP)
+			}
+		}
+		target, err := s.fs.Readlink(current)
+		if err != nil {
+			return "", fmt.Errorf("%q: failed to read link %s: %w", p, current, err)
+		}
+		resolved = resolved[:len(resolved)-1]
+		if strings.HasPrefix(target, "/") {
+			resolved = resolved[:0]
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+
+	return path.Clean(p), nil
+}
+
+// hasNextComponent reports whether any non-empty component remains
+func hasNextComponent(pending []string) bool {
+	for _, c := range pending {
+		if c != "" && c != "." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package absnfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestConfineToRoot(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/a", 0755)
+	mfs.Mkdir("/a/b", 0755)
+	for target, link := range map[string]string{
+		"../../outside": "/a/esc",   // relative target climbing above the root
+		"esc":           "/a/chain", // relative link to a link that escapes
+		"/a/b":          "/abs",     // absolute target inside the root
+		"b/../..":       "/a/up",    // resolves to the root itself
+		"/loop":         "/loop",
+	} {
+		if err := mfs.Symlink(target, link); err != nil {
+			t.Fatalf("Failed to create symlink %s: %v", link, err)
+		}
+	}
+
+	nfs, err := New(mfs, ExportOptions{ConfineSymlinks: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		want   string
+		escape bool
+	}{
+		{path: "/a/b", want: "/a/b"},
+		{path: "/a/./b/", want: "/a/b"},
+		{path: "/a/../a/b", want: "/a/b"},
+		{path: "/a/%2e%2e/x", want: "/a/%2e%2e/x"}, // percent-encoding is not decoded
+		{path: "/a/esc", want: "/a/esc"},           // final component is not followed
+		{path: "/abs/x", want: "/abs/x"},
+		{path: "/a/up/a", want: "/a/up/a"},
+		{path: "/missing/../a", want: "/a"},
+		{path: "/..", escape: true},
+		{path: "/../etc/passwd", escape: true},
+		{path: "/a/b/../../../etc", escape: true},
+		{path: "a/b", escape: true},
+		{path: "", escape: true},
+		{path: "/a\\..\\..\\etc", escape: true},
+		{path: "/a\x00/b", escape: true},
+		{path: "/a/esc/passwd", escape: true},
+		{path: "/a/chain/passwd", escape: true},
+		{path: "/a/up/..", escape: true},
+		{path: "/abs/../../..", escape: true},
+	}
+	for _, tt := range tests {
+		got, err := nfs.confineToRoot(tt.path)
+		if tt.escape {
+			if !errors.Is(err, errEscapesRoot) {
+				t.Errorf("confineToRoot(%q) = %q, %v; want escape error", tt.path, got, err)
+			} else if status := MapErrorToNFSStatus(err); status != NFSERR_ACCES {
+				t.Errorf("confineToRoot(%q) maps to %d, want NFSERR_ACCES", tt.path, status)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("confineToRoot(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	if _, err := nfs.confineToRoot("/loop/x"); err == nil || errors.Is(err, errEscapesRoot) {
+		t.Errorf("Expected a symlink loop error, got %v", err)
+	}
+
+	t.Run("intermediate symlink cycle", func(t *testing.T) {
+		// /a/ping and /a/pong point at each other, so every path below
+		// either one cycles in an intermediate component
+		mfs.Symlink("pong/d", "/a/ping")
+		mfs.Symlink("ping/d", "/a/pong")
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := nfs.Lookup("/a/ping/d/file")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, syscall.ELOOP) {
+				t.Fatalf("Expected ELOOP resolving through the cycle, got %v", err)
+			}
+			if status := MapErrorToNFSStatus(err); status != NFSERR_MLINK {
+				t.Errorf("Expected the loop to map to NFSERR_MLINK, got %d", status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Resolving through a symlink cycle did not return")
+		}
+	})
+
+	t.Run("MaxSymlinkDepth bounds expansions", func(t *testing.T) {
+		// /a/l3 -> l2 -> l1 -> b: three expansions to reach /a/b
+		mfs.Symlink("b", "/a/l1")
+		mfs.Symlink("l1", "/a/l2")
+		mfs.Symlink("l2", "/a/l3")
+
+		if got, err := nfs.confineToRoot("/a/l3/x"); err != nil || got != "/a/l3/x" {
+			t.Errorf("Expected a three-link chain to resolve under the default depth, got %q, %v", got, err)
+		}
+		shallow, err := New(mfs, ExportOptions{ConfineSymlinks: true, MaxSymlinkDepth: 2})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		if _, err := shallow.confineToRoot("/a/l3/x"); !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("Expected ELOOP with MaxSymlinkDepth 2, got %v", err)
+		}
+		if _, err := shallow.confineToRoot("/a/l2/x"); err != nil {
+			t.Errorf("Expected a two-link chain to resolve with MaxSymlinkDepth 2, got %v", err)
+		}
+	})
+
+	t.Run("MaxSymlinkResolutions budgets one request", func(t *testing.T) {
+		// /a/l5 -> l4 -> l3: five expansions to reach /a/b
+		mfs.Symlink("l3", "/a/l4")
+		mfs.Symlink("l4", "/a/l5")
+
+		budgeted, err := New(mfs, ExportOptions{ConfineSymlinks: true, MaxSymlinkResolutions: 4})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		budget := symlinkBudgetFrom(budgeted.withSymlinkBudget(context.Background()))
+		if got, err := budgeted.confine("/a/l3/x", false, budget); err != nil || got != "/a/l3/x" {
+			t.Fatalf("Expected a three-link chain to resolve within a budget of 4, got %q, %v", got, err)
+		}
+		// The same request has one expansion left, too few for another chain
+		if _, err := budgeted.confine("/a/l2/x", false, budget); !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("Expected ELOOP once the request's budget is spent, got %v", err)
+		}
+		budget = symlinkBudgetFrom(budgeted.withSymlinkBudget(context.Background()))
+		if _, err := budgeted.confine("/a/l5/x", false, budget); !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("Expected ELOOP for a five-link chain with a budget of 4, got %v", err)
+		}
+		if _, err := budgeted.confineToRoot("/a/l5/x"); err != nil {
+			t.Errorf("Expected a path resolved outside a request to be bounded by MaxSymlinkDepth only, got %v", err)
+		}
+		if symlinkBudgetFrom(nfs.withSymlinkBudget(context.Background())) != nil {
+			t.Error("Expected no request budget without MaxSymlinkResolutions")
+		}
+
+		// LookupWithContext charges the budget its context carries
+		ctx := budgeted.withSymlinkBudget(context.Background())
+		if _, err := budgeted.LookupWithContext(ctx, "/a/l5/x"); MapErrorToNFSStatus(err) != NFSERR_MLINK {
+			t.Errorf("Expected a lookup past the request's budget to map to NFSERR_MLINK, got %v", err)
+		}
+		if symlinkBudgetFrom(ctx).remaining >= 0 {
+			t.Errorf("Expected the lookup to spend the whole budget, %d left", symlinkBudgetFrom(ctx).remaining)
+		}
+	})
+
+	t.Run("operations reject escapes", func(t *testing.T) {
+		if _, err := nfs.Lookup("/a/esc/passwd"); MapErrorToNFSStatus(err) != NFSERR_ACCES {
+			t.Errorf("Expected Lookup through escaping link to map to NFSERR_ACCES, got %v", err)
+		}
+		dir := &NFSNode{SymlinkFileSystem: mfs, path: "/a/esc", attrs: &NFSAttrs{Mode: os.ModeDir}}
+		if _, err := nfs.Create(dir, "f", &NFSAttrs{Mode: 0644}); MapErrorToNFSStatus(err) != NFSERR_ACCES {
+			t.Errorf("Expected Create under escaping link to map to NFSERR_ACCES, got %v", err)
+		}
+		if _, err := nfs.ReadDir(dir); MapErrorToNFSStatus(err) != NFSERR_ACCES {
+			t.Errorf("Expected ReadDir through escaping link to map to NFSERR_ACCES, got %v", err)
+		}
+		if _, err := nfs.Read(dir, 0, 16); MapErrorToNFSStatus(err) != NFSERR_ACCES {
+			t.Errorf("Expected Read following escaping link to map to NFSERR_ACCES, got %v", err)
+		}
+		if _, err := nfs.Readlink(dir); err != nil && errors.Is(err, errEscapesRoot) {
+			t.Errorf("Expected READLINK of the escaping link itself to be confined only lexically, got %v", err)
+		}
+	})
+
+	t.Run("symlinks are not resolved by default", func(t *testing.T) {
+		lax, err := New(mfs, ExportOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		if got, err := lax.confineToRoot("/a/esc/passwd"); err != nil || got != "/a/esc/passwd" {
+			t.Errorf("Expected lexical-only check to pass, got %q, %v", got, err)
+		}
+		if _, err := lax.confineToRoot("/../etc"); !errors.Is(err, errEscapesRoot) {
+			t.Errorf("Expected lexical escape to be rejected, got %v", err)
+		}
+	})
+}
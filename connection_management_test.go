@@ -106,14 +106,16 @@ func TestConnectionTracking(t *testing.T) {
 	conn2 := &mockConn{}
 	conn3 := &mockConn{}
 
-	// Register connections
+	// Register connections, admitted as if OnConnect let them through
 	if !server.registerConnection(conn1) {
 		t.Errorf("Expected conn1 registration to succeed")
 	}
+	server.admitConnection(conn1)
 
 	if !server.registerConnection(conn2) {
 		t.Errorf("Expected conn2 registration to succeed")
 	}
+	server.admitConnection(conn2)
 
 	// This should fail because we're at the limit
 	if server.registerConnection(conn3) {
@@ -127,15 +129,17 @@ func TestConnectionTracking(t *testing.T) {
 	if !server.registerConnection(conn3) {
 		t.Errorf("Expected conn3 registration to succeed after unregistering conn1")
 	}
+	server.admitConnection(conn3)
 
-	// Check conn count
-	server.connMutex.Lock()
-	count := server.connCount
-	server.connMutex.Unlock()
-
-	if count != 2 {
+	if count := server.ActiveConnections(); count != 2 {
 		t.Errorf("Expected connection count to be 2, got %d", count)
 	}
+
+	m := nfs.GetMetrics()
+	if m.ActiveConnections != 2 || m.TotalConnections != 3 || m.RejectedConnections != 1 {
+		t.Errorf("Connection metrics = active %d total %d rejected %d, want 2, 3 and 1",
+			m.ActiveConnections, m.TotalConnections, m.RejectedConnections)
+	}
 }
 
 func TestIdleConnectionCleanup(t *testing.T) {
@@ -0,0 +1,244 @@
+// cookie_cache.go: READDIR cookie verifiers and listing snapshots.
+//
+// Each directory listed through READDIR or READDIRPLUS is handed a cookie
+// verifier derived from the directory's modification time, which the
+// client returns with every cookie it resumes from. A cookie presented with
+// a verifier the directory no longer has was issued for a listing that has
+// since changed, so it cannot be trusted to point where the client thinks:
+// it is answered with NFSERR_BAD_COOKIE and the client lists the directory
+// again from the start.
+//
+// Modification times can be too coarse to tell two changes apart, so the
+// verifier also mixes in a change counter the server bumps whenever it adds
+// or removes an entry in the directory. The counters are a fixed array that
+// directories are hashed onto: two directories sharing one only cost their
+// clients a spurious NFSERR_BAD_COOKIE.
+//
+// Cookies are offsets into a listing. So that they stay put while a client
+// pages through a large directory, CookieCache holds the listing READDIR or
+// READDIRPLUS paged from until the client reaches its end, for up to a fixed
+// number of directories and a fixed number of entries across them, with LRU
+// eviction. A listing a client abandons expires after cookieSnapshotTTL. A
+// listing that is evicted, expired, too large to hold, or that another
+// client finished, is read again; the verifier still matches only if the
+// directory has not been modified since.
+package absnfs
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// cookieCacheMaxNodes caps the entries held across all listings
+	cookieCacheMaxNodes = 100000
+
+	// cookieSnapshotTTL is how long a listing is held for a client to page
+	// through it
+	cookieSnapshotTTL = 5 * time.Minute
+
+	// cookieGenerations is the number of change counters directories are
+	// hashed onto
+	cookieGenerations = 256
+)
+
+// CookieCache derives cookie verifiers and holds the listings of
+// directories being paged through
+type CookieCache struct {
+	mu          sync.Mutex
+	entries     map[string]*cookieEntry
+	accessList  *list.List
+	maxEntries  int
+	nodes       int // Entries held across all listings
+	maxNodes    int
+	ttl         time.Duration
+	seed        uint64
+	generations [cookieGenerations]atomic.Uint64
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+}
+
+// cookieEntry is the listing of one directory as of verf
+type cookieEntry struct {
+	verf        [8]byte
+	nodes       []*NFSNode
+	saved       time.Time
+	listElement *list.Element
+}
+
+// NewCookieCache creates a cookie cache holding the listings of up to
+// maxEntries directories
+func NewCookieCache(maxEntries int) *CookieCache {
+	if maxEntries <= 0 {
+		maxEntries = 1000 // Default: 1000 directories
+	}
+	return &CookieCache{
+		entries:    make(map[string]*cookieEntry),
+		accessList: list.New(),
+		maxEntries: maxEntries,
+		maxNodes:   cookieCacheMaxNodes,
+		ttl:        cookieSnapshotTTL,
+		// Seed from the clock so verifiers differ across restarts
+		seed: uint64(time.Now().UnixNano()),
+	}
+}
+
+// Verifier returns the cookie verifier of dir when its modification time
+// is mtime
+func (c *CookieCache) Verifier(dir string, mtime time.Time) [8]byte {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(mtime.UnixNano()))
+	binary.BigEndian.PutUint64(buf[8:], c.generation(dir).Load())
+	h := fnv.New64a()
+	h.Write([]byte(dir))
+	h.Write(buf[:])
+	var verf [8]byte
+	binary.BigEndian.PutUint64(verf[:], c.seed^h.Sum64())
+	return verf
+}
+
+// Touch records that an entry of dir was added or removed, changing its
+// verifier even if its modification time has not moved
+func (c *CookieCache) Touch(dir string) {
+	c.generation(dir).Add(1)
+}
+
+// generation returns the change counter dir is hashed onto
+func (c *CookieCache) generation(dir string) *atomic.Uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(dir))
+	return &c.generations[h.Sum64()%cookieGenerations]
+}
+
+// Check reports whether verf is the verifier of dir when its modification
+// time is mtime
+func (c *CookieCache) Check(dir string, mtime time.Time, verf [8]byte) bool {
+	if verf != c.Verifier(dir, mtime) {
+		atomic.AddUint64(&c.misses, 1)
+		return false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return true
+}
+
+// Snapshot returns the listing of dir held under verf, if there is one
+// and it has not expired, and marks dir as most recently used
+func (c *CookieCache) Snapshot(dir string, verf [8]byte) ([]*NFSNode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[dir]
+	if !ok || entry.verf != verf {
+		return nil, false
+	}
+	if time.Since(entry.saved) > c.ttl {
+		c.remove(dir, entry)
+		return nil, false
+	}
+	c.accessList.MoveToFront(entry.listElement)
+	return entry.nodes, true
+}
+
+// Save holds nodes as the listing of dir under verf, replacing any listing
+// held under another verifier. A listing with more entries than the cache
+// holds in total is not held.
+func (c *CookieCache) Save(dir string, verf [8]byte, nodes []*NFSNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[dir]; ok {
+		c.remove(dir, entry)
+	}
+	if len(nodes) > c.maxNodes {
+		return
+	}
+	c.expire()
+	for len(c.entries) >= c.maxEntries || c.nodes+len(nodes) > c.maxNodes {
+		c.evictOldest()
+	}
+	c.entries[dir] = &cookieEntry{verf: verf, nodes: nodes, saved: time.Now(), listElement: c.accessList.PushFront(dir)}
+	c.nodes += len(nodes)
+}
+
+// Release drops the listing of dir held under verf, once a client has
+// paged to its end
+func (c *CookieCache) Release(dir string, verf [8]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[dir]; ok && entry.verf == verf {
+		c.remove(dir, entry)
+	}
+}
+
+// expire drops expired listings from the least recently used end, up to
+// the first that is still live. Caller holds mu.
+func (c *CookieCache) expire() {
+	for lru := c.accessList.Back(); lru != nil; lru = c.accessList.Back() {
+		dir := lru.Value.(string)
+		entry := c.entries[dir]
+		if time.Since(entry.saved) <= c.ttl {
+			return
+		}
+		c.remove(dir, entry)
+	}
+}
+
+// evictOldest drops the least recently used directory. Caller holds mu.
+func (c *CookieCache) evictOldest() {
+	lru := c.accessList.Back()
+	dir := lru.Value.(string)
+	c.remove(dir, c.entries[dir])
+	atomic.AddUint64(&c.evictions, 1)
+}
+
+// remove drops the listing of dir. Caller holds mu.
+func (c *CookieCache) remove(dir string, entry *cookieEntry) {
+	c.accessList.Remove(entry.listElement)
+	delete(c.entries, dir)
+	c.nodes -= len(entry.nodes)
+}
+
+// Resize changes the maximum number of directories held, evicting the
+// least recently used ones if there are now too many
+func (c *CookieCache) Resize(newMaxEntries int) {
+	if newMaxEntries <= 0 {
+		newMaxEntries = 1000 // Default size if invalid
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxEntries = newMaxEntries
+	for len(c.entries) > c.maxEntries {
+		c.evictOldest()
+	}
+}
+
+// Stats returns the number of directory listings held and the hit, miss
+// and eviction counts
+func (c *CookieCache) Stats() (size int, hits, misses, evictions uint64) {
+	c.mu.Lock()
+	size = len(c.entries)
+	c.mu.Unlock()
+	return size, atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses), atomic.LoadUint64(&c.evictions)
+}
+
+// cacheStats returns the cache's counters for CacheStats
+func (c *CookieCache) cacheStats() CacheCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheCounters{
+		Entries:   len(c.entries),
+		Limit:     c.maxEntries,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
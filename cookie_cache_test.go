@@ -0,0 +1,284 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+func TestCookieCache(t *testing.T) {
+	c := NewCookieCache(2)
+	mtime := time.Unix(1700000000, 5)
+	verfA := c.Verifier("/a", mtime)
+	if c.Verifier("/a", mtime) != verfA {
+		t.Fatal("Expected /a to keep its verifier while unmodified")
+	}
+	if c.Verifier("/a", mtime.Add(time.Nanosecond)) == verfA {
+		t.Error("Expected a modified /a to get a new verifier")
+	}
+	if c.Verifier("/b", mtime) == verfA {
+		t.Error("Expected /a and /b to get distinct verifiers")
+	}
+	if !c.Check("/a", mtime, verfA) || c.Check("/a", mtime.Add(time.Second), verfA) {
+		t.Error("Expected Check to accept only the current verifier")
+	}
+
+	nodes := []*NFSNode{{path: "/a/x"}}
+	c.Save("/a", verfA, nodes)
+	c.Save("/b", c.Verifier("/b", mtime), nil)
+	if got, ok := c.Snapshot("/a", verfA); !ok || len(got) != 1 || got[0] != nodes[0] {
+		t.Errorf("Snapshot(/a) = %v, %v; want the saved listing", got, ok)
+	}
+	if _, ok := c.Snapshot("/a", c.Verifier("/a", mtime.Add(time.Second))); ok {
+		t.Error("Expected no snapshot under another verifier")
+	}
+
+	// /a was used last, so /c displaces /b
+	c.Save("/c", c.Verifier("/c", mtime), nil)
+	if _, ok := c.Snapshot("/b", c.Verifier("/b", mtime)); ok {
+		t.Error("Expected /b to have been evicted")
+	}
+	c.Release("/a", verfA)
+	if _, ok := c.Snapshot("/a", verfA); ok {
+		t.Error("Expected /a to be released")
+	}
+	size, hits, misses, evictions := c.Stats()
+	if size != 1 || hits != 1 || misses != 1 || evictions != 1 {
+		t.Errorf("Stats = (%d, %d, %d, %d), want (1, 1, 1, 1)", size, hits, misses, evictions)
+	}
+
+	c.Save("/a", verfA, nodes)
+	c.Resize(1)
+	if size, _, _, evictions := c.Stats(); size != 1 || evictions != 2 {
+		t.Errorf("After Resize(1): size %d evictions %d, want 1 and 2", size, evictions)
+	}
+}
+
+func TestCookieCacheBounds(t *testing.T) {
+	c := NewCookieCache(10)
+	c.maxNodes = 3
+	mtime := time.Unix(1700000000, 5)
+	nodes := func(n int) []*NFSNode {
+		return make([]*NFSNode, n)
+	}
+
+	t.Run("entries across listings", func(t *testing.T) {
+		c.Save("/a", c.Verifier("/a", mtime), nodes(2))
+		c.Save("/b", c.Verifier("/b", mtime), nodes(2))
+		if _, ok := c.Snapshot("/a", c.Verifier("/a", mtime)); ok {
+			t.Error("Expected /a to be evicted to stay within 3 entries")
+		}
+		c.Save("/c", c.Verifier("/c", mtime), nodes(4))
+		if _, ok := c.Snapshot("/c", c.Verifier("/c", mtime)); ok {
+			t.Error("Expected a listing larger than the cache not to be held")
+		}
+		if _, ok := c.Snapshot("/b", c.Verifier("/b", mtime)); !ok {
+			t.Error("Expected /b to stay held")
+		}
+		if c.nodes != 2 {
+			t.Errorf("Held entries = %d, want 2", c.nodes)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		verf := c.Verifier("/b", mtime)
+		c.entries["/b"].saved = time.Now().Add(-cookieSnapshotTTL - time.Second)
+		if _, ok := c.Snapshot("/b", verf); ok {
+			t.Error("Expected an expired listing not to be continued")
+		}
+		if size, _, _, _ := c.Stats(); size != 0 || c.nodes != 0 {
+			t.Errorf("After expiry: %d listings and %d entries held, want none", size, c.nodes)
+		}
+	})
+
+	t.Run("change counter", func(t *testing.T) {
+		verf := c.Verifier("/d", mtime)
+		c.Touch("/d")
+		if c.Verifier("/d", mtime) == verf {
+			t.Error("Expected an entry change to give /d a new verifier at the same mtime")
+		}
+	})
+}
+
+// readdirPage is one READDIR reply: the names, the last cookie, the
+// verifier and whether the listing ended
+type readdirPage struct {
+	status uint32
+	names  []string
+	cookie uint64
+	verf   [8]byte
+	eof    bool
+}
+
+func newCookieTestServer(t *testing.T, mfs absfs.SymlinkFileSystem, opts ExportOptions) (*AbsfsNFS, *Server, *NFSProcedureHandler) {
+	t.Helper()
+	nfs, err := New(mfs, opts)
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	return nfs, server, &NFSProcedureHandler{server: server}
+}
+
+func readdirPageOf(t *testing.T, h *NFSProcedureHandler, handle, cookie uint64, verf [8]byte, count uint32) readdirPage {
+	t.Helper()
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, handle)
+	binary.Write(&buf, binary.BigEndian, cookie)
+	buf.Write(verf[:])
+	binary.Write(&buf, binary.BigEndian, count)
+	reply, err := h.handleReaddir(bytes.NewReader(buf.Bytes()), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("READDIR: %v", err)
+	}
+	r := bytes.NewReader(reply.Data.([]byte))
+	var page readdirPage
+	page.status, _ = xdrDecodeUint32(r)
+	if page.status != NFS_OK {
+		return page
+	}
+	r.Seek(4+84, io.SeekCurrent) // post_op_attr flag and fattr3
+	io.ReadFull(r, page.verf[:])
+	for {
+		more, err := xdrDecodeUint32(r)
+		if err != nil {
+			t.Fatalf("Truncated reply: %v", err)
+		}
+		if more == 0 {
+			break
+		}
+		r.Seek(8, io.SeekCurrent) // fileid
+		name, _ := xdrDecodeString(r)
+		page.names = append(page.names, name)
+		binary.Read(r, binary.BigEndian, &page.cookie)
+	}
+	eof, _ := xdrDecodeUint32(r)
+	page.eof = eof == 1
+	return page
+}
+
+func TestReaddirStaleCookieVerifier(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/a", 0755)
+	for _, name := range []string{"x", "y"} {
+		f, _ := mfs.Create("/a/" + name)
+		f.Close()
+	}
+	nfs, server, h := newCookieTestServer(t, mfs, ExportOptions{})
+	handle := getFileHandle(server, "/a")
+
+	first := readdirPageOf(t, h, handle, 0, [8]byte{}, 4096)
+	if page := readdirPageOf(t, h, handle, 1, first.verf, 4096); page.status != NFS_OK || page.verf != first.verf {
+		t.Fatalf("Resuming /a: status %d verifier %x, want NFS_OK and %x", page.status, page.verf, first.verf)
+	}
+
+	// Removing an entry changes the directory's mtime, and with it the verifier
+	if err := mfs.Remove("/a/x"); err != nil {
+		t.Fatal(err)
+	}
+	if page := readdirPageOf(t, h, handle, 1, first.verf, 4096); page.status != NFSERR_BAD_COOKIE {
+		t.Errorf("Resuming modified /a: expected NFSERR_BAD_COOKIE, got %d", page.status)
+	}
+	if page := readdirPageOf(t, h, handle, 0, [8]byte{}, 4096); page.status != NFS_OK || page.verf == first.verf {
+		t.Errorf("Relisting /a: status %d verifier %x, want NFS_OK and a new verifier", page.status, page.verf)
+	}
+
+	m := nfs.GetMetrics()
+	if m.CookieCacheHits != 1 || m.CookieCacheMisses != 1 {
+		t.Errorf("Cookie cache metrics = hits %d misses %d, want 1 and 1", m.CookieCacheHits, m.CookieCacheMisses)
+	}
+}
+
+// frozenMtimeFS reports a fixed mtime for dir, as a filesystem with a
+// coarse timestamp granularity would for changes made within one tick
+type frozenMtimeFS struct {
+	absfs.SymlinkFileSystem
+	dir string
+}
+
+// frozenInfo is a FileInfo with its modification time pinned
+type frozenInfo struct{ os.FileInfo }
+
+func (frozenInfo) ModTime() time.Time { return time.Unix(1700000000, 0) }
+
+func (fs *frozenMtimeFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.SymlinkFileSystem.Stat(name)
+	if err == nil && name == fs.dir {
+		info = frozenInfo{info}
+	}
+	return info, err
+}
+
+func (fs *frozenMtimeFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.SymlinkFileSystem.Lstat(name)
+	if err == nil && name == fs.dir {
+		info = frozenInfo{info}
+	}
+	return info, err
+}
+
+func TestReaddirLargeDirectoryResumption(t *testing.T) {
+	n := 50000
+	if testing.Short() {
+		n = 5000
+	}
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/big", 0755)
+	for i := 0; i < n; i++ {
+		f, err := mfs.Create(fmt.Sprintf("/big/f%05d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	nfs, server, h := newCookieTestServer(t, &frozenMtimeFS{mfs, "/big"}, ExportOptions{})
+	handle := getFileHandle(server, "/big")
+
+	seen := make(map[string]int, n)
+	page := readdirPageOf(t, h, handle, 0, [8]byte{}, 8192)
+	for pages := 1; ; pages++ {
+		if page.status != NFS_OK {
+			t.Fatalf("page %d: status %d", pages, page.status)
+		}
+		for _, name := range page.names {
+			seen[name]++
+		}
+		if page.eof {
+			break
+		}
+		if pages == 1 {
+			// An entry added within the directory's mtime granularity sorts
+			// ahead of every offset handed out so far, but the held listing
+			// keeps them in place
+			f, _ := mfs.Create("/big/a")
+			f.Close()
+		}
+		page = readdirPageOf(t, h, handle, page.cookie, page.verf, 8192)
+	}
+
+	if len(seen) != n {
+		t.Errorf("listed %d distinct names, want %d", len(seen), n)
+	}
+	for name, count := range seen {
+		if count != 1 {
+			t.Errorf("%s listed %d times", name, count)
+		}
+	}
+	if m := nfs.GetMetrics(); m.CookieCacheSize != 0 {
+		t.Errorf("CookieCacheSize = %d after the listing ended, want 0", m.CookieCacheSize)
+	}
+}
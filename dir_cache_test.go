@@ -637,3 +637,55 @@ func TestDirCacheMetricsTracking(t *testing.T) {
 		t.Errorf("Expected hit rate ~%f after third read, got %f", expectedHitRate, metrics.DirCacheHitRate)
 	}
 }
+
+func TestDirCacheValidateMtime(t *testing.T) {
+	for _, validate := range []bool{false, true} {
+		fs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		if err := fs.Mkdir("/dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Create("/dir/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		nfs, err := New(fs, ExportOptions{
+			EnableDirCache:        true,
+			DirCacheTimeout:       time.Hour,
+			ValidateDirCacheMtime: validate,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		dir, err := nfs.Lookup("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes, err := nfs.ReadDir(dir); err != nil || len(nodes) != 1 {
+			t.Fatalf("Initial ReadDir: got %d entries, err %v", len(nodes), err)
+		}
+
+		// Add an entry behind the server's back, bumping the directory mtime
+		f, err = fs.Create("/dir/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		nodes, err := nfs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		want := 1 // stale listing served from cache
+		if validate {
+			want = 2
+		}
+		if len(nodes) != want {
+			t.Errorf("ValidateDirCacheMtime=%v: expected %d entries, got %d", validate, want, len(nodes))
+		}
+	}
+}
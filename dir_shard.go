@@ -0,0 +1,186 @@
+// dir_shard.go: Virtual sharding of oversized directories.
+//
+// With DirShardThreshold set, a directory holding more entries than the
+// threshold is presented as synthetic subdirectories, one per distinct name
+// prefix, each listing the entries whose names start with it. A shard is an
+// NFSNode whose path is the backing directory and whose shard field holds
+// the prefix, so operations inside it act on the flat backing directory.
+// Lookups through a shard map straight back to the backing entry, and the
+// backing filesystem never sees the shards.
+package absnfs
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"sort"
+)
+
+// dirShardPrefixLen is the number of leading characters that group names
+// into shards
+const dirShardPrefixLen = 2
+
+// shardPrefix returns the shard name belongs to: its first
+// dirShardPrefixLen characters, or all of it if it is shorter
+func shardPrefix(name string) string {
+	n := 0
+	for i := range name {
+		if n == dirShardPrefixLen {
+			return name[:i]
+		}
+		n++
+	}
+	return name
+}
+
+// shardPrefixes returns the sorted shard names of a directory holding
+// entries, or nil if it holds no more than threshold entries and is
+// presented as is
+func shardPrefixes(entries []os.FileInfo, threshold int) []string {
+	if threshold <= 0 || len(entries) <= threshold {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	var count int
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "." || name == ".." {
+			continue
+		}
+		count++
+		seen[shardPrefix(name)] = struct{}{}
+	}
+	if count <= threshold {
+		return nil
+	}
+	prefixes := make([]string, 0, len(seen))
+	for prefix := range seen {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// listNodes converts the backing entries of dir to the nodes a listing of
+// dir presents: the shards of an oversized directory, the entries of one
+// shard, or otherwise the entries themselves. keepVanished is passed on to
+// entryNodes.
+func (s *AbsfsNFS) listNodes(dir *NFSNode, entries []os.FileInfo, keepVanished bool) []*NFSNode {
+	if dir.shard != "" {
+		var members []os.FileInfo
+		for _, entry := range entries {
+			if shardPrefix(entry.Name()) == dir.shard {
+				members = append(members, entry)
+			}
+		}
+		return s.entryNodes(dir, members, keepVanished)
+	}
+
+	prefixes := shardPrefixes(entries, s.tuning.Load().DirShardThreshold)
+	if prefixes == nil {
+		return s.entryNodes(dir, entries, keepVanished)
+	}
+	nodes := make([]*NFSNode, len(prefixes))
+	for i, prefix := range prefixes {
+		nodes[i] = s.shardNode(dir, prefix)
+	}
+	return nodes
+}
+
+// lookupChild resolves name in dir as a listing of dir presents it: a
+// sharded directory holds only its shards, and a shard only the entries
+// with its prefix. ctx may carry the request's symlink budget.
+func (s *AbsfsNFS) lookupChild(ctx context.Context, dir *NFSNode, name string) (*NFSNode, error) {
+	childPath := path.Join(dir.path, name)
+	if dir.shard != "" {
+		if shardPrefix(name) != dir.shard {
+			return nil, opError("lookup", childPath, os.ErrNotExist)
+		}
+		return s.LookupWithContext(ctx, childPath)
+	}
+
+	tuning := s.tuning.Load()
+	if tuning.DirShardThreshold <= 0 {
+		return s.LookupWithContext(ctx, childPath)
+	}
+	entries, err := s.readDirEntries(dir, tuning)
+	if err != nil {
+		return nil, opError("lookup", childPath, err)
+	}
+	prefixes := shardPrefixes(entries, tuning.DirShardThreshold)
+	if prefixes == nil {
+		return s.LookupWithContext(ctx, childPath)
+	}
+	if i := sort.SearchStrings(prefixes, name); i < len(prefixes) && prefixes[i] == name {
+		return s.shardNode(dir, name), nil
+	}
+	return nil, opError("lookup", childPath, os.ErrNotExist)
+}
+
+// entryPath returns the backing path of name in dir for an operation that
+// adds or removes an entry. A shard accepts only names with its prefix, so
+// a new entry is listed where it was created, and a sharded directory,
+// which lists only its shards, accepts none.
+func (s *AbsfsNFS) entryPath(dir *NFSNode, name string) (string, error) {
+	if dir.shard != "" {
+		if shardPrefix(name) != dir.shard {
+			return "", fmt.Errorf("not in shard %q: %w", dir.shard, os.ErrInvalid)
+		}
+		return s.childPath(dir.path, name)
+	}
+
+	tuning := s.tuning.Load()
+	if tuning.DirShardThreshold > 0 {
+		entries, err := s.readDirEntries(dir, tuning)
+		if err != nil {
+			return "", err
+		}
+		if shardPrefixes(entries, tuning.DirShardThreshold) != nil {
+			return "", fmt.Errorf("directory is sharded: %w", os.ErrPermission)
+		}
+	}
+	return s.childPath(dir.path, name)
+}
+
+// shardNode returns the synthetic subdirectory of dir for prefix. It carries
+// the attributes of dir under a file ID of its own.
+func (s *AbsfsNFS) shardNode(dir *NFSNode, prefix string) *NFSNode {
+	dir.mu.RLock()
+	attrs := *dir.attrs
+	dir.mu.RUnlock()
+	attrs.FileId = shardFileID(dir.path, prefix)
+	return &NFSNode{
+		SymlinkFileSystem: s.fs,
+		path:              dir.path,
+		shard:             prefix,
+		attrs:             &attrs,
+		children:          make(map[string]*NFSNode),
+	}
+}
+
+// shardAttrs returns the current attributes of a shard node: those of its
+// backing directory under the shard's file ID
+func (s *AbsfsNFS) shardAttrs(node *NFSNode) (*NFSAttrs, error) {
+	node.mu.RLock()
+	dir := &NFSNode{SymlinkFileSystem: s.fs, path: node.path, attrs: node.attrs}
+	node.mu.RUnlock()
+	dirAttrs, err := s.GetAttr(dir)
+	if err != nil {
+		return nil, err
+	}
+	attrs := *dirAttrs
+	attrs.FileId = shardFileID(node.path, node.shard)
+	return &attrs, nil
+}
+
+// shardFileID derives a shard's file ID from its directory and prefix, the
+// way real entries derive theirs from their path
+func shardFileID(dirPath, prefix string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(dirPath))
+	h.Write([]byte{0})
+	h.Write([]byte(prefix))
+	return h.Sum64()
+}
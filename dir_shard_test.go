@@ -0,0 +1,310 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestDirShardThreshold(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/big", 0755)
+	// 0000.dat through 270f.dat: prefixes 00 through 27
+	for i := 0; i < 10000; i++ {
+		f, err := mfs.Create(fmt.Sprintf("/big/%04x.dat", i))
+		if err != nil {
+			t.Fatalf("Failed to create entry %d: %v", i, err)
+		}
+		f.Close()
+	}
+	mfs.Mkdir("/small", 0755)
+	f, _ := mfs.Create("/small/abc.txt")
+	f.Close()
+
+	nfs, err := New(mfs, ExportOptions{DirShardThreshold: 1000, EnableDirCache: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	h := &NFSProcedureHandler{server: server}
+	auth := testAuthContext()
+
+	lookup := func(dir uint64, name string) (uint32, uint64, uint64) {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dir)
+		xdrEncodeString(&buf, name)
+		reply, err := h.handleLookup(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("LOOKUP %s: %v", name, err)
+		}
+		data := reply.Data.([]byte)
+		status := binary.BigEndian.Uint32(data)
+		if status != NFS_OK {
+			return status, 0, 0
+		}
+		handle, err := xdrDecodeFileHandle(bytes.NewReader(data[4:]))
+		if err != nil {
+			t.Fatalf("LOOKUP %s: decoding handle: %v", name, err)
+		}
+		// status, handle, then the post_op_attr flag, type, mode, nlink, uid,
+		// gid, size, used, rdev and fsid ahead of the fileid
+		fileID := binary.BigEndian.Uint64(data[4+4+8+4+4*5+8*4:])
+		return status, handle, fileID
+	}
+
+	bigNode, err := nfs.Lookup("/big")
+	if err != nil {
+		t.Fatalf("Lookup /big: %v", err)
+	}
+	nodes, err := nfs.ReadDir(bigNode)
+	if err != nil {
+		t.Fatalf("ReadDir /big: %v", err)
+	}
+	if len(nodes) != 0x28 {
+		t.Fatalf("Expected 40 shards in /big, got %d", len(nodes))
+	}
+	if nodes[0].Name() != "00" || nodes[0x1a].Name() != "1a" || nodes[0x27].Name() != "27" {
+		t.Errorf("Unexpected shard names %q, %q, %q", nodes[0].Name(), nodes[0x1a].Name(), nodes[0x27].Name())
+	}
+
+	bigHandle := getFileHandle(server, "/big")
+	status, shardHandle, shardID := lookup(bigHandle, "1a")
+	if status != NFS_OK {
+		t.Fatalf("LOOKUP /big/1a: expected NFS_OK, got %d", status)
+	}
+	if shardHandle == bigHandle {
+		t.Fatal("Shard shares its directory's handle")
+	}
+	shard, ok := h.lookupNode(shardHandle)
+	if !ok {
+		t.Fatal("Shard handle does not resolve")
+	}
+	members, err := nfs.ReadDir(shard)
+	if err != nil {
+		t.Fatalf("ReadDir /big/1a: %v", err)
+	}
+	if len(members) != 256 || members[0].Name() != "1a00.dat" {
+		t.Errorf("Expected 256 entries in /big/1a starting at 1a00.dat, got %d", len(members))
+	}
+
+	status, _, fileID := lookup(shardHandle, "1a2b.dat")
+	if status != NFS_OK {
+		t.Fatalf("LOOKUP /big/1a/1a2b.dat: expected NFS_OK, got %d", status)
+	}
+	backing, err := nfs.Lookup("/big/1a2b.dat")
+	if err != nil {
+		t.Fatalf("Lookup /big/1a2b.dat: %v", err)
+	}
+	if fileID != backing.attrs.FileId || fileID == shardID {
+		t.Errorf("Shard path resolved to file ID %d, want the backing entry's %d", fileID, backing.attrs.FileId)
+	}
+
+	// Entries are only reachable through their own shard
+	if status, _, _ := lookup(bigHandle, "1a2b.dat"); status != NFSERR_NOENT {
+		t.Errorf("LOOKUP /big/1a2b.dat: expected NFSERR_NOENT, got %d", status)
+	}
+	if status, _, _ := lookup(shardHandle, "002a.dat"); status != NFSERR_NOENT {
+		t.Errorf("LOOKUP /big/1a/002a.dat: expected NFSERR_NOENT, got %d", status)
+	}
+
+	// Directories under the threshold are presented as they are
+	if status, _, _ := lookup(getFileHandle(server, "/small"), "abc.txt"); status != NFS_OK {
+		t.Errorf("LOOKUP /small/abc.txt: expected NFS_OK, got %d", status)
+	}
+}
+
+func TestDirShardEntryChanges(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/big", 0755)
+	for i := 0; i < 10; i++ {
+		for _, prefix := range []string{"aa", "bb"} {
+			f, err := mfs.Create(fmt.Sprintf("/big/%s%02d", prefix, i))
+			if err != nil {
+				t.Fatalf("Failed to create entry: %v", err)
+			}
+			f.Close()
+		}
+	}
+	mfs.Mkdir("/big/aasub", 0755)
+
+	nfs, err := New(mfs, ExportOptions{DirShardThreshold: 10})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	h := &NFSProcedureHandler{server: server}
+	auth := testAuthContext()
+
+	bigHandle := getFileHandle(server, "/big")
+	shardHandle := func(name string) uint64 {
+		t.Helper()
+		reply, err := h.handleLookup(bytes.NewReader(buildLookupRequest(bigHandle, name)), &RPCReply{}, auth)
+		if err != nil || readStatusFromReply(reply) != NFS_OK {
+			t.Fatalf("LOOKUP /big/%s failed: %v", name, err)
+		}
+		handle, err := xdrDecodeFileHandle(bytes.NewReader(getReplyData(reply)[4:]))
+		if err != nil {
+			t.Fatalf("LOOKUP /big/%s: decoding handle: %v", name, err)
+		}
+		return handle
+	}
+	aa, bb := shardHandle("aa"), shardHandle("bb")
+
+	// An empty sattr3: every "set" flag false
+	sattr := make([]byte, 6*4)
+	create := func(dir uint64, name string) uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dir)
+		xdrEncodeString(&buf, name)
+		binary.Write(&buf, binary.BigEndian, uint32(UNCHECKED))
+		buf.Write(sattr)
+		reply, err := h.handleCreate(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("CREATE %s: %v", name, err)
+		}
+		return readStatusFromReply(reply)
+	}
+	mkdir := func(dir uint64, name string) uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dir)
+		xdrEncodeString(&buf, name)
+		buf.Write(sattr)
+		reply, err := h.handleMkdir(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("MKDIR %s: %v", name, err)
+		}
+		return readStatusFromReply(reply)
+	}
+	remove := func(dir uint64, name string) uint32 {
+		t.Helper()
+		reply, err := h.handleRemove(bytes.NewReader(buildRemoveRequest(dir, name)), &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("REMOVE %s: %v", name, err)
+		}
+		return readStatusFromReply(reply)
+	}
+	rmdir := func(dir uint64, name string) uint32 {
+		t.Helper()
+		reply, err := h.handleRmdir(bytes.NewReader(buildRemoveRequest(dir, name)), &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("RMDIR %s: %v", name, err)
+		}
+		return readStatusFromReply(reply)
+	}
+	rename := func(from uint64, fromName string, to uint64, toName string) uint32 {
+		t.Helper()
+		reply, err := h.handleRename(bytes.NewReader(buildRenameRequest(from, fromName, to, toName)), &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("RENAME %s to %s: %v", fromName, toName, err)
+		}
+		return readStatusFromReply(reply)
+	}
+	exists := func(name string) bool {
+		_, err := mfs.Stat("/big/" + name)
+		return err == nil
+	}
+
+	t.Run("CREATE", func(t *testing.T) {
+		if status := create(aa, "bb50"); status != NFSERR_INVAL {
+			t.Errorf("CREATE bb50 in shard aa: expected NFSERR_INVAL, got %d", status)
+		}
+		if exists("bb50") {
+			t.Error("CREATE outside the shard created the file")
+		}
+		if status := create(bigHandle, "cc00"); status != NFSERR_ACCES {
+			t.Errorf("CREATE cc00 in sharded /big: expected NFSERR_ACCES, got %d", status)
+		}
+		if status := create(aa, "aa50"); status != NFS_OK {
+			t.Fatalf("CREATE aa50 in shard aa: expected NFS_OK, got %d", status)
+		}
+		shard, ok := h.lookupNode(aa)
+		if !ok {
+			t.Fatal("Shard handle does not resolve")
+		}
+		members, err := nfs.ReadDir(shard)
+		if err != nil {
+			t.Fatalf("ReadDir /big/aa: %v", err)
+		}
+		var found bool
+		for _, member := range members {
+			found = found || member.Name() == "aa50"
+		}
+		if !found {
+			t.Error("aa50 is not listed in the shard it was created in")
+		}
+	})
+
+	t.Run("MKDIR", func(t *testing.T) {
+		if status := mkdir(aa, "bbdir"); status != NFSERR_INVAL {
+			t.Errorf("MKDIR bbdir in shard aa: expected NFSERR_INVAL, got %d", status)
+		}
+		if status := mkdir(bigHandle, "ccdir"); status != NFSERR_ACCES {
+			t.Errorf("MKDIR ccdir in sharded /big: expected NFSERR_ACCES, got %d", status)
+		}
+		if exists("bbdir") || exists("ccdir") {
+			t.Error("Rejected MKDIR created the directory")
+		}
+	})
+
+	t.Run("REMOVE", func(t *testing.T) {
+		if status := remove(aa, "bb00"); status != NFSERR_INVAL {
+			t.Errorf("REMOVE bb00 in shard aa: expected NFSERR_INVAL, got %d", status)
+		}
+		if status := remove(bigHandle, "bb00"); status != NFSERR_ACCES {
+			t.Errorf("REMOVE bb00 in sharded /big: expected NFSERR_ACCES, got %d", status)
+		}
+		if !exists("bb00") {
+			t.Error("Rejected REMOVE removed the file")
+		}
+		if status := remove(bb, "bb00"); status != NFS_OK || exists("bb00") {
+			t.Errorf("REMOVE bb00 in shard bb: expected NFS_OK and the file gone, got %d", status)
+		}
+	})
+
+	t.Run("RMDIR", func(t *testing.T) {
+		if status := rmdir(bb, "aasub"); status != NFSERR_INVAL {
+			t.Errorf("RMDIR aasub in shard bb: expected NFSERR_INVAL, got %d", status)
+		}
+		if status := rmdir(bigHandle, "aasub"); status != NFSERR_ACCES {
+			t.Errorf("RMDIR aasub in sharded /big: expected NFSERR_ACCES, got %d", status)
+		}
+		if !exists("aasub") {
+			t.Error("Rejected RMDIR removed the directory")
+		}
+	})
+
+	t.Run("RENAME", func(t *testing.T) {
+		if status := rename(aa, "aa01", aa, "bb99"); status != NFSERR_INVAL {
+			t.Errorf("RENAME aa01 to bb99 in shard aa: expected NFSERR_INVAL, got %d", status)
+		}
+		if status := rename(bb, "aa01", bb, "bb99"); status != NFSERR_INVAL {
+			t.Errorf("RENAME aa01 from shard bb: expected NFSERR_INVAL, got %d", status)
+		}
+		if status := rename(bigHandle, "aa01", bb, "bb99"); status != NFSERR_ACCES {
+			t.Errorf("RENAME aa01 from sharded /big: expected NFSERR_ACCES, got %d", status)
+		}
+		if !exists("aa01") || exists("bb99") {
+			t.Fatal("Rejected RENAME moved the file")
+		}
+		if status := rename(aa, "aa01", bb, "bb99"); status != NFS_OK {
+			t.Errorf("RENAME aa01 in shard aa to bb99 in shard bb: expected NFS_OK, got %d", status)
+		}
+		if exists("aa01") || !exists("bb99") {
+			t.Error("RENAME between shards did not move the file")
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDryRunDoesNotMutate(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.DryRun = true })
+	logs := &recordingLogger{}
+	srv.handler.SetLogger(logs)
+	dirH := allocHandle(t, srv, "/dir")
+	fileH := allocHandle(t, srv, "/dir/file.txt")
+
+	var create bytes.Buffer
+	xdrEncodeFileHandle(&create, dirH)
+	xdrEncodeString(&create, "new.txt")
+	binary.Write(&create, binary.BigEndian, uint32(0))
+	create.Write(encodeSattr3(true, 0600, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+	result, err := handler.handleCreate(bytes.NewReader(create.Bytes()), &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleCreate: %v", err)
+	}
+	if status := readStatus(t, result); status != NFS_OK {
+		t.Fatalf("CREATE: expected NFS_OK, got %d", status)
+	}
+	if _, err := srv.handler.fs.Stat("/dir/new.txt"); !os.IsNotExist(err) {
+		t.Fatalf("CREATE reached the backing fs: Stat err = %v", err)
+	}
+
+	write := buildWriteRequest(fileH, 0, []byte("changed"))
+	result, err = handler.handleWrite(bytes.NewReader(write), &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleWrite: %v", err)
+	}
+	if status := readStatus(t, result); status != NFS_OK {
+		t.Fatalf("WRITE: expected NFS_OK, got %d", status)
+	}
+
+	var remove bytes.Buffer
+	xdrEncodeFileHandle(&remove, dirH)
+	xdrEncodeString(&remove, "file.txt")
+	result, err = handler.handleRemove(bytes.NewReader(remove.Bytes()), &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleRemove: %v", err)
+	}
+	if status := readStatus(t, result); status != NFS_OK {
+		t.Fatalf("REMOVE: expected NFS_OK, got %d", status)
+	}
+
+	// The file is still there with its original contents
+	f, err := srv.handler.fs.Open("/dir/file.txt")
+	if err != nil {
+		t.Fatalf("REMOVE reached the backing fs: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "hello" {
+		t.Fatalf("WRITE reached the backing fs: contents = %q", data)
+	}
+
+	// Each mutation was logged with its procedure, CREATE with its path
+	var procs []string
+	var createPath interface{}
+	for _, entry := range logs.infos {
+		if entry[0].Value != "dry-run: mutation not applied" {
+			continue
+		}
+		fields := make(map[string]interface{})
+		for _, field := range entry {
+			fields[field.Key] = field.Value
+		}
+		procs = append(procs, fields["proc"].(string))
+		if fields["proc"] == "CREATE" {
+			createPath = fields["path"]
+		}
+	}
+	if len(procs) != 3 || procs[0] != "CREATE" || procs[1] != "WRITE" || procs[2] != "REMOVE" {
+		t.Fatalf("Expected CREATE, WRITE and REMOVE to be logged, got %v", procs)
+	}
+	if createPath != "/dir/new.txt" {
+		t.Fatalf("CREATE logged path %v, want /dir/new.txt", createPath)
+	}
+}
@@ -1,10 +1,17 @@
-// errors.go: Typed error definitions for NFS operations.
+// errors.go: Typed error definitions and error-to-status mapping.
 //
-// Contains InvalidFileHandleError, StaleFileHandleError, and related
-// error constructors used throughout the NFS handler layer.
+// Contains InvalidFileHandleError, NotSupportedError, and
+// MapErrorToNFSStatus, the single translation from Go errors to NFS3
+// status codes used throughout the NFS handler layer.
 package absnfs
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
 
 // InvalidFileHandleError represents an error when a file handle is invalid
 type InvalidFileHandleError struct {
@@ -33,3 +40,79 @@ func (e *NotSupportedError) Error() string {
 	}
 	return fmt.Sprintf("operation '%s' not supported", e.Operation)
 }
+
+// opError wraps err, returned while performing op on path, as
+// `op "path": err`. Operation-layer methods wrap every failure this way so
+// a logged error names the failing path, while errors.Is, errors.As and
+// MapErrorToNFSStatus still see the backing error.
+func opError(op, path string, err error) error {
+	return fmt.Errorf("%s %q: %w", op, path, err)
+}
+
+// MapErrorToNFSStatus converts an error returned by an absfs filesystem (or
+// by this package) to the NFS3 status code a client should see. Errors are
+// matched with errors.Is/errors.As, so wrapped errors map like their cause.
+// Unrecognized errors map to NFSERR_IO.
+//
+// Custom handlers and middleware should use this rather than their own
+// mapping so clients see the same status the built-in procedures return.
+func MapErrorToNFSStatus(err error) uint32 {
+	// Check custom errors first
+	var invalidHandle *InvalidFileHandleError
+	var notSupported *NotSupportedError
+
+	// Order matters where the standard library treats errors as equivalent:
+	// EPERM also matches os.ErrPermission and ENOTEMPTY also matches os.ErrExist.
+	switch {
+	case err == nil:
+		return NFS_OK
+	case errors.As(err, &invalidHandle):
+		return NFSERR_BADHANDLE
+	case errors.As(err, &notSupported) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP):
+		return NFSERR_NOTSUPP
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout):
+		return NFSERR_DELAY
+	case errors.Is(err, context.Canceled):
+		// The request was abandoned (client gone or server stopping); ask for a retry
+		return NFSERR_DELAY
+	case errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOENT):
+		return NFSERR_NOENT
+	case errors.Is(err, syscall.EPERM):
+		return NFSERR_PERM
+	case errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EACCES):
+		return NFSERR_ACCES
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return NFSERR_NOTEMPTY
+	case errors.Is(err, os.ErrExist) || errors.Is(err, syscall.EEXIST):
+		return NFSERR_EXIST
+	case errors.Is(err, os.ErrInvalid) || errors.Is(err, syscall.EINVAL):
+		return NFSERR_INVAL
+	case errors.Is(err, syscall.ENOTDIR):
+		return NFSERR_NOTDIR
+	case errors.Is(err, syscall.EISDIR):
+		return NFSERR_ISDIR
+	case errors.Is(err, syscall.EMLINK) || errors.Is(err, syscall.ELOOP):
+		// NFS3 has no loop status; too many links is the closest
+		return NFSERR_MLINK
+	case errors.Is(err, syscall.EROFS):
+		return NFSERR_ROFS
+	case errors.Is(err, syscall.EXDEV):
+		return NFSERR_XDEV
+	case errors.Is(err, syscall.ENOSPC):
+		return NFSERR_NOSPC
+	case errors.Is(err, syscall.EDQUOT):
+		return NFSERR_DQUOT
+	case errors.Is(err, syscall.EFBIG):
+		return NFSERR_FBIG
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		return NFSERR_NAMETOOLONG
+	case errors.Is(err, syscall.ESTALE):
+		return NFSERR_STALE
+	case errors.Is(err, syscall.ENXIO):
+		return NFSERR_NXIO
+	case errors.Is(err, syscall.ENODEV):
+		return NFSERR_NODEV
+	default:
+		return NFSERR_IO
+	}
+}
@@ -1,9 +1,11 @@
 package absnfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"syscall"
 	"testing"
 )
 
@@ -97,7 +99,7 @@ func TestNotSupportedError(t *testing.T) {
 	}
 }
 
-// TestMapErrorWithCustomErrors tests that mapError correctly maps custom errors
+// TestMapErrorWithCustomErrors tests that MapErrorToNFSStatus correctly maps custom errors
 func TestMapErrorWithCustomErrors(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -145,9 +147,9 @@ func TestMapErrorWithCustomErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := mapError(tt.err)
+			result := MapErrorToNFSStatus(tt.err)
 			if result != tt.expected {
-				t.Errorf("mapError() = %d, want %d", result, tt.expected)
+				t.Errorf("MapErrorToNFSStatus() = %d, want %d", result, tt.expected)
 			}
 		})
 	}
@@ -442,3 +444,78 @@ func TestErrorTypeDetection(t *testing.T) {
 		})
 	}
 }
+
+// TestMapErrorToNFSStatus tests the canonical error-to-status mapping
+func TestMapErrorToNFSStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected uint32
+	}{
+		{"nil", nil, NFS_OK},
+		{"ErrNotExist", os.ErrNotExist, NFSERR_NOENT},
+		{"ENOENT", syscall.ENOENT, NFSERR_NOENT},
+		{"ErrPermission", os.ErrPermission, NFSERR_ACCES},
+		{"EACCES", syscall.EACCES, NFSERR_ACCES},
+		{"EPERM", syscall.EPERM, NFSERR_PERM},
+		{"ErrExist", os.ErrExist, NFSERR_EXIST},
+		{"ENOTEMPTY", syscall.ENOTEMPTY, NFSERR_NOTEMPTY},
+		{"ErrInvalid", os.ErrInvalid, NFSERR_INVAL},
+		{"EINVAL", syscall.EINVAL, NFSERR_INVAL},
+		{"ENOTDIR", syscall.ENOTDIR, NFSERR_NOTDIR},
+		{"EISDIR", syscall.EISDIR, NFSERR_ISDIR},
+		{"EROFS", syscall.EROFS, NFSERR_ROFS},
+		{"EMLINK", syscall.EMLINK, NFSERR_MLINK},
+		{"ELOOP", syscall.ELOOP, NFSERR_MLINK},
+		{"EXDEV", syscall.EXDEV, NFSERR_XDEV},
+		{"ENOSPC", syscall.ENOSPC, NFSERR_NOSPC},
+		{"EDQUOT", syscall.EDQUOT, NFSERR_DQUOT},
+		{"EFBIG", syscall.EFBIG, NFSERR_FBIG},
+		{"ENAMETOOLONG", syscall.ENAMETOOLONG, NFSERR_NAMETOOLONG},
+		{"ESTALE", syscall.ESTALE, NFSERR_STALE},
+		{"ENXIO", syscall.ENXIO, NFSERR_NXIO},
+		{"ENODEV", syscall.ENODEV, NFSERR_NODEV},
+		{"ENOTSUP", syscall.ENOTSUP, NFSERR_NOTSUPP},
+		{"EBUSY", syscall.EBUSY, NFSERR_IO},
+		{"ETXTBSY", syscall.ETXTBSY, NFSERR_IO},
+		{"NotSupportedError", &NotSupportedError{Operation: "LINK"}, NFSERR_NOTSUPP},
+		{"InvalidFileHandleError", &InvalidFileHandleError{Handle: 1}, NFSERR_BADHANDLE},
+		{"DeadlineExceeded", context.DeadlineExceeded, NFSERR_DELAY},
+		{"ErrTimeout", ErrTimeout, NFSERR_DELAY},
+		{"Canceled", context.Canceled, NFSERR_DELAY},
+		{"PathError wrapping EROFS", &os.PathError{Op: "open", Path: "/x", Err: syscall.EROFS}, NFSERR_ROFS},
+		{"LinkError wrapping EXDEV", &os.LinkError{Op: "rename", Old: "/a", New: "/b", Err: syscall.EXDEV}, NFSERR_XDEV},
+		{"wrapped ENOSPC", fmt.Errorf("write: %w", syscall.ENOSPC), NFSERR_NOSPC},
+		{"unknown", errors.New("something else"), NFSERR_IO},
+		{"unknown errno", syscall.EIO, NFSERR_IO},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MapErrorToNFSStatus(tt.err); got != tt.expected {
+				t.Errorf("MapErrorToNFSStatus(%v) = %d, want %d", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteErrorStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected uint32
+	}{
+		{"EBUSY", &os.PathError{Op: "write", Path: "/x", Err: syscall.EBUSY}, NFSERR_JUKEBOX},
+		{"ETXTBSY", syscall.ETXTBSY, NFSERR_JUKEBOX},
+		{"ENOSPC", syscall.ENOSPC, NFSERR_NOSPC},
+		{"not exist", os.ErrNotExist, NFSERR_STALE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writeErrorStatus(tt.err); got != tt.expected {
+				t.Errorf("writeErrorStatus(%v) = %d, want %d", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// exports.go: Declarative export table loaded from an exports(5)-style file.
+//
+// Parses lines of the form "/path client(opts) client(opts)" into an
+// ExportTable and installs it on a Server. Once installed, the table
+// gates MOUNT requests by path and client address, is listed by MOUNT
+// EXPORT, and marks clients whose matching spec is "ro" as read-only
+// for NFS calls. Calling LoadExports again replaces the table.
+package absnfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// ExportClient is a single client specification within an export entry
+type ExportClient struct {
+	Host     string // "*", an IP address, or a CIDR subnet
+	ReadOnly bool   // true for "ro" (the exports(5) default), false for "rw"
+}
+
+// ExportEntry is one exported path and the clients allowed to mount it
+type ExportEntry struct {
+	Path    string
+	Clients []ExportClient
+}
+
+// ExportTable is the parsed contents of an exports file
+type ExportTable struct {
+	Entries []ExportEntry
+}
+
+// ignoredExportOptions are exports(5) options that are accepted for
+// compatibility but have no effect on this server.
+var ignoredExportOptions = map[string]bool{
+	"sync":             true,
+	"async":            true,
+	"wdelay":           true,
+	"no_wdelay":        true,
+	"subtree_check":    true,
+	"no_subtree_check": true,
+	"secure":           true,
+	"insecure":         true,
+}
+
+// ParseExports parses an exports(5)-style table. Blank lines and '#'
+// comments are skipped and a trailing backslash continues a line. Only
+// IP, CIDR and "*" client specs are supported; hostnames and netgroups
+// are rejected rather than silently never matching.
+func ParseExports(r io.Reader) (*ExportTable, error) {
+	table := &ExportTable{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	var pending string
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			pending += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line = pending + line
+		pending = ""
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry, err := parseExportEntry(fields)
+		if err != nil {
+			return nil, fmt.Errorf("exports line %d: %w", lineNo, err)
+		}
+		table.Entries = append(table.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read exports: %w", err)
+	}
+	return table, nil
+}
+
+// parseExportEntry parses the whitespace-separated fields of one line
+func parseExportEntry(fields []string) (ExportEntry, error) {
+	if !strings.HasPrefix(fields[0], "/") {
+		return ExportEntry{}, fmt.Errorf("export path %q must be absolute", fields[0])
+	}
+	entry := ExportEntry{Path: path.Clean(fields[0])}
+
+	// A path with no client list is exported read-only to everyone
+	if len(fields) == 1 {
+		entry.Clients = []ExportClient{{Host: "*", ReadOnly: true}}
+		return entry, nil
+	}
+
+	for _, spec := range fields[1:] {
+		client, err := parseExportClient(spec)
+		if err != nil {
+			return ExportEntry{}, err
+		}
+		entry.Clients = append(entry.Clients, client)
+	}
+	return entry, nil
+}
+
+// parseExportClient parses a "host(opt,opt)" client specification
+func parseExportClient(spec string) (ExportClient, error) {
+	client := ExportClient{Host: spec, ReadOnly: true}
+
+	var opts string
+	if i := strings.IndexByte(spec, '('); i >= 0 {
+		if !strings.HasSuffix(spec, ")") {
+			return ExportClient{}, fmt.Errorf("unterminated option list in %q", spec)
+		}
+		client.Host = spec[:i]
+		opts = spec[i+1 : len(spec)-1]
+	}
+	if client.Host == "" {
+		return ExportClient{}, fmt.Errorf("missing client in %q", spec)
+	}
+	if exportClientPrefixLen(client.Host) < -1 {
+		return ExportClient{}, fmt.Errorf("unsupported client %q (only *, IP addresses and CIDR subnets are supported)", client.Host)
+	}
+
+	if opts != "" {
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt = strings.TrimSpace(opt); {
+			case opt == "ro":
+				client.ReadOnly = true
+			case opt == "rw":
+				client.ReadOnly = false
+			case ignoredExportOptions[opt]:
+			default:
+				return ExportClient{}, fmt.Errorf("unsupported export option %q for client %q", opt, client.Host)
+			}
+		}
+	}
+	return client, nil
+}
+
+// exportClientPrefixLen returns how specific a client host is: -1 for
+// "*", the prefix length for a CIDR subnet, the full address length for
+// a single IP, and -2 if the host cannot be parsed.
+func exportClientPrefixLen(host string) int {
+	if host == "*" {
+		return -1
+	}
+	if strings.Contains(host, "/") {
+		_, subnet, err := net.ParseCIDR(host)
+		if err != nil {
+			return -2
+		}
+		ones, _ := subnet.Mask.Size()
+		return ones
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return -2
+	}
+	return len(normalizeIP(ip)) * 8
+}
+
+// match returns the most specific client spec in the entry that covers
+// clientIP, following exports(5) semantics when several specs overlap.
+func (e *ExportEntry) match(clientIP string) (ExportClient, bool) {
+	best := -2
+	var found ExportClient
+	for _, c := range e.Clients {
+		if c.Host != "*" && !isIPAllowed(clientIP, []string{c.Host}) {
+			continue
+		}
+		if n := exportClientPrefixLen(c.Host); n > best {
+			best = n
+			found = c
+		}
+	}
+	return found, best > -2
+}
+
+// lookup returns the entry with the longest path covering mountPath
+func (t *ExportTable) lookup(mountPath string) (*ExportEntry, bool) {
+	var best *ExportEntry
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		if e.Path != "/" && mountPath != e.Path && !strings.HasPrefix(mountPath, e.Path+"/") {
+			continue
+		}
+		if best == nil || len(e.Path) > len(best.Path) {
+			best = e
+		}
+	}
+	return best, best != nil
+}
+
+// clientAccess reports whether clientIP appears in any export entry and,
+// if so, whether it must be treated as read-only. File handles are not
+// tied to the export they were mounted through, so a client that is "ro"
+// in any matching entry is read-only for every NFS call.
+func (t *ExportTable) clientAccess(clientIP string) (readOnly, allowed bool) {
+	for i := range t.Entries {
+		c, ok := t.Entries[i].match(clientIP)
+		if !ok {
+			continue
+		}
+		allowed = true
+		readOnly = readOnly || c.ReadOnly
+	}
+	return readOnly, allowed
+}
+
+// LoadExports parses the exports file at path and installs it as the
+// server's export table, replacing any previously loaded table. The swap
+// waits for in-flight requests to drain so no request observes a mix of
+// old and new access rules. On error the current table is left in place.
+func (s *Server) LoadExports(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open exports file: %w", err)
+	}
+	defer f.Close()
+
+	table, err := ParseExports(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if s.handler != nil {
+		s.handler.policyRWMu.Lock()
+		defer s.handler.policyRWMu.Unlock()
+	}
+	s.exports.Store(table)
+	return nil
+}
+
+// Exports returns the currently loaded export table, or nil if
+// LoadExports has not been called.
+func (s *Server) Exports() *ExportTable {
+	return s.exports.Load()
+}
@@ -0,0 +1,214 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func newExportsTestServer(t *testing.T) (*Server, *NFSProcedureHandler) {
+	t.Helper()
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	if err := mfs.Mkdir("/data", 0777); err != nil {
+		t.Fatalf("Failed to create /data: %v", err)
+	}
+	for _, name := range []string{"/data/a", "/data/b"} {
+		f, err := mfs.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Close()
+	}
+	nfs, err := New(mfs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	return server, &NFSProcedureHandler{server: server}
+}
+
+func writeExportsFile(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "exports")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write exports file: %v", err)
+	}
+	return p
+}
+
+// callAs issues an NFS or MOUNT call through HandleCall from the given client IP
+func callAs(t *testing.T, h *NFSProcedureHandler, clientIP string, prog, vers, proc uint32, args []byte) *RPCReply {
+	t.Helper()
+	call := &RPCCall{
+		Header: RPCMsgHeader{
+			Xid: 1, MsgType: RPC_CALL, RPCVersion: 2,
+			Program: prog, Version: vers, Procedure: proc,
+		},
+		Credential: RPCCredential{Flavor: AUTH_NONE, Body: []byte{}},
+	}
+	authCtx := &AuthContext{ClientIP: clientIP, ClientPort: 1023, Credential: &call.Credential}
+	reply, err := h.HandleCall(call, bytes.NewReader(args), authCtx)
+	if err != nil {
+		t.Fatalf("HandleCall failed: %v", err)
+	}
+	return reply
+}
+
+func TestLoadExportsReadOnlySubnet(t *testing.T) {
+	server, h := newExportsTestServer(t)
+	exportsPath := writeExportsFile(t, "# test exports\n/data 10.0.0.0/8(ro,no_subtree_check) 192.168.1.5(rw)\n")
+
+	if err := server.LoadExports(exportsPath); err != nil {
+		t.Fatalf("LoadExports failed: %v", err)
+	}
+
+	table := server.Exports()
+	if len(table.Entries) != 1 || table.Entries[0].Path != "/data" {
+		t.Fatalf("Expected a single /data export, got %+v", table.Entries)
+	}
+	if c := table.Entries[0].Clients[0]; c.Host != "10.0.0.0/8" || !c.ReadOnly {
+		t.Errorf("Expected 10.0.0.0/8 to be read-only, got %+v", c)
+	}
+
+	dirHandle := getFileHandle(server, "/data")
+
+	t.Run("client in ro subnet gets ROFS", func(t *testing.T) {
+		for _, ip := range []string{"10.0.0.1", "10.200.3.4"} {
+			reply := callAs(t, h, ip, NFS_PROGRAM, NFS_V3, NFSPROC3_REMOVE, buildRemoveRequest(dirHandle, "a"))
+			if status := readStatusFromReply(reply); status != NFSERR_ROFS {
+				t.Errorf("%s: expected NFSERR_ROFS, got %d", ip, status)
+			}
+		}
+		if _, err := server.handler.Lookup("/data/a"); err != nil {
+			t.Errorf("Expected /data/a to survive read-only REMOVE: %v", err)
+		}
+	})
+
+	t.Run("rw client can modify", func(t *testing.T) {
+		reply := callAs(t, h, "192.168.1.5", NFS_PROGRAM, NFS_V3, NFSPROC3_REMOVE, buildRemoveRequest(dirHandle, "b"))
+		if status := readStatusFromReply(reply); status != NFS_OK {
+			t.Fatalf("Expected NFS_OK, got %d", status)
+		}
+		if _, err := server.handler.Lookup("/data/b"); err == nil {
+			t.Error("Expected /data/b to be removed")
+		}
+	})
+
+	t.Run("client outside table is denied", func(t *testing.T) {
+		reply := callAs(t, h, "172.16.0.1", NFS_PROGRAM, NFS_V3, NFSPROC3_NULL, nil)
+		if reply.Status != MSG_DENIED {
+			t.Errorf("Expected MSG_DENIED, got %d", reply.Status)
+		}
+	})
+
+	t.Run("MNT respects paths and clients", func(t *testing.T) {
+		var args bytes.Buffer
+		xdrEncodeString(&args, "/data")
+		reply := callAs(t, h, "10.1.1.1", MOUNT_PROGRAM, MOUNT_V3, 1, args.Bytes())
+		if status := readStatusFromReply(reply); status != 0 {
+			t.Errorf("Expected MNT3_OK for /data, got %d", status)
+		}
+
+		args.Reset()
+		xdrEncodeString(&args, "/")
+		reply = callAs(t, h, "10.1.1.1", MOUNT_PROGRAM, MOUNT_V3, 1, args.Bytes())
+		if status := readStatusFromReply(reply); status != 13 {
+			t.Errorf("Expected MNT3ERR_ACCES for unexported /, got %d", status)
+		}
+	})
+
+	t.Run("EXPORT lists table entries", func(t *testing.T) {
+		reply := callAs(t, h, "10.1.1.1", MOUNT_PROGRAM, MOUNT_V3, 5, nil)
+		r := bytes.NewReader(getReplyData(reply))
+		var more uint32
+		binary.Read(r, binary.BigEndian, &more)
+		dir, _ := xdrDecodeString(r)
+		var groups []string
+		for {
+			binary.Read(r, binary.BigEndian, &more)
+			if more == 0 {
+				break
+			}
+			g, _ := xdrDecodeString(r)
+			groups = append(groups, g)
+		}
+		if dir != "/data" || strings.Join(groups, " ") != "10.0.0.0/8 192.168.1.5" {
+			t.Errorf("Unexpected export listing: dir=%q groups=%v", dir, groups)
+		}
+	})
+}
+
+func TestLoadExportsReload(t *testing.T) {
+	server, h := newExportsTestServer(t)
+	exportsPath := writeExportsFile(t, "/data 10.0.0.0/8(ro)\n")
+	if err := server.LoadExports(exportsPath); err != nil {
+		t.Fatalf("LoadExports failed: %v", err)
+	}
+	dirHandle := getFileHandle(server, "/data")
+
+	if err := os.WriteFile(exportsPath, []byte("/data 10.0.0.0/8(rw)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.LoadExports(exportsPath); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	reply := callAs(t, h, "10.0.0.1", NFS_PROGRAM, NFS_V3, NFSPROC3_REMOVE, buildRemoveRequest(dirHandle, "a"))
+	if status := readStatusFromReply(reply); status != NFS_OK {
+		t.Errorf("Expected NFS_OK after reloading as rw, got %d", status)
+	}
+
+	// A bad file leaves the previous table in place
+	if err := os.WriteFile(exportsPath, []byte("/data host.example(rw)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.LoadExports(exportsPath); err == nil {
+		t.Fatal("Expected error for hostname client spec")
+	}
+	if c := server.Exports().Entries[0].Clients[0]; c.ReadOnly {
+		t.Errorf("Expected previous rw table to remain, got %+v", c)
+	}
+}
+
+func TestParseExports(t *testing.T) {
+	table, err := ParseExports(strings.NewReader("/pub\n/data * 10.0.0.0/8(rw) \\\n  10.1.0.0/16(ro,sync)\n"))
+	if err != nil {
+		t.Fatalf("ParseExports failed: %v", err)
+	}
+	if len(table.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(table.Entries))
+	}
+	if c := table.Entries[0].Clients; len(c) != 1 || c[0].Host != "*" || !c[0].ReadOnly {
+		t.Errorf("Expected bare path to default to *(ro), got %+v", c)
+	}
+
+	data := table.Entries[1]
+	tests := []struct {
+		ip       string
+		host     string
+		readOnly bool
+	}{
+		{"10.1.2.3", "10.1.0.0/16", true}, // most specific subnet wins
+		{"10.2.0.1", "10.0.0.0/8", false}, // only the /8 matches
+		{"192.0.2.1", "*", true},          // wildcard default is ro
+	}
+	for _, tt := range tests {
+		c, ok := data.match(tt.ip)
+		if !ok || c.Host != tt.host || c.ReadOnly != tt.readOnly {
+			t.Errorf("match(%s) = %+v, %v; want host %s readOnly %v", tt.ip, c, ok, tt.host, tt.readOnly)
+		}
+	}
+
+	for _, bad := range []string{"data *(ro)\n", "/data *(ro\n", "/data *(nohide)\n"} {
+		if _, err := ParseExports(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// features.go: Out-of-band feature discovery for management tools.
+//
+// Features reports the server version, the protocol versions it speaks,
+// and which optional behaviors are enabled under the current options.
+// FeaturesHandler serves the same document as JSON so tools can
+// introspect a running export over HTTP without issuing NFS calls.
+package absnfs
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Features describes what an export supports and has enabled
+type Features struct {
+	Version       string   `json:"version"`
+	NFSVersions   []uint32 `json:"nfs_versions"`
+	MountVersions []uint32 `json:"mount_versions"`
+
+	// Capabilities of the server and its backing filesystem
+	Symlinks     bool `json:"symlinks"`      // SYMLINK and READLINK
+	HardLinks    bool `json:"hard_links"`    // LINK
+	SpecialFiles bool `json:"special_files"` // MKNOD
+
+	// Behaviors enabled by the current options
+	Locking         bool `json:"locking"` // NLM byte-range locking
+	TLS             bool `json:"tls"`
+	ReadOnly        bool `json:"read_only"`
+	ReaddirPlus     bool `json:"readdir_plus"`
+	NegativeCache   bool `json:"negative_cache"`
+	DirCache        bool `json:"dir_cache"`
+	AsyncWrites     bool `json:"async_writes"`
+	RateLimiting    bool `json:"rate_limiting"`
+	ConfineSymlinks bool `json:"confine_symlinks"`
+	PinnedTime      bool `json:"pinned_time"`
+}
+
+// Features returns the features of the export under its current options.
+// NFSVersions are those the Server it was handed to enables.
+func (n *AbsfsNFS) Features() Features {
+	tuning := n.tuning.Load()
+	policy := n.policy.Load()
+	nfsVersions := n.nfsVersions
+	if nfsVersions == nil {
+		// Not yet served; a Server enables NFSv3 alone by default
+		nfsVersions = []uint32{NFS_V3}
+	}
+	return Features{
+		Version:         Version,
+		NFSVersions:     nfsVersions,
+		MountVersions:   []uint32{1, MOUNT_V3},
+		Symlinks:        true,
+		HardLinks:       n.linker != nil,
+		SpecialFiles:    n.mknoder != nil,
+		Locking:         tuning.EnableLocking,
+		TLS:             policy.TLS != nil && policy.TLS.Enabled,
+		ReadOnly:        policy.ReadOnly,
+		ReaddirPlus:     !tuning.DisableReaddirPlus,
+		NegativeCache:   tuning.CacheNegativeLookups,
+		DirCache:        tuning.EnableDirCache,
+		AsyncWrites:     tuning.Async,
+		RateLimiting:    policy.EnableRateLimiting,
+		ConfineSymlinks: policy.ConfineSymlinks,
+		PinnedTime:      policy.PinnedTime != nil,
+	}
+}
+
+// FeaturesHandler returns an http.Handler that serves Features as JSON.
+// Mount it wherever suits, e.g. mux.Handle("/features", nfs.FeaturesHandler()).
+func (n *AbsfsNFS) FeaturesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n.Features())
+	})
+}
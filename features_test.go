@@ -0,0 +1,78 @@
+package absnfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestFeaturesHandler(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{TLS: &TLSConfig{Enabled: true}, DisableReaddirPlus: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+
+	srv := httptest.NewServer(nfs.FeaturesHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %q", ct)
+	}
+
+	var doc struct {
+		Version     string   `json:"version"`
+		NFSVersions []uint32 `json:"nfs_versions"`
+		Locking     bool     `json:"locking"`
+		TLS         bool     `json:"tls"`
+		ReaddirPlus bool     `json:"readdir_plus"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode features: %v", err)
+	}
+	if doc.Version != Version || len(doc.NFSVersions) != 1 || doc.NFSVersions[0] != 3 {
+		t.Errorf("Unexpected version info: %+v", doc)
+	}
+	if doc.Locking || !doc.TLS || doc.ReaddirPlus {
+		t.Errorf("Expected locking=false tls=true readdir_plus=false, got %+v", doc)
+	}
+
+	resp, err = http.Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", resp.StatusCode)
+	}
+
+	locking, err := New(mfs, ExportOptions{EnableLocking: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer locking.Close()
+	if !locking.Features().Locking {
+		t.Error("Expected locking=true with EnableLocking")
+	}
+
+	server, err := NewServer(ServerOptions{EnabledVersions: []int{NFS_V3, NFS_V2}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetHandler(locking)
+	if got := locking.Features().NFSVersions; len(got) != 2 || got[0] != NFS_V2 || got[1] != NFS_V3 {
+		t.Errorf("NFSVersions = %v with NFSv2 enabled, want [2 3]", got)
+	}
+}
@@ -3,10 +3,15 @@
 // Contains FileHandleMap methods for allocating, looking up, releasing,
 // and evicting file handles. Uses a min-heap for O(log n) handle ID
 // reuse and supports LRU eviction when the handle limit is reached.
+// Every lookup of a handle refreshes its last-use time, so eviction and
+// the optional idle expiry (HandleIdleTimeout) only remove handles that
+// clients have stopped using.
 package absnfs
 
 import (
-	"math"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"github.com/absfs/absfs"
 )
@@ -20,22 +25,49 @@ const DefaultMaxHandles = 100000
 // the same path, updates the file reference and returns the existing handle.
 // This prevents unbounded handle growth from repeated LOOKUP/READDIRPLUS calls.
 func (fm *FileHandleMap) Allocate(f absfs.File) uint64 {
+	now := time.Now().UnixNano()
+	defer fm.expireIdle(now)
+
+	// With persistent handles the handle comes from the file's identity,
+	// derived before taking the lock since it stats the file
+	node, isNode := f.(*NFSNode)
+	var derived uint64
+	var persistent bool
+	if isNode && node.path != "" && fm.resolver != nil {
+		derived, persistent = fm.resolver.handleFor(node)
+	}
+
 	fm.Lock()
 	defer fm.Unlock()
 
+	// A derived handle already held by another path is a collision; this
+	// file gets a per-process handle rather than taking it over
+	if persistent {
+		if other, ok := fm.handles[derived].(*NFSNode); ok && other.handleKey() != node.handleKey() {
+			persistent = false
+		}
+	}
+
 	// Deduplicate by path for NFSNode files
-	if node, ok := f.(*NFSNode); ok && node.path != "" {
-		if existing, found := fm.pathHandles[node.path]; found {
-			// Update the file reference (may have newer attrs) and return existing handle
-			fm.handles[existing] = f
-			return existing
+	if isNode && node.path != "" {
+		if existing, found := fm.pathHandles[node.handleKey()]; found {
+			if !persistent || existing == derived {
+				// Update the file reference (may have newer attrs) and return existing handle
+				fm.handles[existing] = f
+				fm.touch(existing, now)
+				return existing
+			}
+			// The path now holds a different file; its old handle is stale
+			fm.remove(existing)
 		}
 	}
 
 	var handle uint64
 
-	// First, try to reuse a freed handle (prefer smallest available)
-	if val, ok := fm.freeHandles.PopMin(); ok {
+	if persistent {
+		handle = derived
+	} else if val, ok := fm.freeHandles.PopMin(); ok {
+		// Reuse a freed handle (prefer smallest available)
 		handle = val
 	} else {
 		// No freed handles available, use the next sequential handle
@@ -44,10 +76,12 @@ func (fm *FileHandleMap) Allocate(f absfs.File) uint64 {
 	}
 
 	fm.handles[handle] = f
+	fm.touch(handle, now)
+	fm.peak = max(fm.peak, len(fm.handles))
 
 	// Record path mapping for NFSNode files
-	if node, ok := f.(*NFSNode); ok && node.path != "" {
-		fm.pathHandles[node.path] = handle
+	if isNode && node.path != "" {
+		fm.pathHandles[node.handleKey()] = handle
 	}
 
 	// Evict oldest handles if map exceeds maxHandles
@@ -60,47 +94,87 @@ func (fm *FileHandleMap) Allocate(f absfs.File) uint64 {
 		if evictCount < 1 {
 			evictCount = 1
 		}
-		// Find the lowest handle numbers (oldest) to evict
-		minHandle := uint64(math.MaxUint64)
+		// Evict the least recently used handles, oldest first on ties,
+		// never the one just allocated
+		victims := make([]uint64, 0, len(fm.handles)-1)
 		for h := range fm.handles {
-			if h < minHandle {
-				minHandle = h
+			if h != handle {
+				victims = append(victims, h)
 			}
 		}
-		// Evict starting from the lowest handles
-		for h := minHandle; evictCount > 0; h++ {
-			if file, exists := fm.handles[h]; exists {
-				// Clean up path mapping for evicted entries
-				if node, ok := file.(*NFSNode); ok {
-					delete(fm.pathHandles, node.path)
-				}
-				file.Close()
-				delete(fm.handles, h)
-				fm.freeHandles.PushValue(h)
-				evictCount--
+		sort.Slice(victims, func(i, j int) bool {
+			ui, uj := fm.lastUsed[victims[i]].Load(), fm.lastUsed[victims[j]].Load()
+			if ui != uj {
+				return ui < uj
 			}
+			return victims[i] < victims[j]
+		})
+		if evictCount > len(victims) {
+			evictCount = len(victims)
+		}
+		for _, h := range victims[:evictCount] {
+			fm.remove(h)
 		}
 	}
 
 	return handle
 }
 
-// Get retrieves the absfs.File associated with the given handle
+// Get retrieves the absfs.File associated with the given handle and
+// refreshes its last-use time. A handle that has been idle for longer than
+// the idle timeout is reported missing even before a sweep removes it.
+// With persistent handles, a handle the map does not hold -- one issued
+// before a restart, or evicted since -- is recovered from the file it was
+// derived from when that file still exists.
 func (fm *FileHandleMap) Get(handle uint64) (absfs.File, bool) {
+	now := time.Now().UnixNano()
+	defer fm.expireIdle(now)
+
+	if f, exists := fm.get(handle, now); exists {
+		return f, true
+	}
+	if fm.resolver == nil {
+		return nil, false
+	}
+	node, ok := fm.resolver.resolve(handle)
+	if !ok {
+		return nil, false
+	}
+
+	fm.Lock()
+	defer fm.Unlock()
+	if existing, found := fm.pathHandles[node.handleKey()]; found && existing != handle {
+		fm.remove(existing)
+	}
+	fm.handles[handle] = node
+	fm.pathHandles[node.handleKey()] = handle
+	fm.touch(handle, now)
+	fm.peak = max(fm.peak, len(fm.handles))
+	return node, true
+}
+
+// get looks handle up in the map, refreshing its last-use time
+func (fm *FileHandleMap) get(handle uint64, now int64) (absfs.File, bool) {
 	fm.RLock()
 	defer fm.RUnlock()
 
 	f, exists := fm.handles[handle]
-	return f, exists
+	if !exists {
+		return nil, false
+	}
+	if used := fm.lastUsed[handle]; used != nil {
+		if ttl := fm.idleTimeout.Load(); ttl > 0 && now-used.Load() > ttl {
+			return nil, false
+		}
+		used.Store(now)
+	}
+	return f, true
 }
 
 // GetOrError retrieves the absfs.File associated with the given handle
 // Returns an InvalidFileHandleError if the handle is not found
 func (fm *FileHandleMap) GetOrError(handle uint64) (absfs.File, error) {
-	fm.RLock()
-	defer fm.RUnlock()
-
-	f, exists := fm.handles[handle]
+	f, exists := fm.Get(handle)
 	if !exists {
 		return nil, &InvalidFileHandleError{
 			Handle: handle,
@@ -115,18 +189,91 @@ func (fm *FileHandleMap) Release(handle uint64) {
 	fm.Lock()
 	defer fm.Unlock()
 
-	if f, exists := fm.handles[handle]; exists {
-		// Clean up path mapping
-		if node, ok := f.(*NFSNode); ok {
-			delete(fm.pathHandles, node.path)
-		}
-		f.Close()
-		delete(fm.handles, handle)
-		// Add the freed handle to the free list for reuse
+	fm.remove(handle)
+}
+
+// remove closes and forgets handle, returning its ID to the free list.
+// Callers hold the write lock.
+func (fm *FileHandleMap) remove(handle uint64) {
+	// Add the freed handle to the free list for reuse; derived
+	// persistent handles are not sequential IDs and are never reused, nor
+	// are handles issued before the map was given an export number
+	if fm.discard(handle) && handle&^handleIDMask == fm.prefix && handle < fm.nextHandle {
 		fm.freeHandles.PushValue(handle)
 	}
 }
 
+// discard closes and forgets handle without reusing its ID, reporting
+// whether the map held it. Callers hold the write lock.
+func (fm *FileHandleMap) discard(handle uint64) bool {
+	f, exists := fm.handles[handle]
+	if !exists {
+		return false
+	}
+	// Clean up path mapping
+	if node, ok := f.(*NFSNode); ok {
+		delete(fm.pathHandles, node.handleKey())
+	}
+	f.Close()
+	delete(fm.handles, handle)
+	delete(fm.lastUsed, handle)
+	return true
+}
+
+// setExportNumber makes the map issue handles carrying export number n,
+// for Server.AddExport. Sequential handles continue from the current
+// count under the new number.
+func (fm *FileHandleMap) setExportNumber(n uint64) {
+	fm.Lock()
+	defer fm.Unlock()
+	fm.prefix = n << exportHandleShift
+	fm.nextHandle = fm.prefix | fm.nextHandle&handleIDMask
+	fm.freeHandles = NewUint64MinHeap()
+}
+
+// touch records now as the last use of handle. Callers hold the write lock.
+func (fm *FileHandleMap) touch(handle uint64, now int64) {
+	if fm.lastUsed == nil {
+		fm.lastUsed = make(map[uint64]*atomic.Int64)
+	}
+	used := fm.lastUsed[handle]
+	if used == nil {
+		used = new(atomic.Int64)
+		fm.lastUsed[handle] = used
+	}
+	used.Store(now)
+}
+
+// SetIdleTimeout makes handles that go unused for d expire, so a client
+// using them later gets NFSERR_STALE. Any lookup of a handle counts as a
+// use. Zero disables expiry.
+func (fm *FileHandleMap) SetIdleTimeout(d time.Duration) {
+	fm.idleTimeout.Store(int64(d))
+}
+
+// expireIdle removes handles idle for longer than the idle timeout. It
+// sweeps at most once per half timeout, so callers may invoke it freely.
+// Expired IDs are not reused: a client that still holds one must get
+// NFSERR_STALE, not another file.
+func (fm *FileHandleMap) expireIdle(now int64) {
+	ttl := fm.idleTimeout.Load()
+	if ttl <= 0 {
+		return
+	}
+	last := fm.lastSweep.Load()
+	if now-last < ttl/2 || !fm.lastSweep.CompareAndSwap(last, now) {
+		return
+	}
+
+	fm.Lock()
+	defer fm.Unlock()
+	for handle, used := range fm.lastUsed {
+		if now-used.Load() > ttl {
+			fm.discard(handle)
+		}
+	}
+}
+
 // ReleaseAll closes and removes all file handles
 func (fm *FileHandleMap) ReleaseAll() {
 	fm.Lock()
@@ -139,9 +286,18 @@ func (fm *FileHandleMap) ReleaseAll() {
 
 	// Clear the path mapping and free list since all handles are now released
 	fm.pathHandles = make(map[string]uint64)
+	fm.lastUsed = nil
 	fm.freeHandles = NewUint64MinHeap()
 }
 
+// Capacity returns how many handles the map holds before it evicts
+func (fm *FileHandleMap) Capacity() int {
+	if fm.maxHandles <= 0 {
+		return DefaultMaxHandles
+	}
+	return fm.maxHandles
+}
+
 // Count returns the number of active file handles
 func (fm *FileHandleMap) Count() int {
 	fm.RLock()
@@ -1,9 +1,11 @@
 package absnfs
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/absfs/absfs"
 	"github.com/absfs/memfs"
@@ -489,3 +491,121 @@ func TestAllocateEvictionReturnsToFreeList(t *testing.T) {
 		t.Error("evicted handles should be in the free list")
 	}
 }
+
+func TestHandleIdleTimeoutKeepsUsedHandles(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, name := range []string{"/used", "/idle"} {
+		f, _ := mfs.Create(name)
+		f.Close()
+	}
+	const ttl = 100 * time.Millisecond
+	nfs, err := New(mfs, ExportOptions{HandleIdleTimeout: ttl})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	used := getFileHandle(server, "/used")
+	idle := getFileHandle(server, "/idle")
+
+	getattr := func(handle uint64) uint32 {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, handle)
+		result, err := handler.handleGetattr(bytes.NewReader(buf.Bytes()), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleGetattr failed: %v", err)
+		}
+		return readStatusFromReply(result)
+	}
+
+	// Keep using one handle for several timeouts' worth of time
+	deadline := time.Now().Add(4 * ttl)
+	for time.Now().Before(deadline) {
+		if status := getattr(used); status != NFS_OK {
+			t.Fatalf("GETATTR on a handle in use: expected NFS_OK, got %d", status)
+		}
+		time.Sleep(ttl / 4)
+	}
+
+	if status := getattr(idle); status != NFSERR_STALE {
+		t.Errorf("GETATTR on an idle handle: expected NFSERR_STALE, got %d", status)
+	}
+	if _, ok := nfs.fileMap.Get(used); !ok {
+		t.Error("Handle in use expired")
+	}
+	if n := nfs.fileMap.Count(); n != 1 {
+		t.Errorf("Expected the idle handle to be swept, %d handles remain", n)
+	}
+}
+
+func TestAllocateEvictsLeastRecentlyUsed(t *testing.T) {
+	fm := &FileHandleMap{
+		handles:     make(map[uint64]absfs.File),
+		pathHandles: make(map[string]uint64),
+		nextHandle:  1,
+		freeHandles: NewUint64MinHeap(),
+		maxHandles:  5,
+	}
+	var handles []uint64
+	for i := 0; i < 5; i++ {
+		handles = append(handles, fm.Allocate(&NFSNode{path: fmt.Sprintf("/file%d", i)}))
+		time.Sleep(time.Millisecond)
+	}
+
+	// The oldest handle is in use, so the next oldest is the one to go
+	fm.Get(handles[0])
+	fm.Allocate(&NFSNode{path: "/file5"})
+
+	if _, ok := fm.Get(handles[0]); !ok {
+		t.Error("Recently used handle was evicted")
+	}
+	if _, ok := fm.Get(handles[1]); ok {
+		t.Error("Least recently used handle was not evicted")
+	}
+}
+
+func TestHandleIdleExpiryDoesNotReuseID(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, name := range []string{"/old", "/new"} {
+		f, _ := mfs.Create(name)
+		f.Close()
+	}
+	const ttl = 20 * time.Millisecond
+	nfs, err := New(mfs, ExportOptions{HandleIdleTimeout: ttl})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	old := getFileHandle(server, "/old")
+
+	// A lookup after the timeout sweeps the idle handle away
+	time.Sleep(2 * ttl)
+	if _, ok := nfs.fileMap.Get(old); ok {
+		t.Fatal("idle handle still found")
+	}
+	if n := nfs.fileMap.Count(); n != 0 {
+		t.Fatalf("Expected the idle handle to be swept, %d handles remain", n)
+	}
+	if fresh := getFileHandle(server, "/new"); fresh == old {
+		t.Fatalf("new file got the expired handle %d", old)
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, old)
+	result, err := handler.handleGetattr(bytes.NewReader(buf.Bytes()), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleGetattr failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFSERR_STALE {
+		t.Errorf("GETATTR on the expired handle: expected NFSERR_STALE, got %d", status)
+	}
+}
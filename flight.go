@@ -0,0 +1,56 @@
+// flight.go: Coalescing of duplicate concurrent backing calls.
+//
+// A burst of identical LOOKUPs for a path that is not cached would each
+// Lstat the backing filesystem. flightGroup lets the first caller for a
+// key do the work while later callers for the same key wait for and share
+// its result, so the burst costs one backing call.
+package absnfs
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup runs at most one call per key at a time
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a call in progress; val and err are valid once done is closed
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// do runs fn for key unless a call for key is already in progress, in which
+// case it waits for that call and returns its result. A waiter whose ctx is
+// done first returns ctx.Err(); the call itself runs to completion for the
+// callers still waiting.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.val, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+	return c.val, c.err
+}
@@ -8,6 +8,8 @@ require (
 	github.com/absfs/lockfs v1.0.0
 	github.com/absfs/memfs v1.1.0
 	github.com/absfs/osfs v1.0.0
+	go.opentelemetry.io/otel v1.35.0
+	go.opentelemetry.io/otel/trace v1.35.0
 )
 
 require github.com/absfs/inode v1.1.0 // indirect
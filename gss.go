@@ -0,0 +1,445 @@
+// gss.go: RPCSEC_GSS authentication (RFC 2203).
+//
+// A client establishes a security context with NULL calls carrying
+// RPCSEC_GSS_INIT and then RPCSEC_GSS_CONTINUE_INIT credentials, whose
+// GSS-API tokens are handed to the export's GSSAcceptor until it reports
+// the context complete. Later calls carry RPCSEC_GSS_DATA credentials that
+// name the context by its handle. Their verifier is the context's MIC of
+// the call header, which is checked along with the credential's sequence
+// number, and the reply verifier is the context's MIC of that sequence
+// number. PrincipalMapper maps the context's principal to the UID and GID
+// the call runs as.
+//
+// Contexts unused for gssContextIdle, and contexts still being established
+// after gssInitTimeout, are dropped, and a client that has lost its
+// context starts a new one. When the table is full, a new context replaces
+// the least recently used.
+//
+// Only the rpc_gss_svc_none service is offered: the header is
+// authenticated, but call arguments and results are neither
+// integrity-checked nor encrypted. The GSS-API mechanism itself, Kerberos
+// v5 in practice, is not part of this package; a GSSAcceptor adapts one.
+package absnfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RPCSEC_GSS control procedures (rpc_gss_proc_t)
+const (
+	RPCSEC_GSS_DATA          = 0
+	RPCSEC_GSS_INIT          = 1
+	RPCSEC_GSS_CONTINUE_INIT = 2
+	RPCSEC_GSS_DESTROY       = 3
+)
+
+// RPCSEC_GSS services (rpc_gss_service_t)
+const (
+	RPC_GSS_SVC_NONE      = 1
+	RPC_GSS_SVC_INTEGRITY = 2
+	RPC_GSS_SVC_PRIVACY   = 3
+)
+
+// GSS-API major status codes sent in context-establishment replies
+const (
+	GSS_S_COMPLETE        = 0
+	GSS_S_CONTINUE_NEEDED = 1
+	GSS_S_FAILURE         = 13 << 16
+)
+
+const (
+	rpcsecGSSVersion       = 1          // rpc_gss_cred_vers_1_t
+	gssMaxSeq              = 0x80000000 // MAXSEQ: a context must be replaced before its sequence numbers reach it
+	gssSeqWindow           = 64         // sequence numbers accepted up to the highest seen
+	gssContextHandleLength = 16
+	maxGSSContexts         = 1024  // contexts held per server
+	maxGSSTokenLength      = 65536 // largest context-establishment token accepted
+
+	gssContextIdle = time.Hour        // an established context unused this long is dropped
+	gssInitTimeout = 30 * time.Second // a context not established this long after INIT is dropped
+)
+
+// GSSContext is a GSS-API security context established, or being
+// established, with one client
+type GSSContext interface {
+	// Principal returns the client's authenticated name, such as
+	// "alice@EXAMPLE.COM". It is only consulted once the context is complete.
+	Principal() string
+
+	// GetMIC returns the context's message integrity code for msg
+	GetMIC(msg []byte) ([]byte, error)
+
+	// VerifyMIC checks that mic is the context's message integrity code
+	// for msg
+	VerifyMIC(msg, mic []byte) error
+}
+
+// GSSAcceptor is the server side of a GSS-API mechanism, such as Kerberos
+// v5 with the service's keytab
+type GSSAcceptor interface {
+	// AcceptSecContext consumes one context-establishment token from a
+	// client, with sc nil for the first. It returns the context, the token
+	// to send back, if any, and whether the context is now complete.
+	AcceptSecContext(sc GSSContext, token []byte) (GSSContext, []byte, bool, error)
+}
+
+// gssCredential is a decoded rpc_gss_cred_vers_1_t
+type gssCredential struct {
+	proc    uint32
+	seq     uint32
+	service uint32
+	handle  []byte
+}
+
+// parseGSSCredential decodes an RPCSEC_GSS credential body
+func parseGSSCredential(body []byte) (*gssCredential, error) {
+	r := &byteReader{data: body}
+	version, err := r.readUint32()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version: %w", err)
+	}
+	if version != rpcsecGSSVersion {
+		return nil, fmt.Errorf("unsupported RPCSEC_GSS version %d", version)
+	}
+	cred := &gssCredential{}
+	if cred.proc, err = r.readUint32(); err != nil {
+		return nil, fmt.Errorf("failed to read gss_proc: %w", err)
+	}
+	if cred.seq, err = r.readUint32(); err != nil {
+		return nil, fmt.Errorf("failed to read seq_num: %w", err)
+	}
+	if cred.service, err = r.readUint32(); err != nil {
+		return nil, fmt.Errorf("failed to read service: %w", err)
+	}
+	handle, err := r.readString()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handle: %w", err)
+	}
+	cred.handle = []byte(handle)
+	return cred, nil
+}
+
+// gssSession is one client's security context and the sequence numbers
+// seen under it
+type gssSession struct {
+	mu       sync.Mutex
+	sc       GSSContext
+	complete atomic.Bool
+	started  bool   // a sequence number has been seen
+	highest  uint32 // highest sequence number seen
+	seen     uint64 // bit i set: highest-i has been seen
+
+	// When the session was added and last looked up; guarded by the
+	// table's mu
+	created, used time.Time
+}
+
+// established returns the session's context once it is complete
+func (s *gssSession) established() (GSSContext, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sc, s.complete.Load()
+}
+
+// acceptSeq records seq as seen and reports whether it is new and no
+// further than the window behind the highest sequence number seen
+func (s *gssSession) acceptSeq(seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !s.started || seq > s.highest:
+		if shift := seq - s.highest; s.started && shift < gssSeqWindow {
+			s.seen <<= shift
+		} else {
+			s.seen = 0
+		}
+		s.started, s.highest = true, seq
+		s.seen |= 1
+		return true
+	case s.highest-seq >= gssSeqWindow:
+		return false
+	}
+	bit := uint64(1) << (s.highest - seq)
+	if s.seen&bit != 0 {
+		return false
+	}
+	s.seen |= bit
+	return true
+}
+
+// gssContextTable holds a server's RPCSEC_GSS contexts by handle
+type gssContextTable struct {
+	mu       sync.Mutex
+	sessions map[string]*gssSession
+	now      func() time.Time // time.Now unless a test sets it
+}
+
+// clock returns the current time. Callers hold mu.
+func (t *gssContextTable) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// expired reports whether sess should be dropped at now. Callers hold mu.
+func (t *gssContextTable) expired(sess *gssSession, now time.Time) bool {
+	if !sess.complete.Load() {
+		return now.Sub(sess.created) >= gssInitTimeout
+	}
+	return now.Sub(sess.used) >= gssContextIdle
+}
+
+// get returns the session with handle, or nil if there is none or it has
+// expired, and marks it used
+func (t *gssContextTable) get(handle []byte) *gssSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sess := t.sessions[string(handle)]
+	if sess == nil {
+		return nil
+	}
+	now := t.clock()
+	if t.expired(sess, now) {
+		delete(t.sessions, string(handle))
+		return nil
+	}
+	sess.used = now
+	return sess
+}
+
+// add stores sess under a new random handle, which it returns. Once
+// maxGSSContexts are held, expired sessions are dropped and, if none
+// were, the least recently used one is.
+func (t *gssContextTable) add(sess *gssSession) ([]byte, error) {
+	handle := make([]byte, gssContextHandleLength)
+	if _, err := rand.Read(handle); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions == nil {
+		t.sessions = make(map[string]*gssSession)
+	}
+	now := t.clock()
+	if len(t.sessions) >= maxGSSContexts {
+		var lru string
+		for h, s := range t.sessions {
+			if t.expired(s, now) {
+				delete(t.sessions, h)
+			} else if lru == "" || s.used.Before(t.sessions[lru].used) {
+				lru = h
+			}
+		}
+		if len(t.sessions) >= maxGSSContexts {
+			delete(t.sessions, lru)
+		}
+	}
+	sess.created, sess.used = now, now
+	t.sessions[string(handle)] = sess
+	return handle, nil
+}
+
+// remove drops the session with handle
+func (t *gssContextTable) remove(handle []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sessions, string(handle))
+}
+
+// rpcCallHeader re-encodes the part of call an RPCSEC_GSS verifier covers:
+// the header from the XID through the credential
+func rpcCallHeader(call *RPCCall) []byte {
+	var buf bytes.Buffer
+	for _, v := range []uint32{call.Header.Xid, RPC_CALL, call.Header.RPCVersion, call.Header.Program,
+		call.Header.Version, call.Header.Procedure, call.Credential.Flavor} {
+		xdrEncodeUint32(&buf, v)
+	}
+	xdrEncodeString(&buf, string(call.Credential.Body))
+	return buf.Bytes()
+}
+
+// gssSeqMIC returns sc's MIC of a sequence number or window size, as sent
+// in reply verifiers
+func gssSeqMIC(sc GSSContext, n uint32) ([]byte, error) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	return sc.GetMIC(b[:])
+}
+
+// gssVerify decodes the RPCSEC_GSS credential of call. For a DATA or
+// DESTROY call it also checks the verifier and sequence number against the
+// context the credential names, records the context's principal in
+// authCtx and signs the sequence number in reply's verifier. A call that
+// fails is refused with the returned auth_stat.
+func (h *NFSProcedureHandler) gssVerify(call *RPCCall, reply *RPCReply, authCtx *AuthContext) (*gssCredential, uint32, error) {
+	cred, err := parseGSSCredential(call.Credential.Body)
+	if err != nil {
+		return nil, AUTH_BADCRED, fmt.Errorf("invalid RPCSEC_GSS credential: %w", err)
+	}
+	switch cred.proc {
+	case RPCSEC_GSS_INIT, RPCSEC_GSS_CONTINUE_INIT:
+		return cred, 0, nil
+	case RPCSEC_GSS_DATA, RPCSEC_GSS_DESTROY:
+	default:
+		return nil, AUTH_BADCRED, fmt.Errorf("unknown RPCSEC_GSS procedure %d", cred.proc)
+	}
+
+	sess := h.server.gss.get(cred.handle)
+	if sess == nil {
+		return nil, RPCSEC_GSS_CREDPROBLEM, fmt.Errorf("no RPCSEC_GSS context for handle %x", cred.handle)
+	}
+	sc, complete := sess.established()
+	if !complete {
+		return nil, RPCSEC_GSS_CREDPROBLEM, fmt.Errorf("RPCSEC_GSS context %x is not established", cred.handle)
+	}
+	if cred.service != RPC_GSS_SVC_NONE {
+		return nil, AUTH_BADCRED, fmt.Errorf("unsupported RPCSEC_GSS service %d", cred.service)
+	}
+	if cred.seq >= gssMaxSeq {
+		return nil, RPCSEC_GSS_CTXPROBLEM, fmt.Errorf("RPCSEC_GSS sequence number %d reached MAXSEQ", cred.seq)
+	}
+	if call.Verifier.Flavor != RPCSEC_GSS {
+		return nil, RPCSEC_GSS_CREDPROBLEM, fmt.Errorf("RPCSEC_GSS call with verifier flavor %d", call.Verifier.Flavor)
+	}
+	if err := sc.VerifyMIC(rpcCallHeader(call), call.Verifier.Body); err != nil {
+		return nil, RPCSEC_GSS_CREDPROBLEM, fmt.Errorf("RPCSEC_GSS verifier: %w", err)
+	}
+	// RFC 2203 has a repeated or stale sequence number silently dropped;
+	// it is refused instead, as ReplayWindow refuses a repeated verifier,
+	// since dropping a call means closing a TCP connection here
+	if !sess.acceptSeq(cred.seq) {
+		return nil, AUTH_REJECTEDVERF, fmt.Errorf("RPCSEC_GSS sequence number %d replayed or outside the window", cred.seq)
+	}
+
+	mic, err := gssSeqMIC(sc, cred.seq)
+	if err != nil {
+		return nil, RPCSEC_GSS_CTXPROBLEM, fmt.Errorf("RPCSEC_GSS reply verifier: %w", err)
+	}
+	reply.Verifier = RPCVerifier{Flavor: RPCSEC_GSS, Body: mic}
+	authCtx.Principal = sc.Principal()
+	return cred, 0, nil
+}
+
+// handleGSSControl answers an RPCSEC_GSS INIT, CONTINUE_INIT or DESTROY
+// call, which gssVerify has checked, with acceptor establishing contexts
+func (h *NFSProcedureHandler) handleGSSControl(cred *gssCredential, call *RPCCall, body io.Reader, reply *RPCReply, authCtx *AuthContext, acceptor GSSAcceptor) *RPCReply {
+	// Control calls ride on the NULL procedure of the program
+	if call.Header.Procedure != 0 {
+		reply.Status = MSG_DENIED
+		reply.AuthStat = AUTH_BADCRED
+		return reply
+	}
+
+	table := &h.server.gss
+	if cred.proc == RPCSEC_GSS_DESTROY {
+		// gssVerify has checked the call's MIC under the context, so only
+		// its holder can tear it down
+		table.remove(cred.handle)
+		reply.Data = []byte{}
+		return reply
+	}
+
+	token, err := readGSSToken(body)
+	if err != nil {
+		reply.AcceptStatus = GARBAGE_ARGS
+		return reply
+	}
+
+	// INIT names no context; one that does is refused rather than let it
+	// touch whichever context the handle belongs to
+	sess, handle := &gssSession{}, cred.handle
+	if cred.proc == RPCSEC_GSS_INIT && len(handle) != 0 {
+		reply.Status = MSG_DENIED
+		reply.AuthStat = RPCSEC_GSS_CREDPROBLEM
+		return reply
+	}
+	if cred.proc == RPCSEC_GSS_CONTINUE_INIT {
+		if sess = table.get(handle); sess == nil {
+			reply.Status = MSG_DENIED
+			reply.AuthStat = RPCSEC_GSS_CREDPROBLEM
+			return reply
+		}
+		if _, complete := sess.established(); complete {
+			reply.Status = MSG_DENIED
+			reply.AuthStat = RPCSEC_GSS_CREDPROBLEM
+			return reply
+		}
+	}
+
+	sess.mu.Lock()
+	sc, out, complete, err := acceptor.AcceptSecContext(sess.sc, token)
+	sess.mu.Unlock()
+	if err != nil {
+		if h.server.options.Debug {
+			h.server.logger.Printf("RPCSEC_GSS context establishment failed (client: %s): %v", authCtx.ClientIP, err)
+		}
+		if cred.proc == RPCSEC_GSS_CONTINUE_INIT {
+			table.remove(handle)
+		}
+		return gssInitResult(reply, nil, GSS_S_FAILURE, out)
+	}
+	if cred.proc == RPCSEC_GSS_INIT {
+		if handle, err = table.add(sess); err != nil {
+			if h.server.options.Debug {
+				h.server.logger.Printf("RPCSEC_GSS context refused: %v", err)
+			}
+			return gssInitResult(reply, nil, GSS_S_FAILURE, nil)
+		}
+	}
+
+	sess.mu.Lock()
+	sess.sc = sc
+	sess.complete.Store(complete)
+	sess.mu.Unlock()
+	if !complete {
+		return gssInitResult(reply, handle, GSS_S_CONTINUE_NEEDED, out)
+	}
+
+	// The reply to the final token carries the context's MIC of the
+	// sequence window, proving the server holds the context too
+	mic, err := gssSeqMIC(sc, gssSeqWindow)
+	if err != nil {
+		table.remove(handle)
+		return gssInitResult(reply, nil, GSS_S_FAILURE, nil)
+	}
+	reply.Verifier = RPCVerifier{Flavor: RPCSEC_GSS, Body: mic}
+	return gssInitResult(reply, handle, GSS_S_COMPLETE, out)
+}
+
+// gssInitResult sets reply's body to an rpc_gss_init_res
+func gssInitResult(reply *RPCReply, handle []byte, major uint32, token []byte) *RPCReply {
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, string(handle))
+	xdrEncodeUint32(&buf, major)
+	xdrEncodeUint32(&buf, 0) // gss_minor
+	xdrEncodeUint32(&buf, gssSeqWindow)
+	xdrEncodeString(&buf, string(token))
+	reply.Data = buf.Bytes()
+	return reply
+}
+
+// readGSSToken reads the opaque token argument of an INIT or CONTINUE_INIT
+// call
+func readGSSToken(r io.Reader) ([]byte, error) {
+	length, err := xdrDecodeUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxGSSTokenLength {
+		return nil, fmt.Errorf("RPCSEC_GSS token length %d exceeds maximum %d", length, maxGSSTokenLength)
+	}
+	token := make([]byte, (length+3)&^3)
+	if _, err := io.ReadFull(r, token); err != nil {
+		return nil, err
+	}
+	return token[:length], nil
+}
@@ -0,0 +1,321 @@
+package absnfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+// fakeGSSContext signs with an HMAC under a key both sides share
+type fakeGSSContext struct {
+	key       []byte
+	principal string
+}
+
+func (c *fakeGSSContext) Principal() string { return c.principal }
+
+func (c *fakeGSSContext) GetMIC(msg []byte) ([]byte, error) {
+	m := hmac.New(sha256.New, c.key)
+	m.Write(msg)
+	return m.Sum(nil), nil
+}
+
+func (c *fakeGSSContext) VerifyMIC(msg, mic []byte) error {
+	want, _ := c.GetMIC(msg)
+	if !hmac.Equal(want, mic) {
+		return errors.New("bad MIC")
+	}
+	return nil
+}
+
+// fakeGSSAcceptor completes a context in two round trips: "hello" is
+// answered with "challenge", and "response" completes it for alice
+type fakeGSSAcceptor struct{}
+
+func (fakeGSSAcceptor) AcceptSecContext(sc GSSContext, token []byte) (GSSContext, []byte, bool, error) {
+	switch {
+	case sc == nil && string(token) == "hello":
+		return &fakeGSSContext{key: []byte("session key")}, []byte("challenge"), false, nil
+	case sc != nil && string(token) == "response":
+		c := sc.(*fakeGSSContext)
+		c.principal = "alice@EXAMPLE.COM"
+		return c, nil, true, nil
+	}
+	return nil, nil, false, errors.New("defective token")
+}
+
+// gssCall builds a call to proc of the NFS program with an RPCSEC_GSS
+// credential, signed with sc unless it is nil
+func gssCall(xid, proc, gssProc, seq uint32, handle []byte, sc GSSContext) *RPCCall {
+	var cred bytes.Buffer
+	for _, v := range []uint32{rpcsecGSSVersion, gssProc, seq, RPC_GSS_SVC_NONE} {
+		xdrEncodeUint32(&cred, v)
+	}
+	xdrEncodeString(&cred, string(handle))
+	call := &RPCCall{
+		Header:     RPCMsgHeader{Xid: xid, MsgType: RPC_CALL, RPCVersion: 2, Program: NFS_PROGRAM, Version: NFS_V3, Procedure: proc},
+		Credential: RPCCredential{Flavor: RPCSEC_GSS, Body: cred.Bytes()},
+		Verifier:   RPCVerifier{Flavor: AUTH_NONE},
+	}
+	if sc != nil {
+		mic, _ := sc.GetMIC(rpcCallHeader(call))
+		call.Verifier = RPCVerifier{Flavor: RPCSEC_GSS, Body: mic}
+	}
+	return call
+}
+
+// gssInitRes is a decoded rpc_gss_init_res
+type gssInitRes struct {
+	handle []byte
+	major  uint32
+	token  string
+}
+
+func TestRPCSECGSS(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{
+		GSSAcceptor: fakeGSSAcceptor{},
+		PrincipalMapper: func(principal string) (uint32, uint32, bool) {
+			if principal == "alice@EXAMPLE.COM" {
+				return 1000, 1001, true
+			}
+			return 0, 0, false
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	h := &NFSProcedureHandler{server: &Server{handler: nfs}}
+
+	do := func(call *RPCCall, args []byte) (*RPCReply, *AuthContext) {
+		t.Helper()
+		authCtx := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 800, Credential: &call.Credential}
+		reply, err := h.HandleCall(call, bytes.NewReader(args), authCtx)
+		if err != nil {
+			t.Fatalf("HandleCall: %v", err)
+		}
+		return reply, authCtx
+	}
+	initCall := func(gssProc uint32, handle []byte, token string) (*RPCReply, gssInitRes) {
+		t.Helper()
+		var args bytes.Buffer
+		xdrEncodeString(&args, token)
+		reply, _ := do(gssCall(1, NFSPROC3_NULL, gssProc, 0, handle, nil), args.Bytes())
+		if reply.Status != MSG_ACCEPTED || reply.AcceptStatus != SUCCESS {
+			t.Fatalf("Context establishment call refused: status %d accept %d auth %d", reply.Status, reply.AcceptStatus, reply.AuthStat)
+		}
+		r := bytes.NewReader(reply.Data.([]byte))
+		var res gssInitRes
+		res.handle, _ = readGSSToken(r) // opaque, and may hold NUL bytes
+		res.major, _ = xdrDecodeUint32(r)
+		xdrDecodeUint32(r) // gss_minor
+		if window, _ := xdrDecodeUint32(r); window != gssSeqWindow {
+			t.Errorf("seq_window = %d, want %d", window, gssSeqWindow)
+		}
+		res.token, _ = xdrDecodeString(r)
+		return reply, res
+	}
+
+	if _, res := initCall(RPCSEC_GSS_INIT, nil, "garbage"); res.major != GSS_S_FAILURE || len(res.handle) != 0 {
+		t.Errorf("INIT with a defective token: major %#x handle %x, want GSS_S_FAILURE and no handle", res.major, res.handle)
+	}
+
+	_, res := initCall(RPCSEC_GSS_INIT, nil, "hello")
+	if res.major != GSS_S_CONTINUE_NEEDED || res.token != "challenge" || len(res.handle) == 0 {
+		t.Fatalf("INIT: major %#x token %q handle %x, want GSS_S_CONTINUE_NEEDED, challenge and a handle", res.major, res.token, res.handle)
+	}
+	handle := res.handle
+	if reply, _ := do(gssCall(2, NFSPROC3_NULL, RPCSEC_GSS_DATA, 1, handle, &fakeGSSContext{key: []byte("session key")}), nil); reply.Status != MSG_DENIED || reply.AuthStat != RPCSEC_GSS_CREDPROBLEM {
+		t.Errorf("DATA on an incomplete context: status %d auth %d, want RPCSEC_GSS_CREDPROBLEM", reply.Status, reply.AuthStat)
+	}
+
+	reply, res := initCall(RPCSEC_GSS_CONTINUE_INIT, handle, "response")
+	if res.major != GSS_S_COMPLETE || !bytes.Equal(res.handle, handle) {
+		t.Fatalf("CONTINUE_INIT: major %#x handle %x, want GSS_S_COMPLETE and %x", res.major, res.handle, handle)
+	}
+	sc := &fakeGSSContext{key: []byte("session key")}
+	if want, _ := gssSeqMIC(sc, gssSeqWindow); reply.Verifier.Flavor != RPCSEC_GSS || !bytes.Equal(reply.Verifier.Body, want) {
+		t.Errorf("CONTINUE_INIT verifier is not the MIC of the window")
+	}
+
+	// An authenticated NULL ping, answered with the MIC of its sequence number
+	reply, authCtx := do(gssCall(3, NFSPROC3_NULL, RPCSEC_GSS_DATA, 1, handle, sc), nil)
+	if reply.Status != MSG_ACCEPTED || reply.AcceptStatus != SUCCESS {
+		t.Fatalf("NULL over the context: status %d accept %d auth %d", reply.Status, reply.AcceptStatus, reply.AuthStat)
+	}
+	if want, _ := gssSeqMIC(sc, 1); !bytes.Equal(reply.Verifier.Body, want) {
+		t.Errorf("NULL reply verifier is not the MIC of sequence number 1")
+	}
+	if authCtx.Principal != "alice@EXAMPLE.COM" || authCtx.EffectiveUID != 1000 || authCtx.EffectiveGID != 1001 {
+		t.Errorf("NULL ran as %q %d/%d, want alice@EXAMPLE.COM as 1000/1001", authCtx.Principal, authCtx.EffectiveUID, authCtx.EffectiveGID)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		call     *RPCCall
+		authStat uint32
+	}{
+		{"replayed sequence number", gssCall(4, NFSPROC3_NULL, RPCSEC_GSS_DATA, 1, handle, sc), AUTH_REJECTEDVERF},
+		{"wrong key", gssCall(5, NFSPROC3_NULL, RPCSEC_GSS_DATA, 2, handle, &fakeGSSContext{key: []byte("guess")}), RPCSEC_GSS_CREDPROBLEM},
+		{"unknown handle", gssCall(6, NFSPROC3_NULL, RPCSEC_GSS_DATA, 3, []byte("nope"), sc), RPCSEC_GSS_CREDPROBLEM},
+		{"MAXSEQ", gssCall(7, NFSPROC3_NULL, RPCSEC_GSS_DATA, gssMaxSeq, handle, sc), RPCSEC_GSS_CTXPROBLEM},
+	} {
+		if reply, _ := do(tt.call, nil); reply.Status != MSG_DENIED || reply.AuthStat != tt.authStat {
+			t.Errorf("%s: status %d auth %d, want MSG_DENIED with %d", tt.name, reply.Status, reply.AuthStat, tt.authStat)
+		}
+	}
+
+	// Neither an INIT naming the context nor a DESTROY signed with the wrong
+	// key can tear it down
+	var badToken bytes.Buffer
+	xdrEncodeString(&badToken, "garbage")
+	if reply, _ := do(gssCall(8, NFSPROC3_NULL, RPCSEC_GSS_INIT, 0, handle, nil), badToken.Bytes()); reply.Status != MSG_DENIED {
+		t.Errorf("INIT naming an existing context: status %d, want MSG_DENIED", reply.Status)
+	}
+	if reply, _ := do(gssCall(9, NFSPROC3_NULL, RPCSEC_GSS_DESTROY, 4, handle, &fakeGSSContext{key: []byte("guess")}), nil); reply.Status != MSG_DENIED || reply.AuthStat != RPCSEC_GSS_CREDPROBLEM {
+		t.Errorf("DESTROY with the wrong key: status %d auth %d, want RPCSEC_GSS_CREDPROBLEM", reply.Status, reply.AuthStat)
+	}
+	if reply, _ := do(gssCall(10, NFSPROC3_NULL, RPCSEC_GSS_DATA, 5, handle, sc), nil); reply.Status != MSG_ACCEPTED || reply.AcceptStatus != SUCCESS {
+		t.Fatalf("NULL after refused INIT and DESTROY: status %d accept %d auth %d", reply.Status, reply.AcceptStatus, reply.AuthStat)
+	}
+
+	if reply, _ := do(gssCall(11, NFSPROC3_NULL, RPCSEC_GSS_DESTROY, 6, handle, sc), nil); reply.Status != MSG_ACCEPTED || reply.AcceptStatus != SUCCESS {
+		t.Fatalf("DESTROY: status %d accept %d auth %d", reply.Status, reply.AcceptStatus, reply.AuthStat)
+	}
+	if reply, _ := do(gssCall(12, NFSPROC3_NULL, RPCSEC_GSS_DATA, 7, handle, sc), nil); reply.Status != MSG_DENIED || reply.AuthStat != RPCSEC_GSS_CREDPROBLEM {
+		t.Errorf("DATA after DESTROY: status %d auth %d, want RPCSEC_GSS_CREDPROBLEM", reply.Status, reply.AuthStat)
+	}
+
+	// Without an acceptor the flavor is refused outright
+	if err := nfs.UpdateExportOptions(ExportOptions{}); err != nil {
+		t.Fatalf("UpdateExportOptions: %v", err)
+	}
+	var args bytes.Buffer
+	xdrEncodeString(&args, "hello")
+	if reply, _ := do(gssCall(13, NFSPROC3_NULL, RPCSEC_GSS_INIT, 0, nil, nil), args.Bytes()); reply.Status != MSG_DENIED {
+		t.Errorf("INIT without GSSAcceptor: status %d, want MSG_DENIED", reply.Status)
+	}
+}
+
+func TestGSSSequenceWindow(t *testing.T) {
+	var s gssSession
+	for _, tt := range []struct {
+		seq  uint32
+		want bool
+	}{
+		{5, true},
+		{5, false}, // repeat
+		{3, true},  // late but within the window
+		{3, false},
+		{5 + gssSeqWindow, true},
+		{5, false},  // fell out of the window
+		{6, true},   // the oldest still inside it
+		{68, true},  // unseen, inside
+		{200, true}, // jumps past the whole window
+		{199, true},
+		{68, false},
+	} {
+		if got := s.acceptSeq(tt.seq); got != tt.want {
+			t.Errorf("acceptSeq(%d) = %v, want %v", tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestGSSContextExpiry(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{GSSAcceptor: fakeGSSAcceptor{}})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	srv := &Server{handler: nfs}
+	h := &NFSProcedureHandler{server: srv}
+	now := time.Now()
+	srv.gss.now = func() time.Time { return now }
+
+	initCall := func(gssProc uint32, handle []byte, token string) (uint32, []byte) {
+		t.Helper()
+		var args bytes.Buffer
+		xdrEncodeString(&args, token)
+		call := gssCall(1, NFSPROC3_NULL, gssProc, 0, handle, nil)
+		authCtx := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 800, Credential: &call.Credential}
+		reply, err := h.HandleCall(call, bytes.NewReader(args.Bytes()), authCtx)
+		if err != nil || reply.Status != MSG_ACCEPTED {
+			t.Fatalf("Context establishment call refused: %v", err)
+		}
+		r := bytes.NewReader(reply.Data.([]byte))
+		handle, _ = readGSSToken(r)
+		major, _ := xdrDecodeUint32(r)
+		return major, handle
+	}
+	held := func() int {
+		srv.gss.mu.Lock()
+		defer srv.gss.mu.Unlock()
+		return len(srv.gss.sessions)
+	}
+
+	// One established context, then clients that send INIT and vanish
+	// until the table is full
+	_, established := initCall(RPCSEC_GSS_INIT, nil, "hello")
+	if major, _ := initCall(RPCSEC_GSS_CONTINUE_INIT, established, "response"); major != GSS_S_COMPLETE {
+		t.Fatalf("CONTINUE_INIT: major %#x", major)
+	}
+	for i := 1; i < maxGSSContexts; i++ {
+		now = now.Add(time.Millisecond)
+		if major, _ := initCall(RPCSEC_GSS_INIT, nil, "hello"); major != GSS_S_CONTINUE_NEEDED {
+			t.Fatalf("INIT %d: major %#x", i, major)
+		}
+	}
+	if n := held(); n != maxGSSContexts {
+		t.Fatalf("table holds %d contexts, want %d", n, maxGSSContexts)
+	}
+
+	// A full table makes room by evicting the least recently used context,
+	// here the established one, last used first
+	major, fresh := initCall(RPCSEC_GSS_INIT, nil, "hello")
+	if major != GSS_S_CONTINUE_NEEDED || held() != maxGSSContexts {
+		t.Fatalf("INIT into a full table: major %#x, %d held", major, held())
+	}
+	if srv.gss.get(established) != nil {
+		t.Error("least recently used context survived eviction")
+	}
+
+	// Half-finished contexts are dropped once gssInitTimeout passes
+	now = now.Add(gssInitTimeout)
+	major, handle := initCall(RPCSEC_GSS_INIT, nil, "hello")
+	if major != GSS_S_CONTINUE_NEEDED {
+		t.Fatalf("INIT after the abandoned ones timed out: major %#x", major)
+	}
+	if n := held(); n != 1 {
+		t.Errorf("table holds %d contexts after the timeout, want only the new one", n)
+	}
+	if srv.gss.get(fresh) != nil {
+		t.Error("incomplete context outlived gssInitTimeout")
+	}
+
+	// An established context lasts while used, and not gssContextIdle
+	// past its last use
+	if major, _ := initCall(RPCSEC_GSS_CONTINUE_INIT, handle, "response"); major != GSS_S_COMPLETE {
+		t.Fatalf("CONTINUE_INIT: major %#x", major)
+	}
+	now = now.Add(gssContextIdle - time.Second)
+	if srv.gss.get(handle) == nil {
+		t.Fatal("established context dropped before gssContextIdle")
+	}
+	now = now.Add(gssContextIdle)
+	if srv.gss.get(handle) != nil {
+		t.Error("idle context outlived gssContextIdle")
+	}
+}
@@ -0,0 +1,148 @@
+// health.go: Component-level health reporting.
+//
+// HealthReport breaks the single IsHealthy answer down into the backing
+// filesystem, worker pool saturation, file handle table usage and the
+// recent error rate, each Healthy, Degraded or Unhealthy against the
+// thresholds in ExportOptions.HealthThresholds. The report is JSON-ready
+// for readiness probes. There is no memory pressure component: the memory
+// monitor it would come from is shelved (see shelved/memory-monitor.md).
+package absnfs
+
+import "fmt"
+
+// HealthStatus is the state of a component or of the whole export
+type HealthStatus string
+
+const (
+	Healthy   HealthStatus = "Healthy"
+	Degraded  HealthStatus = "Degraded"
+	Unhealthy HealthStatus = "Unhealthy"
+)
+
+// severity orders statuses from Healthy to Unhealthy
+func (s HealthStatus) severity() int {
+	switch s {
+	case Degraded:
+		return 1
+	case Unhealthy:
+		return 2
+	}
+	return 0
+}
+
+// ComponentHealth is the state of one component. Value is the measure the
+// thresholds apply to, as a fraction.
+type ComponentHealth struct {
+	Status  HealthStatus `json:"status"`
+	Value   float64      `json:"value"`
+	Message string       `json:"message"`
+}
+
+// HealthReport is the state of an export, component by component. Status
+// is the worst of the components'.
+type HealthReport struct {
+	Status      HealthStatus    `json:"status"`
+	Backend     ComponentHealth `json:"backend"`
+	WorkerPool  ComponentHealth `json:"worker_pool"`
+	FileHandles ComponentHealth `json:"file_handles"`
+	ErrorRate   ComponentHealth `json:"error_rate"`
+}
+
+// HealthThresholds are the fractions at which HealthReport considers a
+// component Degraded and Unhealthy. A zero field takes its default.
+type HealthThresholds struct {
+	// WorkerSaturation is the fraction of the worker pool's workers and
+	// queue slots taken by running and queued tasks; at 1 new tasks are
+	// rejected
+	// Default: 0.75 degraded, 0.95 unhealthy
+	WorkerSaturationDegraded  float64
+	WorkerSaturationUnhealthy float64
+
+	// HandleUsage is the fraction of the file handle table in use; past
+	// it, the least recently used handles are evicted and go stale
+	// Default: 0.8 degraded, 0.95 unhealthy
+	HandleUsageDegraded  float64
+	HandleUsageUnhealthy float64
+
+	// ErrorRate is the fraction of the last 1000 calls that failed
+	// Default: 0.1 degraded, 0.5 unhealthy
+	ErrorRateDegraded  float64
+	ErrorRateUnhealthy float64
+}
+
+// withDefaults returns t with its zero fields set to their defaults
+func (t HealthThresholds) withDefaults() HealthThresholds {
+	def := func(v *float64, d float64) {
+		if *v <= 0 {
+			*v = d
+		}
+	}
+	def(&t.WorkerSaturationDegraded, 0.75)
+	def(&t.WorkerSaturationUnhealthy, 0.95)
+	def(&t.HandleUsageDegraded, 0.8)
+	def(&t.HandleUsageUnhealthy, 0.95)
+	def(&t.ErrorRateDegraded, 0.1)
+	def(&t.ErrorRateUnhealthy, 0.5)
+	return t
+}
+
+// grade returns the status of value against its thresholds
+func grade(value, degraded, unhealthy float64) HealthStatus {
+	switch {
+	case value >= unhealthy:
+		return Unhealthy
+	case value >= degraded:
+		return Degraded
+	}
+	return Healthy
+}
+
+// HealthReport returns the current state of the export's components
+func (n *AbsfsNFS) HealthReport() HealthReport {
+	t := n.tuning.Load().HealthThresholds.withDefaults()
+	var r HealthReport
+
+	r.Backend = ComponentHealth{Status: Healthy, Message: "backing filesystem reachable"}
+	if n.InOutage() {
+		r.Backend = ComponentHealth{Status: Unhealthy, Value: 1, Message: "backing filesystem unavailable; calls get JUKEBOX"}
+	}
+
+	if n.workerPool == nil {
+		r.WorkerPool = ComponentHealth{Status: Healthy, Message: "no worker pool; calls run inline"}
+	} else {
+		sat := n.workerPool.Saturation()
+		maxWorkers, active, queued := n.workerPool.Stats()
+		r.WorkerPool = ComponentHealth{
+			Status:  grade(sat, t.WorkerSaturationDegraded, t.WorkerSaturationUnhealthy),
+			Value:   sat,
+			Message: fmt.Sprintf("%d of %d workers busy, %d tasks queued", active, maxWorkers, queued),
+		}
+	}
+
+	count, capacity := n.fileMap.Count(), n.fileMap.Capacity()
+	usage := float64(count) / float64(capacity)
+	r.FileHandles = ComponentHealth{
+		Status:  grade(usage, t.HandleUsageDegraded, t.HandleUsageUnhealthy),
+		Value:   usage,
+		Message: fmt.Sprintf("%d of %d file handles in use", count, capacity),
+	}
+
+	r.ErrorRate = ComponentHealth{Status: Healthy, Message: "no calls recorded"}
+	if n.metrics != nil {
+		if rate, calls := n.metrics.recentErrorRate(); calls > 0 {
+			r.ErrorRate = ComponentHealth{
+				Status:  grade(rate, t.ErrorRateDegraded, t.ErrorRateUnhealthy),
+				Value:   rate,
+				Message: fmt.Sprintf("%.1f%% of the last %d calls failed", 100*rate, calls),
+			}
+		}
+	}
+
+	r.Status = Healthy
+	for _, c := range []ComponentHealth{r.Backend, r.WorkerPool, r.FileHandles, r.ErrorRate} {
+		if c.Status.severity() > r.Status.severity() {
+			r.Status = c.Status
+		}
+	}
+	return r
+}
@@ -0,0 +1,87 @@
+package absnfs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestHealthReport(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfs, err := New(fs, ExportOptions{MaxWorkers: 1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer nfs.Close()
+
+	r := nfs.HealthReport()
+	if r.Status != Healthy || r.ErrorRate.Status != Healthy || r.WorkerPool.Status != Healthy {
+		t.Fatalf("fresh export reported %+v, want Healthy throughout", r)
+	}
+
+	// Two failures in ten calls pass the default 10% degraded threshold
+	for i := 0; i < 10; i++ {
+		nfs.metrics.RecordOperationResult(i < 2)
+	}
+	r = nfs.HealthReport()
+	if r.ErrorRate.Status != Degraded || r.ErrorRate.Value != 0.2 || r.Status != Degraded {
+		t.Errorf("error rate %+v, overall %s; want Degraded at 0.2", r.ErrorRate, r.Status)
+	}
+	nfs.UpdateTuningOptions(func(t *TuningOptions) { t.HealthThresholds.ErrorRateDegraded = 0.25 })
+	if r = nfs.HealthReport(); r.ErrorRate.Status != Healthy {
+		t.Errorf("error rate %+v under a 25%% threshold, want Healthy", r.ErrorRate)
+	}
+
+	// One worker running and both queue slots taken fill the pool
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var results []chan interface{}
+	for i := 0; i < 3; i++ {
+		results = append(results, nfs.workerPool.Submit(func() interface{} {
+			started <- struct{}{}
+			<-release
+			return nil
+		}))
+	}
+	<-started
+	r = nfs.HealthReport()
+	close(release)
+	<-started
+	<-started
+	for _, ch := range results {
+		<-ch
+	}
+	if r.WorkerPool.Status != Unhealthy || r.WorkerPool.Value != 1 {
+		t.Errorf("worker pool %+v with every slot taken, want Unhealthy at 1", r.WorkerPool)
+	}
+
+	// A handle table nine-tenths full is degraded
+	nfs.fileMap.maxHandles = 10
+	for nfs.fileMap.Count() < 9 {
+		nfs.fileMap.Allocate(&NFSNode{})
+	}
+	if r = nfs.HealthReport(); r.FileHandles.Status != Degraded || r.FileHandles.Value != 0.9 {
+		t.Errorf("file handles %+v, want Degraded at 0.9", r.FileHandles)
+	}
+
+	// An outage makes the whole export unhealthy
+	nfs.outage.Store(true)
+	r = nfs.HealthReport()
+	if r.Backend.Status != Unhealthy || r.Status != Unhealthy {
+		t.Errorf("during an outage backend %s, overall %s; want Unhealthy", r.Backend.Status, r.Status)
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if decoded["status"] != "Unhealthy" || decoded["file_handles"].(map[string]interface{})["status"] != "Degraded" {
+		t.Errorf("JSON report %s", data)
+	}
+}
@@ -0,0 +1,122 @@
+// idmap.go: UID/GID translation between client and backing filesystem.
+//
+// ExportOptions.UIDMap and GIDMap map ranges of client IDs onto ranges of
+// backend IDs, for clients whose ID namespace differs from the backing
+// filesystem's, as in containers. Credentials and SETATTR/CREATE owners are
+// translated client to backend as they arrive; owners in returned
+// attributes are translated back. An ID outside every range of a
+// configured map becomes the export's AnonUID or AnonGID in either
+// direction.
+package absnfs
+
+// defaultAnonID is the ID of the anonymous user, nobody, unless AnonUID or
+// AnonGID says otherwise
+const defaultAnonID = 65534
+
+// anonOrDefault returns id, or defaultAnonID if id is unset
+func anonOrDefault(id uint32) uint32 {
+	if id == 0 {
+		return defaultAnonID
+	}
+	return id
+}
+
+// anonIDs returns the export's anonymous UID and GID. Policies built by
+// hand rather than from ExportOptions may leave them unset.
+func (p *PolicyOptions) anonIDs() (uid, gid uint32) {
+	return anonOrDefault(p.AnonUID), anonOrDefault(p.AnonGID)
+}
+
+// IDMapEntry maps Count consecutive IDs starting at ClientID on the client
+// to as many starting at BackendID on the backing filesystem
+type IDMapEntry struct {
+	ClientID  uint32
+	BackendID uint32
+	Count     uint32
+}
+
+// mapID translates id through entries, client to backend when toBackend
+// is set and backend to client otherwise. An empty map leaves id as is;
+// an id no entry covers becomes anon. The first covering entry wins.
+func mapID(entries []IDMapEntry, id uint32, toBackend bool, anon uint32) uint32 {
+	if len(entries) == 0 {
+		return id
+	}
+	for _, e := range entries {
+		from, to := e.ClientID, e.BackendID
+		if !toBackend {
+			from, to = to, from
+		}
+		if id >= from && id-from < e.Count {
+			return to + (id - from)
+		}
+	}
+	return anon
+}
+
+// idMaps is a snapshot of an export's UID and GID maps. A nil *idMaps
+// translates nothing.
+type idMaps struct {
+	uid, gid         []IDMapEntry
+	anonUID, anonGID uint32
+}
+
+// idMaps returns the export's current maps, or nil if it has none
+func (s *AbsfsNFS) idMaps() *idMaps {
+	policy := s.policy.Load()
+	if len(policy.UIDMap) == 0 && len(policy.GIDMap) == 0 {
+		return nil
+	}
+	m := &idMaps{uid: policy.UIDMap, gid: policy.GIDMap}
+	m.anonUID, m.anonGID = policy.anonIDs()
+	return m
+}
+
+// mapUID translates a UID sent by a client to the backing filesystem's
+func (s *AbsfsNFS) mapUID(clientUID uint32) uint32 {
+	policy := s.policy.Load()
+	anonUID, _ := policy.anonIDs()
+	return mapID(policy.UIDMap, clientUID, true, anonUID)
+}
+
+// mapGID translates a GID sent by a client to the backing filesystem's
+func (s *AbsfsNFS) mapGID(clientGID uint32) uint32 {
+	policy := s.policy.Load()
+	_, anonGID := policy.anonIDs()
+	return mapID(policy.GIDMap, clientGID, true, anonGID)
+}
+
+// mapSattr3 translates the owner a client is setting to backend IDs
+func (s *AbsfsNFS) mapSattr3(sattr *sattr3) {
+	if sattr.SetUID {
+		sattr.UID = s.mapUID(sattr.UID)
+	}
+	if sattr.SetGID {
+		sattr.GID = s.mapGID(sattr.GID)
+	}
+}
+
+// toClient translates a backend owner to the IDs the client sees
+func (m *idMaps) toClient(uid, gid uint32) (uint32, uint32) {
+	if m == nil {
+		return uid, gid
+	}
+	return mapID(m.uid, uid, false, m.anonUID), mapID(m.gid, gid, false, m.anonGID)
+}
+
+// mapCredential translates the effective IDs and auxiliary groups of an
+// authenticated call to backend IDs. The auxiliary list is copied, as
+// squashing does, since the parsed credential may be shared.
+func (p *PolicyOptions) mapCredential(authCtx *AuthContext) {
+	anonUID, anonGID := p.anonIDs()
+	authCtx.EffectiveUID = mapID(p.UIDMap, authCtx.EffectiveUID, true, anonUID)
+	authCtx.EffectiveGID = mapID(p.GIDMap, authCtx.EffectiveGID, true, anonGID)
+	if len(p.GIDMap) == 0 || authCtx.AuthSys == nil || len(authCtx.AuthSys.AuxGIDs) == 0 {
+		return
+	}
+	aux := make([]uint32, len(authCtx.AuthSys.AuxGIDs))
+	for i, gid := range authCtx.AuthSys.AuxGIDs {
+		aux[i] = mapID(p.GIDMap, gid, true, anonGID)
+	}
+	authCtx.AuthSys.AuxGIDs = aux
+}
@@ -0,0 +1,152 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestMapID(t *testing.T) {
+	entries := []IDMapEntry{
+		{ClientID: 0, BackendID: 100000, Count: 1},
+		{ClientID: 1000, BackendID: 5000, Count: 10},
+	}
+	tests := []struct {
+		id        uint32
+		toBackend bool
+		want      uint32
+	}{
+		{0, true, 100000},
+		{1000, true, 5000},
+		{1009, true, 5009},
+		{1010, true, defaultAnonID}, // just past the range
+		{999, true, defaultAnonID},
+		{5003, false, 1003},
+		{100000, false, 0},
+		{1003, false, defaultAnonID}, // a client ID is not a backend one
+		{0xffffffff, true, defaultAnonID},
+	}
+	for _, tt := range tests {
+		if got := mapID(entries, tt.id, tt.toBackend, defaultAnonID); got != tt.want {
+			t.Errorf("mapID(%d, toBackend=%v) = %d, want %d", tt.id, tt.toBackend, got, tt.want)
+		}
+	}
+	if got := mapID(nil, 1234, true, defaultAnonID); got != 1234 {
+		t.Errorf("mapID without a map = %d, want 1234", got)
+	}
+}
+
+func TestIDMapUsesAnonIDs(t *testing.T) {
+	srv, h, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.UIDMap = []IDMapEntry{{ClientID: 1000, BackendID: 5000, Count: 10}}
+		o.GIDMap = []IDMapEntry{{ClientID: 1000, BackendID: 7000, Count: 10}}
+		o.AnonUID = 1500
+		o.AnonGID = 1600
+	})
+	auth.Credential = &RPCCredential{Flavor: AUTH_SYS}
+	auth.AuthSys = &AuthSysCredential{UID: 2000, GID: 2000, AuxGIDs: []uint32{1005, 42}}
+	handle := allocHandle(t, srv, "/dir")
+
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, handle)
+	callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_GETATTR, buf.Bytes())
+	if auth.EffectiveUID != 1500 || auth.EffectiveGID != 1600 {
+		t.Errorf("unmapped caller ran as %d/%d, want 1500/1600", auth.EffectiveUID, auth.EffectiveGID)
+	}
+	if aux := auth.AuthSys.AuxGIDs; len(aux) != 2 || aux[0] != 7005 || aux[1] != 1600 {
+		t.Errorf("auxiliary groups mapped to %v, want [7005 1600]", aux)
+	}
+
+	buf.Reset()
+	encodeFileAttributes(&buf, &NFSAttrs{Uid: 42, Gid: 43}, srv.handler.idMaps())
+	if uid, gid := readOwner(t, bytes.NewReader(buf.Bytes()), 0); uid != 1500 || gid != 1600 {
+		t.Errorf("backend owner 42/43 reported as %d/%d, want 1500/1600", uid, gid)
+	}
+}
+
+// readOwner skips skip XDR words and returns the uid and gid of the fattr3
+// that follows
+func readOwner(t *testing.T, r *bytes.Reader, skip int) (uint32, uint32) {
+	t.Helper()
+	var words struct{ Type, Mode, Nlink, UID, GID uint32 }
+	r.Seek(int64(4*skip), io.SeekCurrent)
+	if err := binary.Read(r, binary.BigEndian, &words); err != nil {
+		t.Fatalf("short fattr3: %v", err)
+	}
+	return words.UID, words.GID
+}
+
+func TestIDMapTranslatesBothWays(t *testing.T) {
+	srv, h, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.UIDMap = []IDMapEntry{{ClientID: 0, BackendID: 0, Count: 1}, {ClientID: 1000, BackendID: 5000, Count: 10}}
+		o.GIDMap = []IDMapEntry{{ClientID: 1000, BackendID: 7000, Count: 10}}
+	})
+	auth.Credential = &RPCCredential{Flavor: AUTH_SYS}
+	auth.AuthSys = &AuthSysCredential{UID: 1003, GID: 1004, AuxGIDs: []uint32{1005, 42}}
+	dir := allocHandle(t, srv, "/dir")
+
+	// CREATE owns the file by the caller's backend IDs
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, dir)
+	xdrEncodeString(&buf, "owned.txt")
+	xdrEncodeUint32(&buf, 0) // UNCHECKED
+	buf.Write(encodeSattr3(false, 0, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+	r := callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_CREATE, buf.Bytes())
+	if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+		t.Fatalf("CREATE: status %d", status)
+	}
+	if auth.EffectiveUID != 5003 || auth.EffectiveGID != 7004 {
+		t.Errorf("credential mapped to %d/%d, want 5003/7004", auth.EffectiveUID, auth.EffectiveGID)
+	}
+	if aux := auth.AuthSys.AuxGIDs; len(aux) != 2 || aux[0] != 7005 || aux[1] != defaultAnonID {
+		t.Errorf("auxiliary groups mapped to %v, want [7005 %d]", aux, defaultAnonID)
+	}
+	backendOwner := func() (uint64, uint64) {
+		info, err := srv.handler.fs.Stat("/dir/owned.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		uid, _ := sysField(info, "Uid")
+		gid, _ := sysField(info, "Gid")
+		return uid, gid
+	}
+	if uid, gid := backendOwner(); uid != 5003 || gid != 7004 {
+		t.Errorf("created file owned by backend %d/%d, want 5003/7004", uid, gid)
+	}
+	xdrDecodeUint32(r) // handle follows
+	handle, _ := xdrDecodeFileHandle(r)
+
+	// Root, mapped to itself, chowns with client IDs
+	auth.AuthSys = &AuthSysCredential{UID: 0, GID: 0}
+	buf.Reset()
+	xdrEncodeFileHandle(&buf, handle)
+	buf.Write(encodeSattr3(false, 0, true, 1007, true, 1008, false, 0, 0, 0, 0, 0, 0, 0))
+	xdrEncodeUint32(&buf, 0) // no guard
+	if status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_SETATTR, buf.Bytes())); status != NFS_OK {
+		t.Fatalf("SETATTR: status %d", status)
+	}
+	if uid, gid := backendOwner(); uid != 5007 || gid != 7008 {
+		t.Errorf("SETATTR set backend owner %d/%d, want 5007/7008", uid, gid)
+	}
+	buf.Reset()
+	xdrEncodeFileHandle(&buf, handle)
+	r = callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_GETATTR, buf.Bytes())
+	if uid, gid := readOwner(t, r, 1); uid != 1007 || gid != 1008 {
+		t.Errorf("GETATTR reported owner %d/%d, want 1007/1008", uid, gid)
+	}
+
+	// A caller outside every range is anonymous
+	auth.AuthSys = &AuthSysCredential{UID: 2000, GID: 2000}
+	callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_GETATTR, buf.Bytes())
+	if auth.EffectiveUID != defaultAnonID || auth.EffectiveGID != defaultAnonID {
+		t.Errorf("unmapped caller ran as %d/%d, want %d", auth.EffectiveUID, auth.EffectiveGID, defaultAnonID)
+	}
+
+	// A backend owner outside every range is reported as anonymous
+	buf.Reset()
+	encodeFileAttributes(&buf, &NFSAttrs{Uid: 42, Gid: 7001}, srv.handler.idMaps())
+	if uid, gid := readOwner(t, bytes.NewReader(buf.Bytes()), 0); uid != defaultAnonID || gid != 1001 {
+		t.Errorf("backend owner 42/7001 reported as %d/%d, want %d/1001", uid, gid, defaultAnonID)
+	}
+}
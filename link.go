@@ -0,0 +1,76 @@
+// link.go: Hard links on backing filesystems that support them.
+//
+// absfs has no hard link operation, so a backing filesystem opts in by
+// implementing Linker. New detects it, and LINK, the FSINFO FSF3_LINK
+// property and Features.HardLinks follow; without it LINK answers
+// NFSERR_NOTSUPP.
+package absnfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Linker is implemented by backing filesystems that can create hard links
+type Linker interface {
+	// Link creates newname as a hard link to the file oldname
+	Link(oldname, newname string) error
+}
+
+// Link implements the LINK operation
+func (s *AbsfsNFS) Link(file *NFSNode, dir *NFSNode, name string) error {
+	return s.LinkWithContext(context.Background(), file, dir, name)
+}
+
+// LinkWithContext implements the LINK operation with timeout support. It
+// is bounded by the create timeout, since it adds a directory entry.
+func (s *AbsfsNFS) LinkWithContext(ctx context.Context, file *NFSNode, dir *NFSNode, name string) error {
+	if file == nil || dir == nil {
+		return fmt.Errorf("nil node")
+	}
+	if s.linker == nil {
+		return &NotSupportedError{Operation: "LINK", Reason: "the backing filesystem does not support hard links"}
+	}
+	if s.readOnlyIn(ctx) {
+		return os.ErrPermission
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.tuning.Load().Timeouts.CreateTimeout)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		if s.metrics != nil {
+			s.metrics.RecordTimeout("CREATE")
+		}
+		return ErrTimeout
+	default:
+	}
+
+	if _, err := s.confine(file.path, false, symlinkBudgetFrom(ctx)); err != nil {
+		return opError("link", file.path, err)
+	}
+	path, err := s.entryPath(dir, name)
+	if err != nil {
+		return opError("link", dir.path, fmt.Errorf("name %q: %w", name, err))
+	}
+
+	fsStart := time.Now()
+	err = s.linker.Link(file.path, path)
+	s.RecordFSLatency("LINK", time.Since(fsStart))
+	if err != nil {
+		return opError("link", file.path, fmt.Errorf("to %q: %w", path, err))
+	}
+
+	// The file gained a link and the directory an entry
+	s.attrCache.Invalidate(file.path)
+	s.attrCache.Invalidate(path)
+	s.attrCache.Invalidate(dir.path)
+	s.attrCache.InvalidateNegativeInDir(dir.path)
+	s.cookieCache.Touch(dir.path)
+	if s.dirCache != nil {
+		s.dirCache.Invalidate(dir.path)
+	}
+	return nil
+}
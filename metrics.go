@@ -6,6 +6,8 @@
 package absnfs
 
 import (
+	"math"
+	"math/rand/v2"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -37,17 +39,34 @@ type NFSMetrics struct {
 	P95WriteLatency time.Duration
 
 	// Cache metrics
-	CacheHitRate      float64
-	AttrCacheSize     int
-	AttrCacheCapacity int
-	DirCacheHitRate   float64
+	CacheHitRate         float64
+	RecentCacheHitRate   float64 // Attribute cache hit rate over the last cacheWindowSize lookups
+	AttrCacheSize        int
+	AttrCacheCapacity    int
+	DirCacheHitRate      float64
 	NegativeCacheSize    int     // Number of negative cache entries
 	NegativeCacheHitRate float64 // Hit rate for negative cache lookups
+	CookieCacheSize      int     // Directories whose listing is held for resuming READDIR
+	CookieCacheHits      uint64  // Resumed listings whose cookie verifier was recognized
+	CookieCacheMisses    uint64  // Resumed listings answered with NFSERR_BAD_COOKIE
+	CookieCacheEvictions uint64  // Listings dropped to stay within CookieCacheSize
+
+	// Worker pool metrics
+	Workers        int           // Workers in the pool now
+	MaxWorkers     int           // Most workers the pool may have; above Workers only with AutoScaleWorkers
+	QueueDepth     int           // Tasks waiting for a worker
+	QueueWaitCount uint64        // Tasks picked up by a worker since start
+	AvgQueueWait   time.Duration // Mean time a task waited before a worker picked it up
+	P95QueueWait   time.Duration
+	P99QueueWait   time.Duration
+	MaxQueueWait   time.Duration
 
 	// Connection metrics
 	ActiveConnections   int
 	TotalConnections    uint64
 	RejectedConnections uint64
+	ActiveSessions      int // Distinct (client, mount path) pairs currently mounted
+	ThrottledClients    int // Clients currently refused READ or WRITE by their per-client rate limit
 
 	// TLS metrics
 	TLSHandshakes          uint64 // Successful TLS handshakes
@@ -66,6 +85,9 @@ type NFSMetrics struct {
 	StaleHandles      uint64
 	ResourceErrors    uint64
 	RateLimitExceeded uint64
+	ReaddirSkipped    uint64 // Directory entries left out of a listing because their attributes could not be read
+	ReaddirDuplicates uint64 // Directory entries left out of a listing because their name was already listed
+	ReadRepairs       uint64 // Reads retried because their data failed the backing filesystem's checksums
 
 	// Timeout metrics
 	ReadTimeouts    uint64
@@ -113,6 +135,30 @@ type MetricsCollector struct {
 	recentResultsCap int    // capacity of the ring buffer
 	recentResultsLen int    // number of entries written so far
 
+	// Rolling attribute cache hit window for OnCacheHealthChange
+	cacheWindowMutex sync.Mutex
+	cacheWindow      []bool // true = hit
+	cacheWindowIdx   int    // next write position (ring buffer)
+	cacheWindowLen   int    // number of entries written so far
+	cacheWindowHits  int    // hits currently in the window
+	cacheDegraded    bool   // rolling rate is below CacheHealthThreshold
+
+	// Backing-filesystem call latency histograms, keyed by operation
+	fsLatencyMutex sync.Mutex
+	fsLatencies    map[string]*latencyHistogram
+
+	// Time tasks spend queued before a worker picks them up
+	queueWaitMutex sync.Mutex
+	queueWait      latencyHistogram
+	queueWaitMax   time.Duration
+
+	// Per-procedure call counts and latency histograms, keyed by the
+	// procedure name, for the Prometheus exporter
+	opMutex        sync.Mutex
+	opCounts       map[string]uint64
+	opLatencies    map[string]*opLatency
+	opStatusCounts map[opStatus]uint64
+
 	// Reference to server components for gathering metrics
 	server *AbsfsNFS
 }
@@ -127,6 +173,11 @@ func NewMetricsCollector(server *AbsfsNFS) *MetricsCollector {
 		writeLatencies:    make([]time.Duration, latencyCap),
 		recentResults:     make([]bool, latencyCap),
 		recentResultsCap:  latencyCap,
+		cacheWindow:       make([]bool, cacheWindowSize),
+		fsLatencies:       make(map[string]*latencyHistogram),
+		opCounts:          make(map[string]uint64),
+		opLatencies:       make(map[string]*opLatency),
+		opStatusCounts:    make(map[opStatus]uint64),
 		metrics: NFSMetrics{
 			StartTime: time.Now(),
 		},
@@ -137,6 +188,10 @@ func NewMetricsCollector(server *AbsfsNFS) *MetricsCollector {
 func (m *MetricsCollector) IncrementOperationCount(opType string) {
 	atomic.AddUint64(&m.metrics.TotalOperations, 1)
 
+	m.opMutex.Lock()
+	m.opCounts[opType]++
+	m.opMutex.Unlock()
+
 	switch opType {
 	case "READ":
 		atomic.AddUint64(&m.metrics.ReadOperations, 1)
@@ -163,8 +218,39 @@ func (m *MetricsCollector) IncrementOperationCount(opType string) {
 	}
 }
 
-// RecordLatency records the latency for an operation using ring buffers
+// opStatus keys the per-procedure outcome counts
+type opStatus struct {
+	op, status string
+}
+
+// RecordOperationStatus counts a call to opType answered with status
+func (m *MetricsCollector) RecordOperationStatus(opType string, status uint32) {
+	key := opStatus{op: opType, status: nfsStatusName(status)}
+	m.opMutex.Lock()
+	m.opStatusCounts[key]++
+	m.opMutex.Unlock()
+}
+
+// OperationStatusCount returns how many calls to opType were answered with
+// the named status
+func (m *MetricsCollector) OperationStatusCount(opType, status string) uint64 {
+	m.opMutex.Lock()
+	defer m.opMutex.Unlock()
+	return m.opStatusCounts[opStatus{op: opType, status: status}]
+}
+
+// RecordLatency records the latency for an operation in its histogram, and
+// for READ and WRITE in the ring buffers the average and p95 come from
 func (m *MetricsCollector) RecordLatency(opType string, duration time.Duration) {
+	m.opMutex.Lock()
+	h, ok := m.opLatencies[opType]
+	if !ok {
+		h = &opLatency{}
+		m.opLatencies[opType] = h
+	}
+	h.record(duration)
+	m.opMutex.Unlock()
+
 	m.latencyMutex.Lock()
 	defer m.latencyMutex.Unlock()
 
@@ -230,12 +316,61 @@ func (m *MetricsCollector) updateLatencyStats(samples []time.Duration, avg *time
 func (m *MetricsCollector) RecordAttrCacheHit() {
 	atomic.AddUint64(&m.attrCacheHits, 1)
 	m.updateCacheHitRate()
+	m.recordCacheWindow(true)
 }
 
 // RecordAttrCacheMiss records a miss in the attribute cache
 func (m *MetricsCollector) RecordAttrCacheMiss() {
 	atomic.AddUint64(&m.attrCacheMisses, 1)
 	m.updateCacheHitRate()
+	m.recordCacheWindow(false)
+}
+
+const (
+	// cacheWindowSize is the number of recent attribute cache lookups the
+	// rolling hit rate is computed over
+	cacheWindowSize = 1000
+	// cacheWindowMinSamples is how many lookups must be seen before the
+	// rolling rate is compared against CacheHealthThreshold
+	cacheWindowMinSamples = 100
+)
+
+// recordCacheWindow adds one lookup to the rolling hit window and invokes
+// OnCacheHealthChange when the rolling rate crosses CacheHealthThreshold.
+// The callback runs on the caller's goroutine after the lock is released.
+func (m *MetricsCollector) recordCacheWindow(hit bool) {
+	m.cacheWindowMutex.Lock()
+	if m.cacheWindowLen == cacheWindowSize && m.cacheWindow[m.cacheWindowIdx] {
+		m.cacheWindowHits--
+	}
+	m.cacheWindow[m.cacheWindowIdx] = hit
+	if hit {
+		m.cacheWindowHits++
+	}
+	m.cacheWindowIdx = (m.cacheWindowIdx + 1) % cacheWindowSize
+	if m.cacheWindowLen < cacheWindowSize {
+		m.cacheWindowLen++
+	}
+	rate := float64(m.cacheWindowHits) / float64(m.cacheWindowLen)
+
+	var callback func(rate float64)
+	if m.server != nil && m.cacheWindowLen >= cacheWindowMinSamples {
+		if tuning := m.server.tuning.Load(); tuning != nil && tuning.OnCacheHealthChange != nil {
+			if degraded := rate < tuning.CacheHealthThreshold; degraded != m.cacheDegraded {
+				m.cacheDegraded = degraded
+				callback = tuning.OnCacheHealthChange
+			}
+		}
+	}
+	m.cacheWindowMutex.Unlock()
+
+	m.mutex.Lock()
+	m.metrics.RecentCacheHitRate = rate
+	m.mutex.Unlock()
+
+	if callback != nil {
+		callback(rate)
+	}
 }
 
 // RecordDirCacheHit records a hit in the directory cache
@@ -280,6 +415,21 @@ func (m *MetricsCollector) RecordError(errorType string) {
 	}
 }
 
+// RecordReaddirSkippedEntry records a directory entry left out of a listing
+func (m *MetricsCollector) RecordReaddirSkippedEntry() {
+	atomic.AddUint64(&m.metrics.ReaddirSkipped, 1)
+}
+
+// RecordReadRepair records a read retried after a checksum mismatch
+func (m *MetricsCollector) RecordReadRepair() {
+	atomic.AddUint64(&m.metrics.ReadRepairs, 1)
+}
+
+// RecordReaddirDuplicateEntry records a repeated name dropped from a listing
+func (m *MetricsCollector) RecordReaddirDuplicateEntry() {
+	atomic.AddUint64(&m.metrics.ReaddirDuplicates, 1)
+}
+
 // RecordRateLimitExceeded records a rate limit rejection
 func (m *MetricsCollector) RecordRateLimitExceeded() {
 	atomic.AddUint64(&m.metrics.RateLimitExceeded, 1)
@@ -424,18 +574,69 @@ func (m *MetricsCollector) updateCacheMetrics() {
 	// Get negative cache size
 	negativeSize := m.server.attrCache.NegativeStats()
 
+	// Get cookie verifier cache metrics
+	var cookieSize int
+	var cookieHits, cookieMisses, cookieEvictions uint64
+	if m.server.cookieCache != nil {
+		cookieSize, cookieHits, cookieMisses, cookieEvictions = m.server.cookieCache.Stats()
+	}
+
+	// Clients refused READ or WRITE by their per-client budget
+	var throttled int
+	if rl := m.server.rateLimiter; rl != nil && m.server.policy.Load().EnableRateLimiting {
+		throttled = rl.ThrottledClients()
+	}
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	m.metrics.AttrCacheSize = attrSize
 	m.metrics.AttrCacheCapacity = attrCapacity
 	m.metrics.NegativeCacheSize = negativeSize
+	m.metrics.ActiveSessions = m.server.ActiveSessions()
+	m.metrics.ThrottledClients = throttled
+	m.metrics.CookieCacheSize = cookieSize
+	m.metrics.CookieCacheHits = cookieHits
+	m.metrics.CookieCacheMisses = cookieMisses
+	m.metrics.CookieCacheEvictions = cookieEvictions
+}
+
+// updateQueueMetrics updates the worker pool queue depth and wait times
+func (m *MetricsCollector) updateQueueMetrics() {
+	var workers, maxWorkers, depth int
+	if m.server != nil && m.server.workerPool != nil {
+		workers, _, depth = m.server.workerPool.Stats()
+		maxWorkers = m.server.workerPool.Ceiling()
+	}
+
+	m.queueWaitMutex.Lock()
+	count := m.queueWait.total
+	var avg time.Duration
+	if count > 0 {
+		avg = m.queueWait.sum / time.Duration(count)
+	}
+	p95, p99 := m.queueWait.percentile(0.95), m.queueWait.percentile(0.99)
+	max := m.queueWaitMax
+	m.queueWaitMutex.Unlock()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.metrics.Workers = workers
+	m.metrics.MaxWorkers = maxWorkers
+	m.metrics.QueueDepth = depth
+	m.metrics.QueueWaitCount = count
+	m.metrics.AvgQueueWait = avg
+	m.metrics.P95QueueWait = p95
+	m.metrics.P99QueueWait = p99
+	m.metrics.MaxQueueWait = max
 }
 
 // GetMetrics returns a snapshot of the current metrics
 func (m *MetricsCollector) GetMetrics() NFSMetrics {
 	// Update dynamic metrics before returning
 	m.updateCacheMetrics()
+	m.updateQueueMetrics()
 
 	// Update uptime
 	m.mutex.Lock()
@@ -478,34 +679,166 @@ func (m *MetricsCollector) RecordOperationResult(isError bool) {
 // IsHealthy checks if the server is in a healthy state
 func (m *MetricsCollector) IsHealthy() bool {
 	// Check windowed error rate using recent results ring buffer
+	if errorRate, n := m.recentErrorRate(); n > 0 && errorRate > 0.5 {
+		return false
+	}
+
+	// R17: Read P95 values under latencyMutex (where they are written)
+	m.latencyMutex.Lock()
+	p95Read := m.metrics.P95ReadLatency
+	p95Write := m.metrics.P95WriteLatency
+	m.latencyMutex.Unlock()
+
+	maxAllowedLatency := 5 * time.Second
+	if p95Read > maxAllowedLatency || p95Write > maxAllowedLatency {
+		return false
+	}
+
+	return true
+}
+
+// recentErrorRate returns the fraction of the recent operation results that
+// were errors, and how many results that fraction covers
+func (m *MetricsCollector) recentErrorRate() (float64, int) {
 	m.latencyMutex.Lock()
+	defer m.latencyMutex.Unlock()
+
 	n := m.recentResultsLen
+	if n == 0 {
+		return 0, 0
+	}
 	errors := 0
 	for i := 0; i < n; i++ {
 		if m.recentResults[i] {
 			errors++
 		}
 	}
-	m.latencyMutex.Unlock()
+	return float64(errors) / float64(n), n
+}
+
+// Latency histogram layout: four buckets per power of two from 1µs up to
+// 2^26µs (~67s), plus one overflow bucket. Each bucket spans a factor of
+// 2^(1/4), so reporting its geometric midpoint is within ~9% of any
+// sample that landed in it, at a fixed cost of a few hundred bytes per op.
+const (
+	latencyBucketsPerOctave = 4
+	latencyOctaves          = 26
+	latencyBucketCount      = latencyBucketsPerOctave*latencyOctaves + 1
+)
+
+// latencyHistogram is a fixed-size log-bucketed latency histogram
+type latencyHistogram struct {
+	counts [latencyBucketCount]uint64
+	sum    time.Duration
+	total  uint64
+}
+
+// latencyBucket returns the bucket index for a duration
+func latencyBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(math.Log2(us) * latencyBucketsPerOctave))
+	if i >= latencyBucketCount-1 {
+		return latencyBucketCount - 1
+	}
+	return i
+}
+
+// bucketValue returns the representative duration for a bucket: the
+// geometric midpoint of its bounds
+func bucketValue(i int) time.Duration {
+	if i == 0 {
+		return time.Microsecond
+	}
+	exp := (float64(i) - 0.5) / latencyBucketsPerOctave
+	return time.Duration(math.Pow(2, exp) * float64(time.Microsecond))
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	h.counts[latencyBucket(d)]++
+	h.sum += d
+	h.total++
+}
 
-	// If we have enough samples, check error rate
-	if n > 0 {
-		errorRate := float64(errors) / float64(n)
-		if errorRate > 0.5 {
-			return false
+// percentile returns the estimated latency at quantile q (0 < q <= 1)
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return bucketValue(i)
 		}
 	}
+	return bucketValue(latencyBucketCount - 1)
+}
 
-	// R17: Read P95 values under latencyMutex (where they are written)
-	m.latencyMutex.Lock()
-	p95Read := m.metrics.P95ReadLatency
-	p95Write := m.metrics.P95WriteLatency
-	m.latencyMutex.Unlock()
+// sampleLatency reports whether an operation's latency is recorded when
+// only a rate fraction of them are. Each operation is picked independently
+// at random, so a regular mix of operation types cannot leave some of them
+// never sampled.
+func (m *MetricsCollector) sampleLatency(rate float64) bool {
+	return rate <= 0 || rate >= 1 || rand.Float64() < rate
+}
 
-	maxAllowedLatency := 5 * time.Second
-	if p95Read > maxAllowedLatency || p95Write > maxAllowedLatency {
-		return false
+// RecordFSLatency records how long a call into the backing filesystem took
+func (m *MetricsCollector) RecordFSLatency(op string, duration time.Duration) {
+	m.fsLatencyMutex.Lock()
+	defer m.fsLatencyMutex.Unlock()
+
+	h, ok := m.fsLatencies[op]
+	if !ok {
+		h = &latencyHistogram{}
+		m.fsLatencies[op] = h
 	}
+	h.record(duration)
+}
 
-	return true
+// opLatencyBounds are the upper bounds, in seconds, of the per-procedure
+// latency buckets, the Prometheus client defaults extended below 5ms
+var opLatencyBounds = [...]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// opLatency is a procedure's latency histogram in Prometheus form: counts
+// per bucket, the last bucket holding samples above every bound
+type opLatency struct {
+	counts [len(opLatencyBounds) + 1]uint64
+	sum    time.Duration
+	total  uint64
+}
+
+func (h *opLatency) record(d time.Duration) {
+	i := sort.SearchFloat64s(opLatencyBounds[:], d.Seconds())
+	h.counts[i]++
+	h.sum += d
+	h.total++
+}
+
+// RecordQueueWait records how long a task waited in the worker pool queue
+// before a worker picked it up
+func (m *MetricsCollector) RecordQueueWait(duration time.Duration) {
+	m.queueWaitMutex.Lock()
+	defer m.queueWaitMutex.Unlock()
+
+	m.queueWait.record(duration)
+	if duration > m.queueWaitMax {
+		m.queueWaitMax = duration
+	}
+}
+
+// FSLatencyPercentiles returns the p50, p95 and p99 backing-filesystem
+// latency for op, or zeros if no samples have been recorded
+func (m *MetricsCollector) FSLatencyPercentiles(op string) (p50, p95, p99 time.Duration) {
+	m.fsLatencyMutex.Lock()
+	defer m.fsLatencyMutex.Unlock()
+
+	h, ok := m.fsLatencies[op]
+	if !ok {
+		return 0, 0, 0
+	}
+	return h.percentile(0.50), h.percentile(0.95), h.percentile(0.99)
 }
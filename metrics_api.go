@@ -2,7 +2,7 @@
 //
 // Contains methods on AbsfsNFS that expose collected metrics:
 // GetMetrics(), GetMetricsSummary(), GetOperationMetrics(),
-// GetCacheMetrics(), ResetMetrics(), and related helpers.
+// GetCacheMetrics(), CacheStats(), ResetMetrics(), and related helpers.
 package absnfs
 
 import (
@@ -29,8 +29,12 @@ func (n *AbsfsNFS) GetMetrics() NFSMetrics {
 	return n.metrics.GetMetrics()
 }
 
-// IsHealthy returns whether the server is in a healthy state
+// IsHealthy returns whether the server is in a healthy state. It is
+// never healthy during a backing filesystem outage.
 func (n *AbsfsNFS) IsHealthy() bool {
+	if n.InOutage() {
+		return false
+	}
 	if n.metrics == nil {
 		// If metrics collection is not initialized, assume server is healthy
 		return true
@@ -39,8 +43,91 @@ func (n *AbsfsNFS) IsHealthy() bool {
 	return n.metrics.IsHealthy()
 }
 
+// RecordQueueWait records how long a task waited for a worker
+func (n *AbsfsNFS) RecordQueueWait(duration time.Duration) {
+	if n.metrics == nil {
+		return
+	}
+	n.metrics.RecordQueueWait(duration)
+}
+
+// FSLatencyPercentiles returns the p50, p95 and p99 latency of calls into the
+// backing filesystem for op ("LSTAT", "READ", "WRITE", "CREATE", "REMOVE",
+// "RENAME" or "READDIR"). Returns zeros if nothing has been recorded.
+func (n *AbsfsNFS) FSLatencyPercentiles(op string) (p50, p95, p99 time.Duration) {
+	if n.metrics == nil {
+		return 0, 0, 0
+	}
+	return n.metrics.FSLatencyPercentiles(op)
+}
+
+// RecordFSLatency records the latency of a call into the backing filesystem,
+// subject to MetricsSampleRate
+func (n *AbsfsNFS) RecordFSLatency(op string, duration time.Duration) {
+	if n.metrics == nil || !n.metrics.sampleLatency(n.tuning.Load().MetricsSampleRate) {
+		return
+	}
+	n.metrics.RecordFSLatency(op, duration)
+}
+
+// OperationStatusCount returns how many calls to the procedure op (e.g.
+// "REMOVE") were answered with status, named without its NFSERR_ prefix
+// ("OK", "NOENT", ...)
+func (n *AbsfsNFS) OperationStatusCount(op, status string) uint64 {
+	if n.metrics == nil {
+		return 0
+	}
+	return n.metrics.OperationStatusCount(op, status)
+}
+
+// CacheCounters describes one of the NFS-layer caches. There is no byte
+// count: every cache here is bounded by entries, not bytes, and holds
+// metadata whose size depends on the backing filesystem (the directory
+// cache keeps its os.FileInfo values), so any figure would be a guess. A
+// cache that holds file data, and so knows its size, should add the field
+// when it is introduced.
+type CacheCounters struct {
+	Entries   int    // Entries held now
+	Limit     int    // Configured maximum entries; 0 means no limit
+	Hits      uint64 // Lookups answered from the cache
+	Misses    uint64 // Lookups the cache could not answer
+	Evictions uint64 // Entries dropped to stay within Limit
+}
+
+// CacheStats is a snapshot of the NFS-layer caches, returned by
+// AbsfsNFS.CacheStats. Counters are cumulative since the server was built.
+type CacheStats struct {
+	// Attr covers positive attribute entries. Its Misses count every
+	// lookup that found neither a positive nor a negative entry.
+	Attr CacheCounters
+
+	// Negative covers negative lookup entries, which share the attribute
+	// cache and its MaxSize. Its Limit is NegativeCacheMaxPerDir, and its
+	// Evictions include those made to honor it.
+	Negative CacheCounters
+
+	// Dir covers directory listings; it is zero unless EnableDirCache
+	Dir CacheCounters
+
+	// Cookie covers the READDIR cookie verifiers held per directory
+	Cookie CacheCounters
+}
+
+// CacheStats returns the entries, limits, hits, misses and evictions of
+// the attribute, negative lookup, directory and cookie verifier caches
+func (n *AbsfsNFS) CacheStats() CacheStats {
+	var stats CacheStats
+	stats.Attr, stats.Negative = n.attrCache.cacheStats()
+	if n.dirCache != nil {
+		stats.Dir = n.dirCache.cacheStats()
+	}
+	stats.Cookie = n.cookieCache.cacheStats()
+	return stats
+}
+
 // RecordOperationStart records the start of an NFS operation for metrics tracking
 // Returns a function that should be called when the operation completes
+// Every operation is counted; its latency only if MetricsSampleRate samples it
 func (n *AbsfsNFS) RecordOperationStart(opType string) func(err error) {
 	if n.metrics == nil {
 		// If metrics collection is not initialized, return a no-op function
@@ -50,8 +137,12 @@ func (n *AbsfsNFS) RecordOperationStart(opType string) func(err error) {
 	// Record operation count
 	n.metrics.IncrementOperationCount(opType)
 
-	// Record start time for latency tracking
-	startTime := time.Now()
+	// Record start time for latency tracking, if this operation is sampled
+	timed := n.metrics.sampleLatency(n.tuning.Load().MetricsSampleRate)
+	var startTime time.Time
+	if timed {
+		startTime = time.Now()
+	}
 
 	// Return a function that will be called when the operation completes
 	return func(err error) {
@@ -59,7 +150,7 @@ func (n *AbsfsNFS) RecordOperationStart(opType string) func(err error) {
 		n.metrics.RecordOperationResult(err != nil)
 
 		// Record latency
-		if opType == "READ" || opType == "WRITE" {
+		if timed {
 			n.metrics.RecordLatency(opType, time.Since(startTime))
 		}
 
@@ -134,6 +225,30 @@ func isResourceError(err error) bool {
 		strings.Contains(errMsg, "limit")
 }
 
+// RecordReaddirSkippedEntry records a directory entry left out of a listing
+func (n *AbsfsNFS) RecordReaddirSkippedEntry() {
+	if n.metrics == nil {
+		return
+	}
+	n.metrics.RecordReaddirSkippedEntry()
+}
+
+// RecordReadRepair records a read retried after a checksum mismatch
+func (n *AbsfsNFS) RecordReadRepair() {
+	if n.metrics == nil {
+		return
+	}
+	n.metrics.RecordReadRepair()
+}
+
+// RecordReaddirDuplicateEntry records a repeated name dropped from a listing
+func (n *AbsfsNFS) RecordReaddirDuplicateEntry() {
+	if n.metrics == nil {
+		return
+	}
+	n.metrics.RecordReaddirDuplicateEntry()
+}
+
 // RecordAttrCacheHit records a hit in the attribute cache
 func (n *AbsfsNFS) RecordAttrCacheHit() {
 	if n.metrics == nil {
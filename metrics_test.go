@@ -1,6 +1,7 @@
 package absnfs
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"testing"
@@ -755,3 +756,209 @@ func TestRecordOperationStartCoverageFull(t *testing.T) {
 		done(os.ErrNotExist)
 	}
 }
+
+func TestFSLatencyPercentiles(t *testing.T) {
+	m := NewMetricsCollector(nil)
+
+	// 1ms..1000ms in 1ms steps: exact p50/p95/p99 are 500/950/990ms
+	for i := 1; i <= 1000; i++ {
+		m.RecordFSLatency("READ", time.Duration(i)*time.Millisecond)
+	}
+
+	p50, p95, p99 := m.FSLatencyPercentiles("READ")
+	for _, tc := range []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"p50", p50, 500 * time.Millisecond},
+		{"p95", p95, 950 * time.Millisecond},
+		{"p99", p99, 990 * time.Millisecond},
+	} {
+		diff := float64(tc.got-tc.want) / float64(tc.want)
+		if diff < -0.10 || diff > 0.10 {
+			t.Errorf("%s = %v, want %v within 10%%", tc.name, tc.got, tc.want)
+		}
+	}
+
+	if p50, p95, p99 := m.FSLatencyPercentiles("WRITE"); p50 != 0 || p95 != 0 || p99 != 0 {
+		t.Errorf("Expected zero percentiles for unrecorded op, got %v/%v/%v", p50, p95, p99)
+	}
+
+	// Extreme values land in the first and overflow buckets without panicking
+	m.RecordFSLatency("LSTAT", 0)
+	m.RecordFSLatency("LSTAT", time.Hour)
+	if p50, _, p99 := m.FSLatencyPercentiles("LSTAT"); p50 != time.Microsecond || p99 < time.Minute {
+		t.Errorf("Unexpected extreme-bucket percentiles: p50=%v p99=%v", p50, p99)
+	}
+}
+
+func TestFSLatencyRecordedForBackingCalls(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	node, err := nfs.Lookup("/file.txt")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if _, err := nfs.Read(node, 0, 5); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	for _, op := range []string{"LSTAT", "READ"} {
+		nfs.metrics.fsLatencyMutex.Lock()
+		h, ok := nfs.metrics.fsLatencies[op]
+		var total uint64
+		if ok {
+			total = h.total
+		}
+		nfs.metrics.fsLatencyMutex.Unlock()
+		if total == 0 {
+			t.Errorf("Expected %s backing-fs latency samples, got none", op)
+		}
+	}
+}
+
+func TestCacheHealthCallback(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		f, err := fs.Create(fmt.Sprintf("/f%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	var mu sync.Mutex
+	var rates []float64
+	nfs, err := New(fs, ExportOptions{
+		CacheHealthThreshold: 0.6,
+		OnCacheHealthChange: func(rate float64) {
+			mu.Lock()
+			rates = append(rates, rate)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+
+	// A hot file: one miss then hits keeps the cache healthy
+	for i := 0; i < 150; i++ {
+		if _, err := nfs.Lookup("/f0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rates) != 0 {
+		t.Fatalf("Expected no callback while healthy, got %v", rates)
+	}
+
+	// A scan of distinct files is all misses
+	for i := 1; i < 300; i++ {
+		if _, err := nfs.Lookup(fmt.Sprintf("/f%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rates) != 1 {
+		t.Fatalf("Expected one degradation callback, got %v", rates)
+	}
+	if rates[0] >= 0.6 {
+		t.Errorf("Expected callback rate below 0.6, got %f", rates[0])
+	}
+	if got := nfs.GetMetrics().RecentCacheHitRate; got >= rates[0] {
+		t.Errorf("Expected rolling rate to keep falling below %f, got %f", rates[0], got)
+	}
+}
+
+func TestMetricsSampleRate(t *testing.T) {
+	newServer := func(rate float64) *AbsfsNFS {
+		t.Helper()
+		fs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		server, err := New(fs, ExportOptions{MetricsSampleRate: rate})
+		if err != nil {
+			t.Fatalf("Failed to create NFS server: %v", err)
+		}
+		return server
+	}
+	// Times n READs, enough to fill the latency ring buffer at full rate
+	const n = 3000
+	run := func(server *AbsfsNFS) time.Duration {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			server.RecordOperationStart("READ")(nil)
+			server.RecordFSLatency("READ", time.Millisecond)
+		}
+		return time.Since(start)
+	}
+
+	full := newServer(0)
+	sampled := newServer(0.01)
+	fullTime := run(full)
+	sampledTime := run(sampled)
+
+	for name, server := range map[string]*AbsfsNFS{"full": full, "sampled": sampled} {
+		m := server.GetMetrics()
+		if m.ReadOperations != n || m.TotalOperations != n {
+			t.Errorf("%s: counted %d reads and %d operations, want %d of each", name, m.ReadOperations, m.TotalOperations, n)
+		}
+	}
+
+	// 1% of 3000 is 30 on average; the bounds are over 4 standard deviations out
+	if got := sampled.metrics.readLatLen; got < 8 || got > 60 {
+		t.Errorf("Sampled READ latencies = %d, want about %d", got, n/100)
+	}
+	if got := full.metrics.readLatLen; got != full.metrics.maxLatencySamples {
+		t.Errorf("Full-rate READ latencies = %d, want a full ring of %d", got, full.metrics.maxLatencySamples)
+	}
+	var fsSamples uint64
+	for _, c := range sampled.metrics.fsLatencies["READ"].counts {
+		fsSamples += c
+	}
+	if fsSamples < 8 || fsSamples > 60 {
+		t.Errorf("Sampled FS READ latencies = %d, want about %d", fsSamples, n/100)
+	}
+
+	if sampledTime >= fullTime/2 {
+		t.Errorf("Sampling at 1%% took %v for %d operations, full rate %v; want under half", sampledTime, n, fullTime)
+	}
+}
+
+func BenchmarkRecordOperationSampleRate(b *testing.B) {
+	for _, rate := range []float64{1, 0.1, 0.01} {
+		b.Run(fmt.Sprintf("rate=%g", rate), func(b *testing.B) {
+			fs, err := memfs.NewFS()
+			if err != nil {
+				b.Fatalf("Failed to create memfs: %v", err)
+			}
+			server, err := New(fs, ExportOptions{MetricsSampleRate: rate})
+			if err != nil {
+				b.Fatalf("Failed to create NFS server: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.RecordOperationStart("READ")(nil)
+			}
+		})
+	}
+}
@@ -1,8 +1,8 @@
 // mount_handlers.go: NFSv3 MOUNT protocol (RFC 1813 Appendix I).
 //
-// Handles MNT (mount export), UMNT (unmount), DUMP (list active
-// mounts), and EXPORT (list available exports). Manages the export
-// path and active mount tracking. Supports both MOUNT v1 and v3
+// Handles MNT (mount export), UMNT (unmount), UMNTALL (unmount all of a
+// client's mounts), DUMP (list active mounts), and EXPORT (list available
+// exports). Manages the export path and active mount tracking. Supports both MOUNT v1 and v3
 // for compatibility with different client implementations.
 package absnfs
 
@@ -18,8 +18,9 @@ import (
 // Supports both MOUNT v1 and v3 for compatibility with different clients
 func (h *NFSProcedureHandler) handleMountCall(call *RPCCall, body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	// Check version - accept v1 or v3
-	if call.Header.Version != 1 && call.Header.Version != MOUNT_V3 {
+	if call.Header.Version != MOUNT_V1 && call.Header.Version != MOUNT_V3 {
 		reply.AcceptStatus = PROG_MISMATCH
+		reply.MismatchLow, reply.MismatchHigh = MOUNT_V1, MOUNT_V3
 		return reply, nil
 	}
 
@@ -29,15 +30,15 @@ func (h *NFSProcedureHandler) handleMountCall(call *RPCCall, body io.Reader, rep
 
 	case 1: // MNT
 		// Apply rate limiting for mount operations
-		if h.server.handler.rateLimiter != nil && h.server.handler.policy.Load().EnableRateLimiting {
-			if !h.server.handler.rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeMount) {
+		if h.nfs().rateLimiter != nil && h.nfs().policy.Load().EnableRateLimiting {
+			if !h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeMount) {
 				var buf bytes.Buffer
 				xdrEncodeUint32(&buf, 10006) // MNT3ERR_SERVERFAULT - server is busy
 				reply.Data = buf.Bytes()
 
 				// Record rate limit exceeded
-				if h.server.handler.metrics != nil {
-					h.server.handler.metrics.RecordRateLimitExceeded()
+				if h.nfs().metrics != nil {
+					h.nfs().metrics.RecordRateLimitExceeded()
 				}
 
 				return reply, nil
@@ -59,8 +60,33 @@ func (h *NFSProcedureHandler) handleMountCall(call *RPCCall, body io.Reader, rep
 			return reply, nil
 		}
 
+		// With an export table loaded, the path must fall under an export
+		// that lists this client
+		if table := h.server.exports.Load(); table != nil {
+			entry, ok := table.lookup(mountPath)
+			if ok {
+				_, ok = entry.match(authCtx.ClientIP)
+			}
+			if !ok {
+				var buf bytes.Buffer
+				xdrEncodeUint32(&buf, 13) // MNT3ERR_ACCES
+				reply.Data = buf.Bytes()
+				return reply, nil
+			}
+		}
+
+		// Find the export the path falls under, and the directory within it
+		nfs, exportPath, ok := h.server.exportForPath(mountPath)
+		if !ok {
+			var buf bytes.Buffer
+			xdrEncodeUint32(&buf, 2) // MNT3ERR_NOENT
+			reply.Data = buf.Bytes()
+			return reply, nil
+		}
+		h = &NFSProcedureHandler{server: h.server, export: nfs}
+
 		// Create mount point with timeout
-		node, err := h.server.handler.Lookup(mountPath)
+		node, err := h.nfs().Lookup(exportPath)
 		if err != nil {
 			// MNT3 response: fhs_status (MNT3ERR_NOENT = 2)
 			var buf bytes.Buffer
@@ -70,9 +96,23 @@ func (h *NFSProcedureHandler) handleMountCall(call *RPCCall, body io.Reader, rep
 		}
 
 		// Allocate file handle for root
-		handle := h.server.handler.fileMap.Allocate(node)
+		handle := h.nfs().fileMap.Allocate(node)
+		h.nfs().addSession(authCtx.ClientIP, mountPath)
 		if h.server.options.Debug {
-			h.server.logger.Printf("MOUNT: Allocated handle %d for path '%s', fileMap count: %d", handle, mountPath, h.server.handler.fileMap.Count())
+			h.server.logger.Printf("MOUNT: Allocated handle %d for path '%s', fileMap count: %d", handle, mountPath, h.nfs().fileMap.Count())
+		}
+		if h.nfs().tuning.Load().WarmOnMount {
+			h.nfs().warmer.enqueue(exportPath)
+		}
+
+		// MOUNT v1 serves NFSv2 clients and replies with an fhstatus:
+		// status followed by a fixed 32-byte fhandle
+		if call.Header.Version == MOUNT_V1 {
+			var buf bytes.Buffer
+			xdrEncodeUint32(&buf, 0) // MNT_OK
+			xdrEncodeFileHandleV2(&buf, handle)
+			reply.Data = buf.Bytes()
+			return reply, nil
 		}
 
 		// Encode MNT3 response
@@ -98,28 +138,51 @@ func (h *NFSProcedureHandler) handleMountCall(call *RPCCall, body io.Reader, rep
 		return reply, nil
 
 	case 3: // UMNT
-		_, err := xdrDecodeString(body)
+		mountPath, err := xdrDecodeString(body)
 		if err != nil {
 			reply.AcceptStatus = GARBAGE_ARGS
 			return reply, nil
 		}
+		mountPath = path.Clean(mountPath)
+		if nfs, _, ok := h.server.exportForPath(mountPath); ok {
+			nfs.removeSession(authCtx.ClientIP, mountPath)
+		}
 
 		// UMNT has no return value
 		return reply, nil
 
 	case 4: // UMNTALL
-		// No arguments, no return value
+		// No arguments, no return value; unmounts everything the client
+		// mounted, on every export
+		h.server.removeClientSessions(authCtx.ClientIP)
 		return reply, nil
 
 	case 5: // EXPORT
 		// Return list of exported filesystems
 		// Each entry: ex_dir (string), ex_groups (list)
-		// We export "/" to all
+		// Without an export table every export path is open to all
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, 1)   // Has entry (1 = true)
-		xdrEncodeString(&buf, "/") // Export path
-		xdrEncodeUint32(&buf, 0)   // No group restrictions (null pointer)
-		xdrEncodeUint32(&buf, 0)   // End of list
+		table := h.server.exports.Load()
+		if table == nil {
+			for _, p := range h.server.exportPaths() {
+				xdrEncodeUint32(&buf, 1) // Has entry (1 = true)
+				xdrEncodeString(&buf, p) // Export path
+				xdrEncodeUint32(&buf, 0) // No group restrictions (null pointer)
+			}
+			xdrEncodeUint32(&buf, 0) // End of list
+			reply.Data = buf.Bytes()
+			return reply, nil
+		}
+		for _, entry := range table.Entries {
+			xdrEncodeUint32(&buf, 1) // Has entry
+			xdrEncodeString(&buf, entry.Path)
+			for _, c := range entry.Clients {
+				xdrEncodeUint32(&buf, 1) // Has group
+				xdrEncodeString(&buf, c.Host)
+			}
+			xdrEncodeUint32(&buf, 0) // End of groups
+		}
+		xdrEncodeUint32(&buf, 0) // End of list
 		reply.Data = buf.Bytes()
 		return reply, nil
 
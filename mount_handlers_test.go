@@ -5,8 +5,11 @@ import (
 	"encoding/binary"
 	"log"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/absfs/absfs"
 	"github.com/absfs/memfs"
 )
 
@@ -795,3 +798,232 @@ func TestCovBoost_HandleMountCall_MNTGarbageArgs(t *testing.T) {
 		t.Errorf("expected GARBAGE_ARGS, got %d", result.AcceptStatus)
 	}
 }
+
+func TestActiveSessions(t *testing.T) {
+	server, h := newExportsTestServer(t)
+
+	mount := func(clientIP string, proc uint32, p string) {
+		var args bytes.Buffer
+		xdrEncodeString(&args, p)
+		callAs(t, h, clientIP, MOUNT_PROGRAM, MOUNT_V3, proc, args.Bytes())
+	}
+
+	mount("10.0.0.1", 1, "/")
+	mount("10.0.0.2", 1, "/data")
+	mount("10.0.0.2", 1, "/data/") // same session after cleaning
+	if n := server.handler.ActiveSessions(); n != 2 {
+		t.Fatalf("Expected 2 active sessions, got %d", n)
+	}
+
+	mount("10.0.0.1", 3, "/")
+	if n := server.handler.ActiveSessions(); n != 1 {
+		t.Errorf("Expected 1 active session after UMNT, got %d", n)
+	}
+	if n := server.handler.GetMetrics().ActiveSessions; n != 1 {
+		t.Errorf("Expected metrics to report 1 active session, got %d", n)
+	}
+
+	server.handler.Unexport()
+	if n := server.handler.ActiveSessions(); n != 0 {
+		t.Errorf("Expected no sessions after Unexport, got %d", n)
+	}
+}
+
+func TestUmntall(t *testing.T) {
+	server, h := newExportsTestServer(t)
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(mfs, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	if err := server.AddExport("/other", other); err != nil {
+		t.Fatal(err)
+	}
+
+	mount := func(clientIP string, proc uint32, p string) {
+		var args bytes.Buffer
+		xdrEncodeString(&args, p)
+		callAs(t, h, clientIP, MOUNT_PROGRAM, MOUNT_V3, proc, args.Bytes())
+	}
+	mount("10.0.0.1", 1, "/")
+	mount("10.0.0.1", 1, "/data")
+	mount("10.0.0.1", 1, "/other")
+	mount("10.0.0.2", 1, "/")
+	mount("10.0.0.2", 1, "/other")
+
+	callAs(t, h, "10.0.0.1", MOUNT_PROGRAM, MOUNT_V3, 4, nil)
+
+	for _, nfs := range []*AbsfsNFS{server.handler, other} {
+		for session := range nfs.sessions {
+			if session.client != "10.0.0.2" {
+				t.Errorf("session %+v survived its client's UMNTALL", session)
+			}
+		}
+	}
+	if n := server.handler.ActiveSessions() + other.ActiveSessions(); n != 2 {
+		t.Errorf("Expected the other client's 2 sessions to remain, got %d", n)
+	}
+}
+
+func TestMountWarmsCache(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.EnableDirCache = true
+		o.WarmOnMount = true
+	})
+
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, "/")
+	call := &RPCCall{Header: RPCMsgHeader{Program: MOUNT_PROGRAM, Version: MOUNT_V3, Procedure: 1}}
+	result, err := handler.handleMountCall(call, &buf, &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleMountCall: %v", err)
+	}
+	if status := binary.BigEndian.Uint32(result.Data.([]byte)); status != 0 {
+		t.Fatalf("expected MNT3_OK, got %d", status)
+	}
+
+	// Warming lists / and /dir in the background
+	dirCache := srv.handler.dirCache
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if entries, _, _ := dirCache.Stats(); entries == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache was not warmed after MNT")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, hits, misses := dirCache.Stats()
+
+	buf.Reset()
+	xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/"))
+	binary.Write(&buf, binary.BigEndian, uint64(0)) // cookie
+	buf.Write(make([]byte, 8))                      // cookieverf
+	binary.Write(&buf, binary.BigEndian, uint32(4096))
+	result, err = handler.handleReaddir(&buf, &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleReaddir: %v", err)
+	}
+	if status := readStatus(t, result); status != NFS_OK {
+		t.Fatalf("expected NFS_OK, got %d", status)
+	}
+	if _, h, m := dirCache.Stats(); h != hits+1 || m != misses {
+		t.Errorf("first READDIR after MNT: hits %d->%d, misses %d->%d; want one hit", hits, h, misses, m)
+	}
+}
+
+// gatedOpenFS counts OpenFile calls per path and holds each until gate is
+// closed
+type gatedOpenFS struct {
+	*memfs.FileSystem
+	gate  chan struct{}
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (f *gatedOpenFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f.mu.Lock()
+	f.opens[name]++
+	f.mu.Unlock()
+	<-f.gate
+	return f.FileSystem.OpenFile(name, flag, perm)
+}
+
+func (f *gatedOpenFS) openCount(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.opens[name]
+}
+
+func TestMountWarmerQueue(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	fs := &gatedOpenFS{FileSystem: mfs, gate: make(chan struct{}), opens: make(map[string]int)}
+	nfs, err := New(fs, ExportOptions{WarmOnMount: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	w := nfs.warmer
+
+	// One goroutine warms one directory at a time; mounts of a directory
+	// already queued or being warmed add nothing
+	w.enqueue("/")
+	for deadline := time.Now().Add(time.Second); fs.openCount("/") == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("warming of / never started")
+		}
+	}
+	w.enqueue("/")
+	w.enqueue("/dir")
+	w.enqueue("/dir")
+	w.mu.Lock()
+	pending := append([]string(nil), w.pending...)
+	w.mu.Unlock()
+	if len(pending) != 1 || pending[0] != "/dir" {
+		t.Errorf("pending = %v while / is warmed, want [/dir]", pending)
+	}
+
+	// Close drops what is queued and waits for the walk in progress
+	closed := make(chan struct{})
+	go func() {
+		nfs.Close()
+		close(closed)
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		w.mu.Lock()
+		done := w.closed
+		w.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Close never reached the warmer")
+		}
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a walk was in progress")
+	default:
+	}
+	close(fs.gate)
+	<-closed
+	select {
+	case <-w.stopped:
+	default:
+		t.Error("warmer goroutine still running after Close")
+	}
+	if n := fs.openCount("/"); n != 1 {
+		t.Errorf("/ opened %d times, want 1", n)
+	}
+	if n := fs.openCount("/dir"); n != 0 {
+		t.Errorf("/dir opened %d times after Close, want 0", n)
+	}
+	w.enqueue("/dir")
+	if len(w.pending) != 0 {
+		t.Errorf("enqueue after Close queued %v", w.pending)
+	}
+}
+
+func TestMountWithoutWarmOnMount(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.EnableDirCache = true
+	})
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, "/")
+	call := &RPCCall{Header: RPCMsgHeader{Program: MOUNT_PROGRAM, Version: MOUNT_V3, Procedure: 1}}
+	if _, err := handler.handleMountCall(call, &buf, &RPCReply{}, auth); err != nil {
+		t.Fatalf("handleMountCall: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if entries, _, _ := srv.handler.dirCache.Stats(); entries != 0 {
+		t.Errorf("dir cache holds %d listings without WarmOnMount, want 0", entries)
+	}
+}
@@ -0,0 +1,217 @@
+// multi_export.go: Serving several filesystems from one Server.
+//
+// Server.AddExport registers an AbsfsNFS at an export path alongside the
+// handler set with SetHandler. Each added export numbers its file handles
+// with its position in the top 16 bits, so an NFS call is routed to the
+// export that issued the handle starting its arguments, and each export's
+// own ExportOptions (read-only, squashing, caching) apply to its calls.
+// MOUNT MNT resolves a path to the export it falls under, and is
+// authenticated against that export's AllowedIPs, Secure and ClientRules;
+// MOUNT EXPORT lists every export path.
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// exportHandleShift is the position of the export number within a file
+// handle; the bits below it number files within the export
+const exportHandleShift = 48
+
+// handleIDMask selects the per-export part of a file handle
+const handleIDMask = 1<<exportHandleShift - 1
+
+// maxExports is the number of exports AddExport can register, all export
+// numbers but 0, which belongs to the SetHandler handler
+const maxExports = 1<<(64-exportHandleShift) - 1
+
+// exportMount is an AbsfsNFS served at a path by AddExport
+type exportMount struct {
+	path string
+	nfs  *AbsfsNFS
+}
+
+// AddExport serves nfs at exportPath, in addition to the handler set with
+// SetHandler, which keeps serving "/" when one is set. If no handler has
+// been set, the first export added also supplies the server-wide settings
+// the handler otherwise does -- rate limiting, worker pool, TCP tuning,
+// logging -- without being exported at "/". Each AbsfsNFS may be added
+// once. Must be called before Listen() and is not safe for concurrent use.
+func (s *Server) AddExport(exportPath string, nfs *AbsfsNFS) error {
+	if nfs == nil {
+		return fmt.Errorf("nil handler for export %q", exportPath)
+	}
+	if !strings.HasPrefix(exportPath, "/") {
+		return fmt.Errorf("export path %q is not absolute", exportPath)
+	}
+	exportPath = path.Clean(exportPath)
+	if exportPath == "/" {
+		return fmt.Errorf("export path \"/\" is served by SetHandler")
+	}
+	if len(s.mounts) == maxExports {
+		return fmt.Errorf("too many exports (maximum %d)", maxExports)
+	}
+	for _, m := range s.mounts {
+		if m.path == exportPath {
+			return fmt.Errorf("export path %q already added", exportPath)
+		}
+		if m.nfs == nfs {
+			return fmt.Errorf("handler already exported at %q", m.path)
+		}
+	}
+
+	s.mounts = append(s.mounts, exportMount{path: exportPath, nfs: nfs})
+	nfs.fileMap.setExportNumber(uint64(len(s.mounts)))
+	nfs.nfsVersions = s.nfsVersions()
+	if s.handler == nil {
+		s.handler = nfs
+		s.handlerUnexported = true
+	}
+	return nil
+}
+
+// exportForHandle returns the AbsfsNFS that issued handle
+func (s *Server) exportForHandle(handle uint64) *AbsfsNFS {
+	if n := handle >> exportHandleShift; n > 0 && n <= uint64(len(s.mounts)) {
+		return s.mounts[n-1].nfs
+	}
+	return s.handler
+}
+
+// exportForPath returns the AbsfsNFS serving mountPath and the path of
+// the same directory within its filesystem. The longest export path that
+// mountPath falls under wins; other paths go to the SetHandler handler.
+func (s *Server) exportForPath(mountPath string) (*AbsfsNFS, string, bool) {
+	var best *exportMount
+	for i := range s.mounts {
+		m := &s.mounts[i]
+		if mountPath != m.path && !strings.HasPrefix(mountPath, m.path+"/") {
+			continue
+		}
+		if best == nil || len(m.path) > len(best.path) {
+			best = m
+		}
+	}
+	if best != nil {
+		return best.nfs, path.Join("/", strings.TrimPrefix(mountPath, best.path)), true
+	}
+	if s.handlerUnexported {
+		return nil, "", false
+	}
+	return s.handler, mountPath, true
+}
+
+// removeClientSessions drops client's sessions on every export, for UMNTALL
+func (s *Server) removeClientSessions(client string) {
+	if !s.handlerUnexported {
+		s.handler.removeClientSessions(client)
+	}
+	for _, m := range s.mounts {
+		m.nfs.removeClientSessions(client)
+	}
+}
+
+// exportPaths lists the paths MOUNT EXPORT reports without an export table
+func (s *Server) exportPaths() []string {
+	var paths []string
+	if !s.handlerUnexported {
+		paths = append(paths, "/")
+	}
+	for _, m := range s.mounts {
+		paths = append(paths, m.path)
+	}
+	return paths
+}
+
+// nfs returns the AbsfsNFS the call being handled is for
+func (h *NFSProcedureHandler) nfs() *AbsfsNFS {
+	if h.export != nil {
+		return h.export
+	}
+	return h.server.handler
+}
+
+// forHandle returns a handler for calls on the export that issued handle
+func (h *NFSProcedureHandler) forHandle(handle uint64) *NFSProcedureHandler {
+	nfs := h.server.exportForHandle(handle)
+	if nfs == h.nfs() {
+		return h
+	}
+	return &NFSProcedureHandler{server: h.server, export: nfs}
+}
+
+// routeCall picks the export an NFS call is for from the file handle that
+// starts its arguments, returning a reader that still yields all of body.
+// Calls without a decodable handle stay with h and fail there.
+func (h *NFSProcedureHandler) routeCall(call *RPCCall, body io.Reader) (*NFSProcedureHandler, io.Reader) {
+	if len(h.server.mounts) == 0 {
+		return h, body
+	}
+	handle, ok, body := peekHandle(call, body)
+	if !ok {
+		return h, body
+	}
+	return h.forHandle(handle), body
+}
+
+// routeMount picks the export a MOUNT MNT call is for from the path it
+// mounts, returning a reader that still yields all of body, so the call is
+// authenticated against that export's policy. Other MOUNT calls, and paths
+// no export serves, stay with h.
+func (h *NFSProcedureHandler) routeMount(call *RPCCall, body io.Reader) (*NFSProcedureHandler, io.Reader) {
+	if len(h.server.mounts) == 0 || call.Header.Procedure != 1 { // MNT
+		return h, body
+	}
+	var head bytes.Buffer
+	mountPath, err := xdrDecodeString(io.TeeReader(body, &head))
+	body = io.MultiReader(&head, body)
+	if err != nil || !strings.HasPrefix(mountPath, "/") {
+		return h, body
+	}
+	nfs, _, ok := h.server.exportForPath(path.Clean(mountPath))
+	if !ok || nfs == h.nfs() {
+		return h, body
+	}
+	return &NFSProcedureHandler{server: h.server, export: nfs}, body
+}
+
+// routeLock picks the export an NLM call is for from the file handle in
+// its nlm4_lock argument, returning a reader that still yields all of body,
+// so the lock is kept in that export's table under its EnableLocking.
+// Calls without a decodable handle stay with h.
+func (h *NFSProcedureHandler) routeLock(call *RPCCall, body io.Reader) (*NFSProcedureHandler, io.Reader) {
+	if len(h.server.mounts) == 0 {
+		return h, body
+	}
+	var flags int // words between the cookie and the nlm4_lock
+	switch call.Header.Procedure {
+	case NLMPROC4_TEST, NLMPROC4_GRANTED:
+		flags = 1
+	case NLMPROC4_LOCK, NLMPROC4_CANCEL:
+		flags = 2
+	case NLMPROC4_UNLOCK:
+	default:
+		return h, body
+	}
+	var head bytes.Buffer
+	r := io.TeeReader(body, &head)
+	body = io.MultiReader(&head, body)
+	if _, err := decodeNetobj(r); err != nil {
+		return h, body
+	}
+	for ; flags > 0; flags-- {
+		if _, err := xdrDecodeUint32(r); err != nil {
+			return h, body
+		}
+	}
+	alock, err := decodeNLM4Lock(r)
+	if err != nil || len(alock.fh) != 8 {
+		return h, body
+	}
+	return h.forHandle(binary.BigEndian.Uint64([]byte(alock.fh))), body
+}
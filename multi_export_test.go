@@ -0,0 +1,295 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// newExport serves a fresh memfs holding path with content
+func newExport(t *testing.T, path, content string, opts ExportOptions) (*memfs.FileSystem, *AbsfsNFS) {
+	t.Helper()
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.MkdirAll("/sub", 0755)
+	writeTestFile(t, fs, path, content)
+	nfs, err := New(fs, opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+	return fs, nfs
+}
+
+// callProc runs one call through HandleCall and returns its reply data
+func callProc(t *testing.T, h *NFSProcedureHandler, auth *AuthContext, prog, vers, proc uint32, args []byte) *bytes.Reader {
+	t.Helper()
+	call := &RPCCall{Header: RPCMsgHeader{Program: prog, Version: vers, Procedure: proc}}
+	reply, err := h.HandleCall(call, bytes.NewReader(args), auth)
+	if err != nil {
+		t.Fatalf("HandleCall(%d, %d): %v", prog, proc, err)
+	}
+	return bytes.NewReader(reply.Data.([]byte))
+}
+
+// mountExport sends MOUNT MNT for dir and returns the MNT3 status and handle
+func mountExport(t *testing.T, h *NFSProcedureHandler, auth *AuthContext, dir string) (uint32, uint64) {
+	t.Helper()
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, dir)
+	r := callProc(t, h, auth, MOUNT_PROGRAM, MOUNT_V3, 1, buf.Bytes())
+	status, _ := xdrDecodeUint32(r)
+	if status != 0 {
+		return status, 0
+	}
+	handle, err := xdrDecodeFileHandle(r)
+	if err != nil {
+		t.Fatalf("MNT %s: %v", dir, err)
+	}
+	return status, handle
+}
+
+func TestAddExport(t *testing.T) {
+	_, ro := newExport(t, "/a.txt", "alpha", ExportOptions{ReadOnly: true})
+	rwFS, rw := newExport(t, "/sub/b.txt", "bravo!", ExportOptions{})
+
+	srv, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.AddExport("/ro", ro); err != nil {
+		t.Fatalf("AddExport /ro: %v", err)
+	}
+	if err := srv.AddExport("/rw/", rw); err != nil {
+		t.Fatalf("AddExport /rw: %v", err)
+	}
+	if err := srv.AddExport("/rw", ro); err == nil {
+		t.Error("AddExport of a path already exported succeeded")
+	}
+	h := &NFSProcedureHandler{server: srv}
+	auth := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 1023, Credential: &RPCCredential{Flavor: AUTH_NONE}}
+
+	// EXPORT lists both paths and no "/"
+	r := callProc(t, h, auth, MOUNT_PROGRAM, MOUNT_V3, 5, nil)
+	var listed []string
+	for more, _ := xdrDecodeUint32(r); more == 1; more, _ = xdrDecodeUint32(r) {
+		p, _ := xdrDecodeString(r)
+		xdrDecodeUint32(r) // no groups
+		listed = append(listed, p)
+	}
+	if len(listed) != 2 || listed[0] != "/ro" || listed[1] != "/rw" {
+		t.Errorf("EXPORT listed %q, want [/ro /rw]", listed)
+	}
+
+	status, roRoot := mountExport(t, h, auth, "/ro")
+	if status != 0 {
+		t.Fatalf("MNT /ro: status %d", status)
+	}
+	status, rwSub := mountExport(t, h, auth, "/rw/sub")
+	if status != 0 {
+		t.Fatalf("MNT /rw/sub: status %d", status)
+	}
+	for _, dir := range []string{"/", "/other", "/rwx"} {
+		if status, _ := mountExport(t, h, auth, dir); status != 2 {
+			t.Errorf("MNT %s: status %d, want MNT3ERR_NOENT", dir, status)
+		}
+	}
+	if roRoot>>exportHandleShift == rwSub>>exportHandleShift {
+		t.Fatalf("handles %#x and %#x carry the same export number", roRoot, rwSub)
+	}
+
+	// Each handle reaches its own filesystem
+	lookup := func(dir uint64, name string) uint32 {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dir)
+		xdrEncodeString(&buf, name)
+		status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_LOOKUP, buf.Bytes()))
+		return status
+	}
+	if status := lookup(roRoot, "a.txt"); status != NFS_OK {
+		t.Errorf("LOOKUP a.txt in /ro: status %d", status)
+	}
+	if status := lookup(rwSub, "b.txt"); status != NFS_OK {
+		t.Errorf("LOOKUP b.txt in /rw/sub: status %d", status)
+	}
+	if status := lookup(roRoot, "sub"); status != NFS_OK {
+		t.Errorf("LOOKUP sub in /ro: status %d", status)
+	}
+	if status := lookup(rwSub, "a.txt"); status != NFSERR_NOENT {
+		t.Errorf("LOOKUP a.txt in /rw/sub: status %d, want NOENT", status)
+	}
+
+	// Each export applies its own ReadOnly
+	create := func(dir uint64, name string) uint32 {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dir)
+		xdrEncodeString(&buf, name)
+		xdrEncodeUint32(&buf, 0) // UNCHECKED
+		buf.Write(encodeSattr3(false, 0, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+		status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_CREATE, buf.Bytes()))
+		return status
+	}
+	if status := create(roRoot, "new.txt"); status != NFSERR_ROFS {
+		t.Errorf("CREATE in /ro: status %d, want ROFS", status)
+	}
+	if status := create(rwSub, "new.txt"); status != NFS_OK {
+		t.Errorf("CREATE in /rw/sub: status %d", status)
+	}
+	if _, err := rwFS.Stat("/sub/new.txt"); err != nil {
+		t.Errorf("CREATE in /rw/sub did not reach its filesystem: %v", err)
+	}
+
+	// RENAME between exports is a cross-device rename
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, rwSub)
+	xdrEncodeString(&buf, "b.txt")
+	xdrEncodeFileHandle(&buf, roRoot)
+	xdrEncodeString(&buf, "b.txt")
+	if status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_RENAME, buf.Bytes())); status != NFSERR_XDEV {
+		t.Errorf("RENAME across exports: status %d, want XDEV", status)
+	}
+}
+
+func TestAddExportAlongsideHandler(t *testing.T) {
+	_, root := newExport(t, "/root.txt", "root", ExportOptions{})
+	_, extra := newExport(t, "/extra.txt", "extra", ExportOptions{})
+	srv, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetHandler(root)
+	if err := srv.AddExport("/extra", extra); err != nil {
+		t.Fatalf("AddExport: %v", err)
+	}
+	h := &NFSProcedureHandler{server: srv}
+	auth := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 1023, Credential: &RPCCredential{Flavor: AUTH_NONE}}
+
+	for _, tc := range []struct {
+		dir, name string
+		size      uint64
+	}{
+		{"/", "root.txt", 4},
+		{"/extra", "extra.txt", 5},
+	} {
+		status, handle := mountExport(t, h, auth, tc.dir)
+		if status != 0 {
+			t.Fatalf("MNT %s: status %d", tc.dir, status)
+		}
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, handle)
+		xdrEncodeString(&buf, tc.name)
+		r := callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_LOOKUP, buf.Bytes())
+		if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+			t.Errorf("LOOKUP %s under %s: status %d", tc.name, tc.dir, status)
+			continue
+		}
+		xdrDecodeFileHandle(r)
+		xdrDecodeUint32(r) // attributes follow
+		var attrs struct{ Type, Mode, Nlink, UID, GID uint32 }
+		binary.Read(r, binary.BigEndian, &attrs)
+		var size uint64
+		binary.Read(r, binary.BigEndian, &size)
+		if size != tc.size {
+			t.Errorf("%s under %s has size %d, want %d", tc.name, tc.dir, size, tc.size)
+		}
+	}
+}
+
+func TestAddExportMountPolicy(t *testing.T) {
+	_, root := newExport(t, "/root.txt", "root", ExportOptions{AllowedIPs: []string{"10.0.0.1"}})
+	_, extra := newExport(t, "/extra.txt", "extra", ExportOptions{AllowedIPs: []string{"10.0.0.2"}})
+	srv, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetHandler(root)
+	if err := srv.AddExport("/extra", extra); err != nil {
+		t.Fatalf("AddExport: %v", err)
+	}
+	h := &NFSProcedureHandler{server: srv}
+
+	// MNT is checked against the AllowedIPs of the export it mounts, not
+	// the SetHandler handler's
+	for _, tc := range []struct {
+		client, dir string
+		allowed     bool
+	}{
+		{"10.0.0.1", "/", true},
+		{"10.0.0.1", "/extra", false},
+		{"10.0.0.2", "/", false},
+		{"10.0.0.2", "/extra", true},
+	} {
+		var buf bytes.Buffer
+		xdrEncodeString(&buf, tc.dir)
+		reply := callAs(t, h, tc.client, MOUNT_PROGRAM, MOUNT_V3, 1, buf.Bytes())
+		if !tc.allowed {
+			if reply.Status != MSG_DENIED {
+				t.Errorf("MNT %s from %s: reply status %d, want MSG_DENIED", tc.dir, tc.client, reply.Status)
+			}
+			continue
+		}
+		if reply.Status != MSG_ACCEPTED {
+			t.Errorf("MNT %s from %s: denied", tc.dir, tc.client)
+			continue
+		}
+		if status := binary.BigEndian.Uint32(reply.Data.([]byte)); status != 0 {
+			t.Errorf("MNT %s from %s: status %d", tc.dir, tc.client, status)
+		}
+	}
+}
+
+func TestAddExportLocking(t *testing.T) {
+	_, root := newExport(t, "/root.txt", "root", ExportOptions{})
+	_, extra := newExport(t, "/extra.txt", "extra", ExportOptions{EnableLocking: true})
+	srv, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetHandler(root)
+	if err := srv.AddExport("/extra", extra); err != nil {
+		t.Fatalf("AddExport: %v", err)
+	}
+	h := &NFSProcedureHandler{server: srv}
+	auth := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 1023, Credential: &RPCCredential{Flavor: AUTH_NONE}}
+
+	// NLM calls are served by the export that issued the handle they lock,
+	// under that export's EnableLocking
+	lock := func(dir uint64) *RPCReply {
+		var buf bytes.Buffer
+		xdrEncodeString(&buf, "ck")
+		xdrEncodeUint32(&buf, 0) // block
+		xdrEncodeUint32(&buf, 1) // exclusive
+		encodeNLM4Lock(&buf, nlmLockArg(dir, 1, 0, 0))
+		xdrEncodeUint32(&buf, 0) // reclaim
+		xdrEncodeUint32(&buf, 1) // state
+		call := &RPCCall{Header: RPCMsgHeader{Program: NLM_PROGRAM, Version: NLM_V4, Procedure: NLMPROC4_LOCK}}
+		reply, err := h.HandleCall(call, bytes.NewReader(buf.Bytes()), auth)
+		if err != nil {
+			t.Fatalf("HandleCall: %v", err)
+		}
+		return reply
+	}
+
+	_, extraRoot := mountExport(t, h, auth, "/extra")
+	reply := lock(extraRoot)
+	if reply.AcceptStatus != SUCCESS {
+		t.Fatalf("LOCK on /extra: accept status %d", reply.AcceptStatus)
+	}
+	r := bytes.NewReader(reply.Data.([]byte))
+	decodeNetobj(r)
+	if status, _ := xdrDecodeUint32(r); status != NLM4_GRANTED {
+		t.Errorf("LOCK on /extra: status %d, want GRANTED", status)
+	}
+	if len(extra.locks.held) != 1 || len(root.locks.held) != 0 {
+		t.Errorf("locks held: %d on /extra, %d on /, want 1 and 0", len(extra.locks.held), len(root.locks.held))
+	}
+
+	_, rootHandle := mountExport(t, h, auth, "/")
+	if reply := lock(rootHandle); reply.AcceptStatus != PROG_UNAVAIL {
+		t.Errorf("LOCK on / without EnableLocking: accept status %d, want PROG_UNAVAIL", reply.AcceptStatus)
+	}
+}
@@ -3,6 +3,7 @@ package absnfs
 import (
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -514,3 +515,87 @@ func TestNegativeCacheConfigureTimeout(t *testing.T) {
 		t.Errorf("Expected negativeTTL=%v, got %v", customTimeout, server.attrCache.negativeTTL)
 	}
 }
+
+// TestNegativeCachePerDirLimit tests that one directory's misses cannot evict
+// the negative entries of others
+func TestNegativeCachePerDirLimit(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.Mkdir("/scanned", 0755)
+	fs.Mkdir("/other", 0755)
+
+	server, err := New(fs, ExportOptions{
+		CacheNegativeLookups:   true,
+		NegativeCacheTimeout:   time.Minute,
+		AttrCacheSize:          2000,
+		NegativeCacheMaxPerDir: 50,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		server.Lookup(filepath.Join("/other", "missing"+string(rune('a'+i))))
+	}
+	for i := 0; i < 1000; i++ {
+		server.Lookup(filepath.Join("/scanned", "probe"+strconv.Itoa(i)))
+	}
+
+	inDir := func(dir string) int {
+		n := 0
+		server.attrCache.mu.RLock()
+		defer server.attrCache.mu.RUnlock()
+		for path, cached := range server.attrCache.cache {
+			if cached.isNegative && isChildOf(path, dir) {
+				n++
+			}
+		}
+		return n
+	}
+	if n := inDir("/scanned"); n != 50 {
+		t.Errorf("/scanned holds %d negative entries, want the cap of 50", n)
+	}
+	if n := inDir("/other"); n != 5 {
+		t.Errorf("/other holds %d negative entries, want all 5", n)
+	}
+
+	// The newest probes survive; the oldest were evicted
+	if attrs, found := server.attrCache.Get("/scanned/probe999"); !found || attrs != nil {
+		t.Error("newest probe not negatively cached")
+	}
+	if _, found := server.attrCache.Get("/scanned/probe0"); found {
+		t.Error("oldest probe still cached past the per-directory cap")
+	}
+	if _, found := server.attrCache.Get("/other/missinga"); !found {
+		t.Error("/other negative entry evicted by /scanned probes")
+	}
+
+	// Lowering the cap trims at once
+	server.UpdateTuningOptions(func(t *TuningOptions) { t.NegativeCacheMaxPerDir = 10 })
+	if n := inDir("/scanned"); n != 10 {
+		t.Errorf("/scanned holds %d negative entries after lowering the cap, want 10", n)
+	}
+	if n := server.attrCache.NegativeStats(); n != 15 {
+		t.Errorf("NegativeStats = %d, want 15", n)
+	}
+
+	// Zero keeps the cap rather than removing it
+	server.UpdateTuningOptions(func(t *TuningOptions) { t.NegativeCacheMaxPerDir = 0 })
+	server.attrCache.mu.RLock()
+	limit := server.attrCache.maxNegativePerDir
+	server.attrCache.mu.RUnlock()
+	if limit != 10 {
+		t.Errorf("per-directory cap after an update to 0 = %d, want 10 kept", limit)
+	}
+	defaulted, err := New(fs, ExportOptions{CacheNegativeLookups: true})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer defaulted.Close()
+	if limit := defaulted.attrCache.maxNegativePerDir; limit != 256 {
+		t.Errorf("per-directory cap with NegativeCacheMaxPerDir 0 = %d, want the default 256", limit)
+	}
+}
@@ -3,19 +3,23 @@
 // Contains NFSProcedureHandler which maps NFS3 procedure numbers to
 // handler functions and dispatches incoming RPC calls via HandleCall.
 // Includes error reply helpers, drain-and-swap logic for live policy
-// updates, and routing for NFS, MOUNT, and portmapper programs.
+// updates, and routing for NFS, MOUNT, NLM, and portmapper programs.
 package absnfs
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"syscall"
+	"time"
 )
 
 // NFSProcedureHandler handles NFS procedure calls
 type NFSProcedureHandler struct {
 	server *Server
+	export *AbsfsNFS // Export a routed call is for; nil means server.handler
 }
 
 // RPCError represents an RPC-specific error with a status code
@@ -57,11 +61,49 @@ var nfsHandlers = map[uint32]nfsHandler{
 	NFSPROC3_MKNOD:       (*NFSProcedureHandler).handleMknod,
 }
 
+// isMetadataCall reports whether call is a cheap metadata procedure, which
+// runs on the workers reserved by MetadataWorkerReserve when there are any
+func isMetadataCall(call *RPCCall) bool {
+	if call.Header.Program != NFS_PROGRAM {
+		return false
+	}
+	if call.Header.Version == NFS_V2 {
+		switch call.Header.Procedure {
+		case NFSPROC2_NULL, NFSPROC2_GETATTR, NFSPROC2_LOOKUP:
+			return true
+		}
+		return false
+	}
+	switch call.Header.Procedure {
+	case NFSPROC3_NULL, NFSPROC3_GETATTR, NFSPROC3_LOOKUP, NFSPROC3_ACCESS,
+		NFSPROC3_READLINK, NFSPROC3_FSSTAT, NFSPROC3_FSINFO, NFSPROC3_PATHCONF:
+		return true
+	}
+	return false
+}
+
 // HandleCall processes an NFS RPC call and returns a reply.
 // It snapshots options at entry, tracks in-flight requests for drain-and-swap,
 // and rejects new requests during a policy drain.
 func (h *NFSProcedureHandler) HandleCall(call *RPCCall, body io.Reader, authCtx *AuthContext) (*RPCReply, error) {
-	handler := h.server.handler
+	reply, err := h.handleCall(call, body, authCtx)
+	if err == nil && call.Header.Program == NFS_PROGRAM && call.Header.Version == NFS_V2 && v2Retry(reply) {
+		return nil, errV2Retry
+	}
+	return reply, err
+}
+
+// handleCall serves HandleCall for every program and version
+func (h *NFSProcedureHandler) handleCall(call *RPCCall, body io.Reader, authCtx *AuthContext) (*RPCReply, error) {
+	switch call.Header.Program {
+	case NFS_PROGRAM:
+		h, body = h.routeCall(call, body)
+	case MOUNT_PROGRAM:
+		h, body = h.routeMount(call, body)
+	case NLM_PROGRAM:
+		h, body = h.routeLock(call, body)
+	}
+	handler := h.nfs()
 
 	reply := &RPCReply{
 		Header:       call.Header,
@@ -94,7 +136,28 @@ func (h *NFSProcedureHandler) HandleCall(call *RPCCall, body io.Reader, authCtx
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	// An RPCSEC_GSS call is checked against its security context first,
+	// so its principal is known when the credential is validated
+	var gssCred *gssCredential
+	if call.Credential.Flavor == RPCSEC_GSS {
+		var authStat uint32
+		var err error
+		if gssCred, authStat, err = h.gssVerify(call, reply, authCtx); err != nil {
+			handler.policyRWMu.RUnlock()
+			reply.Status = MSG_DENIED
+			reply.AuthStat = authStat
+			if h.server.options.Debug {
+				h.server.logger.Printf("Authentication denied: %v (client: %s:%d)", err, authCtx.ClientIP, authCtx.ClientPort)
+			}
+			if handler.metrics != nil {
+				handler.metrics.RecordError("AUTH")
+			}
+			return reply, nil
+		}
+	}
+
 	// Validate authentication using policy snapshot
+	authCtx.Ping = call.Header.Procedure == NFSPROC3_NULL
 	authResult := ValidateAuthentication(authCtx, opts.Policy)
 	if !authResult.Allowed {
 		handler.policyRWMu.RUnlock()
@@ -111,6 +174,67 @@ func (h *NFSProcedureHandler) HandleCall(call *RPCCall, body io.Reader, authCtx
 	// Apply squashed credentials to the auth context
 	authCtx.EffectiveUID = authResult.UID
 	authCtx.EffectiveGID = authResult.GID
+	opts.Policy.mapCredential(authCtx)
+	if authResult.Rule != nil {
+		authCtx.ctx = withClientRule(authCtx.callContext(), authResult.Rule)
+	}
+
+	// Reject an exact repeat of a verifier this client sent recently
+	if window := opts.Policy.ReplayWindow; window > 0 && call.Verifier.Flavor != AUTH_NONE {
+		if handler.replays.replayed(authCtx.ClientIP, call.Verifier, window, time.Now()) {
+			handler.policyRWMu.RUnlock()
+			reply.Status = MSG_DENIED
+			reply.AuthStat = AUTH_REJECTEDVERF
+			if h.server.options.Debug {
+				h.server.logger.Printf("Authentication denied: replayed verifier (client: %s:%d, flavor: %d)",
+					authCtx.ClientIP, authCtx.ClientPort, call.Verifier.Flavor)
+			}
+			if handler.metrics != nil {
+				handler.metrics.RecordError("AUTH")
+			}
+			return reply, nil
+		}
+	}
+
+	// Apply the export table loaded by LoadExports, if any
+	if table := h.server.exports.Load(); table != nil {
+		readOnly, allowed := table.clientAccess(authCtx.ClientIP)
+		if !allowed {
+			handler.policyRWMu.RUnlock()
+			reply.Status = MSG_DENIED
+			if h.server.options.Debug {
+				h.server.logger.Printf("Authentication denied: client %s not in export table", authCtx.ClientIP)
+			}
+			if handler.metrics != nil {
+				handler.metrics.RecordError("AUTH")
+			}
+			return reply, nil
+		}
+		authCtx.ReadOnly = readOnly
+	}
+
+	// RPCSEC_GSS context establishment and destruction are answered here
+	// rather than by the program
+	if gssCred != nil && gssCred.proc != RPCSEC_GSS_DATA {
+		handler.policyRWMu.RUnlock()
+		return h.handleGSSControl(gssCred, call, body, reply, authCtx, opts.Policy.GSSAcceptor), nil
+	}
+
+	// During a backing filesystem outage only NULL is served; JUKEBOX
+	// makes clients back off and retry instead of piling up timeouts
+	if handler.InOutage() && call.Header.Program == NFS_PROGRAM && call.Header.Procedure != NFSPROC3_NULL {
+		handler.policyRWMu.RUnlock()
+		return nfsErrorReply(reply, NFSERR_JUKEBOX), nil
+	}
+
+	// List the call for ActiveOperations while it is waited on, so
+	// CancelOperation can abandon it
+	if call.Header.Program == NFS_PROGRAM {
+		var path string
+		path, body = h.peekHandlePath(call, body)
+		opID := handler.activeOps.add(nfsProcName(call.Header), path, authCtx.ClientIP, cancel)
+		defer handler.activeOps.remove(opID)
+	}
 
 	// Handle the call with timeout
 	replyChan := make(chan *RPCReply, 1)
@@ -136,6 +260,8 @@ func (h *NFSProcedureHandler) HandleCall(call *RPCCall, body io.Reader, authCtx
 			result, err = h.handleMountCall(call, body, reply, authCtx)
 		case NFS_PROGRAM:
 			result, err = h.handleNFSCall(call, body, reply, authCtx)
+		case NLM_PROGRAM:
+			result, err = h.handleNLMCall(call, body, reply, authCtx)
 		default:
 			reply.AcceptStatus = PROG_UNAVAIL
 			select {
@@ -169,6 +295,9 @@ func (h *NFSProcedureHandler) HandleCall(call *RPCCall, body io.Reader, authCtx
 
 	select {
 	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, fmt.Errorf("operation cancelled: %w", ctx.Err())
+		}
 		return nil, fmt.Errorf("operation timed out")
 	case result := <-replyChan:
 		return result, nil
@@ -177,6 +306,13 @@ func (h *NFSProcedureHandler) HandleCall(call *RPCCall, body io.Reader, authCtx
 
 // Helper functions for common operations
 
+// readOnly reports whether mutating procedures must be refused, either
+// because the export is read-only for this client, by ReadOnly or its
+// client rule, or because the export table maps it to an "ro" spec.
+func (h *NFSProcedureHandler) readOnly(authCtx *AuthContext) bool {
+	return authCtx.ReadOnly || h.nfs().readOnlyIn(authCtx.callContext())
+}
+
 // nfsErrorReply creates an error response with the given NFS status code.
 // Used for procedures that need only the status (e.g. GETATTR).
 func nfsErrorReply(reply *RPCReply, status uint32) *RPCReply {
@@ -235,7 +371,45 @@ func nfsErrorWithDoubleWcc(reply *RPCReply, status uint32) *RPCReply {
 // lookupNode retrieves a node from the file handle map
 // Returns the node and true if found, nil and false otherwise
 func (h *NFSProcedureHandler) lookupNode(handle uint64) (*NFSNode, bool) {
-	file, ok := h.server.handler.fileMap.Get(handle)
+	node, ok := h.handleNode(handle)
+	if !ok {
+		return nil, false
+	}
+	// A path deleted and recreated as another type of file is a different
+	// object, so the handle no longer refers to anything
+	if attrs, err := h.nfs().GetAttr(node); err == nil && typeChanged(node, attrs) {
+		return nil, false
+	}
+	if h.recreated(node, handle) {
+		return nil, false
+	}
+	return node, true
+}
+
+// resolveHandle resolves handle as lookupNode does and returns the node's
+// current attributes along with it, from the same GetAttr that checked its
+// file type, so handlers that need them read them once. The status is
+// NFSERR_STALE if the handle no longer refers to anything, or the mapped
+// GetAttr failure.
+func (h *NFSProcedureHandler) resolveHandle(handle uint64) (*NFSNode, *NFSAttrs, uint32) {
+	node, ok := h.handleNode(handle)
+	if !ok {
+		return nil, nil, NFSERR_STALE
+	}
+	attrs, err := h.nfs().GetAttr(node)
+	if err != nil {
+		return nil, nil, handleErrorStatus(err)
+	}
+	if typeChanged(node, attrs) || h.recreated(node, handle) {
+		return nil, nil, NFSERR_STALE
+	}
+	return node, attrs, NFS_OK
+}
+
+// handleNode returns the node handle was allocated for, without checking
+// that it still refers to the same file
+func (h *NFSProcedureHandler) handleNode(handle uint64) (*NFSNode, bool) {
+	file, ok := h.nfs().fileMap.Get(handle)
 	if !ok {
 		return nil, false
 	}
@@ -243,12 +417,92 @@ func (h *NFSProcedureHandler) lookupNode(handle uint64) (*NFSNode, bool) {
 	return node, ok
 }
 
+// recreated reports whether, with PersistentHandles, node's path was last
+// seen holding a file with another identity (deleted and recreated), which
+// no longer answers to handle. A fresh GetAttr of node refreshes what was
+// last seen.
+func (h *NFSProcedureHandler) recreated(node *NFSNode, handle uint64) bool {
+	if index := h.nfs().handleIndex; index != nil && node.shard == "" {
+		if current, ok := index.current(node.path); ok && current != handle&handleIDMask {
+			return true
+		}
+	}
+	return false
+}
+
+// typeChanged reports whether attrs, fresh from the backing filesystem,
+// give node a different file type from the one recorded when its handle
+// was allocated
+func typeChanged(node *NFSNode, attrs *NFSAttrs) bool {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if node.attrs == nil {
+		return false
+	}
+	return node.attrs.Mode.Type() != attrs.Mode.Type()
+}
+
+// metadataThrottled reports whether the client has used up its
+// MetadataOpsPerSecond budget, in which case the caller answers
+// NFSERR_JUKEBOX so the client backs off. READ and WRITE are budgeted
+// separately, so a client scanning metadata keeps its data transfers.
+func (h *NFSProcedureHandler) metadataThrottled(authCtx *AuthContext) bool {
+	return h.throttled(authCtx, OpTypeMetadata)
+}
+
+// throttled reports whether the client has used up its budget for opType,
+// recording the refusal in the metrics
+func (h *NFSProcedureHandler) throttled(authCtx *AuthContext, opType OperationType) bool {
+	if h.nfs().rateLimiter == nil || !h.nfs().policy.Load().EnableRateLimiting {
+		return false
+	}
+	if h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, opType) {
+		return false
+	}
+	if h.nfs().metrics != nil {
+		h.nfs().metrics.RecordRateLimitExceeded()
+	}
+	return true
+}
+
+// handleErrorStatus maps an error from an operation on the object a file
+// handle refers to. The handle itself resolved, so a backing file that no
+// longer exists means the handle is stale rather than that a name was not
+// found; NOENT is reserved for name-based lookups.
+func handleErrorStatus(err error) uint32 {
+	if status := MapErrorToNFSStatus(err); status != NFSERR_NOENT {
+		return status
+	}
+	return NFSERR_STALE
+}
+
+// writeErrorStatus maps a failed WRITE. A file held exclusively elsewhere
+// (EBUSY/ETXTBSY) is reported as JUKEBOX so the client retries the write;
+// other procedures keep the generic mapping.
+func writeErrorStatus(err error) uint32 {
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY) {
+		return NFSERR_JUKEBOX
+	}
+	return handleErrorStatus(err)
+}
+
+// handleDecodeStatus maps a failure to decode a file handle argument. A
+// handle that was read but is malformed is NFSERR_BADHANDLE; arguments
+// that could not be read at all are GARBAGE_ARGS.
+func handleDecodeStatus(err error) uint32 {
+	var invalidHandle *InvalidFileHandleError
+	if errors.As(err, &invalidHandle) {
+		return NFSERR_BADHANDLE
+	}
+	return GARBAGE_ARGS
+}
+
 // decodeAndLookupHandle decodes a file handle from the body and looks up the node
 // Returns the node and handle value, or nil if not found (reply.Data will be set with error)
 func (h *NFSProcedureHandler) decodeAndLookupHandle(body io.Reader, reply *RPCReply) (*NFSNode, uint64) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		nfsErrorReply(reply, GARBAGE_ARGS)
+		nfsErrorReply(reply, handleDecodeStatus(err))
 		return nil, 0
 	}
 
@@ -262,7 +516,7 @@ func (h *NFSProcedureHandler) decodeAndLookupHandle(body io.Reader, reply *RPCRe
 }
 
 // encodeWccData encodes wcc_data (pre_op_attr + post_op_attr) to the buffer
-func encodeWccData(buf *bytes.Buffer, preAttrs, postAttrs *NFSAttrs) error {
+func encodeWccData(buf *bytes.Buffer, preAttrs, postAttrs *NFSAttrs, ids *idMaps) error {
 	// pre_op_attr: attributes_follow + wcc_attr
 	xdrEncodeUint32(buf, 1) // attributes_follow = TRUE
 	if err := encodeWccAttr(buf, preAttrs); err != nil {
@@ -271,13 +525,13 @@ func encodeWccData(buf *bytes.Buffer, preAttrs, postAttrs *NFSAttrs) error {
 
 	// post_op_attr: attributes_follow + fattr3
 	xdrEncodeUint32(buf, 1) // attributes_follow = TRUE
-	return encodeFileAttributes(buf, postAttrs)
+	return encodeFileAttributes(buf, postAttrs, ids)
 }
 
 // encodePostOpAttr encodes post_op_attr to the buffer
-func encodePostOpAttr(buf *bytes.Buffer, attrs *NFSAttrs) error {
+func encodePostOpAttr(buf *bytes.Buffer, attrs *NFSAttrs, ids *idMaps) error {
 	xdrEncodeUint32(buf, 1) // attributes_follow = TRUE
-	return encodeFileAttributes(buf, attrs)
+	return encodeFileAttributes(buf, attrs, ids)
 }
 
 // encodeNoPostOpAttr encodes an empty post_op_attr (attributes_follow = FALSE)
@@ -8,9 +8,11 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/absfs/absfs"
 	"github.com/absfs/memfs"
 )
 
@@ -475,6 +477,107 @@ func TestC1_FSINFODtprefEncoding(t *testing.T) {
 	t.Logf("FSINFO response length: %d bytes (dtpref should be uint32)", len(data))
 }
 
+func TestFSINFOPreferredDirReadSize(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		configured int
+		dtpref     uint32
+	}{
+		{"default", 0, 8192},
+		{"configured", 32768, 32768},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+				o.PreferredDirReadSize = tt.configured
+			})
+			var buf bytes.Buffer
+			xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/"))
+			result, err := handler.handleFsinfo(&buf, &RPCReply{}, auth)
+			if err != nil {
+				t.Fatalf("handleFsinfo: %v", err)
+			}
+			if status := readStatus(t, result); status != NFS_OK {
+				t.Fatalf("Expected NFS_OK, got %d", status)
+			}
+			// status, post_op_attr, then rtmax, rtpref, rtmult, wtmax, wtpref
+			// and wtmult ahead of dtpref
+			data := result.Data.([]byte)
+			if dtpref := binary.BigEndian.Uint32(data[4+4+84+4*6:]); dtpref != tt.dtpref {
+				t.Errorf("FSINFO dtpref = %d, want %d", dtpref, tt.dtpref)
+			}
+		})
+	}
+}
+
+func TestFSINFOTransferSizes(t *testing.T) {
+	for _, tt := range []struct {
+		name                  string
+		transfer, read, write int
+		rtmax, rtpref, rtmult uint32
+		wtmax, wtpref, wtmult uint32
+	}{
+		{"default", 0, 0, 0, 1048576, 65536, 4096, 1048576, 65536, 4096},
+		{"fast link", 1048576, 4194304, 2097152, 4194304, 1048576, 4096, 2097152, 1048576, 4096},
+		{"max under transfer size", 65536, 32768, 16384, 32768, 32768, 4096, 16384, 16384, 4096},
+		{"unaligned transfer size", 10000, 0, 0, 1048576, 8192, 4096, 1048576, 8192, 4096},
+		{"tiny transfer size", 1000, 0, 0, 1048576, 1000, 1000, 1048576, 1000, 1000},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+				o.TransferSize, o.MaxReadSize, o.MaxWriteSize = tt.transfer, tt.read, tt.write
+			})
+			var buf bytes.Buffer
+			xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/"))
+			result, err := handler.handleFsinfo(&buf, &RPCReply{}, auth)
+			if err != nil {
+				t.Fatalf("handleFsinfo: %v", err)
+			}
+			data := result.Data.([]byte)[4+4+84:]
+			got := func(i int) uint32 { return binary.BigEndian.Uint32(data[4*i:]) }
+			if got(0) != tt.rtmax || got(1) != tt.rtpref || got(2) != tt.rtmult {
+				t.Errorf("rtmax/rtpref/rtmult = %d/%d/%d, want %d/%d/%d", got(0), got(1), got(2), tt.rtmax, tt.rtpref, tt.rtmult)
+			}
+			if got(3) != tt.wtmax || got(4) != tt.wtpref || got(5) != tt.wtmult {
+				t.Errorf("wtmax/wtpref/wtmult = %d/%d/%d, want %d/%d/%d", got(3), got(4), got(5), tt.wtmax, tt.wtpref, tt.wtmult)
+			}
+			if got(1)%got(2) != 0 || got(4)%got(5) != 0 {
+				t.Error("preferred size is not a multiple of its multiple")
+			}
+		})
+	}
+}
+
+func TestHandleReadClampedToMaxReadSize(t *testing.T) {
+	// The read is bounded by rtmax, not by the smaller TransferSize
+	for _, tt := range []struct {
+		transfer, read int
+		want           string
+	}{
+		{0, 2, "he"},
+		{2, 4, "hell"},
+	} {
+		srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.TransferSize, o.MaxReadSize = tt.transfer, tt.read })
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/dir/file.txt"))
+		xdrEncodeUint64(&buf, 0)
+		xdrEncodeUint32(&buf, 100)
+		result, err := handler.handleRead(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleRead: %v", err)
+		}
+		data := result.Data.([]byte)
+		if status := binary.BigEndian.Uint32(data); status != NFS_OK {
+			t.Fatalf("READ: status %d", status)
+		}
+		res := data[4+4+84:]
+		count, eof := binary.BigEndian.Uint32(res), binary.BigEndian.Uint32(res[4:])
+		if int(count) != len(tt.want) || eof != 0 || string(res[12:12+count]) != tt.want {
+			t.Errorf("READ of 100 bytes under TransferSize %d, MaxReadSize %d: count %d eof %d data %q, want %q of more",
+				tt.transfer, tt.read, count, eof, res[12:12+count], tt.want)
+		}
+	}
+}
+
 // TestC2_ErrorReplyWithPostOp verifies read-type errors include post_op_attr
 func TestC2_ErrorReplyWithPostOp(t *testing.T) {
 	_, handler, authCtx, err := newTestServerForBugfixes()
@@ -825,6 +928,90 @@ func TestH4_StaleHandleError(t *testing.T) {
 	}
 }
 
+// TestRemovedHandleIsStale verifies a handle whose file was removed reports
+// STALE while a lookup of the removed name still reports NOENT
+func TestRemovedHandleIsStale(t *testing.T) {
+	server, handler, authCtx, err := newTestServerForHandlers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootHandle := getRootHandle(server)
+	fileHandle := getFileHandle(server, "/testfile.txt")
+
+	reply, _ := handler.handleRemove(bytes.NewReader(buildRemoveRequest(rootHandle, "testfile.txt")), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFS_OK {
+		t.Fatalf("REMOVE failed with status %d", status)
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, fileHandle)
+	args := buf.Bytes()
+
+	reply, _ = handler.handleGetattr(bytes.NewReader(args), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_STALE {
+		t.Errorf("GETATTR: expected NFSERR_STALE, got %d", status)
+	}
+
+	readArgs := append(append([]byte{}, args...), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10, 0)
+	reply, _ = handler.handleRead(bytes.NewReader(readArgs), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_STALE {
+		t.Errorf("READ: expected NFSERR_STALE, got %d", status)
+	}
+
+	reply, _ = handler.handleWrite(bytes.NewReader(buildWriteRequest(fileHandle, 0, []byte("x"))), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_STALE {
+		t.Errorf("WRITE: expected NFSERR_STALE, got %d", status)
+	}
+
+	reply, _ = handler.handleLookup(bytes.NewReader(buildLookupRequest(rootHandle, "testfile.txt")), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_NOENT {
+		t.Errorf("LOOKUP: expected NFSERR_NOENT, got %d", status)
+	}
+}
+
+// TestMalformedHandleIsBadHandle verifies a handle of the wrong length
+// reports BADHANDLE, while a well-formed handle to a removed file reports
+// STALE and unreadable arguments remain GARBAGE_ARGS
+func TestMalformedHandleIsBadHandle(t *testing.T) {
+	server, handler, authCtx, err := newTestServerForHandlers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootHandle := getRootHandle(server)
+	fileHandle := getFileHandle(server, "/testfile.txt")
+
+	short := []byte{0, 0, 0, 4, 1, 2, 3, 4}
+	reply, _ := handler.handleGetattr(bytes.NewReader(short), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_BADHANDLE {
+		t.Errorf("GETATTR with a 4-byte handle: expected NFSERR_BADHANDLE, got %d", status)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(16))
+	buf.Write(make([]byte, 16))
+	xdrEncodeString(&buf, "testfile.txt")
+	reply, _ = handler.handleLookup(bytes.NewReader(buf.Bytes()), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_BADHANDLE {
+		t.Errorf("LOOKUP with a 16-byte handle: expected NFSERR_BADHANDLE, got %d", status)
+	}
+
+	reply, _ = handler.handleGetattr(bytes.NewReader([]byte{0, 0}), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != GARBAGE_ARGS {
+		t.Errorf("GETATTR with truncated arguments: expected GARBAGE_ARGS, got %d", status)
+	}
+
+	reply, _ = handler.handleRemove(bytes.NewReader(buildRemoveRequest(rootHandle, "testfile.txt")), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFS_OK {
+		t.Fatalf("REMOVE failed with status %d", status)
+	}
+	buf.Reset()
+	xdrEncodeFileHandle(&buf, fileHandle)
+	reply, _ = handler.handleGetattr(bytes.NewReader(buf.Bytes()), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFSERR_STALE {
+		t.Errorf("GETATTR of a removed file: expected NFSERR_STALE, got %d", status)
+	}
+}
+
 // TestH5_ConfigurableTimeout verifies the timeout uses server config
 func TestH5_ConfigurableTimeout(t *testing.T) {
 	server, _, _, err := newTestServerForBugfixes()
@@ -1162,6 +1349,24 @@ func buildCommitRequest(handle uint64, offset uint64, count uint32) []byte {
 	return buf.Bytes()
 }
 
+// Helper to build a FILE_SYNC write request
+func buildWriteRequest(handle uint64, offset uint64, data []byte) []byte {
+	return buildStableWriteRequest(handle, offset, data, FILE_SYNC)
+}
+
+// Helper to build a write request with the given stable_how
+func buildStableWriteRequest(handle uint64, offset uint64, data []byte, stable uint32) []byte {
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, handle)
+	binary.Write(&buf, binary.BigEndian, offset)
+	binary.Write(&buf, binary.BigEndian, uint32(len(data))) // count
+	binary.Write(&buf, binary.BigEndian, stable)
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	buf.Write(make([]byte, (4-len(data)%4)%4))
+	return buf.Bytes()
+}
+
 // Helper to build a remove/rmdir request
 func buildRemoveRequest(handle uint64, name string) []byte {
 	var buf bytes.Buffer
@@ -2178,6 +2383,237 @@ func TestHandleLink(t *testing.T) {
 	})
 }
 
+// linkingFS stands in for a filesystem with hard links; memfs has none, so
+// Link copies the file, which is all the handler can observe
+type linkingFS struct {
+	*memfs.FileSystem
+	links []string
+}
+
+func (f *linkingFS) Link(oldname, newname string) error {
+	if _, err := f.Stat(newname); err == nil {
+		return os.ErrExist
+	}
+	src, err := f.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := f.Create(newname)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	f.links = append(f.links, oldname+" "+newname)
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func TestHandleLinkWithLinker(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	writeTestFile(t, mfs, "/file.txt", "hello")
+	fs := &linkingFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{EnableDirCache: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer nfs.Close()
+	srv := &Server{handler: nfs}
+	handler := &NFSProcedureHandler{server: srv}
+	authCtx := testAuthContext()
+
+	if !nfs.Features().HardLinks {
+		t.Error("Features().HardLinks = false with a Linker backend")
+	}
+	dirNode, _ := nfs.Lookup("/dir")
+	fileNode, _ := nfs.Lookup("/file.txt")
+	dirHandle := nfs.fileMap.Allocate(dirNode)
+	fileHandle := nfs.fileMap.Allocate(fileNode)
+
+	// Fill the directory cache so the link must invalidate it
+	if entries, err := nfs.ReadDir(dirNode); err != nil || len(entries) != 0 {
+		t.Fatalf("ReadDir(/dir) = %d entries, %v; want an empty directory", len(entries), err)
+	}
+
+	link := func(name string) *bytes.Reader {
+		result, err := handler.handleLink(bytes.NewReader(buildLinkRequest(fileHandle, dirHandle, name)), &RPCReply{}, authCtx)
+		if err != nil {
+			t.Fatalf("handleLink: %v", err)
+		}
+		return bytes.NewReader(result.Data.([]byte))
+	}
+	r := link("hard.txt")
+	if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+		t.Fatalf("LINK: status %d", status)
+	}
+	if len(fs.links) != 1 || fs.links[0] != "/file.txt /dir/hard.txt" {
+		t.Errorf("backend links = %q, want [/file.txt /dir/hard.txt]", fs.links)
+	}
+
+	// post_op_attr for the file, then wcc_data for the directory
+	if follows, _ := xdrDecodeUint32(r); follows != 1 {
+		t.Fatal("LINK reply has no file attributes")
+	}
+	r.Seek(84, io.SeekCurrent) // fattr3
+	if follows, _ := xdrDecodeUint32(r); follows != 1 {
+		t.Error("LINK reply has no directory pre-op attributes")
+	}
+	r.Seek(24, io.SeekCurrent) // wcc_attr
+	if follows, _ := xdrDecodeUint32(r); follows != 1 {
+		t.Error("LINK reply has no directory post-op attributes")
+	}
+	r.Seek(84, io.SeekCurrent)
+	if r.Len() != 0 {
+		t.Errorf("LINK reply has %d trailing bytes", r.Len())
+	}
+
+	entries, err := nfs.ReadDir(dirNode)
+	if err != nil || len(entries) != 1 || entries[0].path != "/dir/hard.txt" {
+		t.Errorf("ReadDir(/dir) after LINK = %d entries, %v; want hard.txt", len(entries), err)
+	}
+
+	// An existing name is EXIST
+	if status, _ := xdrDecodeUint32(link("hard.txt")); status != NFSERR_EXIST {
+		t.Errorf("LINK over an existing name: status %d, want NFSERR_EXIST", status)
+	}
+}
+
+// mknodFS stands in for a filesystem with special files; memfs has none, so
+// Mknod records the request and creates an empty file in its place
+type mknodFS struct {
+	*memfs.FileSystem
+	mode         os.FileMode
+	major, minor uint32
+}
+
+func (f *mknodFS) Mknod(name string, mode os.FileMode, major, minor uint32) error {
+	file, err := f.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	f.mode, f.major, f.minor = mode, major, minor
+	return file.Close()
+}
+
+// buildMknodRequest builds MKNOD3args for a mode-0600 special file, with
+// device numbers when ftype is a device
+func buildMknodRequest(dirHandle uint64, name string, ftype, major, minor uint32) []byte {
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, dirHandle)
+	xdrEncodeString(&buf, name)
+	xdrEncodeUint32(&buf, ftype)
+	buf.Write(encodeSattr3(true, 0600, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+	if ftype == NF3CHR || ftype == NF3BLK {
+		xdrEncodeUint32(&buf, major)
+		xdrEncodeUint32(&buf, minor)
+	}
+	return buf.Bytes()
+}
+
+func TestHandleMknod(t *testing.T) {
+	newHandler := func(t *testing.T, fs absfs.SymlinkFileSystem, opts ExportOptions) (*AbsfsNFS, *NFSProcedureHandler, uint64) {
+		nfs, err := New(fs, opts)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { nfs.Close() })
+		dirNode, err := nfs.Lookup("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nfs, &NFSProcedureHandler{server: &Server{handler: nfs}}, nfs.fileMap.Allocate(dirNode)
+	}
+	mknod := func(t *testing.T, h *NFSProcedureHandler, args []byte) *bytes.Reader {
+		result, err := h.handleMknod(bytes.NewReader(args), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleMknod: %v", err)
+		}
+		return bytes.NewReader(result.Data.([]byte))
+	}
+
+	t.Run("creates a character device", func(t *testing.T) {
+		mfs, _ := memfs.NewFS()
+		fs := &mknodFS{FileSystem: mfs}
+		nfs, h, root := newHandler(t, fs, ExportOptions{})
+		if !nfs.Features().SpecialFiles {
+			t.Error("Features().SpecialFiles = false with a Mknoder backend")
+		}
+
+		r := mknod(t, h, buildMknodRequest(root, "tty0", NF3CHR, 4, 64))
+		if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+			t.Fatalf("MKNOD: status %d", status)
+		}
+		if fs.major != 4 || fs.minor != 64 {
+			t.Errorf("backend got device %d,%d, want 4,64", fs.major, fs.minor)
+		}
+		if want := os.ModeDevice | os.ModeCharDevice | 0600; fs.mode != want {
+			t.Errorf("backend got mode %v, want %v", fs.mode, want)
+		}
+
+		// post_op_fh3, post_op_attr, then wcc_data for the directory
+		if follows, _ := xdrDecodeUint32(r); follows != 1 {
+			t.Fatal("MKNOD reply has no handle")
+		}
+		handle, _ := xdrDecodeFileHandle(r)
+		if node, ok := h.lookupNode(handle); !ok || node.path != "/tty0" {
+			t.Errorf("MKNOD handle %d does not name /tty0", handle)
+		}
+		if follows, _ := xdrDecodeUint32(r); follows != 1 {
+			t.Fatal("MKNOD reply has no attributes")
+		}
+		r.Seek(84, io.SeekCurrent)
+		if follows, _ := xdrDecodeUint32(r); follows != 1 {
+			t.Error("MKNOD reply has no directory pre-op attributes")
+		}
+	})
+
+	t.Run("named pipe needs no device numbers", func(t *testing.T) {
+		mfs, _ := memfs.NewFS()
+		fs := &mknodFS{FileSystem: mfs, major: 9, minor: 9}
+		_, h, root := newHandler(t, fs, ExportOptions{})
+		if status, _ := xdrDecodeUint32(mknod(t, h, buildMknodRequest(root, "fifo", NF3FIFO, 0, 0))); status != NFS_OK {
+			t.Fatalf("MKNOD fifo: status %d", status)
+		}
+		if fs.mode != os.ModeNamedPipe|0600 || fs.major != 0 || fs.minor != 0 {
+			t.Errorf("backend got mode %v device %d,%d, want a named pipe with no device", fs.mode, fs.major, fs.minor)
+		}
+	})
+
+	t.Run("regular file type is BADTYPE", func(t *testing.T) {
+		mfs, _ := memfs.NewFS()
+		_, h, root := newHandler(t, &mknodFS{FileSystem: mfs}, ExportOptions{})
+		if status, _ := xdrDecodeUint32(mknod(t, h, buildMknodRequest(root, "file", NF3REG, 0, 0))); status != NFSERR_BADTYPE {
+			t.Errorf("MKNOD NF3REG: status %d, want NFSERR_BADTYPE", status)
+		}
+	})
+
+	t.Run("read-only export is ACCESS_DENIED", func(t *testing.T) {
+		mfs, _ := memfs.NewFS()
+		_, h, root := newHandler(t, &mknodFS{FileSystem: mfs}, ExportOptions{ReadOnly: true})
+		if status, _ := xdrDecodeUint32(mknod(t, h, buildMknodRequest(root, "tty0", NF3CHR, 4, 64))); status != ACCESS_DENIED {
+			t.Errorf("MKNOD on a read-only export: status %d, want ACCESS_DENIED", status)
+		}
+		if _, err := mfs.Stat("/tty0"); err == nil {
+			t.Error("read-only export created the special file")
+		}
+	})
+
+	t.Run("unsupported backend is NOTSUPP", func(t *testing.T) {
+		mfs, _ := memfs.NewFS()
+		nfs, h, root := newHandler(t, mfs, ExportOptions{})
+		if nfs.Features().SpecialFiles {
+			t.Error("Features().SpecialFiles = true without a Mknoder backend")
+		}
+		if status, _ := xdrDecodeUint32(mknod(t, h, buildMknodRequest(root, "tty0", NF3CHR, 4, 64))); status != NFSERR_NOTSUPP {
+			t.Errorf("MKNOD without Mknoder: status %d, want NFSERR_NOTSUPP", status)
+		}
+	})
+}
+
 func TestHandleRemoveReadOnly(t *testing.T) {
 	fs, err := memfs.NewFS()
 	if err != nil {
@@ -2392,6 +2828,122 @@ func TestHandleAccessReadOnly(t *testing.T) {
 	})
 }
 
+func TestHandleAccessPerUser(t *testing.T) {
+	const all = ACCESS3_READ | ACCESS3_LOOKUP | ACCESS3_MODIFY | ACCESS3_EXTEND | ACCESS3_DELETE | ACCESS3_EXECUTE
+	tests := []struct {
+		name     string
+		mode     os.FileMode
+		uid, gid uint32
+		aux      []uint32
+		readOnly bool
+		want     uint32
+	}{
+		{"other user on 0644 file", 0644, 1000, 1000, nil, false, ACCESS3_READ},
+		{"owner on 0644 file", 0644, 0, 1000, nil, false, ACCESS3_READ | ACCESS3_MODIFY | ACCESS3_EXTEND},
+		{"group member on 0664 file", 0664, 1000, 1000, []uint32{0}, false, ACCESS3_READ | ACCESS3_MODIFY | ACCESS3_EXTEND},
+		{"other user on 0755 file", 0755, 1000, 1000, nil, false, ACCESS3_READ | ACCESS3_EXECUTE},
+		{"root on 0644 file", 0644, 0, 0, nil, false, ACCESS3_READ | ACCESS3_MODIFY | ACCESS3_EXTEND},
+		{"root on 0744 file", 0744, 0, 0, nil, false, ACCESS3_READ | ACCESS3_MODIFY | ACCESS3_EXTEND | ACCESS3_EXECUTE},
+		{"owner on read-only export", 0644, 0, 0, nil, true, ACCESS3_READ},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.ReadOnly = tt.readOnly })
+			if err := srv.handler.fs.Chmod("/dir/file.txt", tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			// The file is owned by uid 0, gid 0
+			auth.Credential = &RPCCredential{Flavor: AUTH_SYS}
+			auth.AuthSys = &AuthSysCredential{UID: tt.uid, GID: tt.gid, AuxGIDs: tt.aux}
+			auth.EffectiveUID, auth.EffectiveGID = tt.uid, tt.gid
+
+			body := buildAccessRequest(allocHandle(t, srv, "/dir/file.txt"), all)
+			result, err := handler.handleAccess(bytes.NewReader(body), &RPCReply{}, auth)
+			if err != nil {
+				t.Fatalf("handleAccess: %v", err)
+			}
+			data := result.Data.([]byte)
+			if status := binary.BigEndian.Uint32(data[0:4]); status != NFS_OK {
+				t.Fatalf("ACCESS status = %d, want NFS_OK", status)
+			}
+			if got := binary.BigEndian.Uint32(data[len(data)-4:]); got != tt.want {
+				t.Errorf("ACCESS granted %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionChecks(t *testing.T) {
+	read := func(h uint64) []byte {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, h)
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, uint32(5))
+		return buf.Bytes()
+	}
+	write := func(h uint64) []byte {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, h)
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, uint32(4))
+		binary.Write(&buf, binary.BigEndian, uint32(2)) // FILE_SYNC
+		binary.Write(&buf, binary.BigEndian, uint32(4))
+		buf.WriteString("data")
+		return buf.Bytes()
+	}
+	chmod := func(h uint64) []byte {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, h)
+		buf.Write(encodeSattr3(true, 0666, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+		binary.Write(&buf, binary.BigEndian, uint32(0))
+		return buf.Bytes()
+	}
+	remove := func(h uint64) []byte {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, h)
+		xdrEncodeString(&buf, "file.txt")
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		checks   bool
+		proc     uint32
+		path     string
+		args     func(uint64) []byte
+		mode     os.FileMode // of path
+		uid, gid uint32
+		aux      []uint32
+		want     uint32
+	}{
+		{"other user writes 0644 file", true, NFSPROC3_WRITE, "/dir/file.txt", write, 0644, 1000, 1000, nil, NFSERR_ACCES},
+		{"other user writes 0644 file unchecked", false, NFSPROC3_WRITE, "/dir/file.txt", write, 0644, 1000, 1000, nil, NFS_OK},
+		{"aux group member writes 0664 file", true, NFSPROC3_WRITE, "/dir/file.txt", write, 0664, 1000, 1000, []uint32{0}, NFS_OK},
+		{"other user reads 0640 file", true, NFSPROC3_READ, "/dir/file.txt", read, 0640, 1000, 1000, nil, NFSERR_ACCES},
+		{"other user reads 0644 file", true, NFSPROC3_READ, "/dir/file.txt", read, 0644, 1000, 1000, nil, NFS_OK},
+		{"other user chmods 0666 file", true, NFSPROC3_SETATTR, "/dir/file.txt", chmod, 0666, 1000, 1000, nil, NFSERR_PERM},
+		{"other user removes from 0755 dir", true, NFSPROC3_REMOVE, "/dir", remove, os.ModeDir | 0755, 1000, 1000, nil, NFSERR_ACCES},
+		{"other user removes from 0777 dir", true, NFSPROC3_REMOVE, "/dir", remove, os.ModeDir | 0777, 1000, 1000, nil, NFS_OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.EnablePermissionChecks = tt.checks })
+			if err := srv.handler.fs.Chmod(tt.path, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			// Everything is owned by uid 0, gid 0
+			auth.Credential = &RPCCredential{Flavor: AUTH_SYS}
+			auth.AuthSys = &AuthSysCredential{UID: tt.uid, GID: tt.gid, AuxGIDs: tt.aux}
+			auth.EffectiveUID, auth.EffectiveGID = tt.uid, tt.gid
+
+			reply := callProc(t, handler, auth, NFS_PROGRAM, NFS_V3, tt.proc, tt.args(allocHandle(t, srv, tt.path)))
+			if status, _ := xdrDecodeUint32(reply); status != tt.want {
+				t.Errorf("status = %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
 // Helper to build fsstat/fsinfo request (just a file handle)
 func buildFsRequest(handle uint64) []byte {
 	var buf bytes.Buffer
@@ -2645,9 +3197,57 @@ func TestHandleFsinfoReadOnly(t *testing.T) {
 		if status != NFS_OK {
 			t.Errorf("Expected NFS_OK, got %d", status)
 		}
-		// The properties field should include FSF_RDONLY bit
-		// We'd need to parse the full response to verify this
 	})
+
+	// RFC 1813 has no read-only FSINFO property, so the bits must be the
+	// standard ones whatever the export mode; read-only shows up in ACCESS
+	rwNFS, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	rwServer := &Server{handler: rwNFS, options: ServerOptions{}}
+	for _, tt := range []struct {
+		name     string
+		server   *Server
+		readOnly bool
+	}{
+		{"read-only", server, true},
+		{"read-write", rwServer, false},
+	} {
+		t.Run("properties and access "+tt.name, func(t *testing.T) {
+			h := &NFSProcedureHandler{server: tt.server}
+			rootHandle := getRootHandle(tt.server)
+
+			result, err := h.handleFsinfo(bytes.NewReader(buildFsRequest(rootHandle)), &RPCReply{}, authCtx)
+			if err != nil {
+				t.Fatalf("handleFsinfo failed: %v", err)
+			}
+			data := result.Data.([]byte)
+			// status, post_op_attr, rtmax..dtpref (7), maxfilesize, time_delta
+			properties := binary.BigEndian.Uint32(data[4+4+84+7*4+8+8:])
+			if want := uint32(FSF3_SYMLINK | FSF3_HOMOGENEOUS | FSF3_CANSETTIME); properties != want {
+				t.Errorf("Expected FSINFO properties %#x, got %#x", want, properties)
+			}
+
+			access := uint32(ACCESS3_READ | ACCESS3_MODIFY | ACCESS3_EXTEND | ACCESS3_DELETE)
+			result, err = h.handleAccess(bytes.NewReader(buildAccessRequest(rootHandle, access)), &RPCReply{}, &AuthContext{ClientIP: "127.0.0.1"})
+			if err != nil {
+				t.Fatalf("handleAccess failed: %v", err)
+			}
+			data = result.Data.([]byte)
+			granted := binary.BigEndian.Uint32(data[4+4+84:])
+			writeBits := uint32(ACCESS3_MODIFY | ACCESS3_EXTEND | ACCESS3_DELETE)
+			if tt.readOnly && granted&writeBits != 0 {
+				t.Errorf("Read-only export granted write access bits %#x", granted&writeBits)
+			}
+			if !tt.readOnly && granted&writeBits != writeBits {
+				t.Errorf("Read-write export withheld write access: granted %#x", granted)
+			}
+			if tt.server.handler.Features().ReadOnly != tt.readOnly {
+				t.Errorf("Features().ReadOnly = %v, want %v", tt.server.handler.Features().ReadOnly, tt.readOnly)
+			}
+		})
+	}
 }
 
 // Tests for handleRead with various edge cases
@@ -3469,7 +4069,7 @@ func TestEncodePostOpAttr(t *testing.T) {
 
 	t.Run("encode post_op_attr", func(t *testing.T) {
 		var buf bytes.Buffer
-		err := encodePostOpAttr(&buf, attrs)
+		err := encodePostOpAttr(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("encodePostOpAttr failed: %v", err)
 		}
@@ -3537,7 +4137,7 @@ func TestEncodeFileAttributesAllTypes(t *testing.T) {
 		attrs.SetAtime(now)
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode block device: %v", err)
 		}
@@ -3555,7 +4155,7 @@ func TestEncodeFileAttributesAllTypes(t *testing.T) {
 		attrs.SetAtime(now)
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode char device: %v", err)
 		}
@@ -3570,7 +4170,7 @@ func TestEncodeFileAttributesAllTypes(t *testing.T) {
 		attrs.SetAtime(now)
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode socket: %v", err)
 		}
@@ -3585,7 +4185,7 @@ func TestEncodeFileAttributesAllTypes(t *testing.T) {
 		attrs.SetAtime(now)
 
 		var buf bytes.Buffer
-		err := encodeFileAttributes(&buf, attrs)
+		err := encodeFileAttributes(&buf, attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode named pipe: %v", err)
 		}
@@ -4290,7 +4890,7 @@ func TestEncodeFileAttributesEdgeCases(t *testing.T) {
 		attrs, _ := nfs.GetAttr(node)
 
 		var buf bytes.Buffer
-		encodeFileAttributes(&buf, attrs)
+		encodeFileAttributes(&buf, attrs, nil)
 		if buf.Len() == 0 {
 			t.Error("Expected non-empty encoded attributes")
 		}
@@ -4305,7 +4905,7 @@ func TestEncodeFileAttributesEdgeCases(t *testing.T) {
 		attrs, _ := nfs.GetAttr(node)
 
 		var buf bytes.Buffer
-		encodeFileAttributes(&buf, attrs)
+		encodeFileAttributes(&buf, attrs, nil)
 		if buf.Len() == 0 {
 			t.Error("Expected non-empty encoded attributes")
 		}
@@ -4333,7 +4933,7 @@ func TestEncodeFileAttributesMoreCases(t *testing.T) {
 			linkAttrs, _ := nfs.GetAttr(linkNode)
 			if linkAttrs != nil {
 				var buf bytes.Buffer
-				encodeFileAttributes(&buf, linkAttrs)
+				encodeFileAttributes(&buf, linkAttrs, nil)
 				if buf.Len() == 0 {
 					t.Error("Expected non-empty encoded attributes")
 				}
@@ -4372,7 +4972,7 @@ func TestEncodeFileAttributesTypes(t *testing.T) {
 				Gid:  1000,
 			}
 			buf := &bytes.Buffer{}
-			err := encodeFileAttributes(buf, attrs)
+			err := encodeFileAttributes(buf, attrs, nil)
 			if err != nil {
 				t.Errorf("encodeFileAttributes for %s failed: %v", tc.name, err)
 			}
@@ -4456,7 +5056,7 @@ func TestAttributesEncodingEdgeCases(t *testing.T) {
 			Gid:  1000,
 		}
 
-		data, err := encodeAttributesResponse(attrs)
+		data, err := encodeAttributesResponse(attrs, nil)
 		if err != nil {
 			t.Errorf("encodeAttributesResponse failed: %v", err)
 		}
@@ -4628,7 +5228,7 @@ func TestEncodeFileAttributesErrors(t *testing.T) {
 		for size := 1; size < 100; size++ {
 			buf := make([]byte, size)
 			w := &limitedWriter{buf: buf, limit: size}
-			_ = encodeFileAttributes(w, attrs) // May or may not error depending on size
+			_ = encodeFileAttributes(w, attrs, nil) // May or may not error depending on size
 		}
 	})
 }
@@ -4661,7 +5261,7 @@ func TestEncodeAttributesForAllTypes(t *testing.T) {
 			attrs.SetAtime(time.Now())
 
 			var buf bytes.Buffer
-			err := encodeFileAttributes(&buf, attrs)
+			err := encodeFileAttributes(&buf, attrs, nil)
 			if err != nil {
 				t.Fatalf("Failed to encode attributes for %s: %v", tc.name, err)
 			}
@@ -4737,7 +5337,7 @@ func TestEncodeAttributesResponseCoverage(t *testing.T) {
 	attrs.SetAtime(time.Now())
 
 	t.Run("encode response", func(t *testing.T) {
-		data, err := encodeAttributesResponse(attrs)
+		data, err := encodeAttributesResponse(attrs, nil)
 		if err != nil {
 			t.Fatalf("Failed to encode attributes response: %v", err)
 		}
@@ -4769,3 +5369,242 @@ func (w *limitedWriter) Write(p []byte) (n int, err error) {
 	w.written += n
 	return n, nil
 }
+
+// openCountingFS counts OpenFile calls
+type openCountingFS struct {
+	*memfs.FileSystem
+	opens atomic.Int32
+}
+
+func (f *openCountingFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f.opens.Add(1)
+	return f.FileSystem.OpenFile(name, flag, perm)
+}
+
+func TestReadZeroCount(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/file")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	fs := &openCountingFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	fileHandle := getFileHandle(server, "/file")
+	fs.opens.Store(0)
+
+	for _, tt := range []struct {
+		offset uint64
+		eof    bool
+	}{{0, false}, {10, true}} {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fileHandle)
+		binary.Write(&buf, binary.BigEndian, tt.offset)
+		binary.Write(&buf, binary.BigEndian, uint32(0))
+
+		result, err := handler.handleRead(bytes.NewReader(buf.Bytes()), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleRead failed: %v", err)
+		}
+		data := getReplyData(result)
+		if status := binary.BigEndian.Uint32(data[0:4]); status != NFS_OK {
+			t.Fatalf("offset %d: expected NFS_OK, got %d", tt.offset, status)
+		}
+		tail := data[4+4+84:] // status, post_op_attr
+		count := binary.BigEndian.Uint32(tail[0:4])
+		eof := binary.BigEndian.Uint32(tail[4:8]) == 1
+		dataLen := binary.BigEndian.Uint32(tail[8:12])
+		if count != 0 || dataLen != 0 || eof != tt.eof {
+			t.Errorf("offset %d: got count=%d len=%d eof=%v, want 0 bytes eof=%v", tt.offset, count, dataLen, eof, tt.eof)
+		}
+	}
+	if n := fs.opens.Load(); n != 0 {
+		t.Errorf("Expected zero-count READs not to open the file, got %d opens", n)
+	}
+}
+
+func TestReadWriteOnSymlinkInval(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/target")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Write([]byte("target data"))
+	f.Close()
+	if err := mfs.Symlink("/target", "/link"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	nfs, err := New(mfs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	linkHandle := getFileHandle(server, "/link")
+
+	var read bytes.Buffer
+	xdrEncodeFileHandle(&read, linkHandle)
+	binary.Write(&read, binary.BigEndian, uint64(0))
+	binary.Write(&read, binary.BigEndian, uint32(64))
+	result, err := handler.handleRead(bytes.NewReader(read.Bytes()), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleRead failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFSERR_INVAL {
+		t.Errorf("READ on symlink: expected NFSERR_INVAL, got %d", status)
+	}
+
+	write := buildWriteRequest(linkHandle, 0, []byte("clobber"))
+	result, err = handler.handleWrite(bytes.NewReader(write), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleWrite failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFSERR_INVAL {
+		t.Errorf("WRITE on symlink: expected NFSERR_INVAL, got %d", status)
+	}
+	if data, _ := mfs.ReadFile("/target"); string(data) != "target data" {
+		t.Errorf("Symlink target modified: %q", data)
+	}
+
+	var readlink bytes.Buffer
+	xdrEncodeFileHandle(&readlink, linkHandle)
+	result, err = handler.handleReadlink(bytes.NewReader(readlink.Bytes()), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleReadlink failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFS_OK {
+		t.Fatalf("READLINK: expected NFS_OK, got %d", status)
+	}
+	data := getReplyData(result)
+	target, err := xdrDecodeString(bytes.NewReader(data[4+4+84:]))
+	if err != nil || target != "/target" {
+		t.Errorf("READLINK returned %q (%v), want /target", target, err)
+	}
+}
+
+func TestHandleStaleAfterTypeChange(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, name := range []string{"/becomes-dir", "/stays-file"} {
+		f, err := mfs.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Write([]byte("file data"))
+		f.Close()
+	}
+
+	nfs, err := New(mfs, ExportOptions{AttrCacheTimeout: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	changed := getFileHandle(server, "/becomes-dir")
+	unchanged := getFileHandle(server, "/stays-file")
+
+	// Replace the file with a directory, and the other with a new file,
+	// behind the server's back
+	if err := mfs.Remove("/becomes-dir"); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := mfs.Mkdir("/becomes-dir", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	mfs.Remove("/stays-file")
+	f, _ := mfs.Create("/stays-file")
+	f.Write([]byte("new data"))
+	f.Close()
+	time.Sleep(5 * time.Millisecond) // let the cached attributes expire
+
+	read := func(handle uint64) uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, handle)
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, uint32(64))
+		result, err := handler.handleRead(&buf, &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleRead failed: %v", err)
+		}
+		return readStatusFromReply(result)
+	}
+	if status := read(changed); status != NFSERR_STALE {
+		t.Errorf("READ of a file replaced by a directory: expected NFSERR_STALE, got %d", status)
+	}
+	if status := read(unchanged); status != NFS_OK {
+		t.Errorf("READ of a file replaced by a file: expected NFS_OK, got %d", status)
+	}
+
+	var args bytes.Buffer
+	xdrEncodeFileHandle(&args, changed)
+	result, err := handler.handleGetattr(&args, &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleGetattr failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFSERR_STALE {
+		t.Errorf("GETATTR of a file replaced by a directory: expected NFSERR_STALE, got %d", status)
+	}
+}
+
+func TestGetattrStatsOnce(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, _ := mfs.Create("/file.txt")
+	f.Close()
+
+	fs := &lstatCountingFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{CacheConsistency: CacheConsistencyStrict})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	handle := getFileHandle(server, "/file.txt")
+
+	var args bytes.Buffer
+	xdrEncodeFileHandle(&args, handle)
+	call := &RPCCall{
+		Header: RPCMsgHeader{
+			Xid:        1,
+			MsgType:    RPC_CALL,
+			RPCVersion: 2,
+			Program:    NFS_PROGRAM,
+			Version:    NFS_V3,
+			Procedure:  NFSPROC3_GETATTR,
+		},
+	}
+	authCtx := testAuthContext()
+	authCtx.Credential = &call.Credential
+
+	fs.lstats.Store(0)
+	reply, err := handler.HandleCall(call, &args, authCtx)
+	if err != nil || readStatusFromReply(reply) != NFS_OK {
+		t.Fatalf("GETATTR failed: %v", err)
+	}
+	// The stale check and the reply share one Lstat, even with every
+	// GetAttr going to the backing filesystem
+	if n := fs.lstats.Load(); n != 1 {
+		t.Errorf("GETATTR made %d Lstat calls, want 1", n)
+	}
+}
@@ -86,12 +86,25 @@ func (n *NFSNode) Seek(offset int64, whence int) (int64, error) {
 
 // Name implements absfs.File
 func (n *NFSNode) Name() string {
+	if n.shard != "" {
+		return n.shard
+	}
 	if n.path == "/" {
 		return "/"
 	}
 	return filepath.Base(n.path)
 }
 
+// handleKey identifies the node for file handle deduplication. A shard
+// directory shares its path with the directory it belongs to, so its key
+// also carries the prefix; NUL cannot appear in a path.
+func (n *NFSNode) handleKey() string {
+	if n.shard != "" {
+		return n.path + "\x00" + n.shard
+	}
+	return n.path
+}
+
 // Readdir implements absfs.File
 func (n *NFSNode) Readdir(count int) ([]os.FileInfo, error) {
 	f, err := n.SymlinkFileSystem.OpenFile(n.path, os.O_RDONLY, 0)
@@ -6,6 +6,8 @@
 package absnfs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io"
 	"runtime"
 	"strings"
@@ -93,20 +95,63 @@ func validateMode(mode uint32, isDir bool) uint32 {
 	return NFS_OK
 }
 
+// replyStatus returns the NFS status a reply carries, if it carries one
+func replyStatus(result *RPCReply, err error) (uint32, bool) {
+	if err != nil {
+		return 0, false
+	}
+	data, ok := result.Data.([]byte)
+	if !ok || len(data) < 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data), true
+}
+
 // handleNFSCall handles NFS protocol operations using a dispatch table
-func (h *NFSProcedureHandler) handleNFSCall(call *RPCCall, body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+func (h *NFSProcedureHandler) handleNFSCall(call *RPCCall, body io.Reader, reply *RPCReply, authCtx *AuthContext) (result *RPCReply, err error) {
 	// Check version first
-	if call.Header.Version != NFS_V3 {
+	if !h.server.nfsVersionEnabled(call.Header.Version) {
 		reply.AcceptStatus = PROG_MISMATCH
+		reply.MismatchLow, reply.MismatchHigh = h.server.nfsVersionRange()
 		return reply, nil
 	}
 
-	// Look up handler in dispatch table
-	handler, ok := nfsHandlers[call.Header.Procedure]
+	// Look up handler in dispatch table; NFSv2 has its own
+	handlers := nfsHandlers
+	if call.Header.Version == NFS_V2 {
+		handlers = nfsV2Handlers
+	}
+	handler, ok := handlers[call.Header.Procedure]
 	if !ok {
 		reply.AcceptStatus = PROC_UNAVAIL
 		return reply, nil
 	}
 
-	return handler(h, body, reply, authCtx)
+	// Count and time the call for the operation metrics, and count and
+	// trace the status it was answered with
+	op := nfsProcName(call.Header)
+	done := h.nfs().RecordOperationStart(op)
+	span := h.startCallSpan(op, call.Header.Version, authCtx)
+	defer func() {
+		done(err)
+		status, replied := replyStatus(result, err)
+		if replied && h.nfs().metrics != nil {
+			h.nfs().metrics.RecordOperationStatus(op, status)
+		}
+		endCallSpan(span, err, status, replied)
+	}()
+
+	// NFSv2 arguments are not decodable by the LogRPCOnError logger
+	if call.Header.Version == NFS_V2 || !h.nfs().tuning.Load().LogRPCOnError {
+		return handler(h, body, reply, authCtx)
+	}
+
+	// Keep the raw arguments so a failed call can be logged with them
+	args, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	result, err = handler(h, bytes.NewReader(args), reply, authCtx)
+	h.logFailedCall(call.Header.Procedure, args, result, err, authCtx)
+	return result, err
 }
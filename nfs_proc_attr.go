@@ -22,34 +22,50 @@ func (h *NFSProcedureHandler) handleGetattr(body io.Reader, reply *RPCReply, aut
 		if h.server.options.Debug {
 			h.server.logger.Printf("GETATTR: Failed to decode handle: %v", err)
 		}
-		return nfsErrorReply(reply, GARBAGE_ARGS), nil
+		return nfsErrorReply(reply, handleDecodeStatus(err)), nil
+	}
+
+	if h.metadataThrottled(authCtx) {
+		return nfsErrorReply(reply, NFSERR_JUKEBOX), nil
 	}
 
 	if h.server.options.Debug {
-		h.server.logger.Printf("GETATTR: Looking up handle %d, fileMap count: %d", handleVal, h.server.handler.fileMap.Count())
+		h.server.logger.Printf("GETATTR: Looking up handle %d, fileMap count: %d", handleVal, h.nfs().fileMap.Count())
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		if h.server.options.Debug {
-			h.server.logger.Printf("GETATTR: Handle %d not found in fileMap", handleVal)
+	tuning := h.nfs().tuning.Load()
+	handleCache := tuning.HandleAttrCache && tuning.CacheConsistency != CacheConsistencyStrict
+	if handleCache {
+		if _, ok := h.nfs().fileMap.Get(handleVal); ok {
+			if attrs, ok := h.nfs().attrCache.GetByHandle(handleVal); ok {
+				h.nfs().RecordAttrCacheHit()
+				return getattrReply(reply, attrs, h.nfs().idMaps()), nil
+			}
 		}
-		return nfsErrorReply(reply, NFSERR_STALE), nil
 	}
 
-	// R22: Return NFS error instead of nil,err
-	attrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorReply(reply, mapError(err)), nil
+	node, attrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		if h.server.options.Debug {
+			h.server.logger.Printf("GETATTR: Handle %d did not resolve: status %d", handleVal, status)
+		}
+		return nfsErrorReply(reply, status), nil
+	}
+	if handleCache && node.shard == "" {
+		h.nfs().attrCache.BindHandle(handleVal, node.path)
 	}
+	return getattrReply(reply, attrs, h.nfs().idMaps()), nil
+}
 
+// getattrReply encodes a successful GETATTR reply carrying attrs
+func getattrReply(reply *RPCReply, attrs *NFSAttrs, ids *idMaps) *RPCReply {
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
-		return nfsErrorReply(reply, NFSERR_IO), nil
+	if err := encodeFileAttributes(&buf, attrs, ids); err != nil {
+		return nfsErrorReply(reply, NFSERR_IO)
 	}
 	reply.Data = buf.Bytes()
-	return reply, nil
+	return reply
 }
 
 // handleSetattr handles NFSPROC3_SETATTR - set file attributes
@@ -60,19 +76,20 @@ func (h *NFSProcedureHandler) handleGetattr(body io.Reader, reply *RPCReply, aut
 // is how shell redirects (>) clear a file before writing.
 func (h *NFSProcedureHandler) handleSetattr(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	// R18: Check read-only before processing
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	sattr, err := decodeSattr3(body)
 	if err != nil {
 		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
 	}
+	h.nfs().mapSattr3(&sattr)
 
 	// R8: Read sattrguard3 (RFC 1813 section 3.3.2). The guard is a
 	// discriminated union: 0 = no guard, 1 = check ctime before applying.
@@ -94,14 +111,9 @@ func (h *NFSProcedureHandler) handleSetattr(body io.Reader, reply *RPCReply, aut
 		return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithWcc(reply, NFSERR_STALE), nil
-	}
-
-	preAttrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+	node, preAttrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
 	}
 
 	// R8: Enforce sattrguard3 - compare guard ctime with current ctime
@@ -114,6 +126,40 @@ func (h *NFSProcedureHandler) handleSetattr(body io.Reader, reply *RPCReply, aut
 		}
 	}
 
+	// Only regular files have a size to change; refuse before the backing
+	// fs is asked to truncate a directory or special file
+	if sattr.SetSize {
+		switch {
+		case preAttrs.Mode.IsDir():
+			return nfsErrorWithWcc(reply, NFSERR_ISDIR), nil
+		case preAttrs.Mode&os.ModeType&^os.ModeIrregular != 0:
+			return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
+		}
+	}
+
+	if status := h.checkAccess(node, callerCredential(authCtx), setattrAccess(&sattr)); status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
+	}
+
+	if status := h.checkSetattrSupport(&sattr); status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
+	}
+
+	if h.dryRun() {
+		if sattr.SetSize && sattr.Size > uint64(math.MaxInt64) {
+			return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
+		}
+		h.logDryRun("SETATTR", LogField{Key: "path", Value: node.path})
+		postAttrs := dryRunSetattr(preAttrs, sattr, authCtx)
+		var buf bytes.Buffer
+		xdrEncodeUint32(&buf, NFS_OK)
+		if err := encodeWccData(&buf, preAttrs, &postAttrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorWithWcc(reply, NFSERR_IO), nil
+		}
+		reply.Data = buf.Bytes()
+		return reply, nil
+	}
+
 	// Apply truncation before other attribute changes.
 	// This is critical for file overwrites: the NFS client sends
 	// SETATTR(size=0) before WRITE(offset=0, data) to clear old content.
@@ -122,14 +168,14 @@ func (h *NFSProcedureHandler) handleSetattr(body io.Reader, reply *RPCReply, aut
 			return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
 		}
 		if err := node.Truncate(int64(sattr.Size)); err != nil {
-			return nfsErrorWithWcc(reply, mapError(err)), nil
+			return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 		}
-		h.server.handler.attrCache.Invalidate(node.path)
-		info, statErr := h.server.handler.fs.Stat(node.path)
+		h.nfs().attrCache.Invalidate(node.path)
+		info, statErr := h.nfs().fs.Stat(node.path)
 		if statErr == nil {
 			node.mu.Lock()
 			node.attrs.Size = info.Size()
-			node.attrs.SetMtime(info.ModTime())
+			node.attrs.SetMtime(h.nfs().fileModTime(node.path, info))
 			node.attrs.Refresh()
 			node.mu.Unlock()
 		}
@@ -165,27 +211,27 @@ func (h *NFSProcedureHandler) handleSetattr(body io.Reader, reply *RPCReply, aut
 	if sattr.SetAtime == 1 {
 		attrs.SetAtime(time.Now())
 	} else if sattr.SetAtime == 2 {
-		attrs.SetAtime(time.Unix(int64(sattr.AtimeSec), int64(sattr.AtimeNsec)))
+		attrs.SetAtime(nfsTime(sattr.AtimeSec, sattr.AtimeNsec))
 	}
 
 	if sattr.SetMtime == 1 {
 		attrs.SetMtime(time.Now())
 	} else if sattr.SetMtime == 2 {
-		attrs.SetMtime(time.Unix(int64(sattr.MtimeSec), int64(sattr.MtimeNsec)))
+		attrs.SetMtime(nfsTime(sattr.MtimeSec, sattr.MtimeNsec))
 	}
 
-	if err := h.server.handler.SetAttr(node, attrs); err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+	if err := h.nfs().SetAttr(node, attrs); err != nil {
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	postAttrs, err := h.server.handler.GetAttr(node)
+	postAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeWccData(&buf, preAttrs, postAttrs); err != nil {
+	if err := encodeWccData(&buf, preAttrs, postAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 	reply.Data = buf.Bytes()
@@ -196,7 +242,7 @@ func (h *NFSProcedureHandler) handleSetattr(body io.Reader, reply *RPCReply, aut
 func (h *NFSProcedureHandler) handleAccess(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
 	var access uint32
@@ -204,54 +250,18 @@ func (h *NFSProcedureHandler) handleAccess(body io.Reader, reply *RPCReply, auth
 		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithPostOp(reply, NFSERR_STALE), nil
+	if h.metadataThrottled(authCtx) {
+		return nfsErrorWithPostOp(reply, NFSERR_JUKEBOX), nil
 	}
 
-	// R22: Return NFS error instead of nil,err
-	attrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+	_, attrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithPostOp(reply, status), nil
 	}
 
-	// Get file attributes for permission checking
-	fileMode := attrs.Mode
-	fileUid := attrs.Uid
-	fileGid := attrs.Gid
-	isDir := attrs.Mode&os.ModeDir != 0
-
 	// Use effective (squashed) UID/GID set by HandleCall authentication
-	effectiveUID := authCtx.EffectiveUID
-	effectiveGID := authCtx.EffectiveGID
-
-	// Determine which permission bits apply (owner, group, other)
-	var permBits os.FileMode
-	if effectiveUID == fileUid {
-		permBits = (fileMode >> 6) & 7 // owner bits
-	} else if effectiveGID == fileGid {
-		permBits = (fileMode >> 3) & 7 // group bits
-	} else {
-		// Check auxiliary groups
-		isGroupMember := false
-		if authCtx.AuthSys != nil {
-			for _, auxGID := range authCtx.AuthSys.AuxGIDs {
-				if auxGID == fileGid {
-					isGroupMember = true
-					break
-				}
-			}
-		}
-		if isGroupMember {
-			permBits = (fileMode >> 3) & 7 // group bits
-		} else {
-			permBits = fileMode & 7 // other bits
-		}
-	}
-	// Root (UID 0) gets all permissions
-	if effectiveUID == 0 {
-		permBits = 7
-	}
+	isDir := attrs.Mode&os.ModeDir != 0
+	permBits := permissionBits(attrs, callerCredential(authCtx))
 
 	var accessAllowed uint32
 	if access&ACCESS3_READ != 0 && permBits&4 != 0 {
@@ -263,7 +273,7 @@ func (h *NFSProcedureHandler) handleAccess(body io.Reader, reply *RPCReply, auth
 	if access&ACCESS3_EXECUTE != 0 && permBits&1 != 0 {
 		accessAllowed |= ACCESS3_EXECUTE
 	}
-	if !h.server.handler.policy.Load().ReadOnly {
+	if !h.readOnly(authCtx) {
 		if access&ACCESS3_MODIFY != 0 && permBits&2 != 0 {
 			accessAllowed |= ACCESS3_MODIFY
 		}
@@ -278,7 +288,7 @@ func (h *NFSProcedureHandler) handleAccess(body io.Reader, reply *RPCReply, auth
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 	binary.Write(&buf, binary.BigEndian, accessAllowed)
@@ -9,22 +9,25 @@ package absnfs
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
+	"math"
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 // handleCreate handles NFSPROC3_CREATE - create a file
 func (h *NFSProcedureHandler) handleCreate(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	// R5: Check read-only before processing
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	name, err := xdrDecodeString(body)
@@ -45,15 +48,20 @@ func (h *NFSProcedureHandler) handleCreate(body io.Reader, reply *RPCReply, auth
 	// Use effective UID/GID from auth context as default for new files
 	newUID := authCtx.EffectiveUID
 	newGID := authCtx.EffectiveGID
-	var isExclusive bool
-	if createHow == 0 || createHow == 1 {
-		sattr, err := decodeSattr3(body)
+	var sattr sattr3
+	var verf [8]byte
+	if createHow == UNCHECKED || createHow == GUARDED {
+		sattr, err = decodeSattr3(body)
 		if err != nil {
 			return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
 		}
+		h.nfs().mapSattr3(&sattr)
 		if sattr.SetMode {
 			mode = sattr.Mode
 		}
+		if sattr.SetSize && sattr.Size > uint64(math.MaxInt64) {
+			return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
+		}
 		// Only allow explicit UID/GID override if caller is root (not squashed)
 		if sattr.SetUID && authCtx.EffectiveUID == 0 {
 			newUID = sattr.UID
@@ -61,28 +69,20 @@ func (h *NFSProcedureHandler) handleCreate(body io.Reader, reply *RPCReply, auth
 		if sattr.SetGID && authCtx.EffectiveUID == 0 {
 			newGID = sattr.GID
 		}
-	} else if createHow == 2 {
+	} else if createHow == EXCLUSIVE {
 		// M14: Use io.ReadFull for the 8-byte EXCLUSIVE verifier
-		var verf [8]byte
 		if _, err := io.ReadFull(body, verf[:]); err != nil {
 			return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
 		}
-		isExclusive = true
 	}
 
 	if status := validateMode(mode, false); status != NFS_OK {
 		return nfsErrorWithWcc(reply, status), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithWcc(reply, NFSERR_STALE), nil
-	}
-
-	// R23: Return NFS error instead of nil,err
-	dirPreAttrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+	node, dirPreAttrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
 	}
 
 	attrs := &NFSAttrs{
@@ -91,58 +91,56 @@ func (h *NFSProcedureHandler) handleCreate(body io.Reader, reply *RPCReply, auth
 		Gid:  newGID,
 	}
 
-	newNode, err := h.server.handler.Create(node, name, attrs)
-	if err != nil {
-		// For EXCLUSIVE creates, if file already exists, return success
-		// (simplified idempotent behavior per RFC 1813 - full verifier comparison not implemented)
-		if isExclusive && os.IsExist(err) {
-			lookupPath := path.Join(node.path, name)
-			existingNode, lookupErr := h.server.handler.Lookup(lookupPath)
-			if lookupErr == nil {
-				dirPostAttrs, _ := h.server.handler.GetAttr(node)
-				if dirPostAttrs == nil {
-					dirPostAttrs = dirPreAttrs
-				}
-				handle := h.server.handler.fileMap.Allocate(existingNode)
-				existingNode.mu.RLock()
-				existingAttrsCopy := *existingNode.attrs
-				existingNode.mu.RUnlock()
-				var buf bytes.Buffer
-				xdrEncodeUint32(&buf, NFS_OK)
-				xdrEncodeUint32(&buf, 1)
-				xdrEncodeFileHandle(&buf, handle)
-				xdrEncodeUint32(&buf, 1)
-				if err := encodeFileAttributes(&buf, &existingAttrsCopy); err != nil {
-					return nfsErrorWithWcc(reply, NFSERR_IO), nil
-				}
-				if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); err != nil {
-					return nfsErrorWithWcc(reply, NFSERR_IO), nil
-				}
-				reply.Data = buf.Bytes()
-				return reply, nil
-			}
+	if h.dryRun() {
+		return h.dryRunCreated(reply, "CREATE", node, dirPreAttrs, name, attrs)
+	}
+
+	// Create exclusively in every mode, so an existing file is left as it
+	// is until createExisting has applied the mode's rules to it
+	created := true
+	newNode, err := h.nfs().create(authCtx.callContext(), node, name, attrs, true)
+	if errors.Is(err, os.ErrExist) {
+		created = false
+		newNode, err = h.createExisting(node, name, createHow, sattr, verf)
+	} else if err == nil && createHow == EXCLUSIVE {
+		// Record the verifier as the file's times so a retransmitted
+		// CREATE can recognize the file it made
+		t := exclusiveVerfTime(verf)
+		if err = h.nfs().fs.Chtimes(newNode.path, t, t); err == nil {
+			h.nfs().attrCache.Invalidate(newNode.path)
+			newNode, err = h.nfs().Lookup(newNode.path)
 		}
-
-		dirPostAttrs, _ := h.server.handler.GetAttr(node)
+	}
+	if err != nil {
+		dirPostAttrs, _ := h.nfs().GetAttr(node)
 		if dirPostAttrs == nil {
 			dirPostAttrs = dirPreAttrs
 		}
 
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, mapError(err))
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); wccErr != nil {
-			return nfsErrorWithWcc(reply, mapError(err)), nil
+		xdrEncodeUint32(&buf, MapErrorToNFSStatus(err))
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); wccErr != nil {
+			return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
 	}
 
-	dirPostAttrs, err := h.server.handler.GetAttr(node)
+	// Apply the caller's effective identity as owner, as MKDIR does
+	if created {
+		if err := h.nfs().fs.Chown(newNode.path, int(newUID), int(newGID)); err != nil {
+			if h.server.options.Debug {
+				h.server.logger.Printf("CREATE: Chown failed for '%s': %v", newNode.path, err)
+			}
+		}
+	}
+
+	dirPostAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	handle := h.server.handler.fileMap.Allocate(newNode)
+	handle := h.nfs().fileMap.Allocate(newNode)
 
 	// R4: Copy newNode attrs under RLock
 	newNode.mu.RLock()
@@ -154,10 +152,10 @@ func (h *NFSProcedureHandler) handleCreate(body io.Reader, reply *RPCReply, auth
 	xdrEncodeUint32(&buf, 1)
 	xdrEncodeFileHandle(&buf, handle)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy); err != nil {
+	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
-	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 
@@ -165,16 +163,64 @@ func (h *NFSProcedureHandler) handleCreate(body io.Reader, reply *RPCReply, auth
 	return reply, nil
 }
 
+// createExisting settles a CREATE that found name already present in dir,
+// as createmode3 how directs. UNCHECKED opens the existing file, truncating
+// it if sattr sets a size; EXCLUSIVE succeeds only if the file's times hold
+// verf, as they do for a retransmission of the CREATE that made it; GUARDED
+// fails. Anything but a regular file fails in every mode.
+func (h *NFSProcedureHandler) createExisting(dir *NFSNode, name string, how uint32, sattr sattr3, verf [8]byte) (*NFSNode, error) {
+	existingPath := path.Join(dir.path, name)
+	exists := opError("create", existingPath, os.ErrExist)
+
+	info, err := h.nfs().fs.Lstat(existingPath)
+	if err != nil {
+		return nil, opError("create", existingPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, exists
+	}
+
+	switch how {
+	case UNCHECKED:
+		if !sattr.SetSize {
+			return h.nfs().Lookup(existingPath)
+		}
+		existing, err := h.nfs().Lookup(existingPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := existing.Truncate(int64(sattr.Size)); err != nil {
+			return nil, opError("create", existingPath, err)
+		}
+		h.nfs().attrCache.Invalidate(existingPath)
+		return h.nfs().Lookup(existingPath)
+	case EXCLUSIVE:
+		if info.ModTime().Equal(exclusiveVerfTime(verf)) {
+			return h.nfs().Lookup(existingPath)
+		}
+	}
+	return nil, exists
+}
+
+// exclusiveVerfTime encodes an EXCLUSIVE CREATE verifier as the time a new
+// file is stamped with: the first four bytes as seconds and the last four
+// as nanoseconds
+func exclusiveVerfTime(verf [8]byte) time.Time {
+	sec := binary.BigEndian.Uint32(verf[0:4])
+	nsec := binary.BigEndian.Uint32(verf[4:8]) % uint32(time.Second)
+	return nfsTime(sec, nsec)
+}
+
 // handleMkdir handles NFSPROC3_MKDIR - create a directory
 func (h *NFSProcedureHandler) handleMkdir(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	// H2: Check read-only before processing
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	name, err := xdrDecodeString(body)
@@ -190,6 +236,7 @@ func (h *NFSProcedureHandler) handleMkdir(body io.Reader, reply *RPCReply, authC
 	if err != nil {
 		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
 	}
+	h.nfs().mapSattr3(&sattr)
 
 	var mode uint32 = 0755
 	if sattr.SetMode {
@@ -200,32 +247,45 @@ func (h *NFSProcedureHandler) handleMkdir(body io.Reader, reply *RPCReply, authC
 		return nfsErrorWithWcc(reply, status), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithWcc(reply, NFSERR_STALE), nil
+	node, dirPreAttrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
 	}
 
-	// R23: Return NFS error instead of nil,err
-	dirPreAttrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+	if h.dryRun() {
+		attrs := &NFSAttrs{
+			Mode: os.FileMode(mode)&os.ModePerm | os.ModeDir,
+			Uid:  authCtx.EffectiveUID,
+			Gid:  authCtx.EffectiveGID,
+		}
+		if sattr.SetUID && authCtx.EffectiveUID == 0 {
+			attrs.Uid = sattr.UID
+		}
+		if sattr.SetGID && authCtx.EffectiveUID == 0 {
+			attrs.Gid = sattr.GID
+		}
+		return h.dryRunCreated(reply, "MKDIR", node, dirPreAttrs, name, attrs)
 	}
 
-	dirPath := path.Join(node.path, name)
-	if err := h.server.handler.fs.Mkdir(dirPath, os.FileMode(mode)); err != nil {
-		dirPostAttrs, _ := h.server.handler.GetAttr(node)
+	dirPath, err := h.nfs().entryPath(node, name)
+	if err != nil {
+		return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
+	}
+	if err := h.nfs().fs.Mkdir(dirPath, os.FileMode(mode)); err != nil {
+		dirPostAttrs, _ := h.nfs().GetAttr(node)
 		if dirPostAttrs == nil {
 			dirPostAttrs = dirPreAttrs
 		}
 
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, mapError(err))
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); wccErr != nil {
-			return nfsErrorWithWcc(reply, mapError(err)), nil
+		xdrEncodeUint32(&buf, MapErrorToNFSStatus(err))
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); wccErr != nil {
+			return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
 	}
+	h.nfs().cookieCache.Touch(node.path)
 
 	// Apply uid/gid: use effective UID/GID from auth context as default,
 	// only allow explicit override if caller is root (not squashed).
@@ -238,24 +298,24 @@ func (h *NFSProcedureHandler) handleMkdir(body io.Reader, reply *RPCReply, authC
 		if sattr.SetGID && authCtx.EffectiveUID == 0 {
 			chownGID = int(sattr.GID)
 		}
-		if err := h.server.handler.fs.Chown(dirPath, chownUID, chownGID); err != nil {
+		if err := h.nfs().fs.Chown(dirPath, chownUID, chownGID); err != nil {
 			if h.server.options.Debug {
 				h.server.logger.Printf("MKDIR: Chown failed for '%s': %v", dirPath, err)
 			}
 		}
 	}
 
-	newNode, err := h.server.handler.Lookup(dirPath)
+	newNode, err := h.nfs().Lookup(dirPath)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
 	}
 
-	dirPostAttrs, err := h.server.handler.GetAttr(node)
+	dirPostAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	handle := h.server.handler.fileMap.Allocate(newNode)
+	handle := h.nfs().fileMap.Allocate(newNode)
 
 	// R4: Copy newNode attrs under RLock
 	newNode.mu.RLock()
@@ -267,10 +327,10 @@ func (h *NFSProcedureHandler) handleMkdir(body io.Reader, reply *RPCReply, authC
 	xdrEncodeUint32(&buf, 1)
 	xdrEncodeFileHandle(&buf, handle)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy); err != nil {
+	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
-	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 
@@ -280,13 +340,13 @@ func (h *NFSProcedureHandler) handleMkdir(body io.Reader, reply *RPCReply, authC
 
 // handleSymlink handles NFSPROC3_SYMLINK - create a symbolic link
 func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	name, err := xdrDecodeString(body)
@@ -302,6 +362,7 @@ func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, aut
 	if err != nil {
 		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
 	}
+	h.nfs().mapSattr3(&sattr)
 
 	target, err := xdrDecodeString(body)
 	if err != nil {
@@ -323,15 +384,9 @@ func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, aut
 		}
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithWcc(reply, NFSERR_STALE), nil
-	}
-
-	// R23: Return NFS error instead of nil,err
-	dirPreAttrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+	node, dirPreAttrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
 	}
 
 	var mode uint32 = 0777
@@ -354,17 +409,22 @@ func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, aut
 		attrs.Gid = sattr.GID
 	}
 
-	newNode, err := h.server.handler.Symlink(node, name, target, attrs)
+	if h.dryRun() {
+		attrs.Size = int64(len(target))
+		return h.dryRunCreated(reply, "SYMLINK", node, dirPreAttrs, name, attrs)
+	}
+
+	newNode, err := h.nfs().SymlinkWithContext(authCtx.callContext(), node, name, target, attrs)
 	if err != nil {
 		// H8: Include wcc_data in error response
-		dirPostAttrs, _ := h.server.handler.GetAttr(node)
+		dirPostAttrs, _ := h.nfs().GetAttr(node)
 		if dirPostAttrs == nil {
 			dirPostAttrs = dirPreAttrs
 		}
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, mapError(err))
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); wccErr != nil {
-			return nfsErrorWithWcc(reply, mapError(err)), nil
+		xdrEncodeUint32(&buf, MapErrorToNFSStatus(err))
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); wccErr != nil {
+			return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
@@ -382,19 +442,19 @@ func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, aut
 			lchownGID = int(sattr.GID)
 		}
 		symlinkPath := path.Join(node.path, name)
-		if err := h.server.handler.fs.Lchown(symlinkPath, lchownUID, lchownGID); err != nil {
+		if err := h.nfs().fs.Lchown(symlinkPath, lchownUID, lchownGID); err != nil {
 			if h.server.options.Debug {
 				h.server.logger.Printf("SYMLINK: Lchown failed for '%s': %v", symlinkPath, err)
 			}
 		}
 	}
 
-	dirPostAttrs, err := h.server.handler.GetAttr(node)
+	dirPostAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	handle := h.server.handler.fileMap.Allocate(newNode)
+	handle := h.nfs().fileMap.Allocate(newNode)
 
 	// R4: Copy newNode attrs under RLock
 	newNode.mu.RLock()
@@ -406,10 +466,10 @@ func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, aut
 	xdrEncodeUint32(&buf, 1)
 	xdrEncodeFileHandle(&buf, handle)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy); err != nil {
+	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
-	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 
@@ -417,14 +477,138 @@ func (h *NFSProcedureHandler) handleSymlink(body io.Reader, reply *RPCReply, aut
 	return reply, nil
 }
 
-// handleMknod handles MKNOD (create special device) requests.
-// Returns NFSERR_NOTSUPP since special device files are not supported.
+// handleMknod handles NFSPROC3_MKNOD - create a device node, named pipe or
+// socket through the backing filesystem's Mknoder. A read-only export
+// refuses it with NFSERR_ACCES.
 func (h *NFSProcedureHandler) handleMknod(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	// Consume the MKNOD arguments to prevent stream desync
-	// MKNOD3args: diropargs3(nfs_fh3 dir + filename3 name) + mknoddata3(ftype3 + union)
-	xdrDecodeFileHandle(body) // dir handle
-	xdrDecodeString(body)     // name
-	xdrDecodeUint32(body)     // ftype3 discriminant
+	if h.readOnly(authCtx) {
+		return nfsErrorWithWcc(reply, ACCESS_DENIED), nil
+	}
+
+	handleVal, err := xdrDecodeFileHandle(body)
+	if err != nil {
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
+	}
+
+	name, err := xdrDecodeString(body)
+	if err != nil {
+		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+	}
+
+	// mknoddata3: ftype3, then sattr3 and, for devices, specdata3
+	ftype, err := xdrDecodeUint32(body)
+	if err != nil {
+		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+	}
+	var typeBits os.FileMode
+	switch ftype {
+	case NF3CHR:
+		typeBits = os.ModeDevice | os.ModeCharDevice
+	case NF3BLK:
+		typeBits = os.ModeDevice
+	case NF3SOCK:
+		typeBits = os.ModeSocket
+	case NF3FIFO:
+		typeBits = os.ModeNamedPipe
+	default:
+		// Regular files, directories and symlinks have their own procedures
+		return nfsErrorWithWcc(reply, NFSERR_BADTYPE), nil
+	}
+	sattr, err := decodeSattr3(body)
+	if err != nil {
+		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+	}
+	h.nfs().mapSattr3(&sattr)
+	var major, minor uint32
+	if ftype == NF3CHR || ftype == NF3BLK {
+		if major, err = xdrDecodeUint32(body); err != nil {
+			return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		}
+		if minor, err = xdrDecodeUint32(body); err != nil {
+			return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		}
+	}
+
+	if h.nfs().mknoder == nil {
+		return nfsErrorWithWcc(reply, NFSERR_NOTSUPP), nil
+	}
+	if status := validateFilename(name); status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
+	}
+
+	node, dirPreAttrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
+	}
+
+	var mode uint32 = 0644
+	if sattr.SetMode {
+		mode = sattr.Mode & 07777
+	}
+	// Use effective UID/GID from auth context as default; only allow an
+	// explicit override if caller is root (not squashed)
+	attrs := &NFSAttrs{
+		Mode:      os.FileMode(mode) | typeBits,
+		Uid:       authCtx.EffectiveUID,
+		Gid:       authCtx.EffectiveGID,
+		RdevMajor: major,
+		RdevMinor: minor,
+	}
+	if sattr.SetUID && authCtx.EffectiveUID == 0 {
+		attrs.Uid = sattr.UID
+	}
+	if sattr.SetGID && authCtx.EffectiveUID == 0 {
+		attrs.Gid = sattr.GID
+	}
+
+	if h.dryRun() {
+		return h.dryRunCreated(reply, "MKNOD", node, dirPreAttrs, name, attrs)
+	}
+
+	newNode, err := h.nfs().MknodWithContext(authCtx.callContext(), node, name, attrs)
+	if err != nil {
+		dirPostAttrs, _ := h.nfs().GetAttr(node)
+		if dirPostAttrs == nil {
+			dirPostAttrs = dirPreAttrs
+		}
+		var buf bytes.Buffer
+		xdrEncodeUint32(&buf, MapErrorToNFSStatus(err))
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); wccErr != nil {
+			return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
+		}
+		reply.Data = buf.Bytes()
+		return reply, nil
+	}
+
+	if err := h.nfs().fs.Chown(newNode.path, int(attrs.Uid), int(attrs.Gid)); err != nil {
+		if h.server.options.Debug {
+			h.server.logger.Printf("MKNOD: Chown failed for '%s': %v", newNode.path, err)
+		}
+	}
+
+	dirPostAttrs, err := h.nfs().GetAttr(node)
+	if err != nil {
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
+	}
+
+	handle := h.nfs().fileMap.Allocate(newNode)
 
-	return nfsErrorWithWcc(reply, NFSERR_NOTSUPP), nil
+	newNode.mu.RLock()
+	newNodeAttrsCopy := *newNode.attrs
+	newNode.mu.RUnlock()
+
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
+	xdrEncodeUint32(&buf, 1)
+	xdrEncodeFileHandle(&buf, handle)
+	xdrEncodeUint32(&buf, 1)
+	if err := encodeFileAttributes(&buf, &newNodeAttrsCopy, h.nfs().idMaps()); err != nil {
+		return nfsErrorWithWcc(reply, NFSERR_IO), nil
+	}
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
+		return nfsErrorWithWcc(reply, NFSERR_IO), nil
+	}
+
+	reply.Data = buf.Bytes()
+	return reply, nil
 }
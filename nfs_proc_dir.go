@@ -11,14 +11,26 @@ import (
 	"encoding/binary"
 	"io"
 	"os"
-	"path"
+	"time"
 )
 
+// cookieVerifier checks the verifier a client presented to resume a listing
+// of dir, last modified at mtime, and returns the verifier to reply with. A
+// zero verifier is accepted with any cookie, for clients that do not track
+// verifiers.
+func (h *NFSProcedureHandler) cookieVerifier(dir string, mtime time.Time, cookie uint64, verf [8]byte) ([8]byte, bool) {
+	cookies := h.nfs().cookieCache
+	if cookie != 0 && verf != ([8]byte{}) && !cookies.Check(dir, mtime, verf) {
+		return verf, false
+	}
+	return cookies.Verifier(dir, mtime), true
+}
+
 // handleReaddir handles NFSPROC3_READDIR - read directory entries
 func (h *NFSProcedureHandler) handleReaddir(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
 	var cookie uint64
@@ -34,12 +46,13 @@ func (h *NFSProcedureHandler) handleReaddir(body io.Reader, reply *RPCReply, aut
 	if err := binary.Read(body, binary.BigEndian, &count); err != nil {
 		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
 	}
+	count = udpTransferCap(authCtx, count)
 
 	// Rate limiting (after body consumption to prevent stream desync)
-	if h.server.handler.rateLimiter != nil && h.server.handler.policy.Load().EnableRateLimiting {
-		if !h.server.handler.rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReaddir) {
-			if h.server.handler.metrics != nil {
-				h.server.handler.metrics.RecordRateLimitExceeded()
+	if h.nfs().rateLimiter != nil && h.nfs().policy.Load().EnableRateLimiting {
+		if !h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReaddir) {
+			if h.nfs().metrics != nil {
+				h.nfs().metrics.RecordRateLimitExceeded()
 			}
 			return nfsErrorWithPostOp(reply, NFSERR_DELAY), nil
 		}
@@ -59,25 +72,42 @@ func (h *NFSProcedureHandler) handleReaddir(body io.Reader, reply *RPCReply, aut
 		return nfsErrorWithPostOp(reply, NFSERR_NOTDIR), nil
 	}
 
-	// R22: Return NFS error instead of nil,err
-	entries, err := h.server.handler.ReadDir(dir)
+	attrs, err := h.nfs().GetAttr(dir)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+		return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
 	}
 
-	var buf bytes.Buffer
-	xdrEncodeUint32(&buf, NFS_OK)
+	verf, ok := h.cookieVerifier(dir.path, attrs.Mtime(), cookie, cookieVerf)
+	if !ok {
+		return nfsErrorWithPostOp(reply, NFSERR_BAD_COOKIE), nil
+	}
 
-	attrs, err := h.server.handler.GetAttr(dir)
-	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+	// Page from the listing the client started on while it is held, so
+	// cookies stay put however the backing filesystem orders entries
+	cookies := h.nfs().cookieCache
+	entries, ok := cookies.Snapshot(dir.path, verf)
+	if !ok {
+		// R22: Return NFS error instead of nil,err
+		entries, err = h.nfs().ReadDir(dir)
+		if err != nil {
+			return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
+		}
+	}
+
+	// Cookies are entry offsets, so one past the last entry is the furthest
+	// a client can have been handed; anything beyond is not a position here
+	if cookie > uint64(len(entries)) {
+		return nfsErrorWithPostOp(reply, NFSERR_BAD_COOKIE), nil
 	}
+
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 
-	buf.Write(cookieVerf[:])
+	buf.Write(verf[:])
 
 	entryCount := 0
 	maxReplySize := int(count) - 100
@@ -112,11 +142,7 @@ func (h *NFSProcedureHandler) handleReaddir(body io.Reader, reply *RPCReply, aut
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
 
-		// M1: Use path.Base() for name extraction
-		name := path.Base(entry.path)
-		if entry.path == "/" {
-			name = "/"
-		}
+		name := entry.Name()
 		if err := xdrEncodeString(&buf, name); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
@@ -133,8 +159,10 @@ func (h *NFSProcedureHandler) handleReaddir(body io.Reader, reply *RPCReply, aut
 
 	if !reachedLimit {
 		xdrEncodeUint32(&buf, 1) // EOF
+		cookies.Release(dir.path, verf)
 	} else {
 		xdrEncodeUint32(&buf, 0)
+		cookies.Save(dir.path, verf, entries)
 	}
 
 	reply.Data = buf.Bytes()
@@ -145,7 +173,7 @@ func (h *NFSProcedureHandler) handleReaddir(body io.Reader, reply *RPCReply, aut
 func (h *NFSProcedureHandler) handleReaddirplus(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
 	var cookie uint64
@@ -164,12 +192,18 @@ func (h *NFSProcedureHandler) handleReaddirplus(body io.Reader, reply *RPCReply,
 	if err := binary.Read(body, binary.BigEndian, &maxCount); err != nil {
 		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
 	}
+	maxCount = udpTransferCap(authCtx, maxCount)
+
+	// NOTSUPP (rather than PROC_UNAVAIL) is what makes clients fall back to READDIR
+	if h.nfs().tuning.Load().DisableReaddirPlus {
+		return nfsErrorWithPostOp(reply, NFSERR_NOTSUPP), nil
+	}
 
 	// Rate limiting (after body consumption to prevent stream desync)
-	if h.server.handler.rateLimiter != nil && h.server.handler.policy.Load().EnableRateLimiting {
-		if !h.server.handler.rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReaddir) {
-			if h.server.handler.metrics != nil {
-				h.server.handler.metrics.RecordRateLimitExceeded()
+	if h.nfs().rateLimiter != nil && h.nfs().policy.Load().EnableRateLimiting {
+		if !h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReaddir) {
+			if h.nfs().metrics != nil {
+				h.nfs().metrics.RecordRateLimitExceeded()
 			}
 			return nfsErrorWithPostOp(reply, NFSERR_DELAY), nil
 		}
@@ -189,92 +223,115 @@ func (h *NFSProcedureHandler) handleReaddirplus(body io.Reader, reply *RPCReply,
 		return nfsErrorWithPostOp(reply, NFSERR_NOTDIR), nil
 	}
 
-	// R22: Return NFS error instead of nil,err
-	entries, err := h.server.handler.ReadDirPlus(dir)
+	attrs, err := h.nfs().GetAttr(dir)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+		return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
 	}
 
-	var buf bytes.Buffer
-	xdrEncodeUint32(&buf, NFS_OK)
-
-	attrs, err := h.server.handler.GetAttr(dir)
-	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
-	}
-	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
-		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
+	verf, ok := h.cookieVerifier(dir.path, attrs.Mtime(), cookie, cookieVerf)
+	if !ok {
+		return nfsErrorWithPostOp(reply, NFSERR_BAD_COOKIE), nil
 	}
 
-	buf.Write(cookieVerf[:])
+	// As for READDIR, page from the listing the client started on while it
+	// is held. Attributes are fetched only for the entries that make the
+	// page.
+	cookies := h.nfs().cookieCache
+	entries, ok := cookies.Snapshot(dir.path, verf)
+	if !ok {
+		// R22: Return NFS error instead of nil,err
+		entries, err = h.nfs().readDir(authCtx.callContext(), dir, false)
+		if err != nil {
+			return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
+		}
+	}
 
-	entryCount := 0
-	reachedLimit := false
-	maxReplySize := int(maxCount) - 200
-	if maxReplySize < 256 {
-		maxReplySize = 256
+	if cookie > uint64(len(entries)) {
+		return nfsErrorWithPostOp(reply, NFSERR_BAD_COOKIE), nil
 	}
 
-	for i, entry := range entries {
-		if uint64(i) < cookie {
-			continue
-		}
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
+	ids := h.nfs().idMaps()
+	xdrEncodeUint32(&buf, 1)
+	if err := encodeFileAttributes(&buf, attrs, ids); err != nil {
+		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
+	}
 
-		if buf.Len() >= maxReplySize && entryCount > 0 {
-			reachedLimit = true
+	buf.Write(verf[:])
+
+	// Each entry is encoded on its own first, so the reply ends at the last
+	// whole entry within the client's budgets: maxcount bytes for the whole
+	// reply, and dircount for the entries' fileids, names and cookies
+	const trailer = 8 // end of the entry list, and eof
+	// post_op_fh3: handle_follows, then the handle's length and 8 bytes
+	const handleSize = 16
+	var entry bytes.Buffer
+	entryCount, dirBytes := 0, 0
+	maxEntries := h.nfs().tuning.Load().ReaddirPlusMaxEntries
+	fresh := make(map[string]*NFSAttrs)
+	defer h.nfs().attrCache.PutBatch(fresh)
+
+	// An entry skipped for want of attributes does not end the page, but
+	// eof is set only once the page reaches the end of the listing, so a
+	// page cut short after a skip still tells the client to continue
+	i := cookie
+	for ; i < uint64(len(entries)); i++ {
+		if maxEntries > 0 && entryCount >= maxEntries {
 			break
 		}
 
-		// Skip entries with nil attrs
-		entry.mu.RLock()
-		if entry.attrs == nil {
-			entry.mu.RUnlock()
+		node := entries[i]
+		entryAttrs, ok := h.nfs().entryAttrs(node, fresh)
+		if !ok {
 			continue
 		}
-		entryAttrsCopy := *entry.attrs
-		entry.mu.RUnlock()
 
-		xdrEncodeUint32(&buf, 1)
-
-		entryCookie := uint64(i + 1)
-
-		if err := xdrEncodeUint64(&buf, entryAttrsCopy.FileId); err != nil {
+		entry.Reset()
+		xdrEncodeUint32(&entry, 1)
+		if err := xdrEncodeUint64(&entry, entryAttrs.FileId); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
-
-		// M1: Use path.Base() for name extraction
-		name := path.Base(entry.path)
-		if entry.path == "/" {
-			name = "/"
-		}
-		if err := xdrEncodeString(&buf, name); err != nil {
+		name := node.Name()
+		if err := xdrEncodeString(&entry, name); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
-
-		if err := xdrEncodeUint64(&buf, entryCookie); err != nil {
+		entryCookie := i + 1
+		if err := xdrEncodeUint64(&entry, entryCookie); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
+		dirSize := entry.Len() - 4
 
-		xdrEncodeUint32(&buf, 1)
-		if err := encodeFileAttributes(&buf, &entryAttrsCopy); err != nil {
+		xdrEncodeUint32(&entry, 1)
+		if err := encodeFileAttributes(&entry, entryAttrs, ids); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
 
-		// C3: Allocate handle only for the post_op_fh3 field in READDIRPLUS
-		entryHandle := h.server.handler.fileMap.Allocate(entry)
-		xdrEncodeUint32(&buf, 1)
-		xdrEncodeFileHandle(&buf, entryHandle)
+		if buf.Len()+entry.Len()+handleSize+trailer > int(maxCount) || (entryCount > 0 && dirCount > 0 && dirBytes+dirSize > int(dirCount)) {
+			// Not even one entry fits; the client must ask for more
+			if entryCount == 0 {
+				return nfsErrorWithPostOp(reply, NFSERR_TOOSMALL), nil
+			}
+			break
+		}
 
+		// C3: Allocate handle only for the post_op_fh3 field in READDIRPLUS,
+		// and only once the entry is known to make the page
+		xdrEncodeUint32(&entry, 1)
+		xdrEncodeFileHandle(&entry, h.nfs().fileMap.Allocate(node))
+		buf.Write(entry.Bytes())
+		dirBytes += dirSize
 		entryCount++
 	}
 
 	xdrEncodeUint32(&buf, 0)
 
-	if !reachedLimit {
+	if i == uint64(len(entries)) {
 		xdrEncodeUint32(&buf, 1)
+		cookies.Release(dir.path, verf)
 	} else {
 		xdrEncodeUint32(&buf, 0)
+		cookies.Save(dir.path, verf, entries)
 	}
 
 	reply.Data = buf.Bytes()
@@ -115,24 +115,18 @@ func (h *NFSProcedureHandler) handleNull(body io.Reader, reply *RPCReply, authCt
 func (h *NFSProcedureHandler) handleFsstat(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithPostOp(reply, NFSERR_STALE), nil
-	}
-
-	// R22: Return NFS error instead of nil,err
-	attrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+	_, attrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithPostOp(reply, status), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 
@@ -152,70 +146,89 @@ func (h *NFSProcedureHandler) handleFsstat(body io.Reader, reply *RPCReply, auth
 func (h *NFSProcedureHandler) handleFsinfo(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithPostOp(reply, NFSERR_STALE), nil
+	_, attrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithPostOp(reply, status), nil
 	}
 
-	// R22: Return NFS error instead of nil,err
-	attrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+	tuning := h.nfs().tuning.Load()
+	dtpref := tuning.PreferredDirReadSize
+	if authCtx.UDP && dtpref > maxUDPTransfer {
+		dtpref = maxUDPTransfer
 	}
+	rtmax, rtpref, rtmult := transferSizes(tuning.MaxReadSize, tuning.TransferSize, authCtx.UDP)
+	wtmax, wtpref, wtmult := transferSizes(tuning.MaxWriteSize, tuning.TransferSize, authCtx.UDP)
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 
-	binary.Write(&buf, binary.BigEndian, uint32(1048576))       // rtmax
-	binary.Write(&buf, binary.BigEndian, uint32(65536))         // rtpref
-	binary.Write(&buf, binary.BigEndian, uint32(4096))          // rtmult
-	binary.Write(&buf, binary.BigEndian, uint32(1048576))       // wtmax
-	binary.Write(&buf, binary.BigEndian, uint32(65536))         // wtpref
-	binary.Write(&buf, binary.BigEndian, uint32(4096))          // wtmult
-	binary.Write(&buf, binary.BigEndian, uint32(8192))          // dtpref (C1: uint32 not uint64)
+	binary.Write(&buf, binary.BigEndian, rtmax)
+	binary.Write(&buf, binary.BigEndian, rtpref)
+	binary.Write(&buf, binary.BigEndian, rtmult)
+	binary.Write(&buf, binary.BigEndian, wtmax)
+	binary.Write(&buf, binary.BigEndian, wtpref)
+	binary.Write(&buf, binary.BigEndian, wtmult)
+	binary.Write(&buf, binary.BigEndian, uint32(dtpref))        // dtpref (C1: uint32 not uint64)
 	binary.Write(&buf, binary.BigEndian, uint64(1099511627776)) // maxfilesize
 	binary.Write(&buf, binary.BigEndian, uint32(0))             // time_delta.seconds
 	binary.Write(&buf, binary.BigEndian, uint32(1000000))       // time_delta.nseconds
 
 	// R1: Correct FSINFO properties bitmask per RFC 1813
-	// FSF3_SYMLINK=0x0002, FSF3_HOMOGENEOUS=0x0008, FSF3_CANSETTIME=0x0010
-	// FSF3_LINK (0x0001) is NOT set because handleLink always returns NFSERR_NOTSUPP
-	var properties uint32 = 0x0002 | 0x0008 | 0x0010 // symlink + homogeneous + cansettime
+	// FSF3_LINK only when the backing filesystem can make hard links
+	var properties uint32 = FSF3_SYMLINK | FSF3_HOMOGENEOUS | FSF3_CANSETTIME
+	if h.nfs().linker != nil {
+		properties |= FSF3_LINK
+	}
 	binary.Write(&buf, binary.BigEndian, properties)
 
 	reply.Data = buf.Bytes()
 	return reply, nil
 }
 
+// transferSizes returns the FSINFO maximum, preferred size and multiple
+// for READ or WRITE. The preferred size is the smaller of the maximum and
+// transferSize, rounded down to a multiple of the 4KB multiple so aligned
+// requests stay aligned; a preferred size under 4KB is its own multiple.
+// UDP clients are held to transfers that fit in one datagram.
+func transferSizes(max, transferSize int, udp bool) (uint32, uint32, uint32) {
+	if udp && max > maxUDPTransfer {
+		max = maxUDPTransfer
+	}
+	pref := transferSize
+	if pref > max {
+		pref = max
+	}
+	mult := 4096
+	if pref < mult {
+		mult = pref
+	}
+	pref -= pref % mult
+	return uint32(max), uint32(pref), uint32(mult)
+}
+
 // handlePathconf handles NFSPROC3_PATHCONF - get path configuration
 func (h *NFSProcedureHandler) handlePathconf(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithPostOp(reply, NFSERR_STALE), nil
-	}
-
-	// R22: Return NFS error instead of nil,err
-	attrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+	_, attrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithPostOp(reply, status), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 
@@ -113,7 +113,7 @@ func TestR3_NilAttrsSafetyInGetAttr(t *testing.T) {
 	}
 }
 
-// TestR3_MapErrorWrappedErrors verifies that mapError correctly identifies
+// TestR3_MapErrorWrappedErrors verifies that MapErrorToNFSStatus correctly identifies
 // wrapped errors using errors.Is instead of direct comparison.
 func TestR3_MapErrorWrappedErrors(t *testing.T) {
 	tests := []struct {
@@ -149,12 +149,12 @@ func TestR3_MapErrorWrappedErrors(t *testing.T) {
 		{
 			name:     "direct os.ErrPermission",
 			err:      os.ErrPermission,
-			expected: NFSERR_PERM,
+			expected: NFSERR_ACCES,
 		},
 		{
 			name:     "PathError wrapping os.ErrPermission",
 			err:      &os.PathError{Op: "open", Path: "/test", Err: os.ErrPermission},
-			expected: NFSERR_PERM,
+			expected: NFSERR_ACCES,
 		},
 		{
 			name:     "direct os.ErrExist",
@@ -180,9 +180,9 @@ func TestR3_MapErrorWrappedErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := mapError(tt.err)
+			got := MapErrorToNFSStatus(tt.err)
 			if got != tt.expected {
-				t.Errorf("mapError(%v) = %d, want %d", tt.err, got, tt.expected)
+				t.Errorf("MapErrorToNFSStatus(%v) = %d, want %d", tt.err, got, tt.expected)
 			}
 		})
 	}
@@ -663,16 +663,16 @@ func TestR3_ReaddirplusSmallCount(t *testing.T) {
 		t.Fatalf("handleReaddirplus returned error: %v", err)
 	}
 
+	// No entry fits in 50 bytes, so the client is told to ask for more
 	status := readStatusFromReply(result)
-	if status != NFS_OK {
-		t.Fatalf("Expected NFS_OK, got %d", status)
+	if status != NFSERR_TOOSMALL {
+		t.Fatalf("Expected NFSERR_TOOSMALL, got %d", status)
 	}
 
 	data := getReplyData(result)
 	if len(data) > 65536 {
 		t.Errorf("READDIRPLUS response unreasonably large for small count: %d bytes", len(data))
 	}
-	t.Logf("READDIRPLUS with maxcount=50 returned %d bytes (no underflow)", len(data))
 }
 
 // TestR3_RmdirCacheInvalidation verifies that after RMDIR, the attr cache no
@@ -1605,6 +1605,45 @@ func TestCovBoost_HandleSetattr_GuardMismatch(t *testing.T) {
 	}
 }
 
+func TestSetattrGuardSeesBackingChanges(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	fh := allocHandle(t, srv, "/dir/file.txt")
+	node, _ := srv.handler.Lookup("/dir/file.txt")
+	cached, _ := srv.handler.GetAttr(node)
+	staleSec := uint32(cached.Mtime().Unix())
+	staleNsec := uint32(cached.Mtime().Nanosecond())
+
+	// Another writer changes the file directly on the backing filesystem
+	changed := cached.Mtime().Add(time.Hour)
+	if err := srv.handler.fs.Chtimes("/dir/file.txt", changed, changed); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	setattr := func(sec, nsec uint32) uint32 {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fh)
+		buf.Write(encodeSattr3(true, 0600, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0))
+		binary.Write(&buf, binary.BigEndian, uint32(1))
+		binary.Write(&buf, binary.BigEndian, sec)
+		binary.Write(&buf, binary.BigEndian, nsec)
+		result, err := handler.handleSetattr(bytes.NewReader(buf.Bytes()), &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleSetattr: %v", err)
+		}
+		return readStatus(t, result)
+	}
+
+	if status := setattr(staleSec, staleNsec); status != NFSERR_NOT_SYNC {
+		t.Errorf("guard with outdated ctime: expected NFSERR_NOT_SYNC, got %d", status)
+	}
+	if info, _ := srv.handler.fs.Stat("/dir/file.txt"); info.Mode().Perm() == 0600 {
+		t.Error("mode changed despite guard mismatch")
+	}
+	if status := setattr(uint32(changed.Unix()), uint32(changed.Nanosecond())); status != NFS_OK {
+		t.Errorf("guard with current ctime: expected NFS_OK, got %d", status)
+	}
+}
+
 func TestCovBoost_HandleSetattr_Truncate(t *testing.T) {
 	srv, handler, auth := setupHandlerEnv(t)
 	fh := allocHandle(t, srv, "/dir/file.txt")
@@ -1622,6 +1661,26 @@ func TestCovBoost_HandleSetattr_Truncate(t *testing.T) {
 	}
 }
 
+func TestSetattrSizeOnDirectory(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	fh := allocHandle(t, srv, "/dir")
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, fh)
+	buf.Write(encodeSattr3(false, 0, false, 0, false, 0, true, 0, 0, 0, 0, 0, 0, 0))
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	result, err := handler.handleSetattr(bytes.NewReader(buf.Bytes()), &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleSetattr: %v", err)
+	}
+	if status := readStatus(t, result); status != NFSERR_ISDIR {
+		t.Errorf("expected NFSERR_ISDIR, got %d", status)
+	}
+	info, err := srv.handler.fs.Stat("/dir/file.txt")
+	if err != nil || info.Size() != 5 {
+		t.Errorf("directory contents changed: %v, %v", info, err)
+	}
+}
+
 func TestCovBoost_HandleSetattr_ClientTime(t *testing.T) {
 	srv, handler, auth := setupHandlerEnv(t)
 	fh := allocHandle(t, srv, "/dir/file.txt")
@@ -1743,8 +1802,9 @@ func TestCovBoost_HandleCreate_ExclusiveExistingFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("handleCreate: %v", err)
 	}
-	if readStatus(t, result) != NFS_OK {
-		t.Errorf("expected NFS_OK for EXCLUSIVE create of existing file, got %d", readStatus(t, result))
+	// file.txt was not made by a CREATE with this verifier
+	if readStatus(t, result) != NFSERR_EXIST {
+		t.Errorf("expected NFSERR_EXIST for EXCLUSIVE create of existing file, got %d", readStatus(t, result))
 	}
 }
 
@@ -1766,6 +1826,72 @@ func TestCovBoost_HandleCreate_GUARDED(t *testing.T) {
 	}
 }
 
+func TestHandleCreateExistingFile(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	f, _ := srv.handler.fs.Create("/dir/existing.txt")
+	f.Write([]byte("existing content"))
+	f.Close()
+	dirH := allocHandle(t, srv, "/dir")
+
+	create := func(name string, how uint32, args []byte) uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dirH)
+		xdrEncodeString(&buf, name)
+		binary.Write(&buf, binary.BigEndian, how)
+		buf.Write(args)
+		result, err := handler.handleCreate(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleCreate: %v", err)
+		}
+		return readStatus(t, result)
+	}
+	size := func(name string) int64 {
+		t.Helper()
+		info, err := srv.handler.fs.Stat("/dir/" + name)
+		if err != nil {
+			t.Fatalf("Stat %s: %v", name, err)
+		}
+		return info.Size()
+	}
+
+	if status := create("existing.txt", UNCHECKED, encodeSattr3(true, 0644, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0)); status != NFS_OK {
+		t.Errorf("UNCHECKED create without a size: expected NFS_OK, got %d", status)
+	}
+	if got := size("existing.txt"); got != int64(len("existing content")) {
+		t.Errorf("UNCHECKED create without a size left %d bytes, want the original %d", got, len("existing content"))
+	}
+
+	if status := create("existing.txt", UNCHECKED, encodeSattr3(false, 0, false, 0, false, 0, true, 3, 0, 0, 0, 0, 0, 0)); status != NFS_OK {
+		t.Errorf("UNCHECKED create with size 3: expected NFS_OK, got %d", status)
+	}
+	if got := size("existing.txt"); got != 3 {
+		t.Errorf("UNCHECKED create with size 3 left %d bytes", got)
+	}
+
+	if status := create("existing.txt", GUARDED, encodeSattr3(false, 0, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0)); status != NFSERR_EXIST {
+		t.Errorf("GUARDED create: expected NFSERR_EXIST, got %d", status)
+	}
+	if got := size("existing.txt"); got != 3 {
+		t.Errorf("GUARDED create changed the file to %d bytes", got)
+	}
+
+	if status := create("sub", UNCHECKED, encodeSattr3(false, 0, false, 0, false, 0, false, 0, 0, 0, 0, 0, 0, 0)); status != NFSERR_EXIST {
+		t.Errorf("UNCHECKED create over a directory: expected NFSERR_EXIST, got %d", status)
+	}
+
+	verf := []byte{0, 0, 0x12, 0x34, 0, 0, 0x56, 0x78}
+	if status := create("exclusive.txt", EXCLUSIVE, verf); status != NFS_OK {
+		t.Fatalf("EXCLUSIVE create: expected NFS_OK, got %d", status)
+	}
+	if status := create("exclusive.txt", EXCLUSIVE, verf); status != NFS_OK {
+		t.Errorf("EXCLUSIVE retransmission: expected NFS_OK, got %d", status)
+	}
+	if status := create("exclusive.txt", EXCLUSIVE, []byte{1, 2, 3, 4, 5, 6, 7, 8}); status != NFSERR_EXIST {
+		t.Errorf("EXCLUSIVE create with another verifier: expected NFSERR_EXIST, got %d", status)
+	}
+}
+
 func TestCovBoost_HandleCreate_InvalidFilename(t *testing.T) {
 	srv, handler, auth := setupHandlerEnv(t)
 	dirH := allocHandle(t, srv, "/dir")
@@ -1861,7 +1987,7 @@ func TestCovBoost_HandleWrite_DataLenMismatch(t *testing.T) {
 }
 
 func TestCovBoost_HandleWrite_ExceedsMaxWriteSize(t *testing.T) {
-	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.TransferSize = 16 })
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.MaxWriteSize = 16 })
 	fh := allocHandle(t, srv, "/dir/file.txt")
 	data := make([]byte, 32)
 	var buf bytes.Buffer
@@ -2829,3 +2955,305 @@ func TestCovBoost_HandleMkdir_UIDGIDNonRoot(t *testing.T) {
 		t.Errorf("expected NFS_OK, got %d", readStatus(t, result))
 	}
 }
+
+func TestGetattrClampFutureMtime(t *testing.T) {
+	future := time.Now().AddDate(1, 0, 0)
+	getattrMtime := func(clamp bool) (time.Time, *recordingLogger) {
+		t.Helper()
+		srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.ClampFutureMtime = clamp })
+		logs := &recordingLogger{}
+		srv.handler.SetLogger(logs)
+		if err := srv.handler.fs.Chtimes("/dir/file.txt", future, future); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/dir/file.txt"))
+		result, err := handler.handleGetattr(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleGetattr: %v", err)
+		}
+		if status := readStatus(t, result); status != NFS_OK {
+			t.Fatalf("GETATTR: expected NFS_OK, got %d", status)
+		}
+		// mtime follows type through fileid (5 words, 5 hyper) and atime in fattr3
+		data := result.Data.([]byte)[4+4*5+8*5+8:]
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), int64(binary.BigEndian.Uint32(data[4:]))), logs
+	}
+
+	mtime, logs := getattrMtime(false)
+	if mtime.Unix() != future.Unix() {
+		t.Errorf("Without clamping, mtime = %v, want %v", mtime, future)
+	}
+	if len(logs.entries) != 0 {
+		t.Errorf("Without clamping, expected no log entries, got %v", logs.entries)
+	}
+
+	mtime, logs = getattrMtime(true)
+	if mtime.After(time.Now()) {
+		t.Errorf("With clamping, mtime = %v is in the future", mtime)
+	}
+	if time.Since(mtime) > time.Minute {
+		t.Errorf("With clamping, mtime = %v, want about now", mtime)
+	}
+	if len(logs.entries) == 0 || logs.entries[0][0].Value != "backing filesystem reported a future mtime" {
+		t.Errorf("With clamping, expected the future mtime to be logged, got %v", logs.entries)
+	}
+}
+
+func TestMetadataRateLimit(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.EnableRateLimiting = true
+		o.RateLimitConfig.MetadataOpsPerSecond = 1
+		o.RateLimitConfig.MetadataBurstSize = 3
+	})
+	dirHandle := allocHandle(t, srv, "/dir")
+	fileHandle := allocHandle(t, srv, "/dir/file.txt")
+
+	lookup := func() uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dirHandle)
+		xdrEncodeString(&buf, "file.txt")
+		result, err := handler.handleLookup(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleLookup: %v", err)
+		}
+		return readStatus(t, result)
+	}
+
+	for i := 0; i < 3; i++ {
+		if status := lookup(); status != NFS_OK {
+			t.Fatalf("LOOKUP %d within burst: expected NFS_OK, got %d", i, status)
+		}
+	}
+	if status := lookup(); status != NFSERR_JUKEBOX {
+		t.Fatalf("LOOKUP past budget: expected NFSERR_JUKEBOX, got %d", status)
+	}
+
+	// Reads draw from a separate budget and keep working
+	for i := 0; i < 5; i++ {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fileHandle)
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, uint32(5))
+		result, err := handler.handleRead(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleRead: %v", err)
+		}
+		if status := readStatus(t, result); status != NFS_OK {
+			t.Fatalf("READ %d: expected NFS_OK, got %d", i, status)
+		}
+	}
+
+	// Another client has its own budget
+	other := *auth
+	other.ClientIP = "127.0.0.2"
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, dirHandle)
+	xdrEncodeString(&buf, "file.txt")
+	result, err := handler.handleLookup(&buf, &RPCReply{}, &other)
+	if err != nil {
+		t.Fatalf("handleLookup: %v", err)
+	}
+	if status := readStatus(t, result); status != NFS_OK {
+		t.Errorf("LOOKUP from another client: expected NFS_OK, got %d", status)
+	}
+}
+
+func TestPerClientReadRateLimit(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) {
+		o.EnableRateLimiting = true
+		o.RateLimitConfig.PerClientReadOpsPerSecond = 1
+		o.RateLimitConfig.PerClientBurstSize = 2
+	})
+	fileHandle := allocHandle(t, srv, "/dir/file.txt")
+
+	read := func(auth *AuthContext) uint32 {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fileHandle)
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		binary.Write(&buf, binary.BigEndian, uint32(5))
+		result, err := handler.handleRead(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleRead: %v", err)
+		}
+		return readStatus(t, result)
+	}
+
+	for i := 0; i < 2; i++ {
+		if status := read(auth); status != NFS_OK {
+			t.Fatalf("READ %d within burst: expected NFS_OK, got %d", i, status)
+		}
+	}
+	if status := read(auth); status != NFSERR_DELAY {
+		t.Fatalf("READ past budget: expected NFSERR_DELAY, got %d", status)
+	}
+
+	// The noisy client does not starve another one
+	other := *auth
+	other.ClientIP = "127.0.0.2"
+	if status := read(&other); status != NFS_OK {
+		t.Errorf("READ from another client: expected NFS_OK, got %d", status)
+	}
+	if got := srv.handler.metrics.GetMetrics().ThrottledClients; got != 1 {
+		t.Errorf("ThrottledClients = %d, want 1", got)
+	}
+}
+
+func TestMetadataRateLimitUnlimitedByDefault(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.EnableRateLimiting = true })
+	fileHandle := allocHandle(t, srv, "/dir/file.txt")
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fileHandle)
+		result, err := handler.handleGetattr(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleGetattr: %v", err)
+		}
+		if status := readStatus(t, result); status != NFS_OK {
+			t.Fatalf("GETATTR %d: expected NFS_OK, got %d", i, status)
+		}
+	}
+}
+
+func TestConcurrentRenameOfSameSource(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	dirHandle := allocHandle(t, srv, "/dir")
+	subHandle := allocHandle(t, srv, "/dir/sub")
+
+	rename := func(dst uint64, name string) uint32 {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, dirHandle)
+		xdrEncodeString(&buf, "file.txt")
+		xdrEncodeFileHandle(&buf, dst)
+		xdrEncodeString(&buf, name)
+		result, err := handler.handleRename(&buf, &RPCReply{}, auth)
+		if err != nil {
+			return math.MaxUint32
+		}
+		return binary.BigEndian.Uint32(result.Data.([]byte))
+	}
+
+	for i := 0; i < 50; i++ {
+		writeTestFile(t, srv.handler.fs.(*memfs.FileSystem), "/dir/file.txt", "hello")
+		srv.handler.attrCache.Clear()
+
+		statuses := make(chan uint32, 2)
+		go func() { statuses <- rename(dirHandle, fmt.Sprintf("a%d.txt", i)) }()
+		go func() { statuses <- rename(subHandle, fmt.Sprintf("b%d.txt", i)) }()
+
+		counts := make(map[uint32]int)
+		for j := 0; j < 2; j++ {
+			select {
+			case status := <-statuses:
+				counts[status]++
+			case <-time.After(5 * time.Second):
+				t.Fatalf("round %d: concurrent renames deadlocked", i)
+			}
+		}
+		if counts[NFS_OK] != 1 || counts[NFSERR_NOENT] != 1 {
+			t.Fatalf("round %d: statuses %v, want one NFS_OK and one NFSERR_NOENT", i, counts)
+		}
+	}
+}
+
+func TestHandleWriteMaxWriteGap(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.MaxWriteGap = 1024 })
+	fh := allocHandle(t, srv, "/dir/file.txt")
+
+	write := func(offset uint64) uint32 {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fh)
+		binary.Write(&buf, binary.BigEndian, offset)
+		binary.Write(&buf, binary.BigEndian, uint32(4))
+		binary.Write(&buf, binary.BigEndian, uint32(FILE_SYNC))
+		binary.Write(&buf, binary.BigEndian, uint32(4))
+		buf.WriteString("data")
+		result, err := handler.handleWrite(&buf, &RPCReply{}, auth)
+		if err != nil {
+			t.Fatalf("handleWrite: %v", err)
+		}
+		return readStatus(t, result)
+	}
+
+	// "hello" is 5 bytes, so a write at 5+1024 leaves the widest hole allowed
+	if status := write(5 + 1024); status != NFS_OK {
+		t.Fatalf("write within MaxWriteGap: status %d", status)
+	}
+	info, _ := srv.handler.fs.Stat("/dir/file.txt")
+	if info.Size() != 5+1024+4 {
+		t.Fatalf("size after write = %d, want %d", info.Size(), 5+1024+4)
+	}
+	if status := write(uint64(info.Size()) + 1025); status != NFSERR_FBIG {
+		t.Errorf("write past MaxWriteGap: status %d, want NFSERR_FBIG", status)
+	}
+	if after, _ := srv.handler.fs.Stat("/dir/file.txt"); after.Size() != info.Size() {
+		t.Errorf("refused write changed the size to %d", after.Size())
+	}
+}
+
+func TestWriteVerifier(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, mfs, "/file.txt", "hello")
+	nfs, err := New(mfs, ExportOptions{Async: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer nfs.Close()
+	srv, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv.SetHandler(nfs)
+	handler := &NFSProcedureHandler{server: srv}
+	auth := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 1023}
+	fh := allocHandle(t, srv, "/file.txt")
+
+	// status, then wcc_data with both sides present, ahead of the verifier
+	const wccEnd = 4 + 4 + 24 + 4 + 84
+	write := func(offset uint64) []byte {
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, fh)
+		xdrEncodeUint64(&buf, offset)
+		xdrEncodeUint32(&buf, 4)
+		xdrEncodeUint32(&buf, UNSTABLE)
+		xdrEncodeUint32(&buf, 4)
+		buf.WriteString("data")
+		result, err := handler.handleWrite(&buf, &RPCReply{}, auth)
+		if err != nil || readStatus(t, result) != NFS_OK {
+			t.Fatalf("WRITE at %d: %v, status %d", offset, err, readStatus(t, result))
+		}
+		return result.Data.([]byte)[wccEnd+8 : wccEnd+16] // after count and committed
+	}
+	first, second := write(0), write(4)
+	if !bytes.Equal(first, second) || !bytes.Equal(first, srv.writeVerf[:]) {
+		t.Errorf("WRITE verifiers %x and %x, want the server's %x for both", first, second, srv.writeVerf)
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, fh)
+	xdrEncodeUint64(&buf, 0)
+	xdrEncodeUint32(&buf, 0)
+	result, err := handler.handleCommit(&buf, &RPCReply{}, auth)
+	if err != nil || readStatus(t, result) != NFS_OK {
+		t.Fatalf("COMMIT: %v, status %d", err, readStatus(t, result))
+	}
+	if commit := result.Data.([]byte)[wccEnd : wccEnd+8]; !bytes.Equal(commit, first) {
+		t.Errorf("COMMIT verifier %x, want the WRITE verifier %x", commit, first)
+	}
+
+	// A restarted server has a new verifier, so clients resend UNSTABLE data
+	for i := 0; i < 10; i++ {
+		restarted, err := NewServer(ServerOptions{})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		if restarted.writeVerf == srv.writeVerf {
+			t.Fatalf("two servers share the write verifier %x", srv.writeVerf)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package absnfs
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"path"
@@ -16,7 +17,7 @@ import (
 func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
 	name, err := xdrDecodeString(body)
@@ -29,6 +30,10 @@ func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, auth
 		return nfsErrorWithPostOp(reply, NFSERR_ACCES), nil
 	}
 
+	if h.metadataThrottled(authCtx) {
+		return nfsErrorWithPostOp(reply, NFSERR_JUKEBOX), nil
+	}
+
 	node, ok := h.lookupNode(handleVal)
 	if !ok {
 		return nfsErrorWithPostOp(reply, NFSERR_STALE), nil
@@ -50,7 +55,7 @@ func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, auth
 		var buf bytes.Buffer
 		xdrEncodeUint32(&buf, NFSERR_NOTDIR)
 		xdrEncodeUint32(&buf, 1)
-		if err := encodeFileAttributes(&buf, &nodeAttrsCopy); err != nil {
+		if err := encodeFileAttributes(&buf, &nodeAttrsCopy, h.nfs().idMaps()); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
 		reply.Data = buf.Bytes()
@@ -62,7 +67,8 @@ func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, auth
 		h.server.logger.Printf("LOOKUP: Looking up '%s'", lookupPath)
 	}
 
-	lookupNode, err := h.server.handler.Lookup(lookupPath)
+	ctx := h.nfs().withSymlinkBudget(context.Background())
+	lookupNode, err := h.nfs().lookupChild(ctx, node, name)
 	if err != nil {
 		if h.server.options.Debug {
 			h.server.logger.Printf("LOOKUP: '%s' not found: %v", lookupPath, err)
@@ -76,16 +82,16 @@ func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, auth
 		nodeAttrsCopy := *node.attrs
 		node.mu.RUnlock()
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, mapError(err))
+		xdrEncodeUint32(&buf, MapErrorToNFSStatus(err))
 		xdrEncodeUint32(&buf, 1)
-		if err := encodeFileAttributes(&buf, &nodeAttrsCopy); err != nil {
+		if err := encodeFileAttributes(&buf, &nodeAttrsCopy, h.nfs().idMaps()); err != nil {
 			return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
 	}
 
-	handle := h.server.handler.fileMap.Allocate(lookupNode)
+	handle := h.nfs().fileMap.Allocate(lookupNode)
 	if h.server.options.Debug {
 		h.server.logger.Printf("LOOKUP: Found '%s', allocated handle %d", lookupPath, handle)
 	}
@@ -102,11 +108,11 @@ func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, auth
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeFileHandle(&buf, handle)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, &lookupAttrsCopy); err != nil {
+	if err := encodeFileAttributes(&buf, &lookupAttrsCopy, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, &nodeAttrsCopy); err != nil {
+	if err := encodeFileAttributes(&buf, &nodeAttrsCopy, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 	reply.Data = buf.Bytes()
@@ -117,7 +123,11 @@ func (h *NFSProcedureHandler) handleLookup(body io.Reader, reply *RPCReply, auth
 func (h *NFSProcedureHandler) handleReadlink(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
+	}
+
+	if h.metadataThrottled(authCtx) {
+		return nfsErrorWithPostOp(reply, NFSERR_JUKEBOX), nil
 	}
 
 	node, ok := h.lookupNode(handleVal)
@@ -134,20 +144,20 @@ func (h *NFSProcedureHandler) handleReadlink(body io.Reader, reply *RPCReply, au
 	}
 
 	// R22: Return NFS error instead of nil,err
-	target, err := h.server.handler.Readlink(node)
+	target, err := h.nfs().Readlink(node)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+		return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
 	}
 
-	attrs, err := h.server.handler.GetAttr(node)
+	attrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+		return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 	if err := xdrEncodeString(&buf, target); err != nil {
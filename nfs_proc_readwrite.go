@@ -10,13 +10,15 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"os"
+	"time"
 )
 
 // handleRead handles NFSPROC3_READ - read from file
 func (h *NFSProcedureHandler) handleRead(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithPostOp(reply, handleDecodeStatus(err)), nil
 	}
 
 	var offset uint64
@@ -32,11 +34,22 @@ func (h *NFSProcedureHandler) handleRead(body io.Reader, reply *RPCReply, authCt
 		return nfsErrorWithPostOp(reply, NFSERR_INVAL), nil
 	}
 
+	// A read past the advertised rtmax, or one that must fit a UDP
+	// datagram, comes up short and the client asks for the rest
+	if max := uint32(h.nfs().tuning.Load().MaxReadSize); count > max {
+		count = max
+	}
+	count = udpTransferCap(authCtx, count)
+
+	if h.throttled(authCtx, OpTypeRead) {
+		return nfsErrorWithPostOp(reply, NFSERR_DELAY), nil
+	}
+
 	// Rate limiting for large reads
-	if count > 65536 && h.server.handler.rateLimiter != nil && h.server.handler.policy.Load().EnableRateLimiting {
-		if !h.server.handler.rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReadLarge) {
-			if h.server.handler.metrics != nil {
-				h.server.handler.metrics.RecordRateLimitExceeded()
+	if count > 65536 && h.nfs().rateLimiter != nil && h.nfs().policy.Load().EnableRateLimiting {
+		if !h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReadLarge) {
+			if h.nfs().metrics != nil {
+				h.nfs().metrics.RecordRateLimitExceeded()
 			}
 			return nfsErrorWithPostOp(reply, NFSERR_DELAY), nil
 		}
@@ -47,21 +60,33 @@ func (h *NFSProcedureHandler) handleRead(body io.Reader, reply *RPCReply, authCt
 		return nfsErrorWithPostOp(reply, NFSERR_STALE), nil
 	}
 
+	// A symlink is not readable as a file; clients must use READLINK
+	if isSymlinkNode(node) {
+		return nfsErrorWithPostOp(reply, NFSERR_INVAL), nil
+	}
+
+	if status := h.checkAccess(node, callerCredential(authCtx), accessRead); status != NFS_OK {
+		return nfsErrorWithPostOp(reply, status), nil
+	}
+
+	ctx := authCtx.callContext()
+	traceTransfer(ctx, handleVal, offset, count)
+
 	// R22: Return NFS error instead of nil,err
-	data, err := h.server.handler.Read(node, int64(offset), int64(count))
+	data, err := h.nfs().ReadWithContext(ctx, node, int64(offset), int64(count))
 	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+		return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
 	}
 
-	attrs, err := h.server.handler.GetAttr(node)
+	attrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithPostOp(reply, mapError(err)), nil
+		return nfsErrorWithPostOp(reply, handleErrorStatus(err)), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	xdrEncodeUint32(&buf, 1)
-	if err := encodeFileAttributes(&buf, attrs); err != nil {
+	if err := encodeFileAttributes(&buf, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithPostOp(reply, NFSERR_IO), nil
 	}
 	xdrEncodeUint32(&buf, uint32(len(data)))
@@ -85,13 +110,13 @@ func (h *NFSProcedureHandler) handleRead(body io.Reader, reply *RPCReply, authCt
 
 // handleWrite handles NFSPROC3_WRITE - write to file
 func (h *NFSProcedureHandler) handleWrite(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	var offset uint64
@@ -110,11 +135,15 @@ func (h *NFSProcedureHandler) handleWrite(body io.Reader, reply *RPCReply, authC
 		return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
 	}
 
+	if h.throttled(authCtx, OpTypeWrite) {
+		return nfsErrorWithWcc(reply, NFSERR_DELAY), nil
+	}
+
 	// Rate limiting for large writes
-	if count > 65536 && h.server.handler.rateLimiter != nil && h.server.handler.policy.Load().EnableRateLimiting {
-		if !h.server.handler.rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeWriteLarge) {
-			if h.server.handler.metrics != nil {
-				h.server.handler.metrics.RecordRateLimitExceeded()
+	if count > 65536 && h.nfs().rateLimiter != nil && h.nfs().policy.Load().EnableRateLimiting {
+		if !h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeWriteLarge) {
+			if h.nfs().metrics != nil {
+				h.nfs().metrics.RecordRateLimitExceeded()
 			}
 			return nfsErrorWithWcc(reply, NFSERR_DELAY), nil
 		}
@@ -129,11 +158,7 @@ func (h *NFSProcedureHandler) handleWrite(body io.Reader, reply *RPCReply, authC
 	}
 
 	// Bound count to the server's advertised write size to prevent DoS
-	maxWriteSize := uint32(h.server.handler.tuning.Load().TransferSize)
-	if maxWriteSize == 0 {
-		maxWriteSize = 1048576 // 1MB default
-	}
-	if count > maxWriteSize {
+	if count > uint32(h.nfs().tuning.Load().MaxWriteSize) {
 		return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
 	}
 
@@ -153,51 +178,90 @@ func (h *NFSProcedureHandler) handleWrite(body io.Reader, reply *RPCReply, authC
 		return nfsErrorWithWcc(reply, NFSERR_STALE), nil
 	}
 
+	// Writing through a symlink handle would modify its target
+	if isSymlinkNode(node) {
+		return nfsErrorWithWcc(reply, NFSERR_INVAL), nil
+	}
+
+	if status := h.checkAccess(node, callerCredential(authCtx), accessWrite); status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
+	}
+
 	if h.server.options.Debug {
 		h.server.logger.Printf("WRITE: handle=%d path='%s' offset=%d count=%d stable=%d", handleVal, node.path, offset, count, stable)
 	}
 
 	// R23: Return NFS error instead of nil,err
-	preAttrs, err := h.server.handler.GetAttr(node)
+	preAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	n, err := h.server.handler.Write(node, int64(offset), data)
+	if h.dryRun() {
+		h.logDryRun("WRITE", LogField{Key: "path", Value: node.path},
+			LogField{Key: "offset", Value: offset}, LogField{Key: "count", Value: count})
+		postAttrs := *preAttrs
+		if end := int64(offset) + int64(count); end > postAttrs.Size {
+			postAttrs.Size = end
+		}
+		postAttrs.SetMtime(time.Now())
+		var buf bytes.Buffer
+		xdrEncodeUint32(&buf, NFS_OK)
+		if err := encodeWccData(&buf, preAttrs, &postAttrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorWithWcc(reply, NFSERR_IO), nil
+		}
+		xdrEncodeUint32(&buf, count)
+		xdrEncodeUint32(&buf, FILE_SYNC)
+		buf.Write(h.server.writeVerf[:])
+		reply.Data = buf.Bytes()
+		return reply, nil
+	}
+
+	ctx := authCtx.callContext()
+	traceTransfer(ctx, handleVal, offset, count)
+	n, err := h.nfs().WriteWithContext(ctx, node, int64(offset), data)
 	if err != nil {
 		if h.server.options.Debug {
 			h.server.logger.Printf("WRITE: Failed to write to '%s': %v", node.path, err)
 		}
-		postAttrs, _ := h.server.handler.GetAttr(node)
+		postAttrs, _ := h.nfs().GetAttr(node)
 		if postAttrs == nil {
 			postAttrs = preAttrs
 		}
 
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, mapError(err))
-		if err := encodeWccData(&buf, preAttrs, postAttrs); err != nil {
+		xdrEncodeUint32(&buf, writeErrorStatus(err))
+		if err := encodeWccData(&buf, preAttrs, postAttrs, h.nfs().idMaps()); err != nil {
 			return nfsErrorWithWcc(reply, NFSERR_IO), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
 	}
 
-	attrs, err := h.server.handler.GetAttr(node)
+	attrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
 	if h.server.options.Debug {
 		h.server.logger.Printf("WRITE: Success, wrote %d bytes to '%s'", n, node.path)
 	}
 
+	// With Async, UNSTABLE data is synced in the background and COMMIT
+	// waits for it; otherwise the backing write is treated as stable
+	committed := uint32(FILE_SYNC)
+	if tuning := h.nfs().tuning.Load(); stable == UNSTABLE && tuning.Async {
+		h.nfs().syncQueue.enqueue(node.path, tuning.UnstableFlushTimeout)
+		committed = UNSTABLE
+	}
+
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeWccData(&buf, preAttrs, attrs); err != nil {
+	if err := encodeWccData(&buf, preAttrs, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 	xdrEncodeUint32(&buf, uint32(n))
-	xdrEncodeUint32(&buf, 2)         // FILE_SYNC: server does synchronous writes
+	xdrEncodeUint32(&buf, committed)
 	buf.Write(h.server.writeVerf[:]) // writeverf unique per server boot
 
 	reply.Data = buf.Bytes()
@@ -206,13 +270,13 @@ func (h *NFSProcedureHandler) handleWrite(body io.Reader, reply *RPCReply, authC
 
 // handleCommit handles NFSPROC3_COMMIT - commit cached data
 func (h *NFSProcedureHandler) handleCommit(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	var offset uint64
@@ -224,21 +288,26 @@ func (h *NFSProcedureHandler) handleCommit(body io.Reader, reply *RPCReply, auth
 		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
 	}
 
-	node, ok := h.lookupNode(handleVal)
-	if !ok {
-		return nfsErrorWithWcc(reply, NFSERR_STALE), nil
+	node, attrs, status := h.resolveHandle(handleVal)
+	if status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
 	}
 
-	// R23: Return NFS error instead of nil,err
-	attrs, err := h.server.handler.GetAttr(node)
-	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+	// Block until the background syncs of this file's UNSTABLE writes finish
+	if err := h.nfs().syncQueue.wait(node.path); err != nil {
+		var buf bytes.Buffer
+		xdrEncodeUint32(&buf, handleErrorStatus(err))
+		if err := encodeWccData(&buf, attrs, attrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorWithWcc(reply, NFSERR_IO), nil
+		}
+		reply.Data = buf.Bytes()
+		return reply, nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
 	// wcc_data (RFC 1813 section 3.3.21)
-	if err := encodeWccData(&buf, attrs, attrs); err != nil {
+	if err := encodeWccData(&buf, attrs, attrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 	buf.Write(h.server.writeVerf[:]) // writeverf unique per server boot
@@ -246,3 +315,11 @@ func (h *NFSProcedureHandler) handleCommit(body io.Reader, reply *RPCReply, auth
 	reply.Data = buf.Bytes()
 	return reply, nil
 }
+
+// isSymlinkNode reports whether node is a symbolic link. READ and WRITE
+// reject symlink handles rather than following them to the target.
+func isSymlinkNode(node *NFSNode) bool {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.attrs.Mode&os.ModeSymlink != 0
+}
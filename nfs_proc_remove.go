@@ -10,18 +10,18 @@ import (
 	"bytes"
 	"io"
 	"os"
-	"path"
+	"time"
 )
 
 // handleRemove handles NFSPROC3_REMOVE - remove a file
 func (h *NFSProcedureHandler) handleRemove(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	name, err := xdrDecodeString(body)
@@ -48,28 +48,36 @@ func (h *NFSProcedureHandler) handleRemove(body io.Reader, reply *RPCReply, auth
 	}
 
 	// R23: Return NFS error instead of nil,err
-	dirPreAttrs, err := h.server.handler.GetAttr(node)
+	dirPreAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
+	}
+
+	if status := h.checkAccess(node, callerCredential(authCtx), accessWrite|accessExecute); status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
 	}
 
 	if h.server.options.Debug {
 		h.server.logger.Printf("REMOVE: Removing '%s' from directory '%s'", name, node.path)
 	}
 
-	if err := h.server.handler.Remove(node, name); err != nil {
+	if h.dryRun() {
+		return h.dryRunRemoved(reply, "REMOVE", node, dirPreAttrs, name)
+	}
+
+	if err := h.nfs().RemoveWithContext(authCtx.callContext(), node, name); err != nil {
 		if h.server.options.Debug {
 			h.server.logger.Printf("REMOVE: Failed to remove '%s': %v", name, err)
 		}
-		dirPostAttrs, _ := h.server.handler.GetAttr(node)
+		dirPostAttrs, _ := h.nfs().GetAttr(node)
 		if dirPostAttrs == nil {
 			dirPostAttrs = dirPreAttrs
 		}
 
 		var buf bytes.Buffer
-		xdrEncodeUint32(&buf, mapError(err))
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); wccErr != nil {
-			return nfsErrorWithWcc(reply, mapError(err)), nil
+		xdrEncodeUint32(&buf, MapErrorToNFSStatus(err))
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); wccErr != nil {
+			return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
@@ -79,14 +87,14 @@ func (h *NFSProcedureHandler) handleRemove(body io.Reader, reply *RPCReply, auth
 		h.server.logger.Printf("REMOVE: Successfully removed '%s' from '%s'", name, node.path)
 	}
 
-	dirPostAttrs, err := h.server.handler.GetAttr(node)
+	dirPostAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 
@@ -96,13 +104,13 @@ func (h *NFSProcedureHandler) handleRemove(body io.Reader, reply *RPCReply, auth
 
 // handleRmdir handles NFSPROC3_RMDIR - remove a directory
 func (h *NFSProcedureHandler) handleRmdir(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithWcc(reply, NFSERR_ROFS), nil
 	}
 
 	handleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	name, err := xdrDecodeString(body)
@@ -129,17 +137,24 @@ func (h *NFSProcedureHandler) handleRmdir(body io.Reader, reply *RPCReply, authC
 	}
 
 	// R23: Return NFS error instead of nil,err
-	dirPreAttrs, err := h.server.handler.GetAttr(node)
+	dirPreAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	targetPath := path.Join(node.path, name)
-	targetInfo, err := h.server.handler.fs.Stat(targetPath)
+	if status := h.checkAccess(node, callerCredential(authCtx), accessWrite|accessExecute); status != NFS_OK {
+		return nfsErrorWithWcc(reply, status), nil
+	}
+
+	targetPath, err := h.nfs().entryPath(node, name)
+	if err != nil {
+		return nfsErrorWithWcc(reply, MapErrorToNFSStatus(err)), nil
+	}
+	targetInfo, err := h.nfs().fs.Stat(targetPath)
 	if err != nil {
 		var buf bytes.Buffer
 		xdrEncodeUint32(&buf, NFSERR_NOENT)
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPreAttrs); wccErr != nil {
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPreAttrs, h.nfs().idMaps()); wccErr != nil {
 			return nfsErrorWithWcc(reply, NFSERR_NOENT), nil
 		}
 		reply.Data = buf.Bytes()
@@ -149,36 +164,33 @@ func (h *NFSProcedureHandler) handleRmdir(body io.Reader, reply *RPCReply, authC
 	if !targetInfo.IsDir() {
 		var buf bytes.Buffer
 		xdrEncodeUint32(&buf, NFSERR_NOTDIR)
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPreAttrs); wccErr != nil {
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPreAttrs, h.nfs().idMaps()); wccErr != nil {
 			return nfsErrorWithWcc(reply, NFSERR_NOTDIR), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
 	}
 
-	if err := h.server.handler.fs.Remove(targetPath); err != nil {
-		dirPostAttrs, _ := h.server.handler.GetAttr(node)
+	if h.dryRun() {
+		return h.dryRunRemoved(reply, "RMDIR", node, dirPreAttrs, name)
+	}
+
+	if err := h.nfs().fs.Remove(targetPath); err != nil {
+		dirPostAttrs, _ := h.nfs().GetAttr(node)
 		if dirPostAttrs == nil {
 			dirPostAttrs = dirPreAttrs
 		}
 
-		var errCode uint32
-		if os.IsPermission(err) {
-			errCode = NFSERR_ACCES
-		} else if os.IsNotExist(err) {
-			errCode = NFSERR_NOENT
-		} else {
-			errCode = mapError(err)
-			// mapError maps os.IsExist errors to NFSERR_EXIST, but for directory
-			// removal the most common cause of os.IsExist is "directory not empty"
-			if errCode == NFSERR_EXIST || errCode == NFSERR_IO {
-				errCode = NFSERR_NOTEMPTY
-			}
+		errCode := MapErrorToNFSStatus(err)
+		// Backends that don't return ENOTEMPTY typically report a non-empty
+		// directory as os.ErrExist or an untyped error
+		if errCode == NFSERR_EXIST || errCode == NFSERR_IO {
+			errCode = NFSERR_NOTEMPTY
 		}
 
 		var buf bytes.Buffer
 		xdrEncodeUint32(&buf, errCode)
-		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); wccErr != nil {
+		if wccErr := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); wccErr != nil {
 			return nfsErrorWithWcc(reply, errCode), nil
 		}
 		reply.Data = buf.Bytes()
@@ -186,21 +198,23 @@ func (h *NFSProcedureHandler) handleRmdir(body io.Reader, reply *RPCReply, authC
 	}
 
 	// Invalidate caches for removed directory and parent
-	h.server.handler.attrCache.Invalidate(targetPath)
-	h.server.handler.attrCache.Invalidate(node.path)
-	if h.server.handler.dirCache != nil {
-		h.server.handler.dirCache.Invalidate(node.path)
-		h.server.handler.dirCache.Invalidate(targetPath)
+	h.nfs().attrCache.Invalidate(targetPath)
+	h.nfs().attrCache.Invalidate(node.path)
+	h.nfs().forgetIdentity(targetPath, false)
+	h.nfs().cookieCache.Touch(node.path)
+	if h.nfs().dirCache != nil {
+		h.nfs().dirCache.Invalidate(node.path)
+		h.nfs().dirCache.Invalidate(targetPath)
 	}
 
-	dirPostAttrs, err := h.server.handler.GetAttr(node)
+	dirPostAttrs, err := h.nfs().GetAttr(node)
 	if err != nil {
-		return nfsErrorWithWcc(reply, mapError(err)), nil
+		return nfsErrorWithWcc(reply, handleErrorStatus(err)), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithWcc(reply, NFSERR_IO), nil
 	}
 
@@ -210,13 +224,13 @@ func (h *NFSProcedureHandler) handleRmdir(body io.Reader, reply *RPCReply, authC
 
 // handleRename handles NFSPROC3_RENAME - rename a file or directory
 func (h *NFSProcedureHandler) handleRename(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	if h.server.handler.policy.Load().ReadOnly {
+	if h.readOnly(authCtx) {
 		return nfsErrorWithDoubleWcc(reply, NFSERR_ROFS), nil
 	}
 
 	srcHandleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithDoubleWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithDoubleWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	srcName, err := xdrDecodeString(body)
@@ -231,7 +245,7 @@ func (h *NFSProcedureHandler) handleRename(body io.Reader, reply *RPCReply, auth
 
 	dstHandleVal, err := xdrDecodeFileHandle(body)
 	if err != nil {
-		return nfsErrorWithDoubleWcc(reply, GARBAGE_ARGS), nil
+		return nfsErrorWithDoubleWcc(reply, handleDecodeStatus(err)), nil
 	}
 
 	dstName, err := xdrDecodeString(body)
@@ -244,6 +258,11 @@ func (h *NFSProcedureHandler) handleRename(body io.Reader, reply *RPCReply, auth
 		return nfsErrorWithDoubleWcc(reply, status), nil
 	}
 
+	// Directories in different exports are on different filesystems
+	if h.server.exportForHandle(srcHandleVal) != h.server.exportForHandle(dstHandleVal) {
+		return nfsErrorWithDoubleWcc(reply, NFSERR_XDEV), nil
+	}
+
 	srcDir, ok := h.lookupNode(srcHandleVal)
 	if !ok {
 		return nfsErrorWithDoubleWcc(reply, NFSERR_STALE), nil
@@ -255,55 +274,75 @@ func (h *NFSProcedureHandler) handleRename(body io.Reader, reply *RPCReply, auth
 	}
 
 	// R23: Return NFS error instead of nil,err
-	srcDirPreAttrs, err := h.server.handler.GetAttr(srcDir)
+	srcDirPreAttrs, err := h.nfs().GetAttr(srcDir)
 	if err != nil {
-		return nfsErrorWithDoubleWcc(reply, mapError(err)), nil
+		return nfsErrorWithDoubleWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	dstDirPreAttrs, err := h.server.handler.GetAttr(dstDir)
+	dstDirPreAttrs, err := h.nfs().GetAttr(dstDir)
 	if err != nil {
-		return nfsErrorWithDoubleWcc(reply, mapError(err)), nil
+		return nfsErrorWithDoubleWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	if err := h.server.handler.Rename(srcDir, srcName, dstDir, dstName); err != nil {
-		srcDirPostAttrs, _ := h.server.handler.GetAttr(srcDir)
+	if h.dryRun() {
+		h.logDryRun("RENAME",
+			LogField{Key: "dir", Value: srcDir.path}, LogField{Key: "name", Value: srcName},
+			LogField{Key: "to_dir", Value: dstDir.path}, LogField{Key: "to_name", Value: dstName})
+		now := time.Now()
+		srcDirPostAttrs, dstDirPostAttrs := *srcDirPreAttrs, *dstDirPreAttrs
+		srcDirPostAttrs.SetMtime(now)
+		dstDirPostAttrs.SetMtime(now)
+		var buf bytes.Buffer
+		xdrEncodeUint32(&buf, NFS_OK)
+		if err := encodeWccData(&buf, srcDirPreAttrs, &srcDirPostAttrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorWithDoubleWcc(reply, NFSERR_IO), nil
+		}
+		if err := encodeWccData(&buf, dstDirPreAttrs, &dstDirPostAttrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorWithDoubleWcc(reply, NFSERR_IO), nil
+		}
+		reply.Data = buf.Bytes()
+		return reply, nil
+	}
+
+	if err := h.nfs().RenameWithContext(authCtx.callContext(), srcDir, srcName, dstDir, dstName); err != nil {
+		srcDirPostAttrs, _ := h.nfs().GetAttr(srcDir)
 		if srcDirPostAttrs == nil {
 			srcDirPostAttrs = srcDirPreAttrs
 		}
-		dstDirPostAttrs, _ := h.server.handler.GetAttr(dstDir)
+		dstDirPostAttrs, _ := h.nfs().GetAttr(dstDir)
 		if dstDirPostAttrs == nil {
 			dstDirPostAttrs = dstDirPreAttrs
 		}
 
-		errCode := mapError(err)
+		errCode := MapErrorToNFSStatus(err)
 		var buf bytes.Buffer
 		xdrEncodeUint32(&buf, errCode)
-		if wccErr := encodeWccData(&buf, srcDirPreAttrs, srcDirPostAttrs); wccErr != nil {
+		if wccErr := encodeWccData(&buf, srcDirPreAttrs, srcDirPostAttrs, h.nfs().idMaps()); wccErr != nil {
 			return nfsErrorWithDoubleWcc(reply, errCode), nil
 		}
-		if wccErr := encodeWccData(&buf, dstDirPreAttrs, dstDirPostAttrs); wccErr != nil {
+		if wccErr := encodeWccData(&buf, dstDirPreAttrs, dstDirPostAttrs, h.nfs().idMaps()); wccErr != nil {
 			return nfsErrorWithDoubleWcc(reply, errCode), nil
 		}
 		reply.Data = buf.Bytes()
 		return reply, nil
 	}
 
-	srcDirPostAttrs, err := h.server.handler.GetAttr(srcDir)
+	srcDirPostAttrs, err := h.nfs().GetAttr(srcDir)
 	if err != nil {
-		return nfsErrorWithDoubleWcc(reply, mapError(err)), nil
+		return nfsErrorWithDoubleWcc(reply, handleErrorStatus(err)), nil
 	}
 
-	dstDirPostAttrs, err := h.server.handler.GetAttr(dstDir)
+	dstDirPostAttrs, err := h.nfs().GetAttr(dstDir)
 	if err != nil {
-		return nfsErrorWithDoubleWcc(reply, mapError(err)), nil
+		return nfsErrorWithDoubleWcc(reply, handleErrorStatus(err)), nil
 	}
 
 	var buf bytes.Buffer
 	xdrEncodeUint32(&buf, NFS_OK)
-	if err := encodeWccData(&buf, srcDirPreAttrs, srcDirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, srcDirPreAttrs, srcDirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithDoubleWcc(reply, NFSERR_IO), nil
 	}
-	if err := encodeWccData(&buf, dstDirPreAttrs, dstDirPostAttrs); err != nil {
+	if err := encodeWccData(&buf, dstDirPreAttrs, dstDirPostAttrs, h.nfs().idMaps()); err != nil {
 		return nfsErrorWithDoubleWcc(reply, NFSERR_IO), nil
 	}
 
@@ -311,18 +350,91 @@ func (h *NFSProcedureHandler) handleRename(body io.Reader, reply *RPCReply, auth
 	return reply, nil
 }
 
-// handleLink handles NFSPROC3_LINK - create hard link (not supported)
-// R2: LINK3resfail format is status + post_op_attr + wcc_data (not just wcc_data)
+// handleLink handles NFSPROC3_LINK - create a hard link, when the backing
+// filesystem implements Linker. LINK3res is status + post_op_attr for the
+// file + wcc_data for the directory, on success and failure alike.
 func (h *NFSProcedureHandler) handleLink(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
-	// Consume the LINK arguments to prevent stream desync
-	// LINK3args: nfs_fh3 file + diropargs3(nfs_fh3 dir + filename3 name)
-	xdrDecodeFileHandle(body) // file handle
-	xdrDecodeFileHandle(body) // dir handle
-	xdrDecodeString(body)     // name
+	fileHandleVal, err := xdrDecodeFileHandle(body)
+	if err != nil {
+		return nfsErrorWithPostOpAndWcc(reply, handleDecodeStatus(err)), nil
+	}
+	dirHandleVal, err := xdrDecodeFileHandle(body)
+	if err != nil {
+		return nfsErrorWithPostOpAndWcc(reply, handleDecodeStatus(err)), nil
+	}
+	name, err := xdrDecodeString(body)
+	if err != nil {
+		return nfsErrorWithPostOpAndWcc(reply, GARBAGE_ARGS), nil
+	}
+
+	if h.nfs().linker == nil {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_NOTSUPP), nil
+	}
+	if h.readOnly(authCtx) {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_ROFS), nil
+	}
+	if status := validateFilename(name); status != NFS_OK {
+		return nfsErrorWithPostOpAndWcc(reply, status), nil
+	}
 
-	notSupported := &NotSupportedError{
-		Operation: "LINK",
-		Reason:    "hard links are not supported by this NFS implementation",
+	// A hard link cannot cross from one export's filesystem to another's
+	if h.server.exportForHandle(fileHandleVal) != h.server.exportForHandle(dirHandleVal) {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_XDEV), nil
 	}
-	return nfsErrorWithPostOpAndWcc(reply, mapError(notSupported)), nil
+
+	file, ok := h.lookupNode(fileHandleVal)
+	if !ok {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_STALE), nil
+	}
+	dir, ok := h.lookupNode(dirHandleVal)
+	if !ok {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_STALE), nil
+	}
+
+	dir.mu.RLock()
+	isDir := dir.attrs.Mode&os.ModeDir != 0
+	dir.mu.RUnlock()
+	if !isDir {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_NOTDIR), nil
+	}
+
+	dirPreAttrs, err := h.nfs().GetAttr(dir)
+	if err != nil {
+		return nfsErrorWithPostOpAndWcc(reply, handleErrorStatus(err)), nil
+	}
+
+	status := uint32(NFS_OK)
+	dirPostAttrs := dirPreAttrs
+	if h.dryRun() {
+		h.logDryRun("LINK", LogField{Key: "path", Value: file.path},
+			LogField{Key: "to_dir", Value: dir.path}, LogField{Key: "to_name", Value: name})
+		post := *dirPreAttrs
+		post.SetMtime(time.Now())
+		dirPostAttrs = &post
+	} else {
+		if err := h.nfs().LinkWithContext(authCtx.callContext(), file, dir, name); err != nil {
+			if h.server.options.Debug {
+				h.server.logger.Printf("LINK: Failed to link '%s' as '%s' in '%s': %v", file.path, name, dir.path, err)
+			}
+			status = MapErrorToNFSStatus(err)
+		}
+		if attrs, err := h.nfs().GetAttr(dir); err == nil {
+			dirPostAttrs = attrs
+		}
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, status)
+	if fileAttrs, err := h.nfs().GetAttr(file); err == nil {
+		if err := encodePostOpAttr(&buf, fileAttrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorWithPostOpAndWcc(reply, NFSERR_IO), nil
+		}
+	} else {
+		xdrEncodeUint32(&buf, 0) // post_op_attr: attributes_follow = FALSE
+	}
+	if err := encodeWccData(&buf, dirPreAttrs, dirPostAttrs, h.nfs().idMaps()); err != nil {
+		return nfsErrorWithPostOpAndWcc(reply, NFSERR_IO), nil
+	}
+	reply.Data = buf.Bytes()
+	return reply, nil
 }
@@ -4,6 +4,8 @@
 // check bits as specified in RFC 1813.
 package absnfs
 
+import "strconv"
+
 // NFS status codes as defined in the NFS protocol
 const (
 	NFS_OK             = 0
@@ -13,6 +15,7 @@ const (
 	NFSERR_NXIO        = 6
 	NFSERR_ACCES       = 13
 	NFSERR_EXIST       = 17
+	NFSERR_XDEV        = 18
 	NFSERR_NODEV       = 19
 	NFSERR_NOTDIR      = 20
 	NFSERR_ISDIR       = 21
@@ -20,6 +23,7 @@ const (
 	NFSERR_FBIG        = 27
 	NFSERR_NOSPC       = 28
 	NFSERR_ROFS        = 30
+	NFSERR_MLINK       = 31
 	NFSERR_NAMETOOLONG = 63
 	NFSERR_NOTEMPTY    = 66
 	NFSERR_DQUOT       = 69
@@ -27,7 +31,10 @@ const (
 	NFSERR_WFLUSH      = 99
 	NFSERR_BADHANDLE   = 10001 // Invalid file handle
 	NFSERR_NOT_SYNC    = 10002 // Update synchronization mismatch (sattrguard3)
+	NFSERR_BAD_COOKIE  = 10003 // READDIR cookie does not name a position in the directory
 	NFSERR_NOTSUPP     = 10004 // Operation not supported
+	NFSERR_TOOSMALL    = 10005 // Buffer or request is too small
+	NFSERR_BADTYPE     = 10007 // Object type not supported by the server
 	NFSERR_JUKEBOX     = 10008 // Server busy, try again later (used during policy drain)
 	NFSERR_DELAY       = 10013 // Server is temporarily busy (rate limit exceeded)
 
@@ -35,6 +42,47 @@ const (
 	ACCESS_DENIED = NFSERR_ACCES
 )
 
+// nfsStatusNames maps NFS status codes to their names without the NFSERR_
+// prefix, as used for the status label of the operation metrics
+var nfsStatusNames = map[uint32]string{
+	NFS_OK:             "OK",
+	NFSERR_PERM:        "PERM",
+	NFSERR_NOENT:       "NOENT",
+	NFSERR_IO:          "IO",
+	NFSERR_NXIO:        "NXIO",
+	NFSERR_ACCES:       "ACCES",
+	NFSERR_EXIST:       "EXIST",
+	NFSERR_XDEV:        "XDEV",
+	NFSERR_NODEV:       "NODEV",
+	NFSERR_NOTDIR:      "NOTDIR",
+	NFSERR_ISDIR:       "ISDIR",
+	NFSERR_INVAL:       "INVAL",
+	NFSERR_FBIG:        "FBIG",
+	NFSERR_NOSPC:       "NOSPC",
+	NFSERR_ROFS:        "ROFS",
+	NFSERR_MLINK:       "MLINK",
+	NFSERR_NAMETOOLONG: "NAMETOOLONG",
+	NFSERR_NOTEMPTY:    "NOTEMPTY",
+	NFSERR_DQUOT:       "DQUOT",
+	NFSERR_STALE:       "STALE",
+	NFSERR_WFLUSH:      "WFLUSH",
+	NFSERR_BADHANDLE:   "BADHANDLE",
+	NFSERR_NOT_SYNC:    "NOT_SYNC",
+	NFSERR_BAD_COOKIE:  "BAD_COOKIE",
+	NFSERR_NOTSUPP:     "NOTSUPP",
+	NFSERR_BADTYPE:     "BADTYPE",
+	NFSERR_JUKEBOX:     "JUKEBOX",
+	NFSERR_DELAY:       "DELAY",
+}
+
+// nfsStatusName returns the name of status, or its number if it has none
+func nfsStatusName(status uint32) string {
+	if name, ok := nfsStatusNames[status]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(status), 10)
+}
+
 // NFS3 ACCESS check constants (RFC 1813, Section 2.6)
 const (
 	ACCESS3_READ    = 0x0001
@@ -44,3 +92,27 @@ const (
 	ACCESS3_DELETE  = 0x0010
 	ACCESS3_EXECUTE = 0x0020
 )
+
+// NFS3 FSINFO properties bits (RFC 1813, Section 3.3.19). The protocol
+// defines no read-only property; clients learn an export is read-only from
+// ACCESS replies that withhold MODIFY, EXTEND and DELETE, and from ROFS.
+const (
+	FSF3_LINK        = 0x0001
+	FSF3_SYMLINK     = 0x0002
+	FSF3_HOMOGENEOUS = 0x0008
+	FSF3_CANSETTIME  = 0x0010
+)
+
+// NFS3 WRITE stable_how values (RFC 1813, Section 3.3.7)
+const (
+	UNSTABLE  = 0
+	DATA_SYNC = 1
+	FILE_SYNC = 2
+)
+
+// NFS3 CREATE createmode3 values (RFC 1813, Section 3.3.8)
+const (
+	UNCHECKED = 0
+	GUARDED   = 1
+	EXCLUSIVE = 2
+)
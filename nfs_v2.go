@@ -0,0 +1,670 @@
+// nfs_v2.go: NFSv2 protocol support (RFC 1094).
+//
+// Serves NULL, GETATTR, LOOKUP, READ, WRITE, CREATE, REMOVE, and READDIR
+// over the version 2 wire format for legacy clients, on top of the same
+// AbsfsNFS operations as NFSv3. Version 2 differs from version 3 in its
+// fixed 32-byte file handles, its fattr layout with 32-bit sizes and
+// microsecond times, its 32-bit offsets and cookies, its status-only
+// error replies, and the 8KB cap on READ and WRITE data. Clients opt in
+// through ServerOptions.EnabledVersions.
+package absnfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	NFSV2_FHSIZE  = 32   // Size of an NFSv2 file handle
+	NFSV2_MAXDATA = 8192 // Most data a READ or WRITE may carry
+)
+
+// NFSv2 file types (ftype); sockets and named pipes have none, so they
+// are sent as NFNON with their type in the mode bits
+const (
+	NFNON = 0 // Non-file
+	NFREG = 1 // Regular file
+	NFDIR = 2 // Directory
+	NFBLK = 3 // Block device
+	NFCHR = 4 // Character device
+	NFLNK = 5 // Symbolic link
+)
+
+// nfsV2Handlers maps NFSv2 procedure numbers to their handler functions
+var nfsV2Handlers = map[uint32]nfsHandler{
+	NFSPROC2_NULL:    (*NFSProcedureHandler).handleNull,
+	NFSPROC2_GETATTR: (*NFSProcedureHandler).handleGetattrV2,
+	NFSPROC2_LOOKUP:  (*NFSProcedureHandler).handleLookupV2,
+	NFSPROC2_READ:    (*NFSProcedureHandler).handleReadV2,
+	NFSPROC2_WRITE:   (*NFSProcedureHandler).handleWriteV2,
+	NFSPROC2_CREATE:  (*NFSProcedureHandler).handleCreateV2,
+	NFSPROC2_REMOVE:  (*NFSProcedureHandler).handleRemoveV2,
+	NFSPROC2_READDIR: (*NFSProcedureHandler).handleReaddirV2,
+}
+
+// nfsV2ProcNames maps NFSv2 procedure numbers to their RFC 1094 names
+var nfsV2ProcNames = map[uint32]string{
+	NFSPROC2_NULL:    "NULL",
+	NFSPROC2_GETATTR: "GETATTR",
+	NFSPROC2_LOOKUP:  "LOOKUP",
+	NFSPROC2_READ:    "READ",
+	NFSPROC2_WRITE:   "WRITE",
+	NFSPROC2_CREATE:  "CREATE",
+	NFSPROC2_REMOVE:  "REMOVE",
+	NFSPROC2_READDIR: "READDIR",
+}
+
+// nfsProcName returns the name of the NFS procedure a call header names
+func nfsProcName(hdr RPCMsgHeader) string {
+	if hdr.Version == NFS_V2 {
+		return nfsV2ProcNames[hdr.Procedure]
+	}
+	return nfsProcNames[hdr.Procedure]
+}
+
+// xdrEncodeFileHandleV2 writes handle as an NFSv2 fhandle: the 8-byte
+// handle value followed by zero padding to 32 bytes
+func xdrEncodeFileHandleV2(w io.Writer, handle uint64) error {
+	var fh [NFSV2_FHSIZE]byte
+	binary.BigEndian.PutUint64(fh[:], handle)
+	_, err := w.Write(fh[:])
+	return err
+}
+
+// xdrDecodeFileHandleV2 reads an NFSv2 fhandle and returns the handle
+// value held in its first 8 bytes
+func xdrDecodeFileHandleV2(r io.Reader) (uint64, error) {
+	var fh [NFSV2_FHSIZE]byte
+	if _, err := io.ReadFull(r, fh[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(fh[:]), nil
+}
+
+// errV2Retry abandons an NFSv2 call that should be retried later
+var errV2Retry = errors.New("NFSv2 has no status to retry later; reply dropped")
+
+// v2Status converts an NFSv3 status to the nearest NFSv2 one. The errno
+// based codes are shared; the NFSv3-only codes above 10000 are not.
+// JUKEBOX and DELAY are kept for HandleCall, which drops such replies.
+func v2Status(status uint32) uint32 {
+	switch {
+	case status < 10000:
+		return status
+	case status == NFSERR_BADHANDLE:
+		return NFSERR_STALE
+	case status == NFSERR_JUKEBOX || status == NFSERR_DELAY:
+		return status
+	default:
+		return NFSERR_IO
+	}
+}
+
+// v2Retry reports whether reply asks the client to retry later. NFSv2 has
+// no status for that, so the reply is dropped instead and the client
+// retransmits the call after its timeout, as it would to a busy server.
+func v2Retry(reply *RPCReply) bool {
+	data, ok := reply.Data.([]byte)
+	if !ok || reply.Status != MSG_ACCEPTED || reply.AcceptStatus != SUCCESS || len(data) < 4 {
+		return false
+	}
+	status := binary.BigEndian.Uint32(data)
+	return status == NFSERR_JUKEBOX || status == NFSERR_DELAY
+}
+
+// nfsErrorV2 creates an NFSv2 error response, which carries only the status
+func nfsErrorV2(reply *RPCReply, status uint32) *RPCReply {
+	return nfsErrorReply(reply, v2Status(status))
+}
+
+// garbageArgsV2 rejects a call whose arguments do not decode
+func garbageArgsV2(reply *RPCReply) *RPCReply {
+	reply.AcceptStatus = GARBAGE_ARGS
+	return reply
+}
+
+// encodeFileAttributesV2 writes an NFSv2 fattr structure to an io.Writer
+// in XDR format. Per RFC 1094, fattr contains:
+//
+//	ftype     type       - file type
+//	unsigned  mode       - type and protection mode bits
+//	unsigned  nlink      - number of hard links
+//	unsigned  uid        - owner user id
+//	unsigned  gid        - owner group id
+//	unsigned  size       - file size in bytes
+//	unsigned  blocksize  - size of a block
+//	unsigned  rdev       - device number
+//	unsigned  blocks     - blocks used
+//	unsigned  fsid       - filesystem id
+//	unsigned  fileid     - file id (inode)
+//	timeval   atime      - access time (seconds, useconds)
+//	timeval   mtime      - modify time (seconds, useconds)
+//	timeval   ctime      - change time (seconds, useconds)
+//
+// Sizes beyond 32 bits are sent as the largest size that fits, and file
+// IDs are folded to 32 bits. The owner is translated through ids.
+func encodeFileAttributesV2(w io.Writer, attrs *NFSAttrs, ids *idMaps) error {
+	const blockSize = 512
+
+	var ftype, typeBits uint32
+	mode, _ := normalizeModeType(attrs.Mode)
+	switch mode & os.ModeType {
+	case os.ModeDir:
+		ftype, typeBits = NFDIR, 0040000
+	case os.ModeSymlink:
+		ftype, typeBits = NFLNK, 0120000
+	case os.ModeDevice:
+		ftype, typeBits = NFBLK, 0060000
+	case os.ModeDevice | os.ModeCharDevice:
+		ftype, typeBits = NFCHR, 0020000
+	case os.ModeSocket:
+		ftype, typeBits = NFNON, 0140000
+	case os.ModeNamedPipe:
+		ftype, typeBits = NFNON, 0010000
+	default:
+		ftype, typeBits = NFREG, 0100000
+	}
+
+	nlink := uint32(1)
+	if ftype == NFDIR {
+		nlink = 2
+	}
+	size := uint32(math.MaxUint32)
+	if attrs.Size < math.MaxUint32 {
+		size = uint32(attrs.Size)
+	}
+	blocks := (uint64(attrs.Size) + blockSize - 1) / blockSize
+	if blocks > math.MaxUint32 {
+		blocks = math.MaxUint32
+	}
+	// The classic 16-bit dev_t: 8 bits of major, 8 of minor
+	var rdev uint32
+	if ftype == NFBLK || ftype == NFCHR {
+		rdev = attrs.RdevMajor&0xff<<8 | attrs.RdevMinor&0xff
+	}
+	atime, mtime := attrs.Atime(), attrs.Mtime()
+	uid, gid := ids.toClient(attrs.Uid, attrs.Gid)
+
+	for _, v := range []uint32{
+		ftype,
+		typeBits | uint32(mode.Perm()),
+		nlink,
+		uid,
+		gid,
+		size,
+		blockSize,
+		rdev,
+		uint32(blocks),
+		0, // fsid
+		uint32(attrs.FileId ^ attrs.FileId>>32),
+		uint32(atime.Unix()), uint32(atime.Nanosecond() / 1000),
+		uint32(mtime.Unix()), uint32(mtime.Nanosecond() / 1000),
+		uint32(mtime.Unix()), uint32(mtime.Nanosecond() / 1000), // ctime - use mtime
+	} {
+		if err := xdrEncodeUint32(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attrstatV2 replies with NFS_OK and the attributes of node
+func (h *NFSProcedureHandler) attrstatV2(reply *RPCReply, node *NFSNode) (*RPCReply, error) {
+	attrs, err := h.nfs().GetAttr(node)
+	if err != nil {
+		return nfsErrorV2(reply, handleErrorStatus(err)), nil
+	}
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
+	if err := encodeFileAttributesV2(&buf, attrs, h.nfs().idMaps()); err != nil {
+		return nfsErrorV2(reply, NFSERR_IO), nil
+	}
+	reply.Data = buf.Bytes()
+	return reply, nil
+}
+
+// diropresV2 replies with NFS_OK, a handle for node and its attributes
+func (h *NFSProcedureHandler) diropresV2(reply *RPCReply, handle uint64, attrs *NFSAttrs) *RPCReply {
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
+	xdrEncodeFileHandleV2(&buf, handle)
+	if err := encodeFileAttributesV2(&buf, attrs, h.nfs().idMaps()); err != nil {
+		return nfsErrorV2(reply, NFSERR_IO)
+	}
+	reply.Data = buf.Bytes()
+	return reply
+}
+
+// decodeDiropargsV2 reads the directory handle and name that LOOKUP,
+// CREATE and REMOVE take
+func decodeDiropargsV2(body io.Reader) (uint64, string, error) {
+	handle, err := xdrDecodeFileHandleV2(body)
+	if err != nil {
+		return 0, "", err
+	}
+	name, err := xdrDecodeString(body)
+	if err != nil {
+		return 0, "", err
+	}
+	return handle, name, nil
+}
+
+// sattr2 holds the NFSv2 settable attributes; a field of all ones is not set
+type sattr2 struct {
+	Mode, UID, GID, Size uint32
+	AtimeSec, AtimeUsec  uint32
+	MtimeSec, MtimeUsec  uint32
+}
+
+// sattr2Unset marks an sattr2 field the client is not setting
+const sattr2Unset = math.MaxUint32
+
+// handleGetattrV2 handles NFSPROC_GETATTR - get file attributes
+func (h *NFSProcedureHandler) handleGetattrV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, err := xdrDecodeFileHandleV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	if h.metadataThrottled(authCtx) {
+		return nfsErrorV2(reply, NFSERR_JUKEBOX), nil
+	}
+
+	node, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+	return h.attrstatV2(reply, node)
+}
+
+// handleLookupV2 handles NFSPROC_LOOKUP - look up filename
+func (h *NFSProcedureHandler) handleLookupV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, name, err := decodeDiropargsV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	if status := validateFilename(name); status != NFS_OK {
+		return nfsErrorV2(reply, NFSERR_ACCES), nil
+	}
+
+	if h.metadataThrottled(authCtx) {
+		return nfsErrorV2(reply, NFSERR_JUKEBOX), nil
+	}
+
+	dir, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+
+	dir.mu.RLock()
+	isDir := dir.attrs.Mode&os.ModeDir != 0
+	dir.mu.RUnlock()
+	if !isDir {
+		return nfsErrorV2(reply, NFSERR_NOTDIR), nil
+	}
+
+	node, err := h.nfs().lookupChild(h.nfs().withSymlinkBudget(context.Background()), dir, name)
+	if err != nil {
+		return nfsErrorV2(reply, MapErrorToNFSStatus(err)), nil
+	}
+	handle := h.nfs().fileMap.Allocate(node)
+
+	node.mu.RLock()
+	attrs := *node.attrs
+	node.mu.RUnlock()
+	return h.diropresV2(reply, handle, &attrs), nil
+}
+
+// handleReadV2 handles NFSPROC_READ - read from file. Reads are capped at
+// NFSV2_MAXDATA bytes.
+func (h *NFSProcedureHandler) handleReadV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, err := xdrDecodeFileHandleV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	// offset, count, and the unused totalcount
+	var args [3]uint32
+	if err := binary.Read(body, binary.BigEndian, &args); err != nil {
+		return garbageArgsV2(reply), nil
+	}
+	offset, count := args[0], args[1]
+	if count > NFSV2_MAXDATA {
+		count = NFSV2_MAXDATA
+	}
+
+	if h.throttled(authCtx, OpTypeRead) {
+		return nfsErrorV2(reply, NFSERR_JUKEBOX), nil
+	}
+
+	node, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+
+	// A symlink is not readable as a file; clients must use READLINK
+	if isSymlinkNode(node) {
+		return nfsErrorV2(reply, NFSERR_INVAL), nil
+	}
+
+	if status := h.checkAccess(node, callerCredential(authCtx), accessRead); status != NFS_OK {
+		return nfsErrorV2(reply, status), nil
+	}
+
+	ctx := authCtx.callContext()
+	traceTransfer(ctx, handleVal, uint64(offset), count)
+	data, err := h.nfs().ReadWithContext(ctx, node, int64(offset), int64(count))
+	if err != nil {
+		return nfsErrorV2(reply, handleErrorStatus(err)), nil
+	}
+
+	attrs, err := h.nfs().GetAttr(node)
+	if err != nil {
+		return nfsErrorV2(reply, handleErrorStatus(err)), nil
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
+	if err := encodeFileAttributesV2(&buf, attrs, h.nfs().idMaps()); err != nil {
+		return nfsErrorV2(reply, NFSERR_IO), nil
+	}
+	xdrEncodeUint32(&buf, uint32(len(data)))
+	buf.Write(data)
+	if pad := (4 - len(data)%4) % 4; pad > 0 {
+		buf.Write(make([]byte, pad))
+	}
+	reply.Data = buf.Bytes()
+	return reply, nil
+}
+
+// handleWriteV2 handles NFSPROC_WRITE - write to file. NFSv2 writes are
+// synchronous, and carry at most NFSV2_MAXDATA bytes.
+func (h *NFSProcedureHandler) handleWriteV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, err := xdrDecodeFileHandleV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	// the unused beginoffset, offset, the unused totalcount, and the
+	// data length
+	var args [4]uint32
+	if err := binary.Read(body, binary.BigEndian, &args); err != nil {
+		return garbageArgsV2(reply), nil
+	}
+	offset, count := args[1], args[3]
+	if count > NFSV2_MAXDATA {
+		return garbageArgsV2(reply), nil
+	}
+	data := make([]byte, (count+3)&^3)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return garbageArgsV2(reply), nil
+	}
+	data = data[:count]
+
+	if h.readOnly(authCtx) {
+		return nfsErrorV2(reply, NFSERR_ROFS), nil
+	}
+
+	if h.throttled(authCtx, OpTypeWrite) {
+		return nfsErrorV2(reply, NFSERR_JUKEBOX), nil
+	}
+
+	node, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+
+	// Writing through a symlink handle would modify its target
+	if isSymlinkNode(node) {
+		return nfsErrorV2(reply, NFSERR_INVAL), nil
+	}
+
+	if status := h.checkAccess(node, callerCredential(authCtx), accessWrite); status != NFS_OK {
+		return nfsErrorV2(reply, status), nil
+	}
+
+	if h.dryRun() {
+		h.logDryRun("WRITE", LogField{Key: "path", Value: node.path},
+			LogField{Key: "offset", Value: offset}, LogField{Key: "count", Value: count})
+		attrs, err := h.nfs().GetAttr(node)
+		if err != nil {
+			return nfsErrorV2(reply, handleErrorStatus(err)), nil
+		}
+		postAttrs := *attrs
+		if end := int64(offset) + int64(count); end > postAttrs.Size {
+			postAttrs.Size = end
+		}
+		postAttrs.SetMtime(time.Now())
+		var buf bytes.Buffer
+		xdrEncodeUint32(&buf, NFS_OK)
+		if err := encodeFileAttributesV2(&buf, &postAttrs, h.nfs().idMaps()); err != nil {
+			return nfsErrorV2(reply, NFSERR_IO), nil
+		}
+		reply.Data = buf.Bytes()
+		return reply, nil
+	}
+
+	ctx := authCtx.callContext()
+	traceTransfer(ctx, handleVal, uint64(offset), count)
+	if _, err := h.nfs().WriteWithContext(ctx, node, int64(offset), data); err != nil {
+		return nfsErrorV2(reply, handleErrorStatus(err)), nil
+	}
+	return h.attrstatV2(reply, node)
+}
+
+// handleCreateV2 handles NFSPROC_CREATE - create a regular file. As with an
+// UNCHECKED NFSv3 CREATE, an existing file is opened, and truncated if the
+// attributes set a size.
+func (h *NFSProcedureHandler) handleCreateV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, name, err := decodeDiropargsV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+	var sattr sattr2
+	if err := binary.Read(body, binary.BigEndian, &sattr); err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	if h.readOnly(authCtx) {
+		return nfsErrorV2(reply, NFSERR_ROFS), nil
+	}
+	if status := validateFilename(name); status != NFS_OK {
+		return nfsErrorV2(reply, status), nil
+	}
+
+	// Clients may send the file type bits along with the permissions
+	mode := uint32(0644)
+	if sattr.Mode != sattr2Unset {
+		mode = sattr.Mode & 07777
+	}
+	// Only allow explicit UID/GID override if caller is root (not squashed)
+	newUID, newGID := authCtx.EffectiveUID, authCtx.EffectiveGID
+	if sattr.UID != sattr2Unset && authCtx.EffectiveUID == 0 {
+		newUID = h.nfs().mapUID(sattr.UID)
+	}
+	if sattr.GID != sattr2Unset && authCtx.EffectiveUID == 0 {
+		newGID = h.nfs().mapGID(sattr.GID)
+	}
+
+	dir, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+
+	attrs := &NFSAttrs{
+		Mode: os.FileMode(mode),
+		Uid:  newUID,
+		Gid:  newGID,
+	}
+
+	if h.dryRun() {
+		handle, newAttrs, err := h.dryRunNode("CREATE", dir, name, attrs)
+		if err != nil {
+			return nfsErrorV2(reply, MapErrorToNFSStatus(err)), nil
+		}
+		return h.diropresV2(reply, handle, newAttrs), nil
+	}
+
+	created := true
+	node, err := h.nfs().create(authCtx.callContext(), dir, name, attrs, true)
+	if errors.Is(err, os.ErrExist) {
+		created = false
+		existing := sattr3{SetSize: sattr.Size != sattr2Unset, Size: uint64(sattr.Size)}
+		node, err = h.createExisting(dir, name, UNCHECKED, existing, [8]byte{})
+	}
+	if err != nil {
+		return nfsErrorV2(reply, MapErrorToNFSStatus(err)), nil
+	}
+
+	// Apply the caller's effective identity as owner, as NFSv3 CREATE does
+	if created {
+		if err := h.nfs().fs.Chown(node.path, int(newUID), int(newGID)); err != nil {
+			if h.server.options.Debug {
+				h.server.logger.Printf("CREATE: Chown failed for '%s': %v", node.path, err)
+			}
+		}
+	}
+
+	handle := h.nfs().fileMap.Allocate(node)
+	node.mu.RLock()
+	nodeAttrs := *node.attrs
+	node.mu.RUnlock()
+	return h.diropresV2(reply, handle, &nodeAttrs), nil
+}
+
+// handleRemoveV2 handles NFSPROC_REMOVE - remove a file
+func (h *NFSProcedureHandler) handleRemoveV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, name, err := decodeDiropargsV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	if h.readOnly(authCtx) {
+		return nfsErrorV2(reply, NFSERR_ROFS), nil
+	}
+	if status := validateFilename(name); status != NFS_OK {
+		return nfsErrorV2(reply, status), nil
+	}
+
+	dir, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+
+	dir.mu.RLock()
+	isDir := dir.attrs.Mode&os.ModeDir != 0
+	dir.mu.RUnlock()
+	if !isDir {
+		return nfsErrorV2(reply, NFSERR_NOTDIR), nil
+	}
+
+	if status := h.checkAccess(dir, callerCredential(authCtx), accessWrite|accessExecute); status != NFS_OK {
+		return nfsErrorV2(reply, status), nil
+	}
+
+	if h.dryRun() {
+		h.logDryRun("REMOVE", LogField{Key: "dir", Value: dir.path}, LogField{Key: "name", Value: name})
+		return nfsErrorReply(reply, NFS_OK), nil
+	}
+
+	if err := h.nfs().RemoveWithContext(authCtx.callContext(), dir, name); err != nil {
+		return nfsErrorV2(reply, MapErrorToNFSStatus(err)), nil
+	}
+	return nfsErrorReply(reply, NFS_OK), nil
+}
+
+// handleReaddirV2 handles NFSPROC_READDIR - read directory entries. Cookies
+// are entry offsets, as in NFSv3, but 32 bits wide; NFSv2 has no cookie
+// verifier.
+func (h *NFSProcedureHandler) handleReaddirV2(body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	handleVal, err := xdrDecodeFileHandleV2(body)
+	if err != nil {
+		return garbageArgsV2(reply), nil
+	}
+
+	var cookie, count uint32
+	if err := binary.Read(body, binary.BigEndian, &cookie); err != nil {
+		return garbageArgsV2(reply), nil
+	}
+	if err := binary.Read(body, binary.BigEndian, &count); err != nil {
+		return garbageArgsV2(reply), nil
+	}
+	count = udpTransferCap(authCtx, count)
+
+	// Rate limiting (after body consumption to prevent stream desync)
+	if h.nfs().rateLimiter != nil && h.nfs().policy.Load().EnableRateLimiting {
+		if !h.nfs().rateLimiter.AllowOperation(authCtx.ClientIP, OpTypeReaddir) {
+			if h.nfs().metrics != nil {
+				h.nfs().metrics.RecordRateLimitExceeded()
+			}
+			return nfsErrorV2(reply, NFSERR_DELAY), nil
+		}
+	}
+
+	dir, ok := h.lookupNode(handleVal)
+	if !ok {
+		return nfsErrorV2(reply, NFSERR_STALE), nil
+	}
+
+	dir.mu.RLock()
+	dirMode := dir.attrs.Mode
+	dir.mu.RUnlock()
+	if dirMode&os.ModeDir == 0 {
+		return nfsErrorV2(reply, NFSERR_NOTDIR), nil
+	}
+
+	entries, err := h.nfs().ReadDir(dir)
+	if err != nil {
+		return nfsErrorV2(reply, handleErrorStatus(err)), nil
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeUint32(&buf, NFS_OK)
+
+	// Stop before an entry that would take the reply past count, leaving
+	// room for the list terminator and EOF flag; the first entry is always
+	// sent so the client makes progress
+	entryCount := 0
+	reachedLimit := false
+	for i := int(cookie); i < len(entries); i++ {
+		entry := entries[i]
+		entry.mu.RLock()
+		if entry.attrs == nil {
+			entry.mu.RUnlock()
+			continue
+		}
+		fileID := entry.attrs.FileId
+		entry.mu.RUnlock()
+
+		name := entry.Name()
+		size := 4 + 4 + 4 + (len(name)+3)&^3 + 4
+		if entryCount > 0 && buf.Len()+size+8 > int(count) {
+			reachedLimit = true
+			break
+		}
+
+		xdrEncodeUint32(&buf, 1)
+		xdrEncodeUint32(&buf, uint32(fileID^fileID>>32))
+		if err := xdrEncodeString(&buf, name); err != nil {
+			return nfsErrorV2(reply, NFSERR_IO), nil
+		}
+		xdrEncodeUint32(&buf, uint32(i+1))
+		entryCount++
+	}
+
+	xdrEncodeUint32(&buf, 0)
+	if reachedLimit {
+		xdrEncodeUint32(&buf, 0)
+	} else {
+		xdrEncodeUint32(&buf, 1) // EOF
+	}
+	reply.Data = buf.Bytes()
+	return reply, nil
+}
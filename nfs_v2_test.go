@@ -0,0 +1,307 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// callV2 dispatches an NFSv2 call through handleNFSCall
+func callV2(t *testing.T, handler *NFSProcedureHandler, auth *AuthContext, proc uint32, args []byte) *RPCReply {
+	t.Helper()
+	call := &RPCCall{Header: RPCMsgHeader{Program: NFS_PROGRAM, Version: NFS_V2, Procedure: proc}}
+	result, err := handler.handleNFSCall(call, bytes.NewReader(args), &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleNFSCall: %v", err)
+	}
+	return result
+}
+
+// fhandleV2 returns the 32-byte NFSv2 encoding of handle
+func fhandleV2(handle uint64) []byte {
+	var buf bytes.Buffer
+	xdrEncodeFileHandleV2(&buf, handle)
+	return buf.Bytes()
+}
+
+// fattrV2 is a decoded NFSv2 fattr
+type fattrV2 struct {
+	Type, Mode, Nlink, UID, GID, Size, BlockSize, Rdev, Blocks, Fsid, FileID uint32
+	AtimeSec, AtimeUsec, MtimeSec, MtimeUsec, CtimeSec, CtimeUsec            uint32
+}
+
+func decodeFattrV2(t *testing.T, data []byte) fattrV2 {
+	t.Helper()
+	var attr fattrV2
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &attr); err != nil {
+		t.Fatalf("decode fattr: %v", err)
+	}
+	return attr
+}
+
+func TestNFSv2VersionNegotiation(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	call := &RPCCall{Header: RPCMsgHeader{Program: NFS_PROGRAM, Version: NFS_V2, Procedure: NFSPROC2_NULL}}
+
+	result, _ := handler.handleNFSCall(call, bytes.NewReader(nil), &RPCReply{}, auth)
+	if result.AcceptStatus != PROG_MISMATCH {
+		t.Fatalf("v2 without opting in: expected PROG_MISMATCH, got %d", result.AcceptStatus)
+	}
+	if result.MismatchLow != NFS_V3 || result.MismatchHigh != NFS_V3 {
+		t.Errorf("Mismatch range = %d-%d, want 3-3", result.MismatchLow, result.MismatchHigh)
+	}
+
+	srv.options.EnabledVersions = []int{NFS_V2, NFS_V3}
+	result, _ = handler.handleNFSCall(call, bytes.NewReader(nil), &RPCReply{}, auth)
+	if result.AcceptStatus != SUCCESS {
+		t.Errorf("v2 NULL: expected SUCCESS, got %d", result.AcceptStatus)
+	}
+
+	call.Header.Version = 4
+	result, _ = handler.handleNFSCall(call, bytes.NewReader(nil), &RPCReply{}, auth)
+	if result.AcceptStatus != PROG_MISMATCH || result.MismatchLow != NFS_V2 || result.MismatchHigh != NFS_V3 {
+		t.Errorf("v4: got status %d range %d-%d, want PROG_MISMATCH 2-3",
+			result.AcceptStatus, result.MismatchLow, result.MismatchHigh)
+	}
+
+	// Procedures outside the v2 set are unavailable
+	result = callV2(t, handler, auth, 17, nil) // STATFS
+	if result.AcceptStatus != PROC_UNAVAIL {
+		t.Errorf("v2 STATFS: expected PROC_UNAVAIL, got %d", result.AcceptStatus)
+	}
+
+	if _, err := NewServer(ServerOptions{EnabledVersions: []int{4}}); err == nil {
+		t.Error("NewServer accepted NFS version 4")
+	}
+}
+
+func TestNFSv2DropsRetryReplies(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	srv.options.EnabledVersions = []int{NFS_V2, NFS_V3}
+	file := allocHandle(t, srv, "/dir/file.txt")
+
+	// A policy drain answers JUKEBOX, which NFSv2 cannot express
+	srv.handler.policyRWMu.Lock()
+	call := &RPCCall{Header: RPCMsgHeader{Program: NFS_PROGRAM, Version: NFS_V2, Procedure: NFSPROC2_GETATTR}}
+	reply, err := handler.HandleCall(call, bytes.NewReader(fhandleV2(file)), auth)
+	if !errors.Is(err, errV2Retry) || reply != nil {
+		t.Errorf("v2 GETATTR during a drain: got reply %v, err %v; want the reply dropped", reply, err)
+	}
+	call = &RPCCall{Header: RPCMsgHeader{Program: NFS_PROGRAM, Version: NFS_V3, Procedure: NFSPROC3_GETATTR}}
+	var args bytes.Buffer
+	xdrEncodeFileHandle(&args, file)
+	reply, err = handler.HandleCall(call, &args, auth)
+	srv.handler.policyRWMu.Unlock()
+	if err != nil || readStatusFromReply(reply) != NFSERR_JUKEBOX {
+		t.Errorf("v3 GETATTR during a drain: got err %v, want NFSERR_JUKEBOX", err)
+	}
+
+	if got := v2Status(NFSERR_DELAY); got != NFSERR_DELAY {
+		t.Errorf("v2Status(NFSERR_DELAY) = %d, want it kept for dropping", got)
+	}
+	if got := v2Status(NFSERR_NOTSUPP); got != NFSERR_IO {
+		t.Errorf("v2Status(NFSERR_NOTSUPP) = %d, want NFSERR_IO", got)
+	}
+}
+
+func TestMountV1ReturnsFixedHandle(t *testing.T) {
+	_, handler, auth := setupHandlerEnv(t)
+	var args bytes.Buffer
+	xdrEncodeString(&args, "/dir")
+	call := &RPCCall{Header: RPCMsgHeader{Program: MOUNT_PROGRAM, Version: MOUNT_V1, Procedure: 1}}
+	result, err := handler.handleMountCall(call, &args, &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleMountCall: %v", err)
+	}
+	data := result.Data.([]byte)
+	if len(data) != 4+NFSV2_FHSIZE {
+		t.Fatalf("fhstatus length = %d, want %d", len(data), 4+NFSV2_FHSIZE)
+	}
+	if status := binary.BigEndian.Uint32(data); status != 0 {
+		t.Fatalf("MNT status = %d, want 0", status)
+	}
+	handle := binary.BigEndian.Uint64(data[4:])
+	node, ok := handler.lookupNode(handle)
+	if !ok || node.path != "/dir" {
+		t.Errorf("MNT handle does not resolve to /dir")
+	}
+}
+
+func TestNFSv2Operations(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	srv.options.EnabledVersions = []int{NFS_V2}
+	dir := allocHandle(t, srv, "/dir")
+
+	// GETATTR encodes the v2 fattr layout
+	result := callV2(t, handler, auth, NFSPROC2_GETATTR, fhandleV2(allocHandle(t, srv, "/dir/file.txt")))
+	data := result.Data.([]byte)
+	if len(data) != 4+17*4 {
+		t.Fatalf("attrstat length = %d, want %d", len(data), 4+17*4)
+	}
+	if status := binary.BigEndian.Uint32(data); status != NFS_OK {
+		t.Fatalf("GETATTR: expected NFS_OK, got %d", status)
+	}
+	attr := decodeFattrV2(t, data[4:])
+	if attr.Type != NFREG || attr.Mode&0170000 != 0100000 || attr.Size != 5 || attr.Nlink != 1 {
+		t.Errorf("GETATTR: type %d mode %o size %d nlink %d, want regular file of 5 bytes",
+			attr.Type, attr.Mode, attr.Size, attr.Nlink)
+	}
+	if attr.MtimeUsec >= 1000000 {
+		t.Errorf("GETATTR: mtime useconds = %d, not microseconds", attr.MtimeUsec)
+	}
+
+	diropargs := func(name string) []byte {
+		var buf bytes.Buffer
+		buf.Write(fhandleV2(dir))
+		xdrEncodeString(&buf, name)
+		return buf.Bytes()
+	}
+
+	// CREATE returns a handle and the new file's attributes
+	var create bytes.Buffer
+	create.Write(diropargs("new.txt"))
+	binary.Write(&create, binary.BigEndian, sattr2{
+		Mode: 0100640, UID: sattr2Unset, GID: sattr2Unset, Size: sattr2Unset,
+		AtimeSec: sattr2Unset, AtimeUsec: sattr2Unset, MtimeSec: sattr2Unset, MtimeUsec: sattr2Unset,
+	})
+	data = callV2(t, handler, auth, NFSPROC2_CREATE, create.Bytes()).Data.([]byte)
+	if status := binary.BigEndian.Uint32(data); status != NFS_OK {
+		t.Fatalf("CREATE: expected NFS_OK, got %d", status)
+	}
+	newFile := binary.BigEndian.Uint64(data[4:])
+	if attr := decodeFattrV2(t, data[4+NFSV2_FHSIZE:]); attr.Mode&0777 != 0640 || attr.Size != 0 {
+		t.Errorf("CREATE: mode %o size %d, want 0640 and 0", attr.Mode, attr.Size)
+	}
+
+	// WRITE past 8KB is refused; within it, it returns the new attributes
+	write := func(offset uint32, payload []byte) *RPCReply {
+		var buf bytes.Buffer
+		buf.Write(fhandleV2(newFile))
+		binary.Write(&buf, binary.BigEndian, [3]uint32{0, offset, 0})
+		binary.Write(&buf, binary.BigEndian, uint32(len(payload)))
+		buf.Write(payload)
+		buf.Write(make([]byte, (4-len(payload)%4)%4))
+		return callV2(t, handler, auth, NFSPROC2_WRITE, buf.Bytes())
+	}
+	if result := write(0, make([]byte, NFSV2_MAXDATA+1)); result.AcceptStatus != GARBAGE_ARGS {
+		t.Errorf("WRITE of %d bytes: expected GARBAGE_ARGS, got %d", NFSV2_MAXDATA+1, result.AcceptStatus)
+	}
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	for off := 0; off < len(content); off += NFSV2_MAXDATA {
+		end := off + NFSV2_MAXDATA
+		if end > len(content) {
+			end = len(content)
+		}
+		data = write(uint32(off), content[off:end]).Data.([]byte)
+		if status := binary.BigEndian.Uint32(data); status != NFS_OK {
+			t.Fatalf("WRITE at %d: expected NFS_OK, got %d", off, status)
+		}
+	}
+	if attr := decodeFattrV2(t, data[4:]); attr.Size != uint32(len(content)) {
+		t.Errorf("WRITE: size %d, want %d", attr.Size, len(content))
+	}
+
+	// READ is capped at 8KB
+	var read bytes.Buffer
+	read.Write(fhandleV2(newFile))
+	binary.Write(&read, binary.BigEndian, [3]uint32{0, 65536, 0})
+	data = callV2(t, handler, auth, NFSPROC2_READ, read.Bytes()).Data.([]byte)
+	if status := binary.BigEndian.Uint32(data); status != NFS_OK {
+		t.Fatalf("READ: expected NFS_OK, got %d", status)
+	}
+	n := binary.BigEndian.Uint32(data[4+17*4:])
+	got := data[4+17*4+4:]
+	if n != NFSV2_MAXDATA || !bytes.Equal(got[:n], content[:NFSV2_MAXDATA]) {
+		t.Errorf("READ: got %d bytes, want the first %d of the file", n, NFSV2_MAXDATA)
+	}
+
+	// LOOKUP finds the file under the same handle
+	data = callV2(t, handler, auth, NFSPROC2_LOOKUP, diropargs("new.txt")).Data.([]byte)
+	if status := binary.BigEndian.Uint32(data); status != NFS_OK {
+		t.Fatalf("LOOKUP: expected NFS_OK, got %d", status)
+	}
+	if h := binary.BigEndian.Uint64(data[4:]); h != newFile {
+		t.Errorf("LOOKUP handle = %d, want %d", h, newFile)
+	}
+	data = callV2(t, handler, auth, NFSPROC2_LOOKUP, diropargs("missing")).Data.([]byte)
+	if len(data) != 4 || binary.BigEndian.Uint32(data) != NFSERR_NOENT {
+		t.Errorf("LOOKUP of a missing name: expected a bare NFSERR_NOENT, got % x", data)
+	}
+
+	// READDIR lists the entries with 32-bit cookies
+	readdir := func(cookie, count uint32) (names []string, cookies []uint32, eof bool) {
+		var buf bytes.Buffer
+		buf.Write(fhandleV2(dir))
+		binary.Write(&buf, binary.BigEndian, [2]uint32{cookie, count})
+		r := bytes.NewReader(callV2(t, handler, auth, NFSPROC2_READDIR, buf.Bytes()).Data.([]byte))
+		if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+			t.Fatalf("READDIR: expected NFS_OK, got %d", status)
+		}
+		for {
+			follows, _ := xdrDecodeUint32(r)
+			if follows == 0 {
+				break
+			}
+			xdrDecodeUint32(r) // fileid
+			name, _ := xdrDecodeString(r)
+			c, _ := xdrDecodeUint32(r)
+			names = append(names, name)
+			cookies = append(cookies, c)
+		}
+		more, _ := xdrDecodeUint32(r)
+		return names, cookies, more == 1
+	}
+	names, _, eof := readdir(0, 4096)
+	if len(names) != 3 || !eof {
+		t.Errorf("READDIR: got %v (eof %v), want file.txt, new.txt and sub", names, eof)
+	}
+	names, cookies, eof := readdir(0, 48)
+	if len(names) != 1 || eof {
+		t.Fatalf("READDIR with a small count: got %v (eof %v), want one entry and more to come", names, eof)
+	}
+	rest, _, eof := readdir(cookies[0], 4096)
+	if len(rest) != 2 || !eof {
+		t.Errorf("READDIR from cookie %d: got %v (eof %v), want the other two entries", cookies[0], rest, eof)
+	}
+
+	// REMOVE replies with a bare status
+	data = callV2(t, handler, auth, NFSPROC2_REMOVE, diropargs("new.txt")).Data.([]byte)
+	if len(data) != 4 || binary.BigEndian.Uint32(data) != NFS_OK {
+		t.Errorf("REMOVE: expected a bare NFS_OK, got % x", data)
+	}
+	if _, err := srv.handler.fs.Stat("/dir/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("REMOVE left the file behind: %v", err)
+	}
+
+	// Handles that do not resolve are stale
+	data = callV2(t, handler, auth, NFSPROC2_GETATTR, fhandleV2(999999)).Data.([]byte)
+	if binary.BigEndian.Uint32(data) != NFSERR_STALE {
+		t.Errorf("GETATTR of an unknown handle: expected NFSERR_STALE, got %d", binary.BigEndian.Uint32(data))
+	}
+}
+
+func TestEncodeFileAttributesV2(t *testing.T) {
+	mtime := time.Unix(1700000000, 123456789)
+	attrs := NewNFSAttrs(os.ModeDir|0755, 5<<30, mtime, mtime, 1000, 100)
+	attrs.FileId = 1<<32 | 7
+
+	var buf bytes.Buffer
+	if err := encodeFileAttributesV2(&buf, attrs, nil); err != nil {
+		t.Fatalf("encodeFileAttributesV2: %v", err)
+	}
+	attr := decodeFattrV2(t, buf.Bytes())
+	want := fattrV2{
+		Type: NFDIR, Mode: 0040755, Nlink: 2, UID: 1000, GID: 100,
+		Size: math.MaxUint32, BlockSize: 512, Blocks: 5 << 30 / 512, FileID: 6,
+		AtimeSec: 1700000000, AtimeUsec: 123456,
+		MtimeSec: 1700000000, MtimeUsec: 123456,
+		CtimeSec: 1700000000, CtimeUsec: 123456,
+	}
+	if attr != want {
+		t.Errorf("fattr = %+v, want %+v", attr, want)
+	}
+}
@@ -0,0 +1,618 @@
+// nlm.go: Network Lock Manager protocol, version 4 (XNFS, RFC 1813 era).
+//
+// With ExportOptions.EnableLocking, calls to program 100021 version 4 are
+// served next to NFS and MOUNT, giving clients fcntl/flock advisory locks.
+// Locks live in an in-memory table keyed by file handle and byte range and
+// are owned by the (caller name, owner handle, svid) triple the client
+// sends. A blocking LOCK that conflicts is queued and answered BLOCKED;
+// when the conflicting lock is released the lock is granted and the
+// client is told with an NLM_GRANTED call to the lock manager its
+// portmapper advertises. A grant whose callback cannot be delivered is
+// withdrawn and passed to the next waiter. Each export keeps its own table,
+// and calls are routed to it by the handle in their nlm4_lock. Locks are
+// not kept across restarts and there is no grace period for reclaims.
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NLM version 4 procedures served
+const (
+	NLMPROC4_NULL    = 0
+	NLMPROC4_TEST    = 1
+	NLMPROC4_LOCK    = 2
+	NLMPROC4_CANCEL  = 3
+	NLMPROC4_UNLOCK  = 4
+	NLMPROC4_GRANTED = 5
+)
+
+// nlm4_stats values
+const (
+	NLM4_GRANTED        = 0
+	NLM4_DENIED         = 1
+	NLM4_DENIED_NOLOCKS = 2
+	NLM4_BLOCKED        = 3
+	NLM4_STALE_FH       = 7
+	NLM4_FAILED         = 9
+)
+
+// nlmPortmapperPort is where GRANTED callbacks look up the client's lock
+// manager; a variable so tests can use an unprivileged portmapper
+var nlmPortmapperPort = PortmapperPort
+
+// nlmMaxNetobj bounds the opaque cookie, handle and owner fields
+// (LM_MAXSTRLEN)
+const nlmMaxNetobj = 1024
+
+// nlmCallbackTimeout bounds each step of a GRANTED callback
+const nlmCallbackTimeout = 5 * time.Second
+
+// nlmGrantedAttempts is how many times a GRANTED callback is tried before
+// the grant is withdrawn
+const nlmGrantedAttempts = 3
+
+// nlmGrantedRetry is the pause between GRANTED attempts; a variable so
+// tests need not wait
+var nlmGrantedRetry = time.Second
+
+// nlmMaxWaiting bounds the blocking requests queued on one export; a
+// blocking LOCK beyond it is answered DENIED_NOLOCKS
+const nlmMaxWaiting = 1024
+
+// nlm4Lock is the nlm4_lock argument describing a lock request
+type nlm4Lock struct {
+	callerName string
+	fh         string
+	oh         string
+	svid       int32
+	offset     uint64
+	length     uint64
+}
+
+// nlmOwner identifies the process holding a lock
+type nlmOwner struct {
+	caller string
+	oh     string
+	svid   int32
+}
+
+// nlmLock is a held byte-range lock covering [start, end)
+type nlmLock struct {
+	owner     nlmOwner
+	exclusive bool
+	start     uint64
+	end       uint64
+	alock     nlm4Lock
+}
+
+// nlmWaiter is a queued blocking LOCK request
+type nlmWaiter struct {
+	lock   nlmLock
+	client string
+	cookie string
+}
+
+// lockTable holds the NLM locks and blocked requests per file handle
+type lockTable struct {
+	mu       sync.Mutex
+	held     map[string][]nlmLock
+	waiting  map[string][]*nlmWaiter
+	nwaiting int // requests across all of waiting
+}
+
+// newNLMLock builds the table entry for a request. A length of zero
+// reaches to the end of the file, as does a range that would overflow.
+func newNLMLock(alock nlm4Lock, exclusive bool) nlmLock {
+	end := uint64(math.MaxUint64)
+	if alock.length != 0 && alock.offset <= math.MaxUint64-alock.length {
+		end = alock.offset + alock.length
+	}
+	return nlmLock{
+		owner:     nlmOwner{caller: alock.callerName, oh: alock.oh, svid: alock.svid},
+		exclusive: exclusive,
+		start:     alock.offset,
+		end:       end,
+		alock:     alock,
+	}
+}
+
+// conflicts reports whether l and o cannot be held at the same time
+func (l nlmLock) conflicts(o nlmLock) bool {
+	return l.owner != o.owner && (l.exclusive || o.exclusive) &&
+		l.start < o.end && o.start < l.end
+}
+
+// conflict returns a lock held on fh by another owner that l conflicts with
+func (t *lockTable) conflict(fh string, l nlmLock) (nlmLock, bool) {
+	for _, h := range t.held[fh] {
+		if l.conflicts(h) {
+			return h, true
+		}
+	}
+	return nlmLock{}, false
+}
+
+// test returns the lock that would stop l being granted, if any
+func (t *lockTable) test(fh string, l nlmLock) (nlmLock, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conflict(fh, l)
+}
+
+// lock grants l unless another owner holds a conflicting lock. On conflict
+// a blocking request is queued, once, and reported as blocked, unless the
+// queue is full. Granting
+// may downgrade the owner's own locks, so it returns any queued requests
+// that can now be granted as well.
+func (t *lockTable) lock(fh string, l nlmLock, block bool, client, cookie string) (status uint32, granted []*nlmWaiter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.conflict(fh, l); ok {
+		if !block {
+			return NLM4_DENIED, nil
+		}
+		for _, w := range t.waiting[fh] {
+			if w.lock.owner == l.owner && w.lock.start == l.start && w.lock.end == l.end {
+				w.lock, w.cookie = l, cookie
+				return NLM4_BLOCKED, nil
+			}
+		}
+		if t.nwaiting >= nlmMaxWaiting {
+			return NLM4_DENIED_NOLOCKS, nil
+		}
+		if t.waiting == nil {
+			t.waiting = make(map[string][]*nlmWaiter)
+		}
+		t.waiting[fh] = append(t.waiting[fh], &nlmWaiter{lock: l, client: client, cookie: cookie})
+		t.nwaiting++
+		return NLM4_BLOCKED, nil
+	}
+	t.release(fh, l.owner, l.start, l.end)
+	if t.held == nil {
+		t.held = make(map[string][]nlmLock)
+	}
+	t.held[fh] = append(t.held[fh], l)
+	return NLM4_GRANTED, t.wake(fh)
+}
+
+// unlock releases owner's locks on fh within [start, end) and returns the
+// queued requests that could then be granted
+func (t *lockTable) unlock(fh string, owner nlmOwner, start, end uint64) []*nlmWaiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.release(fh, owner, start, end)
+	return t.wake(fh)
+}
+
+// cancel drops owner's queued request for [start, end) on fh
+func (t *lockTable) cancel(fh string, owner nlmOwner, start, end uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	queue := t.waiting[fh]
+	for i, w := range queue {
+		if w.lock.owner == owner && w.lock.start == start && w.lock.end == end {
+			t.waiting[fh] = append(queue[:i:i], queue[i+1:]...)
+			if len(t.waiting[fh]) == 0 {
+				delete(t.waiting, fh)
+			}
+			t.nwaiting--
+			return true
+		}
+	}
+	return false
+}
+
+// revoke withdraws l, granted to a waiter whose client could not be told,
+// if it is still held unchanged, and returns the queued requests that
+// could then be granted
+func (t *lockTable) revoke(fh string, l nlmLock) []*nlmWaiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	held := t.held[fh]
+	for i, h := range held {
+		if h == l {
+			t.held[fh] = append(held[:i:i], held[i+1:]...)
+			if len(t.held[fh]) == 0 {
+				delete(t.held, fh)
+			}
+			return t.wake(fh)
+		}
+	}
+	return nil
+}
+
+// release removes [start, end) from owner's locks on fh, splitting a lock
+// that extends past either side. Callers hold t.mu.
+func (t *lockTable) release(fh string, owner nlmOwner, start, end uint64) {
+	var kept []nlmLock
+	for _, h := range t.held[fh] {
+		if h.owner != owner || h.end <= start || end <= h.start {
+			kept = append(kept, h)
+			continue
+		}
+		if h.start < start {
+			left := h
+			left.end = start
+			kept = append(kept, left)
+		}
+		if end < h.end {
+			right := h
+			right.start = end
+			kept = append(kept, right)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.held, fh)
+		return
+	}
+	t.held[fh] = kept
+}
+
+// wake grants queued requests on fh, oldest first, that no longer conflict
+// with a held lock. Callers hold t.mu.
+func (t *lockTable) wake(fh string) []*nlmWaiter {
+	var granted, still []*nlmWaiter
+	for _, w := range t.waiting[fh] {
+		if _, ok := t.conflict(fh, w.lock); ok {
+			still = append(still, w)
+			continue
+		}
+		t.release(fh, w.lock.owner, w.lock.start, w.lock.end)
+		if t.held == nil {
+			t.held = make(map[string][]nlmLock)
+		}
+		t.held[fh] = append(t.held[fh], w.lock)
+		granted = append(granted, w)
+	}
+	t.nwaiting -= len(granted)
+	if len(still) == 0 {
+		delete(t.waiting, fh)
+	} else {
+		t.waiting[fh] = still
+	}
+	return granted
+}
+
+// clear drops every lock and queued request
+func (t *lockTable) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.held, t.waiting, t.nwaiting = nil, nil, 0
+}
+
+// handleNLMCall serves the NLM program when EnableLocking is set on the
+// export the call was routed to
+func (h *NFSProcedureHandler) handleNLMCall(call *RPCCall, body io.Reader, reply *RPCReply, authCtx *AuthContext) (*RPCReply, error) {
+	if !h.nfs().tuning.Load().EnableLocking {
+		reply.AcceptStatus = PROG_UNAVAIL
+		return reply, nil
+	}
+	if call.Header.Version != NLM_V4 {
+		reply.AcceptStatus = PROG_MISMATCH
+		reply.MismatchLow, reply.MismatchHigh = NLM_V4, NLM_V4
+		return reply, nil
+	}
+
+	locks := &h.nfs().locks
+	switch call.Header.Procedure {
+	case NLMPROC4_NULL:
+		return reply, nil
+
+	case NLMPROC4_TEST:
+		// nlm4_testargs: cookie, exclusive, alock
+		cookie, err := decodeNetobj(body)
+		if err != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		exclusive, err1 := xdrDecodeUint32(body)
+		alock, err2 := decodeNLM4Lock(body)
+		if err1 != nil || err2 != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		var buf bytes.Buffer
+		xdrEncodeString(&buf, cookie)
+		if !h.nlmHandleValid(alock.fh) {
+			xdrEncodeUint32(&buf, NLM4_STALE_FH)
+			reply.Data = buf.Bytes()
+			return reply, nil
+		}
+		holder, denied := locks.test(alock.fh, newNLMLock(alock, exclusive != 0))
+		if !denied {
+			xdrEncodeUint32(&buf, NLM4_GRANTED)
+			reply.Data = buf.Bytes()
+			return reply, nil
+		}
+		// nlm4_holder: exclusive, svid, oh, l_offset, l_len
+		xdrEncodeUint32(&buf, NLM4_DENIED)
+		xdrEncodeUint32(&buf, boolToUint32(holder.exclusive))
+		xdrEncodeUint32(&buf, uint32(holder.owner.svid))
+		xdrEncodeString(&buf, holder.owner.oh)
+		xdrEncodeUint64(&buf, holder.start)
+		length := uint64(0)
+		if holder.end != math.MaxUint64 {
+			length = holder.end - holder.start
+		}
+		xdrEncodeUint64(&buf, length)
+		reply.Data = buf.Bytes()
+		return reply, nil
+
+	case NLMPROC4_LOCK:
+		// nlm4_lockargs: cookie, block, exclusive, alock, reclaim, state
+		cookie, err := decodeNetobj(body)
+		if err != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		block, err1 := xdrDecodeUint32(body)
+		exclusive, err2 := xdrDecodeUint32(body)
+		alock, err3 := decodeNLM4Lock(body)
+		_, err4 := xdrDecodeUint32(body)
+		_, err5 := xdrDecodeUint32(body)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		status := uint32(NLM4_STALE_FH)
+		if h.nlmHandleValid(alock.fh) {
+			var granted []*nlmWaiter
+			status, granted = locks.lock(alock.fh, newNLMLock(alock, exclusive != 0), block != 0, authCtx.ClientIP, cookie)
+			h.notifyGranted(granted)
+		}
+		return nlmRes(reply, cookie, status), nil
+
+	case NLMPROC4_CANCEL:
+		// nlm4_cancargs: cookie, block, exclusive, alock
+		cookie, err := decodeNetobj(body)
+		if err != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		_, err1 := xdrDecodeUint32(body)
+		exclusive, err2 := xdrDecodeUint32(body)
+		alock, err3 := decodeNLM4Lock(body)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		l := newNLMLock(alock, exclusive != 0)
+		status := uint32(NLM4_DENIED)
+		if locks.cancel(alock.fh, l.owner, l.start, l.end) {
+			status = NLM4_GRANTED
+		}
+		return nlmRes(reply, cookie, status), nil
+
+	case NLMPROC4_UNLOCK:
+		// nlm4_unlockargs: cookie, alock
+		cookie, err := decodeNetobj(body)
+		if err != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		alock, err := decodeNLM4Lock(body)
+		if err != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		l := newNLMLock(alock, false)
+		h.notifyGranted(locks.unlock(alock.fh, l.owner, l.start, l.end))
+		return nlmRes(reply, cookie, NLM4_GRANTED), nil
+
+	case NLMPROC4_GRANTED:
+		// nlm4_testargs: cookie, exclusive, alock. A GRANTED call tells a
+		// client its blocked request went through; this server never
+		// blocks on another lock manager, so there is nothing it matches.
+		cookie, err := decodeNetobj(body)
+		if err != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		_, err1 := xdrDecodeUint32(body)
+		_, err2 := decodeNLM4Lock(body)
+		if err1 != nil || err2 != nil {
+			return garbageArgsNLM(reply), nil
+		}
+		return nlmRes(reply, cookie, NLM4_DENIED), nil
+
+	default:
+		reply.AcceptStatus = PROC_UNAVAIL
+		return reply, nil
+	}
+}
+
+// nlmHandleValid reports whether fh is an NFSv3 handle for a live node
+func (h *NFSProcedureHandler) nlmHandleValid(fh string) bool {
+	if len(fh) != 8 {
+		return false
+	}
+	handle := binary.BigEndian.Uint64([]byte(fh))
+	_, ok := h.forHandle(handle).lookupNode(handle)
+	return ok
+}
+
+// notifyGranted sends GRANTED callbacks for queued requests that have
+// been granted, without holding up the reply to the call that freed them.
+// A client that cannot be reached after nlmGrantedAttempts tries would
+// never use the lock, so it is withdrawn and offered to the next waiter.
+func (h *NFSProcedureHandler) notifyGranted(granted []*nlmWaiter) {
+	for _, w := range granted {
+		go func(w *nlmWaiter) {
+			var err error
+			for attempt := 1; attempt <= nlmGrantedAttempts; attempt++ {
+				if err = sendNLMGranted(w); err == nil {
+					return
+				}
+				if attempt < nlmGrantedAttempts {
+					time.Sleep(nlmGrantedRetry)
+				}
+			}
+			if slog := h.nfs().getStructuredLogger(); slog != nil {
+				slog.Warn("NLM: GRANTED callback failed, lock withdrawn",
+					LogField{Key: "client", Value: w.client},
+					LogField{Key: "error", Value: err})
+			}
+			h.notifyGranted(h.nfs().locks.revoke(w.lock.alock.fh, w.lock))
+		}(w)
+	}
+}
+
+// decodeNetobj reads an XDR netobj, opaque data kept as raw bytes in a
+// string
+func decodeNetobj(r io.Reader) (string, error) {
+	length, err := xdrDecodeUint32(r)
+	if err != nil {
+		return "", err
+	}
+	if length > nlmMaxNetobj {
+		return "", fmt.Errorf("netobj length %d exceeds %d", length, nlmMaxNetobj)
+	}
+	buf := make([]byte, (length+3)&^3)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf[:length]), nil
+}
+
+// decodeNLM4Lock reads an nlm4_lock: caller_name, fh, oh, svid, l_offset,
+// l_len
+func decodeNLM4Lock(r io.Reader) (nlm4Lock, error) {
+	var l nlm4Lock
+	var err error
+	if l.callerName, err = decodeNetobj(r); err != nil {
+		return l, err
+	}
+	if l.fh, err = decodeNetobj(r); err != nil {
+		return l, err
+	}
+	if l.oh, err = decodeNetobj(r); err != nil {
+		return l, err
+	}
+	svid, err := xdrDecodeUint32(r)
+	if err != nil {
+		return l, err
+	}
+	l.svid = int32(svid)
+	if err := binary.Read(r, binary.BigEndian, &l.offset); err != nil {
+		return l, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &l.length); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// encodeNLM4Lock writes l as an nlm4_lock
+func encodeNLM4Lock(w io.Writer, l nlm4Lock) {
+	xdrEncodeString(w, l.callerName)
+	xdrEncodeString(w, l.fh)
+	xdrEncodeString(w, l.oh)
+	xdrEncodeUint32(w, uint32(l.svid))
+	xdrEncodeUint64(w, l.offset)
+	xdrEncodeUint64(w, l.length)
+}
+
+// nlmRes sets reply to an nlm4_res: the call's cookie and a status
+func nlmRes(reply *RPCReply, cookie string, status uint32) *RPCReply {
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, cookie)
+	xdrEncodeUint32(&buf, status)
+	reply.Data = buf.Bytes()
+	return reply
+}
+
+// garbageArgsNLM rejects a call whose arguments do not decode
+func garbageArgsNLM(reply *RPCReply) *RPCReply {
+	reply.AcceptStatus = GARBAGE_ARGS
+	return reply
+}
+
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nlmXID numbers the RPC calls this server makes for callbacks
+var nlmXID atomic.Uint32
+
+// sendNLMGranted tells the client that queued w that its lock is held.
+// The client's lock manager port comes from the portmapper on the client.
+func sendNLMGranted(w *nlmWaiter) error {
+	var args bytes.Buffer
+	xdrEncodeUint32(&args, NLM_PROGRAM)
+	xdrEncodeUint32(&args, NLM_V4)
+	xdrEncodeUint32(&args, IPPROTO_TCP)
+	xdrEncodeUint32(&args, 0)
+	res, err := callRPC(net.JoinHostPort(w.client, strconv.Itoa(nlmPortmapperPort)),
+		PortmapperProgram, PortmapperVersion, PMAPPROC_GETPORT, args.Bytes())
+	if err != nil {
+		return fmt.Errorf("portmapper: %w", err)
+	}
+	if len(res) < 4 || binary.BigEndian.Uint32(res) == 0 {
+		return fmt.Errorf("client has no NLM v4 service registered")
+	}
+	port := binary.BigEndian.Uint32(res)
+
+	// nlm4_testargs: cookie, exclusive, alock
+	args.Reset()
+	xdrEncodeString(&args, w.cookie)
+	xdrEncodeUint32(&args, boolToUint32(w.lock.exclusive))
+	encodeNLM4Lock(&args, w.lock.alock)
+	_, err = callRPC(net.JoinHostPort(w.client, strconv.Itoa(int(port))),
+		NLM_PROGRAM, NLM_V4, NLMPROC4_GRANTED, args.Bytes())
+	return err
+}
+
+// callRPC makes one AUTH_NONE call over TCP with record marking and returns
+// the results that follow a successful accepted reply
+func callRPC(addr string, prog, vers, proc uint32, args []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, nlmCallbackTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(nlmCallbackTimeout))
+
+	xid := nlmXID.Add(1)
+	var buf bytes.Buffer
+	for _, v := range []uint32{xid, RPC_CALL, 2, prog, vers, proc, AUTH_NONE, 0, AUTH_NONE, 0} {
+		xdrEncodeUint32(&buf, v)
+	}
+	buf.Write(args)
+	rm := NewRecordMarkingConn(conn, conn)
+	if err := rm.WriteRecord(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	data, err := rm.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	// xid, REPLY, MSG_ACCEPTED, verifier, accept_stat
+	r := bytes.NewReader(data)
+	var hdr [4]uint32
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != xid || hdr[1] != RPC_REPLY || hdr[2] != MSG_ACCEPTED {
+		return nil, fmt.Errorf("call rejected")
+	}
+	verfLen, err := xdrDecodeUint32(r)
+	if err != nil || verfLen > 400 {
+		return nil, fmt.Errorf("bad reply verifier")
+	}
+	if _, err := r.Seek(int64((verfLen+3)&^3), io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	status, err := xdrDecodeUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if status != SUCCESS {
+		return nil, fmt.Errorf("call not accepted: accept_stat %d", status)
+	}
+	return data[len(data)-r.Len():], nil
+}
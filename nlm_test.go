@@ -0,0 +1,339 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// nlmLockArg builds an nlm4_lock for owner svid on fh covering [offset, offset+length)
+func nlmLockArg(fh uint64, svid int32, offset, length uint64) nlm4Lock {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], fh)
+	return nlm4Lock{
+		callerName: "client",
+		fh:         string(h[:]),
+		oh:         "owner-" + strconv.Itoa(int(svid)),
+		svid:       svid,
+		offset:     offset,
+		length:     length,
+	}
+}
+
+// callNLM sends one NLM v4 call and returns the nlm4 status after the cookie,
+// with the reader positioned after it
+func callNLM(t *testing.T, handler *NFSProcedureHandler, auth *AuthContext, proc uint32, args []byte) (uint32, *bytes.Reader) {
+	t.Helper()
+	call := &RPCCall{Header: RPCMsgHeader{Program: NLM_PROGRAM, Version: NLM_V4, Procedure: proc}}
+	reply, err := handler.handleNLMCall(call, bytes.NewReader(args), &RPCReply{}, auth)
+	if err != nil {
+		t.Fatalf("handleNLMCall: %v", err)
+	}
+	if reply.AcceptStatus != SUCCESS {
+		t.Fatalf("accept status %d", reply.AcceptStatus)
+	}
+	r := bytes.NewReader(reply.Data.([]byte))
+	if cookie, err := decodeNetobj(r); err != nil || cookie != "ck" {
+		t.Fatalf("reply cookie %q, %v", cookie, err)
+	}
+	status, err := xdrDecodeUint32(r)
+	if err != nil {
+		t.Fatalf("reply status: %v", err)
+	}
+	return status, r
+}
+
+func lockNLM(t *testing.T, handler *NFSProcedureHandler, auth *AuthContext, l nlm4Lock, block, exclusive bool) uint32 {
+	t.Helper()
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, "ck")
+	xdrEncodeUint32(&buf, boolToUint32(block))
+	xdrEncodeUint32(&buf, boolToUint32(exclusive))
+	encodeNLM4Lock(&buf, l)
+	xdrEncodeUint32(&buf, 0) // reclaim
+	xdrEncodeUint32(&buf, 1) // state
+	status, _ := callNLM(t, handler, auth, NLMPROC4_LOCK, buf.Bytes())
+	return status
+}
+
+func unlockNLM(t *testing.T, handler *NFSProcedureHandler, auth *AuthContext, l nlm4Lock) {
+	t.Helper()
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, "ck")
+	encodeNLM4Lock(&buf, l)
+	if status, _ := callNLM(t, handler, auth, NLMPROC4_UNLOCK, buf.Bytes()); status != NLM4_GRANTED {
+		t.Fatalf("UNLOCK: status %d", status)
+	}
+}
+
+func TestNLMTestLockUnlock(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.EnableLocking = true })
+	fh := allocHandle(t, srv, "/dir/file.txt")
+
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 1, 0, 100), false, true); status != NLM4_GRANTED {
+		t.Fatalf("LOCK by owner 1: status %d", status)
+	}
+
+	// TEST by another owner reports the holder
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, "ck")
+	xdrEncodeUint32(&buf, 0) // shared
+	encodeNLM4Lock(&buf, nlmLockArg(fh, 2, 50, 10))
+	status, r := callNLM(t, handler, auth, NLMPROC4_TEST, buf.Bytes())
+	if status != NLM4_DENIED {
+		t.Fatalf("TEST: status %d, want DENIED", status)
+	}
+	var holder struct {
+		Exclusive, Svid uint32
+	}
+	binary.Read(r, binary.BigEndian, &holder)
+	oh, _ := decodeNetobj(r)
+	var offset, length uint64
+	binary.Read(r, binary.BigEndian, &offset)
+	binary.Read(r, binary.BigEndian, &length)
+	if holder.Exclusive != 1 || holder.Svid != 1 || oh != "owner-1" || offset != 0 || length != 100 {
+		t.Errorf("TEST holder = %+v %q %d+%d, want exclusive svid 1 owner-1 0+100", holder, oh, offset, length)
+	}
+
+	// A range past the held lock is free, an overlapping one is not
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 2, 100, 0), false, true); status != NLM4_GRANTED {
+		t.Errorf("LOCK of [100,EOF) by owner 2: status %d", status)
+	}
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 2, 50, 10), false, true); status != NLM4_DENIED {
+		t.Errorf("LOCK of [50,60) by owner 2: status %d, want DENIED", status)
+	}
+
+	// Unlocking the middle of owner 1's range frees only that part
+	unlockNLM(t, handler, auth, nlmLockArg(fh, 1, 40, 30))
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 2, 50, 10), false, true); status != NLM4_GRANTED {
+		t.Errorf("LOCK of [50,60) after partial unlock: status %d", status)
+	}
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 2, 30, 5), false, true); status != NLM4_DENIED {
+		t.Errorf("LOCK of [30,35) still held by owner 1: status %d, want DENIED", status)
+	}
+
+	// Shared locks coexist
+	other := allocHandle(t, srv, "/dir")
+	if status := lockNLM(t, handler, auth, nlmLockArg(other, 1, 0, 0), false, false); status != NLM4_GRANTED {
+		t.Errorf("shared LOCK by owner 1: status %d", status)
+	}
+	if status := lockNLM(t, handler, auth, nlmLockArg(other, 2, 0, 0), false, false); status != NLM4_GRANTED {
+		t.Errorf("shared LOCK by owner 2: status %d", status)
+	}
+
+	if status := lockNLM(t, handler, auth, nlmLockArg(99999, 1, 0, 0), false, true); status != NLM4_STALE_FH {
+		t.Errorf("LOCK on unknown handle: status %d, want STALE_FH", status)
+	}
+}
+
+func TestNLMBlockingLockGranted(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.EnableLocking = true })
+	fh := allocHandle(t, srv, "/dir/file.txt")
+
+	// The client's lock manager, registered with a portmapper on the client
+	nlmListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer nlmListener.Close()
+	pm := NewPortmapper()
+	if err := pm.StartOnPort(0); err != nil {
+		t.Fatalf("portmapper: %v", err)
+	}
+	defer pm.Stop()
+	pm.RegisterService(NLM_PROGRAM, NLM_V4, IPPROTO_TCP, uint32(nlmListener.Addr().(*net.TCPAddr).Port))
+	defer func(port int) { nlmPortmapperPort = port }(nlmPortmapperPort)
+	nlmPortmapperPort = pm.listener.Addr().(*net.TCPAddr).Port
+
+	granted := make(chan []byte, 1)
+	go func() {
+		conn, err := nlmListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rm := NewRecordMarkingConn(conn, conn)
+		data, err := rm.ReadRecord()
+		if err != nil {
+			return
+		}
+		granted <- data
+		var reply bytes.Buffer
+		for _, v := range []uint32{binary.BigEndian.Uint32(data), RPC_REPLY, MSG_ACCEPTED, 0, 0, SUCCESS} {
+			xdrEncodeUint32(&reply, v)
+		}
+		xdrEncodeString(&reply, "ck")
+		xdrEncodeUint32(&reply, NLM4_GRANTED)
+		rm.WriteRecord(reply.Bytes())
+	}()
+
+	holder := nlmLockArg(fh, 1, 0, 0)
+	if status := lockNLM(t, handler, auth, holder, false, true); status != NLM4_GRANTED {
+		t.Fatalf("LOCK by owner 1: status %d", status)
+	}
+	waiter := nlmLockArg(fh, 2, 10, 20)
+	if status := lockNLM(t, handler, auth, waiter, true, true); status != NLM4_BLOCKED {
+		t.Fatalf("blocking LOCK by owner 2: status %d, want BLOCKED", status)
+	}
+	select {
+	case <-granted:
+		t.Fatal("GRANTED sent while the lock was still held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlockNLM(t, handler, auth, holder)
+
+	var data []byte
+	select {
+	case data = <-granted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no GRANTED callback after the conflicting lock was released")
+	}
+	call, err := DecodeRPCCall(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode GRANTED call: %v", err)
+	}
+	if call.Header.Program != NLM_PROGRAM || call.Header.Version != NLM_V4 || call.Header.Procedure != NLMPROC4_GRANTED {
+		t.Fatalf("callback header = %+v, want NLM v4 GRANTED", call.Header)
+	}
+	// Skip the call header, credential and verifier: 10 words for AUTH_NONE
+	args := bytes.NewReader(data[40:])
+	cookie, _ := decodeNetobj(args)
+	exclusive, _ := xdrDecodeUint32(args)
+	alock, err := decodeNLM4Lock(args)
+	if err != nil || cookie != "ck" || exclusive != 1 || alock != waiter {
+		t.Errorf("GRANTED args = %q %d %+v (%v), want the queued lock %+v", cookie, exclusive, alock, err, waiter)
+	}
+
+	// Owner 2 now holds the range
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 1, 15, 1), false, true); status != NLM4_DENIED {
+		t.Errorf("LOCK inside the granted range: status %d, want DENIED", status)
+	}
+}
+
+func TestNLMCancelBlockedLock(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.EnableLocking = true })
+	fh := allocHandle(t, srv, "/dir/file.txt")
+
+	holder := nlmLockArg(fh, 1, 0, 0)
+	lockNLM(t, handler, auth, holder, false, true)
+	waiter := nlmLockArg(fh, 2, 0, 0)
+	if status := lockNLM(t, handler, auth, waiter, true, true); status != NLM4_BLOCKED {
+		t.Fatalf("blocking LOCK: status %d, want BLOCKED", status)
+	}
+
+	var buf bytes.Buffer
+	xdrEncodeString(&buf, "ck")
+	xdrEncodeUint32(&buf, 1)
+	xdrEncodeUint32(&buf, 1)
+	encodeNLM4Lock(&buf, waiter)
+	if status, _ := callNLM(t, handler, auth, NLMPROC4_CANCEL, buf.Bytes()); status != NLM4_GRANTED {
+		t.Fatalf("CANCEL: status %d", status)
+	}
+
+	// The cancelled request is not granted when the holder lets go
+	unlockNLM(t, handler, auth, holder)
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 3, 0, 0), false, true); status != NLM4_GRANTED {
+		t.Errorf("LOCK after cancel and unlock: status %d, want GRANTED", status)
+	}
+}
+
+func TestNLMDisabled(t *testing.T) {
+	_, handler, auth := setupHandlerEnv(t)
+	call := &RPCCall{Header: RPCMsgHeader{Program: NLM_PROGRAM, Version: NLM_V4, Procedure: NLMPROC4_NULL}}
+	reply, err := handler.HandleCall(call, bytes.NewReader(nil), auth)
+	if err != nil {
+		t.Fatalf("HandleCall: %v", err)
+	}
+	if reply.AcceptStatus != PROG_UNAVAIL {
+		t.Errorf("accept status %d, want PROG_UNAVAIL", reply.AcceptStatus)
+	}
+}
+
+func TestNLMGrantedCallbackFailure(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.EnableLocking = true })
+	fh := allocHandle(t, srv, "/dir/file.txt")
+
+	// Nothing listens where the client's portmapper should be
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l.Close()
+	defer func(port int) { nlmPortmapperPort = port }(nlmPortmapperPort)
+	nlmPortmapperPort = l.Addr().(*net.TCPAddr).Port
+	defer func(d time.Duration) { nlmGrantedRetry = d }(nlmGrantedRetry)
+	nlmGrantedRetry = time.Millisecond
+
+	holder := nlmLockArg(fh, 1, 0, 0)
+	lockNLM(t, handler, auth, holder, false, true)
+	if status := lockNLM(t, handler, auth, nlmLockArg(fh, 2, 0, 0), true, true); status != NLM4_BLOCKED {
+		t.Fatalf("blocking LOCK: status %d, want BLOCKED", status)
+	}
+	unlockNLM(t, handler, auth, holder)
+
+	// The grant nobody could be told of is withdrawn
+	deadline := time.Now().Add(5 * time.Second)
+	for lockNLM(t, handler, auth, nlmLockArg(fh, 3, 0, 0), false, true) != NLM4_GRANTED {
+		if time.Now().After(deadline) {
+			t.Fatal("lock granted to an unreachable client was never withdrawn")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNLMRevokeWakesNextWaiter(t *testing.T) {
+	var table lockTable
+	fh := nlmLockArg(1, 0, 0, 0).fh
+	first := newNLMLock(nlmLockArg(1, 1, 0, 0), true)
+	second := newNLMLock(nlmLockArg(1, 2, 0, 0), true)
+	third := newNLMLock(nlmLockArg(1, 3, 0, 0), true)
+
+	table.lock(fh, first, false, "a", "")
+	table.lock(fh, second, true, "b", "")
+	table.lock(fh, third, true, "c", "")
+	granted := table.unlock(fh, first.owner, first.start, first.end)
+	if len(granted) != 1 || granted[0].lock != second {
+		t.Fatalf("unlock granted %v, want the second owner", granted)
+	}
+
+	// Withdrawing the second owner's grant passes the lock to the third
+	granted = table.revoke(fh, second)
+	if len(granted) != 1 || granted[0].lock != third {
+		t.Fatalf("revoke granted %v, want the third owner", granted)
+	}
+	if table.nwaiting != 0 {
+		t.Errorf("%d requests still counted as waiting, want 0", table.nwaiting)
+	}
+	// A lock no longer held as granted is left alone
+	if granted := table.revoke(fh, second); granted != nil {
+		t.Errorf("second revoke granted %v", granted)
+	}
+	if _, ok := table.test(fh, newNLMLock(nlmLockArg(1, 4, 0, 0), true)); !ok {
+		t.Error("third owner's lock was dropped")
+	}
+}
+
+func TestNLMWaitingQueueBounded(t *testing.T) {
+	var table lockTable
+	fh := nlmLockArg(1, 0, 0, 0).fh
+	table.lock(fh, newNLMLock(nlmLockArg(1, 0, 0, 0), true), false, "a", "")
+	for i := 1; i <= nlmMaxWaiting; i++ {
+		if status, _ := table.lock(fh, newNLMLock(nlmLockArg(1, int32(i), 0, 0), true), true, "b", ""); status != NLM4_BLOCKED {
+			t.Fatalf("blocking LOCK %d: status %d, want BLOCKED", i, status)
+		}
+	}
+	over := newNLMLock(nlmLockArg(1, nlmMaxWaiting+1, 0, 0), true)
+	if status, _ := table.lock(fh, over, true, "b", ""); status != NLM4_DENIED_NOLOCKS {
+		t.Fatalf("blocking LOCK past the cap: status %d, want DENIED_NOLOCKS", status)
+	}
+	// Cancelling one makes room again
+	table.cancel(fh, newNLMLock(nlmLockArg(1, 1, 0, 0), true).owner, 0, math.MaxUint64)
+	if status, _ := table.lock(fh, over, true, "b", ""); status != NLM4_BLOCKED {
+		t.Errorf("blocking LOCK after a cancel: status %d, want BLOCKED", status)
+	}
+}
@@ -2,8 +2,8 @@
 //
 // Contains ReadFile, WriteFile, CreateFile, RemoveFile, MakeDirectory,
 // RemoveDirectory, Rename, Symlink, and related helpers. Translates NFS
-// request semantics into absfs.SymlinkFileSystem calls, handling path resolution,
-// striped locking, and error mapping.
+// request semantics into absfs.SymlinkFileSystem calls, handling path resolution
+// and striped locking.
 package absnfs
 
 import (
@@ -14,8 +14,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"syscall"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/absfs/absfs"
@@ -24,44 +25,6 @@ import (
 // ErrTimeout is returned when an operation times out
 var ErrTimeout = errors.New("operation timed out")
 
-// mapError converts absfs errors to NFS status codes
-func mapError(err error) uint32 {
-	// Check custom errors first
-	var invalidHandle *InvalidFileHandleError
-	var notSupported *NotSupportedError
-
-	switch {
-	case err == nil:
-		return NFS_OK
-	case errors.As(err, &invalidHandle):
-		return NFSERR_BADHANDLE
-	case errors.As(err, &notSupported):
-		return NFSERR_NOTSUPP
-	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout):
-		return NFSERR_DELAY
-	case errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOENT):
-		return NFSERR_NOENT
-	case errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM):
-		return NFSERR_PERM
-	case errors.Is(err, os.ErrExist) || errors.Is(err, syscall.EEXIST):
-		return NFSERR_EXIST
-	case errors.Is(err, os.ErrInvalid):
-		return NFSERR_INVAL
-	case errors.Is(err, syscall.ENOTDIR):
-		return NFSERR_NOTDIR
-	case errors.Is(err, syscall.EISDIR):
-		return NFSERR_ISDIR
-	case errors.Is(err, syscall.ENOSPC):
-		return NFSERR_NOSPC
-	case errors.Is(err, syscall.EFBIG):
-		return NFSERR_FBIG
-	case errors.Is(err, syscall.ENAMETOOLONG):
-		return NFSERR_NAMETOOLONG
-	default:
-		return NFSERR_IO
-	}
-}
-
 // sanitizePath validates and sanitizes a path to prevent directory traversal attacks.
 // It ensures the resulting path is within the base directory and rejects paths containing ".." components.
 func sanitizePath(basePath, name string) (string, error) {
@@ -107,6 +70,16 @@ func sanitizePath(basePath, name string) (string, error) {
 	return cleanPathSlash, nil
 }
 
+// childPath builds the backing path for name within dirPath and confines
+// it to the export root
+func (s *AbsfsNFS) childPath(dirPath, name string) (string, error) {
+	p, err := sanitizePath(dirPath, name)
+	if err != nil {
+		return "", err
+	}
+	return s.confineToRoot(p)
+}
+
 // Lookup implements the LOOKUP operation
 func (s *AbsfsNFS) Lookup(path string) (*NFSNode, error) {
 	return s.LookupWithContext(context.Background(), path)
@@ -117,6 +90,10 @@ func (s *AbsfsNFS) LookupWithContext(ctx context.Context, path string) (*NFSNode
 	if path == "" {
 		return nil, fmt.Errorf("empty path")
 	}
+	path, err := s.confine(path, false, symlinkBudgetFrom(ctx))
+	if err != nil {
+		return nil, opError("lookup", path, err)
+	}
 
 	tuning := s.tuning.Load()
 
@@ -147,10 +124,10 @@ func (s *AbsfsNFS) LookupWithContext(ctx context.Context, path string) (*NFSNode
 	}
 
 	// Check cache first (including negative cache)
-	if attrs, found := s.attrCache.Get(path, s); found {
+	if attrs, found := s.cachedAttrs(path, tuning.CacheConsistency); found {
 		if attrs == nil {
 			// Negative cache hit: path confirmed non-existent
-			return nil, fmt.Errorf("lookup: failed to stat %s: %w", path, os.ErrNotExist)
+			return nil, opError("lookup", path, os.ErrNotExist)
 		}
 		node := &NFSNode{
 			SymlinkFileSystem: s.fs,
@@ -163,9 +140,72 @@ func (s *AbsfsNFS) LookupWithContext(ctx context.Context, path string) (*NFSNode
 		return node, nil
 	}
 
+	// Concurrent lookups of the same uncached path share one Lstat
+	shared, err := s.lookups.do(ctx, path, func() (interface{}, error) {
+		return s.resolveLookup(path)
+	})
+	if err != nil {
+		if ctx.Err() != nil && err == ctx.Err() {
+			if s.metrics != nil {
+				s.metrics.RecordTimeout("LOOKUP")
+			}
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+
+	// Each caller gets its own copy of the attributes, since nodes update
+	// them under their own lock
+	attrs := *shared.(*NFSAttrs)
+	node := &NFSNode{
+		SymlinkFileSystem: s.fs,
+		path:              path,
+		attrs:             &attrs,
+	}
+	if attrs.Mode&os.ModeDir != 0 {
+		node.children = make(map[string]*NFSNode)
+	}
+	return node, nil
+}
+
+// cachedAttrs returns the attribute cache's entry for path, as far as the
+// CacheConsistency mode trusts it: under "strict" never, and under
+// "close-to-open" only a positive entry whose mtime and size still match
+// the backing file's. An entry that no longer matches is dropped, with the
+// directory listing cached for path.
+func (s *AbsfsNFS) cachedAttrs(path, consistency string) (*NFSAttrs, bool) {
+	switch consistency {
+	case CacheConsistencyStrict:
+		return nil, false
+	case CacheConsistencyCloseToOpen:
+		attrs, found := s.attrCache.Get(path)
+		if !found || attrs == nil {
+			return nil, false
+		}
+		fsStart := time.Now()
+		info, err := s.fs.Lstat(path)
+		s.RecordFSLatency("LSTAT", time.Since(fsStart))
+		if err != nil || info.Size() != attrs.Size || !s.fileModTime(path, info).Equal(attrs.Mtime()) {
+			s.attrCache.Invalidate(path)
+			if s.dirCache != nil {
+				s.dirCache.Invalidate(path)
+			}
+			return nil, false
+		}
+		s.RecordAttrCacheHit()
+		return attrs, true
+	}
+	return s.attrCache.Get(path, s)
+}
+
+// resolveLookup stats path on the backing filesystem and caches the result,
+// negatively if the path does not exist
+func (s *AbsfsNFS) resolveLookup(path string) (*NFSAttrs, error) {
 	// Use Lstat to get symlink info without following
 	// The filesystem now implements absfs.SymlinkFileSystem which has Lstat
+	fsStart := time.Now()
 	info, err := s.fs.Lstat(path)
+	s.RecordFSLatency("LSTAT", time.Since(fsStart))
 
 	if err != nil {
 		// Store negative cache entry if enabled and error is "not found"
@@ -173,36 +213,28 @@ func (s *AbsfsNFS) LookupWithContext(ctx context.Context, path string) (*NFSNode
 			s.attrCache.PutNegative(path)
 			s.RecordNegativeCacheMiss()
 		}
-		return nil, fmt.Errorf("lookup: failed to stat %s: %w", path, err)
+		return nil, opError("lookup", path, err)
 	}
+	s.noteIdentity(path, info)
 
-	modTime := info.ModTime()
+	modTime := s.fileModTime(path, info)
 	h := fnv.New64a()
 	h.Write([]byte(path))
 	attrs := &NFSAttrs{
-		Mode:   info.Mode(),
+		Mode:   s.fileMode(path, info),
 		Size:   info.Size(),
 		FileId: h.Sum64(),
 		Uid:    0,
 		Gid:    0,
 	}
+	attrs.RdevMajor, attrs.RdevMinor = deviceNumbers(info)
 	attrs.SetMtime(modTime)
 	attrs.SetAtime(modTime)
 	attrs.Refresh() // Initialize cache validity
 
-	node := &NFSNode{
-		SymlinkFileSystem: s.fs,
-		path:              path,
-		attrs:             attrs,
-	}
-
-	if info.IsDir() {
-		node.children = make(map[string]*NFSNode)
-	}
-
 	// Cache the attributes
 	s.attrCache.Put(path, attrs)
-	return node, nil
+	return attrs, nil
 }
 
 // GetAttr implements the GETATTR operation
@@ -210,19 +242,31 @@ func (s *AbsfsNFS) GetAttr(node *NFSNode) (*NFSAttrs, error) {
 	if node == nil {
 		return nil, fmt.Errorf("nil node")
 	}
+	if node.shard != "" {
+		return s.shardAttrs(node)
+	}
+
+	if _, err := s.confineToRoot(node.path); err != nil {
+		return nil, opError("getattr", node.path, err)
+	}
 
-	// Check cache first
-	if attrs, found := s.attrCache.Get(node.path, s); found && attrs != nil && attrs.IsValid() {
-		return attrs, nil
+	// Check cache first, unless the mode says always to stat
+	if s.tuning.Load().CacheConsistency != CacheConsistencyStrict {
+		if attrs, found := s.attrCache.Get(node.path, s); found && attrs != nil && attrs.IsValid() {
+			return attrs, nil
+		}
 	}
 
 	// Get fresh attributes using Lstat (to handle symlinks properly)
 	// The filesystem implements absfs.SymlinkFileSystem which has Lstat
+	fsStart := time.Now()
 	info, err := s.fs.Lstat(node.path)
+	s.RecordFSLatency("LSTAT", time.Since(fsStart))
 
 	if err != nil {
-		return nil, fmt.Errorf("getattr: failed to stat %s: %w", node.path, err)
+		return nil, opError("getattr", node.path, err)
 	}
+	s.noteIdentity(node.path, info)
 
 	// Read Uid/Gid from node.attrs with lock protection
 	var uid, gid uint32
@@ -233,16 +277,17 @@ func (s *AbsfsNFS) GetAttr(node *NFSNode) (*NFSAttrs, error) {
 	}
 	node.mu.RUnlock()
 
-	modTime := info.ModTime()
+	modTime := s.fileModTime(node.path, info)
 	h := fnv.New64a()
 	h.Write([]byte(node.path))
 	attrs := &NFSAttrs{
-		Mode:   info.Mode(),
+		Mode:   s.fileMode(node.path, info),
 		Size:   info.Size(),
 		FileId: h.Sum64(),
 		Uid:    uid,
 		Gid:    gid,
 	}
+	attrs.RdevMajor, attrs.RdevMinor = deviceNumbers(info)
 	attrs.SetMtime(modTime)
 	attrs.SetAtime(modTime)
 	attrs.Refresh() // Initialize cache validity
@@ -261,10 +306,14 @@ func (s *AbsfsNFS) SetAttr(node *NFSNode, attrs *NFSAttrs) error {
 		return fmt.Errorf("nil attrs")
 	}
 
+	if _, err := s.confineFollowing(node.path); err != nil {
+		return opError("setattr", node.path, err)
+	}
+
 	// Check if file exists first
 	_, err := s.fs.Stat(node.path)
 	if err != nil {
-		return fmt.Errorf("setattr: %w", err)
+		return opError("setattr", node.path, err)
 	}
 
 	// Read current attrs with lock protection to compare
@@ -278,20 +327,20 @@ func (s *AbsfsNFS) SetAttr(node *NFSNode, attrs *NFSAttrs) error {
 
 	if attrs.Mode&os.ModePerm != currentMode&os.ModePerm {
 		if err := s.fs.Chmod(node.path, attrs.Mode&os.ModePerm); err != nil {
-			return fmt.Errorf("setattr: chmod failed: %w", err)
+			return opError("setattr", node.path, err)
 		}
 	}
 
 	if attrs.Uid != currentUid || attrs.Gid != currentGid {
 		if err := s.fs.Chown(node.path, int(attrs.Uid), int(attrs.Gid)); err != nil {
-			return fmt.Errorf("setattr: chown failed: %w", err)
+			return opError("setattr", node.path, err)
 		}
 	}
 
 	if (!attrs.Atime().IsZero() || !attrs.Mtime().IsZero()) &&
 		(attrs.Mtime() != currentMtime || attrs.Atime() != currentAtime) {
 		if err := s.fs.Chtimes(node.path, attrs.Atime(), attrs.Mtime()); err != nil {
-			return fmt.Errorf("setattr: chtimes failed: %w", err)
+			return opError("setattr", node.path, err)
 		}
 	}
 
@@ -353,22 +402,32 @@ func (s *AbsfsNFS) ReadWithContext(ctx context.Context, node *NFSNode, offset in
 	default:
 	}
 
-	// Limit the read size to TransferSize if it exceeds the configured limit
-	if count > int64(tuning.TransferSize) {
-		count = int64(tuning.TransferSize)
+	// Limit the read size to the rtmax FSINFO advertises
+	if count > int64(tuning.MaxReadSize) {
+		count = int64(tuning.MaxReadSize)
+	}
+
+	if _, err := s.confine(node.path, true, symlinkBudgetFrom(ctx)); err != nil {
+		return nil, opError("read", node.path, err)
+	}
+
+	// A zero-length read needs nothing from the file; the caller derives
+	// eof from the cached size
+	if count == 0 {
+		return []byte{}, nil
 	}
 
 	// Standard read path
 	f, err := s.fs.OpenFile(node.path, os.O_RDONLY, 0)
 	if err != nil {
-		return nil, fmt.Errorf("read: failed to open %s: %w", node.path, err)
+		return nil, opError("read", node.path, err)
 	}
 	defer f.Close()
 
 	// Get file size
 	info, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("read: failed to stat %s: %w", node.path, err)
+		return nil, opError("read", node.path, err)
 	}
 
 	// Adjust count if it would read beyond EOF
@@ -382,14 +441,60 @@ func (s *AbsfsNFS) ReadWithContext(ctx context.Context, node *NFSNode, offset in
 
 	// Read the adjusted amount
 	buf := make([]byte, count)
-	n, err := f.ReadAt(buf, offset)
+	fsStart := time.Now()
+	span := s.startFSSpan(ctx, "fs.ReadAt", node.path, offset, len(buf))
+	n, err := readAtRetryEINTR(f, buf, offset)
+	endFSSpan(span, n, err)
+	s.RecordFSLatency("READ", time.Since(fsStart))
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("read: failed to read from %s at offset %d: %w", node.path, offset, err)
+		return nil, opError("read", node.path, fmt.Errorf("at offset %d: %w", offset, err))
+	}
+	s.adviseRead(node, offset, n)
+
+	// Data failing the backend's checksums gets one re-read
+	if s.checksummer != nil {
+		return s.verifyRead(s.checksummer, f, node.path, buf[:n], offset)
 	}
 
 	return buf[:n], nil
 }
 
+// maxEINTRRetries bounds how many consecutive EINTRs a single backing
+// ReadAt/WriteAt may return before the error is surfaced
+const maxEINTRRetries = 16
+
+// readAtRetryEINTR calls f.ReadAt, resuming immediately when the backing
+// filesystem reports EINTR. An interrupted call may already have read part
+// of buf, so the retry continues after those bytes.
+func readAtRetryEINTR(f absfs.File, buf []byte, off int64) (int, error) {
+	total := 0
+	for retries := 0; ; retries++ {
+		n, err := f.ReadAt(buf[total:], off+int64(total))
+		total += n
+		if !errors.Is(err, syscall.EINTR) || retries == maxEINTRRetries {
+			return total, err
+		}
+		if total == len(buf) {
+			return total, nil
+		}
+	}
+}
+
+// writeAtRetryEINTR is the WriteAt counterpart of readAtRetryEINTR
+func writeAtRetryEINTR(f absfs.File, data []byte, off int64) (int, error) {
+	total := 0
+	for retries := 0; ; retries++ {
+		n, err := f.WriteAt(data[total:], off+int64(total))
+		total += n
+		if !errors.Is(err, syscall.EINTR) || retries == maxEINTRRetries {
+			return total, err
+		}
+		if total == len(data) {
+			return total, nil
+		}
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -417,7 +522,7 @@ func (s *AbsfsNFS) WriteWithContext(ctx context.Context, node *NFSNode, offset i
 	tuning := s.tuning.Load()
 	policy := s.policy.Load()
 
-	if policy.ReadOnly {
+	if s.readOnlyIn(ctx) {
 		if slog := s.getStructuredLogger(); slog != nil && tuning.Log != nil && tuning.Log.LogOperations {
 			slog.Warn("WRITE operation denied: read-only mode",
 				LogField{Key: "path", Value: node.path})
@@ -453,16 +558,25 @@ func (s *AbsfsNFS) WriteWithContext(ctx context.Context, node *NFSNode, offset i
 	default:
 	}
 
-	// Limit the write size to TransferSize if it exceeds the configured limit
-	dataLength := len(data)
-	if dataLength > tuning.TransferSize {
-		data = data[:tuning.TransferSize]
+	// Limit the write size to the wtmax FSINFO advertises
+	if len(data) > tuning.MaxWriteSize {
+		data = data[:tuning.MaxWriteSize]
+	}
+
+	if _, err := s.confine(node.path, true, symlinkBudgetFrom(ctx)); err != nil {
+		return 0, opError("write", node.path, err)
+	}
+
+	if tuning.SerializeWrites {
+		mu := s.writeLock(node.path)
+		mu.Lock()
+		defer mu.Unlock()
 	}
 
 	// Standard write path
 	f, err := s.fs.OpenFile(node.path, os.O_WRONLY, 0)
 	if err != nil {
-		return 0, fmt.Errorf("write: failed to open %s: %w", node.path, err)
+		return 0, opError("write", node.path, err)
 	}
 	defer func() {
 		if closeErr := f.Close(); closeErr != nil && err == nil {
@@ -470,7 +584,22 @@ func (s *AbsfsNFS) WriteWithContext(ctx context.Context, node *NFSNode, offset i
 		}
 	}()
 
-	n, err := f.WriteAt(data, offset)
+	// Refuse to leave a hole wider than MaxWriteGap past the end of file
+	if gap := policy.MaxWriteGap; gap > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return 0, opError("write", node.path, err)
+		}
+		if offset-info.Size() > gap {
+			return 0, opError("write", node.path, fmt.Errorf("offset %d is more than %d bytes past end of file %d: %w", offset, gap, info.Size(), syscall.EFBIG))
+		}
+	}
+
+	fsStart := time.Now()
+	span := s.startFSSpan(ctx, "fs.WriteAt", node.path, offset, len(data))
+	n, err := writeAtRetryEINTR(f, data, offset)
+	endFSSpan(span, n, err)
+	s.RecordFSLatency("WRITE", time.Since(fsStart))
 	if err == nil {
 		// Invalidate cache after successful write
 		s.attrCache.Invalidate(node.path)
@@ -491,12 +620,46 @@ func (s *AbsfsNFS) WriteWithContext(ctx context.Context, node *NFSNode, offset i
 		if statErr == nil {
 			node.mu.Lock()
 			node.attrs.Size = info.Size()
-			node.attrs.SetMtime(info.ModTime())
+			node.attrs.SetMtime(s.fileModTime(node.path, info))
 			node.attrs.Refresh() // Initialize cache validity
 			node.mu.Unlock()
 		}
 	}
-	return int64(n), err
+	if err != nil {
+		return int64(n), opError("write", node.path, fmt.Errorf("at offset %d: %w", offset, err))
+	}
+	return int64(n), nil
+}
+
+// pathHash hashes path to pick its lock stripe
+func pathHash(path string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return h.Sum64()
+}
+
+// writeLock returns the write lock stripe for path
+func (s *AbsfsNFS) writeLock(path string) *sync.Mutex {
+	return &s.writeLocks[pathHash(path)%writeLockStripes]
+}
+
+// lockDirs locks the rename lock stripes of directories a and b, the lower
+// stripe first so that renames locking the same pair from either end
+// cannot deadlock, and returns the function that unlocks them
+func (s *AbsfsNFS) lockDirs(a, b string) func() {
+	i, j := pathHash(a)%dirLockStripes, pathHash(b)%dirLockStripes
+	if i > j {
+		i, j = j, i
+	}
+	s.dirLocks[i].Lock()
+	if i == j {
+		return s.dirLocks[i].Unlock
+	}
+	s.dirLocks[j].Lock()
+	return func() {
+		s.dirLocks[j].Unlock()
+		s.dirLocks[i].Unlock()
+	}
 }
 
 // Create implements the CREATE operation
@@ -506,6 +669,13 @@ func (s *AbsfsNFS) Create(dir *NFSNode, name string, attrs *NFSAttrs) (*NFSNode,
 
 // CreateWithContext implements the CREATE operation with timeout support
 func (s *AbsfsNFS) CreateWithContext(ctx context.Context, dir *NFSNode, name string, attrs *NFSAttrs) (*NFSNode, error) {
+	return s.create(ctx, dir, name, attrs, false)
+}
+
+// create creates name in dir. An existing file is truncated, unless
+// exclusive is set, in which case create fails with an error wrapping
+// os.ErrExist and leaves the file untouched.
+func (s *AbsfsNFS) create(ctx context.Context, dir *NFSNode, name string, attrs *NFSAttrs, exclusive bool) (*NFSNode, error) {
 	if dir == nil {
 		return nil, fmt.Errorf("nil directory node")
 	}
@@ -517,9 +687,8 @@ func (s *AbsfsNFS) CreateWithContext(ctx context.Context, dir *NFSNode, name str
 	}
 
 	tuning := s.tuning.Load()
-	policy := s.policy.Load()
 
-	if policy.ReadOnly {
+	if s.readOnlyIn(ctx) {
 		if slog := s.getStructuredLogger(); slog != nil && tuning.Log != nil && tuning.Log.LogFileAccess {
 			slog.Warn("CREATE operation denied: read-only mode",
 				LogField{Key: "dir", Value: dir.path},
@@ -556,29 +725,37 @@ func (s *AbsfsNFS) CreateWithContext(ctx context.Context, dir *NFSNode, name str
 	}
 
 	// Sanitize the path to prevent directory traversal attacks
-	path, err := sanitizePath(dir.path, name)
+	path, err := s.entryPath(dir, name)
 	if err != nil {
-		return nil, fmt.Errorf("create: failed to sanitize path: %w", err)
+		return nil, opError("create", dir.path, fmt.Errorf("name %q: %w", name, err))
 	}
 
-	f, err := s.fs.Create(path)
+	fsStart := time.Now()
+	var f absfs.File
+	if exclusive {
+		f, err = s.fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, attrs.Mode&os.ModePerm)
+	} else {
+		f, err = s.fs.Create(path)
+	}
+	s.RecordFSLatency("CREATE", time.Since(fsStart))
 	if err != nil {
-		return nil, fmt.Errorf("create: failed to create %s: %w", path, err)
+		return nil, opError("create", path, err)
 	}
 	if err := f.Close(); err != nil {
 		s.fs.Remove(path)
-		return nil, fmt.Errorf("create: failed to close %s: %w", path, err)
+		return nil, opError("create", path, err)
 	}
 
 	if err := s.fs.Chmod(path, attrs.Mode&os.ModePerm); err != nil {
 		s.fs.Remove(path)
-		return nil, fmt.Errorf("create: failed to chmod %s: %w", path, err)
+		return nil, opError("create", path, err)
 	}
 
 	// Invalidate parent directory caches and negative cache entries in the directory
 	s.attrCache.Invalidate(dir.path)
 	s.attrCache.InvalidateNegativeInDir(dir.path)
 	s.attrCache.Invalidate(path) // Also invalidate the specific path in case it was negatively cached
+	s.cookieCache.Touch(dir.path)
 	if s.dirCache != nil {
 		s.dirCache.Invalidate(dir.path)
 	}
@@ -600,9 +777,8 @@ func (s *AbsfsNFS) RemoveWithContext(ctx context.Context, dir *NFSNode, name str
 	}
 
 	tuning := s.tuning.Load()
-	policy := s.policy.Load()
 
-	if policy.ReadOnly {
+	if s.readOnlyIn(ctx) {
 		if slog := s.getStructuredLogger(); slog != nil && tuning.Log != nil && tuning.Log.LogFileAccess {
 			slog.Warn("REMOVE operation denied: read-only mode",
 				LogField{Key: "dir", Value: dir.path},
@@ -639,18 +815,22 @@ func (s *AbsfsNFS) RemoveWithContext(ctx context.Context, dir *NFSNode, name str
 	}
 
 	// Sanitize the path to prevent directory traversal attacks
-	path, err := sanitizePath(dir.path, name)
+	path, err := s.entryPath(dir, name)
 	if err != nil {
-		return fmt.Errorf("remove: failed to sanitize path: %w", err)
+		return opError("remove", dir.path, fmt.Errorf("name %q: %w", name, err))
 	}
 
+	fsStart := time.Now()
 	err = s.fs.Remove(path)
+	s.RecordFSLatency("REMOVE", time.Since(fsStart))
 	if err != nil {
-		return fmt.Errorf("remove: failed to remove %s: %w", path, err)
+		return opError("remove", path, err)
 	}
 	// Invalidate caches
 	s.attrCache.Invalidate(path)
 	s.attrCache.Invalidate(dir.path)
+	s.forgetIdentity(path, false)
+	s.cookieCache.Touch(dir.path)
 	if s.dirCache != nil {
 		s.dirCache.Invalidate(dir.path)
 	}
@@ -672,9 +852,8 @@ func (s *AbsfsNFS) RenameWithContext(ctx context.Context, oldDir *NFSNode, oldNa
 	}
 
 	tuning := s.tuning.Load()
-	policy := s.policy.Load()
 
-	if policy.ReadOnly {
+	if s.readOnlyIn(ctx) {
 		return os.ErrPermission
 	}
 
@@ -694,19 +873,26 @@ func (s *AbsfsNFS) RenameWithContext(ctx context.Context, oldDir *NFSNode, oldNa
 	}
 
 	// Sanitize both paths to prevent directory traversal attacks
-	oldPath, err := sanitizePath(oldDir.path, oldName)
+	oldPath, err := s.entryPath(oldDir, oldName)
 	if err != nil {
-		return fmt.Errorf("rename: failed to sanitize old path: %w", err)
+		return opError("rename", oldDir.path, fmt.Errorf("name %q: %w", oldName, err))
 	}
 
-	newPath, err := sanitizePath(newDir.path, newName)
+	newPath, err := s.entryPath(newDir, newName)
 	if err != nil {
-		return fmt.Errorf("rename: failed to sanitize new path: %w", err)
+		return opError("rename", newDir.path, fmt.Errorf("name %q: %w", newName, err))
 	}
 
+	// Serialize with other renames in either directory, so of two renames
+	// of the same source the second finds it gone
+	unlock := s.lockDirs(oldDir.path, newDir.path)
+	defer unlock()
+
+	fsStart := time.Now()
 	err = s.fs.Rename(oldPath, newPath)
+	s.RecordFSLatency("RENAME", time.Since(fsStart))
 	if err != nil {
-		return fmt.Errorf("rename: failed to rename %s to %s: %w", oldPath, newPath, err)
+		return opError("rename", oldPath, fmt.Errorf("to %q: %w", newPath, err))
 	}
 	// Invalidate caches and negative cache entries
 	s.attrCache.Invalidate(oldPath)
@@ -716,6 +902,11 @@ func (s *AbsfsNFS) RenameWithContext(ctx context.Context, oldDir *NFSNode, oldNa
 	// Invalidate negative cache entries in both directories
 	s.attrCache.InvalidateNegativeInDir(oldDir.path)
 	s.attrCache.InvalidateNegativeInDir(newDir.path)
+	// A renamed directory takes everything indexed below it along
+	s.forgetIdentity(oldPath, true)
+	s.forgetIdentity(newPath, false)
+	s.cookieCache.Touch(oldDir.path)
+	s.cookieCache.Touch(newDir.path)
 	if s.dirCache != nil {
 		s.dirCache.Invalidate(oldDir.path)
 		s.dirCache.Invalidate(newDir.path)
@@ -730,9 +921,18 @@ func (s *AbsfsNFS) ReadDir(dir *NFSNode) ([]*NFSNode, error) {
 
 // ReadDirWithContext implements the READDIR operation with timeout support
 func (s *AbsfsNFS) ReadDirWithContext(ctx context.Context, dir *NFSNode) ([]*NFSNode, error) {
+	return s.readDir(ctx, dir, s.tuning.Load().ReaddirListVanished)
+}
+
+// readDir lists dir, keeping entries that vanish before their attributes
+// are read if keepVanished is set
+func (s *AbsfsNFS) readDir(ctx context.Context, dir *NFSNode, keepVanished bool) ([]*NFSNode, error) {
 	if dir == nil {
 		return nil, fmt.Errorf("nil directory node")
 	}
+	if _, err := s.confine(dir.path, true, symlinkBudgetFrom(ctx)); err != nil {
+		return nil, opError("readdir", dir.path, err)
+	}
 
 	tuning := s.tuning.Load()
 
@@ -751,11 +951,31 @@ func (s *AbsfsNFS) ReadDirWithContext(ctx context.Context, dir *NFSNode) ([]*NFS
 	default:
 	}
 
+	entries, err := s.readDirEntries(dir, tuning)
+	if err != nil {
+		return nil, err
+	}
+	return s.listNodes(dir, entries, keepVanished), nil
+}
+
+// readDirEntries returns the backing entries of dir, from the directory
+// cache when it holds them
+func (s *AbsfsNFS) readDirEntries(dir *NFSNode, tuning *TuningOptions) ([]os.FileInfo, error) {
 	// Check directory cache first if enabled
 	var entries []os.FileInfo
 	var cacheHit bool
+	var dirMtime time.Time
 	if s.dirCache != nil {
-		entries, cacheHit = s.dirCache.Get(dir.path)
+		if tuning.ValidateDirCacheMtime {
+			// Trade one Stat for catching out-of-band changes before the TTL expires.
+			// On Stat failure fall through to a fresh read, which reports the error.
+			if info, err := s.fs.Stat(dir.path); err == nil {
+				dirMtime = info.ModTime()
+				entries, cacheHit = s.dirCache.GetIfUnchanged(dir.path, dirMtime)
+			}
+		} else {
+			entries, cacheHit = s.dirCache.Get(dir.path)
+		}
 		if cacheHit {
 			// Record cache hit in metrics
 			if s.metrics != nil {
@@ -768,27 +988,7 @@ func (s *AbsfsNFS) ReadDirWithContext(ctx context.Context, dir *NFSNode) ([]*NFS
 					LogField{Key: "path", Value: dir.path})
 			}
 
-			// Convert cached entries to nodes
-			var nodes []*NFSNode
-			for _, entry := range entries {
-				name := entry.Name()
-				// Skip "." and ".." entries
-				if name == "." || name == ".." {
-					continue
-				}
-				// Sanitize the path to prevent directory traversal attacks
-				entryPath, err := sanitizePath(dir.path, name)
-				if err != nil {
-					// Skip entries with invalid names
-					continue
-				}
-				node, err := s.Lookup(entryPath)
-				if err != nil {
-					continue
-				}
-				nodes = append(nodes, node)
-			}
-			return nodes, nil
+			return entries, nil
 		}
 
 		// Record cache miss in metrics
@@ -805,7 +1005,7 @@ func (s *AbsfsNFS) ReadDirWithContext(ctx context.Context, dir *NFSNode) ([]*NFS
 
 	f, err := s.fs.OpenFile(dir.path, os.O_RDONLY, 0)
 	if err != nil {
-		return nil, fmt.Errorf("readdir: failed to open directory %s: %w", dir.path, err)
+		return nil, opError("readdir", dir.path, err)
 	}
 	defer f.Close()
 
@@ -816,83 +1016,213 @@ func (s *AbsfsNFS) ReadDirWithContext(ctx context.Context, dir *NFSNode) ([]*NFS
 	}
 
 	// Read directory entries
+	fsStart := time.Now()
 	entries, err = dirFile.Readdir(-1)
+	s.RecordFSLatency("READDIR", time.Since(fsStart))
 	if err != nil {
-		return nil, fmt.Errorf("readdir: failed to read entries from %s: %w", dir.path, err)
+		return nil, opError("readdir", dir.path, err)
 	}
 
 	// Store entries in cache if enabled
 	if s.dirCache != nil {
-		s.dirCache.Put(dir.path, entries)
+		s.dirCache.PutWithMtime(dir.path, entries, dirMtime)
 	}
 
+	return entries, nil
+}
+
+// entryNodes converts directory entries to nodes. An entry whose attributes
+// cannot be fetched (it vanished, or the backing Lstat failed) is logged,
+// counted and left out so the rest of the listing is still returned, unless
+// it vanished and keepVanished is set: then it is kept with the mode the
+// listing gave it, for a names-only READDIR. A name the backing filesystem
+// lists more than once is kept only the first time.
+func (s *AbsfsNFS) entryNodes(dir *NFSNode, entries []os.FileInfo, keepVanished bool) []*NFSNode {
 	var nodes []*NFSNode
+	seen := make(map[string]struct{}, len(entries))
 	for _, entry := range entries {
 		name := entry.Name()
 		// Skip "." and ".." entries
 		if name == "." || name == ".." {
 			continue
 		}
+		if _, dup := seen[name]; dup {
+			s.RecordReaddirDuplicateEntry()
+			if slog := s.getStructuredLogger(); slog != nil {
+				slog.Warn("READDIR: skipping duplicate entry name",
+					LogField{Key: "dir", Value: dir.path},
+					LogField{Key: "name", Value: name})
+			}
+			continue
+		}
+		seen[name] = struct{}{}
 		// Sanitize the path to prevent directory traversal attacks
-		entryPath, err := sanitizePath(dir.path, name)
+		entryPath, err := s.childPath(dir.path, name)
 		if err != nil {
 			// Skip entries with invalid names
 			continue
 		}
 		node, err := s.Lookup(entryPath)
+		if err != nil && keepVanished && errors.Is(err, os.ErrNotExist) {
+			if slog := s.getStructuredLogger(); slog != nil {
+				slog.Debug("READDIR: listing entry that vanished",
+					LogField{Key: "path", Value: entryPath})
+			}
+			nodes = append(nodes, vanishedNode(s.fs, entryPath, entry))
+			continue
+		}
 		if err != nil {
+			s.RecordReaddirSkippedEntry()
+			if slog := s.getStructuredLogger(); slog != nil {
+				slog.Warn("READDIR: skipping entry with unreadable attributes",
+					LogField{Key: "path", Value: entryPath},
+					LogField{Key: "error", Value: err})
+			}
 			continue
 		}
 		nodes = append(nodes, node)
 	}
+	return nodes
+}
 
-	return nodes, nil
+// vanishedNode returns a node for a listed entry that no longer exists,
+// carrying only what the listing said of it
+func vanishedNode(fs absfs.SymlinkFileSystem, path string, entry os.FileInfo) *NFSNode {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return &NFSNode{
+		SymlinkFileSystem: fs,
+		path:              path,
+		attrs:             &NFSAttrs{Mode: entry.Mode(), FileId: h.Sum64()},
+	}
 }
 
-// ReadDirPlus implements the READDIRPLUS operation
+// ReadDirPlus implements the READDIRPLUS operation. Entries deleted while
+// the directory is being listed are left out, since there are no
+// attributes to send with them.
 func (s *AbsfsNFS) ReadDirPlus(dir *NFSNode) ([]*NFSNode, error) {
 	if dir == nil {
 		return nil, fmt.Errorf("nil directory node")
 	}
 
-	nodes, err := s.ReadDir(dir)
+	nodes, err := s.readDir(context.Background(), dir, false)
 	if err != nil {
 		return nil, err
 	}
 
-	// Pre-cache attributes for all entries
+	// Fetch attributes for all entries, inserting them into the attribute
+	// cache together
+	fresh := make(map[string]*NFSAttrs)
+	kept := nodes[:0]
 	for _, node := range nodes {
-		if attrs, found := s.attrCache.Get(node.path, s); !found || attrs == nil || !attrs.IsValid() {
-			info, err := s.fs.Stat(node.path)
-			if err != nil {
-				continue
-			}
-			// Read Uid/Gid with lock protection
-			node.mu.RLock()
-			uid := node.attrs.Uid
-			gid := node.attrs.Gid
-			node.mu.RUnlock()
-
-			modTime := info.ModTime()
-			attrs := &NFSAttrs{
-				Mode: info.Mode(),
-				Size: info.Size(),
-				Uid:  uid,
-				Gid:  gid,
-			}
-			attrs.SetMtime(modTime)
-			attrs.SetAtime(modTime)
-			attrs.Refresh() // Initialize cache validity
-			s.attrCache.Put(node.path, attrs)
+		if _, ok := s.entryAttrs(node, fresh); ok {
+			kept = append(kept, node)
+		}
+	}
+	s.attrCache.PutBatch(fresh)
 
-			// Assign attrs with write lock protection
-			node.mu.Lock()
-			node.attrs = attrs
-			node.mu.Unlock()
+	return kept, nil
+}
+
+// entryAttrs returns the attributes READDIRPLUS sends for node and stores
+// them in node: the attribute cache's while they last, otherwise a fresh
+// Stat's, which are added to fresh for the caller to cache in one batch.
+// It returns false, counting and logging the entry as skipped, if node has
+// been deleted since it was listed.
+func (s *AbsfsNFS) entryAttrs(node *NFSNode, fresh map[string]*NFSAttrs) (*NFSAttrs, bool) {
+	node.mu.RLock()
+	current := *node.attrs
+	node.mu.RUnlock()
+	if node.shard != "" {
+		// Synthetic shard directories have no backing entry of their own
+		return &current, true
+	}
+	if attrs, found := s.attrCache.Get(node.path, s); found && attrs != nil {
+		return attrs, true
+	}
+
+	info, err := s.fs.Stat(node.path)
+	if err != nil {
+		if s.vanished(node.path, err) {
+			s.RecordReaddirSkippedEntry()
+			if slog := s.getStructuredLogger(); slog != nil {
+				slog.Warn("READDIRPLUS: skipping entry that vanished",
+					LogField{Key: "path", Value: node.path})
+			}
+			return nil, false
 		}
+		return &current, true
+	}
+
+	modTime := s.fileModTime(node.path, info)
+	attrs := &NFSAttrs{
+		Mode:   s.fileMode(node.path, info),
+		Size:   info.Size(),
+		FileId: current.FileId,
+		Uid:    current.Uid,
+		Gid:    current.Gid,
+	}
+	attrs.RdevMajor, attrs.RdevMinor = deviceNumbers(info)
+	attrs.SetMtime(modTime)
+	attrs.SetAtime(modTime)
+	attrs.Refresh() // Initialize cache validity
+	fresh[node.path] = attrs
+
+	// Assign attrs with write lock protection
+	node.mu.Lock()
+	node.attrs = attrs
+	node.mu.Unlock()
+	cp := *attrs
+	return &cp, true
+}
+
+// vanished reports whether err, from reading the attributes of path,
+// means path no longer exists. A dangling symlink fails Stat but is still
+// there to list.
+func (s *AbsfsNFS) vanished(path string, err error) bool {
+	if !errors.Is(err, os.ErrNotExist) {
+		return false
 	}
+	_, err = s.fs.Lstat(path)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// warmOnMountDepth is how far below the mounted path WarmOnMount lists
+const warmOnMountDepth = 2
 
-	return nodes, nil
+// WarmCache lists path and its subdirectories down to depth levels, filling
+// the directory cache and the attribute cache for each entry on the way. An
+// error listing path itself is returned; failures further down are skipped.
+func (s *AbsfsNFS) WarmCache(path string, depth int) error {
+	return s.warmCache(context.Background(), path, depth)
+}
+
+// warmCache is WarmCache, stopping early once ctx is done
+func (s *AbsfsNFS) warmCache(ctx context.Context, path string, depth int) error {
+	node, err := s.Lookup(path)
+	if err != nil {
+		return err
+	}
+	return s.warmDir(ctx, node, depth)
+}
+
+// warmDir lists dir and recurses into its subdirectories while depth lasts
+func (s *AbsfsNFS) warmDir(ctx context.Context, dir *NFSNode, depth int) error {
+	if depth <= 0 || ctx.Err() != nil {
+		return nil
+	}
+	children, err := s.readDir(ctx, dir, false)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		attrs, err := s.GetAttr(child)
+		if err != nil || !attrs.Mode.IsDir() {
+			continue
+		}
+		s.warmDir(ctx, child, depth-1)
+	}
+	return nil
 }
 
 // Export starts serving the NFS export
@@ -928,6 +1258,12 @@ func (s *AbsfsNFS) Export(mountPath string, port int) error {
 
 // Symlink implements the SYMLINK operation
 func (s *AbsfsNFS) Symlink(dir *NFSNode, name string, target string, attrs *NFSAttrs) (*NFSNode, error) {
+	return s.SymlinkWithContext(context.Background(), dir, name, target, attrs)
+}
+
+// SymlinkWithContext implements the SYMLINK operation for the call ctx
+// belongs to
+func (s *AbsfsNFS) SymlinkWithContext(ctx context.Context, dir *NFSNode, name string, target string, attrs *NFSAttrs) (*NFSNode, error) {
 	if dir == nil {
 		return nil, fmt.Errorf("nil directory node")
 	}
@@ -941,42 +1277,98 @@ func (s *AbsfsNFS) Symlink(dir *NFSNode, name string, target string, attrs *NFSA
 		return nil, fmt.Errorf("nil attrs")
 	}
 
-	if s.policy.Load().ReadOnly {
+	if s.readOnlyIn(ctx) {
 		return nil, os.ErrPermission
 	}
 
 	// Sanitize the path to prevent directory traversal attacks
-	path, err := sanitizePath(dir.path, name)
+	path, err := s.entryPath(dir, name)
 	if err != nil {
-		return nil, fmt.Errorf("symlink: failed to sanitize path: %w", err)
+		return nil, opError("symlink", dir.path, fmt.Errorf("name %q: %w", name, err))
 	}
 
 	// Create the symlink (s.fs is absfs.SymlinkFileSystem)
 	err = s.fs.Symlink(target, path)
 	if err != nil {
-		return nil, fmt.Errorf("symlink: failed to create symlink at %s pointing to %s: %w", path, target, err)
+		return nil, opError("symlink", path, fmt.Errorf("to %q: %w", target, err))
 	}
 
 	// Invalidate parent directory caches and negative cache entries in the directory
 	s.attrCache.Invalidate(dir.path)
 	s.attrCache.InvalidateNegativeInDir(dir.path)
 	s.attrCache.Invalidate(path) // Also invalidate the specific path in case it was negatively cached
+	s.cookieCache.Touch(dir.path)
 	if s.dirCache != nil {
 		s.dirCache.Invalidate(dir.path)
 	}
 	return s.Lookup(path)
 }
 
+// MkdirAll creates the directory relPath, a slash-separated path relative to
+// parent, along with any missing intermediate directories, and returns the
+// leaf's node. It is the server-side counterpart of mkdir -p: MKDIR itself
+// creates a single level, since filenames may not contain separators. Each
+// component is checked as MKDIR checks a name, and existing directories
+// along the way are left as they are.
+func (s *AbsfsNFS) MkdirAll(parent *NFSNode, relPath string, mode os.FileMode) (*NFSNode, error) {
+	if parent == nil {
+		return nil, fmt.Errorf("nil directory node")
+	}
+
+	if s.policy.Load().ReadOnly {
+		return nil, os.ErrPermission
+	}
+
+	dirPath := parent.path
+	for _, name := range strings.Split(relPath, "/") {
+		if name == "" {
+			continue
+		}
+		path, err := s.childPath(dirPath, name)
+		if err != nil {
+			return nil, opError("mkdir", dirPath, fmt.Errorf("name %q: %w", name, err))
+		}
+
+		if err := s.fs.Mkdir(path, mode&os.ModePerm); err != nil {
+			info, statErr := s.fs.Stat(path)
+			if statErr != nil || !info.IsDir() {
+				if statErr == nil {
+					err = syscall.ENOTDIR
+				}
+				return nil, opError("mkdir", path, err)
+			}
+		} else {
+			// Invalidate parent directory caches and negative cache entries in the directory
+			s.attrCache.Invalidate(dirPath)
+			s.attrCache.InvalidateNegativeInDir(dirPath)
+			s.attrCache.Invalidate(path)
+			s.cookieCache.Touch(dirPath)
+			if s.dirCache != nil {
+				s.dirCache.Invalidate(dirPath)
+			}
+		}
+		dirPath = path
+	}
+	if dirPath == parent.path {
+		return nil, fmt.Errorf("empty path")
+	}
+	return s.Lookup(dirPath)
+}
+
 // Readlink implements the READLINK operation
 func (s *AbsfsNFS) Readlink(node *NFSNode) (string, error) {
 	if node == nil {
 		return "", fmt.Errorf("nil node")
 	}
 
+	if _, err := s.confineToRoot(node.path); err != nil {
+		return "", opError("readlink", node.path, err)
+	}
+
 	// s.fs is absfs.SymlinkFileSystem, so Readlink is always available
 	target, err := s.fs.Readlink(node.path)
 	if err != nil {
-		return "", fmt.Errorf("readlink: failed to read symlink %s: %w", node.path, err)
+		return "", opError("readlink", node.path, err)
 	}
 
 	// Sanitize relative targets to prevent traversal outside export.
@@ -984,7 +1376,7 @@ func (s *AbsfsNFS) Readlink(node *NFSNode) (string, error) {
 	if !strings.HasPrefix(target, "/") {
 		for _, component := range strings.Split(target, "/") {
 			if component == ".." {
-				return "", fmt.Errorf("readlink: symlink target with '..' not allowed")
+				return "", opError("readlink", node.path, fmt.Errorf("symlink target with '..' not allowed"))
 			}
 		}
 	}
@@ -999,6 +1391,7 @@ func (s *AbsfsNFS) Unexport() error {
 		s.exportServer.Stop()
 		s.exportServer = nil
 	}
+	s.clearSessions()
 	// Cleanup all open file handles
 	s.fileMap.ReleaseAll()
 	// Clear caches
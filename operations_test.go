@@ -3,14 +3,19 @@ package absnfs
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/absfs/absfs"
 	"github.com/absfs/memfs"
 )
 
@@ -889,32 +894,32 @@ func TestOperationsAdvanced(t *testing.T) {
 	// Test error mapping
 	t.Run("error mapping", func(t *testing.T) {
 		// Test nil error
-		if status := mapError(nil); status != NFS_OK {
+		if status := MapErrorToNFSStatus(nil); status != NFS_OK {
 			t.Errorf("Expected NFS_OK for nil error, got %d", status)
 		}
 
 		// Test not exist error
-		if status := mapError(os.ErrNotExist); status != NFSERR_NOENT {
+		if status := MapErrorToNFSStatus(os.ErrNotExist); status != NFSERR_NOENT {
 			t.Errorf("Expected NFSERR_NOENT for ErrNotExist, got %d", status)
 		}
 
 		// Test permission error
-		if status := mapError(os.ErrPermission); status != NFSERR_PERM {
-			t.Errorf("Expected NFSERR_PERM for ErrPermission, got %d", status)
+		if status := MapErrorToNFSStatus(os.ErrPermission); status != NFSERR_ACCES {
+			t.Errorf("Expected NFSERR_ACCES for ErrPermission, got %d", status)
 		}
 
 		// Test file exists error
-		if status := mapError(os.ErrExist); status != NFSERR_EXIST {
+		if status := MapErrorToNFSStatus(os.ErrExist); status != NFSERR_EXIST {
 			t.Errorf("Expected NFSERR_EXIST for ErrExist, got %d", status)
 		}
 
 		// Test invalid argument error
-		if status := mapError(os.ErrInvalid); status != NFSERR_INVAL {
+		if status := MapErrorToNFSStatus(os.ErrInvalid); status != NFSERR_INVAL {
 			t.Errorf("Expected NFSERR_INVAL for ErrInvalid, got %d", status)
 		}
 
 		// Test other error
-		if status := mapError(os.ErrClosed); status != NFSERR_IO {
+		if status := MapErrorToNFSStatus(os.ErrClosed); status != NFSERR_IO {
 			t.Errorf("Expected NFSERR_IO for other error, got %d", status)
 		}
 	})
@@ -1846,7 +1851,7 @@ func TestNFSOperationsErrorPaths(t *testing.T) {
 			}
 
 			var buf bytes.Buffer
-			binary.Write(&buf, binary.BigEndian, fileHandle)
+			xdrEncodeFileHandle(&buf, fileHandle)
 			binary.Write(&buf, binary.BigEndian, uint64(0))  // offset
 			binary.Write(&buf, binary.BigEndian, uint32(10)) // count - intentionally different from data length
 			binary.Write(&buf, binary.BigEndian, uint32(1))  // stable
@@ -1887,7 +1892,7 @@ func TestFileAttributeEncodeErrors(t *testing.T) {
 		// Atime: time.Now()
 	}
 
-	err := encodeFileAttributes(badWriter, attrs)
+	err := encodeFileAttributes(badWriter, attrs, nil)
 	if err == nil {
 		t.Error("Expected error when writing to bad writer")
 	}
@@ -1972,7 +1977,7 @@ func TestM10_DirectoryNlinkAtLeastTwo(t *testing.T) {
 
 	var buf [256]byte
 	w := &sliceWriter{buf: buf[:0]}
-	err := encodeFileAttributes(w, dirAttrs)
+	err := encodeFileAttributes(w, dirAttrs, nil)
 	if err != nil {
 		t.Fatalf("encodeFileAttributes failed: %v", err)
 	}
@@ -1998,7 +2003,7 @@ func TestM10_DirectoryNlinkAtLeastTwo(t *testing.T) {
 	fileAttrs.SetAtime(time.Now())
 
 	w2 := &sliceWriter{buf: buf[:0]}
-	err = encodeFileAttributes(w2, fileAttrs)
+	err = encodeFileAttributes(w2, fileAttrs, nil)
 	if err != nil {
 		t.Fatalf("encodeFileAttributes failed: %v", err)
 	}
@@ -2489,3 +2494,348 @@ func TestValidateFilenameMoreCases(t *testing.T) {
 	}
 }
 
+// busyWriteFS returns EBUSY from WriteAt while busy is set and records the
+// peak number of WriteAt calls in flight at once
+type busyWriteFS struct {
+	*memfs.FileSystem
+	busy     atomic.Bool
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+type busyWriteFile struct {
+	absfs.File
+	fs *busyWriteFS
+}
+
+func (f *busyWriteFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &busyWriteFile{File: file, fs: f}, nil
+}
+
+func (f *busyWriteFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.fs.busy.Load() {
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.EBUSY}
+	}
+	n := f.fs.inFlight.Add(1)
+	defer f.fs.inFlight.Add(-1)
+	for {
+		peak := f.fs.peak.Load()
+		if n <= peak || f.fs.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+	return f.File.WriteAt(p, off)
+}
+
+func TestWriteBusyFile(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/busy.txt")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Close()
+
+	fs := &busyWriteFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{SerializeWrites: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	fileHandle := getFileHandle(server, "/busy.txt")
+
+	t.Run("EBUSY maps to JUKEBOX", func(t *testing.T) {
+		fs.busy.Store(true)
+		defer fs.busy.Store(false)
+
+		reply, err := handler.handleWrite(bytes.NewReader(buildWriteRequest(fileHandle, 0, []byte("data"))), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleWrite failed: %v", err)
+		}
+		if status := readStatusFromReply(reply); status != NFSERR_JUKEBOX {
+			t.Errorf("Expected NFSERR_JUKEBOX, got %d", status)
+		}
+	})
+
+	t.Run("error names op and path", func(t *testing.T) {
+		fs.busy.Store(true)
+		defer fs.busy.Store(false)
+
+		node, err := nfs.Lookup("/busy.txt")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		_, err = nfs.Write(node, 8, []byte("data"))
+		if want := `write "/busy.txt": at offset 8: `; err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("Expected error starting %q, got %v", want, err)
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			t.Errorf("Expected error to wrap EBUSY, got %v", err)
+		}
+	})
+
+	t.Run("serialized writes do not overlap", func(t *testing.T) {
+		node, err := nfs.Lookup("/busy.txt")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := nfs.Write(node, int64(i*4), []byte("abcd")); err != nil {
+					t.Errorf("Write %d failed: %v", i, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if peak := fs.peak.Load(); peak != 1 {
+			t.Errorf("Expected at most 1 write in flight, saw %d", peak)
+		}
+		if info, err := mfs.Stat("/busy.txt"); err != nil || info.Size() != 32 {
+			t.Errorf("Expected 32 bytes written, got %v (err %v)", info, err)
+		}
+	})
+}
+
+// interruptingFS makes the first ReadAt and WriteAt on each open file
+// transfer half the requested bytes and then fail with EINTR
+type interruptingFS struct {
+	*memfs.FileSystem
+	interrupts atomic.Int32
+}
+
+type interruptingFile struct {
+	absfs.File
+	fs                *interruptingFS
+	readHit, writeHit bool
+}
+
+func (f *interruptingFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &interruptingFile{File: file, fs: f}, nil
+}
+
+func (f *interruptingFile) ReadAt(p []byte, off int64) (int, error) {
+	if !f.readHit && len(p) > 1 {
+		f.readHit = true
+		f.fs.interrupts.Add(1)
+		n, _ := f.File.ReadAt(p[:len(p)/2], off)
+		return n, syscall.EINTR
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *interruptingFile) WriteAt(p []byte, off int64) (int, error) {
+	if !f.writeHit && len(p) > 1 {
+		f.writeHit = true
+		f.fs.interrupts.Add(1)
+		n, _ := f.File.WriteAt(p[:len(p)/2], off)
+		return n, syscall.EINTR
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func TestReadWriteRetryEINTR(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/data.txt")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Write([]byte("0123456789abcdef"))
+	f.Close()
+
+	fs := &interruptingFS{FileSystem: mfs}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	node, err := nfs.Lookup("/data.txt")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	data, err := nfs.Read(node, 2, 10)
+	if err != nil {
+		t.Fatalf("Read surfaced an error: %v", err)
+	}
+	if string(data) != "23456789ab" {
+		t.Errorf("Expected %q, got %q", "23456789ab", data)
+	}
+
+	n, err := nfs.Write(node, 4, []byte("WXYZ"))
+	if err != nil {
+		t.Fatalf("Write surfaced an error: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 bytes written, got %d", n)
+	}
+	data, err = nfs.Read(node, 0, 16)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "0123WXYZ89abcdef" {
+		t.Errorf("Expected %q after write, got %q", "0123WXYZ89abcdef", data)
+	}
+
+	if got := fs.interrupts.Load(); got != 3 {
+		t.Errorf("Expected 3 injected EINTRs, got %d", got)
+	}
+}
+
+// slowLstatFS counts Lstat calls per path and makes each one slow enough
+// for concurrent lookups to overlap
+type slowLstatFS struct {
+	*memfs.FileSystem
+	mu     sync.Mutex
+	lstats map[string]int
+}
+
+func (f *slowLstatFS) Lstat(name string) (os.FileInfo, error) {
+	f.mu.Lock()
+	f.lstats[name]++
+	f.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	return f.FileSystem.Lstat(name)
+}
+
+func TestConcurrentLookupsCoalesce(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, _ := mfs.Create("/file")
+	f.Close()
+	fs := &slowLstatFS{FileSystem: mfs, lstats: make(map[string]int)}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+
+	const callers = 20
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	nodes := make([]*NFSNode, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			nodes[i], errs[i] = nfs.Lookup("/file")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Lookup %d failed: %v", i, errs[i])
+		}
+		if nodes[i].path != "/file" || nodes[i].attrs.Mode.IsDir() {
+			t.Errorf("Lookup %d returned %s mode %v", i, nodes[i].path, nodes[i].attrs.Mode)
+		}
+		if i > 0 && nodes[i].attrs == nodes[0].attrs {
+			t.Errorf("Lookup %d shares attributes with lookup 0", i)
+		}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if n := fs.lstats["/file"]; n != 1 {
+		t.Errorf("Expected 1 backing Lstat for %d concurrent lookups, got %d", callers, n)
+	}
+
+	// A missing path coalesces too, and every caller sees the error
+	fs.lstats = make(map[string]int)
+	fs.mu.Unlock()
+	var missing sync.WaitGroup
+	var notFound atomic.Int32
+	start = make(chan struct{})
+	for i := 0; i < callers; i++ {
+		missing.Add(1)
+		go func() {
+			defer missing.Done()
+			<-start
+			if _, err := nfs.Lookup("/missing"); errors.Is(err, os.ErrNotExist) {
+				notFound.Add(1)
+			}
+		}()
+	}
+	close(start)
+	missing.Wait()
+	fs.mu.Lock()
+	if n := notFound.Load(); n != callers {
+		t.Errorf("Expected %d not-found errors, got %d", callers, n)
+	}
+	if n := fs.lstats["/missing"]; n != 1 {
+		t.Errorf("Expected 1 backing Lstat for the missing path, got %d", n)
+	}
+}
+
+func TestMkdirAll(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.Mkdir("/a", 0755)
+	f, _ := fs.Create("/file")
+	f.Close()
+
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	root, err := nfs.Lookup("/")
+	if err != nil {
+		t.Fatalf("Failed to lookup root: %v", err)
+	}
+
+	leaf, err := nfs.MkdirAll(root, "a/b/c", 0750)
+	if err != nil {
+		t.Fatalf("MkdirAll a/b/c: %v", err)
+	}
+	for _, dir := range []string{"/a", "/a/b", "/a/b/c"} {
+		if info, err := fs.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("Expected %s to be a directory, got %v, %v", dir, info, err)
+		}
+	}
+	if info, _ := fs.Stat("/a/b/c"); info != nil && info.Mode().Perm() != 0750 {
+		t.Errorf("Expected /a/b/c to have mode 0750, got %04o", info.Mode().Perm())
+	}
+	handle := nfs.fileMap.Allocate(leaf)
+	file, ok := nfs.fileMap.Get(handle)
+	if !ok || file.(*NFSNode).path != "/a/b/c" {
+		t.Errorf("Leaf handle resolves to %v, want /a/b/c", file)
+	}
+
+	// Existing levels are fine, a file in the way is not
+	if _, err := nfs.MkdirAll(root, "a/b/c", 0755); err != nil {
+		t.Errorf("MkdirAll over existing directories: %v", err)
+	}
+	if _, err := nfs.MkdirAll(root, "file/x", 0755); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("MkdirAll through a file: expected ENOTDIR, got %v", err)
+	}
+	if _, err := nfs.MkdirAll(root, "a/../../escape", 0755); err == nil {
+		t.Error("MkdirAll accepted a path with '..'")
+	}
+	if _, err := nfs.MkdirAll(root, "/", 0755); err == nil {
+		t.Error("MkdirAll accepted an empty path")
+	}
+}
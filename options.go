@@ -7,46 +7,101 @@
 package absnfs
 
 import (
+	"crypto/x509"
 	"fmt"
+	"os"
 	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TuningOptions contains performance-related settings safe for runtime change.
 // Stale reads are harmless -- they only affect performance characteristics.
 type TuningOptions struct {
-	TransferSize          int
-	AttrCacheTimeout      time.Duration
-	AttrCacheSize         int
-	CacheNegativeLookups  bool
-	NegativeCacheTimeout  time.Duration
-	EnableDirCache        bool
-	DirCacheTimeout       time.Duration
-	DirCacheMaxEntries    int
-	DirCacheMaxDirSize    int
-	MaxWorkers            int
-	MaxConnections        int
-	IdleTimeout           time.Duration
-	TCPKeepAlive          bool
-	TCPNoDelay            bool
-	SendBufferSize        int
-	ReceiveBufferSize     int
-	Async                 bool
-	Log                   *LogConfig
-	Timeouts              *TimeoutConfig
+	TransferSize           int
+	MaxReadSize            int
+	MaxWriteSize           int
+	PreferredDirReadSize   int
+	AdviseSequentialReads  int
+	AttrCacheTimeout       time.Duration
+	AttrCacheSize          int
+	CacheConsistency       string
+	CacheNegativeLookups   bool
+	NegativeCacheTimeout   time.Duration
+	NegativeCacheMaxPerDir int
+	EnableDirCache         bool
+	DirCacheTimeout        time.Duration
+	DirCacheMaxEntries     int
+	DirCacheMaxDirSize     int
+	ValidateDirCacheMtime  bool
+	WarmOnMount            bool
+	DisableReaddirPlus     bool
+	HandleAttrCache        bool
+	ReaddirPlusMaxEntries  int
+	ReaddirListVanished    bool
+	DirShardThreshold      int
+	CookieCacheSize        int
+	HandleIdleTimeout      time.Duration
+	SerializeWrites        bool
+	EnableLocking          bool
+	UnstableFlushTimeout   time.Duration
+	ClampFutureMtime       bool
+	OnCacheHealthChange    func(rate float64)
+	CacheHealthThreshold   float64
+	OutageProbeInterval    time.Duration
+	OutageThreshold        int
+	OnOutageChange         func(outage bool)
+	MaxWorkers             int
+	AutoScaleWorkers       bool
+	MetadataWorkerReserve  int
+	MaxConnections         int
+	IdleTimeout            time.Duration
+	TCPKeepAlive           bool
+	TCPNoDelay             bool
+	SendBufferSize         int
+	ReceiveBufferSize      int
+	Async                  bool
+	LogRPCOnError          bool
+	MetricsSampleRate      float64
+	TracerProvider         trace.TracerProvider
+	HealthThresholds       HealthThresholds
+	Log                    *LogConfig
+	Timeouts               *TimeoutConfig
 }
 
 // PolicyOptions contains security/access settings that require drain-and-swap.
 // Stale reads are dangerous -- they can violate security invariants.
 type PolicyOptions struct {
-	ReadOnly           bool
-	Secure             bool
-	AllowedIPs         []string
-	Squash             string
-	MaxFileSize        int64
-	EnableRateLimiting bool
-	RateLimitConfig    *RateLimiterConfig
-	TLS                *TLSConfig
+	ReadOnly               bool
+	Secure                 bool
+	RequireReservedPort    bool
+	AllowedIPs             []string
+	Squash                 string
+	ClientRules            []ClientRule
+	EnablePermissionChecks bool
+	MaxFileSize            int64
+	MaxWriteGap            int64
+	UnsupportedSetattr     string
+	EnableRateLimiting     bool
+	RateLimitConfig        *RateLimiterConfig
+	TLS                    *TLSConfig
+	CertToIDFunc           func(cert *x509.Certificate) (uid, gid uint32, ok bool)
+	GSSAcceptor            GSSAcceptor
+	PrincipalMapper        func(principal string) (uid, gid uint32, ok bool)
+	PinnedTime             *time.Time
+	ExportRoot             string
+	ConfineSymlinks        bool
+	MaxSymlinkDepth        int
+	MaxSymlinkResolutions  int
+	UIDMap                 []IDMapEntry
+	GIDMap                 []IDMapEntry
+	AnonUID                uint32
+	AnonGID                uint32
+	ReplayWindow           time.Duration
+	DryRun                 bool
+	PersistentHandles      bool
+	HandleEncoder          func(path string, info os.FileInfo) uint64
 }
 
 // RequestOptions is a per-request snapshot of both tuning and policy options.
@@ -67,23 +122,53 @@ func (n *AbsfsNFS) snapshotOptions() *RequestOptions {
 // tuningFromExportOptions extracts TuningOptions from ExportOptions.
 func tuningFromExportOptions(opts *ExportOptions) *TuningOptions {
 	t := &TuningOptions{
-		TransferSize:          opts.TransferSize,
-		AttrCacheTimeout:      opts.AttrCacheTimeout,
-		AttrCacheSize:         opts.AttrCacheSize,
-		CacheNegativeLookups:  opts.CacheNegativeLookups,
-		NegativeCacheTimeout:  opts.NegativeCacheTimeout,
-		EnableDirCache:        opts.EnableDirCache,
-		DirCacheTimeout:       opts.DirCacheTimeout,
-		DirCacheMaxEntries:    opts.DirCacheMaxEntries,
-		DirCacheMaxDirSize:    opts.DirCacheMaxDirSize,
-		MaxWorkers:            opts.MaxWorkers,
-		MaxConnections:        opts.MaxConnections,
-		IdleTimeout:           opts.IdleTimeout,
-		TCPKeepAlive:          opts.TCPKeepAlive,
-		TCPNoDelay:            opts.TCPNoDelay,
-		SendBufferSize:        opts.SendBufferSize,
-		ReceiveBufferSize:     opts.ReceiveBufferSize,
-		Async:                 opts.Async,
+		TransferSize:           opts.TransferSize,
+		MaxReadSize:            opts.MaxReadSize,
+		MaxWriteSize:           opts.MaxWriteSize,
+		PreferredDirReadSize:   opts.PreferredDirReadSize,
+		AdviseSequentialReads:  opts.AdviseSequentialReads,
+		AttrCacheTimeout:       opts.AttrCacheTimeout,
+		AttrCacheSize:          opts.AttrCacheSize,
+		CacheConsistency:       opts.CacheConsistency,
+		CacheNegativeLookups:   opts.CacheNegativeLookups,
+		NegativeCacheTimeout:   opts.NegativeCacheTimeout,
+		NegativeCacheMaxPerDir: opts.NegativeCacheMaxPerDir,
+		EnableDirCache:         opts.EnableDirCache,
+		DirCacheTimeout:        opts.DirCacheTimeout,
+		DirCacheMaxEntries:     opts.DirCacheMaxEntries,
+		DirCacheMaxDirSize:     opts.DirCacheMaxDirSize,
+		ValidateDirCacheMtime:  opts.ValidateDirCacheMtime,
+		WarmOnMount:            opts.WarmOnMount,
+		DisableReaddirPlus:     opts.DisableReaddirPlus,
+		HandleAttrCache:        opts.HandleAttrCache,
+		ReaddirPlusMaxEntries:  opts.ReaddirPlusMaxEntries,
+		ReaddirListVanished:    opts.ReaddirListVanished,
+		DirShardThreshold:      opts.DirShardThreshold,
+		CookieCacheSize:        opts.CookieCacheSize,
+		HandleIdleTimeout:      opts.HandleIdleTimeout,
+		SerializeWrites:        opts.SerializeWrites,
+		EnableLocking:          opts.EnableLocking,
+		UnstableFlushTimeout:   opts.UnstableFlushTimeout,
+		ClampFutureMtime:       opts.ClampFutureMtime,
+		OnCacheHealthChange:    opts.OnCacheHealthChange,
+		CacheHealthThreshold:   opts.CacheHealthThreshold,
+		OutageProbeInterval:    opts.OutageProbeInterval,
+		OutageThreshold:        opts.OutageThreshold,
+		OnOutageChange:         opts.OnOutageChange,
+		MaxWorkers:             opts.MaxWorkers,
+		AutoScaleWorkers:       opts.AutoScaleWorkers,
+		MetadataWorkerReserve:  opts.MetadataWorkerReserve,
+		MaxConnections:         opts.MaxConnections,
+		IdleTimeout:            opts.IdleTimeout,
+		TCPKeepAlive:           opts.TCPKeepAlive,
+		TCPNoDelay:             opts.TCPNoDelay,
+		SendBufferSize:         opts.SendBufferSize,
+		ReceiveBufferSize:      opts.ReceiveBufferSize,
+		Async:                  opts.Async,
+		LogRPCOnError:          opts.LogRPCOnError,
+		MetricsSampleRate:      opts.MetricsSampleRate,
+		TracerProvider:         opts.TracerProvider,
+		HealthThresholds:       opts.HealthThresholds,
 	}
 	if opts.Log != nil {
 		logCopy := *opts.Log
@@ -99,16 +184,43 @@ func tuningFromExportOptions(opts *ExportOptions) *TuningOptions {
 // policyFromExportOptions extracts PolicyOptions from ExportOptions.
 func policyFromExportOptions(opts *ExportOptions) *PolicyOptions {
 	p := &PolicyOptions{
-		ReadOnly:           opts.ReadOnly,
-		Secure:             opts.Secure,
-		Squash:             opts.Squash,
-		MaxFileSize:        opts.MaxFileSize,
-		EnableRateLimiting: opts.EnableRateLimiting,
+		ReadOnly:               opts.ReadOnly,
+		Secure:                 opts.Secure,
+		RequireReservedPort:    opts.RequireReservedPort == nil || *opts.RequireReservedPort,
+		Squash:                 opts.Squash,
+		EnablePermissionChecks: opts.EnablePermissionChecks,
+		MaxFileSize:            opts.MaxFileSize,
+		MaxWriteGap:            opts.MaxWriteGap,
+		UnsupportedSetattr:     opts.UnsupportedSetattr,
+		EnableRateLimiting:     opts.EnableRateLimiting,
+		CertToIDFunc:           opts.CertToIDFunc,
+		GSSAcceptor:            opts.GSSAcceptor,
+		PrincipalMapper:        opts.PrincipalMapper,
+		ExportRoot:             opts.ExportRoot,
+		ConfineSymlinks:        opts.ConfineSymlinks,
+		MaxSymlinkDepth:        opts.MaxSymlinkDepth,
+		MaxSymlinkResolutions:  opts.MaxSymlinkResolutions,
+		UIDMap:                 opts.UIDMap,
+		GIDMap:                 opts.GIDMap,
+		AnonUID:                anonOrDefault(opts.AnonUID),
+		AnonGID:                anonOrDefault(opts.AnonGID),
+		ReplayWindow:           opts.ReplayWindow,
+		DryRun:                 opts.DryRun,
+		PersistentHandles:      opts.PersistentHandles,
+		HandleEncoder:          opts.HandleEncoder,
+	}
+	if opts.PinnedTime != nil {
+		pt := *opts.PinnedTime
+		p.PinnedTime = &pt
 	}
 	if len(opts.AllowedIPs) > 0 {
 		p.AllowedIPs = make([]string, len(opts.AllowedIPs))
 		copy(p.AllowedIPs, opts.AllowedIPs)
 	}
+	if len(opts.ClientRules) > 0 {
+		p.ClientRules = make([]ClientRule, len(opts.ClientRules))
+		copy(p.ClientRules, opts.ClientRules)
+	}
 	if opts.RateLimitConfig != nil {
 		rc := *opts.RateLimitConfig
 		p.RateLimitConfig = &rc
@@ -121,34 +233,92 @@ func policyFromExportOptions(opts *ExportOptions) *PolicyOptions {
 
 // exportOptionsFromSnapshots reconstructs an ExportOptions from tuning + policy snapshots.
 func exportOptionsFromSnapshots(t *TuningOptions, p *PolicyOptions) ExportOptions {
+	requireReservedPort := p.RequireReservedPort
 	opts := ExportOptions{
-		ReadOnly:              p.ReadOnly,
-		Secure:                p.Secure,
-		Squash:                p.Squash,
-		MaxFileSize:           p.MaxFileSize,
-		EnableRateLimiting:    p.EnableRateLimiting,
-		Async:                 t.Async,
-		TransferSize:          t.TransferSize,
-		AttrCacheTimeout:      t.AttrCacheTimeout,
-		AttrCacheSize:         t.AttrCacheSize,
-		CacheNegativeLookups:  t.CacheNegativeLookups,
-		NegativeCacheTimeout:  t.NegativeCacheTimeout,
-		EnableDirCache:        t.EnableDirCache,
-		DirCacheTimeout:       t.DirCacheTimeout,
-		DirCacheMaxEntries:    t.DirCacheMaxEntries,
-		DirCacheMaxDirSize:    t.DirCacheMaxDirSize,
-		MaxWorkers:            t.MaxWorkers,
-		MaxConnections:        t.MaxConnections,
-		IdleTimeout:           t.IdleTimeout,
-		TCPKeepAlive:          t.TCPKeepAlive,
-		TCPNoDelay:            t.TCPNoDelay,
-		SendBufferSize:        t.SendBufferSize,
-		ReceiveBufferSize:     t.ReceiveBufferSize,
+		ReadOnly:               p.ReadOnly,
+		Secure:                 p.Secure,
+		RequireReservedPort:    &requireReservedPort,
+		Squash:                 p.Squash,
+		EnablePermissionChecks: p.EnablePermissionChecks,
+		MaxFileSize:            p.MaxFileSize,
+		MaxWriteGap:            p.MaxWriteGap,
+		UnsupportedSetattr:     p.UnsupportedSetattr,
+		EnableRateLimiting:     p.EnableRateLimiting,
+		CertToIDFunc:           p.CertToIDFunc,
+		GSSAcceptor:            p.GSSAcceptor,
+		PrincipalMapper:        p.PrincipalMapper,
+		ExportRoot:             p.ExportRoot,
+		ConfineSymlinks:        p.ConfineSymlinks,
+		MaxSymlinkDepth:        p.MaxSymlinkDepth,
+		MaxSymlinkResolutions:  p.MaxSymlinkResolutions,
+		UIDMap:                 p.UIDMap,
+		GIDMap:                 p.GIDMap,
+		AnonUID:                p.AnonUID,
+		AnonGID:                p.AnonGID,
+		ReplayWindow:           p.ReplayWindow,
+		DryRun:                 p.DryRun,
+		PersistentHandles:      p.PersistentHandles,
+		HandleEncoder:          p.HandleEncoder,
+		Async:                  t.Async,
+		TransferSize:           t.TransferSize,
+		MaxReadSize:            t.MaxReadSize,
+		MaxWriteSize:           t.MaxWriteSize,
+		PreferredDirReadSize:   t.PreferredDirReadSize,
+		AdviseSequentialReads:  t.AdviseSequentialReads,
+		AttrCacheTimeout:       t.AttrCacheTimeout,
+		AttrCacheSize:          t.AttrCacheSize,
+		CacheConsistency:       t.CacheConsistency,
+		CacheNegativeLookups:   t.CacheNegativeLookups,
+		NegativeCacheTimeout:   t.NegativeCacheTimeout,
+		NegativeCacheMaxPerDir: t.NegativeCacheMaxPerDir,
+		EnableDirCache:         t.EnableDirCache,
+		DirCacheTimeout:        t.DirCacheTimeout,
+		DirCacheMaxEntries:     t.DirCacheMaxEntries,
+		DirCacheMaxDirSize:     t.DirCacheMaxDirSize,
+		ValidateDirCacheMtime:  t.ValidateDirCacheMtime,
+		WarmOnMount:            t.WarmOnMount,
+		DisableReaddirPlus:     t.DisableReaddirPlus,
+		HandleAttrCache:        t.HandleAttrCache,
+		ReaddirPlusMaxEntries:  t.ReaddirPlusMaxEntries,
+		ReaddirListVanished:    t.ReaddirListVanished,
+		DirShardThreshold:      t.DirShardThreshold,
+		CookieCacheSize:        t.CookieCacheSize,
+		HandleIdleTimeout:      t.HandleIdleTimeout,
+		SerializeWrites:        t.SerializeWrites,
+		EnableLocking:          t.EnableLocking,
+		UnstableFlushTimeout:   t.UnstableFlushTimeout,
+		ClampFutureMtime:       t.ClampFutureMtime,
+		OnCacheHealthChange:    t.OnCacheHealthChange,
+		CacheHealthThreshold:   t.CacheHealthThreshold,
+		OutageProbeInterval:    t.OutageProbeInterval,
+		OutageThreshold:        t.OutageThreshold,
+		OnOutageChange:         t.OnOutageChange,
+		MaxWorkers:             t.MaxWorkers,
+		AutoScaleWorkers:       t.AutoScaleWorkers,
+		MetadataWorkerReserve:  t.MetadataWorkerReserve,
+		MaxConnections:         t.MaxConnections,
+		IdleTimeout:            t.IdleTimeout,
+		TCPKeepAlive:           t.TCPKeepAlive,
+		TCPNoDelay:             t.TCPNoDelay,
+		SendBufferSize:         t.SendBufferSize,
+		ReceiveBufferSize:      t.ReceiveBufferSize,
+		LogRPCOnError:          t.LogRPCOnError,
+		MetricsSampleRate:      t.MetricsSampleRate,
+		TracerProvider:         t.TracerProvider,
+		HealthThresholds:       t.HealthThresholds,
+	}
+	if p.PinnedTime != nil {
+		pt := *p.PinnedTime
+		opts.PinnedTime = &pt
 	}
 	if len(p.AllowedIPs) > 0 {
 		opts.AllowedIPs = make([]string, len(p.AllowedIPs))
 		copy(opts.AllowedIPs, p.AllowedIPs)
 	}
+	if len(p.ClientRules) > 0 {
+		opts.ClientRules = make([]ClientRule, len(p.ClientRules))
+		copy(opts.ClientRules, p.ClientRules)
+	}
 	if p.RateLimitConfig != nil {
 		rc := *p.RateLimitConfig
 		opts.RateLimitConfig = &rc
@@ -169,8 +339,9 @@ func exportOptionsFromSnapshots(t *TuningOptions, p *PolicyOptions) ExportOption
 
 // UpdateTuningOptions applies a mutation function to the current tuning options.
 // The mutation is applied to a copy; the result is stored atomically.
-// No drain is needed -- stale tuning reads are harmless.
-func (n *AbsfsNFS) UpdateTuningOptions(fn func(*TuningOptions)) {
+// No drain is needed -- stale tuning reads are harmless. A result with an
+// invalid CacheConsistency is rejected and the options are left unchanged.
+func (n *AbsfsNFS) UpdateTuningOptions(fn func(*TuningOptions)) error {
 	n.tuningMu.Lock()
 	defer n.tuningMu.Unlock()
 
@@ -186,8 +357,36 @@ func (n *AbsfsNFS) UpdateTuningOptions(fn func(*TuningOptions)) {
 		updated.Timeouts = &tCopy
 	}
 	fn(&updated)
+	if err := validateCacheConsistency(updated.CacheConsistency); err != nil {
+		return err
+	}
 	n.tuning.Store(&updated)
 	n.applyTuningSideEffects(old, &updated)
+	return nil
+}
+
+// validatePolicyChange reports why newPolicy cannot replace old: it changes
+// a field fixed at New, or is invalid in itself
+func validatePolicyChange(old, newPolicy *PolicyOptions) error {
+	if old.Squash != newPolicy.Squash {
+		return fmt.Errorf("cannot change Squash mode at runtime")
+	}
+	if !samePinnedTime(old.PinnedTime, newPolicy.PinnedTime) {
+		return fmt.Errorf("cannot change PinnedTime at runtime")
+	}
+	if old.ExportRoot != newPolicy.ExportRoot {
+		return fmt.Errorf("cannot change ExportRoot at runtime")
+	}
+	if old.PersistentHandles != newPolicy.PersistentHandles {
+		return fmt.Errorf("cannot change PersistentHandles at runtime")
+	}
+	if newPolicy.PinnedTime != nil && !newPolicy.ReadOnly {
+		return fmt.Errorf("an export with PinnedTime must remain read-only")
+	}
+	if err := validateUnsupportedSetattr(newPolicy.UnsupportedSetattr); err != nil {
+		return err
+	}
+	return validateClientRules(newPolicy.ClientRules)
 }
 
 // UpdatePolicyOptions swaps policy using drain-and-swap.
@@ -197,9 +396,8 @@ func (n *AbsfsNFS) UpdatePolicyOptions(newPolicy PolicyOptions) error {
 	n.policyMu.Lock()
 	defer n.policyMu.Unlock()
 
-	old := n.policy.Load()
-	if old.Squash != newPolicy.Squash {
-		return fmt.Errorf("cannot change Squash mode at runtime")
+	if err := validatePolicyChange(n.policy.Load(), &newPolicy); err != nil {
+		return err
 	}
 
 	// Drain in-flight requests: Lock() blocks until all RLock holders
@@ -213,6 +411,10 @@ func (n *AbsfsNFS) UpdatePolicyOptions(newPolicy PolicyOptions) error {
 		snapshot.AllowedIPs = make([]string, len(newPolicy.AllowedIPs))
 		copy(snapshot.AllowedIPs, newPolicy.AllowedIPs)
 	}
+	if len(newPolicy.ClientRules) > 0 {
+		snapshot.ClientRules = make([]ClientRule, len(newPolicy.ClientRules))
+		copy(snapshot.ClientRules, newPolicy.ClientRules)
+	}
 	if newPolicy.RateLimitConfig != nil {
 		rc := *newPolicy.RateLimitConfig
 		snapshot.RateLimitConfig = &rc
@@ -220,6 +422,10 @@ func (n *AbsfsNFS) UpdatePolicyOptions(newPolicy PolicyOptions) error {
 	if newPolicy.TLS != nil {
 		snapshot.TLS = newPolicy.TLS.Clone()
 	}
+	if newPolicy.PinnedTime != nil {
+		pt := *newPolicy.PinnedTime
+		snapshot.PinnedTime = &pt
+	}
 	n.policy.Store(&snapshot)
 
 	// Update rate limiter while still holding the write lock (H2 fix)
@@ -266,6 +472,11 @@ func (n *AbsfsNFS) applyTuningSideEffects(old, updated *TuningOptions) {
 			n.attrCache.ConfigureNegativeCaching(updated.CacheNegativeLookups, updated.NegativeCacheTimeout)
 		}
 	}
+	if updated.NegativeCacheMaxPerDir > 0 && updated.NegativeCacheMaxPerDir != old.NegativeCacheMaxPerDir {
+		if n.attrCache != nil {
+			n.attrCache.SetNegativeDirLimit(updated.NegativeCacheMaxPerDir)
+		}
+	}
 
 	// Update directory cache
 	if updated.DirCacheMaxEntries > 0 && updated.DirCacheMaxEntries != old.DirCacheMaxEntries {
@@ -280,9 +491,25 @@ func (n *AbsfsNFS) applyTuningSideEffects(old, updated *TuningOptions) {
 	}
 
 	// Update worker pool
-	if updated.MaxWorkers > 0 && updated.MaxWorkers != old.MaxWorkers {
+	if updated.MaxWorkers > 0 && (updated.MaxWorkers != old.MaxWorkers || updated.AutoScaleWorkers != old.AutoScaleWorkers) {
+		if n.workerPool != nil {
+			if updated.AutoScaleWorkers {
+				n.workerPool.SetAutoScale(autoScaleMinWorkers(updated.MaxWorkers), updated.MaxWorkers)
+			} else {
+				n.workerPool.SetAutoScale(0, 0)
+				n.workerPool.Resize(updated.MaxWorkers)
+			}
+		}
+	}
+	if updated.CookieCacheSize > 0 && updated.CookieCacheSize != old.CookieCacheSize {
+		n.cookieCache.Resize(updated.CookieCacheSize)
+	}
+	if updated.HandleIdleTimeout != old.HandleIdleTimeout {
+		n.fileMap.SetIdleTimeout(updated.HandleIdleTimeout)
+	}
+	if updated.MetadataWorkerReserve != old.MetadataWorkerReserve {
 		if n.workerPool != nil {
-			n.workerPool.Resize(updated.MaxWorkers)
+			n.workerPool.SetMetadataReserve(updated.MetadataWorkerReserve)
 		}
 	}
 
@@ -314,17 +541,80 @@ func (n *AbsfsNFS) initAtomicOptions(opts *ExportOptions) {
 // ExportOptions defines the configuration for an NFS export
 type ExportOptions struct {
 	ReadOnly    bool     // Export as read-only
-	Secure      bool     // Require secure ports (<1024)
+	Secure      bool     // Require secure ports (<1024) even if RequireReservedPort is false
 	AllowedIPs  []string // List of allowed client IPs/subnets
 	Squash      string   // User mapping (root/all/none)
-	Async       bool     // Allow async writes
+	Async       bool     // Sync UNSTABLE writes in the background until COMMIT
 	MaxFileSize int64    // Maximum file size
 
-	// TransferSize controls the maximum size in bytes of read/write transfers
+	// RequireReservedPort denies every call but NULL from a client source
+	// port of 1024 or above, over TCP and UDP, as nfsd's "secure" export
+	// option does. Secure is the older name for the same check and still
+	// enforces it when set. nil in an update keeps the current setting
+	// Default: true (point it at false to accept calls from any port)
+	RequireReservedPort *bool
+
+	// ClientRules override ReadOnly and Squash for the clients they cover,
+	// like the client list of an exports(5) line. When several rules cover
+	// a client the most specific host wins: an IP address over a longer
+	// CIDR prefix over a shorter one over "*". Clients no rule covers get
+	// ReadOnly and Squash; AllowedIPs still decides who may connect
+	// Default: nil (every client gets ReadOnly and Squash)
+	ClientRules []ClientRule
+
+	// EnablePermissionChecks makes READ, WRITE, SETATTR, REMOVE and RMDIR
+	// check the file's mode bits against the caller's squashed UID, GID and
+	// auxiliary GIDs, failing with NFSERR_ACCES (or NFSERR_PERM for a
+	// SETATTR only the owner may make) as a local kernel would. Without it
+	// the server leaves permission checks to clients
+	// Default: false
+	EnablePermissionChecks bool
+
+	// MaxWriteGap is how far past the end of a file, in bytes, a WRITE may
+	// start. A write that would leave a wider hole fails with NFSERR_FBIG,
+	// so a stray offset cannot create a huge sparse file on a backend that
+	// fills holes with real zeros
+	// Default: 0 (unlimited)
+	MaxWriteGap int64
+
+	// UnsupportedSetattr is what SETATTR does when a client sets an
+	// attribute a backing filesystem implementing SetattrSupporter cannot
+	// change: "notsupp" refuses the request with NFSERR_NOTSUPP, changing
+	// nothing, and "cosmetic" leaves that attribute as it is and applies
+	// the rest. Empty calls the backing filesystem anyway and returns its
+	// error
+	// Default: ""
+	UnsupportedSetattr string
+
+	// TransferSize controls the preferred size in bytes of read/write
+	// transfers; the largest are bounded by MaxReadSize and MaxWriteSize
 	// Larger values may improve performance but require more memory
 	// Default: 65536 (64KB)
 	TransferSize int
 
+	// MaxReadSize and MaxWriteSize are the largest READ and WRITE counts
+	// the export accepts, advertised as rtmax and wtmax in FSINFO. The
+	// preferred sizes, rtpref and wtpref, are the smaller of these and
+	// TransferSize, so raise both for fast links. A larger READ comes back
+	// short; a larger WRITE fails with NFSERR_INVAL
+	// Default: 1048576 (1MB)
+	MaxReadSize  int
+	MaxWriteSize int
+
+	// PreferredDirReadSize is the READDIR request size in bytes advertised
+	// to clients as dtpref in FSINFO. Clients size their directory reads by
+	// it, so larger values mean fewer round trips to list big directories
+	// Default: 8192 (8KB)
+	PreferredDirReadSize int
+
+	// AdviseSequentialReads is how many READs of a file handle in a row,
+	// each starting where the last ended, make the server advise a backing
+	// filesystem implementing Advisor that the file is read sequentially;
+	// each further READ in the run advises the range after it. 0 disables
+	// the advice
+	// Default: 0
+	AdviseSequentialReads int
+
 	// AttrCacheTimeout controls how long file attributes are cached
 	// Longer timeouts improve performance but may cause clients to see stale data
 	// Default: 5 * time.Second
@@ -335,6 +625,25 @@ type ExportOptions struct {
 	// Default: 10000 entries
 	AttrCacheSize int
 
+	// CacheConsistency is how far LOOKUP and GETATTR trust cached attributes
+	// when the backing filesystem may change underneath the server:
+	// "ttl" trusts them until AttrCacheTimeout, "close-to-open" has every
+	// LOOKUP (which a client makes on open) check the cached mtime and size
+	// against the backing file, and "strict" stats the backing filesystem
+	// on every call and never answers from the cache
+	// Default: "" (same as "ttl")
+	CacheConsistency string
+
+	// HandleAttrCache lets GETATTR answer from the attribute cache by file
+	// handle, without resolving the handle's path or Lstat-ing it again
+	// (with ConfineSymlinks, one Lstat per path component). A handle's
+	// entry is dropped whenever the path's is, by any change made through
+	// the server; changes made to the backing filesystem directly, such as
+	// a directory replaced by a symlink, go unnoticed for up to
+	// AttrCacheTimeout
+	// Default: false
+	HandleAttrCache bool
+
 	// CacheNegativeLookups enables caching of failed lookups (file not found)
 	// This can significantly reduce filesystem load for repeated lookups of non-existent files
 	// Negative cache entries use a shorter TTL than positive entries
@@ -347,6 +656,14 @@ type ExportOptions struct {
 	// Default: 5 * time.Second
 	NegativeCacheTimeout time.Duration
 
+	// NegativeCacheMaxPerDir caps the negative cache entries kept for any
+	// one directory, so a client probing many missing names in a directory
+	// evicts that directory's oldest negatives rather than everything else
+	// Zero or less selects the default, and leaves the cap unchanged on a
+	// runtime update; a server's negative cache is always capped per directory
+	// Default: 256
+	NegativeCacheMaxPerDir int
+
 	// EnableDirCache enables caching of directory entries for improved performance
 	// When enabled, directory listings are cached to reduce filesystem calls
 	// Default: false (disabled)
@@ -370,11 +687,150 @@ type ExportOptions struct {
 	// Default: 10000 entries per directory
 	DirCacheMaxDirSize int
 
+	// ValidateDirCacheMtime stats a directory before serving its cached listing
+	// and refreshes the listing if the directory mtime changed since it was cached
+	// Catches out-of-band changes to the backing filesystem at the cost of one Stat
+	// Only applicable when EnableDirCache is true
+	// Default: false (cached listings are served until DirCacheTimeout)
+	ValidateDirCacheMtime bool
+
+	// WarmOnMount warms the caches under the mounted path after a successful MNT
+	// by listing it and its subdirectories to a small depth in the background,
+	// so the client's first READDIR and GETATTRs are served from cache
+	// The MNT reply does not wait for warming to finish; mounted paths are
+	// warmed one at a time, a path already queued is not queued again, and
+	// Close abandons warming still in progress
+	// Default: false
+	WarmOnMount bool
+
+	// DisableReaddirPlus makes READDIRPLUS return NFS3ERR_NOTSUPP so clients
+	// fall back to READDIR followed by LOOKUP/GETATTR for the entries they need
+	// Useful when per-entry attribute lookups are expensive on the backing filesystem
+	// Default: false (READDIRPLUS is served)
+	DisableReaddirPlus bool
+
+	// ReaddirPlusMaxEntries caps the number of entries in one READDIRPLUS reply,
+	// independent of the client's byte budget; the client resumes from the
+	// returned cookie. Helps clients that cope poorly with very large replies
+	// Default: 0 (limited only by the byte budget)
+	ReaddirPlusMaxEntries int
+
+	// ReaddirListVanished keeps names in READDIR replies that the backing
+	// filesystem lists but that are gone by the time their attributes are
+	// read, as when a file is deleted mid-listing; the client gets
+	// NFS3ERR_NOENT when it looks one up. READDIRPLUS, whose entries carry
+	// attributes, always leaves such names out
+	// Default: false (READDIR leaves them out too)
+	ReaddirListVanished bool
+
+	// DirShardThreshold presents a directory holding more than this many
+	// entries as synthetic subdirectories, one per distinct two-character
+	// name prefix, for clients that cope poorly with huge flat directories.
+	// "abc.txt" in a sharded /big is listed and looked up as /big/ab/abc.txt,
+	// while the backing filesystem is left flat. Entries are created and
+	// removed only through the shard their name belongs to; the sharded
+	// directory itself refuses them. Deciding whether a directory is sharded
+	// reads it on each LOOKUP and entry change, so pair this with
+	// EnableDirCache and a DirCacheMaxDirSize that admits such directories
+	// Default: 0 (directories are never sharded)
+	DirShardThreshold int
+
+	// CookieCacheSize caps the number of directories whose listing is held
+	// while clients page through it with READDIR, evicting the least
+	// recently listed first. At most 100,000 entries are held across all
+	// listings, and a listing is dropped five minutes after it was read. A
+	// client resuming an evicted listing is served from a fresh read, which
+	// its cookies still fit unless the directory has been modified, when it
+	// gets NFSERR_BAD_COOKIE
+	// Default: 1000 directories
+	CookieCacheSize int
+
+	// HandleIdleTimeout expires file handles no request has referenced for
+	// this long; a client presenting one afterwards gets NFSERR_STALE. Every
+	// request that references a handle refreshes it, so handles in use never
+	// expire. When the handle table is full, the least recently used handles
+	// are evicted first regardless of this setting
+	// Default: 0 (handles are only evicted when the table is full)
+	HandleIdleTimeout time.Duration
+
+	// SerializeWrites runs WRITEs to the same file one at a time
+	// Useful for backing filesystems that reject concurrent writers (EBUSY/ETXTBSY)
+	// Writes to different files still proceed in parallel
+	// Default: false (writes are issued concurrently)
+	SerializeWrites bool
+
+	// EnableLocking serves the Network Lock Manager (NLM v4, program 100021)
+	// so clients mounted without nolock get working fcntl/flock locks
+	// Locks are advisory and held in memory; they are lost on restart
+	// StartWithPortmapper registers NLM when this is set at startup
+	// Default: false (NLM calls get PROG_UNAVAIL)
+	EnableLocking bool
+
+	// UnstableFlushTimeout holds the background sync of a file's UNSTABLE
+	// writes until no write has reached the file for this long, so a burst
+	// of writes is synced once. The data still reaches stable storage
+	// without a COMMIT, which helps clients that rely on close-to-open
+	// consistency and never send one. A COMMIT, or Close, syncs at once
+	// Only applicable when Async is true
+	// Default: 0 (UNSTABLE writes are synced as soon as possible)
+	UnstableFlushTimeout time.Duration
+
+	// ClampFutureMtime reports an mtime that is ahead of the server clock, as
+	// some backing filesystems or skewed clocks produce, as the current time,
+	// so client cache heuristics are not thrown off. Each occurrence is logged
+	// Default: false (mtimes are reported as the backing filesystem gives them)
+	ClampFutureMtime bool
+
+	// OnCacheHealthChange is called when the attribute cache hit rate over the
+	// last 1000 lookups falls below CacheHealthThreshold, and again when it
+	// recovers, with the rolling rate at that moment. Runs on the request path,
+	// so it must not block
+	// Default: nil (no callback)
+	OnCacheHealthChange func(rate float64)
+
+	// CacheHealthThreshold is the rolling hit rate below which the attribute
+	// cache is considered degraded for OnCacheHealthChange
+	// Default: 0.5
+	CacheHealthThreshold float64
+
+	// OutageProbeInterval is how often a background probe Stats the export root
+	// to detect an unresponsive backing filesystem. After OutageThreshold
+	// consecutive failures (or probes still outstanding after an interval) NFS
+	// calls other than NULL get NFSERR_JUKEBOX until a probe succeeds again.
+	// The probe starts in New only when this is set; changes to the interval
+	// at runtime take effect on the next probe
+	// Default: 0 (no probe)
+	OutageProbeInterval time.Duration
+
+	// OutageThreshold is the number of consecutive failed probes that starts
+	// an outage
+	// Default: 3
+	OutageThreshold int
+
+	// OnOutageChange is called from the probe goroutine with true when an
+	// outage starts and false when it ends
+	// Default: nil (no callback)
+	OnOutageChange func(outage bool)
+
 	// MaxWorkers controls the maximum number of goroutines used for handling concurrent operations
 	// More workers can improve performance for concurrent workloads but consume more CPU resources
 	// Default: runtime.NumCPU() * 4 (number of logical CPUs multiplied by 4)
 	MaxWorkers int
 
+	// AutoScaleWorkers starts the worker pool at a quarter of MaxWorkers and
+	// grows it toward MaxWorkers while tasks back up in its queues, shrinking
+	// it again once it has been idle for 30 seconds. Whatever MaxWorkers
+	// says, the pool never grows past 1024 workers.
+	// Default: false (the pool always has MaxWorkers workers)
+	AutoScaleWorkers bool
+
+	// MetadataWorkerReserve sets aside this many workers for metadata calls
+	// (NULL, GETATTR, LOOKUP, ACCESS, READLINK, FSSTAT, FSINFO, PATHCONF), which
+	// get their own queue so they are not starved behind bulk READs and WRITEs.
+	// Metadata calls may also run on the other workers. Capped at MaxWorkers-1
+	// Default: 0 (all calls share one queue)
+	MetadataWorkerReserve int
+
 	// MaxConnections limits the number of simultaneous client connections
 	// Setting to 0 means unlimited connections (limited only by system resources)
 	// Default: 100
@@ -424,6 +880,144 @@ type ExportOptions struct {
 	// If nil, TLS is disabled and connections are unencrypted (default NFSv3 behavior)
 	TLS *TLSConfig
 
+	// CertToIDFunc maps a verified TLS client certificate to the UID/GID used
+	// for that client's requests, replacing the spoofable AUTH_SYS identity
+	// Returning ok=false falls back to the RPC credential; Squash still applies
+	// Default: nil (identity always comes from the RPC credential)
+	CertToIDFunc func(cert *x509.Certificate) (uid, gid uint32, ok bool)
+
+	// GSSAcceptor enables the RPCSEC_GSS auth flavor, establishing security
+	// contexts with the GSS-API mechanism it adapts, typically Kerberos v5.
+	// Only the rpc_gss_svc_none service is supported: calls are
+	// authenticated, but their arguments and results are not protected
+	// Default: nil (RPCSEC_GSS calls are refused)
+	GSSAcceptor GSSAcceptor
+
+	// PrincipalMapper maps the principal of an RPCSEC_GSS call, such as
+	// "alice@EXAMPLE.COM", to the UID/GID it runs as; Squash still applies
+	// Returning ok=false, or leaving it nil, runs the call as nobody
+	// Default: nil
+	PrincipalMapper func(principal string) (uid, gid uint32, ok bool)
+
+	// PinnedTime exports the backing filesystem as it was at this instant
+	// The filesystem passed to New must implement TimeTravelFS
+	// The export is always read-only; this cannot be changed at runtime
+	// Default: nil (the live filesystem is exported)
+	PinnedTime *time.Time
+
+	// ExportRoot exports only the subtree below this directory of the backing
+	// filesystem, which the export sees as "/". Symlinks in the subtree whose
+	// targets lead outside it are treated as dangling
+	// Cannot be changed at runtime
+	// Default: "" (the whole filesystem is exported)
+	ExportRoot string
+
+	// ConfineSymlinks resolves symlinks along every path before it reaches the
+	// backing filesystem and rejects paths whose targets lead outside the export
+	// root with NFSERR_ACCES. Costs one Lstat per path component
+	// Lexical escapes ("..", backslashes, NUL) are rejected regardless
+	// Default: false
+	ConfineSymlinks bool
+
+	// MaxSymlinkDepth bounds how many symlinks ConfineSymlinks expands while
+	// resolving one path. A path through more, as any symlink cycle is, fails
+	// with NFSERR_MLINK, the NFS3 status closest to ELOOP
+	// Default: 40
+	MaxSymlinkDepth int
+
+	// MaxSymlinkResolutions bounds the symlinks ConfineSymlinks expands for
+	// one LOOKUP call across every path it resolves, so a tree of many short
+	// chains cannot cost more Readlink calls than this. Exceeding it fails
+	// the call with NFSERR_MLINK
+	// Default: 0 (only MaxSymlinkDepth applies)
+	MaxSymlinkResolutions int
+
+	// UIDMap translates UIDs between the client's namespace and the backing
+	// filesystem's, for containers whose IDs differ from the host's. Caller
+	// credentials and owners set by SETATTR or CREATE map client to backend;
+	// owners in returned attributes map back. With a map set, an ID outside
+	// every range becomes AnonUID
+	// Default: nil (IDs pass through unchanged)
+	UIDMap []IDMapEntry
+
+	// GIDMap is UIDMap for group IDs, auxiliary groups included; a GID
+	// outside every range becomes AnonGID
+	// Default: nil (IDs pass through unchanged)
+	GIDMap []IDMapEntry
+
+	// AnonUID is the UID of the anonymous user, as nfsd's "anonuid" export
+	// option sets: squashed callers, AUTH_NONE callers, unmapped RPCSEC_GSS
+	// principals and IDs outside UIDMap all become it. Zero selects the
+	// default, so root cannot be made the anonymous user
+	// Default: 65534 (nobody)
+	AnonUID uint32
+
+	// AnonGID is AnonUID for the group, as nfsd's "anongid" sets; squashed
+	// auxiliary groups become it too
+	// Default: 65534 (nogroup)
+	AnonGID uint32
+
+	// ReplayWindow rejects a call whose RPC verifier exactly repeats one the
+	// same client sent within this window, with an AUTH_REJECTEDVERF denial.
+	// Only non-null verifiers are tracked, so AUTH_NONE and AUTH_SYS calls
+	// (which carry a null verifier) are unaffected. Memory is capped per
+	// client and in total; past the cap the oldest verifiers are forgotten
+	// Default: 0 (no replay tracking)
+	ReplayWindow time.Duration
+
+	// DryRun logs CREATE, MKDIR, SYMLINK, WRITE, SETATTR, REMOVE, RMDIR and
+	// RENAME at info level instead of applying them. They reply NFS_OK with
+	// the attributes the change would have produced; the backing filesystem
+	// is never modified, so reads keep returning its unchanged contents
+	// Default: false
+	DryRun bool
+
+	// PersistentHandles derives each file handle from the file's identity
+	// instead of a per-process counter, so handles stay valid across a
+	// restart and clients need not remount. A handle unknown to a restarted
+	// server is recovered from an index of up to about a million paths,
+	// filled by a walk of the export started by New. A file deleted and
+	// recreated at the same path gets a new handle, and its old handles
+	// become stale. Allocating a handle costs one Lstat
+	// Cannot be turned on at runtime; false in an update keeps the current
+	// setting
+	// Default: false (handles are valid for the life of the process)
+	PersistentHandles bool
+
+	// HandleEncoder derives the handle for the file at path from its Lstat
+	// info when PersistentHandles is set. It must be deterministic across
+	// restarts and give a different handle to a recreated file. Only the
+	// low 48 bits are used; the top 16 hold the export number
+	// Default: nil (DefaultHandleEncoder: hash of path and inode number)
+	HandleEncoder func(path string, info os.FileInfo) uint64
+
+	// LogRPCOnError logs each NFS call that fails with its procedure, decoded
+	// arguments (handle and path, names, offsets, counts) and reply status, at
+	// warn level. Write payloads are logged by length only
+	// Entries go to the structured logger set by Log or SetLogger
+	// Default: false
+	LogRPCOnError bool
+
+	// MetricsSampleRate is the fraction (0.0-1.0) of operations whose latency
+	// is recorded, for READ/WRITE latency statistics and backing filesystem
+	// latency histograms. Operation and error counts are always exact; only
+	// the cost of timing every call is cut. Operations are sampled at random,
+	// so the recorded distributions stay representative
+	// Default: 1.0 (every operation is timed; 0 also means 1.0)
+	MetricsSampleRate float64
+
+	// TracerProvider, when set, traces each NFS call as a span named after
+	// its procedure (e.g. nfs.READ) carrying the reply status, with a child
+	// span around the backing filesystem read or write of READ and WRITE
+	// Default: nil (no spans)
+	TracerProvider trace.TracerProvider
+
+	// HealthThresholds sets the worker pool saturation, file handle usage
+	// and error rate at which HealthReport considers each Degraded and
+	// Unhealthy
+	// Default: zero fields take the defaults documented on HealthThresholds
+	HealthThresholds HealthThresholds
+
 	// Log holds the logging configuration for the NFS server
 	// When nil, logging is disabled (no-op logger is used)
 	// When provided, enables structured logging with configurable level, format, and output
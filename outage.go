@@ -0,0 +1,103 @@
+// outage.go: Backing filesystem outage detection.
+//
+// With OutageProbeInterval set, a goroutine Stats the export root on that
+// interval. After OutageThreshold consecutive failures the server enters
+// an outage and HandleCall answers every NFS call except NULL with
+// NFSERR_JUKEBOX, so clients back off instead of piling up timeouts
+// against a filesystem that cannot answer. The first successful probe
+// ends the outage. Each transition is logged and passed to OnOutageChange.
+package absnfs
+
+import (
+	"time"
+)
+
+// InOutage reports whether the backing filesystem is currently considered
+// unavailable by the outage probe
+func (n *AbsfsNFS) InOutage() bool {
+	return n.outage.Load()
+}
+
+// startOutageProbe starts the probe goroutine
+func (n *AbsfsNFS) startOutageProbe() {
+	n.outageStop = make(chan struct{})
+	n.outageDone = make(chan struct{})
+	go n.runOutageProbe(n.outageStop, n.outageDone)
+}
+
+// stopOutageProbe stops the probe goroutine, if running, and waits for it
+func (n *AbsfsNFS) stopOutageProbe() {
+	if n.outageStop == nil {
+		return
+	}
+	close(n.outageStop)
+	<-n.outageDone
+	n.outageStop = nil
+}
+
+// runOutageProbe probes the backing filesystem until stop is closed. A
+// probe still outstanding when the next one is due counts as a failure,
+// so a hung filesystem is detected without stacking up probe goroutines.
+func (n *AbsfsNFS) runOutageProbe(stop, done chan struct{}) {
+	defer close(done)
+
+	interval := n.tuning.Load().OutageProbeInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pending chan error
+	failures := 0
+	for {
+		if pending == nil {
+			pending = make(chan error, 1)
+			go func(result chan<- error) {
+				_, err := n.fs.Stat("/")
+				result <- err
+			}(pending)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		select {
+		case err = <-pending:
+			pending = nil
+		default:
+			err = ErrTimeout
+		}
+
+		tuning := n.tuning.Load()
+		if err == nil {
+			failures = 0
+			if n.outage.CompareAndSwap(true, false) {
+				n.outageChanged(tuning, false, nil)
+			}
+		} else if failures++; failures >= tuning.OutageThreshold && n.outage.CompareAndSwap(false, true) {
+			n.outageChanged(tuning, true, err)
+		}
+
+		if next := tuning.OutageProbeInterval; next > 0 && next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// outageChanged reports an outage transition
+func (n *AbsfsNFS) outageChanged(tuning *TuningOptions, outage bool, err error) {
+	if slog := n.getStructuredLogger(); slog != nil {
+		if outage {
+			slog.Error("backing filesystem outage: answering NFS calls with JUKEBOX",
+				LogField{Key: "error", Value: err})
+		} else {
+			slog.Info("backing filesystem recovered from outage")
+		}
+	}
+	if tuning.OnOutageChange != nil {
+		tuning.OnOutageChange(outage)
+	}
+}
@@ -0,0 +1,99 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+// flakyRootFS fails Stat of the root while failing is set
+type flakyRootFS struct {
+	*memfs.FileSystem
+	failing atomic.Bool
+}
+
+func (f *flakyRootFS) Stat(name string) (os.FileInfo, error) {
+	if name == "/" && f.failing.Load() {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.EIO}
+	}
+	return f.FileSystem.Stat(name)
+}
+
+func TestOutageDetection(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/file")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+
+	fs := &flakyRootFS{FileSystem: mfs}
+	events := make(chan bool, 4)
+	nfs, err := New(fs, ExportOptions{
+		OutageProbeInterval: 5 * time.Millisecond,
+		OutageThreshold:     2,
+		OnOutageChange:      func(outage bool) { events <- outage },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	h := &NFSProcedureHandler{server: server}
+	fileHandle := getFileHandle(server, "/file")
+
+	var read bytes.Buffer
+	xdrEncodeFileHandle(&read, fileHandle)
+	binary.Write(&read, binary.BigEndian, uint64(0))
+	binary.Write(&read, binary.BigEndian, uint32(5))
+	readStatus := func() uint32 {
+		return readStatusFromReply(callAs(t, h, "127.0.0.1", NFS_PROGRAM, NFS_V3, NFSPROC3_READ, read.Bytes()))
+	}
+
+	waitEvent := func(want bool) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("Expected outage=%v event, got %v", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for outage=%v event", want)
+		}
+	}
+
+	if status := readStatus(); status != NFS_OK {
+		t.Fatalf("Expected READ to succeed before the outage, got %d", status)
+	}
+
+	fs.failing.Store(true)
+	waitEvent(true)
+	if !nfs.InOutage() || nfs.IsHealthy() {
+		t.Error("Expected server to report an outage and be unhealthy")
+	}
+	if status := readStatus(); status != NFSERR_JUKEBOX {
+		t.Errorf("Expected NFSERR_JUKEBOX during outage, got %d", status)
+	}
+	if reply := callAs(t, h, "127.0.0.1", NFS_PROGRAM, NFS_V3, NFSPROC3_NULL, nil); reply.AcceptStatus != SUCCESS || reply.Data != nil {
+		t.Errorf("Expected NULL to be served during outage, got %+v", reply)
+	}
+
+	fs.failing.Store(false)
+	waitEvent(false)
+	if nfs.InOutage() {
+		t.Error("Expected outage to clear after recovery")
+	}
+	if status := readStatus(); status != NFS_OK {
+		t.Errorf("Expected READ to succeed after recovery, got %d", status)
+	}
+}
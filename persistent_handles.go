@@ -0,0 +1,293 @@
+// persistent_handles.go: File handles that survive a server restart.
+//
+// With ExportOptions.PersistentHandles, the handle for a file is derived
+// from its identity -- by default a hash of its path and inode number --
+// instead of a per-process counter. After a restart, a handle missing from
+// the FileHandleMap is recovered by finding the path it was derived from
+// in an index of handles, filled by a walk of the export started when the
+// server is created and by every derivation since. A handle is only
+// accepted if the file now at its path still derives the same handle, so a
+// file that was deleted and recreated (and so got a new inode) leaves old
+// handles stale rather than resolving to the new file. Two paths deriving
+// the same handle both fall back to per-process handles.
+package absnfs
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/absfs/absfs"
+)
+
+// maxIndexedPaths bounds the paths the handle index holds, and so the
+// startup walk. Files beyond it still get derived handles, but one evicted
+// from the FileHandleMap cannot be recovered.
+const maxIndexedPaths = 1 << 20
+
+// handleResolver derives handles for nodes and recovers nodes from handles
+// the FileHandleMap does not hold
+type handleResolver interface {
+	handleFor(node *NFSNode) (uint64, bool)
+	resolve(handle uint64) (*NFSNode, bool)
+}
+
+// handleIndex maps persistent handle IDs, the handle without its export
+// number, to the paths they were derived from
+type handleIndex struct {
+	mu       sync.Mutex
+	byHandle map[uint64]string
+	byPath   map[string]uint64
+	collided map[uint64]bool // IDs derived for more than one path
+	limit    int
+
+	// walked is closed when the startup walk that fills the index has
+	// finished or been abandoned; cancel abandons it
+	walked chan struct{}
+	cancel context.CancelFunc
+}
+
+func newHandleIndex(limit int) *handleIndex {
+	return &handleIndex{
+		byHandle: make(map[uint64]string),
+		byPath:   make(map[string]uint64),
+		collided: make(map[uint64]bool),
+		limit:    limit,
+		walked:   make(chan struct{}),
+		cancel:   func() {},
+	}
+}
+
+// note records that the file at p currently derives id. It reports false
+// if another indexed path derives id too; neither path is then indexed,
+// and id is never resolved again. A new path is not indexed once the
+// index is full.
+func (x *handleIndex) note(p string, id uint64) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.byPath[p]; ok && old != id {
+		delete(x.byHandle, old)
+		delete(x.byPath, p)
+	}
+	if q, ok := x.byHandle[id]; ok && q != p {
+		x.collided[id] = true
+		delete(x.byHandle, id)
+		delete(x.byPath, q)
+	}
+	if x.collided[id] {
+		return false
+	}
+	if _, ok := x.byPath[p]; !ok && len(x.byPath) >= x.limit {
+		return true
+	}
+	x.byPath[p] = id
+	x.byHandle[id] = p
+	return true
+}
+
+// forget drops id, whose file no longer exists
+func (x *handleIndex) forget(id uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if p, ok := x.byHandle[id]; ok {
+		delete(x.byHandle, id)
+		delete(x.byPath, p)
+	}
+}
+
+// forgetPath drops p, which was removed or renamed away, and with tree
+// everything indexed below it as well
+func (x *handleIndex) forgetPath(p string, tree bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if id, ok := x.byPath[p]; ok {
+		delete(x.byPath, p)
+		delete(x.byHandle, id)
+	}
+	if !tree {
+		return
+	}
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for q, id := range x.byPath {
+		if strings.HasPrefix(q, prefix) {
+			delete(x.byPath, q)
+			delete(x.byHandle, id)
+		}
+	}
+}
+
+// path returns the path id was derived from, if it has been seen
+func (x *handleIndex) path(id uint64) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p, ok := x.byHandle[id]
+	return p, ok
+}
+
+// current returns the ID last derived for the file at p
+func (x *handleIndex) current(p string) (uint64, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	id, ok := x.byPath[p]
+	return id, ok
+}
+
+// size returns the number of paths indexed
+func (x *handleIndex) size() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.byPath)
+}
+
+// DefaultHandleEncoder derives a handle from a hash of the file's path and
+// its inode number, which serves as the generation: a file deleted and
+// recreated at the same path gets a new inode and so a new handle. When
+// the backing filesystem reports no inode number only the path is hashed.
+func DefaultHandleEncoder(path string, info os.FileInfo) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	var gen [8]byte
+	binary.BigEndian.PutUint64(gen[:], inodeNumber(info))
+	h.Write(gen[:])
+	return h.Sum64()
+}
+
+// inodeNumber returns the Ino field of the value behind info.Sys(), as
+// found in syscall.Stat_t and in the inodes of absfs in-memory
+// filesystems, or 0 if there is none
+func inodeNumber(info os.FileInfo) uint64 {
+	ino, _ := sysField(info, "Ino")
+	return ino
+}
+
+// deriveID derives the handle ID for the file at p using the configured
+// HandleEncoder and records it in the index, reporting false if another
+// path derives the same ID
+func (s *AbsfsNFS) deriveID(p string, info os.FileInfo) (uint64, bool) {
+	encode := s.policy.Load().HandleEncoder
+	if encode == nil {
+		encode = DefaultHandleEncoder
+	}
+	id := encode(p, info) & handleIDMask
+	return id, s.handleIndex.note(p, id)
+}
+
+// noteIdentity refreshes the index from a fresh Lstat of p, so that a
+// recreated file is noticed whenever its attributes are refetched
+func (s *AbsfsNFS) noteIdentity(p string, info os.FileInfo) {
+	if s.handleIndex != nil {
+		s.deriveID(p, info)
+	}
+}
+
+// forgetIdentity drops p, and with tree everything below it, from the
+// index after a remove or rename
+func (s *AbsfsNFS) forgetIdentity(p string, tree bool) {
+	if s.handleIndex != nil {
+		s.handleIndex.forgetPath(p, tree)
+	}
+}
+
+// handleFor derives the persistent handle for node; the top bits of the
+// encoded value are replaced by the export number, as for every handle.
+// Synthetic shard directories, paths that cannot be stat'ed and paths
+// whose handle another path derives too get per-process handles.
+func (s *AbsfsNFS) handleFor(node *NFSNode) (uint64, bool) {
+	if node.shard != "" {
+		return 0, false
+	}
+	info, err := s.fs.Lstat(node.path)
+	if err != nil {
+		return 0, false
+	}
+	id, ok := s.deriveID(node.path, info)
+	if !ok {
+		return 0, false
+	}
+	return s.fileMap.prefix | id, true
+}
+
+// resolve recovers the node for a persistent handle the FileHandleMap does
+// not hold, once the startup walk has filled the index
+func (s *AbsfsNFS) resolve(handle uint64) (*NFSNode, bool) {
+	<-s.handleIndex.walked
+	id := handle & handleIDMask
+	p, ok := s.handleIndex.path(id)
+	if !ok {
+		return nil, false
+	}
+	info, err := s.fs.Lstat(p)
+	if err != nil {
+		s.handleIndex.forget(id)
+		return nil, false
+	}
+	if derived, ok := s.deriveID(p, info); !ok || derived != id {
+		return nil, false
+	}
+	node, err := s.Lookup(p)
+	if err != nil {
+		return nil, false
+	}
+	return node, true
+}
+
+// startWalk starts the walk that indexes the files already in the export,
+// so handles issued before a restart can be resolved
+func (s *AbsfsNFS) startWalk() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.handleIndex.cancel = cancel
+	go func() {
+		defer close(s.handleIndex.walked)
+		s.walkExport(ctx)
+	}()
+}
+
+// stopWalk abandons the startup walk and waits for it to exit
+func (s *AbsfsNFS) stopWalk() {
+	s.handleIndex.cancel()
+	<-s.handleIndex.walked
+}
+
+// walkExport indexes the handle of every file in the export until the
+// index is full or ctx is cancelled. Later files are indexed as they are
+// looked up or created.
+func (s *AbsfsNFS) walkExport(ctx context.Context) {
+	x := s.handleIndex
+	info, err := s.fs.Lstat("/")
+	if err != nil {
+		return
+	}
+	s.deriveID("/", info)
+	dirs := []string{"/"}
+	for len(dirs) > 0 {
+		if ctx.Err() != nil || x.size() >= x.limit {
+			return
+		}
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		f, err := s.fs.OpenFile(dir, os.O_RDONLY, 0)
+		if err != nil {
+			continue
+		}
+		var entries []os.FileInfo
+		if d, ok := f.(absfs.File); ok {
+			entries, _ = d.Readdir(-1)
+		}
+		f.Close()
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == "." || name == ".." {
+				continue
+			}
+			child := path.Join(dir, name)
+			s.deriveID(child, entry)
+			if entry.IsDir() {
+				dirs = append(dirs, child)
+			}
+		}
+	}
+}
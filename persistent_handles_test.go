@@ -0,0 +1,258 @@
+package absnfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// newPersistentNFS serves fs with PersistentHandles, as a server started
+// before or after a restart would, once its startup walk has finished
+func newPersistentNFS(t *testing.T, fs *memfs.FileSystem, opts ExportOptions) *AbsfsNFS {
+	t.Helper()
+	opts.PersistentHandles = true
+	nfs, err := New(fs, opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+	<-nfs.handleIndex.walked
+	return nfs
+}
+
+func writeTestFile(t *testing.T, fs *memfs.FileSystem, name, content string) {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatalf("create %s: %v", name, err)
+	}
+	f.Write([]byte(content))
+	f.Close()
+}
+
+func TestPersistentHandlesSurviveRestart(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.MkdirAll("/a/b", 0755)
+	writeTestFile(t, fs, "/a/b/file.txt", "data")
+
+	before := newPersistentNFS(t, fs, ExportOptions{})
+	node, err := before.Lookup("/a/b/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := before.fileMap.Allocate(node)
+	if again := before.fileMap.Allocate(node); again != handle {
+		t.Errorf("second Allocate = %d, want %d", again, handle)
+	}
+
+	// A fresh server has never seen the handle and finds it by walking
+	after := newPersistentNFS(t, fs, ExportOptions{})
+	f, err := after.fileMap.GetOrError(handle)
+	if err != nil {
+		t.Fatalf("handle from before the restart: %v", err)
+	}
+	if got := f.(*NFSNode).path; got != "/a/b/file.txt" {
+		t.Errorf("handle resolved to %q, want /a/b/file.txt", got)
+	}
+
+	// The restarted server allocates the same handle for the same file
+	node2, err := after.Lookup("/a/b/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := after.fileMap.Allocate(node2); got != handle {
+		t.Errorf("handle after restart = %d, want %d", got, handle)
+	}
+
+	if _, ok := after.fileMap.Get(handle ^ 1); ok {
+		t.Error("unknown handle resolved")
+	}
+}
+
+func TestPersistentHandleStaleAfterRecreate(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/file.txt", "old")
+
+	nfs := newPersistentNFS(t, fs, ExportOptions{})
+	node, err := nfs.Lookup("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := nfs.fileMap.Allocate(node)
+
+	fs.Remove("/file.txt")
+	writeTestFile(t, fs, "/file.txt", "new")
+
+	// After a restart the old handle does not reach the new file
+	restarted := newPersistentNFS(t, fs, ExportOptions{})
+	if _, ok := restarted.fileMap.Get(handle); ok {
+		t.Error("handle of the deleted file resolved to the recreated one after restart")
+	}
+
+	// Nor does it on the running server once the attributes are refetched
+	nfs.attrCache.Invalidate("/file.txt")
+	handler := &NFSProcedureHandler{server: &Server{handler: nfs}}
+	if _, ok := handler.lookupNode(handle); ok {
+		t.Error("handle of the deleted file resolved to the recreated one")
+	}
+	node, err = nfs.Lookup("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh := nfs.fileMap.Allocate(node); fresh == handle {
+		t.Error("recreated file got the handle of the deleted one")
+	}
+}
+
+func TestPersistentHandlesCustomEncoder(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/file.txt", "data")
+
+	encoder := func(path string, info os.FileInfo) uint64 {
+		if path == "/file.txt" {
+			return 0xabcdef
+		}
+		return DefaultHandleEncoder(path, info)
+	}
+	nfs := newPersistentNFS(t, fs, ExportOptions{HandleEncoder: encoder})
+	node, err := nfs.Lookup("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nfs.fileMap.Allocate(node); got != 0xabcdef {
+		t.Errorf("Allocate = %#x, want the encoder's 0xabcdef", got)
+	}
+}
+
+func TestPersistentHandlesImmutable(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Left false, PersistentHandles keeps its current setting
+	nfs := newPersistentNFS(t, fs, ExportOptions{})
+	opts := nfs.GetExportOptions()
+	opts.PersistentHandles = false
+	if err := nfs.UpdateExportOptions(opts); err != nil {
+		t.Errorf("update leaving PersistentHandles unset: %v", err)
+	}
+	if !nfs.GetExportOptions().PersistentHandles {
+		t.Error("PersistentHandles was turned off at runtime")
+	}
+
+	plain, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	opts = plain.GetExportOptions()
+	opts.PersistentHandles = true
+	if err := plain.UpdateExportOptions(opts); err == nil {
+		t.Error("turning PersistentHandles on at runtime succeeded")
+	}
+}
+
+func TestPersistentHandleCollision(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/a.txt", "a")
+	writeTestFile(t, fs, "/b.txt", "b")
+
+	encoder := func(path string, info os.FileInfo) uint64 {
+		if path == "/a.txt" || path == "/b.txt" {
+			return 0xabcdef
+		}
+		return DefaultHandleEncoder(path, info)
+	}
+	nfs := newPersistentNFS(t, fs, ExportOptions{HandleEncoder: encoder})
+	var handles []uint64
+	for _, name := range []string{"/a.txt", "/b.txt"} {
+		node, err := nfs.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handle := nfs.fileMap.Allocate(node)
+		if handle == 0xabcdef {
+			t.Errorf("%s got the handle both paths derive", name)
+		}
+		if again := nfs.fileMap.Allocate(node); again != handle {
+			t.Errorf("second Allocate of %s = %#x, want %#x", name, again, handle)
+		}
+		handles = append(handles, handle)
+	}
+	if handles[0] == handles[1] {
+		t.Errorf("both files got handle %#x", handles[0])
+	}
+
+	// The shared handle, as issued before the files collided, reaches neither
+	if _, ok := nfs.fileMap.Get(0xabcdef); ok {
+		t.Error("handle derived by two paths resolved")
+	}
+}
+
+func TestPersistentHandleIndexBounded(t *testing.T) {
+	x := newHandleIndex(2)
+	x.note("/d", 1)
+	x.note("/d/a", 2)
+	x.note("/d/b", 3)
+	if _, ok := x.current("/d/b"); ok || x.size() != 2 {
+		t.Errorf("index holds %d paths, /d/b indexed %v; want 2 and false", x.size(), ok)
+	}
+
+	// Removed and renamed paths leave the index, a directory with its tree
+	x.forgetPath("/d/a", false)
+	if _, ok := x.path(2); ok {
+		t.Error("removed path still indexed")
+	}
+	x.note("/d/b", 3)
+	x.forgetPath("/d", true)
+	if x.size() != 0 {
+		t.Errorf("index holds %d paths after forgetting /d, want 0", x.size())
+	}
+}
+
+func TestPersistentHandleIndexDropsRemoved(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.MkdirAll("/d", 0755)
+	writeTestFile(t, fs, "/d/file.txt", "data")
+	writeTestFile(t, fs, "/gone.txt", "data")
+
+	nfs := newPersistentNFS(t, fs, ExportOptions{})
+	for _, p := range []string{"/d", "/d/file.txt", "/gone.txt"} {
+		if _, ok := nfs.handleIndex.current(p); !ok {
+			t.Fatalf("%s not indexed by the startup walk", p)
+		}
+	}
+
+	root, err := nfs.Lookup("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nfs.Remove(root, "gone.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := nfs.Rename(root, "d", root, "e"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	for _, p := range []string{"/gone.txt", "/d", "/d/file.txt"} {
+		if _, ok := nfs.handleIndex.current(p); ok {
+			t.Errorf("%s still indexed", p)
+		}
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -60,8 +62,9 @@ type Portmapper struct {
 	wg         sync.WaitGroup
 	logger     *log.Logger
 	debug      atomic.Bool
-	listenAddr atomic.Value       // stores string; actual listen address for universal address construction
-	connSem    chan struct{}       // semaphore to limit concurrent connections
+	listenAddr atomic.Value                 // stores string; actual listen address for universal address construction
+	connSem    chan struct{}                // semaphore to limit concurrent connections
+	limiter    atomic.Pointer[PerIPLimiter] // per-source-IP request limit, nil = unlimited
 }
 
 // NewPortmapper creates a new portmapper instance
@@ -87,6 +90,31 @@ func (pm *Portmapper) SetListenAddr(addr string) {
 	pm.listenAddr.Store(addr)
 }
 
+// SetRateLimit limits each source IP to perSecond requests per second, with
+// bursts of the same size. Requests beyond the limit are dropped without a
+// reply, so the portmapper cannot be used to amplify traffic toward a
+// spoofed address. Zero or less removes the limit.
+func (pm *Portmapper) SetRateLimit(perSecond int) {
+	if perSecond <= 0 {
+		pm.limiter.Store(nil)
+		return
+	}
+	pm.limiter.Store(NewPerIPLimiter(float64(perSecond), perSecond, time.Minute))
+}
+
+// allowRequest reports whether a request from remoteAddr is within the rate limit
+func (pm *Portmapper) allowRequest(remoteAddr net.Addr) bool {
+	limiter := pm.limiter.Load()
+	if limiter == nil || remoteAddr == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		host = remoteAddr.String()
+	}
+	return limiter.Allow(host)
+}
+
 // RegisterService registers an RPC service with the portmapper
 func (pm *Portmapper) RegisterService(prog, vers, prot, port uint32) {
 	pm.mu.Lock()
@@ -306,6 +334,10 @@ func (pm *Portmapper) handleConnection(conn net.Conn) {
 }
 
 func (pm *Portmapper) handleCall(data []byte, remoteAddr net.Addr) ([]byte, error) {
+	if !pm.allowRequest(remoteAddr) {
+		return nil, fmt.Errorf("rate limit exceeded for %s", remoteAddr)
+	}
+
 	r := bytes.NewReader(data)
 
 	// Read RPC header
@@ -457,6 +489,41 @@ func (pm *Portmapper) encodePort(port uint32) []byte {
 	return buf.Bytes()
 }
 
+// universalAddr returns the universal address (RFC 3530 section 2.2) of port
+// on the listen address for netid: "h1.h2.h3.h4.p1.p2" for tcp and udp, and
+// "x1:x2:...:x8.p1.p2" for tcp6 and udp6. A listen address that is not an IP
+// of netid's family, such as a hostname, is given as the wildcard address.
+func (pm *Portmapper) universalAddr(netid string, port uint32) string {
+	ipv6 := netid == "tcp6" || netid == "udp6"
+	host := "0.0.0.0"
+	if ipv6 {
+		host = "::"
+	}
+	addr, _ := pm.listenAddr.Load().(string)
+	if ip := net.ParseIP(addr); ip != nil && (ip.To4() == nil) == ipv6 {
+		host = ip.String()
+	}
+	return fmt.Sprintf("%s.%d.%d", host, port/256, port%256)
+}
+
+// universalAddrPort returns the port of a universal address of either family
+func universalAddrPort(uaddr string) (uint32, bool) {
+	lo := strings.LastIndexByte(uaddr, '.')
+	if lo < 0 {
+		return 0, false
+	}
+	hi := strings.LastIndexByte(uaddr[:lo], '.')
+	if hi < 0 || net.ParseIP(uaddr[:hi]) == nil {
+		return 0, false
+	}
+	p1, err1 := strconv.ParseUint(uaddr[hi+1:lo], 10, 8)
+	p2, err2 := strconv.ParseUint(uaddr[lo+1:], 10, 8)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	return uint32(p1<<8 | p2), true
+}
+
 // encodeEmptyString encodes an empty XDR string (length 0)
 func (pm *Portmapper) encodeEmptyString() []byte {
 	var buf bytes.Buffer
@@ -566,7 +633,8 @@ func (pm *Portmapper) handleUnset(r io.Reader, remoteAddr net.Addr) []byte {
 }
 
 // handleGetAddr handles rpcbind v3/v4 GETADDR procedure
-// Returns universal address string like "0.0.0.0.8.1" for port 2049
+// Returns universal address string like "0.0.0.0.8.1" for port 2049, or
+// "::.8.1" for tcp6 and udp6
 func (pm *Portmapper) handleGetAddr(r io.Reader) []byte {
 	// Read rpcb structure per RFC 1833:
 	// r_prog: uint32
@@ -608,27 +676,10 @@ func (pm *Portmapper) handleGetAddr(r io.Reader) []byte {
 			prog, vers, netid, port)
 	}
 
-	// Return universal address as XDR string
-	// Format depends on netid:
-	// - tcp/udp: "h1.h2.h3.h4.port_hi.port_lo" (IPv4)
-	// - tcp6/udp6: "h1:h2:...:h8.port_hi.port_lo" (IPv6)
+	// Return universal address as XDR string; empty means not found
 	var uaddr string
 	if port > 0 {
-		portHi := port / 256
-		portLo := port % 256
-		addr, _ := pm.listenAddr.Load().(string)
-		if addr == "" {
-			addr = "0.0.0.0"
-		}
-		if netid == "tcp6" || netid == "udp6" {
-			// IPv6 format: ::1.port_hi.port_lo (localhost)
-			uaddr = fmt.Sprintf("::1.%d.%d", portHi, portLo)
-		} else {
-			// IPv4 format: addr.port_hi.port_lo
-			uaddr = fmt.Sprintf("%s.%d.%d", addr, portHi, portLo)
-		}
-	} else {
-		uaddr = "" // Empty string means not found
+		uaddr = pm.universalAddr(netid, port)
 	}
 
 	var buf bytes.Buffer
@@ -665,12 +716,8 @@ func (pm *Portmapper) handleRpcbSet(r io.Reader) []byte {
 		prot = IPPROTO_UDP
 	}
 
-	// Parse port from uaddr
-	if uaddr != "" {
-		var a, b, c, d, hi, lo int
-		if _, err := fmt.Sscanf(uaddr, "%d.%d.%d.%d.%d.%d", &a, &b, &c, &d, &hi, &lo); err == nil {
-			port = uint32(hi*256 + lo)
-		}
+	if p, ok := universalAddrPort(uaddr); ok {
+		port = p
 	}
 
 	if port > 0 {
@@ -732,14 +779,7 @@ func (pm *Portmapper) handleRpcbDump() []byte {
 		xdrEncodeString(&buf, netid)
 
 		// uaddr - universal address format
-		portHi := m.Port / 256
-		portLo := m.Port % 256
-		addr, _ := pm.listenAddr.Load().(string)
-		if addr == "" {
-			addr = "0.0.0.0"
-		}
-		uaddr := fmt.Sprintf("%s.%d.%d", addr, portHi, portLo)
-		xdrEncodeString(&buf, uaddr)
+		xdrEncodeString(&buf, pm.universalAddr(netid, m.Port))
 
 		// owner
 		xdrEncodeString(&buf, "superuser")
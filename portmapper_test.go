@@ -1115,3 +1115,95 @@ func TestSkipAuth(t *testing.T) {
 		}
 	})
 }
+
+func TestPortmapperRateLimitPerIP(t *testing.T) {
+	pm := NewPortmapper()
+	pm.SetRateLimit(5)
+
+	var call bytes.Buffer
+	binary.Write(&call, binary.BigEndian, uint32(1))
+	binary.Write(&call, binary.BigEndian, uint32(RPC_CALL))
+	binary.Write(&call, binary.BigEndian, uint32(2))
+	binary.Write(&call, binary.BigEndian, uint32(PortmapperProgram))
+	binary.Write(&call, binary.BigEndian, uint32(PortmapperVersion))
+	binary.Write(&call, binary.BigEndian, uint32(PMAPPROC_NULL))
+	binary.Write(&call, binary.BigEndian, uint32(0)) // cred flavor
+	binary.Write(&call, binary.BigEndian, uint32(0)) // cred length
+	binary.Write(&call, binary.BigEndian, uint32(0)) // verf flavor
+	binary.Write(&call, binary.BigEndian, uint32(0)) // verf length
+
+	answered := func(addr string, n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			reply, err := pm.handleCall(call.Bytes(), &fakeAddr{addr: addr})
+			if err == nil && reply != nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := answered("192.0.2.1:700", 50); got != 5 {
+		t.Errorf("Flooding IP got %d of 50 requests answered, want 5", got)
+	}
+	// The limit is per IP, not per port
+	if got := answered("192.0.2.1:701", 1); got != 0 {
+		t.Errorf("Flooding IP on a new port got %d answered, want 0", got)
+	}
+	if got := answered("192.0.2.2:700", 5); got != 5 {
+		t.Errorf("Other IP got %d of 5 requests answered, want 5", got)
+	}
+
+	pm.SetRateLimit(0)
+	if got := answered("192.0.2.1:700", 10); got != 10 {
+		t.Errorf("Without a limit got %d of 10 answered, want 10", got)
+	}
+}
+
+func TestPortmapperIPv6UniversalAddr(t *testing.T) {
+	getaddr := func(pm *Portmapper, netid string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, uint32(100003))
+		binary.Write(&buf, binary.BigEndian, uint32(3))
+		xdrEncodeString(&buf, netid)
+		xdrEncodeString(&buf, "")
+		xdrEncodeString(&buf, "")
+		uaddr, err := xdrDecodeString(bytes.NewReader(pm.handleGetAddr(&buf)))
+		if err != nil {
+			t.Fatalf("Failed to decode uaddr: %v", err)
+		}
+		return uaddr
+	}
+
+	pm := NewPortmapper()
+	pm.RegisterService(100003, 3, IPPROTO_TCP, 2049)
+	pm.RegisterService(100003, 3, IPPROTO_UDP, 2049)
+	for _, tt := range []struct {
+		listenAddr, netid, want string
+	}{
+		{"", "tcp6", "::.8.1"},
+		{"", "udp6", "::.8.1"},
+		{"fd00::5", "tcp6", "fd00::5.8.1"},
+		{"fd00::5", "tcp", "0.0.0.0.8.1"},
+		{"10.0.0.5", "tcp6", "::.8.1"},
+		{"localhost", "tcp", "0.0.0.0.8.1"},
+	} {
+		pm.SetListenAddr(tt.listenAddr)
+		if got := getaddr(pm, tt.netid); got != tt.want {
+			t.Errorf("GETADDR %s with listen address %q = %q, want %q", tt.netid, tt.listenAddr, got, tt.want)
+		}
+	}
+
+	// SET takes the port from an IPv6 universal address too
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(100099))
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	xdrEncodeString(&buf, "tcp6")
+	xdrEncodeString(&buf, "fd00::5.3.233")
+	xdrEncodeString(&buf, "")
+	pm.handleRpcbSet(&buf)
+	if port := pm.GetPort(100099, 1, IPPROTO_TCP); port != 1001 {
+		t.Errorf("Registered port from IPv6 uaddr = %d, want 1001", port)
+	}
+}
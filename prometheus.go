@@ -0,0 +1,202 @@
+// prometheus.go: Prometheus text exposition of the collected metrics.
+//
+// PrometheusHandler serves per-procedure call counts, counts by reply
+// status and latency histograms, backing-filesystem latency and worker
+// queue wait summaries, cache hit counts and ratios, open file
+// handles and worker pool occupancy in the Prometheus text format (version
+// 0.0.4). The format is written directly, so scraping needs no client
+// library.
+package absnfs
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// PrometheusHandler returns an http.Handler that serves the metrics in the
+// Prometheus text format. Mount it wherever suits, e.g.
+// mux.Handle("/metrics", nfs.PrometheusHandler()).
+func (n *AbsfsNFS) PrometheusHandler() http.Handler {
+	return n.metrics.PrometheusHandler()
+}
+
+// PrometheusHandler returns an http.Handler that serves the collector's
+// metrics in the Prometheus text format
+func (m *MetricsCollector) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		m.writePrometheus(bw)
+		bw.Flush()
+	})
+}
+
+// promFloat formats v as Prometheus expects
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// promHeader writes the HELP and TYPE lines of a metric family
+func promHeader(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// summaryQuantiles are the quantiles exported for latencyHistogram summaries
+var summaryQuantiles = [...]float64{0.5, 0.95, 0.99}
+
+// writeSummary writes h as the samples of summary name, with labels (if
+// any) leading each sample's label set
+func writeSummary(w *bufio.Writer, name, labels string, h *latencyHistogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for _, q := range summaryQuantiles {
+		fmt.Fprintf(w, "%s{%s%squantile=%q} %s\n", name, labels, sep, promFloat(q), promFloat(h.percentile(q).Seconds()))
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, promFloat(h.sum.Seconds()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.total)
+}
+
+// writePrometheus writes every metric family to w
+func (m *MetricsCollector) writePrometheus(w *bufio.Writer) {
+	// Snapshot the per-procedure maps, in a stable order
+	m.opMutex.Lock()
+	counts := make(map[string]uint64, len(m.opCounts))
+	for op, c := range m.opCounts {
+		counts[op] = c
+	}
+	latencies := make(map[string]opLatency, len(m.opLatencies))
+	for op, h := range m.opLatencies {
+		latencies[op] = *h
+	}
+	statuses := make([]opStatus, 0, len(m.opStatusCounts))
+	statusCounts := make(map[opStatus]uint64, len(m.opStatusCounts))
+	for key, c := range m.opStatusCounts {
+		statuses = append(statuses, key)
+		statusCounts[key] = c
+	}
+	m.opMutex.Unlock()
+
+	m.fsLatencyMutex.Lock()
+	fsLatencies := make(map[string]latencyHistogram, len(m.fsLatencies))
+	fsOps := make([]string, 0, len(m.fsLatencies))
+	for op, h := range m.fsLatencies {
+		fsLatencies[op] = *h
+		fsOps = append(fsOps, op)
+	}
+	m.fsLatencyMutex.Unlock()
+	sort.Strings(fsOps)
+
+	m.queueWaitMutex.Lock()
+	queueWait := m.queueWait
+	m.queueWaitMutex.Unlock()
+
+	ops := make([]string, 0, len(counts))
+	for op := range counts {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	promHeader(w, "absnfs_operations_total", "counter", "NFS procedure calls handled.")
+	for _, op := range ops {
+		fmt.Fprintf(w, "absnfs_operations_total{op=%q} %d\n", op, counts[op])
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].op != statuses[j].op {
+			return statuses[i].op < statuses[j].op
+		}
+		return statuses[i].status < statuses[j].status
+	})
+	promHeader(w, "absnfs_operation_status_total", "counter", "NFS procedure calls by the status they were answered with.")
+	for _, key := range statuses {
+		fmt.Fprintf(w, "absnfs_operation_status_total{op=%q,status=%q} %d\n", key.op, key.status, statusCounts[key])
+	}
+
+	promHeader(w, "absnfs_op_latency_seconds", "histogram", "Time taken to handle an NFS procedure call, for the calls MetricsSampleRate samples.")
+	for _, op := range ops {
+		h, ok := latencies[op]
+		if !ok {
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range opLatencyBounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "absnfs_op_latency_seconds_bucket{op=%q,le=%q} %d\n", op, promFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "absnfs_op_latency_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.total)
+		fmt.Fprintf(w, "absnfs_op_latency_seconds_sum{op=%q} %s\n", op, promFloat(h.sum.Seconds()))
+		fmt.Fprintf(w, "absnfs_op_latency_seconds_count{op=%q} %d\n", op, h.total)
+	}
+
+	promHeader(w, "absnfs_fs_latency_seconds", "summary", "Time taken by calls into the backing filesystem, per operation.")
+	for _, op := range fsOps {
+		h := fsLatencies[op]
+		writeSummary(w, "absnfs_fs_latency_seconds", fmt.Sprintf("op=%q", op), &h)
+	}
+
+	promHeader(w, "absnfs_queue_wait_seconds", "summary", "Time tasks waited in the worker pool queue before a worker picked them up.")
+	writeSummary(w, "absnfs_queue_wait_seconds", "", &queueWait)
+
+	caches := []struct {
+		name         string
+		hits, misses uint64
+	}{
+		{"attr", atomic.LoadUint64(&m.attrCacheHits), atomic.LoadUint64(&m.attrCacheMisses)},
+		{"dir", atomic.LoadUint64(&m.dirCacheHits), atomic.LoadUint64(&m.dirCacheMisses)},
+		{"negative", atomic.LoadUint64(&m.negativeCacheHits), atomic.LoadUint64(&m.negativeCacheMisses)},
+	}
+	promHeader(w, "absnfs_cache_hits_total", "counter", "Cache lookups answered from the cache.")
+	for _, c := range caches {
+		fmt.Fprintf(w, "absnfs_cache_hits_total{cache=%q} %d\n", c.name, c.hits)
+	}
+	promHeader(w, "absnfs_cache_misses_total", "counter", "Cache lookups the cache could not answer.")
+	for _, c := range caches {
+		fmt.Fprintf(w, "absnfs_cache_misses_total{cache=%q} %d\n", c.name, c.misses)
+	}
+	promHeader(w, "absnfs_cache_hit_ratio", "gauge", "Fraction of cache lookups that hit since start.")
+	for _, c := range caches {
+		var ratio float64
+		if c.hits+c.misses > 0 {
+			ratio = float64(c.hits) / float64(c.hits+c.misses)
+		}
+		fmt.Fprintf(w, "absnfs_cache_hit_ratio{cache=%q} %s\n", c.name, promFloat(ratio))
+	}
+
+	var handles, workers, maxWorkers, active, queued, throttled int
+	if m.server != nil {
+		handles = m.server.fileMap.Count()
+		if m.server.workerPool != nil {
+			workers, active, queued = m.server.workerPool.Stats()
+			maxWorkers = m.server.workerPool.Ceiling()
+		}
+		if rl := m.server.rateLimiter; rl != nil && m.server.policy.Load().EnableRateLimiting {
+			throttled = rl.ThrottledClients()
+		}
+	}
+	promHeader(w, "absnfs_active_file_handles", "gauge", "File handles currently allocated.")
+	fmt.Fprintf(w, "absnfs_active_file_handles %d\n", handles)
+	promHeader(w, "absnfs_worker_queue_depth", "gauge", "Tasks waiting for a worker.")
+	fmt.Fprintf(w, "absnfs_worker_queue_depth %d\n", queued)
+	promHeader(w, "absnfs_workers_active", "gauge", "Workers currently running a task.")
+	fmt.Fprintf(w, "absnfs_workers_active %d\n", active)
+	promHeader(w, "absnfs_workers", "gauge", "Workers in the pool.")
+	fmt.Fprintf(w, "absnfs_workers %d\n", workers)
+	promHeader(w, "absnfs_workers_max", "gauge", "Most workers the pool may grow to.")
+	fmt.Fprintf(w, "absnfs_workers_max %d\n", maxWorkers)
+	promHeader(w, "absnfs_throttled_clients", "gauge", "Clients currently refused READ or WRITE by their per-client rate limit.")
+	fmt.Fprintf(w, "absnfs_throttled_clients %d\n", throttled)
+}
@@ -0,0 +1,170 @@
+package absnfs
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	srv, h, auth := setupHandlerEnv(t)
+	nfs := srv.handler
+	dir := allocHandle(t, srv, "/dir")
+
+	var args bytes.Buffer
+	xdrEncodeFileHandle(&args, dir)
+	for i := 0; i < 2; i++ {
+		callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_GETATTR, args.Bytes())
+	}
+	xdrEncodeString(&args, "file.txt")
+	callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_LOOKUP, args.Bytes())
+	nfs.metrics.RecordDirCacheHit()
+	nfs.metrics.RecordDirCacheMiss()
+	nfs.metrics.RecordDirCacheMiss()
+	nfs.metrics.RecordDirCacheMiss()
+
+	ts := httptest.NewServer(nfs.PrometheusHandler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+
+	samples := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample line %q", line)
+		}
+		samples[line[:i]] = line[i+1:]
+	}
+	for series, want := range map[string]string{
+		`absnfs_operations_total{op="GETATTR"}`:                    "2",
+		`absnfs_operations_total{op="LOOKUP"}`:                     "1",
+		`absnfs_op_latency_seconds_count{op="GETATTR"}`:            "2",
+		`absnfs_op_latency_seconds_bucket{op="GETATTR",le="+Inf"}`: "2",
+		`absnfs_cache_hits_total{cache="dir"}`:                     "1",
+		`absnfs_cache_misses_total{cache="dir"}`:                   "3",
+		`absnfs_cache_hit_ratio{cache="dir"}`:                      "0.25",
+		`absnfs_active_file_handles`:                               strconv.Itoa(nfs.fileMap.Count()),
+	} {
+		if got, ok := samples[series]; !ok {
+			t.Errorf("%s missing from the exposition", series)
+		} else if got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+	if _, ok := samples["absnfs_worker_queue_depth"]; !ok {
+		t.Error("absnfs_worker_queue_depth missing from the exposition")
+	}
+
+	// Buckets are cumulative and end at the call count
+	var last uint64
+	for _, bound := range opLatencyBounds {
+		v, err := strconv.ParseUint(samples[`absnfs_op_latency_seconds_bucket{op="LOOKUP",le="`+promFloat(bound)+`"}`], 10, 64)
+		if err != nil || v < last || v > 1 {
+			t.Fatalf("LOOKUP bucket le=%v = %d, %v after %d", bound, v, err, last)
+		}
+		last = v
+	}
+	if last != 1 {
+		t.Errorf("LOOKUP le=10 bucket = %d, want the one call", last)
+	}
+
+	resp, err = http.Post(ts.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestPrometheusOperationStatus(t *testing.T) {
+	srv, h, auth := setupHandlerEnv(t)
+	nfs := srv.handler
+	dir := allocHandle(t, srv, "/dir")
+
+	remove := func(name string) uint32 {
+		var args bytes.Buffer
+		xdrEncodeFileHandle(&args, dir)
+		xdrEncodeString(&args, name)
+		status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_REMOVE, args.Bytes()))
+		return status
+	}
+	if status := remove("file.txt"); status != NFS_OK {
+		t.Fatalf("REMOVE file.txt: status %d", status)
+	}
+	if status := remove("missing.txt"); status != NFSERR_NOENT {
+		t.Fatalf("REMOVE missing.txt: status %d, want NOENT", status)
+	}
+
+	if got := nfs.OperationStatusCount("REMOVE", "OK"); got != 1 {
+		t.Errorf("REMOVE OK count = %d, want 1", got)
+	}
+	if got := nfs.OperationStatusCount("REMOVE", "NOENT"); got != 1 {
+		t.Errorf("REMOVE NOENT count = %d, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	nfs.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`absnfs_operation_status_total{op="REMOVE",status="NOENT"} 1`,
+		`absnfs_operation_status_total{op="REMOVE",status="OK"} 1`,
+		`absnfs_operations_total{op="REMOVE"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("exposition lacks %q", line)
+		}
+	}
+}
+
+func TestPrometheusFSLatencyAndQueueWait(t *testing.T) {
+	m := NewMetricsCollector(nil)
+	for i := 0; i < 3; i++ {
+		m.RecordFSLatency("Lstat", 2*time.Millisecond)
+	}
+	m.RecordFSLatency("Lstat", 40*time.Millisecond)
+	m.RecordQueueWait(500 * time.Microsecond)
+	m.RecordQueueWait(1500 * time.Microsecond)
+
+	rec := httptest.NewRecorder()
+	m.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	sec := func(d time.Duration) string { return promFloat(d.Seconds()) }
+	p50, _, p99 := m.FSLatencyPercentiles("Lstat")
+	for _, line := range []string{
+		"# TYPE absnfs_fs_latency_seconds summary",
+		`absnfs_fs_latency_seconds{op="Lstat",quantile="0.5"} ` + sec(p50),
+		`absnfs_fs_latency_seconds{op="Lstat",quantile="0.99"} ` + sec(p99),
+		`absnfs_fs_latency_seconds_sum{op="Lstat"} 0.046`,
+		`absnfs_fs_latency_seconds_count{op="Lstat"} 4`,
+		"# TYPE absnfs_queue_wait_seconds summary",
+		`absnfs_queue_wait_seconds{quantile="0.99"} ` + sec(bucketValue(latencyBucket(1500*time.Microsecond))),
+		`absnfs_queue_wait_seconds_sum 0.002`,
+		`absnfs_queue_wait_seconds_count 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("exposition lacks %q", line)
+		}
+	}
+	if p50 == p99 {
+		t.Errorf("p50 and p99 both %v, want the 40ms sample to raise p99", p50)
+	}
+}
@@ -6,6 +6,7 @@
 package absnfs
 
 import (
+	"container/list"
 	"sync"
 	"time"
 )
@@ -27,6 +28,15 @@ type RateLimiterConfig struct {
 	ReadLargeOpsPerSecond  int // Large reads (>64KB) per second per IP
 	WriteLargeOpsPerSecond int // Large writes (>64KB) per second per IP
 	ReaddirOpsPerSecond    int // READDIR operations per second per IP
+	MetadataOpsPerSecond   int // GETATTR, LOOKUP, ACCESS and READLINK per second per IP (0 = unlimited)
+	MetadataBurstSize      int // Burst allowance for metadata operations per IP (0 = MetadataOpsPerSecond)
+
+	// Per-client data operation limits
+	PerClientReadOpsPerSecond  int           // READs of any size per second per client (0 = unlimited)
+	PerClientWriteOpsPerSecond int           // WRITEs of any size per second per client (0 = unlimited)
+	PerClientBurstSize         int           // Burst allowance for each of them (0 = the per-second rate)
+	MaxTrackedClients          int           // Clients whose buckets are kept, least recently seen evicted first (0 = 10000)
+	ClientIdleTTL              time.Duration // How long an idle client's buckets are kept (0 = 10 minutes)
 
 	// Mount operation limits
 	MountOpsPerMinute int // MOUNT operations per minute per IP
@@ -50,6 +60,8 @@ func DefaultRateLimiterConfig() RateLimiterConfig {
 		ReadLargeOpsPerSecond:          100,
 		WriteLargeOpsPerSecond:         50,
 		ReaddirOpsPerSecond:            50, // Increased for directory listings
+		MaxTrackedClients:              10000,
+		ClientIdleTTL:                  10 * time.Minute,
 		MountOpsPerMinute:              10,
 		FileHandlesPerIP:               10000,
 		FileHandlesGlobal:              1000000,
@@ -284,6 +296,9 @@ const (
 	OpTypeWriteLarge OperationType = "write_large" // WRITE >64KB
 	OpTypeReaddir    OperationType = "readdir"     // READDIR
 	OpTypeMount      OperationType = "mount"       // MOUNT operations
+	OpTypeMetadata   OperationType = "metadata"    // GETATTR, LOOKUP, ACCESS, READLINK
+	OpTypeRead       OperationType = "read"        // READ of any size, budgeted per client
+	OpTypeWrite      OperationType = "write"       // WRITE of any size, budgeted per client
 )
 
 // PerOperationLimiter manages rate limiters per operation type per IP
@@ -303,6 +318,7 @@ func NewPerOperationLimiter(config RateLimiterConfig) *PerOperationLimiter {
 		OpTypeWriteLarge: float64(config.WriteLargeOpsPerSecond),
 		OpTypeReaddir:    float64(config.ReaddirOpsPerSecond),
 		OpTypeMount:      float64(config.MountOpsPerMinute) / 60.0, // Convert to per-second
+		OpTypeMetadata:   float64(config.MetadataOpsPerSecond),
 	}
 
 	bursts := map[OperationType]int{
@@ -310,6 +326,10 @@ func NewPerOperationLimiter(config RateLimiterConfig) *PerOperationLimiter {
 		OpTypeWriteLarge: 5,
 		OpTypeReaddir:    5,
 		OpTypeMount:      2,
+		OpTypeMetadata:   config.MetadataBurstSize,
+	}
+	if bursts[OpTypeMetadata] <= 0 {
+		bursts[OpTypeMetadata] = config.MetadataOpsPerSecond
 	}
 
 	return &PerOperationLimiter{
@@ -365,6 +385,139 @@ func (pol *PerOperationLimiter) cleanup() {
 	}
 }
 
+// ClientLimiter budgets READ and WRITE per client, so one client moving a
+// lot of data cannot use up the server's share for everyone else. It keeps
+// buckets for at most maxClients clients, evicting the least recently seen,
+// and drops those of clients idle for longer than idleTTL.
+type ClientLimiter struct {
+	mu         sync.Mutex
+	clients    map[string]*list.Element // of *clientBuckets
+	lru        *list.List               // most recently seen first
+	rates      map[OperationType]float64
+	bursts     map[OperationType]int
+	maxClients int
+	idleTTL    time.Duration
+}
+
+// clientBuckets are one client's buckets, and the operation it was last
+// refused, if its last call was refused
+type clientBuckets struct {
+	ip        string
+	buckets   map[OperationType]*TokenBucket
+	lastSeen  time.Time
+	throttled OperationType
+}
+
+// NewClientLimiter creates a per-client limiter for OpTypeRead and
+// OpTypeWrite from config
+func NewClientLimiter(config RateLimiterConfig) *ClientLimiter {
+	cl := &ClientLimiter{
+		clients: make(map[string]*list.Element),
+		lru:     list.New(),
+		rates: map[OperationType]float64{
+			OpTypeRead:  float64(config.PerClientReadOpsPerSecond),
+			OpTypeWrite: float64(config.PerClientWriteOpsPerSecond),
+		},
+		bursts:     make(map[OperationType]int),
+		maxClients: config.MaxTrackedClients,
+		idleTTL:    config.ClientIdleTTL,
+	}
+	for op, rate := range cl.rates {
+		cl.bursts[op] = config.PerClientBurstSize
+		if cl.bursts[op] <= 0 {
+			cl.bursts[op] = int(rate)
+		}
+	}
+	if cl.maxClients <= 0 {
+		cl.maxClients = 10000
+	}
+	if cl.idleTTL <= 0 {
+		cl.idleTTL = 10 * time.Minute
+	}
+	return cl
+}
+
+// Allow checks if an operation from the given client can proceed.
+// Operations without a per-client rate always can.
+func (cl *ClientLimiter) Allow(ip string, opType OperationType) bool {
+	if cl.rates[opType] <= 0 {
+		return true
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := time.Now()
+	cl.evictIdle(now)
+
+	var c *clientBuckets
+	if elem, ok := cl.clients[ip]; ok {
+		cl.lru.MoveToFront(elem)
+		c = elem.Value.(*clientBuckets)
+	} else {
+		c = &clientBuckets{ip: ip, buckets: make(map[OperationType]*TokenBucket)}
+		cl.clients[ip] = cl.lru.PushFront(c)
+		if cl.lru.Len() > cl.maxClients {
+			cl.remove(cl.lru.Back())
+		}
+	}
+	c.lastSeen = now
+
+	bucket, ok := c.buckets[opType]
+	if !ok {
+		bucket = NewTokenBucket(cl.rates[opType], cl.bursts[opType])
+		c.buckets[opType] = bucket
+	}
+	if !bucket.Allow() {
+		c.throttled = opType
+		return false
+	}
+	c.throttled = ""
+	return true
+}
+
+// evictIdle drops the buckets of clients not seen for idleTTL, which sit
+// at the back of the list
+func (cl *ClientLimiter) evictIdle(now time.Time) {
+	for elem := cl.lru.Back(); elem != nil; elem = cl.lru.Back() {
+		if now.Sub(elem.Value.(*clientBuckets).lastSeen) <= cl.idleTTL {
+			return
+		}
+		cl.remove(elem)
+	}
+}
+
+// remove drops the client held by elem
+func (cl *ClientLimiter) remove(elem *list.Element) {
+	cl.lru.Remove(elem)
+	delete(cl.clients, elem.Value.(*clientBuckets).ip)
+}
+
+// TrackedClients returns the number of clients whose buckets are kept
+func (cl *ClientLimiter) TrackedClients() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.evictIdle(time.Now())
+	return cl.lru.Len()
+}
+
+// ThrottledClients returns the number of clients whose last call was
+// refused and who have not yet earned the token to retry it
+func (cl *ClientLimiter) ThrottledClients() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.evictIdle(time.Now())
+	n := 0
+	for elem := cl.lru.Front(); elem != nil; elem = elem.Next() {
+		c := elem.Value.(*clientBuckets)
+		if c.throttled != "" && c.buckets[c.throttled].Tokens() < 1 {
+			n++
+		}
+	}
+	return n
+}
+
 // RateLimiter manages all rate limiting for the NFS server
 type RateLimiter struct {
 	config               RateLimiterConfig
@@ -372,6 +525,7 @@ type RateLimiter struct {
 	perIPLimiter         *PerIPLimiter
 	perConnectionLimiter sync.Map // map[connID]*TokenBucket
 	perOperationLimiter  *PerOperationLimiter
+	clientLimiter        *ClientLimiter
 	fileHandlesPerIP     sync.Map // map[IP]int
 	fileHandlesGlobal    int
 	fileHandlesMu        sync.Mutex
@@ -384,6 +538,7 @@ func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
 		globalLimiter:       NewTokenBucket(float64(config.GlobalRequestsPerSecond), config.GlobalRequestsPerSecond),
 		perIPLimiter:        NewPerIPLimiter(float64(config.PerIPRequestsPerSecond), config.PerIPBurstSize, config.CleanupInterval),
 		perOperationLimiter: NewPerOperationLimiter(config),
+		clientLimiter:       NewClientLimiter(config),
 	}
 }
 
@@ -419,8 +574,23 @@ func (rl *RateLimiter) AllowRequest(ip string, connID string) bool {
 	return true
 }
 
-// AllowOperation checks if a specific operation type should be allowed
+// allowDatagram checks the global and per-IP limits for a request that
+// arrived over UDP, which has no connection to limit
+func (rl *RateLimiter) allowDatagram(ip string) bool {
+	return rl.globalLimiter.Allow() && rl.perIPLimiter.Allow(ip)
+}
+
+// AllowOperation checks if a specific operation type should be allowed.
+// OpTypeRead and OpTypeWrite are budgeted per client; the global and
+// per-IP request limits AllowRequest applies bound them from outside.
 func (rl *RateLimiter) AllowOperation(ip string, opType OperationType) bool {
+	if opType == OpTypeRead || opType == OpTypeWrite {
+		return rl.clientLimiter.Allow(ip, opType)
+	}
+	// Metadata operations are only budgeted when a rate is configured
+	if opType == OpTypeMetadata && rl.config.MetadataOpsPerSecond <= 0 {
+		return true
+	}
 	return rl.perOperationLimiter.Allow(ip, opType)
 }
 
@@ -471,12 +641,20 @@ func (rl *RateLimiter) CleanupConnection(connID string) {
 	rl.perConnectionLimiter.Delete(connID)
 }
 
+// ThrottledClients returns the number of clients currently refused READ
+// or WRITE by their per-client budget
+func (rl *RateLimiter) ThrottledClients() int {
+	return rl.clientLimiter.ThrottledClients()
+}
+
 // GetStats returns rate limiter statistics
 func (rl *RateLimiter) GetStats() map[string]interface{} {
 	stats := make(map[string]interface{})
 
 	stats["global_tokens"] = rl.globalLimiter.Tokens()
 	stats["per_ip_stats"] = rl.perIPLimiter.GetStats()
+	stats["tracked_clients"] = rl.clientLimiter.TrackedClients()
+	stats["throttled_clients"] = rl.clientLimiter.ThrottledClients()
 
 	rl.fileHandlesMu.Lock()
 	stats["file_handles_global"] = rl.fileHandlesGlobal
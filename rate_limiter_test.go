@@ -191,6 +191,70 @@ func TestPerOperationLimiter(t *testing.T) {
 	})
 }
 
+func TestClientLimiter(t *testing.T) {
+	config := RateLimiterConfig{
+		PerClientReadOpsPerSecond:  1,
+		PerClientWriteOpsPerSecond: 0,
+		PerClientBurstSize:         3,
+		MaxTrackedClients:          2,
+		ClientIdleTTL:              50 * time.Millisecond,
+	}
+
+	t.Run("budgets reads per client", func(t *testing.T) {
+		cl := NewClientLimiter(config)
+		for i := 0; i < 3; i++ {
+			if !cl.Allow("10.0.0.1", OpTypeRead) {
+				t.Fatalf("read %d within burst denied", i)
+			}
+		}
+		if cl.Allow("10.0.0.1", OpTypeRead) {
+			t.Error("read past burst allowed")
+		}
+		if !cl.Allow("10.0.0.1", OpTypeWrite) {
+			t.Error("write denied with no per-client write rate")
+		}
+		if !cl.Allow("10.0.0.2", OpTypeRead) {
+			t.Error("read from another client denied")
+		}
+		if got := cl.ThrottledClients(); got != 1 {
+			t.Errorf("ThrottledClients = %d, want 1", got)
+		}
+	})
+
+	t.Run("evicts least recently seen past MaxTrackedClients", func(t *testing.T) {
+		cl := NewClientLimiter(config)
+		for i := 0; i < 4; i++ {
+			cl.Allow("10.0.0.1", OpTypeRead)
+		}
+		cl.Allow("10.0.0.2", OpTypeRead)
+		cl.Allow("10.0.0.3", OpTypeRead)
+		if got := cl.TrackedClients(); got != 2 {
+			t.Errorf("TrackedClients = %d, want 2", got)
+		}
+		// 10.0.0.1 was evicted with its empty bucket and starts afresh
+		if !cl.Allow("10.0.0.1", OpTypeRead) {
+			t.Error("read from evicted client denied")
+		}
+	})
+
+	t.Run("drops idle clients after ClientIdleTTL", func(t *testing.T) {
+		cl := NewClientLimiter(config)
+		for i := 0; i < 4; i++ {
+			cl.Allow("10.0.0.1", OpTypeRead)
+		}
+		if got := cl.ThrottledClients(); got != 1 {
+			t.Fatalf("ThrottledClients = %d, want 1", got)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if got := cl.TrackedClients(); got != 0 {
+			t.Errorf("TrackedClients after idle TTL = %d, want 0", got)
+		}
+		if got := cl.ThrottledClients(); got != 0 {
+			t.Errorf("ThrottledClients after idle TTL = %d, want 0", got)
+		}
+	})
+}
+
 func TestRateLimiter(t *testing.T) {
 	config := RateLimiterConfig{
 		GlobalRequestsPerSecond:        1000,
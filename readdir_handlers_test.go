@@ -3,8 +3,15 @@ package absnfs
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/absfs/absfs"
 	"github.com/absfs/memfs"
 )
 
@@ -512,3 +519,557 @@ func TestHandleReaddirplus(t *testing.T) {
 	})
 }
 
+func TestDisableReaddirPlus(t *testing.T) {
+	server, err := newTestServerNoRateLimit()
+	if err != nil {
+		t.Fatalf("Failed to create test server: %v", err)
+	}
+	server.handler.UpdateTuningOptions(func(o *TuningOptions) { o.DisableReaddirPlus = true })
+
+	handler := &NFSProcedureHandler{server: server}
+	authCtx := &AuthContext{ClientIP: "127.0.0.1", ClientPort: 12345}
+	rootHandle := getRootHandle(server)
+
+	result, err := handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(rootHandle, 0, 4096, 8192)), &RPCReply{}, authCtx)
+	if err != nil {
+		t.Fatalf("handleReaddirplus failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFSERR_NOTSUPP {
+		t.Errorf("Expected NFSERR_NOTSUPP for READDIRPLUS, got %d", status)
+	}
+
+	result, err = handler.handleReaddir(bytes.NewReader(buildReaddirRequest(rootHandle, 0, 4096)), &RPCReply{}, authCtx)
+	if err != nil {
+		t.Fatalf("handleReaddir failed: %v", err)
+	}
+	if status := readStatusFromReply(result); status != NFS_OK {
+		t.Errorf("Expected NFS_OK for READDIR, got %d", status)
+	}
+}
+
+// failingLstatFS returns an I/O error from Lstat for one path
+type failingLstatFS struct {
+	*memfs.FileSystem
+	failPath string
+}
+
+func (f *failingLstatFS) Lstat(name string) (os.FileInfo, error) {
+	if name == f.failPath {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: syscall.EIO}
+	}
+	return f.FileSystem.Lstat(name)
+}
+
+// readdirplusNames decodes a successful READDIRPLUS reply into entry names and eof
+func readdirplusNames(t *testing.T, data []byte) ([]string, bool) {
+	t.Helper()
+	const fattr3Size = 84
+	r := bytes.NewReader(data)
+	if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+		t.Fatalf("Expected NFS_OK, got %d", status)
+	}
+	r.Seek(4+fattr3Size+8, io.SeekCurrent) // dir attributes and cookieverf
+	var names []string
+	for {
+		more, err := xdrDecodeUint32(r)
+		if err != nil {
+			t.Fatalf("Truncated reply: %v", err)
+		}
+		if more == 0 {
+			break
+		}
+		r.Seek(8, io.SeekCurrent) // fileid
+		name, err := xdrDecodeString(r)
+		if err != nil {
+			t.Fatalf("Failed to decode name: %v", err)
+		}
+		names = append(names, name)
+		r.Seek(8+4+fattr3Size, io.SeekCurrent) // cookie and attributes
+		if follows, _ := xdrDecodeUint32(r); follows == 1 {
+			xdrDecodeFileHandle(r)
+		}
+	}
+	eof, _ := xdrDecodeUint32(r)
+	return names, eof == 1
+}
+
+func TestReaddirplusSkipsUnreadableEntry(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	for _, name := range []string{"a", "bad", "c"} {
+		f, err := mfs.Create("/dir/" + name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Close()
+	}
+
+	nfs, err := New(&failingLstatFS{FileSystem: mfs, failPath: "/dir/bad"}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	dirHandle := getFileHandle(server, "/dir")
+
+	result, err := handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(dirHandle, 0, 4096, 8192)), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleReaddirplus failed: %v", err)
+	}
+	names, eof := readdirplusNames(t, result.Data.([]byte))
+	if strings.Join(names, ",") != "a,c" || !eof {
+		t.Errorf("Expected entries [a c] with eof, got %v eof=%v", names, eof)
+	}
+	if skipped := nfs.GetMetrics().ReaddirSkipped; skipped != 1 {
+		t.Errorf("Expected 1 skipped entry, got %d", skipped)
+	}
+
+	// A page cut short after a skip is not the end of the listing
+	if err := nfs.UpdateTuningOptions(func(o *TuningOptions) { o.ReaddirPlusMaxEntries = 1 }); err != nil {
+		t.Fatalf("UpdateTuningOptions: %v", err)
+	}
+	for _, tt := range []struct {
+		cookie uint64
+		want   string
+		eof    bool
+	}{
+		{0, "a", false},
+		{1, "c", true}, // bad is skipped and c completes the listing
+	} {
+		result, err := handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(dirHandle, tt.cookie, 4096, 8192)), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddirplus failed: %v", err)
+		}
+		names, eof := readdirplusNames(t, result.Data.([]byte))
+		if strings.Join(names, ",") != tt.want || eof != tt.eof {
+			t.Errorf("From cookie %d: expected [%s] eof=%v, got %v eof=%v", tt.cookie, tt.want, tt.eof, names, eof)
+		}
+	}
+
+	// So is one cut by maxcount with the skipped entry inside it
+	if err := nfs.UpdateTuningOptions(func(o *TuningOptions) { o.ReaddirPlusMaxEntries = 0 }); err != nil {
+		t.Fatalf("UpdateTuningOptions: %v", err)
+	}
+	const dirReply = 4 + 4 + 84 + 8               // status, dir attributes, cookieverf
+	const plusEntry = 4 + 8 + 8 + 8 + 4 + 84 + 16 // one single-letter entry and its handle
+	maxCount := uint32(dirReply + plusEntry + 8 + plusEntry/2)
+	result, err = handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(dirHandle, 0, 4096, maxCount)), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleReaddirplus failed: %v", err)
+	}
+	names, eof = readdirplusNames(t, result.Data.([]byte))
+	if strings.Join(names, ",") != "a" || eof {
+		t.Errorf("Expected [a] without eof from a page too small for c, got %v eof=%v", names, eof)
+	}
+	if skipped := nfs.GetMetrics().ReaddirSkipped; skipped != 3 {
+		t.Errorf("Expected 3 skipped entries in all, got %d", skipped)
+	}
+}
+
+// dupReaddirFS lists the first entry of dupDir a second time
+type dupReaddirFS struct {
+	*memfs.FileSystem
+	dupDir string
+}
+
+func (f *dupReaddirFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil || name != f.dupDir {
+		return file, err
+	}
+	return &dupReaddirFile{File: file}, nil
+}
+
+type dupReaddirFile struct {
+	absfs.File
+}
+
+func (f *dupReaddirFile) Readdir(n int) ([]os.FileInfo, error) {
+	entries, err := f.File.Readdir(n)
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+	return append(entries, entries[0]), nil
+}
+
+// readdirNames decodes a successful READDIR reply into entry names
+func readdirNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	const fattr3Size = 84
+	r := bytes.NewReader(data)
+	if status, _ := xdrDecodeUint32(r); status != NFS_OK {
+		t.Fatalf("Expected NFS_OK, got %d", status)
+	}
+	r.Seek(4+fattr3Size+8, io.SeekCurrent) // dir attributes and cookieverf
+	var names []string
+	for {
+		more, err := xdrDecodeUint32(r)
+		if err != nil {
+			t.Fatalf("Truncated reply: %v", err)
+		}
+		if more == 0 {
+			break
+		}
+		r.Seek(8, io.SeekCurrent) // fileid
+		name, err := xdrDecodeString(r)
+		if err != nil {
+			t.Fatalf("Failed to decode name: %v", err)
+		}
+		names = append(names, name)
+		r.Seek(8, io.SeekCurrent) // cookie
+	}
+	return names
+}
+
+func TestReaddirDedupesNames(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	for _, name := range []string{"a", "b"} {
+		f, err := mfs.Create("/dir/" + name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Close()
+	}
+
+	nfs, err := New(&dupReaddirFS{FileSystem: mfs, dupDir: "/dir"}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	dirHandle := getFileHandle(server, "/dir")
+
+	result, err := handler.handleReaddir(bytes.NewReader(buildReaddirRequest(dirHandle, 0, 8192)), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleReaddir failed: %v", err)
+	}
+	if names := readdirNames(t, result.Data.([]byte)); strings.Join(names, ",") != "a,b" {
+		t.Errorf("Expected entries [a b], got %v", names)
+	}
+	if dups := nfs.GetMetrics().ReaddirDuplicates; dups != 1 {
+		t.Errorf("Expected 1 duplicate entry, got %d", dups)
+	}
+}
+
+// vanishingFS deletes vanishPath when Stat is called on it, as a REMOVE
+// from another client would between the listing and the Stat after it
+type vanishingFS struct {
+	*memfs.FileSystem
+	vanishPath string
+}
+
+func (f *vanishingFS) Stat(name string) (os.FileInfo, error) {
+	if name == f.vanishPath {
+		f.FileSystem.Remove(name)
+	}
+	return f.FileSystem.Stat(name)
+}
+
+func TestReaddirplusSkipsEntryDeletedBeforeStat(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	for _, name := range []string{"a", "b", "c"} {
+		f, err := mfs.Create("/dir/" + name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Close()
+	}
+
+	// Attributes expire at once, so READDIRPLUS stats every entry
+	nfs, err := New(&vanishingFS{FileSystem: mfs, vanishPath: "/dir/b"}, ExportOptions{AttrCacheTimeout: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	dirHandle := getFileHandle(server, "/dir")
+
+	result, err := handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(dirHandle, 0, 4096, 8192)), &RPCReply{}, testAuthContext())
+	if err != nil {
+		t.Fatalf("handleReaddirplus failed: %v", err)
+	}
+	names, eof := readdirplusNames(t, result.Data.([]byte))
+	if strings.Join(names, ",") != "a,c" || !eof {
+		t.Errorf("Expected entries [a c] with eof, got %v eof=%v", names, eof)
+	}
+	if skipped := nfs.GetMetrics().ReaddirSkipped; skipped != 1 {
+		t.Errorf("Expected 1 skipped entry, got %d", skipped)
+	}
+}
+
+// ghostReaddirFS lists a "gone" entry in dir that does not exist
+type ghostReaddirFS struct {
+	*memfs.FileSystem
+	dir string
+}
+
+func (f *ghostReaddirFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil || name != f.dir {
+		return file, err
+	}
+	return &ghostReaddirFile{File: file}, nil
+}
+
+type ghostReaddirFile struct {
+	absfs.File
+}
+
+func (f *ghostReaddirFile) Readdir(n int) ([]os.FileInfo, error) {
+	entries, err := f.File.Readdir(n)
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+	return append(entries, renamedInfo{FileInfo: entries[0], name: "gone"}), nil
+}
+
+// renamedInfo is a FileInfo under another name
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string { return i.name }
+
+func TestReaddirListVanished(t *testing.T) {
+	for _, tt := range []struct {
+		listVanished bool
+		readdir      string
+	}{
+		{false, "a,b"},
+		{true, "a,b,gone"},
+	} {
+		mfs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		mfs.Mkdir("/dir", 0755)
+		for _, name := range []string{"a", "b"} {
+			f, err := mfs.Create("/dir/" + name)
+			if err != nil {
+				t.Fatalf("Failed to create %s: %v", name, err)
+			}
+			f.Close()
+		}
+
+		nfs, err := New(&ghostReaddirFS{FileSystem: mfs, dir: "/dir"}, ExportOptions{ReaddirListVanished: tt.listVanished})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		t.Cleanup(func() { nfs.Close() })
+		server := &Server{handler: nfs, options: ServerOptions{}}
+		handler := &NFSProcedureHandler{server: server}
+		dirHandle := getFileHandle(server, "/dir")
+
+		result, err := handler.handleReaddir(bytes.NewReader(buildReaddirRequest(dirHandle, 0, 8192)), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddir failed: %v", err)
+		}
+		if names := strings.Join(readdirNames(t, result.Data.([]byte)), ","); names != tt.readdir {
+			t.Errorf("ReaddirListVanished=%v: READDIR listed [%s], want [%s]", tt.listVanished, names, tt.readdir)
+		}
+
+		// READDIRPLUS has no attributes to send for the name either way
+		result, err = handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(dirHandle, 0, 4096, 8192)), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddirplus failed: %v", err)
+		}
+		if names, _ := readdirplusNames(t, result.Data.([]byte)); strings.Join(names, ",") != "a,b" {
+			t.Errorf("ReaddirListVanished=%v: READDIRPLUS listed %v, want [a b]", tt.listVanished, names)
+		}
+	}
+}
+
+func TestReaddirPlusMaxEntries(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/big", 0755)
+	const total = 500
+	for i := 0; i < total; i++ {
+		f, err := mfs.Create(fmt.Sprintf("/big/f%03d", i))
+		if err != nil {
+			t.Fatalf("Failed to create file %d: %v", i, err)
+		}
+		f.Close()
+	}
+
+	nfs, err := New(mfs, ExportOptions{ReaddirPlusMaxEntries: 100})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	dirHandle := getFileHandle(server, "/big")
+
+	seen := make(map[string]bool)
+	cookie := uint64(0)
+	for calls := 1; ; calls++ {
+		req := buildReaddirplusRequest(dirHandle, cookie, 1<<20, 1<<20)
+		result, err := handler.handleReaddirplus(bytes.NewReader(req), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddirplus failed: %v", err)
+		}
+		names, eof := readdirplusNames(t, result.Data.([]byte))
+		if len(names) > 100 {
+			t.Fatalf("Call %d returned %d entries, cap is 100", calls, len(names))
+		}
+		for _, name := range names {
+			if seen[name] {
+				t.Fatalf("Entry %s returned twice", name)
+			}
+			seen[name] = true
+		}
+		cookie += uint64(len(names))
+		if eof {
+			if calls != total/100 {
+				t.Errorf("Expected %d calls, took %d", total/100, calls)
+			}
+			break
+		}
+		if calls > total/100 {
+			t.Fatal("Listing did not reach EOF")
+		}
+	}
+	if len(seen) != total {
+		t.Errorf("Expected %d distinct entries, got %d", total, len(seen))
+	}
+}
+
+func TestReaddirplusByteBudgets(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/big", 0755)
+	const total = 200
+	for i := 0; i < total; i++ {
+		f, err := mfs.Create(fmt.Sprintf("/big/f%03d", i))
+		if err != nil {
+			t.Fatalf("Failed to create file %d: %v", i, err)
+		}
+		f.Close()
+	}
+
+	nfs, err := New(mfs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	dirHandle := getFileHandle(server, "/big")
+
+	readdirplus := func(cookie uint64, dirCount, maxCount uint32) *RPCReply {
+		t.Helper()
+		req := buildReaddirplusRequest(dirHandle, cookie, dirCount, maxCount)
+		result, err := handler.handleReaddirplus(bytes.NewReader(req), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddirplus failed: %v", err)
+		}
+		return result
+	}
+
+	// Too small for the directory attributes and a single entry
+	handles := nfs.fileMap.Count()
+	if status := readStatusFromReply(readdirplus(0, 4096, 200)); status != NFSERR_TOOSMALL {
+		t.Errorf("maxcount 200: expected NFSERR_TOOSMALL, got %d", status)
+	}
+	if got := nfs.fileMap.Count(); got != handles {
+		t.Errorf("NFSERR_TOOSMALL allocated %d handles", got-handles)
+	}
+
+	// Each entry costs 24 bytes of dircount: fileid, name "fNNN" and cookie.
+	// Only the entries on the page get handles.
+	if names, eof := readdirplusNames(t, readdirplus(0, 72, 1<<20).Data.([]byte)); len(names) != 3 || eof {
+		t.Errorf("dircount 72: expected 3 entries without eof, got %v eof=%v", names, eof)
+	}
+	if got := nfs.fileMap.Count(); got != handles+3 {
+		t.Errorf("a 3-entry page allocated %d handles, want 3", got-handles)
+	}
+
+	const maxCount = 1024
+	seen := make(map[string]bool)
+	cookie := uint64(0)
+	for calls := 1; ; calls++ {
+		data := readdirplus(cookie, 1<<20, maxCount).Data.([]byte)
+		if len(data) > maxCount {
+			t.Fatalf("Call %d returned %d bytes, maxcount is %d", calls, len(data), maxCount)
+		}
+		names, eof := readdirplusNames(t, data)
+		for _, name := range names {
+			if seen[name] {
+				t.Fatalf("Entry %s returned twice", name)
+			}
+			seen[name] = true
+		}
+		cookie += uint64(len(names))
+		if eof {
+			break
+		}
+		if len(names) == 0 || calls > total {
+			t.Fatal("Listing did not reach EOF")
+		}
+	}
+	if len(seen) != total {
+		t.Errorf("Expected %d distinct entries, got %d", total, len(seen))
+	}
+}
+
+func TestReaddirBadCookie(t *testing.T) {
+	server, err := newTestServerNoRateLimit()
+	if err != nil {
+		t.Fatalf("Failed to create test server: %v", err)
+	}
+	handler := &NFSProcedureHandler{server: server}
+	server.handler.fs.Mkdir("/dir", 0755)
+	for _, name := range []string{"a", "b", "c"} {
+		f, _ := server.handler.fs.Create("/dir/" + name)
+		f.Close()
+	}
+	dirHandle := getFileHandle(server, "/dir")
+
+	for _, tt := range []struct {
+		cookie uint64
+		status uint32
+	}{
+		{0, NFS_OK},
+		{3, NFS_OK}, // one past the last entry: an empty, final page
+		{4, NFSERR_BAD_COOKIE},
+		{1 << 62, NFSERR_BAD_COOKIE},
+		{^uint64(0), NFSERR_BAD_COOKIE},
+	} {
+		result, err := handler.handleReaddir(bytes.NewReader(buildReaddirRequest(dirHandle, tt.cookie, 4096)), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddir failed: %v", err)
+		}
+		if status := readStatusFromReply(result); status != tt.status {
+			t.Errorf("READDIR cookie %d: expected status %d, got %d", tt.cookie, tt.status, status)
+		}
+		if tt.cookie == 3 {
+			if names := readdirNames(t, result.Data.([]byte)); len(names) != 0 {
+				t.Errorf("READDIR cookie 3: expected no entries, got %v", names)
+			}
+		}
+
+		result, err = handler.handleReaddirplus(bytes.NewReader(buildReaddirplusRequest(dirHandle, tt.cookie, 4096, 4096)), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleReaddirplus failed: %v", err)
+		}
+		if status := readStatusFromReply(result); status != tt.status {
+			t.Errorf("READDIRPLUS cookie %d: expected status %d, got %d", tt.cookie, tt.status, status)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// replay.go: Detection of replayed RPC verifiers.
+//
+// With ReplayWindow set, each non-null verifier a client sends is
+// remembered for the window and an exact repeat within it is refused.
+// Null (AUTH_NONE) verifiers, which AUTH_NONE and AUTH_SYS calls carry,
+// are identical by design and never tracked.
+//
+// Memory is bounded: a client remembers at most maxReplayPerClient
+// verifiers and the cache at most maxReplayEntries in total. When either
+// is full the oldest verifier is forgotten first, so a flood of fresh
+// verifiers shortens how far back replays are caught rather than growing
+// the cache.
+package absnfs
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxReplayPerClient bounds the verifiers remembered for one client
+	maxReplayPerClient = 4096
+
+	// maxReplayEntries bounds the verifiers remembered across all clients
+	maxReplayEntries = 65536
+)
+
+// replayEntry records when a verifier was remembered, in arrival order
+type replayEntry struct {
+	client string
+	key    string
+	at     time.Time
+}
+
+// replayCache remembers recently seen verifiers per client address
+type replayCache struct {
+	mu    sync.Mutex
+	seen  map[string]map[string]time.Time // client -> verifier -> when seen
+	order []replayEntry                   // remembered verifiers, oldest first
+	count int                             // verifiers in seen
+}
+
+// replayed records verf as seen from client at now and reports whether
+// the same verifier was already seen from that client within window.
+func (c *replayCache) replayed(client string, verf RPCVerifier, window time.Duration, now time.Time) bool {
+	key := strconv.FormatUint(uint64(verf.Flavor), 10) + ":" + string(verf.Body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Entries arrive in time order, so expired ones are at the front
+	for len(c.order) > 0 && now.Sub(c.order[0].at) >= window {
+		c.dropOldest()
+	}
+
+	if c.seen == nil {
+		c.seen = make(map[string]map[string]time.Time)
+	}
+	verfs := c.seen[client]
+	if verfs == nil {
+		verfs = make(map[string]time.Time)
+		c.seen[client] = verfs
+	}
+	at, ok := verfs[key]
+	if ok && now.Sub(at) < window {
+		return true
+	}
+
+	if !ok {
+		if len(verfs) >= maxReplayPerClient {
+			c.dropClientOldest(verfs)
+		}
+		for c.count >= maxReplayEntries {
+			c.dropOldest()
+		}
+		c.count++
+		// Eviction may have dropped this client's last entry and its map
+		c.seen[client] = verfs
+	}
+	verfs[key] = now
+	c.order = append(c.order, replayEntry{client: client, key: key, at: now})
+
+	// Entries forgotten per client stay in order until they reach the
+	// front; compact once they outnumber the live ones
+	if len(c.order) > 2*maxReplayEntries {
+		live := make([]replayEntry, 0, c.count)
+		for _, e := range c.order {
+			if c.current(e) {
+				live = append(live, e)
+			}
+		}
+		c.order = live
+	}
+	return false
+}
+
+// current reports whether e is still the remembered entry for its verifier
+func (c *replayCache) current(e replayEntry) bool {
+	at, ok := c.seen[e.client][e.key]
+	return ok && at.Equal(e.at)
+}
+
+// dropOldest forgets the front of order, if it is still remembered
+func (c *replayCache) dropOldest() {
+	e := c.order[0]
+	c.order = c.order[1:]
+	if !c.current(e) {
+		return
+	}
+	verfs := c.seen[e.client]
+	delete(verfs, e.key)
+	c.count--
+	if len(verfs) == 0 {
+		delete(c.seen, e.client)
+	}
+}
+
+// dropClientOldest forgets the oldest verifier remembered for one client.
+// Its entry in order is left behind and skipped when it reaches the front.
+func (c *replayCache) dropClientOldest(verfs map[string]time.Time) {
+	var oldest string
+	var oldestAt time.Time
+	first := true
+	for k, at := range verfs {
+		if first || at.Before(oldestAt) {
+			oldest, oldestAt, first = k, at, false
+		}
+	}
+	delete(verfs, oldest)
+	c.count--
+}
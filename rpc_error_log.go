@@ -0,0 +1,139 @@
+// rpc_error_log.go: Logging of failed NFS calls with their arguments.
+//
+// With LogRPCOnError, a call whose reply carries a status other than
+// NFS_OK is logged with its procedure, the decoded arguments (handle and
+// its path, names, offsets and counts) and the status. Write payloads are
+// never logged, only their length, so the log stays free of file data
+// without having to enable full per-operation logging.
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// nfsProcNames maps NFS procedure numbers to their RFC 1813 names
+var nfsProcNames = map[uint32]string{
+	NFSPROC3_NULL:        "NULL",
+	NFSPROC3_GETATTR:     "GETATTR",
+	NFSPROC3_SETATTR:     "SETATTR",
+	NFSPROC3_LOOKUP:      "LOOKUP",
+	NFSPROC3_ACCESS:      "ACCESS",
+	NFSPROC3_READLINK:    "READLINK",
+	NFSPROC3_READ:        "READ",
+	NFSPROC3_WRITE:       "WRITE",
+	NFSPROC3_CREATE:      "CREATE",
+	NFSPROC3_MKDIR:       "MKDIR",
+	NFSPROC3_SYMLINK:     "SYMLINK",
+	NFSPROC3_MKNOD:       "MKNOD",
+	NFSPROC3_REMOVE:      "REMOVE",
+	NFSPROC3_RMDIR:       "RMDIR",
+	NFSPROC3_RENAME:      "RENAME",
+	NFSPROC3_LINK:        "LINK",
+	NFSPROC3_READDIR:     "READDIR",
+	NFSPROC3_READDIRPLUS: "READDIRPLUS",
+	NFSPROC3_FSSTAT:      "FSSTAT",
+	NFSPROC3_FSINFO:      "FSINFO",
+	NFSPROC3_PATHCONF:    "PATHCONF",
+	NFSPROC3_COMMIT:      "COMMIT",
+}
+
+// logFailedCall logs a call whose reply status is not NFS_OK, with the
+// arguments decoded from args. Arguments that fail to decode are omitted.
+func (h *NFSProcedureHandler) logFailedCall(proc uint32, args []byte, result *RPCReply, err error, authCtx *AuthContext) {
+	slog := h.nfs().getStructuredLogger()
+	if slog == nil {
+		return
+	}
+
+	fields := []LogField{{Key: "proc", Value: nfsProcNames[proc]}}
+	if err != nil {
+		fields = append(fields, LogField{Key: "error", Value: err.Error()})
+	} else {
+		data, ok := result.Data.([]byte)
+		if !ok || len(data) < 4 {
+			return
+		}
+		status := binary.BigEndian.Uint32(data)
+		if status == NFS_OK {
+			return
+		}
+		fields = append(fields, LogField{Key: "status", Value: status})
+	}
+	if log := h.nfs().tuning.Load().Log; log != nil && log.LogClientIPs {
+		fields = append(fields, LogField{Key: "client", Value: authCtx.ClientIP})
+	}
+	fields = append(fields, h.describeArgs(proc, bytes.NewReader(args))...)
+
+	slog.Warn("nfs call failed", fields...)
+}
+
+// describeArgs decodes the arguments of proc into log fields. A field that
+// is missing or malformed is omitted, as is everything after it.
+func (h *NFSProcedureHandler) describeArgs(proc uint32, r io.Reader) []LogField {
+	var fields []LogField
+	var failed bool
+	addHandle := func(key string) {
+		handle, err := xdrDecodeFileHandle(r)
+		if failed = failed || err != nil; failed {
+			return
+		}
+		fields = append(fields, LogField{Key: key, Value: handle})
+		if node, ok := h.lookupNode(handle); ok {
+			fields = append(fields, LogField{Key: key + "_path", Value: node.path})
+		}
+	}
+	addName := func(key string) {
+		name, err := xdrDecodeString(r)
+		if failed = failed || err != nil; failed {
+			return
+		}
+		fields = append(fields, LogField{Key: key, Value: name})
+	}
+	addUint32 := func(key string) {
+		var v uint32
+		if failed = failed || binary.Read(r, binary.BigEndian, &v) != nil; failed {
+			return
+		}
+		fields = append(fields, LogField{Key: key, Value: v})
+	}
+	addUint64 := func(key string) {
+		var v uint64
+		if failed = failed || binary.Read(r, binary.BigEndian, &v) != nil; failed {
+			return
+		}
+		fields = append(fields, LogField{Key: key, Value: v})
+	}
+
+	if proc == NFSPROC3_NULL {
+		return fields
+	}
+	addHandle("handle")
+	switch proc {
+	case NFSPROC3_LOOKUP, NFSPROC3_CREATE, NFSPROC3_MKDIR, NFSPROC3_SYMLINK,
+		NFSPROC3_MKNOD, NFSPROC3_REMOVE, NFSPROC3_RMDIR:
+		addName("name")
+	case NFSPROC3_ACCESS:
+		addUint32("access")
+	case NFSPROC3_READ, NFSPROC3_COMMIT:
+		addUint64("offset")
+		addUint32("count")
+	case NFSPROC3_WRITE:
+		// The payload itself is only summarized by its length
+		addUint64("offset")
+		addUint32("count")
+		addUint32("stable")
+		addUint32("data_len")
+	case NFSPROC3_READDIR, NFSPROC3_READDIRPLUS:
+		addUint64("cookie")
+	case NFSPROC3_RENAME:
+		addName("name")
+		addHandle("to_handle")
+		addName("to_name")
+	case NFSPROC3_LINK:
+		addHandle("dir_handle")
+		addName("name")
+	}
+	return fields
+}
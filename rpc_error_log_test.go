@@ -0,0 +1,94 @@
+package absnfs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// recordingLogger keeps every entry logged at warn level in entries and
+// at info level in infos
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries [][]LogField
+	infos   [][]LogField
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...LogField) {}
+func (l *recordingLogger) Error(msg string, fields ...LogField) {}
+func (l *recordingLogger) Info(msg string, fields ...LogField) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, append([]LogField{{Key: "msg", Value: msg}}, fields...))
+}
+func (l *recordingLogger) Warn(msg string, fields ...LogField) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, append([]LogField{{Key: "msg", Value: msg}}, fields...))
+}
+
+func TestLogRPCOnError(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, _ := mfs.Create("/file")
+	f.Close()
+
+	nfs, err := New(mfs, ExportOptions{ReadOnly: true, LogRPCOnError: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	logs := &recordingLogger{}
+	nfs.SetLogger(logs)
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	h := &NFSProcedureHandler{server: server}
+	fileHandle := getFileHandle(server, "/file")
+
+	// A successful call is not logged
+	var getattr bytes.Buffer
+	xdrEncodeFileHandle(&getattr, fileHandle)
+	if status := readStatusFromReply(callAs(t, h, "127.0.0.1", NFS_PROGRAM, NFS_V3, NFSPROC3_GETATTR, getattr.Bytes())); status != NFS_OK {
+		t.Fatalf("GETATTR: expected NFS_OK, got %d", status)
+	}
+	if len(logs.entries) != 0 {
+		t.Fatalf("Expected no log entries for a successful call, got %v", logs.entries)
+	}
+
+	secret := "TOP-SECRET-PAYLOAD"
+	write := buildWriteRequest(fileHandle, 7, []byte(secret))
+	if status := readStatusFromReply(callAs(t, h, "127.0.0.1", NFS_PROGRAM, NFS_V3, NFSPROC3_WRITE, write)); status != NFSERR_ROFS {
+		t.Fatalf("WRITE: expected NFSERR_ROFS, got %d", status)
+	}
+	if len(logs.entries) != 1 {
+		t.Fatalf("Expected 1 log entry, got %d", len(logs.entries))
+	}
+
+	got := map[string]interface{}{}
+	for _, field := range logs.entries[0] {
+		got[field.Key] = field.Value
+		if strings.Contains(fmt.Sprint(field.Value), secret) {
+			t.Errorf("Field %s contains the write payload", field.Key)
+		}
+	}
+	want := map[string]interface{}{
+		"msg":         "nfs call failed",
+		"proc":        "WRITE",
+		"status":      uint32(NFSERR_ROFS),
+		"handle":      fileHandle,
+		"handle_path": "/file",
+		"offset":      uint64(7),
+		"count":       uint32(len(secret)),
+		"data_len":    uint32(len(secret)),
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Field %s = %v (%T), want %v (%T)", key, got[key], got[key], value, value)
+		}
+	}
+}
@@ -25,6 +25,7 @@ const (
 	AUTH_SYS   = 1 // UNIX-style authentication (formerly AUTH_UNIX)
 	AUTH_SHORT = 2 // Short hand UNIX-style
 	AUTH_DH    = 3 // Diffie-Hellman authentication
+	RPCSEC_GSS = 6 // GSS-API security contexts, such as Kerberos (RFC 2203)
 )
 
 // Maximum sizes for XDR data structures to prevent DoS attacks
@@ -61,16 +62,29 @@ const (
 	AUTH_ERROR   = 1 // remote can't authenticate caller
 )
 
+// auth_stat values for AUTH_ERROR rejections
+const (
+	AUTH_BADCRED      = 1 // bad credential
+	AUTH_REJECTEDVERF = 4 // verifier expired or replayed
+
+	RPCSEC_GSS_CREDPROBLEM = 13 // no usable RPCSEC_GSS context for the credential
+	RPCSEC_GSS_CTXPROBLEM  = 14 // RPCSEC_GSS context is no longer usable
+)
+
 // RPC program numbers
 const (
 	MOUNT_PROGRAM = 100005
 	NFS_PROGRAM   = 100003
+	NLM_PROGRAM   = 100021
 )
 
 // RPC versions
 const (
+	MOUNT_V1 = 1
 	MOUNT_V3 = 3
+	NFS_V2   = 2
 	NFS_V3   = 3
+	NLM_V4   = 4
 )
 
 // RPC procedures for NFS v3
@@ -99,6 +113,18 @@ const (
 	NFSPROC3_COMMIT      = 21
 )
 
+// RPC procedures for NFS v2 (RFC 1094) served by the v2 dispatch path
+const (
+	NFSPROC2_NULL    = 0
+	NFSPROC2_GETATTR = 1
+	NFSPROC2_LOOKUP  = 4
+	NFSPROC2_READ    = 6
+	NFSPROC2_WRITE   = 8
+	NFSPROC2_CREATE  = 9
+	NFSPROC2_REMOVE  = 10
+	NFSPROC2_READDIR = 16
+)
+
 // RPC message header
 type RPCMsgHeader struct {
 	Xid        uint32
@@ -152,7 +178,9 @@ func xdrEncodeFileHandle(w io.Writer, handle uint64) error {
 }
 
 // xdrDecodeFileHandle decodes an NFS3 file handle (opaque<FHSIZE3>)
-// Returns the 8-byte handle value
+// Returns the 8-byte handle value. A handle of the wrong length is consumed
+// and reported as an InvalidFileHandleError, which handlers answer with
+// NFSERR_BADHANDLE rather than GARBAGE_ARGS.
 func xdrDecodeFileHandle(r io.Reader) (uint64, error) {
 	// First read the length
 	length, err := xdrDecodeUint32(r)
@@ -174,7 +202,7 @@ func xdrDecodeFileHandle(r io.Reader) (uint64, error) {
 				return 0, fmt.Errorf("failed to discard handle data: %w", err)
 			}
 		}
-		return 0, fmt.Errorf("invalid handle length: %d (expected 8)", length)
+		return 0, &InvalidFileHandleError{Reason: fmt.Sprintf("invalid handle length: %d (expected 8)", length)}
 	}
 
 	// Read the 8-byte handle
@@ -254,8 +282,14 @@ type RPCReply struct {
 	Header       RPCMsgHeader
 	Status       uint32 // reply_stat: MSG_ACCEPTED or MSG_DENIED
 	AcceptStatus uint32 // accept_stat: SUCCESS, PROG_UNAVAIL, etc. (only when Status == MSG_ACCEPTED)
+	AuthStat     uint32 // auth_stat for an AUTH_ERROR rejection (only when Status == MSG_DENIED; zero means AUTH_BADCRED)
 	Verifier     RPCVerifier
 	Data         interface{}
+
+	// MismatchLow and MismatchHigh are the supported version range sent
+	// with a PROG_MISMATCH reply; a zero MismatchHigh sends 3 to 3
+	MismatchLow  uint32
+	MismatchHigh uint32
 }
 
 // DecodeRPCCall decodes an RPC call from a reader
@@ -396,10 +430,14 @@ func EncodeRPCReply(w io.Writer, reply *RPCReply) error {
 
 		// RFC 1831: PROG_MISMATCH requires mismatch_info (low and high version)
 		if reply.AcceptStatus == PROG_MISMATCH {
-			if err := xdrEncodeUint32(w, 3); err != nil { // low version
+			low, high := reply.MismatchLow, reply.MismatchHigh
+			if high == 0 {
+				low, high = 3, 3
+			}
+			if err := xdrEncodeUint32(w, low); err != nil { // low version
 				return fmt.Errorf("failed to encode mismatch low: %w", err)
 			}
-			if err := xdrEncodeUint32(w, 3); err != nil { // high version
+			if err := xdrEncodeUint32(w, high); err != nil { // high version
 				return fmt.Errorf("failed to encode mismatch high: %w", err)
 			}
 			return nil
@@ -414,7 +452,7 @@ func EncodeRPCReply(w io.Writer, reply *RPCReply) error {
 				return err
 			case *NFSAttrs:
 				// File attributes
-				return encodeFileAttributes(w, data)
+				return encodeFileAttributes(w, data, nil)
 			case string:
 				// String data (mainly for error messages)
 				return xdrEncodeString(w, data)
@@ -435,8 +473,11 @@ func EncodeRPCReply(w io.Writer, reply *RPCReply) error {
 		if err := xdrEncodeUint32(w, AUTH_ERROR); err != nil {
 			return fmt.Errorf("failed to encode reject stat: %w", err)
 		}
-		// Auth error type (AUTH_BADCRED = 1)
-		if err := xdrEncodeUint32(w, 1); err != nil {
+		authStat := reply.AuthStat
+		if authStat == 0 {
+			authStat = AUTH_BADCRED
+		}
+		if err := xdrEncodeUint32(w, authStat); err != nil {
 			return fmt.Errorf("failed to encode auth error: %w", err)
 		}
 	}
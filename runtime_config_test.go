@@ -403,3 +403,71 @@ func TestUpdateExportOptions_ClearAllowedIPs(t *testing.T) {
 		t.Errorf("AllowedIPs should be cleared, got %v", opts.AllowedIPs)
 	}
 }
+
+func TestUpdateExportOptions_RejectedLeavesTuning(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	server, err := New(fs, ExportOptions{PersistentHandles: true, TransferSize: 65536})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		options ExportOptions
+	}{
+		{"ExportRoot", ExportOptions{PersistentHandles: true, ExportRoot: "/other", TransferSize: 8192}},
+		{"Squash", ExportOptions{PersistentHandles: true, Squash: "all", TransferSize: 8192}},
+		{"invalid ClientRules", ExportOptions{PersistentHandles: true, ClientRules: []ClientRule{{Host: "not a network"}}, TransferSize: 8192}},
+		{"invalid CacheConsistency", ExportOptions{PersistentHandles: true, CacheConsistency: "eventual", TransferSize: 8192}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := server.UpdateExportOptions(tt.options); err == nil {
+				t.Fatal("Expected the update to be rejected")
+			}
+			if got := server.GetExportOptions().TransferSize; got != 65536 {
+				t.Errorf("TransferSize = %d after a rejected update, want 65536", got)
+			}
+			if !server.GetExportOptions().PersistentHandles {
+				t.Error("PersistentHandles was changed")
+			}
+		})
+	}
+}
+
+func TestUpdateTuningOptions_RejectsInvalidCacheConsistency(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	server, err := New(fs, ExportOptions{CacheConsistency: CacheConsistencyStrict})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Close()
+
+	err = server.UpdateTuningOptions(func(t *TuningOptions) {
+		t.CacheConsistency = "eventual"
+		t.AttrCacheSize = 42
+	})
+	if err == nil {
+		t.Fatal("Expected an invalid CacheConsistency to be rejected")
+	}
+	tuning := server.tuning.Load()
+	if tuning.CacheConsistency != CacheConsistencyStrict || tuning.AttrCacheSize == 42 {
+		t.Errorf("Rejected update applied: CacheConsistency %q, AttrCacheSize %d", tuning.CacheConsistency, tuning.AttrCacheSize)
+	}
+
+	if err := server.UpdateTuningOptions(func(t *TuningOptions) { t.CacheConsistency = CacheConsistencyCloseToOpen }); err != nil {
+		t.Fatalf("UpdateTuningOptions: %v", err)
+	}
+	if got := server.tuning.Load().CacheConsistency; got != CacheConsistencyCloseToOpen {
+		t.Errorf("CacheConsistency = %q, want %q", got, CacheConsistencyCloseToOpen)
+	}
+}
@@ -11,6 +11,7 @@ package absnfs
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
@@ -18,6 +19,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,12 +38,50 @@ type ServerOptions struct {
 	Debug            bool   // Enable debug logging
 	UsePortmapper    bool   // Whether to start portmapper service (requires root for port 111)
 	UseRecordMarking bool   // Use RPC record marking (required for standard NFS clients)
+
+	// PortmapperRateLimit caps portmapper requests per second from each
+	// source IP; requests beyond it are dropped without a reply
+	// Default: 0 (unlimited)
+	PortmapperRateLimit int
+
+	// OnConnect is called with each accepted connection that passed the
+	// AllowedIPs and connection limit checks, before any RPC is read from
+	// it, for admission policy beyond IP allowlists. A non-nil error
+	// closes the connection. It runs on the connection's own goroutine, so
+	// a slow check does not hold up other clients
+	// Default: nil (every connection is admitted)
+	OnConnect func(conn net.Conn) error
+
+	// EnabledVersions lists the NFS protocol versions served, from 2
+	// (RFC 1094, for legacy clients) and 3. Calls for any other version
+	// are answered PROG_MISMATCH, and only these versions are registered
+	// with the portmapper
+	// Default: nil (NFSv3 only)
+	EnabledVersions []int
+
+	// EnableUDP also serves NFS and MOUNT over UDP, on the same port as
+	// TCP, for clients and appliances that default to it. Each datagram
+	// carries one call without record marking; READ data and directory
+	// listings are capped at 32KB so each reply fits in one datagram.
+	// AllowedIPs and OnConnect are checked for every datagram, and at most
+	// MaxConnections (64 if unlimited) are served at once. Listen refuses
+	// it together with TLS, which UDP calls would bypass
+	// Default: false
+	EnableUDP bool
+
+	// EnableIPv6 binds the TCP and UDP listeners to the IPv6 wildcard
+	// address [::] in place of Hostname, which on a dual-stack host accepts
+	// IPv4 clients as well. Hostname is still the address the portmapper
+	// hands out. To bind a single IPv6 address, set Hostname to it instead
+	// Default: false
+	EnableIPv6 bool
 }
 
 // connectionState tracks the state of an active connection
 type connectionState struct {
 	lastActivity   time.Time
 	unregisterOnce sync.Once // Ensures connection is only unregistered once
+	admitted       bool      // OnConnect let it through and it counts in the metrics
 }
 
 // Server represents an NFS server instance
@@ -49,14 +89,23 @@ type Server struct {
 	options       ServerOptions
 	handler       *AbsfsNFS
 	listener      net.Listener
-	mountListener net.Listener // Separate listener for mount daemon
-	portmapper    *Portmapper  // Portmapper service
+	mountListener net.Listener   // Separate listener for mount daemon
+	udpConn       net.PacketConn // UDP socket when EnableUDP is set
+	portmapper    *Portmapper    // Portmapper service
 	logger        *log.Logger
 	ctx           context.Context
 	cancel        context.CancelFunc
 	wg            sync.WaitGroup
-	acceptErrs    atomic.Int32 // Counter for accept errors to prevent excessive logging
-	writeVerf     [8]byte      // Write verifier unique per server boot (RFC 1813)
+	acceptErrs    atomic.Int32                // Counter for accept errors to prevent excessive logging
+	writeVerf     [8]byte                     // Write verifier unique per server boot (RFC 1813)
+	exports       atomic.Pointer[ExportTable] // Export table from LoadExports (nil = "/" to all)
+	mounts        []exportMount               // Exports from AddExport; export i numbers its handles i+1
+	// handlerUnexported is set when handler came from AddExport rather
+	// than SetHandler, so it is not also served at "/"
+	handlerUnexported bool
+
+	// gss holds the RPCSEC_GSS contexts clients have established
+	gss gssContextTable
 
 	// Connection management
 	connMutex   sync.Mutex
@@ -75,6 +124,11 @@ func NewServer(options ServerOptions) (*Server, error) {
 	if options.Hostname == "" {
 		options.Hostname = "localhost"
 	}
+	for _, v := range options.EnabledVersions {
+		if v != NFS_V2 && v != NFS_V3 {
+			return nil, fmt.Errorf("unsupported NFS version %d", v)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s := &Server{
@@ -84,8 +138,12 @@ func NewServer(options ServerOptions) (*Server, error) {
 		cancel:      cancel,
 		activeConns: make(map[net.Conn]*connectionState),
 	}
-	// Initialize write verifier unique to this server boot (RFC 1813)
-	binary.BigEndian.PutUint64(s.writeVerf[:], uint64(time.Now().UnixNano()))
+	// Initialize write verifier unique to this server boot (RFC 1813). It is
+	// random so that two servers started within a clock tick still differ;
+	// the start time stands in if no randomness is available.
+	if _, err := rand.Read(s.writeVerf[:]); err != nil {
+		binary.BigEndian.PutUint64(s.writeVerf[:], uint64(time.Now().UnixNano()))
+	}
 	return s, nil
 }
 
@@ -93,6 +151,47 @@ func NewServer(options ServerOptions) (*Server, error) {
 // Must be called before Listen() and is not safe for concurrent use.
 func (s *Server) SetHandler(handler *AbsfsNFS) {
 	s.handler = handler
+	s.handlerUnexported = false
+	if handler != nil {
+		handler.nfsVersions = s.nfsVersions()
+	}
+}
+
+// nfsVersionEnabled reports whether EnabledVersions lets clients use NFS
+// version v
+func (s *Server) nfsVersionEnabled(v uint32) bool {
+	if len(s.options.EnabledVersions) == 0 {
+		return v == NFS_V3
+	}
+	for _, enabled := range s.options.EnabledVersions {
+		if uint32(enabled) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// nfsVersions returns the enabled NFS versions in ascending order
+func (s *Server) nfsVersions() []uint32 {
+	var versions []uint32
+	for _, v := range []uint32{NFS_V2, NFS_V3} {
+		if s.nfsVersionEnabled(v) {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// nfsVersionRange returns the lowest and highest enabled NFS versions, as
+// a PROG_MISMATCH reply reports them
+func (s *Server) nfsVersionRange() (uint32, uint32) {
+	if s.nfsVersionEnabled(NFS_V2) && !s.nfsVersionEnabled(NFS_V3) {
+		return NFS_V2, NFS_V2
+	}
+	if s.nfsVersionEnabled(NFS_V2) {
+		return NFS_V2, NFS_V3
+	}
+	return NFS_V3, NFS_V3
 }
 
 // isIPAllowed checks if the client IP is in the AllowedIPs list
@@ -108,12 +207,11 @@ func (s *Server) isIPAllowed(clientIP string) bool {
 	}
 
 	// Parse the client IP
-	ip := net.ParseIP(clientIP)
+	ip := parseClientIP(clientIP)
 	if ip == nil {
 		// Invalid IP, reject
 		return false
 	}
-	ip = normalizeIP(ip)
 
 	// Check against each allowed IP/subnet
 	for _, allowedIP := range policy.AllowedIPs {
@@ -144,7 +242,9 @@ func (s *Server) isIPAllowed(clientIP string) bool {
 }
 
 // Listen starts the NFS server
-// registerConnection adds a connection to the tracking map and increments the counter
+// registerConnection adds a connection to the tracking map and increments
+// the counter. It counts toward TotalConnections only once admitConnection
+// is called, after OnConnect lets it through.
 func (s *Server) registerConnection(conn net.Conn) bool {
 	if s.handler == nil {
 		return true // If no handler, always allow connections
@@ -177,6 +277,9 @@ func (s *Server) registerConnection(conn net.Conn) bool {
 					LogField{Key: "limit", Value: tuning.MaxConnections})
 			}
 		}
+		if s.handler.metrics != nil {
+			s.handler.metrics.RecordRejectedConnection()
+		}
 
 		return false
 	}
@@ -210,6 +313,20 @@ func (s *Server) registerConnection(conn net.Conn) bool {
 	return true
 }
 
+// admitConnection records a registered connection that OnConnect let
+// through as accepted in the metrics
+func (s *Server) admitConnection(conn net.Conn) {
+	if s.handler == nil || s.handler.metrics == nil {
+		return
+	}
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	if state, ok := s.activeConns[conn]; ok {
+		state.admitted = true
+		s.handler.metrics.RecordConnection()
+	}
+}
+
 // unregisterConnection removes a connection from the tracking map and decrements the counter
 // Uses sync.Once to ensure this only happens once per connection, preventing race conditions
 func (s *Server) unregisterConnection(conn net.Conn) {
@@ -230,6 +347,9 @@ func (s *Server) unregisterConnection(conn net.Conn) {
 		if _, stillExists := s.activeConns[conn]; stillExists {
 			delete(s.activeConns, conn)
 			s.connCount--
+			if state.admitted && s.handler != nil && s.handler.metrics != nil {
+				s.handler.metrics.RecordConnectionClosed()
+			}
 
 			if s.options.Debug {
 				s.logger.Printf("Connection closed (total: %d)", s.connCount)
@@ -257,6 +377,14 @@ func (s *Server) unregisterConnection(conn net.Conn) {
 	})
 }
 
+// ActiveConnections returns the number of client connections currently open,
+// which MaxConnections caps
+func (s *Server) ActiveConnections() int {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	return s.connCount
+}
+
 // updateConnectionActivity updates the last activity time for a connection
 func (s *Server) updateConnectionActivity(conn net.Conn) {
 	s.connMutex.Lock()
@@ -347,6 +475,15 @@ func (s *Server) idleConnectionCleanupLoop() {
 	}
 }
 
+// bindAddr returns the address the TCP and UDP listeners bind to
+func (s *Server) bindAddr() string {
+	host := s.options.Hostname
+	if s.options.EnableIPv6 {
+		host = "::"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(s.options.Port))
+}
+
 func (s *Server) Listen() error {
 	if s.handler == nil {
 		return fmt.Errorf("no handler set")
@@ -355,6 +492,12 @@ func (s *Server) Listen() error {
 	tuning := s.handler.tuning.Load()
 	policy := s.handler.policy.Load()
 
+	// Datagrams carry no TLS, so serving them would bypass it and the
+	// client certificate checks it enforces
+	if s.options.EnableUDP && policy.TLS != nil && policy.TLS.Enabled {
+		return fmt.Errorf("EnableUDP cannot be combined with TLS")
+	}
+
 	// Start periodic idle connection cleanup if needed
 	if tuning.IdleTimeout > 0 {
 		s.wg.Add(1)
@@ -370,7 +513,7 @@ func (s *Server) Listen() error {
 	}
 
 	// Try to bind to the specified port
-	addr := fmt.Sprintf("%s:%d", s.options.Hostname, s.options.Port)
+	addr := s.bindAddr()
 
 	// Check if TLS is enabled
 	var listener net.Listener
@@ -428,6 +571,13 @@ func (s *Server) Listen() error {
 
 	procHandler := &NFSProcedureHandler{server: s}
 
+	if s.options.EnableUDP {
+		if err := s.listenUDP(procHandler); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -452,6 +602,7 @@ func (s *Server) StartWithPortmapper() error {
 	s.portmapper = NewPortmapper()
 	s.portmapper.SetDebug(s.options.Debug)
 	s.portmapper.SetListenAddr(s.options.Hostname)
+	s.portmapper.SetRateLimit(s.options.PortmapperRateLimit)
 	if err := s.portmapper.Start(); err != nil {
 		return fmt.Errorf("failed to start portmapper: %w", err)
 	}
@@ -468,17 +619,37 @@ func (s *Server) StartWithPortmapper() error {
 		nfsPort = 2049
 	}
 
-	// Register NFS service
-	s.portmapper.RegisterService(NFS_PROGRAM, NFS_V3, IPPROTO_TCP, nfsPort)
+	// Register NFS service for each enabled version
+	low, high := s.nfsVersionRange()
+	for v := low; v <= high; v++ {
+		if s.nfsVersionEnabled(v) {
+			s.portmapper.RegisterService(NFS_PROGRAM, v, IPPROTO_TCP, nfsPort)
+			if s.options.EnableUDP {
+				s.portmapper.RegisterService(NFS_PROGRAM, v, IPPROTO_UDP, nfsPort)
+			}
+		}
+	}
 
 	// Register MOUNT service (same port in this implementation)
-	// Register for both v1 and v3 since some clients (like showmount) use v1
+	// Register for both v1 and v3: NFSv2 clients and showmount use v1
 	mountPort := uint32(s.options.MountPort)
 	if mountPort == 0 {
 		mountPort = nfsPort // Use NFS port for mount if not specified
 	}
-	s.portmapper.RegisterService(MOUNT_PROGRAM, 1, IPPROTO_TCP, mountPort)        // v1
+	s.portmapper.RegisterService(MOUNT_PROGRAM, MOUNT_V1, IPPROTO_TCP, mountPort) // v1
 	s.portmapper.RegisterService(MOUNT_PROGRAM, MOUNT_V3, IPPROTO_TCP, mountPort) // v3
+	if s.options.EnableUDP {
+		s.portmapper.RegisterService(MOUNT_PROGRAM, MOUNT_V1, IPPROTO_UDP, mountPort)
+		s.portmapper.RegisterService(MOUNT_PROGRAM, MOUNT_V3, IPPROTO_UDP, mountPort)
+	}
+
+	// Register the lock manager on the NFS port
+	if s.handler.tuning.Load().EnableLocking {
+		s.portmapper.RegisterService(NLM_PROGRAM, NLM_V4, IPPROTO_TCP, nfsPort)
+		if s.options.EnableUDP {
+			s.portmapper.RegisterService(NLM_PROGRAM, NLM_V4, IPPROTO_UDP, nfsPort)
+		}
+	}
 
 	s.logger.Printf("NFS server started with portmapper (NFS port: %d, Mount port: %d)", nfsPort, mountPort)
 
@@ -570,6 +741,9 @@ func (s *Server) acceptLoop(procHandler *NFSProcedureHandler) {
 							slog.Warn("connection rejected: IP not allowed")
 						}
 					}
+					if s.handler.metrics != nil {
+						s.handler.metrics.RecordRejectedConnection()
+					}
 				}
 
 				conn.Close()
@@ -635,6 +809,13 @@ func (s *Server) acceptLoop(procHandler *NFSProcedureHandler) {
 						s.logger.Printf("recovered panic in connection handler: %v", r)
 					}
 				}()
+				if s.options.OnConnect != nil {
+					if err := s.options.OnConnect(conn); err != nil {
+						s.rejectConnection(conn, err)
+						return
+					}
+				}
+				s.admitConnection(conn)
 				if s.options.UseRecordMarking {
 					s.handleConnectionWithRecordMarking(conn, procHandler)
 				} else {
@@ -645,6 +826,30 @@ func (s *Server) acceptLoop(procHandler *NFSProcedureHandler) {
 	}
 }
 
+// rejectConnection closes a connection OnConnect refused, logging why
+func (s *Server) rejectConnection(conn net.Conn, reason error) {
+	if s.options.Debug {
+		s.logger.Printf("Connection rejected by OnConnect: %s: %v", conn.RemoteAddr(), reason)
+	}
+	if s.handler != nil {
+		if slog := s.handler.getStructuredLogger(); slog != nil {
+			tuning := s.handler.tuning.Load()
+			if tuning.Log != nil && tuning.Log.LogClientIPs {
+				slog.Warn("connection rejected by OnConnect",
+					LogField{Key: "client", Value: conn.RemoteAddr().String()},
+					LogField{Key: "error", Value: reason})
+			} else {
+				slog.Warn("connection rejected by OnConnect",
+					LogField{Key: "error", Value: reason})
+			}
+		}
+		if s.handler.metrics != nil {
+			s.handler.metrics.RecordRejectedConnection()
+		}
+	}
+	conn.Close()
+}
+
 // connIO abstracts the read/write framing for a connection, allowing the
 // shared connection loop to work with both raw TCP and record-marking modes.
 type connIO interface {
@@ -775,32 +980,20 @@ func (s *Server) handleConnectionLoop(conn net.Conn, procHandler *NFSProcedureHa
 				}
 			}
 
+			// Only a certificate verified against the configured CA may
+			// stand in for the client's identity
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				authCtx.TLSEnabled = true
+				state := tlsConn.ConnectionState()
+				if len(state.VerifiedChains) > 0 {
+					authCtx.ClientCert = state.PeerCertificates[0]
+				}
+			}
+
 			// Check rate limit
 			if connRateLimiter != nil && s.handler != nil && s.handler.policy.Load().EnableRateLimiting {
 				if !connRateLimiter.AllowRequest(authCtx.ClientIP, connID) {
-					reply := &RPCReply{
-						Header: call.Header,
-						Status: MSG_DENIED,
-						Verifier: RPCVerifier{
-							Flavor: 0,
-							Body:   []byte{},
-						},
-					}
-					if s.options.Debug {
-						s.logger.Printf("Rate limit exceeded for client %s", authCtx.ClientIP)
-					}
-					if slog := s.handler.getStructuredLogger(); slog != nil {
-						tuning := s.handler.tuning.Load()
-						if tuning.Log != nil && tuning.Log.LogClientIPs {
-							slog.Warn("rate limit exceeded",
-								LogField{Key: "client_ip", Value: authCtx.ClientIP})
-						} else {
-							slog.Warn("rate limit exceeded")
-						}
-					}
-					if s.handler.metrics != nil {
-						s.handler.metrics.RecordRateLimitExceeded()
-					}
+					reply := s.rateLimitedReply(call, authCtx)
 					if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err == nil {
 						if writeErr := cio.WriteReply(reply); writeErr != nil {
 							return
@@ -810,35 +1003,8 @@ func (s *Server) handleConnectionLoop(conn net.Conn, procHandler *NFSProcedureHa
 				}
 			}
 
-			// Dispatch to handler via worker pool or directly
-			var reply *RPCReply
-			var handleErr error
-
-			if s.handler != nil && s.handler.workerPool != nil {
-				result := s.handler.ExecuteWithWorker(func() interface{} {
-					r, e := procHandler.HandleCall(call, body, authCtx)
-					return struct {
-						Reply *RPCReply
-						Err   error
-					}{r, e}
-				})
-				typedResult, ok := result.(struct {
-					Reply *RPCReply
-					Err   error
-				})
-				if !ok {
-					s.logger.Printf("worker pool returned unexpected result type")
-					return
-				}
-				reply, handleErr = typedResult.Reply, typedResult.Err
-			} else {
-				reply, handleErr = procHandler.HandleCall(call, body, authCtx)
-			}
-
-			if handleErr != nil {
-				if s.options.Debug {
-					s.logger.Printf("handle error: %v", handleErr)
-				}
+			reply, err := s.dispatchCall(procHandler, call, body, authCtx)
+			if err != nil {
 				return
 			}
 
@@ -860,6 +1026,80 @@ func (s *Server) handleConnectionLoop(conn net.Conn, procHandler *NFSProcedureHa
 	}
 }
 
+// rateLimitedReply records a call refused by the rate limiter and returns
+// the MSG_DENIED reply to send for it
+func (s *Server) rateLimitedReply(call *RPCCall, authCtx *AuthContext) *RPCReply {
+	if s.options.Debug {
+		s.logger.Printf("Rate limit exceeded for client %s", authCtx.ClientIP)
+	}
+	if slog := s.handler.getStructuredLogger(); slog != nil {
+		tuning := s.handler.tuning.Load()
+		if tuning.Log != nil && tuning.Log.LogClientIPs {
+			slog.Warn("rate limit exceeded",
+				LogField{Key: "client_ip", Value: authCtx.ClientIP})
+		} else {
+			slog.Warn("rate limit exceeded")
+		}
+	}
+	if s.handler.metrics != nil {
+		s.handler.metrics.RecordRateLimitExceeded()
+	}
+	return &RPCReply{
+		Header: call.Header,
+		Status: MSG_DENIED,
+		Verifier: RPCVerifier{
+			Flavor: 0,
+			Body:   []byte{},
+		},
+	}
+}
+
+// dispatchCall hands call to procHandler, via the worker pool when there is
+// one. An error means the call was abandoned or failed without a reply.
+func (s *Server) dispatchCall(procHandler *NFSProcedureHandler, call *RPCCall, body io.Reader, authCtx *AuthContext) (*RPCReply, error) {
+	var reply *RPCReply
+	var handleErr error
+
+	if s.handler != nil && s.handler.workerPool != nil {
+		// Queued work shares the request deadline HandleCall enforces,
+		// so a request that expires in the queue never takes a worker
+		reqCtx, reqCancel := context.WithTimeout(context.Background(), s.handler.tuning.Load().Timeouts.DefaultTimeout)
+		result, err := s.handler.executeWithWorker(reqCtx, isMetadataCall(call), func() interface{} {
+			r, e := procHandler.HandleCall(call, body, authCtx)
+			return struct {
+				Reply *RPCReply
+				Err   error
+			}{r, e}
+		})
+		reqCancel()
+		if err != nil {
+			if s.options.Debug {
+				s.logger.Printf("request abandoned before a worker picked it up: %v", err)
+			}
+			return nil, err
+		}
+		typedResult, ok := result.(struct {
+			Reply *RPCReply
+			Err   error
+		})
+		if !ok {
+			s.logger.Printf("worker pool returned unexpected result type")
+			return nil, fmt.Errorf("unexpected worker result type %T", result)
+		}
+		reply, handleErr = typedResult.Reply, typedResult.Err
+	} else {
+		reply, handleErr = procHandler.HandleCall(call, body, authCtx)
+	}
+
+	if handleErr != nil {
+		if s.options.Debug {
+			s.logger.Printf("handle error: %v", handleErr)
+		}
+		return nil, handleErr
+	}
+	return reply, nil
+}
+
 // Stop stops the NFS server
 func (s *Server) Stop() error {
 	s.cancel() // Signal all goroutines to stop
@@ -879,6 +1119,11 @@ func (s *Server) Stop() error {
 		s.mountListener.Close()
 	}
 
+	// Close the UDP socket to end its read loop
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+
 	// Close all active connections
 	s.closeAllConnections()
 
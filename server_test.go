@@ -9,6 +9,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1219,3 +1221,153 @@ func TestSetLoggerCoverage(t *testing.T) {
 		_ = nfs.SetLogger(nil)
 	})
 }
+
+func TestServerOnConnect(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+
+	// Reserve a local port to dial the rejected connection from
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	rejectedPort := reserved.Addr().(*net.TCPAddr).Port
+	reserved.Close()
+	rejectLo, rejectHi := rejectedPort, rejectedPort
+
+	var mu sync.Mutex
+	var rejected []int
+	server, err := NewServer(ServerOptions{
+		Port:     0,
+		Hostname: "127.0.0.1",
+		OnConnect: func(conn net.Conn) error {
+			port := conn.RemoteAddr().(*net.TCPAddr).Port
+			if port >= rejectLo && port <= rejectHi {
+				mu.Lock()
+				rejected = append(rejected, port)
+				mu.Unlock()
+				return fmt.Errorf("port %d not admitted", port)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetHandler(nfs)
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	// NULL call: xid, CALL, RPC version 2, NFS v3 NULL, AUTH_NONE
+	// credential and verifier
+	var call bytes.Buffer
+	for _, v := range []uint32{42, RPC_CALL, 2, NFS_PROGRAM, NFS_V3, NFSPROC3_NULL, AUTH_NONE, 0, AUTH_NONE, 0} {
+		xdrEncodeUint32(&call, v)
+	}
+	nullCall := func(dialer *net.Dialer) ([]byte, error) {
+		t.Helper()
+		conn, err := dialer.Dial("tcp", server.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(testConnTimeout))
+		if _, err := conn.Write(call.Bytes()); err != nil {
+			return nil, err
+		}
+		// xid and REPLY
+		reply := make([]byte, 8)
+		_, err = io.ReadFull(conn, reply)
+		return reply, err
+	}
+
+	reply, err := nullCall(&net.Dialer{})
+	if err != nil {
+		t.Fatalf("Admitted connection: NULL failed: %v", err)
+	}
+	if xid, msgType := binary.BigEndian.Uint32(reply), binary.BigEndian.Uint32(reply[4:]); xid != 42 || msgType != RPC_REPLY {
+		t.Errorf("Admitted connection: got xid %d, message type %d, want a reply to xid 42", xid, msgType)
+	}
+
+	if _, err := nullCall(&net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: rejectedPort}}); err == nil {
+		t.Error("Rejected connection: NULL got a reply")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rejected) != 1 || rejected[0] != rejectedPort {
+		t.Errorf("OnConnect rejected ports %v, want [%d]", rejected, rejectedPort)
+	}
+
+	// The refused connection counts as rejected only, not as accepted too
+	// (read atomically: the admitted connection's goroutine may still be
+	// recording its close)
+	total := atomic.LoadUint64(&nfs.metrics.metrics.TotalConnections)
+	refused := atomic.LoadUint64(&nfs.metrics.metrics.RejectedConnections)
+	if total != 1 || refused != 1 {
+		t.Errorf("Connection metrics = total %d rejected %d, want 1 and 1", total, refused)
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	probe, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	probe.Close()
+
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(fs, ExportOptions{AllowedIPs: []string{"::1/128"}})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+
+	server, err := NewServer(ServerOptions{Port: 0, EnableIPv6: true, UseRecordMarking: true})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetHandler(nfs)
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Stop()
+	if host, _, _ := net.SplitHostPort(server.listener.Addr().String()); host != "::" {
+		t.Errorf("Listener bound to %s, want ::", host)
+	}
+
+	conn, err := net.DialTimeout("tcp6", net.JoinHostPort("::1", fmt.Sprint(server.options.Port)), testTimeout)
+	if err != nil {
+		t.Fatalf("Dial over IPv6 failed: %v", err)
+	}
+	defer conn.Close()
+
+	var msg bytes.Buffer
+	for _, v := range []uint32{9, RPC_CALL, 2, NFS_PROGRAM, NFS_V3, NFSPROC3_NULL, AUTH_NONE, 0, AUTH_NONE, 0} {
+		xdrEncodeUint32(&msg, v)
+	}
+	rm := NewRecordMarkingConn(conn, conn)
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	if err := rm.WriteRecord(msg.Bytes()); err != nil {
+		t.Fatalf("WriteRecord failed: %v", err)
+	}
+	reply, err := rm.ReadRecord()
+	if err != nil {
+		t.Fatalf("NULL over IPv6 got no reply: %v", err)
+	}
+	// xid, REPLY, MSG_ACCEPTED, verifier(flavor, len), accept_stat
+	if len(reply) < 24 || binary.BigEndian.Uint32(reply[20:24]) != SUCCESS {
+		t.Errorf("NULL over IPv6: unexpected reply %x", reply)
+	}
+}
@@ -0,0 +1,58 @@
+// sessions.go: Tracking of active mount sessions.
+//
+// A session is a distinct (client address, mount path) pair. MNT opens
+// a session, UMNT closes it, UMNTALL closes all of one client's, and
+// stopping the export drops them all.
+// Clients that disappear without unmounting keep their session, the same
+// as in a kernel server's rmtab.
+package absnfs
+
+// mountSession identifies one client's mount of one path
+type mountSession struct {
+	client string
+	path   string
+}
+
+// addSession records that client has mounted mountPath
+func (s *AbsfsNFS) addSession(client, mountPath string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[mountSession]struct{})
+	}
+	s.sessions[mountSession{client: client, path: mountPath}] = struct{}{}
+}
+
+// removeSession records that client has unmounted mountPath
+func (s *AbsfsNFS) removeSession(client, mountPath string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	delete(s.sessions, mountSession{client: client, path: mountPath})
+}
+
+// removeClientSessions records that client has unmounted every path
+func (s *AbsfsNFS) removeClientSessions(client string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	for session := range s.sessions {
+		if session.client == client {
+			delete(s.sessions, session)
+		}
+	}
+}
+
+// clearSessions drops every session, used when the export stops
+func (s *AbsfsNFS) clearSessions() {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	s.sessions = nil
+}
+
+// ActiveSessions returns the number of distinct (client, mount path)
+// sessions currently mounted. Unlike the connection count this does not
+// change when a client reconnects or opens several connections.
+func (s *AbsfsNFS) ActiveSessions() int {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	return len(s.sessions)
+}
@@ -0,0 +1,73 @@
+// setattr_support.go: SETATTR fields the backing filesystem cannot change.
+//
+// absfs requires Chmod, Chown and Chtimes of every filesystem, but some
+// can only fail them, such as an object store without owners. Such a
+// filesystem implements SetattrSupporter, and ExportOptions.UnsupportedSetattr
+// picks what SETATTR does with a field it cannot change: call the backing
+// filesystem anyway and return its error (the default), refuse the whole
+// request with NFSERR_NOTSUPP, or leave the field unchanged and succeed.
+package absnfs
+
+import "fmt"
+
+// SetattrField is a group of attributes SETATTR changes with one backing
+// filesystem call
+type SetattrField int
+
+const (
+	SetattrMode  SetattrField = iota // mode bits, through Chmod
+	SetattrOwner                     // uid and gid, through Chown
+	SetattrTimes                     // atime and mtime, through Chtimes
+)
+
+// SetattrSupporter is implemented by backing filesystems that cannot
+// change every attribute
+type SetattrSupporter interface {
+	// SupportsSetattr reports whether the filesystem can change field
+	SupportsSetattr(field SetattrField) bool
+}
+
+// UnsupportedSetattr values
+const (
+	UnsupportedSetattrNotSupp  = "notsupp"
+	UnsupportedSetattrCosmetic = "cosmetic"
+)
+
+// validateUnsupportedSetattr checks an UnsupportedSetattr value
+func validateUnsupportedSetattr(policy string) error {
+	switch policy {
+	case "", UnsupportedSetattrNotSupp, UnsupportedSetattrCosmetic:
+		return nil
+	}
+	return fmt.Errorf("invalid UnsupportedSetattr %q: must be notsupp, cosmetic, or empty", policy)
+}
+
+// checkSetattrSupport applies the UnsupportedSetattr policy to the fields
+// sattr sets that the backing filesystem cannot change. It returns
+// NFSERR_NOTSUPP under the "notsupp" policy; under "cosmetic" it clears
+// those fields from sattr so the rest of the request goes ahead.
+func (h *NFSProcedureHandler) checkSetattrSupport(sattr *sattr3) uint32 {
+	supporter := h.nfs().setattrSupporter
+	policy := h.nfs().policy.Load().UnsupportedSetattr
+	if supporter == nil || policy == "" {
+		return NFS_OK
+	}
+	for _, f := range []struct {
+		field SetattrField
+		set   bool
+		clear func()
+	}{
+		{SetattrMode, sattr.SetMode, func() { sattr.SetMode = false }},
+		{SetattrOwner, sattr.SetUID || sattr.SetGID, func() { sattr.SetUID, sattr.SetGID = false, false }},
+		{SetattrTimes, sattr.SetAtime != 0 || sattr.SetMtime != 0, func() { sattr.SetAtime, sattr.SetMtime = 0, 0 }},
+	} {
+		if !f.set || supporter.SupportsSetattr(f.field) {
+			continue
+		}
+		if policy == UnsupportedSetattrNotSupp {
+			return NFSERR_NOTSUPP
+		}
+		f.clear()
+	}
+	return NFS_OK
+}
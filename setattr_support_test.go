@@ -0,0 +1,99 @@
+package absnfs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// noChownFS is a filesystem without owners: it cannot change them
+type noChownFS struct {
+	*memfs.FileSystem
+	chowns int
+}
+
+func (f *noChownFS) SupportsSetattr(field SetattrField) bool { return field != SetattrOwner }
+
+func (f *noChownFS) Chown(name string, uid, gid int) error {
+	f.chowns++
+	return errors.ErrUnsupported
+}
+
+func TestSetattrUnsupportedField(t *testing.T) {
+	setattr := func(t *testing.T, policy string) (*noChownFS, uint32) {
+		t.Helper()
+		mfs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, mfs, "/file.txt", "hello")
+		mfs.Chmod("/file.txt", 0644)
+		fs := &noChownFS{FileSystem: mfs}
+		nfs, err := New(fs, ExportOptions{UnsupportedSetattr: policy})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { nfs.Close() })
+		handler := &NFSProcedureHandler{server: &Server{handler: nfs}}
+		node, err := nfs.Lookup("/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// One request changing the mode, which the filesystem supports,
+		// and the group, which it does not
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, nfs.fileMap.Allocate(node))
+		buf.Write(encodeSattr3(true, 0600, false, 0, true, 42, false, 0, 0, 0, 0, 0, 0, 0))
+		xdrEncodeUint32(&buf, 0) // no guard
+		result, err := handler.handleSetattr(&buf, &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleSetattr: %v", err)
+		}
+		return fs, readStatus(t, result)
+	}
+	mode := func(t *testing.T, fs *noChownFS) os.FileMode {
+		info, err := fs.Stat("/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Mode().Perm()
+	}
+
+	t.Run("notsupp refuses the whole request", func(t *testing.T) {
+		fs, status := setattr(t, UnsupportedSetattrNotSupp)
+		if status != NFSERR_NOTSUPP {
+			t.Errorf("status %d, want NFSERR_NOTSUPP", status)
+		}
+		if m := mode(t, fs); m != 0644 || fs.chowns != 0 {
+			t.Errorf("refused SETATTR left mode %v after %d Chowns, want 0644 and none", m, fs.chowns)
+		}
+	})
+
+	t.Run("cosmetic applies the supported fields", func(t *testing.T) {
+		fs, status := setattr(t, UnsupportedSetattrCosmetic)
+		if status != NFS_OK {
+			t.Errorf("status %d, want NFS_OK", status)
+		}
+		if m := mode(t, fs); m != 0600 || fs.chowns != 0 {
+			t.Errorf("cosmetic SETATTR left mode %v after %d Chowns, want 0600 and none", m, fs.chowns)
+		}
+	})
+
+	t.Run("default calls the filesystem anyway", func(t *testing.T) {
+		fs, status := setattr(t, "")
+		if status == NFS_OK || fs.chowns != 1 {
+			t.Errorf("status %d after %d Chowns, want the Chown error", status, fs.chowns)
+		}
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		mfs, _ := memfs.NewFS()
+		if _, err := New(mfs, ExportOptions{UnsupportedSetattr: "ignore"}); err == nil {
+			t.Error("New accepted UnsupportedSetattr \"ignore\"")
+		}
+	})
+}
@@ -0,0 +1,400 @@
+// subtree.go: Exports restricted to a subdirectory of the backing filesystem.
+//
+// When ExportOptions.ExportRoot is set, New wraps the backing filesystem in
+// a view whose "/" is that directory, so LOOKUP, MOUNT and every path the
+// export resolves stay beneath it. The view follows symlinks component by
+// component itself rather than letting the backing filesystem do it: a
+// link whose target leads outside the subtree reads as dangling
+// (os.ErrNotExist) instead of reaching the rest of the backing filesystem.
+// Absolute link targets are stored in backing-filesystem terms and shown
+// to clients relative to the subtree.
+package absnfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// subtreeView presents the directory root of fs as a filesystem of its own
+type subtreeView struct {
+	fs   absfs.SymlinkFileSystem
+	root string
+}
+
+// newSubtreeView returns the view of fs to export for ExportRoot root,
+// which must name an existing directory
+func newSubtreeView(fs absfs.SymlinkFileSystem, root string) (*subtreeView, error) {
+	if !strings.HasPrefix(root, "/") {
+		return nil, fmt.Errorf("ExportRoot %q is not absolute", root)
+	}
+	root = path.Clean(root)
+	info, err := fs.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("ExportRoot %q: %w", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("ExportRoot %q is not a directory", root)
+	}
+	return &subtreeView{fs: fs, root: root}, nil
+}
+
+// backing returns the backing path of the components below root
+func (v *subtreeView) backing(components []string) string {
+	if len(components) == 0 {
+		return v.root
+	}
+	return path.Join(v.root, strings.Join(components, "/"))
+}
+
+// within returns the components of the backing path p below root, and
+// false if p lies outside the subtree
+func (v *subtreeView) within(p string) ([]string, bool) {
+	p = path.Clean(p)
+	if p == v.root {
+		return nil, true
+	}
+	prefix := v.root
+	if prefix != "/" {
+		prefix += "/"
+	}
+	if !strings.HasPrefix(p, prefix) {
+		return nil, false
+	}
+	return strings.Split(p[len(prefix):], "/"), true
+}
+
+// resolve maps name, a path within the view, to its backing path. Symlinks
+// are followed in every component but the last, and in the last too when
+// followFinal is set; a link leading outside the subtree fails with
+// os.ErrNotExist, as a dangling link would.
+func (v *subtreeView) resolve(op, name string, followFinal bool) (string, error) {
+	pending := strings.Split(name, "/")
+	var resolved []string // components of the resolved prefix below root
+	expansions := 0
+	for len(pending) > 0 {
+		comp := pending[0]
+		pending = pending[1:]
+		switch comp {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		resolved = append(resolved, comp)
+
+		if !followFinal && !hasNextComponent(pending) {
+			continue
+		}
+		current := v.backing(resolved)
+		info, err := v.fs.Lstat(current)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// A missing component is left for the backing call to report
+			continue
+		}
+		if expansions++; expansions > defaultMaxSymlinkDepth {
+			return "", &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		target, err := v.fs.Readlink(current)
+		if err != nil {
+			return "", &os.PathError{Op: op, Path: name, Err: err}
+		}
+		resolved = resolved[:len(resolved)-1]
+		if strings.HasPrefix(target, "/") {
+			components, ok := v.within(target)
+			if !ok {
+				return "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+			}
+			resolved = components
+			continue
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return v.backing(resolved), nil
+}
+
+// OpenFile implements absfs.Filer
+func (v *subtreeView) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	p, err := v.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return v.fs.OpenFile(p, flag, perm)
+}
+
+// Mkdir implements absfs.Filer
+func (v *subtreeView) Mkdir(name string, perm os.FileMode) error {
+	p, err := v.resolve("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	return v.fs.Mkdir(p, perm)
+}
+
+// Remove implements absfs.Filer
+func (v *subtreeView) Remove(name string) error {
+	p, err := v.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return v.fs.Remove(p)
+}
+
+// Rename implements absfs.Filer
+func (v *subtreeView) Rename(oldpath, newpath string) error {
+	from, err := v.resolve("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	to, err := v.resolve("rename", newpath, false)
+	if err != nil {
+		return err
+	}
+	return v.fs.Rename(from, to)
+}
+
+// Stat implements absfs.Filer
+func (v *subtreeView) Stat(name string) (os.FileInfo, error) {
+	p, err := v.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return v.fs.Stat(p)
+}
+
+// Chmod implements absfs.Filer
+func (v *subtreeView) Chmod(name string, mode os.FileMode) error {
+	p, err := v.resolve("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	return v.fs.Chmod(p, mode)
+}
+
+// Chtimes implements absfs.Filer
+func (v *subtreeView) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := v.resolve("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	return v.fs.Chtimes(p, atime, mtime)
+}
+
+// Chown implements absfs.Filer
+func (v *subtreeView) Chown(name string, uid, gid int) error {
+	p, err := v.resolve("chown", name, true)
+	if err != nil {
+		return err
+	}
+	return v.fs.Chown(p, uid, gid)
+}
+
+// ReadDir implements absfs.Filer
+func (v *subtreeView) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := v.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return v.fs.ReadDir(p)
+}
+
+// ReadFile implements absfs.Filer
+func (v *subtreeView) ReadFile(name string) ([]byte, error) {
+	p, err := v.resolve("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return v.fs.ReadFile(p)
+}
+
+// Sub implements absfs.Filer
+func (v *subtreeView) Sub(dir string) (fs.FS, error) {
+	return absfs.FilerToFS(v, dir)
+}
+
+// Chdir implements absfs.FileSystem. The view resolves every name against
+// its root, so it has no working directory to change.
+func (v *subtreeView) Chdir(dir string) error {
+	return &os.PathError{Op: "chdir", Path: dir, Err: errors.ErrUnsupported}
+}
+
+// Getwd implements absfs.FileSystem
+func (v *subtreeView) Getwd() (string, error) {
+	return "/", nil
+}
+
+// TempDir implements absfs.FileSystem. The backing filesystem's temporary
+// directory generally lies outside the subtree, so the view offers none.
+func (v *subtreeView) TempDir() string {
+	return "/"
+}
+
+// Open implements absfs.FileSystem
+func (v *subtreeView) Open(name string) (absfs.File, error) {
+	return v.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create implements absfs.FileSystem
+func (v *subtreeView) Create(name string) (absfs.File, error) {
+	return v.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// MkdirAll implements absfs.FileSystem
+func (v *subtreeView) MkdirAll(name string, perm os.FileMode) error {
+	p, err := v.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	return v.fs.MkdirAll(p, perm)
+}
+
+// RemoveAll implements absfs.FileSystem
+func (v *subtreeView) RemoveAll(name string) error {
+	p, err := v.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return v.fs.RemoveAll(p)
+}
+
+// Truncate implements absfs.FileSystem
+func (v *subtreeView) Truncate(name string, size int64) error {
+	p, err := v.resolve("truncate", name, true)
+	if err != nil {
+		return err
+	}
+	return v.fs.Truncate(p, size)
+}
+
+// Lstat implements absfs.SymLinker
+func (v *subtreeView) Lstat(name string) (os.FileInfo, error) {
+	p, err := v.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return v.fs.Lstat(p)
+}
+
+// Lchown implements absfs.SymLinker
+func (v *subtreeView) Lchown(name string, uid, gid int) error {
+	p, err := v.resolve("lchown", name, false)
+	if err != nil {
+		return err
+	}
+	return v.fs.Lchown(p, uid, gid)
+}
+
+// Readlink implements absfs.SymLinker. An absolute target inside the
+// subtree is returned relative to the view's root; others come back as
+// stored, which clients will find dangling.
+func (v *subtreeView) Readlink(name string) (string, error) {
+	p, err := v.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	target, err := v.fs.Readlink(p)
+	if err != nil || !strings.HasPrefix(target, "/") {
+		return target, err
+	}
+	if components, ok := v.within(target); ok {
+		return "/" + strings.Join(components, "/"), nil
+	}
+	return target, nil
+}
+
+// Symlink implements absfs.SymLinker. An absolute oldname is taken
+// relative to the view's root and stored as a backing path.
+func (v *subtreeView) Symlink(oldname, newname string) error {
+	p, err := v.resolve("symlink", newname, false)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(oldname, "/") {
+		oldname = path.Join(v.root, oldname)
+	}
+	return v.fs.Symlink(oldname, p)
+}
+
+// scopeCapabilities makes the optional backend capabilities New found on
+// the backing filesystem take paths within the view
+func (v *subtreeView) scopeCapabilities(s *AbsfsNFS) {
+	if s.linker != nil {
+		s.linker = subtreeLinker{v, s.linker}
+	}
+	if s.mknoder != nil {
+		s.mknoder = subtreeMknoder{v, s.mknoder}
+	}
+	if s.advisor != nil {
+		s.advisor = subtreeAdvisor{v, s.advisor}
+	}
+	if s.checksummer != nil {
+		s.checksummer = subtreeChecksummer{v, s.checksummer}
+	}
+}
+
+type subtreeLinker struct {
+	v *subtreeView
+	l Linker
+}
+
+func (l subtreeLinker) Link(oldname, newname string) error {
+	from, err := l.v.resolve("link", oldname, false)
+	if err != nil {
+		return err
+	}
+	to, err := l.v.resolve("link", newname, false)
+	if err != nil {
+		return err
+	}
+	return l.l.Link(from, to)
+}
+
+type subtreeMknoder struct {
+	v *subtreeView
+	m Mknoder
+}
+
+func (m subtreeMknoder) Mknod(name string, mode os.FileMode, major, minor uint32) error {
+	p, err := m.v.resolve("mknod", name, false)
+	if err != nil {
+		return err
+	}
+	return m.m.Mknod(p, mode, major, minor)
+}
+
+type subtreeAdvisor struct {
+	v *subtreeView
+	a Advisor
+}
+
+func (a subtreeAdvisor) Advise(name string, offset, length int64, advice Advice) error {
+	p, err := a.v.resolve("advise", name, true)
+	if err != nil {
+		return err
+	}
+	return a.a.Advise(p, offset, length, advice)
+}
+
+type subtreeChecksummer struct {
+	v  *subtreeView
+	cv ChecksumVerifier
+}
+
+func (c subtreeChecksummer) VerifyChecksum(name string, offset int64, data []byte) error {
+	p, err := c.v.resolve("verify", name, true)
+	if err != nil {
+		return err
+	}
+	return c.cv.VerifyChecksum(p, offset, data)
+}
@@ -0,0 +1,182 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// newSubtreeFS returns a memfs with an export subtree at /srv/export and
+// a file outside it
+func newSubtreeFS(t *testing.T) *memfs.FileSystem {
+	t.Helper()
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("memfs: %v", err)
+	}
+	if err := mfs.MkdirAll("/srv/export/docs", 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	writeTestFile(t, mfs, "/srv/export/docs/inside.txt", "inside")
+	writeTestFile(t, mfs, "/secret.txt", "secret")
+	return mfs
+}
+
+func TestExportRoot(t *testing.T) {
+	mfs := newSubtreeFS(t)
+	nfs, err := New(mfs, ExportOptions{ExportRoot: "/srv/export"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+
+	t.Run("root is the subtree", func(t *testing.T) {
+		root, err := nfs.Lookup("/")
+		if err != nil {
+			t.Fatalf("Lookup(/): %v", err)
+		}
+		entries, err := nfs.ReadDir(root)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		if len(entries) != 1 || entries[0].path != "/docs" {
+			t.Fatalf("root entries = %v, want only /docs", entries)
+		}
+		node, err := nfs.Lookup("/docs/inside.txt")
+		if err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+		data, err := nfs.Read(node, 0, 100)
+		if err != nil || string(data) != "inside" {
+			t.Fatalf("Read = %q, %v; want %q", data, err, "inside")
+		}
+		if _, err := nfs.Lookup("/secret.txt"); err == nil {
+			t.Fatal("Lookup(/secret.txt) found a file outside the subtree")
+		}
+	})
+
+	t.Run("writes land in the subtree", func(t *testing.T) {
+		root, err := nfs.Lookup("/")
+		if err != nil {
+			t.Fatalf("Lookup(/): %v", err)
+		}
+		if _, err := nfs.Create(root, "new.txt", &NFSAttrs{Mode: 0644}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := mfs.Stat("/srv/export/new.txt"); err != nil {
+			t.Fatalf("created file missing from subtree: %v", err)
+		}
+		if _, err := mfs.Stat("/new.txt"); err == nil {
+			t.Fatal("created file appeared at the backing root")
+		}
+	})
+
+	t.Run("escaping symlinks are dangling", func(t *testing.T) {
+		links := map[string]string{
+			"/srv/export/abs":      "/secret.txt",
+			"/srv/export/rel":      "../../secret.txt",
+			"/srv/export/docs/dir": "/srv",
+		}
+		for link, target := range links {
+			if err := mfs.Symlink(target, link); err != nil {
+				t.Fatalf("Symlink %s: %v", link, err)
+			}
+		}
+		for _, p := range []string{"/abs", "/rel"} {
+			node, err := nfs.Lookup(p)
+			if err != nil {
+				t.Fatalf("Lookup(%s) of the link itself: %v", p, err)
+			}
+			if _, err := nfs.Read(node, 0, 100); err == nil {
+				t.Errorf("Read through %s reached outside the subtree", p)
+			}
+		}
+		if _, err := nfs.fs.Stat("/docs/dir/export/docs/inside.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Stat through escaping directory link = %v, want ErrNotExist", err)
+		}
+	})
+
+	t.Run("absolute links within the subtree", func(t *testing.T) {
+		if err := mfs.Symlink("/srv/export/docs/inside.txt", "/srv/export/docs/alias"); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+		target, err := nfs.fs.Readlink("/docs/alias")
+		if err != nil || target != "/docs/inside.txt" {
+			t.Fatalf("Readlink = %q, %v; want /docs/inside.txt", target, err)
+		}
+		node, err := nfs.Lookup("/docs/alias")
+		if err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+		data, err := nfs.Read(node, 0, 100)
+		if err != nil || string(data) != "inside" {
+			t.Fatalf("Read through alias = %q, %v; want %q", data, err, "inside")
+		}
+
+		root, err := nfs.Lookup("/")
+		if err != nil {
+			t.Fatalf("Lookup(/): %v", err)
+		}
+		if _, err := nfs.Symlink(root, "made", "/docs/inside.txt", &NFSAttrs{Mode: 0777}); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+		stored, err := mfs.Readlink("/srv/export/made")
+		if err != nil || stored != "/srv/export/docs/inside.txt" {
+			t.Fatalf("stored target = %q, %v; want /srv/export/docs/inside.txt", stored, err)
+		}
+	})
+
+	t.Run("MNT returns the subtree root", func(t *testing.T) {
+		handler := &NFSProcedureHandler{server: &Server{handler: nfs}}
+		var buf bytes.Buffer
+		xdrEncodeString(&buf, "/")
+		call := &RPCCall{Header: RPCMsgHeader{Program: MOUNT_PROGRAM, Version: MOUNT_V3, Procedure: 1}}
+		result, err := handler.handleMountCall(call, bytes.NewReader(buf.Bytes()), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleMountCall: %v", err)
+		}
+		data := result.Data.([]byte)
+		if status := binary.BigEndian.Uint32(data[0:4]); status != 0 {
+			t.Fatalf("MNT status = %d, want MNT3_OK", status)
+		}
+		node, ok := handler.lookupNode(binary.BigEndian.Uint64(data[8:16]))
+		if !ok {
+			t.Fatal("MNT handle does not resolve")
+		}
+		entries, err := nfs.ReadDir(node)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		names := map[string]bool{}
+		for _, e := range entries {
+			names[e.path] = true
+		}
+		if !names["/docs"] || names["/srv"] {
+			t.Fatalf("MNT root lists %v, want the subtree", names)
+		}
+	})
+}
+
+func TestExportRootValidation(t *testing.T) {
+	mfs := newSubtreeFS(t)
+	for _, root := range []string{"srv/export", "/missing", "/secret.txt"} {
+		if _, err := New(mfs, ExportOptions{ExportRoot: root}); err == nil {
+			t.Errorf("New accepted ExportRoot %q", root)
+		}
+	}
+
+	nfs, err := New(mfs, ExportOptions{ExportRoot: "/srv/export"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { nfs.Close() })
+	policy := *nfs.policy.Load()
+	policy.ExportRoot = "/srv"
+	if err := nfs.UpdatePolicyOptions(policy); err == nil {
+		t.Error("UpdatePolicyOptions changed ExportRoot")
+	}
+}
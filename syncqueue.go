@@ -0,0 +1,268 @@
+// syncqueue.go: Background fsync batching for UNSTABLE writes.
+//
+// With Async enabled, an UNSTABLE WRITE queues its file for sync and
+// replies without waiting. A single goroutine drains the queue, syncing
+// each queued file once per batch however many writes it received, and
+// COMMIT waits for the file's queued and in-flight syncs to finish. This
+// keeps sync latency off the WRITE path while COMMIT still guarantees
+// durability.
+//
+// With UnstableFlushTimeout set, a file's sync is instead held until no
+// UNSTABLE write has reached it for that long, so a burst of writes costs
+// one sync even from clients that never send COMMIT. A COMMIT, or closing
+// the export, releases a held sync at once.
+//
+// A failed sync is remembered for its file until a COMMIT reports it, so a
+// COMMIT arriving after the batch that failed still fails. At most
+// maxFailedSyncs files are remembered; beyond that the oldest failure is
+// forgotten, having already been logged.
+package absnfs
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// maxFailedSyncs bounds the failed syncs waiting for a COMMIT to report
+// them, since a client may never send one
+const maxFailedSyncs = 1024
+
+// failedSync is a failed sync no COMMIT has reported yet
+type failedSync struct {
+	err error
+	seq uint64 // order of failure, oldest lowest
+}
+
+// pendingSync is a sync of one file that writes are waiting on
+type pendingSync struct {
+	done chan struct{} // closed once the sync has run
+}
+
+// syncQueue batches syncs of files written UNSTABLE
+type syncQueue struct {
+	server *AbsfsNFS
+
+	mu       sync.Mutex
+	queued   map[string]*pendingSync // files waiting for the next batch
+	inflight map[string]*pendingSync // files in the batch being synced
+	held     map[string]*pendingSync // files waiting to go idle
+	timers   map[string]*time.Timer  // idle timers of held files
+	failed   map[string]failedSync   // failed syncs no COMMIT has reported yet
+	failSeq  uint64
+	started  bool
+	closed   bool
+
+	kick    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newSyncQueue creates a sync queue. Its goroutine starts on first use.
+func newSyncQueue(server *AbsfsNFS) *syncQueue {
+	return &syncQueue{
+		server:  server,
+		queued:  make(map[string]*pendingSync),
+		held:    make(map[string]*pendingSync),
+		timers:  make(map[string]*time.Timer),
+		failed:  make(map[string]failedSync),
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// enqueue schedules a sync of path. Repeated calls before the next batch
+// runs share one sync. With idle set, the sync is held until no enqueue of
+// path has happened for that long. After close the sync runs inline.
+func (q *syncQueue) enqueue(path string, idle time.Duration) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		if err := q.server.syncFile(path); err != nil {
+			q.fail(path, err)
+		}
+		return
+	}
+	if !q.started {
+		q.started = true
+		go q.run()
+	}
+	if _, ok := q.queued[path]; ok {
+		// Already due in the next batch
+		q.mu.Unlock()
+		return
+	}
+
+	if idle > 0 {
+		if timer, ok := q.timers[path]; ok {
+			timer.Reset(idle)
+		} else {
+			q.held[path] = &pendingSync{done: make(chan struct{})}
+			q.timers[path] = time.AfterFunc(idle, func() { q.release(path) })
+		}
+		q.mu.Unlock()
+		return
+	}
+
+	q.releaseLocked(path)
+	if _, ok := q.queued[path]; !ok {
+		q.queued[path] = &pendingSync{done: make(chan struct{})}
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// release moves the held sync of path, if any, into the next batch
+func (q *syncQueue) release(path string) {
+	q.mu.Lock()
+	released := q.releaseLocked(path)
+	q.mu.Unlock()
+	if released {
+		q.signal()
+	}
+}
+
+// releaseLocked moves the held sync of path, if any, into the next batch
+// and reports whether there was one. q.mu must be held.
+func (q *syncQueue) releaseLocked(path string) bool {
+	ps, ok := q.held[path]
+	if !ok {
+		return false
+	}
+	q.timers[path].Stop()
+	delete(q.held, path)
+	delete(q.timers, path)
+	q.queued[path] = ps
+	return true
+}
+
+// signal wakes the goroutine to run a batch
+func (q *syncQueue) signal() {
+	select {
+	case q.kick <- struct{}{}:
+	default: // a batch is already pending
+	}
+}
+
+// fail records a failed sync of path for the next wait to report,
+// forgetting the oldest recorded failure if there are too many
+func (q *syncQueue) fail(path string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.failed[path]; ok {
+		return
+	}
+	if len(q.failed) >= maxFailedSyncs {
+		oldest, first := "", true
+		for p, f := range q.failed {
+			if first || f.seq < q.failed[oldest].seq {
+				oldest, first = p, false
+			}
+		}
+		delete(q.failed, oldest)
+	}
+	q.failSeq++
+	q.failed[path] = failedSync{err: err, seq: q.failSeq}
+}
+
+// wait blocks until every sync of path queued so far has run, returning
+// the first error, including one from a batch that finished earlier. The
+// error is reported once.
+func (q *syncQueue) wait(path string) error {
+	q.mu.Lock()
+	released := q.releaseLocked(path)
+	pending := []*pendingSync{q.inflight[path], q.queued[path]}
+	q.mu.Unlock()
+	if released {
+		q.signal()
+	}
+
+	for _, ps := range pending {
+		if ps != nil {
+			<-ps.done
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f := q.failed[path]
+	delete(q.failed, path)
+	return f.err
+}
+
+// close runs any queued syncs and stops the goroutine
+func (q *syncQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	started := q.started
+	for path := range q.held {
+		q.releaseLocked(path)
+	}
+	q.mu.Unlock()
+
+	if started {
+		close(q.stop)
+		<-q.stopped
+	}
+}
+
+// run syncs queued files in batches until stopped
+func (q *syncQueue) run() {
+	defer close(q.stopped)
+	for {
+		select {
+		case <-q.kick:
+			q.flush()
+		case <-q.stop:
+			q.flush()
+			return
+		}
+	}
+}
+
+// flush syncs every file queued since the last batch
+func (q *syncQueue) flush() {
+	q.mu.Lock()
+	batch := q.queued
+	q.queued = make(map[string]*pendingSync)
+	q.inflight = batch
+	q.mu.Unlock()
+
+	for path, ps := range batch {
+		if err := q.server.syncFile(path); err != nil {
+			q.fail(path, err)
+		}
+		close(ps.done)
+	}
+
+	q.mu.Lock()
+	q.inflight = nil
+	q.mu.Unlock()
+}
+
+// syncFile flushes path to stable storage on the backing filesystem
+func (s *AbsfsNFS) syncFile(path string) error {
+	f, err := s.fs.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	fsStart := time.Now()
+	err = f.Sync()
+	s.RecordFSLatency("SYNC", time.Since(fsStart))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if slog := s.getStructuredLogger(); slog != nil {
+			slog.Warn("sync of unstable writes failed",
+				LogField{Key: "path", Value: path},
+				LogField{Key: "error", Value: err})
+		}
+	}
+	return err
+}
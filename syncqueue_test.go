@@ -0,0 +1,260 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// gatedSyncFS counts Sync calls and holds each one until gate is closed.
+// With fail set, they report an I/O error.
+type gatedSyncFS struct {
+	*memfs.FileSystem
+	gate  chan struct{}
+	syncs atomic.Int32
+	fail  atomic.Bool
+}
+
+func (f *gatedSyncFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &gatedSyncFile{File: file, fs: f}, nil
+}
+
+type gatedSyncFile struct {
+	absfs.File
+	fs *gatedSyncFS
+}
+
+func (f *gatedSyncFile) Sync() error {
+	<-f.fs.gate
+	defer f.fs.syncs.Add(1)
+	if f.fs.fail.Load() {
+		return errors.New("input/output error")
+	}
+	return f.File.Sync()
+}
+
+func TestUnstableWritesBatchSyncs(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/file")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Close()
+
+	fs := &gatedSyncFS{FileSystem: mfs, gate: make(chan struct{})}
+	nfs, err := New(fs, ExportOptions{Async: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	authCtx := testAuthContext()
+	fileHandle := getFileHandle(server, "/file")
+
+	// Every WRITE replies UNSTABLE without waiting on the held sync
+	const writes = 50
+	for i := 0; i < writes; i++ {
+		body := buildStableWriteRequest(fileHandle, uint64(i*4), []byte("data"), UNSTABLE)
+		reply, err := handler.handleWrite(bytes.NewReader(body), &RPCReply{}, authCtx)
+		if err != nil {
+			t.Fatalf("handleWrite failed: %v", err)
+		}
+		data := getReplyData(reply)
+		if status := readStatusFromReply(reply); status != NFS_OK {
+			t.Fatalf("WRITE %d: expected NFS_OK, got %d", i, status)
+		}
+		if committed := binary.BigEndian.Uint32(data[len(data)-12:]); committed != UNSTABLE {
+			t.Fatalf("WRITE %d: expected committed UNSTABLE, got %d", i, committed)
+		}
+	}
+
+	commitDone := make(chan uint32)
+	go func() {
+		reply, _ := handler.handleCommit(bytes.NewReader(buildCommitRequest(fileHandle, 0, 0)), &RPCReply{}, authCtx)
+		commitDone <- readStatusFromReply(reply)
+	}()
+
+	select {
+	case <-commitDone:
+		t.Fatal("COMMIT returned before the queued sync ran")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(fs.gate)
+	if status := <-commitDone; status != NFS_OK {
+		t.Errorf("COMMIT: expected NFS_OK, got %d", status)
+	}
+	// The held sync picked up at most the first write, so the rest queued
+	// behind it and share one batched sync
+	if n := fs.syncs.Load(); n < 1 || n > 2 {
+		t.Errorf("Expected 1 or 2 syncs for %d UNSTABLE writes, got %d", writes, n)
+	}
+}
+
+func TestUnstableFlushTimeout(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, name := range []string{"/idle", "/committed"} {
+		f, err := mfs.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Close()
+	}
+
+	gate := make(chan struct{})
+	close(gate)
+	fs := &gatedSyncFS{FileSystem: mfs, gate: gate}
+	const timeout = 200 * time.Millisecond
+	nfs, err := New(fs, ExportOptions{Async: true, UnstableFlushTimeout: timeout})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	authCtx := testAuthContext()
+
+	write := func(handle uint64, offset uint64) {
+		t.Helper()
+		body := buildStableWriteRequest(handle, offset, []byte("data"), UNSTABLE)
+		reply, err := handler.handleWrite(bytes.NewReader(body), &RPCReply{}, authCtx)
+		if err != nil {
+			t.Fatalf("handleWrite failed: %v", err)
+		}
+		if status := readStatusFromReply(reply); status != NFS_OK {
+			t.Fatalf("WRITE: expected NFS_OK, got %d", status)
+		}
+	}
+
+	// Writes keep the file busy, so its sync stays held
+	idle := getFileHandle(server, "/idle")
+	for i := 0; i < 5; i++ {
+		write(idle, uint64(i*4))
+		time.Sleep(timeout / 8)
+	}
+	if n := fs.syncs.Load(); n != 0 {
+		t.Fatalf("Expected no syncs while writes keep arriving, got %d", n)
+	}
+
+	// Once the file goes idle, its writes are synced without a COMMIT
+	deadline := time.Now().Add(5 * time.Second)
+	for fs.syncs.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("UNSTABLE writes were never synced without a COMMIT")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := fs.syncs.Load(); n != 1 {
+		t.Errorf("Expected 1 sync for the idle file's writes, got %d", n)
+	}
+
+	// COMMIT does not wait for the timeout
+	committed := getFileHandle(server, "/committed")
+	write(committed, 0)
+	start := time.Now()
+	reply, _ := handler.handleCommit(bytes.NewReader(buildCommitRequest(committed, 0, 0)), &RPCReply{}, authCtx)
+	if status := readStatusFromReply(reply); status != NFS_OK {
+		t.Errorf("COMMIT: expected NFS_OK, got %d", status)
+	}
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("COMMIT waited %v for the flush timeout", elapsed)
+	}
+	if n := fs.syncs.Load(); n != 2 {
+		t.Errorf("Expected 2 syncs after COMMIT, got %d", n)
+	}
+}
+
+func TestUnstableSyncFailureReachesCommit(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create("/file")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Close()
+
+	fs := &gatedSyncFS{FileSystem: mfs, gate: make(chan struct{})}
+	close(fs.gate)
+	fs.fail.Store(true)
+	nfs, err := New(fs, ExportOptions{Async: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+	authCtx := testAuthContext()
+	fileHandle := getFileHandle(server, "/file")
+
+	body := buildStableWriteRequest(fileHandle, 0, []byte("data"), UNSTABLE)
+	if reply, _ := handler.handleWrite(bytes.NewReader(body), &RPCReply{}, authCtx); readStatusFromReply(reply) != NFS_OK {
+		t.Fatalf("WRITE: expected NFS_OK, got %d", readStatusFromReply(reply))
+	}
+
+	// Let the batch holding the failed sync finish before COMMIT arrives
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		nfs.syncQueue.mu.Lock()
+		idle := fs.syncs.Load() == 1 && nfs.syncQueue.inflight == nil
+		nfs.syncQueue.mu.Unlock()
+		if idle {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background sync never ran")
+		}
+	}
+
+	commit := func() uint32 {
+		reply, _ := handler.handleCommit(bytes.NewReader(buildCommitRequest(fileHandle, 0, 0)), &RPCReply{}, authCtx)
+		return readStatusFromReply(reply)
+	}
+	if status := commit(); status == NFS_OK {
+		t.Error("COMMIT after a failed background sync returned NFS_OK")
+	}
+	if status := commit(); status != NFS_OK {
+		t.Errorf("second COMMIT: expected NFS_OK once the failure was reported, got %d", status)
+	}
+}
+
+func TestFailedSyncsBounded(t *testing.T) {
+	q := newSyncQueue(nil)
+	errSync := errors.New("sync failed")
+	for i := 0; i <= maxFailedSyncs; i++ {
+		q.fail(fmt.Sprintf("/file%d", i), errSync)
+	}
+	if len(q.failed) != maxFailedSyncs {
+		t.Fatalf("%d failed syncs remembered, want %d", len(q.failed), maxFailedSyncs)
+	}
+
+	// The oldest failure is the one forgotten
+	if err := q.wait("/file0"); err != nil {
+		t.Errorf("oldest failure still reported: %v", err)
+	}
+	if err := q.wait("/file1"); err != errSync {
+		t.Errorf("second failure reported as %v, want %v", err, errSync)
+	}
+	if err := q.wait(fmt.Sprintf("/file%d", maxFailedSyncs)); err != errSync {
+		t.Errorf("newest failure reported as %v, want %v", err, errSync)
+	}
+}
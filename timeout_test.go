@@ -548,25 +548,25 @@ func TestTimeoutMetricsTracking(t *testing.T) {
 // TestTimeoutErrorMapping tests that timeout errors are properly mapped to NFSERR_DELAY
 func TestTimeoutErrorMapping(t *testing.T) {
 	// Test ErrTimeout mapping
-	status := mapError(ErrTimeout)
+	status := MapErrorToNFSStatus(ErrTimeout)
 	if status != NFSERR_DELAY {
 		t.Errorf("Expected NFSERR_DELAY for ErrTimeout, got %d", status)
 	}
 
 	// Test context.DeadlineExceeded mapping
-	status = mapError(context.DeadlineExceeded)
+	status = MapErrorToNFSStatus(context.DeadlineExceeded)
 	if status != NFSERR_DELAY {
 		t.Errorf("Expected NFSERR_DELAY for context.DeadlineExceeded, got %d", status)
 	}
 
 	// Test nil error
-	status = mapError(nil)
+	status = MapErrorToNFSStatus(nil)
 	if status != NFS_OK {
 		t.Errorf("Expected NFS_OK for nil error, got %d", status)
 	}
 
 	// Test other errors
-	status = mapError(os.ErrNotExist)
+	status = MapErrorToNFSStatus(os.ErrNotExist)
 	if status != NFSERR_NOENT {
 		t.Errorf("Expected NFSERR_NOENT for ErrNotExist, got %d", status)
 	}
@@ -0,0 +1,47 @@
+// timetravel.go: Exports pinned to a point in time.
+//
+// When ExportOptions.PinnedTime is set, New asks the backing filesystem
+// (which must implement TimeTravelFS) for its state as of that instant and
+// exports that view read-only. Intended for forensic and recovery mounts.
+package absnfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// TimeTravelFS is implemented by backing filesystems that can present their
+// contents as they were at an earlier point in time.
+type TimeTravelFS interface {
+	// AsOf returns a view of the filesystem as of t, or nil if no state
+	// is available for that instant.
+	AsOf(t time.Time) absfs.FileSystem
+}
+
+// pinnedView returns the view of fs to export for PinnedTime t. Views that
+// lack native symlink support are extended the same way absfs does for any
+// other FileSystem.
+func pinnedView(fs absfs.SymlinkFileSystem, t time.Time) (absfs.SymlinkFileSystem, error) {
+	tt, ok := fs.(TimeTravelFS)
+	if !ok {
+		return nil, fmt.Errorf("PinnedTime requires a backing filesystem implementing TimeTravelFS")
+	}
+	view := tt.AsOf(t)
+	if view == nil {
+		return nil, fmt.Errorf("no filesystem state available as of %s", t.Format(time.RFC3339))
+	}
+	if sfs, ok := view.(absfs.SymlinkFileSystem); ok {
+		return sfs, nil
+	}
+	return absfs.ExtendSymlinkFiler(view), nil
+}
+
+// samePinnedTime reports whether two PinnedTime settings refer to the same instant
+func samePinnedTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
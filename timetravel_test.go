@@ -0,0 +1,112 @@
+package absnfs
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// versionedFS is a fake TimeTravelFS holding whole-filesystem versions
+type versionedFS struct {
+	*memfs.FileSystem
+	versions []fsVersion // oldest first
+}
+
+type fsVersion struct {
+	at time.Time
+	fs *memfs.FileSystem
+}
+
+func (v *versionedFS) AsOf(t time.Time) absfs.FileSystem {
+	var found absfs.FileSystem
+	for _, ver := range v.versions {
+		if ver.at.After(t) {
+			break
+		}
+		found = ver.fs
+	}
+	return found
+}
+
+func newMemFSWithFile(t *testing.T, name, content string) *memfs.FileSystem {
+	t.Helper()
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, err := mfs.Create(name)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	f.Close()
+	return mfs
+}
+
+func TestPinnedTime(t *testing.T) {
+	monday := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	old := newMemFSWithFile(t, "/report.txt", "draft")
+	live := newMemFSWithFile(t, "/report.txt", "final version")
+	fs := &versionedFS{
+		FileSystem: live,
+		versions:   []fsVersion{{at: monday, fs: old}},
+	}
+
+	pinned := monday.Add(time.Hour)
+	nfs, err := New(fs, ExportOptions{PinnedTime: &pinned})
+	if err != nil {
+		t.Fatalf("Failed to create pinned NFS: %v", err)
+	}
+	server := &Server{handler: nfs, options: ServerOptions{}}
+	handler := &NFSProcedureHandler{server: server}
+
+	t.Run("reads return historical content", func(t *testing.T) {
+		node, err := nfs.Lookup("/report.txt")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		data, err := nfs.Read(node, 0, 64)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if got := string(data); got != "draft" {
+			t.Errorf("Expected pinned content %q, got %q", "draft", got)
+		}
+	})
+
+	t.Run("export is read-only", func(t *testing.T) {
+		fileHandle := getFileHandle(server, "/report.txt")
+		reply, err := handler.handleWrite(bytes.NewReader(buildWriteRequest(fileHandle, 0, []byte("edit"))), &RPCReply{}, testAuthContext())
+		if err != nil {
+			t.Fatalf("handleWrite failed: %v", err)
+		}
+		if status := readStatusFromReply(reply); status != NFSERR_ROFS {
+			t.Errorf("Expected NFSERR_ROFS, got %d", status)
+		}
+		if err := nfs.UpdateExportOptions(ExportOptions{}); err == nil {
+			t.Error("Expected error making a pinned export writable")
+		}
+		later := pinned.Add(time.Hour)
+		if err := nfs.UpdateExportOptions(ExportOptions{ReadOnly: true, PinnedTime: &later}); err == nil {
+			t.Error("Expected error changing PinnedTime at runtime")
+		}
+		if got := nfs.GetExportOptions().PinnedTime; got == nil || !got.Equal(pinned) {
+			t.Errorf("Expected PinnedTime %v to round-trip, got %v", pinned, got)
+		}
+	})
+
+	t.Run("requires TimeTravelFS and available state", func(t *testing.T) {
+		if _, err := New(live, ExportOptions{PinnedTime: &pinned}); err == nil {
+			t.Error("Expected error for a filesystem without TimeTravelFS")
+		}
+		before := monday.Add(-time.Hour)
+		if _, err := New(fs, ExportOptions{PinnedTime: &before}); err == nil {
+			t.Error("Expected error when no state exists at PinnedTime")
+		}
+	})
+}
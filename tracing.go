@@ -0,0 +1,88 @@
+// tracing.go: OpenTelemetry spans for NFS calls.
+//
+// With ExportOptions.TracerProvider set, every NFS call gets a server span
+// named after its procedure (nfs.READ, nfs.LOOKUP, ...) that records the
+// reply status, and READ and WRITE add the handle, offset and byte count.
+// ReadWithContext and WriteWithContext start a child span around the call
+// into the backing filesystem, so a trace separates the time spent there
+// from decoding and encoding. Without a provider the spans are no-ops.
+package absnfs
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans
+const tracerName = "github.com/absfs/absnfs"
+
+// tracer returns the export's tracer, a no-op one when it has no
+// TracerProvider
+func (s *AbsfsNFS) tracer() trace.Tracer {
+	if tp := s.tuning.Load().TracerProvider; tp != nil {
+		return tp.Tracer(tracerName)
+	}
+	return noop.Tracer{}
+}
+
+// startCallSpan starts the span of an NFS call to the procedure op and
+// makes its context the call's
+func (h *NFSProcedureHandler) startCallSpan(op string, vers uint32, authCtx *AuthContext) trace.Span {
+	ctx, span := h.nfs().tracer().Start(authCtx.callContext(), "nfs."+op,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.Int("nfs.version", int(vers)),
+			attribute.String("client.address", authCtx.ClientIP)))
+	authCtx.ctx = ctx
+	return span
+}
+
+// endCallSpan records how an NFS call ended on its span and ends it. A
+// reply with a status other than NFS_OK marks the span as an error.
+func endCallSpan(span trace.Span, err error, status uint32, replied bool) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case replied:
+		span.SetAttributes(attribute.String("nfs.status", nfsStatusName(status)))
+		if status != NFS_OK {
+			span.SetStatus(codes.Error, nfsStatusName(status))
+		}
+	}
+	span.End()
+}
+
+// traceTransfer adds the handle, offset and byte count of a READ or WRITE
+// to the span of the call
+func traceTransfer(ctx context.Context, handle, offset uint64, count uint32) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64("nfs.handle", int64(handle)),
+		attribute.Int64("nfs.offset", int64(offset)),
+		attribute.Int("nfs.count", int(count)))
+}
+
+// startFSSpan starts the span of a call into the backing filesystem
+func (s *AbsfsNFS) startFSSpan(ctx context.Context, name, path string, offset int64, count int) trace.Span {
+	_, span := s.tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String("fs.path", path),
+		attribute.Int64("fs.offset", offset),
+		attribute.Int("fs.count", count)))
+	return span
+}
+
+// endFSSpan records the bytes a backing filesystem call moved and its
+// error, other than io.EOF, and ends its span
+func endFSSpan(span trace.Span, n int, err error) {
+	span.SetAttributes(attribute.Int("fs.bytes", n))
+	if err != nil && err != io.EOF {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,142 @@
+package absnfs
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingProvider is a TracerProvider that keeps every span it starts
+type recordingProvider struct {
+	noop.TracerProvider
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (p *recordingProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{p: p}
+}
+
+// find returns the last span named name
+func (p *recordingProvider) find(name string) *recordingSpan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(p.spans) - 1; i >= 0; i-- {
+		if p.spans[i].name == name {
+			return p.spans[i]
+		}
+	}
+	return nil
+}
+
+type recordingTracer struct {
+	noop.Tracer
+	p *recordingProvider
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &recordingSpan{name: name, kind: cfg.SpanKind(), attrs: make(map[string]attribute.Value)}
+	s.parent, _ = trace.SpanFromContext(ctx).(*recordingSpan)
+	s.SetAttributes(cfg.Attributes()...)
+	t.p.mu.Lock()
+	t.p.spans = append(t.p.spans, s)
+	t.p.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type recordingSpan struct {
+	noop.Span
+	name   string
+	kind   trace.SpanKind
+	parent *recordingSpan
+	attrs  map[string]attribute.Value
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[string(a.Key)] = a.Value
+	}
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *recordingSpan) End(...trace.SpanEndOption)          { s.ended = true }
+
+func TestTracingSpans(t *testing.T) {
+	tp := &recordingProvider{}
+	srv, h, auth := setupHandlerEnv(t, func(o *ExportOptions) { o.TracerProvider = tp })
+	file := allocHandle(t, srv, "/dir/file.txt")
+	dir := allocHandle(t, srv, "/dir")
+
+	var buf bytes.Buffer
+	xdrEncodeFileHandle(&buf, file)
+	xdrEncodeUint64(&buf, 1)
+	xdrEncodeUint32(&buf, 10)
+	if status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_READ, buf.Bytes())); status != NFS_OK {
+		t.Fatalf("READ: status %d", status)
+	}
+
+	call := tp.find("nfs.READ")
+	if call == nil {
+		t.Fatal("no nfs.READ span")
+	}
+	if !call.ended || call.kind != trace.SpanKindServer || call.status == codes.Error {
+		t.Errorf("nfs.READ span ended=%v kind=%v status=%v, want an ended server span without error", call.ended, call.kind, call.status)
+	}
+	for key, want := range map[string]int64{"nfs.handle": int64(file), "nfs.offset": 1, "nfs.count": 10, "nfs.version": 3} {
+		if got := call.attrs[key].AsInt64(); got != want {
+			t.Errorf("nfs.READ %s = %d, want %d", key, got, want)
+		}
+	}
+	if got := call.attrs["nfs.status"].AsString(); got != "OK" {
+		t.Errorf("nfs.READ nfs.status = %q, want OK", got)
+	}
+
+	// The backing filesystem read is a child of the call
+	fs := tp.find("fs.ReadAt")
+	if fs == nil {
+		t.Fatal("no fs.ReadAt span")
+	}
+	if fs.parent != call || !fs.ended {
+		t.Errorf("fs.ReadAt parent=%v ended=%v, want an ended child of nfs.READ", fs.parent, fs.ended)
+	}
+	if got := fs.attrs["fs.path"].AsString(); got != "/dir/file.txt" {
+		t.Errorf("fs.ReadAt fs.path = %q", got)
+	}
+	if got := fs.attrs["fs.bytes"].AsInt64(); got != 4 {
+		t.Errorf("fs.ReadAt fs.bytes = %d, want 4", got)
+	}
+
+	// WRITE traces the write into the backing filesystem too
+	buf.Reset()
+	xdrEncodeFileHandle(&buf, file)
+	xdrEncodeUint64(&buf, 5)
+	xdrEncodeUint32(&buf, 3)
+	xdrEncodeUint32(&buf, FILE_SYNC)
+	xdrEncodeUint32(&buf, 3)
+	buf.Write([]byte("abc\x00"))
+	if status, _ := xdrDecodeUint32(callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_WRITE, buf.Bytes())); status != NFS_OK {
+		t.Fatalf("WRITE: status %d", status)
+	}
+	if fs := tp.find("fs.WriteAt"); fs == nil || fs.parent != tp.find("nfs.WRITE") || fs.attrs["fs.bytes"].AsInt64() != 3 {
+		t.Errorf("fs.WriteAt span %+v, want a 3-byte child of nfs.WRITE", fs)
+	}
+
+	// A failed call is an error span carrying its status
+	buf.Reset()
+	xdrEncodeFileHandle(&buf, dir)
+	xdrEncodeString(&buf, "missing")
+	callProc(t, h, auth, NFS_PROGRAM, NFS_V3, NFSPROC3_LOOKUP, buf.Bytes())
+	lookup := tp.find("nfs.LOOKUP")
+	if lookup == nil || lookup.status != codes.Error || lookup.attrs["nfs.status"].AsString() != "NOENT" {
+		t.Errorf("nfs.LOOKUP span %+v, want an error span with nfs.status NOENT", lookup)
+	}
+}
@@ -33,13 +33,16 @@ func TestTransferSize(t *testing.T) {
 	testCases := []struct {
 		name         string
 		transferSize int
+		maxReadSize  int
 		readSize     int64
 		expected     int
 	}{
-		{"Small transfer size", 1024, 2048, 1024},        // TransferSize limits read
-		{"Medium transfer size", 4096, 2048, 2048},       // Read request is smaller than limit
-		{"Large transfer size", 1024 * 1024, 2048, 2048}, // Read request is much smaller than limit
-		{"Zero transfer size", 0, 2048, 2048},            // Default 64K used when 0
+		{"Small transfer size", 1024, 0, 2048, 2048},              // TransferSize does not limit reads
+		{"Small max read size", 0, 1024, 2048, 1024},              // MaxReadSize limits read
+		{"Medium transfer size", 4096, 0, 2048, 2048},             // Read request is smaller than limit
+		{"Large transfer size", 1024 * 1024, 0, 2048, 2048},       // Read request is much smaller than limit
+		{"Zero transfer size", 0, 0, 2048, 2048},                  // Default 64K used when 0
+		{"Read past transfer size", 0, 0, 256 * 1024, 256 * 1024}, // Up to the default 1MB rtmax
 	}
 
 	for _, tc := range testCases {
@@ -47,6 +50,7 @@ func TestTransferSize(t *testing.T) {
 			// Create server with the specified transfer size
 			options := ExportOptions{
 				TransferSize: tc.transferSize,
+				MaxReadSize:  tc.maxReadSize,
 			}
 			server, err := New(fs, options)
 			if err != nil {
@@ -74,7 +78,7 @@ func TestTransferSize(t *testing.T) {
 				t.Fatalf("Read operation failed: %v", err)
 			}
 
-			// Check that read size respects transfer size limit
+			// Check that read size respects the MaxReadSize limit
 			if len(data) != tc.expected {
 				t.Errorf("Read data length incorrect: got %d, want %d", len(data), tc.expected)
 			}
@@ -108,13 +112,16 @@ func TestWriteTransferSize(t *testing.T) {
 	testCases := []struct {
 		name         string
 		transferSize int
+		maxWriteSize int
 		writeSize    int
 		expected     int64
 	}{
-		{"Small transfer size", 1024, 2048, 1024},        // TransferSize limits write
-		{"Medium transfer size", 4096, 2048, 2048},       // Write request is smaller than limit
-		{"Large transfer size", 1024 * 1024, 2048, 2048}, // Write request is much smaller than limit
-		{"Zero transfer size", 0, 2048, 2048},            // Default 64K used when 0
+		{"Small max write size", 0, 1024, 2048, 1024},              // MaxWriteSize limits write
+		{"Small transfer size", 1024, 0, 2048, 2048},               // TransferSize does not limit writes
+		{"Medium transfer size", 4096, 0, 2048, 2048},              // Write request is smaller than limit
+		{"Large transfer size", 1024 * 1024, 0, 2048, 2048},        // Write request is much smaller than limit
+		{"Zero transfer size", 0, 0, 2048, 2048},                   // Default 64K used when 0
+		{"Write past transfer size", 0, 0, 256 * 1024, 256 * 1024}, // Up to the default 1MB wtmax
 	}
 
 	for _, tc := range testCases {
@@ -122,6 +129,7 @@ func TestWriteTransferSize(t *testing.T) {
 			// Create server with the specified transfer size
 			options := ExportOptions{
 				TransferSize: tc.transferSize,
+				MaxWriteSize: tc.maxWriteSize,
 			}
 			server, err := New(fs, options)
 			if err != nil {
@@ -146,7 +154,7 @@ func TestWriteTransferSize(t *testing.T) {
 				t.Fatalf("Write operation failed: %v", err)
 			}
 
-			// Check that write size respects transfer size limit
+			// Check that write size respects the MaxWriteSize limit
 			if written != tc.expected {
 				t.Errorf("Write length incorrect: got %d, want %d", written, tc.expected)
 			}
@@ -47,6 +47,11 @@ const Version = "1.1.0"
 type AbsfsNFS struct {
 	mu               sync.RWMutex            // Protects shared mutable state
 	fs               absfs.SymlinkFileSystem // The wrapped absfs filesystem (supports symlinks)
+	linker           Linker                  // fs, if it supports hard links
+	mknoder          Mknoder                 // fs, if it supports special files
+	advisor          Advisor                 // fs, if it takes read hints
+	checksummer      ChecksumVerifier        // fs, if it verifies checksums
+	setattrSupporter SetattrSupporter        // fs, if it reports unsupported SETATTR fields
 	root             *NFSNode                // Root directory node
 	logger           *log.Logger             // Deprecated: use structuredLogger instead
 	structuredLogger Logger                  // Structured logger for production use
@@ -54,10 +59,14 @@ type AbsfsNFS struct {
 	mountPath        string                  // Export path
 	attrCache        *AttrCache              // Cache for file attributes
 	dirCache         *DirCache               // Cache for directory entries
+	cookieCache      *CookieCache            // READDIR cookie verifiers per directory
 	workerPool       *WorkerPool             // Worker pool for concurrent operations
 	metrics          *MetricsCollector       // Metrics collection and reporting
 	rateLimiter      *RateLimiter            // Rate limiter for DoS protection
 	exportServer     *Server                 // Server created by Export(), nil if not exported
+	nfsVersions      []uint32                // NFS versions of the Server serving this export, for Features
+	syncQueue        *syncQueue              // Batched syncs of UNSTABLE writes
+	warmer           *mountWarmer            // Cache warming queued by WarmOnMount
 
 	// Options are stored as immutable snapshots behind atomic pointers.
 	// Readers load the pointer -- no lock needed.
@@ -77,26 +86,84 @@ type AbsfsNFS struct {
 
 	// loggerMu protects structuredLogger writes from concurrent access.
 	loggerMu sync.RWMutex
+
+	// writeLocks serialize writes per file when SerializeWrites is set,
+	// striped by path hash so unrelated files rarely contend.
+	writeLocks [writeLockStripes]sync.Mutex
+
+	// dirLocks serialize RENAMEs by the directories they touch, striped
+	// the same way, so two renames of one source cannot both succeed
+	dirLocks [dirLockStripes]sync.Mutex
+
+	// outage is set while the backing filesystem is failing the outage probe
+	outage     atomic.Bool
+	outageStop chan struct{} // closed to stop the probe, nil if not running
+	outageDone chan struct{} // closed when the probe goroutine exits
+
+	// replays tracks recent RPC verifiers per client for ReplayWindow
+	replays replayCache
+
+	// lookups coalesces concurrent uncached lookups of the same path
+	lookups flightGroup
+
+	// activeOps lists in-flight NFS calls for ActiveOperations
+	activeOps activeOps
+
+	// sessions holds the active (client, mount path) pairs
+	sessionsMu sync.Mutex
+	sessions   map[mountSession]struct{}
+
+	// locks holds NLM byte-range locks when EnableLocking is set
+	locks lockTable
+
+	// handleIndex maps persistent handles to paths, nil unless PersistentHandles
+	handleIndex *handleIndex
 }
 
+// writeLockStripes is the number of per-file write lock stripes
+const writeLockStripes = 64
+
+// dirLockStripes is the number of per-directory rename lock stripes
+const dirLockStripes = 64
+
 // FileHandleMap manages the mapping between NFS file handles and absfs files
 type FileHandleMap struct {
 	sync.RWMutex
 	handles     map[uint64]absfs.File
-	pathHandles map[string]uint64  // Reverse map: path -> handle for deduplication
-	nextHandle  uint64             // Counter for allocating new handles
-	freeHandles *uint64MinHeap     // Min-heap of freed handles for reuse
-	maxHandles  int                // Maximum handles before eviction (0 = DefaultMaxHandles)
+	pathHandles map[string]uint64 // Reverse map: node handleKey -> handle for deduplication
+	nextHandle  uint64            // Counter for allocating new handles
+	freeHandles *uint64MinHeap    // Min-heap of freed handles for reuse
+	maxHandles  int               // Maximum handles before eviction (0 = DefaultMaxHandles)
+	peak        int               // Most handles held since the maps were built; see Compact
+
+	// lastUsed holds the time (UnixNano) each handle was last allocated or
+	// looked up; entries are updated atomically so Get needs only RLock
+	lastUsed    map[uint64]*atomic.Int64
+	idleTimeout atomic.Int64 // nanoseconds unused before a handle expires (0 = never)
+	lastSweep   atomic.Int64 // UnixNano of the last idle sweep
+
+	// resolver derives and recovers handles with PersistentHandles, nil otherwise
+	resolver handleResolver
+
+	// prefix holds the export number from Server.AddExport in the bits
+	// above exportHandleShift; every handle issued carries it
+	prefix uint64
 }
 
 // NFSNode represents a file or directory in the NFS tree
 type NFSNode struct {
 	absfs.SymlinkFileSystem
 	path     string
+	shard    string // Shard prefix when this is a synthetic subdirectory of the directory at path
 	fileId   uint64
-	mu       sync.RWMutex // Protects attrs access
+	mu       sync.RWMutex // Protects attrs access and read tracking
 	attrs    *NFSAttrs
 	children map[string]*NFSNode
+
+	// nextRead is where the last READ through this node ended and seqReads
+	// how many READs in a row started where the one before ended
+	nextRead int64
+	seqReads int
 }
 
 // NFSAttrs holds the NFS attributes for a file or directory with caching
@@ -104,6 +171,8 @@ type NFSAttrs struct {
 	Mode       os.FileMode
 	Size       int64
 	FileId     uint64 // Unique file identifier (inode number)
+	RdevMajor  uint32 // Major device number of a block or character device
+	RdevMinor  uint32 // Minor device number of a block or character device
 	mtime      time.Time
 	atime      time.Time
 	Uid        uint32
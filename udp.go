@@ -0,0 +1,204 @@
+// udp.go: UDP datagram transport for NFS and MOUNT.
+//
+// With ServerOptions.EnableUDP, Listen also binds a UDP socket on the TCP
+// port. Each datagram carries one whole RPC call, without record marking;
+// it is dispatched through the same NFSProcedureHandler as TCP calls, and
+// the reply goes back to the source address in a single datagram. A fixed
+// set of workers serves the datagrams, dropping any that arrive while all
+// are busy and the backlog is full, as the client retransmits. Replies
+// are kept within the datagram limit by capping READ data and directory
+// listings at maxUDPTransfer, which FSINFO advertises to UDP clients.
+package absnfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// maxUDPDatagram is the largest UDP payload over IPv4
+	maxUDPDatagram = 65507
+
+	// maxUDPTransfer bounds READ data and directory listings in replies
+	// sent over UDP, leaving room in the datagram for the RPC header and
+	// the rest of the reply
+	maxUDPTransfer = 32768
+
+	// maxUDPWorkers is the number of datagram workers when MaxConnections
+	// is unlimited
+	maxUDPWorkers = 64
+)
+
+// udpDatagram is a datagram waiting for a worker
+type udpDatagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// datagramConn presents the source of a datagram to OnConnect as a
+// connection. Reads return io.EOF; writes go to the source address.
+type datagramConn struct {
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+func (c *datagramConn) Read(b []byte) (int, error)         { return 0, io.EOF }
+func (c *datagramConn) Write(b []byte) (int, error)        { return c.pc.WriteTo(b, c.addr) }
+func (c *datagramConn) Close() error                       { return nil }
+func (c *datagramConn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *datagramConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *datagramConn) SetDeadline(t time.Time) error      { return nil }
+func (c *datagramConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *datagramConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// udpTransferCap returns count, capped at maxUDPTransfer for a call that
+// arrived over UDP
+func udpTransferCap(authCtx *AuthContext, count uint32) uint32 {
+	if authCtx.UDP && count > maxUDPTransfer {
+		return maxUDPTransfer
+	}
+	return count
+}
+
+// listenUDP binds the UDP socket on the port the TCP listener is using and
+// starts reading datagrams from it
+func (s *Server) listenUDP(procHandler *NFSProcedureHandler) error {
+	addr := s.bindAddr()
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s/udp: %w", addr, err)
+	}
+	s.udpConn = conn
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.udpLoop(procHandler)
+	}()
+	return nil
+}
+
+// udpLoop reads datagrams until the socket is closed, handing each to one
+// of a fixed set of workers so a slow call does not hold up the rest
+func (s *Server) udpLoop(procHandler *NFSProcedureHandler) {
+	workers := s.handler.tuning.Load().MaxConnections
+	if workers <= 0 {
+		workers = maxUDPWorkers
+	}
+	datagrams := make(chan udpDatagram, workers)
+	defer close(datagrams)
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for d := range datagrams {
+				s.handleDatagram(procHandler, d.data, d.addr)
+			}
+		}()
+	}
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+			}
+			if s.options.Debug {
+				s.logger.Printf("UDP read error: %v", err)
+			}
+			continue
+		}
+		select {
+		case datagrams <- udpDatagram{data: append([]byte(nil), buf[:n]...), addr: addr}:
+		default:
+			if s.options.Debug {
+				s.logger.Printf("Dropped UDP datagram from %s: all workers busy", addr)
+			}
+		}
+	}
+}
+
+// handleDatagram serves the RPC call in data and sends the reply to addr.
+// Calls that do not decode, or come from a client outside AllowedIPs or
+// refused by OnConnect, are dropped without a reply, as UDP gives no
+// connection to close.
+func (s *Server) handleDatagram(procHandler *NFSProcedureHandler, data []byte, addr net.Addr) {
+	authCtx := &AuthContext{UDP: true}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		authCtx.ClientIP = udpAddr.IP.String()
+		authCtx.ClientPort = udpAddr.Port
+	}
+	if !s.isIPAllowed(authCtx.ClientIP) {
+		if s.options.Debug {
+			s.logger.Printf("Dropped UDP datagram from disallowed client %s", authCtx.ClientIP)
+		}
+		return
+	}
+	if s.options.OnConnect != nil {
+		if err := s.options.OnConnect(&datagramConn{pc: s.udpConn, addr: addr}); err != nil {
+			if s.options.Debug {
+				s.logger.Printf("Dropped UDP datagram rejected by OnConnect: %s: %v", addr, err)
+			}
+			return
+		}
+	}
+
+	body := bytes.NewReader(data)
+	call, err := DecodeRPCCall(body)
+	if err != nil {
+		if s.options.Debug {
+			s.logger.Printf("UDP decode error from %s: %v", addr, err)
+		}
+		return
+	}
+	authCtx.Credential = &call.Credential
+
+	if s.options.Debug {
+		s.logger.Printf("Received UDP RPC call: prog=%d vers=%d proc=%d",
+			call.Header.Program, call.Header.Version, call.Header.Procedure)
+	}
+
+	var reply *RPCReply
+	if rl := s.handler.rateLimiter; rl != nil && s.handler.policy.Load().EnableRateLimiting && !rl.allowDatagram(authCtx.ClientIP) {
+		reply = s.rateLimitedReply(call, authCtx)
+	} else if reply, err = s.dispatchCall(procHandler, call, body, authCtx); err != nil {
+		return
+	}
+
+	var out bytes.Buffer
+	if err := EncodeRPCReply(&out, reply); err != nil {
+		if s.options.Debug {
+			s.logger.Printf("UDP encode error: %v", err)
+		}
+		return
+	}
+	// A reply too large for one datagram would be lost; report the failure
+	// so the client does not retry it forever
+	if out.Len() > maxUDPDatagram {
+		if s.options.Debug {
+			s.logger.Printf("UDP reply of %d bytes exceeds the datagram limit (prog=%d proc=%d)",
+				out.Len(), call.Header.Program, call.Header.Procedure)
+		}
+		out.Reset()
+		EncodeRPCReply(&out, &RPCReply{
+			Header:       call.Header,
+			Status:       MSG_ACCEPTED,
+			AcceptStatus: SYSTEM_ERR,
+			Verifier:     RPCVerifier{Flavor: 0, Body: []byte{}},
+		})
+	}
+
+	if _, err := s.udpConn.WriteTo(out.Bytes(), addr); err != nil && s.options.Debug {
+		s.logger.Printf("UDP write error: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package absnfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestServerUDP(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	content := bytes.Repeat([]byte("0123456789abcdef"), 100*1024/16)
+	f, err := fs.Create("/big")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	f.Write(content)
+	f.Close()
+
+	anyPort := false
+	nfs, err := New(fs, ExportOptions{RequireReservedPort: &anyPort})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	server, err := NewServer(ServerOptions{Port: 0, Hostname: "127.0.0.1", EnableUDP: true})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetHandler(nfs)
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	if got := server.udpConn.LocalAddr().(*net.UDPAddr).Port; got != server.GetPort() {
+		t.Errorf("UDP port = %d, want the TCP port %d", got, server.GetPort())
+	}
+
+	conn, err := net.Dial("udp", server.udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial UDP: %v", err)
+	}
+	defer conn.Close()
+
+	// call sends one datagram holding an AUTH_NONE call and returns the
+	// reply datagram after its xid, REPLY, MSG_ACCEPTED, verifier and
+	// accept_stat
+	call := func(xid, proc uint32, args []byte) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		for _, v := range []uint32{xid, RPC_CALL, 2, NFS_PROGRAM, NFS_V3, proc, AUTH_NONE, 0, AUTH_NONE, 0} {
+			xdrEncodeUint32(&buf, v)
+		}
+		buf.Write(args)
+		conn.SetDeadline(time.Now().Add(testConnTimeout))
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			t.Fatalf("Failed to send datagram: %v", err)
+		}
+		reply := make([]byte, 65536)
+		n, err := conn.Read(reply)
+		if err != nil {
+			t.Fatalf("Failed to read reply datagram: %v", err)
+		}
+		if n > maxUDPDatagram {
+			t.Fatalf("Reply of %d bytes exceeds the datagram limit", n)
+		}
+		var hdr [6]uint32
+		if err := binary.Read(bytes.NewReader(reply[:n]), binary.BigEndian, &hdr); err != nil {
+			t.Fatalf("Short reply: %v", err)
+		}
+		if hdr[0] != xid || hdr[1] != RPC_REPLY || hdr[2] != MSG_ACCEPTED || hdr[5] != SUCCESS {
+			t.Fatalf("Reply header = %v, want xid %d accepted with SUCCESS", hdr, xid)
+		}
+		return reply[24:n]
+	}
+
+	call(7, NFSPROC3_NULL, nil)
+
+	// A READ larger than a datagram comes back short
+	node, err := nfs.Lookup("/big")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	var args bytes.Buffer
+	xdrEncodeFileHandle(&args, nfs.fileMap.Allocate(node))
+	binary.Write(&args, binary.BigEndian, uint64(0))
+	binary.Write(&args, binary.BigEndian, uint32(65536))
+	res := call(8, NFSPROC3_READ, args.Bytes())
+	if status := binary.BigEndian.Uint32(res); status != NFS_OK {
+		t.Fatalf("READ: expected NFS_OK, got %d", status)
+	}
+	// status, post_op_attr, count, eof, data length
+	res = res[4+4+84:]
+	count, eof := binary.BigEndian.Uint32(res), binary.BigEndian.Uint32(res[4:])
+	if count != maxUDPTransfer || eof != 0 {
+		t.Fatalf("READ over UDP: count %d eof %d, want %d bytes and more to come", count, eof, maxUDPTransfer)
+	}
+	if !bytes.Equal(res[12:12+count], content[:count]) {
+		t.Error("READ over UDP returned the wrong data")
+	}
+}
+
+func TestFSINFOOverUDP(t *testing.T) {
+	srv, handler, auth := setupHandlerEnv(t)
+	fsinfo := func(udp bool) (rtmax, wtmax uint32) {
+		t.Helper()
+		var buf bytes.Buffer
+		xdrEncodeFileHandle(&buf, allocHandle(t, srv, "/dir"))
+		ctx := *auth
+		ctx.UDP = udp
+		result, err := handler.handleFsinfo(&buf, &RPCReply{}, &ctx)
+		if err != nil {
+			t.Fatalf("handleFsinfo: %v", err)
+		}
+		data := result.Data.([]byte)[4+4+84:]
+		return binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[12:])
+	}
+	if rtmax, wtmax := fsinfo(false); rtmax != 1048576 || wtmax != 1048576 {
+		t.Errorf("TCP: rtmax %d wtmax %d, want 1048576", rtmax, wtmax)
+	}
+	if rtmax, wtmax := fsinfo(true); rtmax != maxUDPTransfer || wtmax != maxUDPTransfer {
+		t.Errorf("UDP: rtmax %d wtmax %d, want %d", rtmax, wtmax, maxUDPTransfer)
+	}
+}
+
+func TestServerUDPAdmission(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	// Datagrams would bypass TLS, so the two are refused together
+	tlsNFS, err := New(fs, ExportOptions{TLS: &TLSConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer tlsNFS.Close()
+	tlsServer, err := NewServer(ServerOptions{Port: 0, Hostname: "127.0.0.1", EnableUDP: true})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	tlsServer.SetHandler(tlsNFS)
+	if err := tlsServer.Listen(); err == nil {
+		tlsServer.Stop()
+		t.Fatal("Listen with EnableUDP and TLS succeeded")
+	}
+
+	// OnConnect sees every datagram, and a refused one gets no reply
+	nfs, err := New(fs, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	var checked, refuse atomic.Int32
+	server, err := NewServer(ServerOptions{Port: 0, Hostname: "127.0.0.1", EnableUDP: true, OnConnect: func(conn net.Conn) error {
+		checked.Add(1)
+		if refuse.Load() != 0 {
+			return errors.New("refused")
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetHandler(nfs)
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("udp", server.udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial UDP: %v", err)
+	}
+	defer conn.Close()
+	ping := func(xid uint32, wait time.Duration) bool {
+		var buf bytes.Buffer
+		for _, v := range []uint32{xid, RPC_CALL, 2, NFS_PROGRAM, NFS_V3, NFSPROC3_NULL, AUTH_NONE, 0, AUTH_NONE, 0} {
+			xdrEncodeUint32(&buf, v)
+		}
+		conn.SetDeadline(time.Now().Add(wait))
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			t.Fatalf("Failed to send datagram: %v", err)
+		}
+		_, err := conn.Read(make([]byte, 512))
+		return err == nil
+	}
+
+	if !ping(1, testConnTimeout) || !ping(2, testConnTimeout) {
+		t.Fatal("NULL over UDP got no reply")
+	}
+	refuse.Store(1)
+	if ping(3, 200*time.Millisecond) {
+		t.Error("Datagram refused by OnConnect was answered")
+	}
+	if got := checked.Load(); got != 3 {
+		t.Errorf("OnConnect ran %d times, want once per datagram (3)", got)
+	}
+}
@@ -0,0 +1,128 @@
+// warmer.go: Background cache warming after MNT.
+//
+// With WarmOnMount set, each successful MNT queues the mounted directory
+// for warming instead of starting a goroutine of its own. A single
+// goroutine works through the queue, so a burst of mounts costs one walk
+// per distinct directory at a time, however many clients mount it. Close
+// abandons the walk in progress and waits for the goroutine to exit.
+package absnfs
+
+import (
+	"context"
+	"sync"
+)
+
+// maxPendingWarms bounds the directories waiting to be warmed; mounts
+// beyond it are served without warming
+const maxPendingWarms = 64
+
+// mountWarmer warms the caches under mounted directories, one at a time
+type mountWarmer struct {
+	server *AbsfsNFS
+
+	mu      sync.Mutex
+	pending []string        // directories waiting, in mount order
+	queued  map[string]bool // pending directories, and the one being warmed
+	started bool
+	closed  bool
+
+	kick    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// newMountWarmer creates a warmer. Its goroutine starts on first use.
+func newMountWarmer(server *AbsfsNFS) *mountWarmer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mountWarmer{
+		server:  server,
+		queued:  make(map[string]bool),
+		kick:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+}
+
+// enqueue queues path for warming unless it is already queued or being
+// warmed, the queue is full, or the warmer is closed
+func (w *mountWarmer) enqueue(path string) {
+	w.mu.Lock()
+	if w.closed || w.queued[path] || len(w.pending) >= maxPendingWarms {
+		w.mu.Unlock()
+		return
+	}
+	if !w.started {
+		w.started = true
+		go w.run()
+	}
+	w.queued[path] = true
+	w.pending = append(w.pending, path)
+	w.mu.Unlock()
+
+	select {
+	case w.kick <- struct{}{}:
+	default: // the goroutine already has work to pick up
+	}
+}
+
+// close abandons queued and in-progress warming and stops the goroutine
+func (w *mountWarmer) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	started := w.started
+	w.pending = nil
+	w.mu.Unlock()
+
+	w.cancel()
+	if started {
+		<-w.stopped
+	}
+}
+
+// run warms queued directories until the warmer is closed
+func (w *mountWarmer) run() {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-w.kick:
+		case <-w.ctx.Done():
+			return
+		}
+		for {
+			w.mu.Lock()
+			if len(w.pending) == 0 {
+				w.mu.Unlock()
+				break
+			}
+			path := w.pending[0]
+			w.pending = w.pending[1:]
+			w.mu.Unlock()
+
+			w.warm(path)
+
+			w.mu.Lock()
+			delete(w.queued, path)
+			w.mu.Unlock()
+		}
+	}
+}
+
+// warm runs the cache warming for a mounted directory, logging rather than
+// returning a failure since nobody is waiting on it
+func (w *mountWarmer) warm(path string) {
+	err := w.server.warmCache(w.ctx, path, warmOnMountDepth)
+	if err == nil || w.ctx.Err() != nil {
+		return
+	}
+	if slog := w.server.getStructuredLogger(); slog != nil {
+		slog.Warn("cache warming after mount failed",
+			LogField{Key: "path", Value: path},
+			LogField{Key: "error", Value: err})
+	}
+}
@@ -1,7 +1,11 @@
 // worker_pool.go: Concurrent request processing via goroutine pool.
 //
-// Contains WorkerPool which manages a fixed set of worker goroutines
+// Contains WorkerPool which manages a set of worker goroutines
 // for handling NFS requests, with task queuing and graceful shutdown.
+// A share of the workers can be reserved for metadata tasks, which have
+// their own queue, so cheap interactive calls are not starved by bulk I/O.
+// With auto-scaling on, the pool adds workers while more tasks stay queued
+// than it has workers, and retires them again once it has been idle a while.
 package absnfs
 
 import (
@@ -11,12 +15,35 @@ import (
 	"time"
 )
 
+const (
+	// maxAutoScaleWorkers is a hard ceiling on an auto-scaling pool,
+	// whatever maximum it is given
+	maxAutoScaleWorkers = 1024
+	// autoScaleInterval is how often an auto-scaling pool checks its queues
+	autoScaleInterval = 100 * time.Millisecond
+	// autoScaleGrowChecks is how many checks in a row must find the queues
+	// above the watermark before the pool grows
+	autoScaleGrowChecks = 3
+	// autoScaleIdle is how long the pool must be idle before it shrinks
+	autoScaleIdle = 30 * time.Second
+)
+
+// autoScaleMinWorkers is the size AutoScaleWorkers starts a pool of at
+// most maxWorkers at, and shrinks it back to
+func autoScaleMinWorkers(maxWorkers int) int {
+	return max(maxWorkers/4, 1)
+}
+
 // WorkerPool manages a pool of worker goroutines for handling concurrent operations
 type WorkerPool struct {
 	// Number of workers in the pool
 	maxWorkers int
 	// Channel for passing tasks to workers
 	taskQueue chan Task
+	// Number of workers that only serve metadataQueue
+	reserve int
+	// Channel for metadata tasks; nil when no workers are reserved
+	metadataQueue chan Task
 	// Context for cancellation
 	ctx context.Context
 	// Cancel function to stop all workers
@@ -34,6 +61,17 @@ type WorkerPool struct {
 	// closeMu prevents Submit from sending on a closed taskQueue.
 	// Submit holds RLock; Stop holds Lock before closing the channel.
 	closeMu sync.RWMutex
+
+	// Auto-scaling bounds; ceiling is 0 when the pool has a fixed size
+	floor, ceiling int
+	// Tokens telling idle unreserved workers to exit as the pool shrinks
+	retire chan struct{}
+	// Cancels the goroutine running autoScale
+	stopScaler context.CancelFunc
+	// Checks in a row that found the queues above the watermark
+	busyChecks int
+	// When the pool was last seen going idle, or zero if it is busy
+	idleSince time.Time
 }
 
 // Task represents a unit of work to be processed by a worker
@@ -44,6 +82,9 @@ type Task struct {
 	ResultChan chan interface{}
 	// Start time of the task for metrics
 	startTime time.Time
+	// Context of the request the task serves; the task is skipped if it is
+	// done before a worker picks the task up
+	ctx context.Context
 }
 
 // NewWorkerPool creates a new worker pool with the specified number of workers
@@ -55,6 +96,7 @@ func NewWorkerPool(maxWorkers int, logger *AbsfsNFS) *WorkerPool {
 		ctx:        ctx,
 		cancel:     cancel,
 		logger:     logger,
+		retire:     make(chan struct{}, maxAutoScaleWorkers),
 	}
 }
 
@@ -65,50 +107,75 @@ func (p *WorkerPool) Start() {
 		return // Already running
 	}
 
-	// Launch worker goroutines
+	// Launch worker goroutines; the first reserve workers serve only
+	// metadata tasks, the rest take from either queue
 	p.wg.Add(p.maxWorkers)
 	for i := 0; i < p.maxWorkers; i++ {
-		go p.worker(i)
+		go p.worker(i, i < p.reserve)
+	}
+	if p.ceiling > 0 {
+		p.startScaler()
 	}
 
 	p.logger.logger.Printf("Worker pool started with %d workers", p.maxWorkers)
 }
 
 // worker runs in a goroutine and processes tasks from the queue
-func (p *WorkerPool) worker(id int) {
+func (p *WorkerPool) worker(id int, reserved bool) {
 	defer p.wg.Done()
 
+	taskQueue, metadataQueue, retire := p.taskQueue, p.metadataQueue, p.retire
+	if reserved {
+		taskQueue = nil // a nil channel is never selected
+		retire = nil
+	}
 	for {
+		var task Task
+		var ok bool
 		select {
 		case <-p.ctx.Done():
 			// Worker pool is shutting down
 			return
-		case task, ok := <-p.taskQueue:
-			if !ok {
-				// Task queue has been closed
-				return
-			}
+		case <-retire:
+			// The pool is shrinking
+			return
+		case task, ok = <-taskQueue:
+		case task, ok = <-metadataQueue:
+		}
+		if !ok {
+			// Task queue has been closed
+			return
+		}
+
+		if !task.startTime.IsZero() {
+			p.logger.RecordQueueWait(time.Since(task.startTime))
+		}
 
-			// Execute the task
+		// Skip work whose request was cancelled or timed out while queued,
+		// reporting the context error in place of a result
+		var result interface{}
+		if err := task.ctx.Err(); err != nil {
+			result = err
+		} else {
 			atomic.AddInt32(&p.activeWorkers, 1)
-			result := task.Execute()
+			result = task.Execute()
 			atomic.AddInt32(&p.activeWorkers, -1)
+		}
 
-			// R14/R33: Non-blocking send to avoid deadlock if receiver is gone
-			if task.ResultChan != nil {
-				select {
-				case task.ResultChan <- result:
-				default:
-				}
+		// R14/R33: Non-blocking send to avoid deadlock if receiver is gone
+		if task.ResultChan != nil {
+			select {
+			case task.ResultChan <- result:
+			default:
 			}
+		}
 
-			// Calculate and log task duration if we have a valid start time
-			if !task.startTime.IsZero() {
-				duration := time.Since(task.startTime)
-				// Only log long-running tasks
-				if duration > 100*time.Millisecond {
-					p.logger.logger.Printf("Task completed in %v", duration)
-				}
+		// Calculate and log task duration if we have a valid start time
+		if !task.startTime.IsZero() {
+			duration := time.Since(task.startTime)
+			// Only log long-running tasks
+			if duration > 100*time.Millisecond {
+				p.logger.logger.Printf("Task completed in %v", duration)
 			}
 		}
 	}
@@ -117,6 +184,26 @@ func (p *WorkerPool) worker(id int) {
 // Submit adds a task to the worker pool
 // Returns a channel that will receive the result, or nil if the task was rejected
 func (p *WorkerPool) Submit(execute func() interface{}) chan interface{} {
+	return p.SubmitWithContext(context.Background(), execute)
+}
+
+// SubmitWithContext adds a task that carries the deadline of the request it
+// serves. If ctx is done before a worker picks the task up, execute is not
+// run and the channel receives ctx.Err() instead of a result, so an expired
+// request does not occupy a worker. Returns nil if the task was rejected.
+func (p *WorkerPool) SubmitWithContext(ctx context.Context, execute func() interface{}) chan interface{} {
+	return p.submit(ctx, false, execute)
+}
+
+// SubmitMetadata is SubmitWithContext for cheap metadata calls (LOOKUP,
+// GETATTR and the like). With workers reserved for metadata, the task goes
+// to their queue and is not held up behind bulk I/O; otherwise it is queued
+// like any other task.
+func (p *WorkerPool) SubmitMetadata(ctx context.Context, execute func() interface{}) chan interface{} {
+	return p.submit(ctx, true, execute)
+}
+
+func (p *WorkerPool) submit(ctx context.Context, metadata bool, execute func() interface{}) chan interface{} {
 	// Hold closeMu.RLock for the entire check+send sequence so that
 	// Stop cannot close taskQueue between our running check and the send.
 	p.closeMu.RLock()
@@ -134,19 +221,28 @@ func (p *WorkerPool) Submit(execute func() interface{}) chan interface{} {
 		Execute:    execute,
 		ResultChan: resultChan,
 		startTime:  time.Now(),
+		ctx:        ctx,
+	}
+
+	queue := p.taskQueue
+	if metadata && p.metadataQueue != nil {
+		queue = p.metadataQueue
 	}
 
 	// Try to submit the task to the queue with timeout
 	timer := time.NewTimer(50 * time.Millisecond)
 	defer timer.Stop()
 	select {
-	case p.taskQueue <- task:
+	case queue <- task:
 		// Task submitted successfully
 		return resultChan
 	case <-timer.C:
 		// Task queue is full, close the result channel
 		close(resultChan)
 		return nil
+	case <-ctx.Done():
+		close(resultChan)
+		return nil
 	}
 }
 
@@ -183,6 +279,9 @@ func (p *WorkerPool) Stop() {
 		}()
 		close(p.taskQueue)
 	}()
+	if p.metadataQueue != nil {
+		close(p.metadataQueue)
+	}
 	p.closeMu.Unlock()
 
 	// Wait for all workers to finish
@@ -191,38 +290,223 @@ func (p *WorkerPool) Stop() {
 	p.logger.logger.Printf("Worker pool stopped")
 }
 
-// Stats returns statistics about the worker pool
+// Stats returns statistics about the worker pool; maxWorkers is the number
+// of workers it has now
 func (p *WorkerPool) Stats() (maxWorkers int, activeWorkers int, queuedTasks int) {
 	p.resizeMu.Lock()
 	maxWorkers = p.maxWorkers
 	p.resizeMu.Unlock()
 	activeWorkers = int(atomic.LoadInt32(&p.activeWorkers))
-	queuedTasks = len(p.taskQueue)
+	queuedTasks = len(p.taskQueue) + len(p.metadataQueue)
 	return
 }
 
+// Saturation returns the fraction of the pool's workers and queue slots
+// taken by running and queued tasks. At 1 the queues are full and new
+// tasks are rejected.
+func (p *WorkerPool) Saturation() float64 {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	capacity := p.maxWorkers + cap(p.taskQueue) + cap(p.metadataQueue)
+	used := int(atomic.LoadInt32(&p.activeWorkers)) + len(p.taskQueue) + len(p.metadataQueue)
+	return float64(used) / float64(capacity)
+}
+
+// Ceiling returns the most workers the pool may have: the maximum given to
+// SetAutoScale, or the pool's size when it is fixed
+func (p *WorkerPool) Ceiling() int {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	if p.ceiling > 0 {
+		return p.ceiling
+	}
+	return p.maxWorkers
+}
+
+// SetAutoScale lets the pool size itself between minWorkers and
+// maxWorkers, capped at maxAutoScaleWorkers. It restarts the pool at
+// minWorkers (at least one more than the metadata reserve), with a task
+// queue sized for maxWorkers, and doubles it while more tasks stay queued
+// than it has workers. Once nothing has been queued
+// and at most half the workers have been busy for autoScaleIdle, it halves
+// the pool back toward minWorkers. A maxWorkers of 0 switches auto-scaling
+// off and keeps the pool at its current size.
+func (p *WorkerPool) SetAutoScale(minWorkers, maxWorkers int) {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+
+	if p.stopScaler != nil {
+		p.stopScaler()
+		p.stopScaler = nil
+	}
+	if maxWorkers <= 0 {
+		p.floor, p.ceiling = 0, 0
+		return
+	}
+	maxWorkers = min(maxWorkers, maxAutoScaleWorkers)
+	minWorkers = min(max(minWorkers, p.reserve+1), maxWorkers)
+	p.floor, p.ceiling = minWorkers, maxWorkers
+	p.busyChecks, p.idleSince = 0, time.Time{}
+	if !p.resize(minWorkers, p.reserve) && atomic.LoadInt32(&p.running) == 1 {
+		p.startScaler()
+	}
+}
+
+// startScaler runs autoScale until the pool stops or SetAutoScale is
+// called again. Callers hold resizeMu, or own the pool before it starts.
+func (p *WorkerPool) startScaler() {
+	ctx, cancel := context.WithCancel(p.ctx)
+	p.stopScaler = cancel
+	go p.autoScale(ctx)
+}
+
+// autoScale checks the queues every autoScaleInterval until ctx is done. A
+// check is skipped while a resize holds resizeMu.
+func (p *WorkerPool) autoScale(ctx context.Context) {
+	ticker := time.NewTicker(autoScaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if p.resizeMu.TryLock() {
+				p.scale(now)
+				p.resizeMu.Unlock()
+			}
+		}
+	}
+}
+
+// scale grows or shrinks an auto-scaling pool by what its queues hold at
+// now. Callers hold resizeMu.
+func (p *WorkerPool) scale(now time.Time) {
+	// The queue is sized for the ceiling, so measure it against the
+	// workers the pool has now
+	queued := len(p.taskQueue) + len(p.metadataQueue)
+	watermark := p.maxWorkers + p.reserve
+	if queued > watermark {
+		p.idleSince = time.Time{}
+		p.busyChecks++
+		if p.busyChecks >= autoScaleGrowChecks && p.maxWorkers < p.ceiling {
+			p.busyChecks = 0
+			p.grow(min(p.maxWorkers, p.ceiling-p.maxWorkers), queued)
+		}
+		return
+	}
+	p.busyChecks = 0
+
+	if queued > 0 || int(atomic.LoadInt32(&p.activeWorkers)) > p.maxWorkers/2 {
+		p.idleSince = time.Time{}
+		return
+	}
+	if p.idleSince.IsZero() {
+		p.idleSince = now
+		return
+	}
+	// Reserved workers never retire, so keep one more than them
+	target := max(p.maxWorkers/2, p.floor, p.reserve+1)
+	if now.Sub(p.idleSince) >= autoScaleIdle && p.maxWorkers > target {
+		p.idleSince = now
+		p.shrink(p.maxWorkers - target)
+	}
+}
+
+// grow starts n more unreserved workers. Callers hold resizeMu.
+func (p *WorkerPool) grow(n, queued int) {
+	// Under closeMu, Stop cannot be waiting on wg while workers are added
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if atomic.LoadInt32(&p.running) == 0 {
+		return
+	}
+
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.worker(p.maxWorkers+i, false)
+	}
+	p.maxWorkers += n
+	p.logScaling("worker pool grown", queued)
+}
+
+// shrink retires n unreserved workers, each as soon as it is idle. Callers
+// hold resizeMu.
+func (p *WorkerPool) shrink(n int) {
+	for i := 0; i < n; i++ {
+		p.retire <- struct{}{}
+	}
+	p.maxWorkers -= n
+	p.logScaling("worker pool shrunk", 0)
+}
+
+// logScaling logs a scaling decision at debug level
+func (p *WorkerPool) logScaling(msg string, queued int) {
+	if logger := p.logger.getStructuredLogger(); logger != nil {
+		logger.Debug(msg,
+			LogField{Key: "workers", Value: p.maxWorkers},
+			LogField{Key: "max_workers", Value: p.ceiling},
+			LogField{Key: "queued", Value: queued})
+	}
+}
+
+// MetadataReserve returns the number of workers reserved for metadata tasks
+func (p *WorkerPool) MetadataReserve() int {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	return p.reserve
+}
+
+// SetMetadataReserve reserves n workers for tasks submitted with
+// SubmitMetadata. At least one worker is always left for other tasks, so n
+// is capped at the pool size minus one. Like Resize, this restarts the pool.
+func (p *WorkerPool) SetMetadataReserve(n int) {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	p.resize(p.maxWorkers, n)
+}
+
 // Resize changes the number of workers in the pool
 // This operation requires stopping and restarting the worker pool
 func (p *WorkerPool) Resize(maxWorkers int) {
 	// R29: Serialize Resize calls to prevent concurrent access
 	p.resizeMu.Lock()
 	defer p.resizeMu.Unlock()
+	p.resize(maxWorkers, p.reserve)
+}
 
+// queueSize returns the task queue capacity for a pool of maxWorkers: two
+// slots per worker, counting every worker an auto-scaling pool may grow to,
+// so a burst the full pool could serve is queued rather than rejected
+// while the pool is still small. Callers hold resizeMu.
+func (p *WorkerPool) queueSize(maxWorkers int) int {
+	return max(maxWorkers, p.ceiling) * 2
+}
+
+// resize restarts the pool with the given worker count and metadata
+// reserve, and reports whether it did. Callers hold resizeMu.
+func (p *WorkerPool) resize(maxWorkers, reserve int) bool {
 	// Ensure valid worker count
 	if maxWorkers <= 0 {
 		maxWorkers = 1
 	}
+	if reserve > maxWorkers-1 {
+		reserve = maxWorkers - 1
+	}
+	if reserve < 0 {
+		reserve = 0
+	}
 
-	// Only resize if the worker count changes
-	if p.maxWorkers == maxWorkers {
-		return
+	// Only resize if the worker count, reserve or queue size changes
+	if p.maxWorkers == maxWorkers && p.reserve == reserve && cap(p.taskQueue) == p.queueSize(maxWorkers) {
+		return false
 	}
 
 	// Check if the pool is running
 	wasRunning := atomic.LoadInt32(&p.running) == 1
 
-	// Save reference to old queue before stopping
+	// Save reference to old queues before stopping
 	oldQueue := p.taskQueue
+	oldMetadataQueue := p.metadataQueue
 
 	// Stop the pool if it's running
 	// This will close the old queue and wait for all workers to finish
@@ -245,11 +529,31 @@ func (p *WorkerPool) Resize(maxWorkers int) {
 			pendingTasks = append(pendingTasks, task)
 		}
 	}
+	var pendingMetadata []Task
+	if oldMetadataQueue != nil {
+		if !wasRunning {
+			func() {
+				defer func() { recover() }()
+				close(oldMetadataQueue)
+			}()
+		}
+		for task := range oldMetadataQueue {
+			pendingMetadata = append(pendingMetadata, task)
+		}
+	}
 
 	// Update the max workers
 	p.maxWorkers = maxWorkers
+	p.reserve = reserve
 	// Create a new task queue with appropriate size
-	p.taskQueue = make(chan Task, maxWorkers*2)
+	p.taskQueue = make(chan Task, p.queueSize(maxWorkers))
+	p.metadataQueue = nil
+	if reserve > 0 {
+		p.metadataQueue = make(chan Task, reserve*2)
+	}
+	// Every worker restarts, so no retire token is still owed
+	p.retire = make(chan struct{}, maxAutoScaleWorkers)
+	p.busyChecks, p.idleSince = 0, time.Time{}
 	// Create a new context
 	p.ctx, p.cancel = context.WithCancel(context.Background())
 	// Reset active workers count
@@ -259,23 +563,32 @@ func (p *WorkerPool) Resize(maxWorkers int) {
 	if wasRunning {
 		p.Start()
 
-		// Re-enqueue pending tasks from the old queue
-		for _, task := range pendingTasks {
-			select {
-			case p.taskQueue <- task:
-			default:
-				// Queue full, notify caller of failure
-				if task.ResultChan != nil {
-					task.ResultChan <- nil
+		// Re-enqueue pending tasks from the old queues
+		metadataQueue := p.metadataQueue
+		if metadataQueue == nil {
+			metadataQueue = p.taskQueue
+		}
+		requeue := func(queue chan Task, tasks []Task) {
+			for _, task := range tasks {
+				select {
+				case queue <- task:
+				default:
+					// Queue full, notify caller of failure
+					if task.ResultChan != nil {
+						task.ResultChan <- nil
+					}
 				}
 			}
 		}
+		requeue(p.taskQueue, pendingTasks)
+		requeue(metadataQueue, pendingMetadata)
 	} else {
 		// Pool wasn't running, notify callers of dropped tasks
-		for _, task := range pendingTasks {
+		for _, task := range append(pendingTasks, pendingMetadata...) {
 			if task.ResultChan != nil {
 				task.ResultChan <- nil
 			}
 		}
 	}
+	return true
 }
@@ -1,6 +1,8 @@
 package absnfs
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"log"
 	"os"
@@ -787,3 +789,333 @@ func TestWorkerPoolResizeDuringSubmitNoPanic(t *testing.T) {
 		pool.Stop()
 	}
 }
+
+func TestWorkerPoolSubmitWithContextExpired(t *testing.T) {
+	mockServer := &AbsfsNFS{logger: log.New(os.Stderr, "[test] ", log.LstdFlags)}
+	pool := NewWorkerPool(2, mockServer)
+	pool.Start()
+	defer pool.Stop()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel2 := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel2()
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"cancelled", cancelled, context.Canceled},
+		{"expired", expired, context.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ran atomic.Bool
+			task := func() interface{} {
+				ran.Store(true)
+				return "done"
+			}
+
+			resultChan := pool.SubmitWithContext(tc.ctx, task)
+			if resultChan != nil {
+				select {
+				case result := <-resultChan:
+					if result != tc.want {
+						t.Errorf("result = %v, want %v", result, tc.want)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("no result for cancelled task")
+				}
+			}
+
+			result, err := mockServer.ExecuteWithWorkerContext(tc.ctx, task)
+			if err != tc.want {
+				t.Errorf("ExecuteWithWorkerContext err = %v, want %v", err, tc.want)
+			}
+			if result != nil {
+				t.Errorf("ExecuteWithWorkerContext result = %v, want nil", result)
+			}
+			if ran.Load() {
+				t.Error("task ran despite its context being done")
+			}
+		})
+	}
+
+	// A live context still runs the task
+	result := <-pool.SubmitWithContext(context.Background(), func() interface{} { return 42 })
+	if result != 42 {
+		t.Errorf("result = %v, want 42", result)
+	}
+}
+
+func TestMetadataWorkerReserve(t *testing.T) {
+	// lookupUnderLoad saturates the general workers with slow bulk tasks,
+	// then reports whether a LOOKUP completes within the bound
+	lookupUnderLoad := func(t *testing.T, reserve int) bool {
+		mfs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		f, _ := mfs.Create("/file")
+		f.Close()
+		nfs, err := New(mfs, ExportOptions{MaxWorkers: 4, MetadataWorkerReserve: reserve})
+		if err != nil {
+			t.Fatalf("Failed to create NFS: %v", err)
+		}
+		defer nfs.Close()
+		if got := nfs.workerPool.MetadataReserve(); got != reserve {
+			t.Fatalf("MetadataReserve() = %d, want %d", got, reserve)
+		}
+		server := &Server{handler: nfs, options: ServerOptions{}}
+		handler := &NFSProcedureHandler{server: server}
+		rootHandle := getRootHandle(server)
+
+		// Stand-ins for slow WRITEs: they hold their worker until released.
+		// Occupy every general worker and leave more waiting in the queue.
+		release := make(chan struct{})
+		defer close(release)
+		for i := 0; i < 4-reserve+4; i++ {
+			if nfs.workerPool.SubmitWithContext(context.Background(), func() interface{} {
+				<-release
+				return nil
+			}) == nil {
+				t.Fatalf("Bulk task %d rejected", i)
+			}
+		}
+
+		done := make(chan uint32, 1)
+		go func() {
+			result, err := nfs.executeWithWorker(context.Background(), true, func() interface{} {
+				reply, _ := handler.handleLookup(bytes.NewReader(buildLookupRequest(rootHandle, "file")), &RPCReply{}, testAuthContext())
+				return reply
+			})
+			if err != nil {
+				done <- 0xFFFFFFFF
+				return
+			}
+			done <- readStatusFromReply(result.(*RPCReply))
+		}()
+
+		select {
+		case status := <-done:
+			if status != NFS_OK {
+				t.Errorf("LOOKUP: expected NFS_OK, got %d", status)
+			}
+			return true
+		case <-time.After(500 * time.Millisecond):
+			return false
+		}
+	}
+
+	if !lookupUnderLoad(t, 1) {
+		t.Error("LOOKUP did not complete under bulk load with a reserved worker")
+	}
+	if lookupUnderLoad(t, 0) {
+		t.Error("LOOKUP completed under bulk load without a reserve; the load did not saturate the pool")
+	}
+}
+
+func TestSetMetadataReserveCapped(t *testing.T) {
+	mockServer := &AbsfsNFS{logger: log.New(os.Stderr, "[test] ", log.LstdFlags)}
+	pool := NewWorkerPool(3, mockServer)
+	pool.Start()
+	defer pool.Stop()
+
+	pool.SetMetadataReserve(10)
+	if got := pool.MetadataReserve(); got != 2 {
+		t.Errorf("MetadataReserve() = %d, want 2 (one worker left for other tasks)", got)
+	}
+	if result := <-pool.Submit(func() interface{} { return "bulk" }); result != "bulk" {
+		t.Errorf("bulk result = %v", result)
+	}
+	if result := <-pool.SubmitMetadata(context.Background(), func() interface{} { return "meta" }); result != "meta" {
+		t.Errorf("metadata result = %v", result)
+	}
+}
+
+func TestWorkerPoolQueueMetrics(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{MaxWorkers: 1})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	pool := nfs.workerPool
+
+	// Occupy the only worker, then queue two tasks behind it
+	release := make(chan struct{})
+	first := pool.Submit(func() interface{} {
+		<-release
+		return nil
+	})
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, active, _ := pool.Stats(); active == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("worker never picked up the blocking task")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	queued := []chan interface{}{
+		pool.Submit(func() interface{} { return nil }),
+		pool.Submit(func() interface{} { return nil }),
+	}
+
+	if depth := nfs.GetMetrics().QueueDepth; depth != 2 {
+		t.Fatalf("QueueDepth = %d, want 2", depth)
+	}
+
+	const held = 20 * time.Millisecond
+	time.Sleep(held)
+	close(release)
+	<-first
+	for _, ch := range queued {
+		<-ch
+	}
+
+	m := nfs.GetMetrics()
+	if m.QueueDepth != 0 {
+		t.Errorf("QueueDepth after draining = %d, want 0", m.QueueDepth)
+	}
+	if m.QueueWaitCount < 3 {
+		t.Errorf("QueueWaitCount = %d, want at least 3", m.QueueWaitCount)
+	}
+	if m.MaxQueueWait < held {
+		t.Errorf("MaxQueueWait = %v, want at least %v", m.MaxQueueWait, held)
+	}
+	if m.P99QueueWait <= 0 || m.AvgQueueWait <= 0 {
+		t.Errorf("Expected positive queue wait, got avg %v p99 %v", m.AvgQueueWait, m.P99QueueWait)
+	}
+}
+
+func TestWorkerPoolAutoScale(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{MaxWorkers: 8, AutoScaleWorkers: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	pool := nfs.workerPool
+	if m := nfs.GetMetrics(); m.Workers != 2 || m.MaxWorkers != 8 {
+		t.Fatalf("Workers/MaxWorkers = %d/%d, want 2/8", m.Workers, m.MaxWorkers)
+	}
+
+	// Drive the scaling checks by hand rather than on the ticker
+	pool.resizeMu.Lock()
+	pool.stopScaler()
+	pool.resizeMu.Unlock()
+	check := func(now time.Time) int {
+		pool.resizeMu.Lock()
+		pool.scale(now)
+		pool.resizeMu.Unlock()
+		workers, _, _ := pool.Stats()
+		return workers
+	}
+	waitFor := func(what string, cond func(active, queued int) bool) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); ; {
+			if _, active, queued := pool.Stats(); cond(active, queued) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Occupy both workers, then queue three tasks: above half of the four
+	// queue slots
+	release := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		if pool.Submit(func() interface{} { <-release; return nil }) == nil {
+			t.Fatalf("task %d rejected", i)
+		}
+		if i == 1 {
+			waitFor("both workers to start", func(active, _ int) bool { return active == 2 })
+		}
+	}
+	now := time.Now()
+	for i := 1; i < autoScaleGrowChecks; i++ {
+		if workers := check(now); workers != 2 {
+			t.Fatalf("grew to %d workers after %d checks above the watermark", workers, i)
+		}
+	}
+	if workers := check(now); workers != 4 {
+		t.Fatalf("workers = %d after %d checks above the watermark, want 4", workers, autoScaleGrowChecks)
+	}
+	waitFor("the new workers to take queued tasks", func(active, _ int) bool { return active == 4 })
+
+	close(release)
+	waitFor("the pool to go idle", func(active, queued int) bool { return active == 0 && queued == 0 })
+	if workers := check(now); workers != 4 {
+		t.Fatalf("shrank to %d workers as soon as the pool went idle", workers)
+	}
+	if workers := check(now.Add(autoScaleIdle)); workers != 2 {
+		t.Fatalf("workers = %d after %v idle, want 2", workers, autoScaleIdle)
+	}
+	for deadline := time.Now().Add(time.Second); len(pool.retire) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("retired workers never exited")
+		}
+	}
+	if result, ok := pool.SubmitWait(func() interface{} { return 42 }); !ok || result != 42 {
+		t.Errorf("SubmitWait after shrinking = %v, %v", result, ok)
+	}
+
+	pool.SetAutoScale(1, 5000)
+	if got := pool.Ceiling(); got != maxAutoScaleWorkers {
+		t.Errorf("Ceiling() = %d, want the hard ceiling %d", got, maxAutoScaleWorkers)
+	}
+	pool.SetAutoScale(0, 0)
+	if got := pool.Ceiling(); got != 1 {
+		t.Errorf("Ceiling() with auto-scaling off = %d, want the pool size 1", got)
+	}
+}
+
+func TestWorkerPoolAutoScaleQueuesBurst(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	nfs, err := New(mfs, ExportOptions{MaxWorkers: 8, AutoScaleWorkers: true})
+	if err != nil {
+		t.Fatalf("Failed to create NFS: %v", err)
+	}
+	defer nfs.Close()
+	pool := nfs.workerPool
+	if workers, _, _ := pool.Stats(); workers != 2 {
+		t.Fatalf("workers = %d, want the floor 2", workers)
+	}
+
+	// A burst the full-size pool would queue is queued at the floor too:
+	// two tasks running plus two queue slots for each of the eight workers
+	release := make(chan struct{})
+	var results []chan interface{}
+	for i := 0; i < 2+2*8; i++ {
+		ch := pool.Submit(func() interface{} { <-release; return i })
+		if ch == nil {
+			t.Fatalf("task %d rejected; the queue holds %d", i, cap(pool.taskQueue))
+		}
+		results = append(results, ch)
+	}
+	close(release)
+	for i, ch := range results {
+		if got := <-ch; got != i {
+			t.Errorf("task %d result = %v", i, got)
+		}
+	}
+
+	pool.SetAutoScale(0, 0)
+	pool.Resize(4)
+	if got := cap(pool.taskQueue); got != 8 {
+		t.Errorf("queue capacity of a fixed pool of 4 = %d, want 8", got)
+	}
+}